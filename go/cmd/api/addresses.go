@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+var (
+	errInvalidAddress = errors.New("not a valid EVM, Solana or Tron address")
+	errBadChecksum    = errors.New("mixed-case address has an invalid EIP-55 checksum")
+)
+
+// isEVMAddress reports whether a is a 0x-prefixed 20-byte hex address.
+func isEVMAddress(a string) bool {
+	return len(a) == 42 && (strings.HasPrefix(a, "0x") || strings.HasPrefix(a, "0X")) && isHex(a[2:])
+}
+
+// isSolanaAddress reports whether a is a base58-encoded 32-byte public key.
+func isSolanaAddress(a string) bool {
+	if len(a) < 32 || len(a) > 44 {
+		return false
+	}
+	key, ok := decodeBase58(a)
+	return ok && len(key) == 32
+}
+
+// decodeBase58 decodes s with the Bitcoin alphabet Solana uses. Each
+// leading '1' stands for a leading zero byte.
+func decodeBase58(s string) ([]byte, bool) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range s {
+		i := strings.IndexRune(base58Alphabet, c)
+		if i < 0 {
+			return nil, false
+		}
+		n.Mul(n, radix).Add(n, big.NewInt(int64(i)))
+	}
+	zeros := len(s) - len(strings.TrimLeft(s, "1"))
+	return append(make([]byte, zeros), n.Bytes()...), true
+}
+
+// encodeBase58 encodes b with the same alphabet, the inverse of
+// decodeBase58.
+func encodeBase58(b []byte) string {
+	n := new(big.Int).SetBytes(b)
+	radix, mod := big.NewInt(58), new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append([]byte{base58Alphabet[mod.Int64()]}, out...)
+	}
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append([]byte{'1'}, out...)
+	}
+	return string(out)
+}
+
+// checksumAddress returns the EIP-55 form of an EVM address: hex letters are
+// upper-cased where the matching nibble of the Keccak-256 hash of the
+// lowercase address is 8 or more.
+func checksumAddress(a string) string {
+	hex := []byte(strings.ToLower(a[2:]))
+	h := sha3.NewLegacyKeccak256()
+	h.Write(hex)
+	sum := h.Sum(nil)
+	for i, c := range hex {
+		nibble := sum[i/2] >> 4
+		if i%2 == 1 {
+			nibble = sum[i/2] & 0x0f
+		}
+		if c >= 'a' && nibble >= 8 {
+			hex[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(hex)
+}
+
+// canonicalAddress validates address as an address on chain and returns its
+// canonical form, as the chain's adapter defines it: EIP-55 checksummed on
+// EVM chains, and the base58 key or base58check address unchanged on Solana
+// and Tron, whose addresses are case-sensitive. An empty chain accepts any
+// of these formats.
+func canonicalAddress(chain, address string) (string, error) {
+	address = strings.TrimSpace(address)
+	if chain == "" {
+		if chain = addressChain(address); chain == "" {
+			return "", errInvalidAddress
+		}
+	}
+	canonical, err := chainAdapter(chain).Validate(address)
+	if errors.Is(err, errNotChainAddress) {
+		return "", fmt.Errorf("not a valid %s address", chain)
+	}
+	return canonical, err
+}
+
+// addressChain returns the chain an address's format belongs to when no
+// chain is given: ethereum for EVM addresses, solana for base58 keys and
+// tron for base58check T-addresses, or "" for none of them.
+func addressChain(address string) string {
+	switch {
+	case isEVMAddress(address):
+		return "ethereum"
+	case isSolanaAddress(address):
+		return "solana"
+	case isTronAddress(address):
+		return "tron"
+	}
+	return ""
+}
+
+// isCaseSensitiveAddress reports whether address is in a base58 format,
+// Solana's or Tron's, whose case is part of the address.
+func isCaseSensitiveAddress(address string) bool {
+	return isSolanaAddress(address) || isTronAddress(address)
+}
+
+// addressKey returns the form addresses are compared and indexed by: a
+// Solana or Tron address itself, since base58 is case-sensitive, and
+// anything else lowercased, as EVM addresses only carry a checksum in their
+// case.
+func addressKey(address string) string {
+	if isCaseSensitiveAddress(address) {
+		return address
+	}
+	return strings.ToLower(address)
+}
+
+// addressColumn returns the SQL expression comparable to addressKey(address)
+// for an address column, so Solana and Tron addresses match exactly and
+// other lookups use the LOWER() indexes.
+func addressColumn(column, address string) string {
+	if isCaseSensitiveAddress(address) {
+		return column
+	}
+	return "LOWER(" + column + ")"
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const (
+	wrappedSOL = "So11111111111111111111111111111111111111112"
+	// tronUSDT is the USDT contract on Tron, 41a614f8...d13c in hex.
+	tronUSDT = "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"
+)
+
+func TestCanonicalAddress(t *testing.T) {
+	for _, tc := range []struct {
+		chain, address, want string
+	}{
+		// EIP-55 test vectors
+		{"ethereum", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"},
+		{"base", "0XFB6916095CA1DF60BB79CE92CE3EA74C37C5D359", "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359"},
+		{"", " 0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB ", "0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB"},
+		{"solana", wrappedSOL, wrappedSOL},
+		{"", wrappedSOL, wrappedSOL},
+		{"tron", tronUSDT, tronUSDT},
+		{"", tronUSDT, tronUSDT},
+		{"tron", "41a614f803b6fd780986a42c78ec9c7f77e6ded13c", tronUSDT},
+		{"tron", "0x41A614F803B6FD780986A42C78EC9C7F77E6DED13C", tronUSDT},
+		{"tron", "410000000000000000000000000000000000000000", "T9yD14Nj9j7xAB4dbGeiX9h8unkKHxuWwb"},
+	} {
+		got, err := canonicalAddress(tc.chain, tc.address)
+		if err != nil || got != tc.want {
+			t.Errorf("canonicalAddress(%q, %q) = %q, %v; want %q", tc.chain, tc.address, got, err, tc.want)
+		}
+	}
+
+	for _, tc := range []struct{ chain, address string }{
+		{"ethereum", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD"}, // bad checksum
+		{"ethereum", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1bea"},   // too short
+		{"ethereum", wrappedSOL},
+		{"solana", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"},
+		{"solana", "So1111111111111111111111111111111111111111O"}, // O is not base58
+		{"solana", "alice"},
+		{"solana", tronUSDT},
+		{"tron", "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6T"}, // bad checksum
+		{"tron", "0xa614f803b6fd780986a42c78ec9c7f77e6ded13c"},
+		{"tron", wrappedSOL},
+		{"ethereum", tronUSDT},
+		{"", "nobody"},
+		{"", ""},
+	} {
+		if got, err := canonicalAddress(tc.chain, tc.address); err == nil {
+			t.Errorf("canonicalAddress(%q, %q) = %q, want error", tc.chain, tc.address, got)
+		}
+	}
+}
+
+func TestAddressKeyKeepsSolanaCase(t *testing.T) {
+	if got := addressKey("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"); got != "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed" {
+		t.Fatalf("evm key = %q", got)
+	}
+	if got := addressKey(wrappedSOL); got != wrappedSOL {
+		t.Fatalf("solana key = %q", got)
+	}
+	if got := addressKey(tronUSDT); got != tronUSDT {
+		t.Fatalf("tron key = %q", got)
+	}
+
+	store := NewEventStore(10, 10)
+	store.Add(makeEvent("1", wrappedSOL, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "1", time.Now().UTC().Format(time.RFC3339), ""))
+	if got := store.GetRecent(EventFilter{}); len(got) != 1 || got[0].From != wrappedSOL || got[0].To != "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed" {
+		t.Fatalf("stored addresses were altered: %+v", got)
+	}
+	if got := store.GetByWallet("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", EventFilter{}); len(got) != 1 {
+		t.Fatalf("evm lookup ignoring case = %d events, want 1", len(got))
+	}
+}
+
+func TestCanonicalEventAddresses(t *testing.T) {
+	ev := &Event{Chain: "ethereum", From: "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"}
+	if err := chainAdapter(ev.Chain).Normalize(ev); err != nil || ev.From != "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed" || ev.To != "" {
+		t.Fatalf("canonical event = %+v, %v", ev, err)
+	}
+	if err := chainAdapter("solana").Normalize(&Event{Chain: "solana", From: wrappedSOL, To: "garbage"}); err == nil {
+		t.Fatal("expected invalid to address to be rejected")
+	}
+
+	store := NewEventStore(10, 10)
+	allowAll, _ := ParseNetworkAllowlist("")
+	handle := ingestEvents(store, NewHub(), allowAll, nil, nil, nil, nil, nil, nil, nil)
+	if err := handle(context.Background(), []byte(`{"event_id":"bad","chain":"solana","network":"devnet","from":"x","to":"y","value":"1"}`)); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if got := store.GetRecent(EventFilter{}); len(got) != 0 {
+		t.Fatalf("event with invalid addresses was stored: %+v", got)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxPurgeBatchSize caps the rows deleted per statement by a purge.
+	maxPurgeBatchSize = 100000
+	// maxCacheLimit caps the in-memory limits settable at runtime.
+	maxCacheLimit = 1000000
+	// maxSnapshotBytes caps the body of a snapshot restore.
+	maxSnapshotBytes = 64 << 20
+)
+
+// PurgeRequest is the body of POST /admin/purge.
+type PurgeRequest struct {
+	OlderThanDays int `json:"older_than_days"`
+	// BatchSize is the number of rows deleted per statement; 1000 when zero.
+	BatchSize int `json:"batch_size,omitempty"`
+}
+
+// PurgeResult reports what a purge deleted.
+type PurgeResult struct {
+	Cutoff string `json:"cutoff"`
+	// Deleted counts the events deleted from the repository, by the time
+	// they were received.
+	Deleted int `json:"deleted"`
+	// CacheDeleted counts the events dropped from the in-memory cache, by
+	// event timestamp.
+	CacheDeleted int `json:"cache_deleted"`
+}
+
+// CacheLimits is the body of PUT /admin/cache/limits.
+type CacheLimits struct {
+	MaxEvents          int `json:"max_events"`
+	MaxEventsPerWallet int `json:"max_events_per_wallet"`
+}
+
+// CacheResizeResult reports the cache after a resize.
+type CacheResizeResult struct {
+	Dropped int        `json:"dropped"`
+	Cache   CacheStats `json:"cache"`
+}
+
+// AdminStats describes the event store. StoredEvents and PersistQueue are
+// only set with a durable backend.
+type AdminStats struct {
+	Backend       string     `json:"backend"`
+	Cache         CacheStats `json:"cache"`
+	StoredEvents  *int       `json:"stored_events,omitempty"`
+	PersistQueue  *int       `json:"persist_queue,omitempty"`
+	PersistFailed *uint64    `json:"persist_failed,omitempty"`
+}
+
+// mountAdmin registers the /admin routes, which require the bearer token.
+func mountAdmin(r chi.Router, token string, store *EventStore, chains *ChainRegistry, sequences *SequenceTracker, retention *RetentionJob) {
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(requireAdminToken(token))
+		r.Get("/stats", func(w http.ResponseWriter, r *http.Request) {
+			getAdminStats(store, w, r)
+		})
+		r.Post("/purge", func(w http.ResponseWriter, r *http.Request) {
+			purgeEvents(store, w, r)
+		})
+		r.Post("/diff", func(w http.ResponseWriter, r *http.Request) {
+			diffResults(store, w, r)
+		})
+		r.Put("/cache/limits", func(w http.ResponseWriter, r *http.Request) {
+			resizeCache(store, w, r)
+		})
+		r.Get("/snapshot", func(w http.ResponseWriter, r *http.Request) {
+			getSnapshot(store, w, r)
+		})
+		r.Put("/snapshot", func(w http.ResponseWriter, r *http.Request) {
+			restoreSnapshot(store, w, r)
+		})
+		r.Get("/chains", func(w http.ResponseWriter, r *http.Request) {
+			listChainConfigs(chains, w, r)
+		})
+		r.Get("/sequences", func(w http.ResponseWriter, r *http.Request) {
+			getSequenceStatus(sequences, w, r)
+		})
+		r.Get("/explorers", func(w http.ResponseWriter, r *http.Request) {
+			getExplorerStatus(store.history, w, r)
+		})
+		r.Get("/retention", func(w http.ResponseWriter, r *http.Request) {
+			getRetentionStatus(retention, w, r)
+		})
+		r.Post("/retention/run", func(w http.ResponseWriter, r *http.Request) {
+			runRetention(retention, w, r)
+		})
+		r.Get("/correlation/state", func(w http.ResponseWriter, r *http.Request) {
+			getCorrelationState(store, w, r)
+		})
+		r.Get("/correlation/overrides", func(w http.ResponseWriter, r *http.Request) {
+			listCorrelationOverrides(store, w, r)
+		})
+		r.Put("/correlation/overrides/{event_id}/{leg_id}", func(w http.ResponseWriter, r *http.Request) {
+			putCorrelationOverride(store, w, r)
+		})
+		r.Delete("/correlation/overrides/{event_id}/{leg_id}", func(w http.ResponseWriter, r *http.Request) {
+			deleteCorrelationOverride(store, w, r)
+		})
+		r.Get("/bridges/routes", func(w http.ResponseWriter, r *http.Request) {
+			listBridgeRoutes(store, w, r)
+		})
+		r.Put("/bridges/routes/{bridge}/{source_chain}/{dest_chain}", func(w http.ResponseWriter, r *http.Request) {
+			putBridgeRoute(store, w, r)
+		})
+		r.Delete("/bridges/routes/{bridge}/{source_chain}/{dest_chain}", func(w http.ResponseWriter, r *http.Request) {
+			deleteBridgeRoute(store, w, r)
+		})
+		r.Put("/chains/{chain}/{network}", func(w http.ResponseWriter, r *http.Request) {
+			updateChain(chains, w, r)
+		})
+	})
+}
+
+// requireSharedWrite guards writes to data all tenants share, such as
+// labels, so one tenant cannot change what the others see. They need the
+// admin token when one is set, and are refused when tenants are configured
+// without one. An API without tenants and admin token stays open.
+func requireSharedWrite(token string, tenants *Tenants) func(http.Handler) http.Handler {
+	if token != "" {
+		return requireAdminToken(token)
+	}
+	return func(next http.Handler) http.Handler {
+		if !tenants.Enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			httpError(w, "shared data is read-only for tenants without ADMIN_TOKEN", http.StatusForbidden)
+		})
+	}
+}
+
+// requireAdminToken rejects requests without "Authorization: Bearer
+// <token>". The comparison is constant-time.
+func requireAdminToken(token string) func(http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				httpError(w, "missing or invalid admin token", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// backendName names the storage backend of repo.
+func backendName(repo EventRepository) string {
+	switch repo.(type) {
+	case nil, *MemoryRepository:
+		return BackendMemory
+	case *TimescaleRepository:
+		return BackendTimescale
+	case *PartitionedRepository:
+		return BackendPartitioned
+	case *PostgresRepository:
+		return BackendPostgres
+	case *SQLiteRepository:
+		return BackendSQLite
+	}
+	return fmt.Sprintf("%T", repo)
+}
+
+// getAdminStats reports the cache contents and limits and, with a durable
+// backend, the stored event count and persistence queue.
+func getAdminStats(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	stats := AdminStats{Backend: backendName(store.repo), Cache: store.cache.Stats()}
+	if store.repo != nil {
+		n, err := store.repo.Count(r.Context(), nil, EventFilter{})
+		if err != nil {
+			log.WithError(err).Error("failed to count stored events")
+			httpError(w, "could not count stored events", http.StatusInternalServerError)
+			return
+		}
+		stats.StoredEvents = &n
+	}
+	if store.batch != nil {
+		depth, failed := store.batch.Depth(), store.batch.Failed()
+		stats.PersistQueue, stats.PersistFailed = &depth, &failed
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(stats)
+}
+
+// purgeEvents deletes events older than the requested number of days from
+// the repository and the cache. Repository deletes are batched and commit
+// as they go, so a purge that fails part way can simply be repeated.
+func purgeEvents(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	var req PurgeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.OlderThanDays < 1 {
+		badRequest(w, invalidParam("older_than_days", "older_than_days must be at least 1"))
+		return
+	}
+	if req.BatchSize < 0 || req.BatchSize > maxPurgeBatchSize {
+		badRequest(w, invalidParam("batch_size", "batch_size must be between 1 and %d", maxPurgeBatchSize))
+		return
+	}
+	if req.BatchSize == 0 {
+		req.BatchSize = defaultPurgeBatchSize
+	}
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -req.OlderThanDays)
+	res := PurgeResult{Cutoff: cutoff.Format(time.RFC3339)}
+	res.CacheDeleted, _ = store.cache.PurgeBefore(r.Context(), cutoff, req.BatchSize)
+	// Evicted even when the purge fails part way, since its batches commit
+	defer store.responses.InvalidateAll(r.Context())
+	if store.repo != nil {
+		n, err := store.repo.PurgeBefore(r.Context(), cutoff, req.BatchSize)
+		res.Deleted = n
+		if err != nil {
+			log.WithError(err).WithField("deleted", n).Error("event purge failed")
+			httpError(w, fmt.Sprintf("purge failed after deleting %d events", n), http.StatusInternalServerError)
+			return
+		}
+	}
+	log.WithFields(log.Fields{"cutoff": res.Cutoff, "deleted": res.Deleted, "cache_deleted": res.CacheDeleted}).Info("admin: events purged")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// resizeCache changes the in-memory limits. Shrinking drops the oldest
+// events right away; growing takes effect as new events arrive.
+func resizeCache(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	var limits CacheLimits
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&limits); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if limits.MaxEvents < 1 || limits.MaxEvents > maxCacheLimit {
+		badRequest(w, invalidParam("max_events", "max_events must be between 1 and %d", maxCacheLimit))
+		return
+	}
+	if limits.MaxEventsPerWallet < 1 || limits.MaxEventsPerWallet > maxCacheLimit {
+		badRequest(w, invalidParam("max_events_per_wallet", "max_events_per_wallet must be between 1 and %d", maxCacheLimit))
+		return
+	}
+	res := CacheResizeResult{Dropped: store.cache.Resize(limits.MaxEvents, limits.MaxEventsPerWallet)}
+	res.Cache = store.cache.Stats()
+	log.WithFields(log.Fields{"max_events": limits.MaxEvents, "max_events_per_wallet": limits.MaxEventsPerWallet, "dropped": res.Dropped}).Info("admin: cache resized")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// getSnapshot returns every cached event, newest first, in the format
+// restoreSnapshot accepts.
+func getSnapshot(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(store.cache.Dump())
+}
+
+// restoreSnapshot replaces the cache contents with a snapshot, e.g. to warm
+// a fresh instance. The repository is not touched.
+func restoreSnapshot(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	var events []*Event
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSnapshotBytes)).Decode(&events); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	for i, ev := range events {
+		if ev == nil || ev.EventID == "" {
+			badRequest(w, invalidParam(fmt.Sprintf("[%d].event_id", i), "event %d has no event_id", i))
+			return
+		}
+	}
+	store.cache.Restore(events)
+	store.responses.InvalidateAll(r.Context())
+	stats := store.cache.Stats()
+	log.WithField("events", stats.Events).Info("admin: cache restored from snapshot")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(stats)
+}
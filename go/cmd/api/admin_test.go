@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAdminRequiresToken(t *testing.T) {
+	router := chi.NewRouter()
+	mountAdmin(router, "s3cret", NewEventStore(100, 50), NewChainRegistry(nil, nil), nil, nil)
+
+	for _, auth := range []string{"", "Bearer wrong", "s3cret", "Bearer s3cret2"} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var body ErrorResponse
+		_ = json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code != http.StatusUnauthorized || body.Code != ErrCodeUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("authorization %q: %d %+v", auth, rec.Code, body)
+		}
+	}
+}
+
+func TestRequireSharedWrite(t *testing.T) {
+	tenants, err := ParseTenants("treasury=k1", "")
+	if err != nil {
+		t.Fatalf("tenants: %v", err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	for _, tc := range []struct {
+		token   string
+		tenants *Tenants
+		auth    string
+		want    int
+	}{
+		{"", nil, "", http.StatusNoContent},
+		{"", tenants, "", http.StatusForbidden},
+		{"s3cret", tenants, "", http.StatusUnauthorized},
+		{"s3cret", nil, "Bearer s3cret", http.StatusNoContent},
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/labels/0xabc", nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rec := httptest.NewRecorder()
+		requireSharedWrite(tc.token, tc.tenants)(ok).ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("token %q, tenants %v, auth %q: got %d, want %d", tc.token, tc.tenants != nil, tc.auth, rec.Code, tc.want)
+		}
+	}
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	store := NewEventStore(100, 50)
+	old := time.Now().UTC().AddDate(0, 0, -40).Format(time.RFC3339)
+	recent := time.Now().UTC().Format(time.RFC3339)
+	store.Add(makeEvent("old", "alice", "bob", "1", old, ""))
+	store.Add(makeEvent("new-1", "alice", "bob", "1", recent, ""))
+	store.Add(makeEvent("new-2", "alice", "carol", "1", recent, ""))
+
+	router := chi.NewRouter()
+	mountAdmin(router, "s3cret", store, NewChainRegistry(nil, nil), nil, nil)
+	do := func(method, path, body string, out interface{}) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if out != nil && rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+				t.Fatalf("%s %s: decode: %v", method, path, err)
+			}
+		}
+		return rec.Code
+	}
+
+	var stats AdminStats
+	if code := do(http.MethodGet, "/admin/stats", "", &stats); code != http.StatusOK || stats.Backend != BackendMemory ||
+		stats.Cache.Events != 3 || stats.Cache.MaxEvents != 100 || stats.StoredEvents != nil {
+		t.Fatalf("stats = %d %+v", code, stats)
+	}
+
+	var snapshot []*Event
+	if code := do(http.MethodGet, "/admin/snapshot", "", &snapshot); code != http.StatusOK || len(snapshot) != 3 || snapshot[2].EventID != "old" {
+		t.Fatalf("snapshot = %d %+v", code, snapshot)
+	}
+
+	var purged PurgeResult
+	if code := do(http.MethodPost, "/admin/purge", `{"older_than_days": 30}`, &purged); code != http.StatusOK || purged.CacheDeleted != 1 || purged.Deleted != 0 {
+		t.Fatalf("purge = %d %+v", code, purged)
+	}
+	for _, body := range []string{`{}`, `{"older_than_days": 1, "batch_size": -1}`, `not json`} {
+		if code := do(http.MethodPost, "/admin/purge", body, nil); code != http.StatusBadRequest {
+			t.Fatalf("purge %s = %d, want 400", body, code)
+		}
+	}
+
+	// new-1 is trimmed from the global list but still in bob's history
+	var resized CacheResizeResult
+	if code := do(http.MethodPut, "/admin/cache/limits", `{"max_events": 1, "max_events_per_wallet": 1}`, &resized); code != http.StatusOK ||
+		resized.Dropped != 0 || resized.Cache.Events != 2 || resized.Cache.MaxEventsPerWallet != 1 {
+		t.Fatalf("resize = %d %+v", code, resized)
+	}
+	if code := do(http.MethodPut, "/admin/cache/limits", `{"max_events": 0, "max_events_per_wallet": 1}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("zero limit = %d, want 400", code)
+	}
+
+	// Restoring the earlier snapshot under the new limits keeps the newest
+	raw, _ := json.Marshal(snapshot)
+	var restored CacheStats
+	if code := do(http.MethodPut, "/admin/snapshot", string(raw), &restored); code != http.StatusOK || restored.Events != 2 {
+		t.Fatalf("restore = %d %+v", code, restored)
+	}
+	if _, ok := store.GetByID("old"); ok {
+		t.Fatal("oldest event restored over the limits")
+	}
+	if code := do(http.MethodPut, "/admin/snapshot", `[{"from": "a"}]`, nil); code != http.StatusBadRequest {
+		t.Fatalf("restore without event_id = %d, want 400", code)
+	}
+}
+
+func TestAdminStatsWithRepository(t *testing.T) {
+	store := NewEventStore(100, 50)
+	repo := NewMemoryRepository(100, 50)
+	_ = repo.Insert(context.Background(), makeEvent("1", "a", "b", "1", time.Now().UTC().Format(time.RFC3339), ""))
+	store.AttachRepository(repo)
+
+	rec := httptest.NewRecorder()
+	getAdminStats(store, rec, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	var stats AdminStats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil || stats.StoredEvents == nil || *stats.StoredEvents != 1 {
+		t.Fatalf("stats = %+v, %v", stats, err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// maxEventVersions bounds how far the versions of an amended event are
+// followed, so a corrupt supersedes cycle cannot loop forever.
+const maxEventVersions = 64
+
+var errSelfAmendment = errors.New("an event cannot supersede itself")
+
+// lookup returns the event with eventID from the cache, which also holds
+// events still queued for a batched write, or else the repository.
+func (s *EventStore) lookup(ctx context.Context, eventID string) (*Event, bool) {
+	if ev, ok, _ := s.cache.ByID(ctx, eventID); ok {
+		return ev, true
+	}
+	return s.GetByID(eventID)
+}
+
+// prepareAmendment readies an ingested event for storage. superseded_by is
+// only ever set by the API, so a producer's value is dropped. An amendment
+// of a version that was itself amended supersedes the latest version
+// instead, so the versions of an event stay a single line.
+func (s *EventStore) prepareAmendment(ctx context.Context, ev *Event) error {
+	ev.SupersededBy = ""
+	if ev.Supersedes == "" {
+		return nil
+	}
+	if ev.Supersedes == ev.EventID {
+		return errSelfAmendment
+	}
+	latest, ok := s.lookup(ctx, ev.Supersedes)
+	if !ok {
+		return nil
+	}
+	// A redelivered amendment stops at the version it superseded before
+	for i := 0; i < maxEventVersions && latest.SupersededBy != "" && latest.SupersededBy != ev.EventID; i++ {
+		next, ok := s.lookup(ctx, latest.SupersededBy)
+		if !ok {
+			break
+		}
+		latest = next
+	}
+	ev.Supersedes = latest.EventID
+	return nil
+}
+
+// markSuperseded records on the version a stored amendment supersedes
+// that it was superseded. An amendment of an unknown event is kept as it
+// is, with its supersedes link.
+func (s *EventStore) markSuperseded(ctx context.Context, ev *Event) error {
+	if ev.Supersedes == "" {
+		return nil
+	}
+	target, ok := s.lookup(ctx, ev.Supersedes)
+	if !ok || target.SupersededBy != "" {
+		return nil
+	}
+	marked := *target
+	marked.SupersededBy = ev.EventID
+	return s.UpdateMetadata(ctx, &marked)
+}
+
+// Lineage returns every version of ev's event, oldest first, ending with
+// the latest.
+func (s *EventStore) Lineage(ctx context.Context, ev *Event) []*Event {
+	latest := s.LatestVersion(ctx, ev)
+	versions := []*Event{latest}
+	for i := 0; i < maxEventVersions && versions[0].Supersedes != ""; i++ {
+		prev, ok := s.lookup(ctx, versions[0].Supersedes)
+		if !ok {
+			break
+		}
+		versions = append([]*Event{prev}, versions...)
+	}
+	return versions
+}
+
+// LatestVersion returns the amendment that last superseded ev, or ev when
+// it was never amended.
+func (s *EventStore) LatestVersion(ctx context.Context, ev *Event) *Event {
+	for i := 0; i < maxEventVersions && ev.SupersededBy != ""; i++ {
+		next, ok := s.lookup(ctx, ev.SupersededBy)
+		if !ok {
+			break
+		}
+		ev = next
+	}
+	return ev
+}
+
+// currentVersions drops the superseded versions from events unless the
+// request asks for ?include_history=true.
+func currentVersions(r *http.Request, events []*Event) ([]*Event, error) {
+	p := newQueryParams(r)
+	if p.Bool("include_history", false) {
+		return events, p.Err()
+	}
+	current := make([]*Event, 0, len(events))
+	for _, ev := range events {
+		if ev.SupersededBy == "" {
+			current = append(current, ev)
+		}
+	}
+	return current, p.Err()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAmendmentsSupersedeEarlierVersions(t *testing.T) {
+	store := NewEventStore(100, 50)
+	allowAll, _ := ParseNetworkAllowlist("")
+	hub := NewHub()
+	go hub.Run()
+	handle := ingestEvents(store, hub, allowAll, NewDeduplicator(store), nil, nil, nil, nil, nil, nil)
+	ctx := context.Background()
+	sig := strings.Repeat("5", 88)
+	ingest := func(id, value, supersedes string) {
+		t.Helper()
+		payload := `{"event_id":"` + id + `","chain":"solana","network":"devnet","tx_hash":"` + sig + `","from":"` + wrappedSOL +
+			`","to":"` + wrappedSOL + `","value":"` + value + `","log_index":0,"supersedes":"` + supersedes + `","superseded_by":"forged"}`
+		if err := handle(ctx, []byte(payload)); err != nil {
+			t.Fatalf("ingest %s: %v", id, err)
+		}
+	}
+	ids := func(events []*Event) string {
+		out := make([]string, len(events))
+		for i, ev := range events {
+			out[i] = ev.EventID
+		}
+		return strings.Join(out, ",")
+	}
+
+	ingest("v1", "100", "")
+	// The correction is the same transfer, but is not merged away as a copy
+	ingest("v2", "1000", "v1")
+	// Amending a superseded version amends the latest one
+	ingest("v3", "1001", "v1")
+	ingest("v3", "1001", "v1")
+	ingest("self", "1", "self")
+
+	if got := store.GetRecent(EventFilter{}); ids(got) != "v3" {
+		t.Fatalf("recent = %s, want the latest version only", ids(got))
+	}
+	if got := store.GetRecent(EventFilter{IncludeHistory: true}); ids(got) != "v3,v2,v1" {
+		t.Fatalf("recent with history = %s, want v3,v2,v1", ids(got))
+	}
+	v1, _ := store.GetByID("v1")
+	v3, _ := store.GetByID("v3")
+	if v1.SupersededBy != "v2" || v3.Supersedes != "v2" || v3.SupersededBy != "" {
+		t.Fatalf("v1 = %+v, v3 = %+v", v1, v3)
+	}
+	if lineage := store.Lineage(ctx, v1); ids(lineage) != "v1,v2,v3" {
+		t.Fatalf("lineage = %s, want v1,v2,v3", ids(lineage))
+	}
+
+	router := chi.NewRouter()
+	router.Get("/transactions/{event_id}", func(w http.ResponseWriter, r *http.Request) {
+		getEventDetail(store, nil, w, r)
+	})
+	router.Get("/tx/{hash}", func(w http.ResponseWriter, r *http.Request) {
+		getTransactionByHash(store, w, r)
+	})
+	get := func(path string, out interface{}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if err := json.NewDecoder(rec.Body).Decode(out); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, %v", path, rec.Code, err)
+		}
+	}
+	var detail EventDetail
+	get("/transactions/v1", &detail)
+	if detail.Event.EventID != "v3" || detail.History != nil {
+		t.Fatalf("detail = %+v, want v3 without history", detail)
+	}
+	get("/transactions/v1?include_history=true", &detail)
+	if ids(detail.History) != "v1,v2,v3" {
+		t.Fatalf("history = %s, want v1,v2,v3", ids(detail.History))
+	}
+	var events []*Event
+	get("/tx/"+sig, &events)
+	if ids(events) != "v3" {
+		t.Fatalf("transaction events = %s, want v3", ids(events))
+	}
+	get("/tx/"+sig+"?include_history=true", &events)
+	if len(events) != 3 {
+		t.Fatalf("transaction events with history = %s", ids(events))
+	}
+}
+
+func TestSQLiteRepositoryHidesSupersededEvents(t *testing.T) {
+	ctx := context.Background()
+	repo, err := OpenSQLiteRepository(ctx, filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer repo.Close()
+	ts := time.Now().UTC().Format(time.RFC3339)
+	original := makeEvent("v1", "alice", "bob", "5", ts, "USDC")
+	amendment := makeEvent("v2", "alice", "bob", "5000000", ts, "USDC")
+	amendment.Supersedes = "v1"
+	if err := repo.InsertBatch(ctx, []*Event{original, amendment}); err != nil {
+		t.Fatal(err)
+	}
+	marked := *original
+	marked.SupersededBy = "v2"
+	if err := repo.UpdateMetadata(ctx, &marked); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := repo.ByWallet(ctx, "alice", EventFilter{}); len(got) != 1 || got[0].EventID != "v2" || got[0].Supersedes != "v1" {
+		t.Fatalf("by wallet = %+v, want v2", got)
+	}
+	if n, _ := repo.Count(ctx, nil, EventFilter{IncludeHistory: true}); n != 2 {
+		t.Fatalf("count with history = %d, want 2", n)
+	}
+	if ev, ok, _ := repo.ByID(ctx, "v1"); !ok || ev.SupersededBy != "v2" {
+		t.Fatalf("v1 = %+v", ev)
+	}
+}
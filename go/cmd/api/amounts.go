@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// minValuePrecision is the number of fractional digits value bounds are
+// passed to SQL with, more than any asset's decimals.
+const minValuePrecision = 36
+
+// Amount is an event's value as an integer in the asset's smallest unit and
+// the asset's decimals, so values of assets with different decimals compare
+// correctly.
+type Amount struct {
+	Raw      *big.Int
+	Decimals int
+}
+
+// eventAmount returns ev's value as an Amount. The listener publishes
+// integers in the smallest unit; a value with a decimal point is taken to
+// be in whole units already, with digits beyond the asset's decimals
+// dropped. ok is false when ev has no numeric value or the decimals of its
+// asset are unknown.
+func eventAmount(ev *Event) (Amount, bool) {
+	decimals, ok := eventDecimals(ev)
+	if !ok || ev.Value == "" {
+		return Amount{}, false
+	}
+	var raw *big.Int
+	if strings.Contains(ev.Value, ".") {
+		whole, ok := new(big.Rat).SetString(ev.Value)
+		if !ok {
+			return Amount{}, false
+		}
+		scaled := whole.Mul(whole, new(big.Rat).SetInt(pow10(decimals)))
+		raw = new(big.Int).Quo(scaled.Num(), scaled.Denom())
+	} else if raw, ok = new(big.Int).SetString(ev.Value, 10); !ok {
+		return Amount{}, false
+	}
+	if raw.Sign() < 0 {
+		return Amount{}, false
+	}
+	return Amount{Raw: raw, Decimals: decimals}, true
+}
+
+// eventDecimals returns the decimals of the asset ev moves: the token's,
+// or the chain's native currency's.
+func eventDecimals(ev *Event) (int, bool) {
+	if ev.Token != nil {
+		return int(ev.Token.Decimals), true
+	}
+	return chainAdapter(ev.Chain).NativeDecimals()
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// Whole returns the amount in whole units.
+func (a Amount) Whole() *big.Rat {
+	return new(big.Rat).SetFrac(a.Raw, pow10(a.Decimals))
+}
+
+// String formats the amount in whole units without trailing zeros, e.g.
+// "1.5" for 1500000 with 6 decimals.
+func (a Amount) String() string {
+	s := a.Whole().FloatString(a.Decimals)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// AtLeast reports whether the amount is at least min whole units.
+func (a Amount) AtLeast(min *big.Rat) bool {
+	return a.Whole().Cmp(min) >= 0
+}
+
+// AtMost reports whether the amount is at most max whole units.
+func (a Amount) AtMost(max *big.Rat) bool {
+	return a.Whole().Cmp(max) <= 0
+}
+
+// Numeric is the amount in whole units as a NUMERIC value.
+func (a Amount) Numeric() pgtype.Numeric {
+	return pgtype.Numeric{Int: new(big.Int).Set(a.Raw), Exp: int32(-a.Decimals), Valid: true}
+}
+
+// decimalizeOne returns ev, or a copy of it with ValueDecimal set when its
+// amount is known.
+func decimalizeOne(ev *Event) *Event {
+	if ev == nil {
+		return ev
+	}
+	amount, ok := eventAmount(ev)
+	if !ok {
+		return ev
+	}
+	out := *ev
+	out.ValueDecimal = amount.String()
+	return &out
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEventAmount(t *testing.T) {
+	usdc := &Token{Address: "0xa0b8", Symbol: "USDC", Decimals: 6}
+	cases := []struct {
+		chain, value string
+		token        *Token
+		want         string
+		ok           bool
+	}{
+		{"ethereum", "1500000000000000000", nil, "1.5", true},
+		{"ethereum", "1000000", usdc, "1", true},
+		{"ethereum", "1", usdc, "0.000001", true},
+		{"solana", "2500000000", nil, "2.5", true},
+		{"solana", "1.25", nil, "1.25", true},
+		{"ethereum", "0.0000001", usdc, "0", true},
+		{"ethereum", "", nil, "", false},
+		{"ethereum", "lots", nil, "", false},
+		{"ethereum", "-5", nil, "", false},
+		{"dogechain", "100", nil, "", false},
+	}
+	for _, c := range cases {
+		ev := &Event{Chain: c.chain, Value: c.value, Token: c.token}
+		amount, ok := eventAmount(ev)
+		if ok != c.ok {
+			t.Fatalf("%s %q: ok = %v, want %v", c.chain, c.value, ok, c.ok)
+		}
+		if ok && amount.String() != c.want {
+			t.Fatalf("%s %q = %s, want %s", c.chain, c.value, amount.String(), c.want)
+		}
+	}
+}
+
+func TestMinValueComparesWholeUnits(t *testing.T) {
+	usdc := makeEvent("1", "alice", "bob", "1000000", "2024-01-01T00:00:00Z", "USDC")
+	usdc.Chain = "ethereum"
+	usdc.Token.Decimals = 6
+	eth := makeEvent("2", "alice", "bob", "1000000000000000000", "2024-01-01T00:00:01Z", "")
+	eth.Chain = "ethereum"
+
+	// 1 USDC and 1 ETH are both one whole unit, however many base units
+	f := EventFilter{MinValue: big.NewRat(1, 1)}
+	if !f.Matches(usdc) || !f.Matches(eth) {
+		t.Fatal("one whole unit should match min_value=1")
+	}
+	f.MinValue = big.NewRat(2, 1)
+	if f.Matches(usdc) || f.Matches(eth) {
+		t.Fatal("one whole unit should not match min_value=2")
+	}
+
+	store := NewEventStore(100, 100)
+	store.Add(usdc)
+	store.Add(eth)
+	req := httptest.NewRequest(http.MethodGet, "/transactions?min_value=0.5", nil)
+	w := httptest.NewRecorder()
+	getTransactions(store, w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	var got []*Event
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(got) != 2 || got[0].ValueDecimal != "1" || got[1].ValueDecimal != "1" {
+		t.Fatalf("events = %+v, want both with value_decimal 1", got)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxAnalyticsBuckets bounds the size of a time series so a wide range at a
+// fine interval cannot produce an unbounded response.
+const maxAnalyticsBuckets = 2000
+
+// nativeToken is reported as the token of native-currency transfers.
+const nativeToken = "native"
+
+// AnalyticsQuery selects the events and bucketing of a time series.
+type AnalyticsQuery struct {
+	Chain    string
+	Network  string
+	Token    string
+	Tenant   string
+	Interval string // "1h" or "1d"
+	Start    time.Time
+	End      time.Time
+	// IncludeScam keeps scam tokens in volume series.
+	IncludeScam bool
+}
+
+// VolumePoint is the transfer volume of one asset in one bucket. Volume is in
+// the token's smallest unit (wei, lamports, ...), as a decimal string.
+type VolumePoint struct {
+	Bucket       string `json:"bucket"`
+	Chain        string `json:"chain"`
+	Network      string `json:"network"`
+	Token        string `json:"token"`
+	TokenAddress string `json:"token_address,omitempty"`
+	// Verified is false for tokens missing from the token list, whose
+	// symbol may be spoofed.
+	Verified bool `json:"verified"`
+	// Scam points are only returned with include_scam=true.
+	Scam      bool   `json:"scam,omitempty"`
+	Volume    string `json:"volume"`
+	Transfers int64  `json:"transfers"`
+}
+
+// ActiveWalletsPoint is the number of distinct addresses that sent or
+// received a transfer in one bucket.
+type ActiveWalletsPoint struct {
+	Bucket  string `json:"bucket"`
+	Wallets int64  `json:"wallets"`
+}
+
+// AnalyticsSeries is the response envelope of the analytics endpoints.
+type AnalyticsSeries struct {
+	Interval string      `json:"interval"`
+	Start    string      `json:"start"`
+	End      string      `json:"end"`
+	Points   interface{} `json:"points"`
+}
+
+// truncUnit maps an interval to its date_trunc unit and duration.
+func truncUnit(interval string) (string, time.Duration, bool) {
+	switch interval {
+	case "1h":
+		return "hour", time.Hour, true
+	case "1d":
+		return "day", 24 * time.Hour, true
+	}
+	return "", 0, false
+}
+
+// bucketStart truncates t (in UTC) to the start of its bucket.
+func (q AnalyticsQuery) bucketStart(t time.Time) time.Time {
+	t = t.UTC()
+	if q.Interval == "1d" {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// parseAnalyticsQuery reads and validates the common analytics parameters,
+// scoped to the caller's tenant. The range defaults to the last 24 hours for hourly and the last 30 days for
+// daily buckets.
+func parseAnalyticsQuery(r *http.Request) (AnalyticsQuery, error) {
+	p := newQueryParams(r)
+	chain, network := p.Network()
+	q := AnalyticsQuery{
+		Chain:       chain,
+		Network:     network,
+		Token:       p.String("token"),
+		Tenant:      tenantFrom(r.Context()),
+		Interval:    p.Enum("interval", "1h", "1d"),
+		End:         time.Now().UTC(),
+		IncludeScam: p.Bool("include_scam", false),
+	}
+	if q.Interval == "" {
+		q.Interval = "1h"
+	}
+	_, step, _ := truncUnit(q.Interval)
+	if end := p.Time("end"); end != nil {
+		q.End = end.UTC()
+	}
+	q.Start = q.End.Add(-24 * time.Hour)
+	if q.Interval == "1d" {
+		q.Start = q.End.AddDate(0, 0, -30)
+	}
+	if start := p.Time("start"); start != nil {
+		q.Start = start.UTC()
+	}
+	if err := p.Err(); err != nil {
+		return q, err
+	}
+	if !q.Start.Before(q.End) {
+		return q, invalidParam("start", "start must be before end")
+	}
+	if q.End.Sub(q.Start)/step > maxAnalyticsBuckets {
+		return q, invalidParam("start", "range too large: at most %d buckets", maxAnalyticsBuckets)
+	}
+	return q, nil
+}
+
+// analyticsWhere builds the Postgres WHERE clause; args start at $1.
+func (q AnalyticsQuery) analyticsWhere() (string, []interface{}) {
+	where := fmt.Sprintf("timestamp::timestamptz >= $1 AND timestamp::timestamptz < $2 AND status <> '%s' AND superseded_by = ''", StatusOrphaned)
+	args := []interface{}{q.Start, q.End}
+	if q.Chain != "" {
+		args = append(args, q.Chain)
+		where += fmt.Sprintf(" AND chain = $%d", len(args))
+	}
+	if q.Network != "" {
+		args = append(args, q.Network)
+		where += fmt.Sprintf(" AND network = $%d", len(args))
+	}
+	if q.Token != "" {
+		args = append(args, q.Token)
+		where += fmt.Sprintf(" AND token_symbol = $%d", len(args))
+	}
+	if q.Tenant != "" {
+		args = append(args, q.Tenant)
+		where += fmt.Sprintf(" AND "+tenantSQL("$"), "$", len(args))
+	}
+	return where, args
+}
+
+// inRange reports whether the event matches the query's filters and range.
+func (q AnalyticsQuery) inRange(ev *Event) (time.Time, bool) {
+	filter := EventFilter{Chain: q.Chain, Network: q.Network, Token: q.Token, Tenant: q.Tenant}
+	if !filter.Matches(ev) {
+		return time.Time{}, false
+	}
+	ts, err := time.Parse(time.RFC3339, ev.Timestamp)
+	if err != nil || ts.Before(q.Start) || !ts.Before(q.End) {
+		return time.Time{}, false
+	}
+	return ts, true
+}
+
+// aggregateVolume sums transfer volume per bucket, chain and token over the
+// events matching q, for backends without server-side bucketing.
+func aggregateVolume(events []*Event, q AnalyticsQuery) []VolumePoint {
+	// Tokens are told apart by address so a spoofed symbol is not merged
+	// into the real token's volume
+	type key struct{ bucket, chain, network, token, address string }
+	sums := make(map[key]*big.Int)
+	counts := make(map[key]int64)
+	for _, ev := range events {
+		ts, ok := q.inRange(ev)
+		if !ok {
+			continue
+		}
+		val, ok := new(big.Int).SetString(ev.Value, 10)
+		if !ok {
+			continue
+		}
+		token, address := nativeToken, ""
+		if ev.Token != nil {
+			token, address = ev.Token.Symbol, ev.Token.Address
+		}
+		k := key{q.bucketStart(ts).Format(time.RFC3339), ev.Chain, ev.Network, token, address}
+		if sums[k] == nil {
+			sums[k] = new(big.Int)
+		}
+		sums[k].Add(sums[k], val)
+		counts[k]++
+	}
+
+	out := make([]VolumePoint, 0, len(sums))
+	for k, sum := range sums {
+		out = append(out, VolumePoint{Bucket: k.bucket, Chain: k.chain, Network: k.network, Token: k.token, TokenAddress: k.address,
+			Volume: sum.String(), Transfers: counts[k]})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Bucket != b.Bucket {
+			return a.Bucket < b.Bucket
+		}
+		if a.Chain != b.Chain {
+			return a.Chain < b.Chain
+		}
+		if a.Network != b.Network {
+			return a.Network < b.Network
+		}
+		if a.Token != b.Token {
+			return a.Token < b.Token
+		}
+		return a.TokenAddress < b.TokenAddress
+	})
+	return out
+}
+
+// aggregateActiveWallets counts distinct addresses per bucket over the events
+// matching q.
+func aggregateActiveWallets(events []*Event, q AnalyticsQuery) []ActiveWalletsPoint {
+	wallets := make(map[string]map[string]struct{})
+	for _, ev := range events {
+		ts, ok := q.inRange(ev)
+		if !ok {
+			continue
+		}
+		b := q.bucketStart(ts).Format(time.RFC3339)
+		if wallets[b] == nil {
+			wallets[b] = make(map[string]struct{})
+		}
+		wallets[b][addressKey(ev.From)] = struct{}{}
+		wallets[b][addressKey(ev.To)] = struct{}{}
+	}
+
+	out := make([]ActiveWalletsPoint, 0, len(wallets))
+	for b, set := range wallets {
+		out = append(out, ActiveWalletsPoint{Bucket: b, Wallets: int64(len(set))})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Bucket < out[j].Bucket })
+	return out
+}
+
+// VolumeSeries returns transfer volume per bucket, chain, network and
+// token, with tokens checked against the token list. Scam tokens are left
+// out unless q.IncludeScam is set.
+func (s *EventStore) VolumeSeries(q AnalyticsQuery) ([]VolumePoint, error) {
+	var points []VolumePoint
+	var err error
+	if s.repo != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		points, err = s.repo.VolumeSeries(ctx, q)
+		if err != nil {
+			log.WithError(err).Warn("repository query failed; falling back to in-memory")
+		}
+	}
+	if s.repo == nil || err != nil {
+		if points, err = s.cache.VolumeSeries(context.Background(), q); err != nil {
+			return nil, err
+		}
+	}
+
+	points = s.tokens.ResolveVolume(points)
+	if q.IncludeScam {
+		return points, nil
+	}
+	kept := points[:0]
+	for _, p := range points {
+		if !p.Scam {
+			kept = append(kept, p)
+		}
+	}
+	return kept, nil
+}
+
+// ActiveWalletsSeries returns the number of distinct active addresses per
+// bucket.
+func (s *EventStore) ActiveWalletsSeries(q AnalyticsQuery) ([]ActiveWalletsPoint, error) {
+	if s.repo != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		points, err := s.repo.ActiveWalletsSeries(ctx, q)
+		if err == nil {
+			return points, nil
+		}
+		log.WithError(err).Warn("repository query failed; falling back to in-memory")
+	}
+	return s.cache.ActiveWalletsSeries(context.Background(), q)
+}
+
+func writeAnalytics(w http.ResponseWriter, q AnalyticsQuery, points interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(AnalyticsSeries{
+		Interval: q.Interval,
+		Start:    q.Start.Format(time.RFC3339),
+		End:      q.End.Format(time.RFC3339),
+		Points:   points,
+	})
+}
+
+// getVolumeAnalytics serves transfer volume as a time series.
+func getVolumeAnalytics(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	q, err := parseAnalyticsQuery(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	points, err := store.VolumeSeries(q)
+	if err != nil {
+		log.WithError(err).Error("volume analytics failed")
+		httpError(w, "could not compute volume", http.StatusInternalServerError)
+		return
+	}
+	writeAnalytics(w, q, points)
+}
+
+// getActiveWalletsAnalytics serves distinct active wallets as a time series.
+func getActiveWalletsAnalytics(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	q, err := parseAnalyticsQuery(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	points, err := store.ActiveWalletsSeries(q)
+	if err != nil {
+		log.WithError(err).Error("active wallets analytics failed")
+		httpError(w, "could not compute active wallets", http.StatusInternalServerError)
+		return
+	}
+	writeAnalytics(w, q, points)
+}
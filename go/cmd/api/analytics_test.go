@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVolumeAndActiveWalletsSeries(t *testing.T) {
+	store := NewEventStore(100, 50)
+	base := time.Date(2025, 10, 14, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) string { return base.Add(d).Format(time.RFC3339) }
+
+	store.Add(makeEvent("1", "alice", "bob", "1000000000000000000000", at(5*time.Minute), ""))
+	store.Add(makeEvent("2", "bob", "carol", "2000000000000000000000", at(20*time.Minute), ""))
+	store.Add(makeEvent("3", "alice", "dave", "5", at(30*time.Minute), "USDC"))
+	store.Add(makeEvent("4", "erin", "frank", "7", at(90*time.Minute), ""))
+	orphan := makeEvent("5", "mallory", "trent", "9", at(10*time.Minute), "")
+	orphan.Status = StatusOrphaned
+	store.Add(orphan)
+
+	// The devnet "USDC" fixture impersonates the curated mint, so keep scam
+	// tokens to check the aggregation itself
+	q := AnalyticsQuery{Interval: "1h", Start: base, End: base.Add(2 * time.Hour), IncludeScam: true}
+	volume, err := store.VolumeSeries(q)
+	if err != nil {
+		t.Fatalf("volume: %v", err)
+	}
+	want := []VolumePoint{
+		{Bucket: "2025-10-14T10:00:00Z", Chain: "solana", Network: "devnet", Token: "USDC", TokenAddress: "tkn", Scam: true, Volume: "5", Transfers: 1},
+		{Bucket: "2025-10-14T10:00:00Z", Chain: "solana", Network: "devnet", Token: nativeToken, Verified: true, Volume: "3000000000000000000000", Transfers: 2},
+		{Bucket: "2025-10-14T11:00:00Z", Chain: "solana", Network: "devnet", Token: nativeToken, Verified: true, Volume: "7", Transfers: 1},
+	}
+	if len(volume) != len(want) {
+		t.Fatalf("expected %d points, got %+v", len(want), volume)
+	}
+	for i := range want {
+		if volume[i] != want[i] {
+			t.Fatalf("point %d: want %+v, got %+v", i, want[i], volume[i])
+		}
+	}
+
+	active, err := store.ActiveWalletsSeries(q)
+	if err != nil {
+		t.Fatalf("active wallets: %v", err)
+	}
+	if len(active) != 2 || active[0].Wallets != 4 || active[1].Wallets != 2 {
+		t.Fatalf("unexpected active wallets %+v", active)
+	}
+
+	q.Interval = "1d"
+	active, _ = store.ActiveWalletsSeries(q)
+	if len(active) != 1 || active[0].Bucket != "2025-10-14T00:00:00Z" || active[0].Wallets != 6 {
+		t.Fatalf("unexpected daily active wallets %+v", active)
+	}
+}
+
+func TestAnalyticsQueryValidation(t *testing.T) {
+	store := NewEventStore(10, 10)
+	for _, qs := range []string{
+		"interval=5m",
+		"start=yesterday",
+		"start=2025-10-14T12:00:00Z&end=2025-10-14T11:00:00Z",
+		"interval=1h&start=2020-01-01T00:00:00Z&end=2025-01-01T00:00:00Z",
+	} {
+		r := httptest.NewRecorder()
+		getVolumeAnalytics(store, r, httptest.NewRequest(http.MethodGet, "/analytics/volume?"+qs, nil))
+		if r.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", qs, r.Code)
+		}
+	}
+
+	r := httptest.NewRecorder()
+	getActiveWalletsAnalytics(store, r, httptest.NewRequest(http.MethodGet, "/analytics/active-wallets?interval=1d", nil))
+	var series AnalyticsSeries
+	if err := json.NewDecoder(r.Body).Decode(&series); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	start, _ := time.Parse(time.RFC3339, series.Start)
+	end, _ := time.Parse(time.RFC3339, series.End)
+	if series.Interval != "1d" || end.Sub(start) != 30*24*time.Hour {
+		t.Fatalf("expected default 30 day range, got %+v", series)
+	}
+}
@@ -250,10 +250,16 @@ func makeEvent(id, from, to, value, ts string, tokenSymbol string) *Event {
 	if tokenSymbol != "" {
 		tok = &Token{Address: "tkn", Symbol: tokenSymbol, Decimals: 18}
 	}
+	// Fixtures sent from an EVM address are on an EVM chain, so their
+	// addresses are valid for it; the rest are on Solana
+	chain, network := "solana", "devnet"
+	if isEVMAddress(from) {
+		chain, network = "ethereum", "sepolia"
+	}
 	return &Event{
 		EventID:   id,
-		Chain:     "solana",
-		Network:   "devnet",
+		Chain:     chain,
+		Network:   network,
 		TxHash:    "hash",
 		Timestamp: ts,
 		From:      from,
@@ -264,6 +270,13 @@ func makeEvent(id, from, to, value, ts string, tokenSymbol string) *Event {
 	}
 }
 
+// Wallets in valid address formats, for handlers that validate addresses.
+const (
+	aliceAddr = "0xa11ce00000000000000000000000000000000000"
+	bobAddr   = "0xb0b0000000000000000000000000000000000000"
+	carolAddr = "0xca20100000000000000000000000000000000000"
+)
+
 // helper to attach chi route param
 func withChiParam(req *http.Request, key, val string) *http.Request {
 	rctx := chi.NewRouteContext()
@@ -277,12 +290,12 @@ func TestGetWalletTransactionsAndFilters(t *testing.T) {
 	// timestamps in RFC3339
 	ts1 := time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
 	ts2 := time.Now().Add(-1 * time.Hour).UTC().Format(time.RFC3339)
-	store.Add(makeEvent("1", "alice", "bob", "1.23", ts1, ""))
-	store.Add(makeEvent("2", "carol", "alice", "5.00", ts2, "USDC"))
+	store.Add(makeEvent("1", aliceAddr, bobAddr, "1.23", ts1, ""))
+	store.Add(makeEvent("2", carolAddr, aliceAddr, "5.00", ts2, "USDC"))
 
 	// request for alice
-	req := httptest.NewRequest(http.MethodGet, "/wallet/alice/transactions?limit=1", nil)
-	req = withChiParam(req, "address", "alice")
+	req := httptest.NewRequest(http.MethodGet, "/wallet/"+aliceAddr+"/transactions?limit=1", nil)
+	req = withChiParam(req, "address", aliceAddr)
 	r := httptest.NewRecorder()
 
 	getWalletTransactions(store, r, req)
@@ -299,8 +312,8 @@ func TestGetWalletTransactionsAndFilters(t *testing.T) {
 	}
 
 	// Test token filter (should only return event with token USDC)
-	req2 := httptest.NewRequest(http.MethodGet, "/wallet/alice/transactions?token=USDC", nil)
-	req2 = withChiParam(req2, "address", "alice")
+	req2 := httptest.NewRequest(http.MethodGet, "/wallet/"+aliceAddr+"/transactions?token=USDC", nil)
+	req2 = withChiParam(req2, "address", aliceAddr)
 	r2 := httptest.NewRecorder()
 	getWalletTransactions(store, r2, req2)
 	if r2.Code != http.StatusOK {
@@ -315,8 +328,8 @@ func TestGetWalletTransactionsAndFilters(t *testing.T) {
 	}
 
 	// Test min_value (min_value=2 should filter out the 1.23 value)
-	req3 := httptest.NewRequest(http.MethodGet, "/wallet/alice/transactions?min_value=2", nil)
-	req3 = withChiParam(req3, "address", "alice")
+	req3 := httptest.NewRequest(http.MethodGet, "/wallet/"+aliceAddr+"/transactions?min_value=2", nil)
+	req3 = withChiParam(req3, "address", aliceAddr)
 	r3 := httptest.NewRecorder()
 	getWalletTransactions(store, r3, req3)
 	if r3.Code != http.StatusOK {
@@ -331,6 +344,37 @@ func TestGetWalletTransactionsAndFilters(t *testing.T) {
 	}
 }
 
+func TestGetWalletTransactionsPreservesSolanaCase(t *testing.T) {
+	store := NewEventStore(100, 50)
+	// The system program: 32 zero bytes
+	const systemProgram = "11111111111111111111111111111111"
+	store.Add(makeEvent("1", wrappedSOL, systemProgram, "1", time.Now().UTC().Format(time.RFC3339), ""))
+
+	get := func(address string) *httptest.ResponseRecorder {
+		r := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/wallet/"+address+"/transactions?chain=solana", nil)
+		getWalletTransactions(store, r, withChiParam(req, "address", address))
+		return r
+	}
+
+	r := get(wrappedSOL)
+	var events []*Event
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil || r.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %v", r.Code, err)
+	}
+	if len(events) != 1 || events[0].From != wrappedSOL || events[0].To != systemProgram {
+		t.Fatalf("expected the event with its keys unchanged, got %+v", events)
+	}
+	// Base58 is case-sensitive: the lowercased key is another, unknown wallet
+	if r := get(strings.ToLower(wrappedSOL)); r.Code != http.StatusNotFound {
+		t.Fatalf("lowercased key: expected 404, got %d", r.Code)
+	}
+	// An EVM address is not a Solana wallet
+	if r := get(aliceAddr); r.Code != http.StatusBadRequest {
+		t.Fatalf("evm address on solana: expected 400, got %d", r.Code)
+	}
+}
+
 func TestGetTransactionsFiltersAndPagination(t *testing.T) {
 	store := NewEventStore(1000, 100)
 
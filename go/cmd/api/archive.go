@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveConfig locates the S3-compatible bucket aged events are exported
+// to before retention deletes them.
+type ArchiveConfig struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Prefix    string
+}
+
+// ArchiveConfigFromEnv reads ARCHIVE_S3_ENDPOINT, ARCHIVE_S3_BUCKET,
+// ARCHIVE_S3_REGION, ARCHIVE_S3_ACCESS_KEY, ARCHIVE_S3_SECRET_KEY and
+// ARCHIVE_S3_PREFIX. The region defaults to us-east-1.
+func ArchiveConfigFromEnv() ArchiveConfig {
+	cfg := ArchiveConfig{
+		Endpoint:  os.Getenv("ARCHIVE_S3_ENDPOINT"),
+		Bucket:    os.Getenv("ARCHIVE_S3_BUCKET"),
+		Region:    os.Getenv("ARCHIVE_S3_REGION"),
+		AccessKey: os.Getenv("ARCHIVE_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("ARCHIVE_S3_SECRET_KEY"),
+		Prefix:    strings.Trim(os.Getenv("ARCHIVE_S3_PREFIX"), "/"),
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	return cfg
+}
+
+// S3Archive uploads objects to an S3-compatible store (AWS S3, MinIO,
+// Ceph, R2...) with path-style URLs and Signature Version 4, so it needs
+// no SDK.
+type S3Archive struct {
+	endpoint *url.URL
+	cfg      ArchiveConfig
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3Archive returns the archive cfg describes, or nil when no bucket is
+// configured.
+func NewS3Archive(cfg ArchiveConfig) (*S3Archive, error) {
+	if cfg.Bucket == "" {
+		return nil, nil
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid ARCHIVE_S3_ENDPOINT %q", cfg.Endpoint)
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("ARCHIVE_S3_ACCESS_KEY and ARCHIVE_S3_SECRET_KEY must be set with ARCHIVE_S3_BUCKET")
+	}
+	return &S3Archive{endpoint: endpoint, cfg: cfg, client: &http.Client{Timeout: 30 * time.Minute}, now: time.Now}, nil
+}
+
+// String describes the archive location, without credentials.
+func (a *S3Archive) String() string {
+	if a == nil {
+		return ""
+	}
+	return a.endpoint.String() + "/" + a.cfg.Bucket + "/" + a.cfg.Prefix
+}
+
+// Key returns the object key of name under the configured prefix.
+func (a *S3Archive) Key(name string) string {
+	if a.cfg.Prefix == "" {
+		return name
+	}
+	return a.cfg.Prefix + "/" + name
+}
+
+// Put uploads size bytes of body, whose SHA-256 is digest, under key.
+// body is read from the start on every attempt.
+func (a *S3Archive) Put(ctx context.Context, key string, body io.ReadSeeker, size int64, digest []byte, contentType string) error {
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	u := *a.endpoint
+	u.Path = a.endpoint.Path + "/" + a.cfg.Bucket + "/" + key
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), io.NopCloser(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	signV4(req, hex.EncodeToString(digest), a.cfg.Region, "s3", a.cfg.AccessKey, a.cfg.SecretKey, a.now())
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("archive upload of %s: %s: %s", key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// signV4 signs req with AWS Signature Version 4, covering the host and
+// every header set on req.
+func signV4(req *http.Request, payloadHash, region, service, accessKey, secretKey string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// canonicalQuery encodes query sorted by key and value, as SigV4 wants.
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, url.QueryEscape(key)+"="+url.QueryEscape(v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.ReplaceAll(strings.Join(pairs, "&"), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
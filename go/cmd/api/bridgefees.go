@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PriceTable holds USD prices of assets by symbol, from USD_PRICES.
+type PriceTable struct {
+	prices map[string]*big.Rat
+}
+
+// ParsePrices parses a comma-separated list of SYMBOL=price pairs, e.g.
+// "ETH=3200,SOL=145.5,USDC=1".
+func ParsePrices(spec string) (*PriceTable, error) {
+	t := &PriceTable{prices: make(map[string]*big.Rat)}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		symbol, price, ok := strings.Cut(item, "=")
+		p, valid := new(big.Rat).SetString(strings.TrimSpace(price))
+		if !ok || strings.TrimSpace(symbol) == "" || !valid || p.Sign() < 0 {
+			return nil, fmt.Errorf("invalid price %q: want SYMBOL=price", item)
+		}
+		t.prices[strings.ToUpper(strings.TrimSpace(symbol))] = p
+	}
+	return t, nil
+}
+
+// USD returns the price of one whole unit of symbol.
+func (t *PriceTable) USD(symbol string) (*big.Rat, bool) {
+	if t == nil {
+		return nil, false
+	}
+	p, ok := t.prices[strings.ToUpper(symbol)]
+	return p, ok
+}
+
+// eventAsset is the symbol of the asset ev moves: its token's, or the
+// chain's native currency's.
+func eventAsset(ev *Event) string {
+	if ev.Token != nil {
+		return strings.ToUpper(ev.Token.Symbol)
+	}
+	return chainAdapter(ev.Chain).NativeSymbol()
+}
+
+// BridgeFee is what a completed bridge transfer cost: the value lost
+// between the amount sent and the amount received, plus the transaction
+// fees paid on both sides. Amounts are in whole units of their asset and
+// USD values have two decimals. USD values are left out when an asset has
+// no price in USD_PRICES, and MissingPrices names those assets.
+type BridgeFee struct {
+	AmountIn     string `json:"amount_in"`
+	AmountOut    string `json:"amount_out"`
+	AmountInUSD  string `json:"amount_in_usd,omitempty"`
+	AmountOutUSD string `json:"amount_out_usd,omitempty"`
+	// GasUSD is the fee of the source transaction and of every
+	// destination transaction, each counted once.
+	GasUSD string `json:"gas_usd,omitempty"`
+	// FeeUSD is AmountInUSD - AmountOutUSD + GasUSD.
+	FeeUSD        string   `json:"fee_usd,omitempty"`
+	MissingPrices []string `json:"missing_prices,omitempty"`
+
+	// Exact USD values for aggregation; nil when a price is missing.
+	inUSD, feeUSD *big.Rat
+}
+
+// usdOf values ev's value, or its transaction fee when fee is set, in
+// whole units and USD, at the price resolved at ev's block when it has
+// one; usd is nil without a price.
+func usdOf(prices *PriceTable, ev *Event, fee bool) (whole, usd *big.Rat, symbol string) {
+	symbol = eventAsset(ev)
+	if fee {
+		symbol = chainAdapter(ev.Chain).NativeSymbol()
+		d, ok := chainAdapter(ev.Chain).NativeDecimals()
+		raw, valid := new(big.Int).SetString(ev.Fee, 10)
+		if !ok || !valid {
+			return nil, nil, symbol
+		}
+		whole = Amount{Raw: raw, Decimals: d}.Whole()
+	} else {
+		a, ok := eventAmount(ev)
+		if !ok {
+			return nil, nil, symbol
+		}
+		whole = a.Whole()
+	}
+	if p, ok := blockPrice(ev, symbol); ok {
+		usd = new(big.Rat).Mul(whole, p)
+	} else if p, ok := prices.USD(symbol); ok {
+		usd = new(big.Rat).Mul(whole, p)
+	}
+	return whole, usd, symbol
+}
+
+// bridgeFee computes the fee of transfer ev given its destination legs.
+func bridgeFee(prices *PriceTable, ev *Event, legs []*Event) *BridgeFee {
+	missing := map[string]bool{}
+	priced := true
+	add := func(sum, usd *big.Rat, symbol string) {
+		if usd == nil {
+			priced = false
+			if symbol == "" {
+				symbol = "unknown"
+			}
+			missing[symbol] = true
+			return
+		}
+		sum.Add(sum, usd)
+	}
+
+	inUSD, outUSD, gasUSD := new(big.Rat), new(big.Rat), new(big.Rat)
+	f := &BridgeFee{}
+	in, usd, symbol := usdOf(prices, ev, false)
+	if in == nil {
+		return nil
+	}
+	f.AmountIn = in.FloatString(decimalsOrZero(ev))
+	add(inUSD, usd, symbol)
+
+	out := new(big.Rat)
+	paid := map[string]bool{}
+	for _, tx := range append([]*Event{ev}, legs...) {
+		if tx != ev {
+			whole, usd, symbol := usdOf(prices, tx, false)
+			if whole != nil {
+				out.Add(out, whole)
+				add(outUSD, usd, symbol)
+			}
+		}
+		if tx.Fee == "" || paid[tx.Chain+":"+tx.TxHash] {
+			continue
+		}
+		paid[tx.Chain+":"+tx.TxHash] = true
+		if whole, usd, symbol := usdOf(prices, tx, true); whole != nil {
+			add(gasUSD, usd, symbol)
+		}
+	}
+	f.AmountOut = out.FloatString(decimalsOrZero(ev))
+
+	if !priced {
+		for s := range missing {
+			f.MissingPrices = append(f.MissingPrices, s)
+		}
+		sort.Strings(f.MissingPrices)
+		return f
+	}
+	f.inUSD = inUSD
+	f.feeUSD = new(big.Rat).Add(new(big.Rat).Sub(inUSD, outUSD), gasUSD)
+	f.AmountInUSD, f.AmountOutUSD = inUSD.FloatString(2), outUSD.FloatString(2)
+	f.GasUSD, f.FeeUSD = gasUSD.FloatString(2), f.feeUSD.FloatString(2)
+	return f
+}
+
+func decimalsOrZero(ev *Event) int {
+	d, _ := eventDecimals(ev)
+	return d
+}
+
+// BridgeFeeStats aggregates the fees of the completed transfers of one
+// route. USD totals only cover the Priced transfers.
+type BridgeFeeStats struct {
+	Bridge      string `json:"bridge"`
+	SourceChain string `json:"source_chain"`
+	DestChain   string `json:"dest_chain"`
+	Token       string `json:"token"`
+	Transfers   int    `json:"transfers"`
+	Priced      int    `json:"priced"`
+	VolumeUSD   string `json:"volume_usd"`
+	FeeUSD      string `json:"fee_usd"`
+	AvgFeeUSD   string `json:"avg_fee_usd,omitempty"`
+	// FeeBps is FeeUSD in basis points of VolumeUSD.
+	FeeBps string `json:"fee_bps,omitempty"`
+}
+
+// BridgeFeeStats aggregates the fees of the recent completed transfers
+// tenant sees by route, busiest first.
+func (s *EventStore) BridgeFeeStats(bridge, tenant string, now time.Time) []BridgeFeeStats {
+	type totals struct {
+		stats       BridgeFeeStats
+		volume, fee *big.Rat
+	}
+	byRoute := map[string]*totals{}
+	for _, t := range s.BridgeTransfers(bridge, TransferCompleted, tenant, maxBridgeTransfers, now) {
+		ev := t.Transfer
+		dest := ev.DestChain
+		if dest == "" {
+			dest = t.Legs[0].Chain
+		}
+		key := bridgeRouteKey(ev.Bridge, ev.SourceChain, dest, eventAsset(ev))
+		r, ok := byRoute[key]
+		if !ok {
+			r = &totals{
+				stats:  BridgeFeeStats{Bridge: ev.Bridge, SourceChain: ev.SourceChain, DestChain: dest, Token: eventAsset(ev)},
+				volume: new(big.Rat), fee: new(big.Rat),
+			}
+			byRoute[key] = r
+		}
+		r.stats.Transfers++
+		if t.Fees != nil && t.Fees.feeUSD != nil {
+			r.stats.Priced++
+			r.volume.Add(r.volume, t.Fees.inUSD)
+			r.fee.Add(r.fee, t.Fees.feeUSD)
+		}
+	}
+
+	out := make([]BridgeFeeStats, 0, len(byRoute))
+	for _, r := range byRoute {
+		r.stats.VolumeUSD, r.stats.FeeUSD = r.volume.FloatString(2), r.fee.FloatString(2)
+		if r.stats.Priced > 0 {
+			r.stats.AvgFeeUSD = new(big.Rat).Quo(r.fee, big.NewRat(int64(r.stats.Priced), 1)).FloatString(2)
+		}
+		if r.volume.Sign() > 0 {
+			r.stats.FeeBps = new(big.Rat).Quo(new(big.Rat).Mul(r.fee, big.NewRat(10000, 1)), r.volume).FloatString(2)
+		}
+		out = append(out, r.stats)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Transfers != out[j].Transfers {
+			return out[i].Transfers > out[j].Transfers
+		}
+		return bridgeRouteKey(out[i].Bridge, out[i].SourceChain, out[i].DestChain, out[i].Token) <
+			bridgeRouteKey(out[j].Bridge, out[j].SourceChain, out[j].DestChain, out[j].Token)
+	})
+	return out
+}
+
+// getBridgeFeeStats handles GET /stats/bridge-fees.
+func getBridgeFeeStats(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	p := newQueryParams(r)
+	bridge := p.Enum("bridge", bridgeProtocols...)
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(store.BridgeFeeStats(bridge, tenantFrom(r.Context()), time.Now().UTC()))
+}
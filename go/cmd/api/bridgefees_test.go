@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParsePrices(t *testing.T) {
+	prices, err := ParsePrices(" eth=3000, SOL=145.5 ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := prices.USD("ETH"); !ok || p.FloatString(0) != "3000" {
+		t.Fatalf("ETH = %v, %v", p, ok)
+	}
+	if _, ok := prices.USD("USDC"); ok {
+		t.Fatal("USDC priced")
+	}
+	for _, spec := range []string{"ETH", "ETH=abc", "=1", "ETH=-1"} {
+		if _, err := ParsePrices(spec); err == nil {
+			t.Errorf("ParsePrices(%q) accepted", spec)
+		}
+	}
+}
+
+func TestBridgeFees(t *testing.T) {
+	store := NewEventStore(100, 50)
+	prices, _ := ParsePrices("ETH=3000,USDC=1")
+	store.AttachPrices(prices)
+	now := time.Now().UTC()
+	ts := now.Add(-time.Hour).Format(time.RFC3339)
+	add := func(id, chain, txHash, sequence, token, value, fee string) {
+		ev := makeEvent(id, aliceAddr, bobAddr, value, ts, token)
+		ev.Chain, ev.TxHash, ev.Fee = chain, txHash, fee
+		ev.Bridge, ev.SourceChain, ev.DestChain, ev.Sequence = BridgeCCTP, "ethereum", "base", sequence
+		store.Add(ev)
+	}
+	// 1000 USDC in, 999.5 out, 0.001 ETH gas on ethereum and 0.0001 on base
+	add("burn", "ethereum", "0x01", "1", "USDC", "1000000000000000000000", "1000000000000000")
+	add("mint", "base", "0x02", "1", "USDC", "999500000000000000000", "100000000000000")
+	add("eurc-burn", "ethereum", "0x03", "2", "EURC", "5000000000000000000", "")
+	add("eurc-mint", "base", "0x04", "2", "EURC", "5000000000000000000", "")
+	add("pending", "ethereum", "0x05", "3", "USDC", "1000000000000000000", "")
+
+	burn, _ := store.GetByID("burn")
+	tr := store.BridgeTransfer(burn, "", now)
+	f := tr.Fees
+	if f == nil || f.AmountIn != "1000.000000000000000000" || f.AmountOut != "999.500000000000000000" ||
+		f.GasUSD != "3.30" || f.FeeUSD != "3.80" || f.AmountInUSD != "1000.00" || len(f.MissingPrices) != 0 {
+		t.Fatalf("fees = %+v", f)
+	}
+	pending, _ := store.GetByID("pending")
+	if tr := store.BridgeTransfer(pending, "", now); tr.Fees != nil {
+		t.Fatalf("pending transfer fees = %+v", tr.Fees)
+	}
+	eurc, _ := store.GetByID("eurc-burn")
+	if f := store.BridgeTransfer(eurc, "", now).Fees; f == nil || f.FeeUSD != "" || len(f.MissingPrices) != 1 || f.MissingPrices[0] != "EURC" {
+		t.Fatalf("unpriced fees = %+v", f)
+	}
+
+	rec := httptest.NewRecorder()
+	getBridgeFeeStats(store, rec, httptest.NewRequest(http.MethodGet, "/stats/bridge-fees?bridge=cctp", nil))
+	var stats []BridgeFeeStats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil || len(stats) != 2 {
+		t.Fatalf("stats = %d %+v, %v", rec.Code, stats, err)
+	}
+	byToken := map[string]BridgeFeeStats{stats[0].Token: stats[0], stats[1].Token: stats[1]}
+	if s := byToken["USDC"]; s.Transfers != 1 || s.Priced != 1 || s.VolumeUSD != "1000.00" || s.FeeUSD != "3.80" || s.AvgFeeUSD != "3.80" || s.FeeBps != "38.00" {
+		t.Errorf("USDC route = %+v", s)
+	}
+	if s := byToken["EURC"]; s.Transfers != 1 || s.Priced != 0 || s.FeeUSD != "0.00" || s.AvgFeeUSD != "" || s.FeeBps != "" {
+		t.Errorf("EURC route = %+v", s)
+	}
+
+	rec = httptest.NewRecorder()
+	getBridgeFeeStats(store, rec, httptest.NewRequest(http.MethodGet, "/stats/bridge-fees?bridge=hop", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown bridge = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+// Statuses of a bridge transfer on GET /bridges/transfers. A transfer
+// without a leg on the destination chain is stuck once it is older than
+// its route's expected latency; transfers on unknown routes stay pending.
+const (
+	TransferCompleted = "completed"
+	TransferPending   = "pending"
+	TransferStuck     = "stuck"
+)
+
+// maxBridgeTransfers bounds the source-side events looked up per bridge
+// protocol for GET /bridges/transfers.
+const maxBridgeTransfers = 200
+
+// bridgeProtocols are the protocols listed by GET /bridges/transfers.
+var bridgeProtocols = []string{BridgeWormhole, BridgeLayerZero, BridgeCCTP}
+
+// bridgeRoutesSchema creates the table bridge routes are persisted in. An
+// empty token is the route's default for every token.
+const bridgeRoutesSchema = `
+	CREATE TABLE IF NOT EXISTS bridge_routes (
+		bridge TEXT NOT NULL,
+		source_chain TEXT NOT NULL,
+		dest_chain TEXT NOT NULL,
+		token TEXT NOT NULL DEFAULT '',
+		expected_latency_seconds BIGINT NOT NULL,
+		min_fee TEXT NOT NULL DEFAULT '',
+		max_fee TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (bridge, source_chain, dest_chain, token)
+	);
+`
+
+// BridgeRoute is a known way of moving assets between two chains, with
+// how long a transfer should take and what its source transaction should
+// cost. Fees are in the source chain's smallest native unit, like an
+// event's fee.
+type BridgeRoute struct {
+	Bridge      string `json:"bridge"`
+	SourceChain string `json:"source_chain"`
+	DestChain   string `json:"dest_chain"`
+	// Token is the symbol the route applies to; an empty Token applies to
+	// every token without a route of its own.
+	Token                  string `json:"token,omitempty"`
+	ExpectedLatencySeconds int64  `json:"expected_latency_seconds"`
+	MinFee                 string `json:"min_fee,omitempty"`
+	MaxFee                 string `json:"max_fee,omitempty"`
+	UpdatedAt              string `json:"updated_at,omitempty"`
+}
+
+// BridgeRouteUpdate is the body of PUT
+// /admin/bridges/routes/{bridge}/{source_chain}/{dest_chain}.
+type BridgeRouteUpdate struct {
+	ExpectedLatencySeconds int64  `json:"expected_latency_seconds"`
+	MinFee                 string `json:"min_fee,omitempty"`
+	MaxFee                 string `json:"max_fee,omitempty"`
+}
+
+func bridgeRouteKey(bridge, source, dest, token string) string {
+	return bridge + ":" + source + ":" + dest + ":" + token
+}
+
+// BridgeRouteRegistry holds the known bridge routes. It starts out empty
+// and is edited through the admin API; with a database attached, routes
+// are persisted to the bridge_routes table and survive restarts.
+type BridgeRouteRegistry struct {
+	mu     sync.RWMutex
+	routes map[string]BridgeRoute // bridge:source:dest:token -> route
+	db     *pgxpool.Pool
+}
+
+func NewBridgeRouteRegistry() *BridgeRouteRegistry {
+	return &BridgeRouteRegistry{routes: make(map[string]BridgeRoute)}
+}
+
+// AttachDB connects the registry to Postgres and loads the stored routes.
+func (reg *BridgeRouteRegistry) AttachDB(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx, `
+		SELECT bridge, source_chain, dest_chain, token, expected_latency_seconds, min_fee, max_fee, updated_at FROM bridge_routes
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	loaded := make(map[string]BridgeRoute)
+	for rows.Next() {
+		var rt BridgeRoute
+		var updated time.Time
+		if err := rows.Scan(&rt.Bridge, &rt.SourceChain, &rt.DestChain, &rt.Token, &rt.ExpectedLatencySeconds, &rt.MinFee, &rt.MaxFee, &updated); err != nil {
+			return err
+		}
+		rt.UpdatedAt = updated.UTC().Format(time.RFC3339)
+		loaded[bridgeRouteKey(rt.Bridge, rt.SourceChain, rt.DestChain, rt.Token)] = rt
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	reg.mu.Lock()
+	reg.routes = loaded
+	reg.db = db
+	reg.mu.Unlock()
+	return nil
+}
+
+// Lookup returns the route of a transfer, preferring one for its token
+// over the route's default.
+func (reg *BridgeRouteRegistry) Lookup(bridge, source, dest, token string) (BridgeRoute, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	if token != "" {
+		if rt, ok := reg.routes[bridgeRouteKey(bridge, source, dest, strings.ToUpper(token))]; ok {
+			return rt, true
+		}
+	}
+	rt, ok := reg.routes[bridgeRouteKey(bridge, source, dest, "")]
+	return rt, ok
+}
+
+// List returns every route sorted by bridge, chains and token.
+func (reg *BridgeRouteRegistry) List() []BridgeRoute {
+	reg.mu.RLock()
+	out := make([]BridgeRoute, 0, len(reg.routes))
+	for _, rt := range reg.routes {
+		out = append(out, rt)
+	}
+	reg.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		return bridgeRouteKey(out[i].Bridge, out[i].SourceChain, out[i].DestChain, out[i].Token) <
+			bridgeRouteKey(out[j].Bridge, out[j].SourceChain, out[j].DestChain, out[j].Token)
+	})
+	return out
+}
+
+// Put adds or replaces a route. Invalid input is reported as a
+// *FieldError.
+func (reg *BridgeRouteRegistry) Put(ctx context.Context, bridge, source, dest, token string, u BridgeRouteUpdate) (BridgeRoute, error) {
+	rt := BridgeRoute{
+		Bridge:                 strings.ToLower(bridge),
+		SourceChain:            strings.ToLower(source),
+		DestChain:              strings.ToLower(dest),
+		Token:                  strings.ToUpper(strings.TrimSpace(token)),
+		ExpectedLatencySeconds: u.ExpectedLatencySeconds,
+		MinFee:                 strings.TrimSpace(u.MinFee),
+		MaxFee:                 strings.TrimSpace(u.MaxFee),
+	}
+	if !containsToken(bridgeProtocols, rt.Bridge) {
+		return rt, invalidParam("bridge", "bridge must be one of %s", strings.Join(bridgeProtocols, ", "))
+	}
+	if !chainNamePattern.MatchString(rt.SourceChain) {
+		return rt, invalidParam("source_chain", "source_chain must be lowercase letters, digits, '-' or '_'")
+	}
+	if !chainNamePattern.MatchString(rt.DestChain) {
+		return rt, invalidParam("dest_chain", "dest_chain must be lowercase letters, digits, '-' or '_'")
+	}
+	if rt.ExpectedLatencySeconds <= 0 {
+		return rt, invalidParam("expected_latency_seconds", "expected_latency_seconds must be positive")
+	}
+	minFee, ok := parseFee(rt.MinFee)
+	if !ok {
+		return rt, invalidParam("min_fee", "min_fee must be a non-negative integer")
+	}
+	maxFee, ok := parseFee(rt.MaxFee)
+	if !ok {
+		return rt, invalidParam("max_fee", "max_fee must be a non-negative integer")
+	}
+	if minFee != nil && maxFee != nil && minFee.Cmp(maxFee) > 0 {
+		return rt, invalidParam("max_fee", "max_fee must not be below min_fee")
+	}
+	rt.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.db != nil {
+		_, err := reg.db.Exec(ctx, `
+			INSERT INTO bridge_routes (bridge, source_chain, dest_chain, token, expected_latency_seconds, min_fee, max_fee, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+			ON CONFLICT (bridge, source_chain, dest_chain, token) DO UPDATE SET
+				expected_latency_seconds = EXCLUDED.expected_latency_seconds, min_fee = EXCLUDED.min_fee,
+				max_fee = EXCLUDED.max_fee, updated_at = NOW()
+		`, rt.Bridge, rt.SourceChain, rt.DestChain, rt.Token, rt.ExpectedLatencySeconds, rt.MinFee, rt.MaxFee)
+		if err != nil {
+			return rt, err
+		}
+	}
+	reg.routes[bridgeRouteKey(rt.Bridge, rt.SourceChain, rt.DestChain, rt.Token)] = rt
+	return rt, nil
+}
+
+// Delete removes a route and reports whether it existed.
+func (reg *BridgeRouteRegistry) Delete(ctx context.Context, bridge, source, dest, token string) (bool, error) {
+	bridge, source, dest = strings.ToLower(bridge), strings.ToLower(source), strings.ToLower(dest)
+	token = strings.ToUpper(strings.TrimSpace(token))
+	key := bridgeRouteKey(bridge, source, dest, token)
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, ok := reg.routes[key]; !ok {
+		return false, nil
+	}
+	if reg.db != nil {
+		if _, err := reg.db.Exec(ctx, `
+			DELETE FROM bridge_routes WHERE bridge = $1 AND source_chain = $2 AND dest_chain = $3 AND token = $4
+		`, bridge, source, dest, token); err != nil {
+			return false, err
+		}
+	}
+	delete(reg.routes, key)
+	return true, nil
+}
+
+// parseFee parses an optional fee bound; nil means unbounded.
+func parseFee(s string) (*big.Int, bool) {
+	if s == "" {
+		return nil, true
+	}
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 {
+		return nil, false
+	}
+	return n, true
+}
+
+// BridgeTransfer is the source side of a bridge transfer with its legs on
+// the destination chain and how it compares to its route.
+type BridgeTransfer struct {
+	Transfer *Event   `json:"transfer"`
+	Legs     []*Event `json:"legs"`
+	Status   string   `json:"status"`
+	// LatencySeconds is how long the first destination leg took to appear,
+	// or the transfer's age while it has none.
+	LatencySeconds int64        `json:"latency_seconds"`
+	Route          *BridgeRoute `json:"route,omitempty"`
+	// WithinSLA and FeeInRange compare the transfer to its route; they are
+	// omitted without a route, and FeeInRange without a fee or fee range.
+	WithinSLA  *bool `json:"within_sla,omitempty"`
+	FeeInRange *bool `json:"fee_in_range,omitempty"`
+	// Fees is the cost of a completed transfer.
+	Fees *BridgeFee `json:"fees,omitempty"`
+}
+
+// BridgeTransfer classifies ev, the source side of a bridge transfer, as
+// seen by tenant at now.
+func (s *EventStore) BridgeTransfer(ev *Event, tenant string, now time.Time) BridgeTransfer {
+	t := BridgeTransfer{Transfer: ev, Legs: []*Event{}, Status: TransferPending}
+	sent, err := time.Parse(time.RFC3339, ev.Timestamp)
+	if err != nil {
+		sent = now
+	}
+	arrived := now
+	for _, leg := range s.BridgeLegs(ev, tenant) {
+		if leg.Chain == ev.SourceChain {
+			continue
+		}
+		t.Legs = append(t.Legs, leg)
+		if ts, err := time.Parse(time.RFC3339, leg.Timestamp); err == nil && (t.Status != TransferCompleted || ts.Before(arrived)) {
+			arrived = ts
+		}
+		t.Status = TransferCompleted
+	}
+	if t.Status == TransferCompleted {
+		t.Fees = bridgeFee(s.prices, ev, t.Legs)
+	}
+	t.LatencySeconds = int64(arrived.Sub(sent) / time.Second)
+	if t.LatencySeconds < 0 {
+		t.LatencySeconds = 0
+	}
+
+	dest := ev.DestChain
+	if dest == "" && len(t.Legs) > 0 {
+		dest = t.Legs[0].Chain
+	}
+	var token string
+	if ev.Token != nil {
+		token = ev.Token.Symbol
+	}
+	rt, ok := s.routes.Lookup(ev.Bridge, ev.SourceChain, dest, token)
+	if !ok {
+		return t
+	}
+	t.Route = &rt
+	within := t.LatencySeconds <= rt.ExpectedLatencySeconds
+	t.WithinSLA = &within
+	if t.Status == TransferPending && !within {
+		t.Status = TransferStuck
+	}
+	if fee, ok := parseFee(ev.Fee); ok && fee != nil && (rt.MinFee != "" || rt.MaxFee != "") {
+		minFee, _ := parseFee(rt.MinFee)
+		maxFee, _ := parseFee(rt.MaxFee)
+		inRange := (minFee == nil || fee.Cmp(minFee) >= 0) && (maxFee == nil || fee.Cmp(maxFee) <= 0)
+		t.FeeInRange = &inRange
+	}
+	return t
+}
+
+// BridgeTransfers returns the most recent bridge transfers sent from a
+// chain the tracker ingests, newest first, one per bridge message.
+func (s *EventStore) BridgeTransfers(bridge, status, tenant string, limit int, now time.Time) []BridgeTransfer {
+	bridges := bridgeProtocols
+	if bridge != "" {
+		bridges = []string{bridge}
+	}
+	var sources []*Event
+	seen := make(map[string]bool)
+	for _, b := range bridges {
+		for _, ev := range s.GetRecent(EventFilter{Bridge: b, Tenant: tenant, Limit: maxBridgeTransfers}) {
+			key := ev.Bridge + ":" + ev.SourceChain + ":" + ev.Sequence
+			if ev.Sequence == "" || ev.Chain != ev.SourceChain || seen[key] {
+				continue
+			}
+			seen[key] = true
+			sources = append(sources, ev)
+		}
+	}
+	sort.SliceStable(sources, func(i, j int) bool { return sources[i].Timestamp > sources[j].Timestamp })
+
+	out := []BridgeTransfer{}
+	for _, ev := range sources {
+		if len(out) == limit {
+			break
+		}
+		if t := s.BridgeTransfer(ev, tenant, now); status == "" || t.Status == status {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// getBridgeTransfers handles GET /bridges/transfers, listing bridge
+// transfers with their destination legs and route SLA.
+func getBridgeTransfers(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	p := newQueryParams(r)
+	bridge := p.Enum("bridge", bridgeProtocols...)
+	status := p.Enum("status", TransferCompleted, TransferPending, TransferStuck)
+	limit := p.Int("limit", defaultPageSize, 1, maxPageSize)
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	transfers := store.BridgeTransfers(bridge, status, tenantFrom(r.Context()), limit, time.Now().UTC())
+	for i := range transfers {
+		transfers[i].Transfer = store.EnrichOne(transfers[i].Transfer)
+		transfers[i].Legs = store.Enrich(transfers[i].Legs)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(transfers)
+}
+
+// listBridgeRoutes lists the known bridge routes.
+func listBridgeRoutes(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(store.routes.List())
+}
+
+// putBridgeRoute adds or replaces the route named by the path and ?token=.
+func putBridgeRoute(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	var u BridgeRouteUpdate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&u); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	rt, err := store.routes.Put(r.Context(), chi.URLParam(r, "bridge"), chi.URLParam(r, "source_chain"),
+		chi.URLParam(r, "dest_chain"), r.URL.Query().Get("token"), u)
+	var fe *FieldError
+	switch {
+	case errors.As(err, &fe):
+		badRequest(w, err)
+		return
+	case err != nil:
+		log.WithError(err).Error("failed to store bridge route")
+		httpError(w, "could not store bridge route", http.StatusInternalServerError)
+		return
+	}
+	log.WithFields(log.Fields{"bridge": rt.Bridge, "source_chain": rt.SourceChain, "dest_chain": rt.DestChain, "token": rt.Token}).
+		Info("admin: bridge route updated")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rt)
+}
+
+// deleteBridgeRoute removes the route named by the path and ?token=.
+func deleteBridgeRoute(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	ok, err := store.routes.Delete(r.Context(), chi.URLParam(r, "bridge"), chi.URLParam(r, "source_chain"),
+		chi.URLParam(r, "dest_chain"), r.URL.Query().Get("token"))
+	if err != nil {
+		log.WithError(err).Error("failed to delete bridge route")
+		httpError(w, "could not delete bridge route", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "bridge route not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestBridgeRouteRegistry(t *testing.T) {
+	reg := NewBridgeRouteRegistry()
+	ctx := context.Background()
+	if _, err := reg.Put(ctx, "CCTP", "ethereum", "base", "", BridgeRouteUpdate{ExpectedLatencySeconds: 900}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Put(ctx, "cctp", "ethereum", "base", "usdc", BridgeRouteUpdate{ExpectedLatencySeconds: 1200, MaxFee: "5000"}); err != nil {
+		t.Fatal(err)
+	}
+	if rt, ok := reg.Lookup("cctp", "ethereum", "base", "USDC"); !ok || rt.ExpectedLatencySeconds != 1200 {
+		t.Fatalf("USDC route = %+v, %v", rt, ok)
+	}
+	if rt, ok := reg.Lookup("cctp", "ethereum", "base", "EURC"); !ok || rt.ExpectedLatencySeconds != 900 {
+		t.Fatalf("default route = %+v, %v", rt, ok)
+	}
+	if _, ok := reg.Lookup("cctp", "base", "ethereum", ""); ok {
+		t.Fatal("reverse route found")
+	}
+
+	for _, tc := range []struct {
+		bridge, source string
+		u              BridgeRouteUpdate
+		field          string
+	}{
+		{"hop", "ethereum", BridgeRouteUpdate{ExpectedLatencySeconds: 60}, "bridge"},
+		{"cctp", "Ethereum Mainnet", BridgeRouteUpdate{ExpectedLatencySeconds: 60}, "source_chain"},
+		{"cctp", "ethereum", BridgeRouteUpdate{}, "expected_latency_seconds"},
+		{"cctp", "ethereum", BridgeRouteUpdate{ExpectedLatencySeconds: 60, MinFee: "-1"}, "min_fee"},
+		{"cctp", "ethereum", BridgeRouteUpdate{ExpectedLatencySeconds: 60, MinFee: "10", MaxFee: "5"}, "max_fee"},
+	} {
+		var fe *FieldError
+		if _, err := reg.Put(ctx, tc.bridge, tc.source, "base", "", tc.u); !errors.As(err, &fe) || fe.Field != tc.field {
+			t.Errorf("Put(%s, %s, %+v) = %v, want an error on %s", tc.bridge, tc.source, tc.u, err, tc.field)
+		}
+	}
+
+	if ok, err := reg.Delete(ctx, "cctp", "ethereum", "base", "USDC"); !ok || err != nil {
+		t.Fatalf("delete = %v, %v", ok, err)
+	}
+	if ok, _ := reg.Delete(ctx, "cctp", "ethereum", "base", "USDC"); ok {
+		t.Fatal("second delete found the route")
+	}
+	if list := reg.List(); len(list) != 1 || list[0].Token != "" {
+		t.Fatalf("routes = %+v", list)
+	}
+}
+
+func TestBridgeTransfers(t *testing.T) {
+	store := NewEventStore(100, 50)
+	now := time.Now().UTC()
+	add := func(id, chain, txHash, sequence, fee string, age time.Duration) {
+		ev := makeEvent(id, aliceAddr, bobAddr, "1", now.Add(-age).Format(time.RFC3339), "USDC")
+		ev.Chain, ev.TxHash, ev.Fee = chain, txHash, fee
+		ev.Bridge, ev.SourceChain, ev.DestChain, ev.Sequence = BridgeCCTP, "ethereum", "base", sequence
+		store.Add(ev)
+	}
+	add("done-burn", "ethereum", "0x01", "1", "4000", 50*time.Minute)
+	add("done-mint", "base", "0x02", "1", "", 40*time.Minute)
+	add("late-burn", "ethereum", "0x03", "2", "9000", 30*time.Minute)
+	add("new-burn", "ethereum", "0x04", "3", "", time.Minute)
+
+	// Without a route nothing can be stuck.
+	if got := store.BridgeTransfers("", TransferStuck, "", 10, now); len(got) != 0 {
+		t.Fatalf("stuck without routes = %+v", got)
+	}
+
+	router := chi.NewRouter()
+	mountAdmin(router, "s3cret", store, NewChainRegistry(nil, nil), nil, nil)
+	req := httptest.NewRequest(http.MethodPut, "/admin/bridges/routes/cctp/ethereum/base?token=usdc",
+		strings.NewReader(`{"expected_latency_seconds":900,"max_fee":"5000"}`))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("put route = %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	getBridgeTransfers(store, rec, httptest.NewRequest(http.MethodGet, "/bridges/transfers", nil))
+	var transfers []BridgeTransfer
+	if err := json.NewDecoder(rec.Body).Decode(&transfers); err != nil || len(transfers) != 3 {
+		t.Fatalf("transfers = %d %+v, %v", rec.Code, transfers, err)
+	}
+	want := map[string]struct {
+		status     string
+		within     bool
+		feeInRange *bool
+	}{
+		"new-burn":  {TransferPending, true, nil},
+		"late-burn": {TransferStuck, false, new(bool)},
+		"done-burn": {TransferCompleted, true, func() *bool { b := true; return &b }()},
+	}
+	for i, tr := range transfers {
+		w := want[tr.Transfer.EventID]
+		if tr.Status != w.status || tr.WithinSLA == nil || *tr.WithinSLA != w.within || tr.Route == nil {
+			t.Errorf("%d: %s = %s within_sla %v, want %s within_sla %v", i, tr.Transfer.EventID, tr.Status, tr.WithinSLA, w.status, w.within)
+		}
+		if (tr.FeeInRange == nil) != (w.feeInRange == nil) || (tr.FeeInRange != nil && *tr.FeeInRange != *w.feeInRange) {
+			t.Errorf("%s fee_in_range = %v, want %v", tr.Transfer.EventID, tr.FeeInRange, w.feeInRange)
+		}
+	}
+	if transfers[0].Transfer.EventID != "new-burn" {
+		t.Errorf("first transfer = %s, want the newest", transfers[0].Transfer.EventID)
+	}
+	if done := transfers[2]; len(done.Legs) != 1 || done.Legs[0].EventID != "done-mint" || done.LatencySeconds != 600 {
+		t.Errorf("completed transfer = %+v, want done-mint after 600s", done)
+	}
+
+	rec = httptest.NewRecorder()
+	getBridgeTransfers(store, rec, httptest.NewRequest(http.MethodGet, "/bridges/transfers?status=stuck", nil))
+	if err := json.NewDecoder(rec.Body).Decode(&transfers); err != nil || len(transfers) != 1 || transfers[0].Transfer.EventID != "late-burn" {
+		t.Fatalf("stuck transfers = %+v, %v", transfers, err)
+	}
+	rec = httptest.NewRecorder()
+	getBridgeTransfers(store, rec, httptest.NewRequest(http.MethodGet, "/bridges/transfers?status=lost", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid status = %d, want 400", rec.Code)
+	}
+}
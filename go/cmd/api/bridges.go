@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Bridge protocols the listener decodes messages of.
+const (
+	BridgeWormhole  = "wormhole"
+	BridgeLayerZero = "layerzero"
+	BridgeCCTP      = "cctp"
+)
+
+// maxBridgeLegs bounds the events looked up for one bridge message; a
+// message has a leg on each chain, each with a few events at most.
+const maxBridgeLegs = 20
+
+// Rules a candidate must pass to be paired with an event as a leg of its
+// bridge message. Candidates already share its bridge and sequence.
+const (
+	RuleOtherTransaction = "other_transaction"
+	RuleSourceChain      = "source_chain"
+	RuleDestChain        = "dest_chain"
+)
+
+// CorrelationCheck is the outcome of one pairing rule for a candidate.
+type CorrelationCheck struct {
+	Rule   string `json:"rule"`
+	Passed bool   `json:"passed"`
+}
+
+// CorrelationCandidate is an event carrying the same bridge message, or
+// linked to the event by an operator, with the rules it passed or failed.
+// Confidence is set for paired candidates and Override when an operator
+// recorded a verdict on the pair.
+type CorrelationCandidate struct {
+	Event      *Event             `json:"event"`
+	Paired     bool               `json:"paired"`
+	Checks     []CorrelationCheck `json:"checks"`
+	Confidence float64            `json:"confidence,omitempty"`
+	Override   string             `json:"override,omitempty"`
+}
+
+// CorrelationState explains how an event was paired with the legs of its
+// bridge message, for GET /admin/correlation/state.
+type CorrelationState struct {
+	Transfer *Event `json:"transfer"`
+	// Reason is set when the event carries no bridge message to pair.
+	Reason     string                 `json:"reason,omitempty"`
+	Candidates []CorrelationCandidate `json:"candidates"`
+	// Legs are the event IDs BridgeLegs returns.
+	Legs []string `json:"legs"`
+	// Truncated reports that maxBridgeLegs candidates were looked up, so
+	// others may have been left out.
+	Truncated bool `json:"truncated"`
+}
+
+// bridgeLegChecks applies the pairing rules to candidate c of ev: it must
+// be another transaction with the same source chain, and the same
+// destination chain when both sides record one.
+func bridgeLegChecks(ev, c *Event) []CorrelationCheck {
+	return []CorrelationCheck{
+		{Rule: RuleOtherTransaction, Passed: c.TxHash != ev.TxHash},
+		{Rule: RuleSourceChain, Passed: c.SourceChain == ev.SourceChain},
+		{Rule: RuleDestChain, Passed: c.DestChain == "" || ev.DestChain == "" || c.DestChain == ev.DestChain},
+	}
+}
+
+func passedAll(checks []CorrelationCheck) bool {
+	for _, c := range checks {
+		if !c.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// bridgeCandidates returns the events carrying ev's bridge message, as seen
+// by tenant, or nil when ev carries none.
+func (s *EventStore) bridgeCandidates(ev *Event, tenant string) []*Event {
+	if ev.Bridge == "" || ev.Sequence == "" {
+		return nil
+	}
+	return s.GetRecent(EventFilter{
+		Bridge:   ev.Bridge,
+		Sequence: ev.Sequence,
+		Tenant:   tenant,
+		Limit:    maxBridgeLegs,
+	})
+}
+
+// BridgeLegs returns the events of the other transactions carrying ev's
+// bridge message, as seen by tenant. Legs are matched on the protocol's
+// identifiers, not on amounts and timing: same bridge, source chain and
+// sequence, and the same destination chain when both sides record one.
+// Operator overrides take precedence: rejected pairs are left out and
+// confirmed or linked events are included whatever the rules say.
+func (s *EventStore) BridgeLegs(ev *Event, tenant string) []*Event {
+	legs, _ := s.Correlations(ev, tenant)
+	return legs
+}
+
+// Correlations returns BridgeLegs with the confidence that each is a leg
+// of ev's transfer.
+func (s *EventStore) Correlations(ev *Event, tenant string) ([]*Event, []Correlation) {
+	var legs []*Event
+	var scores []Correlation
+	for _, c := range s.correlationCandidates(ev, tenant, s.bridgeCandidates(ev, tenant)) {
+		if c.Paired {
+			legs = append(legs, c.Event)
+			scores = append(scores, Correlation{LegID: c.Event.EventID, Confidence: c.Confidence, Override: c.Override})
+		}
+	}
+	return legs, scores
+}
+
+// correlationCandidates applies the pairing rules and operator overrides
+// to found, the events carrying ev's bridge message, and to the events
+// linked to ev that tenant sees.
+func (s *EventStore) correlationCandidates(ev *Event, tenant string, found []*Event) []CorrelationCandidate {
+	var out []CorrelationCandidate
+	seen := map[string]bool{ev.EventID: true}
+	add := func(c *Event) {
+		seen[c.EventID] = true
+		checks := bridgeLegChecks(ev, c)
+		cand := CorrelationCandidate{Event: c, Paired: passedAll(checks), Checks: checks}
+		if o, ok := s.correlations.Get(ev.EventID, c.EventID); ok {
+			cand.Override = o.Verdict
+			cand.Paired = o.Verdict != VerdictRejected
+		}
+		switch {
+		case !cand.Paired:
+		case cand.Override != "":
+			cand.Confidence = 1
+		default:
+			cand.Confidence = legConfidence(ev, c)
+		}
+		out = append(out, cand)
+	}
+	for _, c := range found {
+		if !seen[c.EventID] {
+			add(c)
+		}
+	}
+	for _, id := range s.correlations.Linked(ev.EventID) {
+		if c, ok := s.GetByID(id); ok && !seen[id] && tenantSees(tenant, c) {
+			add(c)
+		}
+	}
+	return out
+}
+
+// CorrelationState returns the candidates BridgeLegs considers for ev
+// across all tenants, with the rules each passed.
+func (s *EventStore) CorrelationState(ev *Event) CorrelationState {
+	state := CorrelationState{Transfer: ev, Candidates: []CorrelationCandidate{}, Legs: []string{}}
+	if (ev.Bridge == "" || ev.Sequence == "") && len(s.correlations.Linked(ev.EventID)) == 0 {
+		state.Reason = "the event carries no bridge message"
+		return state
+	}
+	found := s.bridgeCandidates(ev, "")
+	state.Truncated = len(found) >= maxBridgeLegs
+	for _, c := range s.correlationCandidates(ev, "", found) {
+		state.Candidates = append(state.Candidates, c)
+		if c.Paired {
+			state.Legs = append(state.Legs, c.Event.EventID)
+		}
+	}
+	return state
+}
+
+// getCorrelationState handles GET /admin/correlation/state?transfer_id=,
+// showing why an event was or was not paired with other bridge legs.
+func getCorrelationState(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	p := newQueryParams(r)
+	id := p.String("transfer_id")
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	if id == "" {
+		badRequest(w, invalidParam("transfer_id", "transfer_id is required"))
+		return
+	}
+	ev, ok := store.GetByID(id)
+	if !ok {
+		httpError(w, "transfer not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(store.CorrelationState(ev))
+}
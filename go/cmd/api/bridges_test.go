@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestBridgeLegs(t *testing.T) {
+	store := NewEventStore(100, 50)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	leg := func(id, chain, txHash, source, dest, sequence string) *Event {
+		ev := makeEvent(id, "alice", "bob", "1", ts, "USDC")
+		ev.Chain, ev.TxHash = chain, txHash
+		ev.Bridge, ev.SourceChain, ev.DestChain, ev.Sequence = BridgeWormhole, source, dest, sequence
+		store.Add(ev)
+		return ev
+	}
+	burn := leg("burn", "ethereum", "0x01", "ethereum", "base", "42")
+	leg("burn-fee", "ethereum", "0x01", "ethereum", "base", "42")
+	leg("mint", "base", "0x02", "ethereum", "base", "42")
+	leg("other-route", "solana", "sig", "ethereum", "solana", "42")
+	leg("other-source", "base", "0x03", "solana", "base", "42")
+	leg("other-sequence", "base", "0x04", "ethereum", "base", "43")
+
+	if legs := store.BridgeLegs(burn, ""); len(legs) != 1 || legs[0].EventID != "mint" {
+		t.Fatalf("legs = %+v, want mint", legs)
+	}
+	// A sending side that does not record the destination matches any
+	burn.DestChain = ""
+	if legs := store.BridgeLegs(burn, ""); len(legs) != 2 {
+		t.Fatalf("legs without dest_chain = %d, want 2", len(legs))
+	}
+	if legs := store.BridgeLegs(makeEvent("plain", "alice", "bob", "1", ts, ""), ""); legs != nil {
+		t.Fatalf("legs of a plain transfer = %+v", legs)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/transactions/{event_id}", func(w http.ResponseWriter, r *http.Request) {
+		getEventDetail(store, nil, w, r)
+	})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transactions/mint", nil))
+	var detail EventDetail
+	if err := json.NewDecoder(rec.Body).Decode(&detail); err != nil || len(detail.BridgeLegs) != 2 {
+		t.Fatalf("detail = %d %+v, %v; want the burn legs", rec.Code, detail.BridgeLegs, err)
+	}
+}
+
+func TestCorrelationState(t *testing.T) {
+	store := NewEventStore(100, 50)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	for _, l := range []struct{ id, chain, txHash, source, dest string }{
+		{"burn", "ethereum", "0x01", "ethereum", "base"},
+		{"mint", "base", "0x02", "ethereum", "base"},
+		{"other-route", "solana", "sig", "ethereum", "solana"},
+	} {
+		ev := makeEvent(l.id, "alice", "bob", "1", ts, "USDC")
+		ev.Chain, ev.TxHash = l.chain, l.txHash
+		ev.Bridge, ev.SourceChain, ev.DestChain, ev.Sequence = BridgeCCTP, l.source, l.dest, "7"
+		store.Add(ev)
+	}
+	store.Add(makeEvent("plain", "alice", "bob", "1", ts, ""))
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		getCorrelationState(store, rec, httptest.NewRequest(http.MethodGet, "/admin/correlation/state"+query, nil))
+		return rec
+	}
+	rec := get("?transfer_id=burn")
+	var state CorrelationState
+	if err := json.NewDecoder(rec.Body).Decode(&state); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("state = %d, %v", rec.Code, err)
+	}
+	if len(state.Legs) != 1 || state.Legs[0] != "mint" || len(state.Candidates) != 2 {
+		t.Fatalf("state = %+v, want mint paired out of two candidates", state)
+	}
+	for _, c := range state.Candidates {
+		if c.Event.EventID == "other-route" && (c.Paired || c.Checks[2].Rule != RuleDestChain || c.Checks[2].Passed) {
+			t.Fatalf("other-route = %+v, want it rejected on dest_chain", c)
+		}
+	}
+
+	if rec := get("?transfer_id=plain"); !strings.Contains(rec.Body.String(), `"reason"`) {
+		t.Fatalf("plain transfer = %s, want a reason", rec.Body.String())
+	}
+	if rec := get("?transfer_id=nope"); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown transfer = %d, want 404", rec.Code)
+	}
+	if rec := get(""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("missing transfer_id = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxBulkWallets caps the number of addresses in one bulk wallet query.
+const maxBulkWallets = 100
+
+// BulkWalletRequest is the body of POST /wallets/transactions.
+type BulkWalletRequest struct {
+	Addresses []string `json:"addresses"`
+	Chain     string   `json:"chain,omitempty"`
+	Network   string   `json:"network,omitempty"`
+	Token     string   `json:"token,omitempty"`
+	Status    string   `json:"status,omitempty"`
+	Limit     int      `json:"limit,omitempty"`
+	Offset    int      `json:"offset,omitempty"`
+}
+
+// WalletEvent is an event attributed to the requested wallets it touches.
+type WalletEvent struct {
+	*Event
+	Wallets []string `json:"wallets"`
+}
+
+// BulkWalletResponse is a merged page of events across the requested wallets.
+type BulkWalletResponse struct {
+	Events []WalletEvent `json:"events"`
+	Limit  int           `json:"limit"`
+	Offset int           `json:"offset"`
+	Total  int           `json:"total"`
+}
+
+// GetByWallets returns events touching any of the addresses, newest first,
+// with a single query when a repository is attached.
+func (s *EventStore) GetByWallets(addresses []string, filter EventFilter) []*Event {
+	return s.read(func(ctx context.Context, r EventRepository) ([]*Event, error) {
+		return r.ByWallets(ctx, addresses, filter)
+	})
+}
+
+// attributeWallets tags each event with the requested wallets it involves.
+// addresses is keyed by addressKey.
+func attributeWallets(events []*Event, addresses map[string]struct{}) []WalletEvent {
+	out := make([]WalletEvent, 0, len(events))
+	for _, ev := range events {
+		we := WalletEvent{Event: ev, Wallets: []string{}}
+		from, to := addressKey(ev.From), addressKey(ev.To)
+		if _, ok := addresses[from]; ok {
+			we.Wallets = append(we.Wallets, ev.From)
+		}
+		if _, ok := addresses[to]; ok && to != from {
+			we.Wallets = append(we.Wallets, ev.To)
+		}
+		out = append(out, we)
+	}
+	return out
+}
+
+// getBulkWalletTransactions returns one merged, paginated history for up to
+// maxBulkWallets addresses, replacing many parallel per-wallet requests.
+func getBulkWalletTransactions(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	var req BulkWalletRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	profile, err := parseProfile(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	req.Chain, req.Network, err = resolveNetwork(req.Chain, req.Network)
+	if err != nil {
+		badRequest(w, invalidParam("chain", "%v", err))
+		return
+	}
+
+	addresses := make([]string, 0, len(req.Addresses))
+	wanted := make(map[string]struct{}, len(req.Addresses))
+	for i, a := range req.Addresses {
+		if strings.TrimSpace(a) == "" {
+			continue
+		}
+		a, err := canonicalAddress(req.Chain, a)
+		if err != nil {
+			badRequest(w, invalidParam("addresses", "addresses[%d]: %v", i, err))
+			return
+		}
+		if _, dup := wanted[addressKey(a)]; dup {
+			continue
+		}
+		wanted[addressKey(a)] = struct{}{}
+		addresses = append(addresses, a)
+	}
+	if len(addresses) == 0 {
+		badRequest(w, invalidParam("addresses", "addresses is required"))
+		return
+	}
+	if len(addresses) > maxBulkWallets {
+		badRequest(w, invalidParam("addresses", "at most %d addresses per request", maxBulkWallets))
+		return
+	}
+
+	filter := EventFilter{
+		Chain:   req.Chain,
+		Network: req.Network,
+		Token:   req.Token,
+		Status:  req.Status,
+		Tenant:  tenantFrom(r.Context()),
+		Limit:   50,
+		Offset:  0,
+	}
+	switch {
+	case req.Limit < 0:
+		badRequest(w, invalidParam("limit", "limit must be at least 1"))
+		return
+	case req.Limit > maxPageSize:
+		filter.Limit = maxPageSize
+	case req.Limit > 0:
+		filter.Limit = req.Limit
+	}
+	if req.Offset < 0 {
+		badRequest(w, invalidParam("offset", "offset must be at least 0"))
+		return
+	}
+	filter.Offset = req.Offset
+
+	events := withProfile(profile, store.Enrich(store.GetByWallets(addresses, filter)))
+	total := store.Count(addresses, filter)
+	// Pages are selected in the POST body, so there are no Link URLs to offer
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(BulkWalletResponse{
+		Events: attributeWallets(events, wanted),
+		Limit:  filter.Limit,
+		Offset: filter.Offset,
+		Total:  total,
+	})
+}
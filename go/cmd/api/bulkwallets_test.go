@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBulkWalletTransactions(t *testing.T) {
+	store := NewEventStore(100, 50)
+	base := time.Date(2025, 10, 14, 10, 0, 0, 0, time.UTC)
+	at := func(m int) string { return base.Add(time.Duration(m) * time.Minute).Format(time.RFC3339) }
+	store.Add(makeEvent("1", aliceAddr, bobAddr, "1", at(1), ""))
+	store.Add(makeEvent("2", bobAddr, carolAddr, "1", at(2), ""))
+	store.Add(makeEvent("3", "dave", "erin", "1", at(3), ""))
+	store.Add(makeEvent("4", carolAddr, aliceAddr, "1", at(4), ""))
+
+	body := fmt.Sprintf(`{"addresses": [%q, %q, %q, "0x0000000000000000000000000000000000000bad"], "limit": 10}`,
+		strings.ToUpper(aliceAddr), bobAddr, aliceAddr)
+	r := httptest.NewRecorder()
+	getBulkWalletTransactions(store, r, httptest.NewRequest(http.MethodPost, "/wallets/transactions", strings.NewReader(body)))
+	if r.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", r.Code, r.Body.String())
+	}
+	var resp BulkWalletResponse
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	var ids []string
+	for _, ev := range resp.Events {
+		ids = append(ids, ev.EventID)
+	}
+	if got := strings.Join(ids, ","); got != "4,2,1" {
+		t.Fatalf("expected merged newest-first events 4,2,1 without duplicates, got %s", got)
+	}
+	if w := resp.Events[2].Wallets; len(w) != 2 || w[0] != aliceAddr || w[1] != bobAddr {
+		t.Fatalf("expected event 1 attributed to alice and bob, got %v", w)
+	}
+	if w := resp.Events[0].Wallets; len(w) != 1 || w[0] != aliceAddr {
+		t.Fatalf("expected event 4 attributed to alice, got %v", w)
+	}
+
+	// Pagination applies to the merged list
+	r = httptest.NewRecorder()
+	getBulkWalletTransactions(store, r, httptest.NewRequest(http.MethodPost, "/wallets/transactions", strings.NewReader(fmt.Sprintf(`{"addresses":[%q,%q],"limit":1,"offset":1}`, aliceAddr, bobAddr))))
+	resp = BulkWalletResponse{}
+	_ = json.NewDecoder(r.Body).Decode(&resp)
+	if len(resp.Events) != 1 || resp.Events[0].EventID != "2" {
+		t.Fatalf("expected second page to hold event 2, got %+v", resp.Events)
+	}
+}
+
+func TestBulkWalletTransactionsValidation(t *testing.T) {
+	store := NewEventStore(10, 10)
+	many := make([]string, maxBulkWallets+1)
+	for i := range many {
+		many[i] = fmt.Sprintf(`"0x%040x"`, i)
+	}
+	for _, body := range []string{
+		`not json`,
+		`{"addresses": []}`,
+		`{"addresses": ["", "  "]}`,
+		`{"addresses": ["nobody"]}`,
+		`{"addresses": [` + strings.Join(many, ",") + `]}`,
+	} {
+		r := httptest.NewRecorder()
+		getBulkWalletTransactions(store, r, httptest.NewRequest(http.MethodPost, "/wallets/transactions", strings.NewReader(body)))
+		if r.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %.40s, got %d", body, r.Code)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// errNotChainAddress is returned by ChainAdapter.Validate for strings that
+// are not an address on the chain at all.
+var errNotChainAddress = errors.New("not an address on the chain")
+
+// RPCCaller makes one JSON-RPC request to a node of the adapter's chain and
+// returns its result, or nil for a null result.
+type RPCCaller func(ctx context.Context, method string, params ...interface{}) (json.RawMessage, error)
+
+// ChainAdapter is everything the API knows about one chain. Supporting a
+// new chain means writing an adapter in its own file and registering it in
+// an init function; the handlers, repositories and ingest only go through
+// chainAdapter.
+type ChainAdapter interface {
+	// Chain is the name the chain's events carry, e.g. "ethereum".
+	Chain() string
+	// Normalize rewrites an ingested event's addresses and transaction
+	// hash into their canonical form, failing when an address is not one
+	// of the chain's.
+	Normalize(ev *Event) error
+	// Validate returns the canonical form of an address given in a
+	// request. Strings that are not an address on the chain at all fail
+	// with errNotChainAddress.
+	Validate(address string) (string, error)
+	// TxHash returns the canonical form of a transaction hash; ok is false
+	// when hash is not one.
+	TxHash(hash string) (canonical string, ok bool)
+	// Subscribe returns the Redis channel the listener publishes the
+	// events of one of the chain's networks on.
+	Subscribe(network string) string
+	// Backfill fetches a transaction from a node through call. It returns
+	// nil when the node does not know the transaction, and complete is
+	// false while the transaction may still change, so it is not cached.
+	Backfill(ctx context.Context, call RPCCaller, hash string) (raw json.RawMessage, complete bool, err error)
+	// History fetches the past transfers of address through call, newest
+	// first, within bound, for importing wallets the tracker has never
+	// seen. The events carry no network; the caller sets it.
+	History(ctx context.Context, call RPCCaller, address string, bound HistoryBound) ([]*Event, error)
+	// HeadMethod is the JSON-RPC method returning the latest block height
+	// or slot, used to probe providers.
+	HeadMethod() string
+	// NativeDecimals are the decimals of the chain's native currency; ok
+	// is false when they are unknown.
+	NativeDecimals() (decimals int, ok bool)
+	// NativeSymbol is the symbol of the chain's native currency, e.g. ETH,
+	// or "" when unknown.
+	NativeSymbol() string
+	// VerifyMessage checks that signature, encoded as the chain's wallets
+	// return it, is the signature of message by address's key, failing
+	// with errBadSignature when it is not.
+	VerifyMessage(address, message, signature string) error
+}
+
+var (
+	chainAdaptersMu sync.RWMutex
+	chainAdapters   = make(map[string]ChainAdapter)
+)
+
+// RegisterChainAdapter makes a the adapter of a.Chain(). Registering a
+// chain twice is a programming error and panics.
+func RegisterChainAdapter(a ChainAdapter) {
+	chain := strings.ToLower(a.Chain())
+	chainAdaptersMu.Lock()
+	defer chainAdaptersMu.Unlock()
+	if _, dup := chainAdapters[chain]; dup {
+		panic("chain adapter registered twice for " + chain)
+	}
+	chainAdapters[chain] = a
+}
+
+// chainAdapter returns the adapter registered for chain, or one of its
+// aliases. Chains without one are treated as EVM chains whose native
+// decimals are unknown, as the listener publishes few others.
+func chainAdapter(chain string) ChainAdapter {
+	chain, _ = canonicalNetwork(chain, "")
+	chainAdaptersMu.RLock()
+	a, ok := chainAdapters[chain]
+	chainAdaptersMu.RUnlock()
+	if ok {
+		return a
+	}
+	return evmAdapter{chain: chain}
+}
+
+// normalizeEvent canonicalizes ev's addresses with a.Validate and its
+// transaction hash with a.TxHash. Adapters without chain-specific event
+// fields implement Normalize with it. Empty addresses, such as the
+// recipient of a contract creation, are left empty.
+func normalizeEvent(a ChainAdapter, ev *Event) error {
+	for _, p := range []*string{&ev.From, &ev.To} {
+		if *p == "" {
+			continue
+		}
+		canonical, err := a.Validate(strings.TrimSpace(*p))
+		if errors.Is(err, errNotChainAddress) {
+			err = fmt.Errorf("not a valid %s address", a.Chain())
+		}
+		if err != nil {
+			return fmt.Errorf("address %q: %w", *p, err)
+		}
+		*p = canonical
+	}
+	ev.TxHash = strings.TrimSpace(ev.TxHash)
+	if hash, ok := a.TxHash(ev.TxHash); ok {
+		ev.TxHash = hash
+	}
+	return nil
+}
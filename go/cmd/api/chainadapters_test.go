@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// upperAdapter is a made-up chain whose addresses are "U-" and upper-case
+// letters, to check a new chain only needs an adapter.
+type upperAdapter struct{ evmAdapter }
+
+func (upperAdapter) Chain() string { return "upperchain" }
+
+func (a upperAdapter) Normalize(ev *Event) error { return normalizeEvent(a, ev) }
+
+func (upperAdapter) Validate(address string) (string, error) {
+	if !strings.HasPrefix(strings.ToUpper(address), "U-") {
+		return "", errNotChainAddress
+	}
+	return strings.ToUpper(address), nil
+}
+
+func TestChainAdapterRegistry(t *testing.T) {
+	for _, tc := range []struct {
+		chain    string
+		decimals int
+		known    bool
+		head     string
+	}{
+		{"ethereum", 18, true, "eth_blockNumber"},
+		{"Base", 18, true, "eth_blockNumber"},
+		{"solana", 9, true, "getSlot"},
+		{"trx", 6, true, "eth_blockNumber"},
+		{"fantom", 0, false, "eth_blockNumber"},
+	} {
+		a := chainAdapter(tc.chain)
+		if d, ok := a.NativeDecimals(); d != tc.decimals || ok != tc.known || a.HeadMethod() != tc.head {
+			t.Errorf("%s: decimals %d %v, head %s", tc.chain, d, ok, a.HeadMethod())
+		}
+	}
+	if got := chainAdapter("solana").Subscribe("devnet"); got != eventsChannel("solana", "devnet") {
+		t.Errorf("solana channel = %s", got)
+	}
+
+	RegisterChainAdapter(upperAdapter{})
+	defer func() {
+		chainAdaptersMu.Lock()
+		delete(chainAdapters, "upperchain")
+		chainAdaptersMu.Unlock()
+	}()
+	if got, err := canonicalAddress("upperchain", "u-abc"); err != nil || got != "U-ABC" {
+		t.Fatalf("upperchain address = %q, %v", got, err)
+	}
+	if _, err := canonicalAddress("upperchain", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"); err == nil || err.Error() != "not a valid upperchain address" {
+		t.Fatalf("EVM address on upperchain = %v", err)
+	}
+	ev := &Event{Chain: "upperchain", From: "u-a", To: "bad"}
+	if err := chainAdapter(ev.Chain).Normalize(ev); err == nil {
+		t.Fatal("invalid recipient accepted")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering a chain twice did not panic")
+		}
+	}()
+	RegisterChainAdapter(upperAdapter{})
+}
+
+func TestChainAdapterBackfill(t *testing.T) {
+	var methods []string
+	call := func(ctx context.Context, method string, params ...interface{}) (json.RawMessage, error) {
+		methods = append(methods, method)
+		if method == "eth_getTransactionReceipt" {
+			return nil, nil
+		}
+		return json.RawMessage(`{"hash":"0x01"}`), nil
+	}
+	raw, complete, err := chainAdapter("ethereum").Backfill(context.Background(), call, "0x01")
+	if err != nil || complete || !strings.Contains(string(raw), `"receipt":null`) || len(methods) != 2 {
+		t.Fatalf("pending EVM transaction = %s, %v, %v after %v", raw, complete, err, methods)
+	}
+
+	failing := func(ctx context.Context, method string, params ...interface{}) (json.RawMessage, error) {
+		return nil, errors.New("down")
+	}
+	if raw, _, err := chainAdapter("solana").Backfill(context.Background(), failing, "sig"); err == nil || raw != nil {
+		t.Fatalf("failing node = %s, %v", raw, err)
+	}
+}
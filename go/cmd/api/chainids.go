@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// chainAliases maps names producers and clients use for a chain to the
+// name events are stored under.
+var chainAliases = map[string]string{
+	"eth":     "ethereum",
+	"sol":     "solana",
+	"matic":   "polygon",
+	"arb":     "arbitrum",
+	"op":      "optimism",
+	"bnb":     "bsc",
+	"binance": "bsc",
+	"avax":    "avalanche",
+	"trx":     "tron",
+}
+
+// networkAliases does the same for networks, e.g. Solana's cluster name.
+var networkAliases = map[string]string{
+	"main":         "mainnet",
+	"mainnet-beta": "mainnet",
+}
+
+// defaultChainIDs are the CAIP-2 identifiers (namespace:reference) of the
+// chain/network pairs the listener publishes. EVM chains are identified by
+// their EIP-155 chain id. Solana's are named after the network, since the
+// genesis hash references CAIP-2 defines for it are accepted as aliases.
+// Tron's carry the chain id its JSON-RPC API returns for eth_chainId.
+var defaultChainIDs = map[string]string{
+	"ethereum:mainnet":  "eip155:1",
+	"ethereum:sepolia":  "eip155:11155111",
+	"ethereum:holesky":  "eip155:17000",
+	"polygon:mainnet":   "eip155:137",
+	"polygon:amoy":      "eip155:80002",
+	"arbitrum:mainnet":  "eip155:42161",
+	"arbitrum:sepolia":  "eip155:421614",
+	"optimism:mainnet":  "eip155:10",
+	"optimism:sepolia":  "eip155:11155420",
+	"base:mainnet":      "eip155:8453",
+	"base:sepolia":      "eip155:84532",
+	"bsc:mainnet":       "eip155:56",
+	"avalanche:mainnet": "eip155:43114",
+	"solana:mainnet":    "solana:mainnet",
+	"solana:devnet":     "solana:devnet",
+	"solana:testnet":    "solana:testnet",
+	"tron:mainnet":      "tron:0x2b6653dc",
+	"tron:shasta":       "tron:0x94a9059e",
+	"tron:nile":         "tron:0xcd8690dc",
+}
+
+// solanaGenesisIDs are the CAIP-2 identifiers of the Solana networks.
+var solanaGenesisIDs = map[string]string{
+	"solana:5eykt4usfv8p8njdtrepy1vzqkqzkvdp": "solana:mainnet",
+	"solana:etwtrabzayq6imfeykouru166vu2xqa1": "solana:devnet",
+	"solana:4uhcvjyu9pjkvqys88urdiswhxsck3z":  "solana:testnet",
+}
+
+var (
+	chainIDsMu sync.RWMutex
+	// chainIDs maps chain:network to its CAIP-2 identifier, and pairIDs
+	// the identifier, lowercased, back to chain:network.
+	chainIDs, pairIDs = indexChainIDs(defaultChainIDs)
+)
+
+func indexChainIDs(ids map[string]string) (map[string]string, map[string]string) {
+	byPair := make(map[string]string, len(ids))
+	byID := make(map[string]string, len(ids)+len(solanaGenesisIDs))
+	for id, pair := range solanaGenesisIDs {
+		byID[id] = pair
+	}
+	for pair, id := range ids {
+		byPair[pair] = id
+		byID[strings.ToLower(id)] = pair
+	}
+	return byPair, byID
+}
+
+// ParseChainIDs parses CHAIN_IDS, a comma-separated list of
+// chain:network=namespace:reference entries naming the CAIP-2 identifier
+// of pairs the defaults do not cover, or overriding theirs, e.g.
+// "zksync:mainnet=eip155:324".
+func ParseChainIDs(spec string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pair, id, ok := strings.Cut(item, "=")
+		chain, network, pairOK := strings.Cut(strings.TrimSpace(pair), ":")
+		namespace, reference, idOK := strings.Cut(strings.TrimSpace(id), ":")
+		if !ok || !pairOK || !idOK || chain == "" || network == "" || namespace == "" || reference == "" {
+			return nil, fmt.Errorf("invalid chain id %q: want chain:network=namespace:reference", item)
+		}
+		chain, network = canonicalNetwork(chain, network)
+		out[chain+":"+network] = strings.TrimSpace(id)
+	}
+	return out, nil
+}
+
+// SetChainIDs adds ids, as parsed by ParseChainIDs, to the default chain
+// identifiers.
+func SetChainIDs(ids map[string]string) {
+	merged := make(map[string]string, len(defaultChainIDs)+len(ids))
+	for pair, id := range defaultChainIDs {
+		merged[pair] = id
+	}
+	for pair, id := range ids {
+		merged[pair] = id
+	}
+	byPair, byID := indexChainIDs(merged)
+	chainIDsMu.Lock()
+	chainIDs, pairIDs = byPair, byID
+	chainIDsMu.Unlock()
+}
+
+// canonicalNetwork lowercases a chain/network pair and resolves aliases,
+// so "ETH"/"mainnet" and "sol"/"mainnet-beta" name the same networks as
+// "ethereum"/"mainnet" and "solana"/"mainnet".
+func canonicalNetwork(chain, network string) (string, string) {
+	chain, network = strings.ToLower(strings.TrimSpace(chain)), strings.ToLower(strings.TrimSpace(network))
+	if c, ok := chainAliases[chain]; ok {
+		chain = c
+	}
+	if n, ok := networkAliases[network]; ok {
+		network = n
+	}
+	return chain, network
+}
+
+// ChainID returns the CAIP-2 identifier of a canonical chain/network pair,
+// or "" when it is unknown.
+func ChainID(chain, network string) string {
+	chainIDsMu.RLock()
+	defer chainIDsMu.RUnlock()
+	return chainIDs[chain+":"+network]
+}
+
+// pairOfChainID returns the chain/network pair a CAIP-2 identifier names.
+func pairOfChainID(id string) (chain, network string, ok bool) {
+	chainIDsMu.RLock()
+	pair, ok := pairIDs[strings.ToLower(strings.TrimSpace(id))]
+	chainIDsMu.RUnlock()
+	if !ok {
+		return "", "", false
+	}
+	chain, network, _ = strings.Cut(pair, ":")
+	return chain, network, true
+}
+
+// parseNetworkPair reads a chain:network pair from the configuration. It
+// may use aliases or be a CAIP-2 identifier; ok is false when it is
+// neither.
+func parseNetworkPair(s string) (chain, network string, ok bool) {
+	if chain, network, ok := pairOfChainID(s); ok {
+		return chain, network, true
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return "", "", false
+	}
+	chain, network = canonicalNetwork(parts[0], parts[1])
+	return chain, network, true
+}
+
+// canonicalizeEvent rewrites ev's chain and network into their canonical
+// names and sets its chain id.
+func canonicalizeEvent(ev *Event) {
+	ev.Chain, ev.Network = canonicalNetwork(ev.Chain, ev.Network)
+	ev.ChainID = ChainID(ev.Chain, ev.Network)
+}
+
+// resolveNetwork reads the chain and network of a filter. chain may be a
+// name, an alias or a CAIP-2 identifier, which sets the network too; an
+// identifier that is not known, or names another network than network,
+// fails.
+func resolveNetwork(chain, network string) (string, string, error) {
+	if strings.Contains(chain, ":") {
+		c, n, ok := pairOfChainID(chain)
+		if !ok {
+			return "", "", fmt.Errorf("unknown chain id %q", chain)
+		}
+		if network != "" {
+			if _, want := canonicalNetwork("", network); want != n {
+				return "", "", fmt.Errorf("chain id %q is %s:%s, not network %s", chain, c, n, network)
+			}
+		}
+		return c, n, nil
+	}
+	c, n := canonicalNetwork(chain, network)
+	return c, n, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCanonicalizeEvent(t *testing.T) {
+	for _, tc := range []struct {
+		chain, network             string
+		wantChain, wantNet, wantID string
+	}{
+		{"ETH", "Mainnet", "ethereum", "mainnet", "eip155:1"},
+		{"ethereum", "sepolia", "ethereum", "sepolia", "eip155:11155111"},
+		{"sol", "mainnet-beta", "solana", "mainnet", "solana:mainnet"},
+		{"zksync", "mainnet", "zksync", "mainnet", ""},
+	} {
+		ev := &Event{Chain: tc.chain, Network: tc.network}
+		canonicalizeEvent(ev)
+		if ev.Chain != tc.wantChain || ev.Network != tc.wantNet || ev.ChainID != tc.wantID {
+			t.Errorf("%s:%s = %s:%s (%q)", tc.chain, tc.network, ev.Chain, ev.Network, ev.ChainID)
+		}
+	}
+
+	ids, err := ParseChainIDs(" zkSync:Mainnet=eip155:324 ")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	SetChainIDs(ids)
+	t.Cleanup(func() { SetChainIDs(nil) })
+	if got := ChainID("zksync", "mainnet"); got != "eip155:324" {
+		t.Fatalf("configured chain id = %q", got)
+	}
+	if got := ChainID("ethereum", "mainnet"); got != "eip155:1" {
+		t.Fatalf("default chain id = %q after configuring others", got)
+	}
+	for _, bad := range []string{"zksync:mainnet", "zksync=eip155:324", "zksync:mainnet=324"} {
+		if _, err := ParseChainIDs(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestResolveNetwork(t *testing.T) {
+	for _, tc := range []struct {
+		chain, network     string
+		wantChain, wantNet string
+	}{
+		{"eip155:1", "", "ethereum", "mainnet"},
+		{"EIP155:8453", "main", "base", "mainnet"},
+		{"solana:5eykt4UsFv8P8NJdTREpY1vzqKqZKvdp", "", "solana", "mainnet"},
+		{"solana:devnet", "", "solana", "devnet"},
+		{"matic", "", "polygon", ""},
+		{"", "", "", ""},
+	} {
+		chain, network, err := resolveNetwork(tc.chain, tc.network)
+		if err != nil || chain != tc.wantChain || network != tc.wantNet {
+			t.Errorf("resolveNetwork(%q, %q) = %q, %q, %v", tc.chain, tc.network, chain, network, err)
+		}
+	}
+	if _, _, err := resolveNetwork("eip155:1", "sepolia"); err == nil {
+		t.Error("expected a chain id contradicting network to be rejected")
+	}
+	if _, _, err := resolveNetwork("eip155:999999", ""); err == nil {
+		t.Error("expected an unknown chain id to be rejected")
+	}
+
+	a, err := ParseNetworkAllowlist("eip155:1,sol:mainnet-beta")
+	if err != nil {
+		t.Fatalf("parse allowlist: %v", err)
+	}
+	if !a.Allows("eth", "mainnet") || !a.Allows("solana", "mainnet") || a.Allows("ethereum", "sepolia") {
+		t.Fatal("expected the allowlist to hold the canonical pairs")
+	}
+}
+
+func TestIngestResolvesAliasesAndFiltersByChainID(t *testing.T) {
+	store := NewEventStore(100, 50)
+	allowAll, _ := ParseNetworkAllowlist("")
+	handle := ingestEvents(store, NewHub(), allowAll, nil, nil, nil, nil, nil, nil, nil)
+	for _, payload := range []string{
+		`{"event_id":"1","chain":"eth","network":"mainnet","from":"` + aliceAddr + `","to":"` + bobAddr + `","value":"5"}`,
+		`{"event_id":"2","chain":"ethereum","network":"sepolia","from":"` + aliceAddr + `","to":"` + bobAddr + `","value":"5"}`,
+	} {
+		if err := handle(context.Background(), []byte(payload)); err != nil {
+			t.Fatalf("handle: %v", err)
+		}
+	}
+
+	r := httptest.NewRecorder()
+	getTransactions(store, r, httptest.NewRequest(http.MethodGet, "/transactions?chain=eip155:1", nil))
+	var events []*Event
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(events) != 1 || events[0].Chain != "ethereum" || events[0].ChainID != "eip155:1" {
+		t.Fatalf("expected the mainnet event under its canonical name, got %+v", events)
+	}
+
+	r = httptest.NewRecorder()
+	getTransactions(store, r, httptest.NewRequest(http.MethodGet, "/transactions?chain=eip155:999999", nil))
+	if r.Code != http.StatusBadRequest {
+		t.Fatalf("unknown chain id: status = %d, want 400", r.Code)
+	}
+}
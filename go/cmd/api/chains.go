@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+// chainNamePattern matches valid chain and network names.
+var chainNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// chainsSchema creates the chains table. Tables from before multiple
+// providers per network get their single rpc_url moved into rpc_urls.
+const chainsSchema = `
+	CREATE TABLE IF NOT EXISTS chains (
+		chain TEXT NOT NULL,
+		network TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL,
+		rpc_urls TEXT[] NOT NULL DEFAULT '{}',
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (chain, network)
+	);
+	ALTER TABLE chains ADD COLUMN IF NOT EXISTS rpc_urls TEXT[] NOT NULL DEFAULT '{}';
+	DO $$ BEGIN
+		IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'chains' AND column_name = 'rpc_url') THEN
+			UPDATE chains SET rpc_urls = ARRAY[rpc_url] WHERE rpc_url <> '';
+			ALTER TABLE chains DROP COLUMN rpc_url;
+		END IF;
+	END $$;
+`
+
+// ChainConfig is the runtime configuration of a chain/network pair.
+type ChainConfig struct {
+	Chain   string `json:"chain"`
+	Network string `json:"network"`
+	Enabled bool   `json:"enabled"`
+	// RPCURLs are the JSON-RPC providers used for raw transactions, in
+	// configured order; RPCManager picks among them. Provider URLs often
+	// embed an API key, so only admin responses include them.
+	RPCURLs       []string `json:"rpc_urls,omitempty"`
+	RPCConfigured bool     `json:"rpc_configured"`
+	UpdatedAt     string   `json:"updated_at,omitempty"`
+}
+
+// ChainUpdate is the body of PUT /admin/chains/{chain}/{network}. Omitted
+// fields keep their current value; an empty rpc_urls removes the providers.
+type ChainUpdate struct {
+	Enabled *bool     `json:"enabled,omitempty"`
+	RPCURLs *[]string `json:"rpc_urls,omitempty"`
+}
+
+// ChainRegistry holds the chain/network pairs the tracker ingests and their
+// RPC endpoints. It starts from NETWORKS and RPC_URLS and can be changed at
+// runtime; with a database attached, changes are persisted to the chains
+// table and survive restarts.
+//
+// When NETWORKS is set only enabled pairs are ingested. Otherwise every
+// pair is, except those disabled.
+type ChainRegistry struct {
+	mu         sync.RWMutex
+	chains     map[string]ChainConfig // chain:network -> config
+	restricted bool
+	changed    chan struct{}
+	db         *pgxpool.Pool
+}
+
+// NewChainRegistry seeds a registry with the pairs of networks and the
+// providers of rpcURLs (as parsed by ParseRPCURLs). Either may be nil.
+func NewChainRegistry(networks *NetworkAllowlist, rpcURLs map[string][]string) *ChainRegistry {
+	r := &ChainRegistry{
+		chains:     make(map[string]ChainConfig),
+		restricted: !networks.Empty(),
+		changed:    make(chan struct{}),
+	}
+	if networks != nil {
+		for pair := range networks.pairs {
+			chain, network, _ := strings.Cut(pair, ":")
+			r.chains[pair] = ChainConfig{Chain: chain, Network: network, Enabled: true}
+		}
+	}
+	for pair, urls := range rpcURLs {
+		c, ok := r.chains[pair]
+		if !ok {
+			c.Chain, c.Network, _ = strings.Cut(pair, ":")
+			c.Enabled = !r.restricted
+		}
+		c.RPCURLs, c.RPCConfigured = urls, len(urls) > 0
+		r.chains[pair] = c
+	}
+	return r
+}
+
+// AttachDB connects the registry to Postgres and loads the stored pairs,
+// which take precedence over the environment.
+func (r *ChainRegistry) AttachDB(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx, `SELECT chain, network, enabled, rpc_urls, updated_at FROM chains`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var loaded []ChainConfig
+	for rows.Next() {
+		var c ChainConfig
+		var updated time.Time
+		if err := rows.Scan(&c.Chain, &c.Network, &c.Enabled, &c.RPCURLs, &updated); err != nil {
+			return err
+		}
+		c.RPCConfigured = len(c.RPCURLs) > 0
+		c.UpdatedAt = updated.UTC().Format(time.RFC3339)
+		loaded = append(loaded, c)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	for _, c := range loaded {
+		r.chains[c.Chain+":"+c.Network] = c
+	}
+	r.db = db
+	r.notifyLocked()
+	r.mu.Unlock()
+	return nil
+}
+
+// Allows reports whether events for the chain/network pair are ingested.
+func (r *ChainRegistry) Allows(chain, network string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	chain, network = canonicalNetwork(chain, network)
+	if c, ok := r.chains[chain+":"+network]; ok {
+		return c.Enabled
+	}
+	return !r.restricted
+}
+
+// Empty reports whether pairs the registry does not know are ingested.
+func (r *ChainRegistry) Empty() bool {
+	return !r.restricted
+}
+
+// Channels returns the Redis channels of the enabled pairs, sorted, or nil
+// when the registry is not restricted to them.
+func (r *ChainRegistry) Channels() []string {
+	if !r.restricted {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out []string
+	for _, c := range r.chains {
+		if c.Enabled {
+			out = append(out, chainAdapter(c.Chain).Subscribe(c.Network))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Changed returns a channel that is closed at the next change.
+func (r *ChainRegistry) Changed() <-chan struct{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.changed
+}
+
+// notifyLocked wakes up Changed waiters. Callers hold the write lock.
+func (r *ChainRegistry) notifyLocked() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// RPCURLs returns the RPC providers of the pair in configured order.
+// Disabled pairs keep theirs, so the events already stored can still be
+// inspected.
+func (r *ChainRegistry) RPCURLs(chain, network string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	chain, network = canonicalNetwork(chain, network)
+	c := r.chains[chain+":"+network]
+	return append([]string(nil), c.RPCURLs...)
+}
+
+// List returns every known pair sorted by chain and network.
+func (r *ChainRegistry) List() []ChainConfig {
+	r.mu.RLock()
+	out := make([]ChainConfig, 0, len(r.chains))
+	for _, c := range r.chains {
+		out = append(out, c)
+	}
+	r.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Chain != out[j].Chain {
+			return out[i].Chain < out[j].Chain
+		}
+		return out[i].Network < out[j].Network
+	})
+	return out
+}
+
+// Update applies u to the pair, adding it when unknown. A new pair starts
+// out ingested exactly when it already was. Invalid input is reported as a
+// *FieldError.
+func (r *ChainRegistry) Update(ctx context.Context, chain, network string, u ChainUpdate) (ChainConfig, error) {
+	chain, network = canonicalNetwork(chain, network)
+	if !chainNamePattern.MatchString(chain) {
+		return ChainConfig{}, invalidParam("chain", "chain must be lowercase letters, digits, '-' or '_'")
+	}
+	if !chainNamePattern.MatchString(network) {
+		return ChainConfig{}, invalidParam("network", "network must be lowercase letters, digits, '-' or '_'")
+	}
+	if u.RPCURLs != nil {
+		for _, url := range *u.RPCURLs {
+			if !validRPCURL(url) {
+				return ChainConfig{}, invalidParam("rpc_urls", "rpc_urls must be http(s) URLs")
+			}
+		}
+	}
+
+	// The write lock is held across the database write so concurrent
+	// updates of a pair cannot interleave
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := chain + ":" + network
+	c, ok := r.chains[key]
+	if !ok {
+		c = ChainConfig{Chain: chain, Network: network, Enabled: !r.restricted}
+	}
+	if u.Enabled != nil {
+		c.Enabled = *u.Enabled
+	}
+	if u.RPCURLs != nil {
+		c.RPCURLs = append([]string(nil), *u.RPCURLs...)
+	}
+	c.RPCConfigured = len(c.RPCURLs) > 0
+	c.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if r.db != nil {
+		_, err := r.db.Exec(ctx, `
+			INSERT INTO chains (chain, network, enabled, rpc_urls, updated_at) VALUES ($1, $2, $3, $4, NOW())
+			ON CONFLICT (chain, network) DO UPDATE SET enabled = EXCLUDED.enabled, rpc_urls = EXCLUDED.rpc_urls, updated_at = NOW()
+		`, c.Chain, c.Network, c.Enabled, append([]string{}, c.RPCURLs...))
+		if err != nil {
+			return c, err
+		}
+	}
+	r.chains[key] = c
+	r.notifyLocked()
+	return c, nil
+}
+
+// listChains lists the known chain/network pairs without their RPC URLs.
+func listChains(chains *ChainRegistry, w http.ResponseWriter, r *http.Request) {
+	list := chains.List()
+	for i := range list {
+		list[i].RPCURLs = nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+// listChainConfigs lists the known pairs including their RPC URLs.
+func listChainConfigs(chains *ChainRegistry, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(chains.List())
+}
+
+// updateChain enables or disables a pair or changes its RPC endpoint.
+func updateChain(chains *ChainRegistry, w http.ResponseWriter, r *http.Request) {
+	var u ChainUpdate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&u); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	c, err := chains.Update(r.Context(), chi.URLParam(r, "chain"), chi.URLParam(r, "network"), u)
+	var fe *FieldError
+	switch {
+	case errors.As(err, &fe):
+		badRequest(w, err)
+		return
+	case err != nil:
+		log.WithError(err).Error("failed to store chain")
+		httpError(w, "could not store chain", http.StatusInternalServerError)
+		return
+	}
+	log.WithFields(log.Fields{"chain": c.Chain, "network": c.Network, "enabled": c.Enabled, "rpc_configured": c.RPCConfigured}).
+		Info("admin: chain updated")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(c)
+}
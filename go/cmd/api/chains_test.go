@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestChainRegistryRestricted(t *testing.T) {
+	ctx := context.Background()
+	networks, _ := ParseNetworkAllowlist("ethereum:mainnet")
+	r := NewChainRegistry(networks, map[string][]string{"solana:devnet": {"https://sol.example"}})
+
+	if !r.Allows("Ethereum", "MAINNET") || r.Allows("solana", "devnet") || r.Allows("ethereum", "sepolia") {
+		t.Fatal("a restricted registry ingests only the enabled pairs")
+	}
+	if urls := r.RPCURLs("solana", "devnet"); len(urls) != 1 || urls[0] != "https://sol.example" {
+		t.Fatalf("rpc urls = %v; disabled pairs keep their providers", urls)
+	}
+	if got := r.Channels(); len(got) != 1 || got[0] != eventsChannel("ethereum", "mainnet") {
+		t.Fatalf("channels = %v", got)
+	}
+
+	changed := r.Changed()
+	enabled := true
+	c, err := r.Update(ctx, "Solana", "devnet", ChainUpdate{Enabled: &enabled})
+	if err != nil || !c.Enabled || len(c.RPCURLs) != 1 {
+		t.Fatalf("update = %+v, %v", c, err)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Fatal("update did not signal a change")
+	}
+	if !r.Allows("solana", "devnet") || len(r.Channels()) != 2 {
+		t.Fatalf("after enabling: channels = %v", r.Channels())
+	}
+
+	// A new pair is added disabled unless enabled explicitly
+	none := []string{}
+	if c, err := r.Update(ctx, "ethereum", "sepolia", ChainUpdate{RPCURLs: &none}); err != nil || c.Enabled || c.RPCConfigured {
+		t.Fatalf("new pair = %+v, %v", c, err)
+	}
+	if got := r.List(); len(got) != 3 || got[0].Network != "mainnet" || got[2].Chain != "solana" {
+		t.Fatalf("list = %+v", got)
+	}
+}
+
+func TestChainRegistryUnrestricted(t *testing.T) {
+	r := NewChainRegistry(nil, nil)
+	if !r.Allows("base", "mainnet") || !r.Empty() || r.Channels() != nil {
+		t.Fatal("an unrestricted registry ingests every pair")
+	}
+	disabled := false
+	if _, err := r.Update(context.Background(), "base", "mainnet", ChainUpdate{Enabled: &disabled}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if r.Allows("base", "mainnet") || !r.Allows("base", "sepolia") {
+		t.Fatal("only the disabled pair is dropped")
+	}
+
+	bad := []string{"https://rpc.example", "ftp://rpc.example"}
+	for _, tc := range []struct {
+		chain, network string
+		u              ChainUpdate
+		field          string
+	}{
+		{"", "mainnet", ChainUpdate{}, "chain"},
+		{"base", "main net", ChainUpdate{}, "network"},
+		{"base", "mainnet", ChainUpdate{RPCURLs: &bad}, "rpc_urls"},
+	} {
+		var fe *FieldError
+		if _, err := r.Update(context.Background(), tc.chain, tc.network, tc.u); !errors.As(err, &fe) || fe.Field != tc.field {
+			t.Errorf("%s:%s: err = %v, want invalid %s", tc.chain, tc.network, err, tc.field)
+		}
+	}
+}
+
+func TestChainEndpoints(t *testing.T) {
+	chains := NewChainRegistry(nil, map[string][]string{"ethereum:mainnet": {"https://eth.example/v2/KEY"}})
+	router := chi.NewRouter()
+	router.Get("/chains", func(w http.ResponseWriter, r *http.Request) {
+		listChains(chains, w, r)
+	})
+	mountAdmin(router, "s3cret", NewEventStore(100, 50), chains, nil, nil)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodGet, "/chains", "")
+	if strings.Contains(rec.Body.String(), "KEY") {
+		t.Fatalf("public chain list leaks the RPC URL: %s", rec.Body)
+	}
+	var list []ChainConfig
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list) != 1 || !list[0].RPCConfigured || !list[0].Enabled {
+		t.Fatalf("chains = %+v, %v", list, err)
+	}
+
+	rec = do(http.MethodPut, "/admin/chains/ethereum/mainnet", `{"enabled": false, "rpc_urls": ["https://eth.other"]}`)
+	var c ChainConfig
+	if err := json.NewDecoder(rec.Body).Decode(&c); err != nil || rec.Code != http.StatusOK || c.Enabled || len(c.RPCURLs) != 1 || c.RPCURLs[0] != "https://eth.other" {
+		t.Fatalf("update = %d %+v, %v", rec.Code, c, err)
+	}
+	if rec := do(http.MethodGet, "/admin/chains", ""); !strings.Contains(rec.Body.String(), "https://eth.other") {
+		t.Fatalf("admin chain list = %s", rec.Body)
+	}
+	if rec := do(http.MethodPut, "/admin/chains/ethereum/mainnet", `{"rpc_urls": ["wss://eth"]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid rpc url = %d, want 400", rec.Code)
+	}
+}
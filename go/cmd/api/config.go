@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigPollInterval is how often CONFIG_FILE is checked for changes,
+// overridable with CONFIG_POLL_INTERVAL.
+const defaultConfigPollInterval = 10 * time.Second
+
+// reloadableSettings are the settings a config reload applies without a
+// restart. The rest are read once at startup.
+var reloadableSettings = map[string]bool{
+	"TENANT_WALLETS":   true,
+	"SEQUENCE_WALLETS": true,
+	"RATE_LIMIT_RPS":   true,
+	"RATE_LIMIT_BURST": true,
+}
+
+// FileConfig is the YAML document of CONFIG_FILE. Every setting mirrors an
+// environment variable, which wins when both are set.
+type FileConfig struct {
+	RedisURL    string `yaml:"redis_url"`
+	EventSource string `yaml:"event_source"`
+	Kafka       struct {
+		RESTURL string   `yaml:"rest_url"`
+		Topics  []string `yaml:"topics"`
+		Group   string   `yaml:"group"`
+	} `yaml:"kafka"`
+	Storage struct {
+		Backend     string `yaml:"backend"`
+		PostgresDSN string `yaml:"postgres_dsn"`
+		SQLitePath  string `yaml:"sqlite_path"`
+	} `yaml:"storage"`
+	// Networks lists chain:network pairs, RPCURLs their providers and
+	// ChainIDs the CAIP-2 identifiers of pairs without a default one.
+	Networks []string            `yaml:"networks"`
+	RPCURLs  map[string][]string `yaml:"rpc_urls"`
+	ChainIDs map[string]string   `yaml:"chain_ids"`
+	Cache    struct {
+		MaxEvents          int    `yaml:"max_events"`
+		MaxEventsPerWallet int    `yaml:"max_events_per_wallet"`
+		ResponseTTL        string `yaml:"response_ttl"`
+		EventTTL           string `yaml:"event_ttl"`
+		MaxMemoryMB        int    `yaml:"max_memory_mb"`
+	} `yaml:"cache"`
+	RateLimit struct {
+		RequestsPerSecond float64 `yaml:"requests_per_second"`
+		Burst             int     `yaml:"burst"`
+	} `yaml:"rate_limit"`
+	// TenantWallets maps tenants to the wallets they watch.
+	TenantWallets   map[string][]string `yaml:"tenant_wallets"`
+	SequenceWallets []SequenceWallet    `yaml:"sequence_wallets"`
+}
+
+// ParseFileConfig parses a CONFIG_FILE document. Unknown keys are errors, so
+// a misspelled setting is not silently ignored.
+func ParseFileConfig(data []byte) (*FileConfig, error) {
+	var cfg FileConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	// An empty document decodes to io.EOF
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Env returns the settings of c as the environment variables they mirror,
+// leaving out those c does not set.
+func (c *FileConfig) Env() map[string]string {
+	env := make(map[string]string)
+	set := func(key, value string) {
+		if value != "" {
+			env[key] = value
+		}
+	}
+	setInt := func(key string, n int) {
+		if n != 0 {
+			env[key] = strconv.Itoa(n)
+		}
+	}
+	set("REDIS_URL", c.RedisURL)
+	set("EVENT_SOURCE", c.EventSource)
+	set("KAFKA_REST_URL", c.Kafka.RESTURL)
+	set("KAFKA_TOPICS", strings.Join(c.Kafka.Topics, ","))
+	set("KAFKA_GROUP", c.Kafka.Group)
+	set("STORAGE_BACKEND", c.Storage.Backend)
+	set("POSTGRES_DSN", c.Storage.PostgresDSN)
+	set("SQLITE_PATH", c.Storage.SQLitePath)
+	set("NETWORKS", strings.Join(c.Networks, ","))
+	set("RPC_URLS", joinSpec(c.RPCURLs))
+	ids := make(map[string][]string, len(c.ChainIDs))
+	for pair, id := range c.ChainIDs {
+		ids[pair] = []string{id}
+	}
+	set("CHAIN_IDS", joinSpec(ids))
+	setInt("CACHE_MAX_EVENTS", c.Cache.MaxEvents)
+	setInt("CACHE_MAX_EVENTS_PER_WALLET", c.Cache.MaxEventsPerWallet)
+	set("RESPONSE_CACHE_TTL", c.Cache.ResponseTTL)
+	set("CACHE_EVENT_TTL", c.Cache.EventTTL)
+	setInt("CACHE_MAX_MEMORY_MB", c.Cache.MaxMemoryMB)
+	if c.RateLimit.RequestsPerSecond != 0 {
+		env["RATE_LIMIT_RPS"] = strconv.FormatFloat(c.RateLimit.RequestsPerSecond, 'f', -1, 64)
+	}
+	setInt("RATE_LIMIT_BURST", c.RateLimit.Burst)
+	set("TENANT_WALLETS", joinSpec(c.TenantWallets))
+	wallets := make([]string, 0, len(c.SequenceWallets))
+	for _, w := range c.SequenceWallets {
+		wallets = append(wallets, w.Chain+":"+w.Network+"="+w.Address)
+	}
+	set("SEQUENCE_WALLETS", strings.Join(wallets, ","))
+	return env
+}
+
+// joinSpec encodes m as a comma-separated list of key=value entries, one
+// per value, in key order.
+func joinSpec(m map[string][]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var items []string
+	for _, k := range keys {
+		for _, v := range m[k] {
+			items = append(items, k+"="+v)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// ConfigFile is a loaded CONFIG_FILE. Its settings fill in the environment
+// variables the operator did not set, so the rest of the API reads them as
+// before. Reloads update the reloadable settings the file provides; changes
+// to the others are logged as needing a restart.
+type ConfigFile struct {
+	path string
+
+	mu      sync.Mutex
+	fromEnv map[string]bool   // settings the environment overrides
+	applied map[string]string // settings taken from the file
+	modTime time.Time
+}
+
+// LoadConfigFile reads path and fills in the environment from it.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	c := &ConfigFile{path: path, fromEnv: make(map[string]bool), applied: make(map[string]string)}
+	env, modTime, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range env {
+		if _, ok := os.LookupEnv(key); ok {
+			c.fromEnv[key] = true
+			continue
+		}
+		os.Setenv(key, value)
+		c.applied[key] = value
+	}
+	c.modTime = modTime
+	return c, nil
+}
+
+func (c *ConfigFile) read() (map[string]string, time.Time, error) {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	cfg, err := ParseFileConfig(data)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s: %w", c.path, err)
+	}
+	return cfg.Env(), info.ModTime(), nil
+}
+
+// Reload re-reads the file, updates the environment with the reloadable
+// settings that changed and returns them; a removed setting is returned
+// empty. Settings the environment overrides are left alone.
+func (c *ConfigFile) Reload() (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	env, modTime, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	c.modTime = modTime
+	keys := make(map[string]bool)
+	for key := range env {
+		keys[key] = true
+	}
+	for key := range c.applied {
+		keys[key] = true
+	}
+	changed := make(map[string]string)
+	for key := range keys {
+		value := env[key]
+		if _, fromFile := c.applied[key]; !fromFile && !c.fromEnv[key] {
+			_, c.fromEnv[key] = os.LookupEnv(key)
+		}
+		if c.fromEnv[key] || value == c.applied[key] {
+			continue
+		}
+		if !reloadableSettings[key] {
+			log.WithField("setting", key).Warn("config file setting changed; restart to apply it")
+			continue
+		}
+		if value == "" {
+			os.Unsetenv(key)
+			delete(c.applied, key)
+		} else {
+			os.Setenv(key, value)
+			c.applied[key] = value
+		}
+		changed[key] = value
+	}
+	return changed, nil
+}
+
+// changedOnDisk reports whether the file was modified since it was last
+// read.
+func (c *ConfigFile) changedOnDisk() bool {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !info.ModTime().Equal(c.modTime)
+}
+
+// Watch reloads the file on SIGHUP and when it changes on disk, checking
+// every interval, and passes the changed settings to apply, until ctx is
+// done.
+func (c *ConfigFile) Watch(ctx context.Context, interval time.Duration, apply func(changed map[string]string)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-ticker.C:
+			if !c.changedOnDisk() {
+				continue
+			}
+		}
+		changed, err := c.Reload()
+		if err != nil {
+			log.WithError(err).Warn("config reload failed; keeping the current settings")
+			continue
+		}
+		log.WithField("changed", len(changed)).Info("api: config file reloaded")
+		if len(changed) > 0 {
+			apply(changed)
+		}
+	}
+}
+
+// rateLimitFromEnv reads RATE_LIMIT_RPS and RATE_LIMIT_BURST; a rate of 0,
+// the default, turns limiting off.
+func rateLimitFromEnv() (float64, int, error) {
+	var rate float64
+	if v := os.Getenv("RATE_LIMIT_RPS"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r < 0 {
+			return 0, 0, fmt.Errorf("invalid RATE_LIMIT_RPS %q", v)
+		}
+		rate = r
+	}
+	var burst int
+	if v := os.Getenv("RATE_LIMIT_BURST"); v != "" {
+		b, err := strconv.Atoi(v)
+		if err != nil || b < 0 {
+			return 0, 0, fmt.Errorf("invalid RATE_LIMIT_BURST %q", v)
+		}
+		burst = b
+	}
+	return rate, burst, nil
+}
+
+// applyConfig returns the Watch callback updating tenants' wallets, the
+// sequence tracker's wallets and the rate limits, and importing the history
+// of added wallets when history is set. A setting that does not parse is
+// logged and the current one kept.
+func applyConfig(tenants *Tenants, sequences *SequenceTracker, history *HistoryImporter, limiter *RateLimiter) func(map[string]string) {
+	return func(changed map[string]string) {
+		if spec, ok := changed["TENANT_WALLETS"]; ok {
+			if err := tenants.SetWallets(spec); err != nil {
+				log.WithError(err).Warn("invalid TENANT_WALLETS; keeping the current wallets")
+			} else {
+				log.Info("api: tenant wallets reloaded")
+				history.ImportWatched(tenants, nil)
+			}
+		}
+		if spec, ok := changed["SEQUENCE_WALLETS"]; ok && sequences != nil {
+			if wallets, err := ParseSequenceWallets(spec); err != nil {
+				log.WithError(err).Warn("invalid SEQUENCE_WALLETS; keeping the current wallets")
+			} else {
+				sequences.SetWallets(wallets)
+				log.WithField("wallets", len(wallets)).Info("api: sequence wallets reloaded")
+				history.ImportWatched(nil, wallets)
+			}
+		}
+		_, rps := changed["RATE_LIMIT_RPS"]
+		_, burst := changed["RATE_LIMIT_BURST"]
+		if rps || burst {
+			if rate, burst, err := rateLimitFromEnv(); err != nil {
+				log.WithError(err).Warn("keeping the current rate limits")
+			} else {
+				limiter.SetLimit(rate, burst)
+				log.WithFields(log.Fields{"rps": rate, "burst": burst}).Info("api: rate limits reloaded")
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+redis_url: redis://redis:6379
+networks: [ethereum:mainnet, solana:devnet]
+rpc_urls:
+  ethereum:mainnet: [https://a.example, https://b.example]
+cache:
+  max_events: 5000
+rate_limit:
+  requests_per_second: 2.5
+  burst: 10
+tenant_wallets:
+  treasury: [0xabc]
+sequence_wallets:
+  - {chain: ethereum, network: mainnet, address: 0xabc}
+`
+
+func TestFileConfigEnv(t *testing.T) {
+	cfg, err := ParseFileConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := map[string]string{
+		"REDIS_URL":        "redis://redis:6379",
+		"NETWORKS":         "ethereum:mainnet,solana:devnet",
+		"RPC_URLS":         "ethereum:mainnet=https://a.example,ethereum:mainnet=https://b.example",
+		"CACHE_MAX_EVENTS": "5000",
+		"RATE_LIMIT_RPS":   "2.5",
+		"RATE_LIMIT_BURST": "10",
+		"TENANT_WALLETS":   "treasury=0xabc",
+		"SEQUENCE_WALLETS": "ethereum:mainnet=0xabc",
+	}
+	env := cfg.Env()
+	if len(env) != len(want) {
+		t.Fatalf("env = %v", env)
+	}
+	for key, value := range want {
+		if env[key] != value {
+			t.Errorf("%s = %q, want %q", key, env[key], value)
+		}
+	}
+
+	if _, err := ParseFileConfig([]byte("redis: redis://typo:6379\n")); err == nil {
+		t.Fatal("unknown key accepted")
+	}
+	if cfg, err := ParseFileConfig(nil); err != nil || len(cfg.Env()) != 0 {
+		t.Fatalf("empty file = %v, %v", cfg, err)
+	}
+}
+
+// clearEnv unsets keys for the test and restores them afterwards.
+func clearEnv(t *testing.T, keys ...string) {
+	for _, key := range keys {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+}
+
+func TestConfigFileReload(t *testing.T) {
+	clearEnv(t, "REDIS_URL", "NETWORKS", "RPC_URLS", "CACHE_MAX_EVENTS", "RATE_LIMIT_RPS", "RATE_LIMIT_BURST", "SEQUENCE_WALLETS")
+	// The environment wins over the file
+	t.Setenv("TENANT_WALLETS", "ops=0xdef")
+	path := filepath.Join(t.TempDir(), "tracker.yaml")
+	write := func(doc string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(doc), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(testConfig)
+	cf, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if os.Getenv("REDIS_URL") != "redis://redis:6379" || os.Getenv("TENANT_WALLETS") != "ops=0xdef" {
+		t.Fatalf("REDIS_URL %q, TENANT_WALLETS %q", os.Getenv("REDIS_URL"), os.Getenv("TENANT_WALLETS"))
+	}
+
+	write(`
+redis_url: redis://other:6379
+rate_limit:
+  requests_per_second: 1
+tenant_wallets:
+  treasury: [0x123]
+`)
+	changed, err := cf.Reload()
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	want := map[string]string{"RATE_LIMIT_RPS": "1", "RATE_LIMIT_BURST": "", "SEQUENCE_WALLETS": ""}
+	if len(changed) != len(want) {
+		t.Fatalf("changed = %v, want %v", changed, want)
+	}
+	for key, value := range want {
+		if v, ok := changed[key]; !ok || v != value {
+			t.Errorf("changed[%s] = %q, %v; want %q", key, v, ok, value)
+		}
+	}
+	// Structural settings wait for a restart
+	if os.Getenv("REDIS_URL") != "redis://redis:6379" {
+		t.Fatalf("REDIS_URL reloaded to %q", os.Getenv("REDIS_URL"))
+	}
+	if _, ok := os.LookupEnv("RATE_LIMIT_BURST"); ok {
+		t.Fatal("removed RATE_LIMIT_BURST still set")
+	}
+
+	write("redis_url: [not, a, string]\n")
+	if _, err := cf.Reload(); err == nil {
+		t.Fatal("invalid file reloaded")
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	clearEnv(t, "RATE_LIMIT_RPS", "RATE_LIMIT_BURST")
+	tenants, err := ParseTenants("treasury=k1", "treasury=0xabc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewSequenceTracker(NewEventStore(10, 10), nil, &memoryBackfillQueue{}, nil, nil, time.Minute)
+	limiter := NewRateLimiter(0, 0)
+	apply := applyConfig(tenants, tracker, nil, limiter)
+
+	os.Setenv("RATE_LIMIT_RPS", "1")
+	apply(map[string]string{
+		"TENANT_WALLETS":   "treasury=0xdef",
+		"SEQUENCE_WALLETS": "ethereum:mainnet=" + aliceAddr,
+		"RATE_LIMIT_RPS":   "1",
+	})
+	if tenant, _ := tenants.Owner("0xdef"); tenant != "treasury" {
+		t.Fatalf("tenant wallets not applied")
+	}
+	if status := tracker.Status(); len(status) != 1 || status[0].Address != checksumAddress(aliceAddr) {
+		t.Fatalf("sequence wallets = %+v", status)
+	}
+	limiter.Allow("a")
+	if ok, _ := limiter.Allow("a"); ok {
+		t.Fatal("rate limit not applied")
+	}
+
+	// Invalid settings keep the current ones
+	apply(map[string]string{"TENANT_WALLETS": "treasury", "SEQUENCE_WALLETS": "nope"})
+	if tenant, _ := tenants.Owner("0xdef"); tenant != "treasury" {
+		t.Fatal("invalid tenant wallets replaced the current ones")
+	}
+	if status := tracker.Status(); len(status) != 1 {
+		t.Fatalf("invalid sequence wallets replaced the current ones: %+v", status)
+	}
+}
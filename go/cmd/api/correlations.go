@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+// Verdicts an operator can record on a pair of bridge legs. Confirmed and
+// linked pairs are legs with full confidence, whether or not the pairing
+// rules matched them; rejected pairs are never legs.
+const (
+	VerdictConfirmed = "confirmed"
+	VerdictRejected  = "rejected"
+	VerdictLinked    = "linked"
+)
+
+// correlationsSchema creates the table correlation overrides are persisted
+// in. Each pair is stored once, with the smaller event ID first.
+const correlationsSchema = `
+	CREATE TABLE IF NOT EXISTS correlation_overrides (
+		event_id TEXT NOT NULL,
+		leg_id TEXT NOT NULL,
+		verdict TEXT NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (event_id, leg_id)
+	);
+`
+
+// CorrelationOverride is an operator's verdict on whether two events are
+// legs of the same bridge transfer. The recorded overrides double as
+// labeled pairs for tuning the pairing heuristics.
+type CorrelationOverride struct {
+	EventID   string `json:"event_id"`
+	LegID     string `json:"leg_id"`
+	Verdict   string `json:"verdict"`
+	Note      string `json:"note,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+// CorrelationVerdict is the body of PUT /admin/correlation/overrides/{event_id}/{leg_id}.
+type CorrelationVerdict struct {
+	Verdict string `json:"verdict"`
+	Note    string `json:"note,omitempty"`
+}
+
+// Correlation is the confidence that an event is a leg of another's bridge
+// transfer, and the override that decided it, if any.
+type Correlation struct {
+	LegID      string  `json:"leg_id"`
+	Confidence float64 `json:"confidence"`
+	Override   string  `json:"override,omitempty"`
+}
+
+// legConfidence scores a pair that passed the pairing rules: matching
+// protocol identifiers give 0.7, a destination chain both sides record
+// adds 0.2 and moving the same asset adds 0.1.
+func legConfidence(ev, leg *Event) float64 {
+	score := 0.7
+	if ev.DestChain != "" && leg.DestChain != "" {
+		score += 0.2
+	}
+	if eventSymbol(ev) == eventSymbol(leg) {
+		score += 0.1
+	}
+	return math.Round(score*100) / 100
+}
+
+// eventSymbol is the token symbol an event moves, or "" for the native
+// currency.
+func eventSymbol(ev *Event) string {
+	if ev.Token == nil {
+		return ""
+	}
+	return strings.ToUpper(ev.Token.Symbol)
+}
+
+// correlationPair orders two event IDs the way overrides are keyed.
+func correlationPair(a, b string) [2]string {
+	if b < a {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+// CorrelationStore keeps correlation overrides in memory and, when a
+// database is attached, persists them to the correlation_overrides table.
+type CorrelationStore struct {
+	mu        sync.RWMutex
+	overrides map[[2]string]CorrelationOverride
+	db        *pgxpool.Pool
+}
+
+func NewCorrelationStore() *CorrelationStore {
+	return &CorrelationStore{overrides: make(map[[2]string]CorrelationOverride)}
+}
+
+// AttachDB connects the store to Postgres and loads the existing overrides.
+func (s *CorrelationStore) AttachDB(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx, `SELECT event_id, leg_id, verdict, note, updated_at FROM correlation_overrides`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	loaded := make(map[[2]string]CorrelationOverride)
+	for rows.Next() {
+		var o CorrelationOverride
+		var updated time.Time
+		if err := rows.Scan(&o.EventID, &o.LegID, &o.Verdict, &o.Note, &updated); err != nil {
+			return err
+		}
+		o.UpdatedAt = updated.UTC().Format(time.RFC3339)
+		loaded[correlationPair(o.EventID, o.LegID)] = o
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.overrides = loaded
+	s.db = db
+	s.mu.Unlock()
+	return nil
+}
+
+// Get returns the override recorded for two events, in either order.
+func (s *CorrelationStore) Get(a, b string) (CorrelationOverride, bool) {
+	if s == nil {
+		return CorrelationOverride{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	o, ok := s.overrides[correlationPair(a, b)]
+	return o, ok
+}
+
+// Linked returns the IDs of the events confirmed or linked as legs of id.
+func (s *CorrelationStore) Linked(id string) []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []string
+	for pair, o := range s.overrides {
+		if o.Verdict == VerdictRejected {
+			continue
+		}
+		switch id {
+		case pair[0]:
+			out = append(out, pair[1])
+		case pair[1]:
+			out = append(out, pair[0])
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// List returns every override, most recently updated first.
+func (s *CorrelationStore) List() []CorrelationOverride {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]CorrelationOverride, 0, len(s.overrides))
+	for _, o := range s.overrides {
+		out = append(out, o)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].UpdatedAt != out[j].UpdatedAt {
+			return out[i].UpdatedAt > out[j].UpdatedAt
+		}
+		if out[i].EventID != out[j].EventID {
+			return out[i].EventID < out[j].EventID
+		}
+		return out[i].LegID < out[j].LegID
+	})
+	return out
+}
+
+// Set records a verdict on two events, replacing any earlier one.
+func (s *CorrelationStore) Set(ctx context.Context, o CorrelationOverride) (CorrelationOverride, error) {
+	switch o.Verdict {
+	case VerdictConfirmed, VerdictRejected, VerdictLinked:
+	default:
+		return o, invalidParam("verdict", "verdict must be one of %s, %s, %s", VerdictConfirmed, VerdictRejected, VerdictLinked)
+	}
+	if o.EventID == o.LegID {
+		return o, invalidParam("leg_id", "an event cannot be its own leg")
+	}
+	pair := correlationPair(o.EventID, o.LegID)
+	o.EventID, o.LegID = pair[0], pair[1]
+	o.Note = strings.TrimSpace(o.Note)
+	o.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if s.db != nil {
+		if _, err := s.db.Exec(ctx, `
+			INSERT INTO correlation_overrides (event_id, leg_id, verdict, note) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (event_id, leg_id) DO UPDATE SET verdict = EXCLUDED.verdict, note = EXCLUDED.note, updated_at = NOW()
+		`, o.EventID, o.LegID, o.Verdict, o.Note); err != nil {
+			return o, err
+		}
+	}
+	s.mu.Lock()
+	s.overrides[pair] = o
+	s.mu.Unlock()
+	return o, nil
+}
+
+// Delete removes the verdict on two events and reports whether there was
+// one.
+func (s *CorrelationStore) Delete(ctx context.Context, a, b string) (bool, error) {
+	pair := correlationPair(a, b)
+	if _, ok := s.Get(a, b); !ok {
+		return false, nil
+	}
+	if s.db != nil {
+		if _, err := s.db.Exec(ctx, `DELETE FROM correlation_overrides WHERE event_id = $1 AND leg_id = $2`, pair[0], pair[1]); err != nil {
+			return false, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.overrides[pair]
+	delete(s.overrides, pair)
+	return ok, nil
+}
+
+// listCorrelationOverrides returns every recorded verdict, for review or
+// as labeled pairs for the pairing heuristics.
+func listCorrelationOverrides(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(store.correlations.List())
+}
+
+// putCorrelationOverride confirms, rejects or manually links two events as
+// legs of one bridge transfer.
+func putCorrelationOverride(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	var body CorrelationVerdict
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	id, legID := chi.URLParam(r, "event_id"), chi.URLParam(r, "leg_id")
+	for _, eventID := range []string{id, legID} {
+		if _, ok := store.GetByID(eventID); !ok {
+			httpError(w, "event "+eventID+" not found", http.StatusNotFound)
+			return
+		}
+	}
+	o, err := store.correlations.Set(r.Context(), CorrelationOverride{EventID: id, LegID: legID, Verdict: body.Verdict, Note: body.Note})
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to store correlation override")
+		httpError(w, "could not store correlation override", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(o)
+}
+
+// deleteCorrelationOverride removes a verdict, returning the pair to the
+// pairing rules.
+func deleteCorrelationOverride(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	ok, err := store.correlations.Delete(r.Context(), chi.URLParam(r, "event_id"), chi.URLParam(r, "leg_id"))
+	if err != nil {
+		log.WithError(err).Error("failed to delete correlation override")
+		httpError(w, "could not delete correlation override", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "correlation override not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
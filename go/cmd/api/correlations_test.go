@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestCorrelationOverrides(t *testing.T) {
+	store := NewEventStore(100, 50)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	for _, l := range []struct{ id, chain, txHash, dest, sequence string }{
+		{"burn", "ethereum", "0x01", "base", "9"},
+		{"mint", "base", "0x02", "base", "9"},
+		{"unrelated", "base", "0x03", "", ""},
+	} {
+		ev := makeEvent(l.id, "alice", "bob", "1", ts, "USDC")
+		ev.Chain, ev.TxHash = l.chain, l.txHash
+		if l.sequence != "" {
+			ev.Bridge, ev.SourceChain, ev.DestChain, ev.Sequence = BridgeCCTP, "ethereum", l.dest, l.sequence
+		}
+		store.Add(ev)
+	}
+	burn, _ := store.GetByID("burn")
+
+	legs, scores := store.Correlations(burn, "")
+	if len(legs) != 1 || len(scores) != 1 || scores[0].LegID != "mint" || scores[0].Confidence != 1 || scores[0].Override != "" {
+		t.Fatalf("correlations = %+v, want mint at full confidence from the rules", scores)
+	}
+
+	router := chi.NewRouter()
+	mountAdmin(router, "s3cret", store, NewChainRegistry(nil, nil), nil, nil)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPut, "/admin/correlation/overrides/mint/burn", `{"verdict":"rejected","note":" wrong amount "}`); rec.Code != http.StatusOK {
+		t.Fatalf("reject = %d %s", rec.Code, rec.Body)
+	}
+	if legs := store.BridgeLegs(burn, ""); len(legs) != 0 {
+		t.Fatalf("legs after reject = %+v, want none", legs)
+	}
+	if rec := do(http.MethodPut, "/admin/correlation/overrides/burn/unrelated", `{"verdict":"linked"}`); rec.Code != http.StatusOK {
+		t.Fatalf("link = %d %s", rec.Code, rec.Body)
+	}
+	legs, scores = store.Correlations(burn, "")
+	if len(legs) != 1 || legs[0].EventID != "unrelated" || scores[0].Confidence != 1 || scores[0].Override != VerdictLinked {
+		t.Fatalf("correlations after link = %+v", scores)
+	}
+	// Links apply both ways, even to an event without a bridge message.
+	unrelated, _ := store.GetByID("unrelated")
+	if legs := store.BridgeLegs(unrelated, ""); len(legs) != 1 || legs[0].EventID != "burn" {
+		t.Fatalf("legs of the linked event = %+v, want burn", legs)
+	}
+
+	var overrides []CorrelationOverride
+	rec := do(http.MethodGet, "/admin/correlation/overrides", "")
+	if err := json.NewDecoder(rec.Body).Decode(&overrides); err != nil || len(overrides) != 2 {
+		t.Fatalf("overrides = %d %+v, %v", rec.Code, overrides, err)
+	}
+	for _, o := range overrides {
+		if o.EventID == "burn" && o.LegID == "mint" && (o.Verdict != VerdictRejected || o.Note != "wrong amount") {
+			t.Fatalf("stored override = %+v", o)
+		}
+	}
+
+	for _, tc := range []struct {
+		path, body string
+		want       int
+	}{
+		{"/admin/correlation/overrides/burn/mint", `{"verdict":"maybe"}`, http.StatusBadRequest},
+		{"/admin/correlation/overrides/burn/burn", `{"verdict":"linked"}`, http.StatusBadRequest},
+		{"/admin/correlation/overrides/burn/nope", `{"verdict":"linked"}`, http.StatusNotFound},
+		{"/admin/correlation/overrides/burn/mint", `not json`, http.StatusBadRequest},
+	} {
+		if rec := do(http.MethodPut, tc.path, tc.body); rec.Code != tc.want {
+			t.Errorf("PUT %s %s = %d, want %d", tc.path, tc.body, rec.Code, tc.want)
+		}
+	}
+
+	if rec := do(http.MethodDelete, "/admin/correlation/overrides/mint/burn", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete = %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/admin/correlation/overrides/mint/burn", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("second delete = %d, want 404", rec.Code)
+	}
+	if legs := store.BridgeLegs(burn, ""); len(legs) != 2 {
+		t.Fatalf("legs after delete = %+v, want mint and the linked event", legs)
+	}
+}
+
+func TestLegConfidence(t *testing.T) {
+	ev := &Event{DestChain: "base", Token: &Token{Symbol: "USDC"}}
+	for _, tc := range []struct {
+		leg  *Event
+		want float64
+	}{
+		{&Event{DestChain: "base", Token: &Token{Symbol: "usdc"}}, 1},
+		{&Event{Token: &Token{Symbol: "USDC"}}, 0.8},
+		{&Event{DestChain: "base"}, 0.9},
+		{&Event{}, 0.7},
+	} {
+		if got := legConfidence(ev, tc.leg); got != tc.want {
+			t.Errorf("legConfidence(%+v) = %v, want %v", tc.leg, got, tc.want)
+		}
+	}
+}
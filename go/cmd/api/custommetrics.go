@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxMetricsPerTenant caps the custom metrics a tenant can define.
+	maxMetricsPerTenant = 50
+	// maxMetricCells caps the (bucket, group) cells a metric keeps. When a
+	// new bucket starts on a full metric the oldest bucket is evicted;
+	// new groups beyond the cap are not counted.
+	maxMetricCells = 10000
+	// metricsFlushInterval is how often changed metric state is saved.
+	metricsFlushInterval = time.Minute
+)
+
+// metricsSchema creates the table custom metrics are persisted in, with
+// their definition and their aggregated state as of the last flush.
+const metricsSchema = `
+	CREATE TABLE IF NOT EXISTS custom_metrics (
+		tenant TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL,
+		definition JSONB NOT NULL,
+		state JSONB NOT NULL DEFAULT '[]',
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (tenant, name)
+	);
+`
+
+var metricName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// MetricDefinition describes a tenant-defined metric: an aggregate of an
+// expression over the events matching Where, optionally per group and per
+// time bucket. See ParseExpr for the expression syntax.
+type MetricDefinition struct {
+	Name string `json:"name"`
+	// Aggregate is count, sum, avg, min or max. All but count need Value.
+	Aggregate string `json:"aggregate"`
+	Value     string `json:"value,omitempty"`
+	Where     string `json:"where,omitempty"`
+	GroupBy   string `json:"group_by,omitempty"`
+	// Interval buckets the metric by event time: "1h", "1d", or empty for
+	// a single running total.
+	Interval  string `json:"interval,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+	// Tenant owns the metric, which only sees the tenant's events.
+	Tenant string `json:"-"`
+}
+
+// MetricPoint is a metric's value in one bucket and group.
+type MetricPoint struct {
+	Bucket string  `json:"bucket,omitempty"`
+	Group  string  `json:"group,omitempty"`
+	Value  float64 `json:"value"`
+	Count  int64   `json:"count"`
+}
+
+// MetricSeries is the response of GET /stats/custom/{name}. Dropped counts
+// the events that fell into groups beyond the metric's cell cap.
+type MetricSeries struct {
+	MetricDefinition
+	Points  []MetricPoint `json:"points"`
+	Dropped int64         `json:"dropped,omitempty"`
+}
+
+type metricKey struct {
+	Bucket int64 // Unix seconds of the bucket start, 0 without an interval
+	Group  string
+}
+
+// metricCell is the running aggregate of one (bucket, group), which is
+// also how metric state is persisted.
+type metricCell struct {
+	Bucket int64   `json:"bucket,omitempty"`
+	Group  string  `json:"group,omitempty"`
+	Count  int64   `json:"count"`
+	Sum    float64 `json:"sum"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+// customMetric is a compiled definition and its state. Its fields besides def
+// and the expressions are guarded by the CustomMetrics lock.
+type customMetric struct {
+	def                   MetricDefinition
+	value, where, groupBy *Expr
+	cells                 map[metricKey]*metricCell
+	dropped               int64
+	dirty                 bool
+}
+
+// compile normalizes a definition and compiles its expressions.
+func (d *MetricDefinition) compile() (*customMetric, error) {
+	if !metricName.MatchString(d.Name) {
+		return nil, invalidParam("name", "name must be 1-64 lowercase letters, digits, '-' or '_'")
+	}
+	d.Aggregate = strings.ToLower(strings.TrimSpace(d.Aggregate))
+	switch d.Aggregate {
+	case "count", "sum", "avg", "min", "max":
+	default:
+		return nil, invalidParam("aggregate", "aggregate must be one of count, sum, avg, min, max")
+	}
+	if _, _, ok := truncUnit(d.Interval); d.Interval != "" && !ok {
+		return nil, invalidParam("interval", "interval must be 1h, 1d or empty")
+	}
+	m := &customMetric{cells: make(map[metricKey]*metricCell)}
+	for _, e := range []struct {
+		field string
+		src   *string
+		dst   **Expr
+	}{
+		{"value", &d.Value, &m.value},
+		{"where", &d.Where, &m.where},
+		{"group_by", &d.GroupBy, &m.groupBy},
+	} {
+		if *e.src = strings.TrimSpace(*e.src); *e.src == "" {
+			continue
+		}
+		expr, err := ParseExpr(*e.src)
+		if err != nil {
+			return nil, invalidParam(e.field, "%s: %v", e.field, err)
+		}
+		*e.dst = expr
+	}
+	if m.value == nil && d.Aggregate != "count" {
+		return nil, invalidParam("value", "value is required for %s", d.Aggregate)
+	}
+	m.def = *d
+	return m, nil
+}
+
+// observe folds ev into the metric. Events the where condition rejects,
+// or whose value or group cannot be evaluated, are skipped.
+func (m *customMetric) observe(ev *Event) {
+	if m.where != nil && !m.where.EvalBool(ev) {
+		return
+	}
+	var key metricKey
+	if m.def.Interval != "" {
+		ts, err := time.Parse(time.RFC3339, ev.Timestamp)
+		if err != nil {
+			return
+		}
+		key.Bucket = AnalyticsQuery{Interval: m.def.Interval}.bucketStart(ts).Unix()
+	}
+	if m.groupBy != nil {
+		g, err := m.groupBy.Eval(ev)
+		if err != nil {
+			return
+		}
+		key.Group = formatExprValue(g)
+	}
+	var v float64
+	if m.value != nil {
+		var err error
+		if v, err = m.value.EvalNumber(ev); err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return
+		}
+	}
+
+	c, ok := m.cells[key]
+	if !ok {
+		if len(m.cells) >= maxMetricCells && !m.evictBefore(key.Bucket) {
+			m.dropped++
+			m.dirty = true
+			return
+		}
+		c = &metricCell{Bucket: key.Bucket, Group: key.Group, Min: v, Max: v}
+		m.cells[key] = c
+	}
+	c.Count++
+	c.Sum += v
+	c.Min = math.Min(c.Min, v)
+	c.Max = math.Max(c.Max, v)
+	m.dirty = true
+}
+
+// evictBefore frees cells by removing the oldest bucket, if it is older
+// than bucket, and reports whether it did.
+func (m *customMetric) evictBefore(bucket int64) bool {
+	oldest := bucket
+	for k := range m.cells {
+		if k.Bucket < oldest {
+			oldest = k.Bucket
+		}
+	}
+	if oldest == bucket {
+		return false
+	}
+	for k := range m.cells {
+		if k.Bucket == oldest {
+			delete(m.cells, k)
+		}
+	}
+	return true
+}
+
+// points returns the metric's values, sorted by bucket and group, for the
+// buckets in [start, end); zero times leave that side open.
+func (m *customMetric) points(start, end time.Time) []MetricPoint {
+	cells := make([]*metricCell, 0, len(m.cells))
+	for _, c := range m.cells {
+		if m.def.Interval != "" {
+			t := time.Unix(c.Bucket, 0)
+			if (!start.IsZero() && t.Before(start)) || (!end.IsZero() && !t.Before(end)) {
+				continue
+			}
+		}
+		cells = append(cells, c)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Bucket != cells[j].Bucket {
+			return cells[i].Bucket < cells[j].Bucket
+		}
+		return cells[i].Group < cells[j].Group
+	})
+	out := make([]MetricPoint, len(cells))
+	for i, c := range cells {
+		p := MetricPoint{Group: c.Group, Count: c.Count}
+		if m.def.Interval != "" {
+			p.Bucket = time.Unix(c.Bucket, 0).UTC().Format(time.RFC3339)
+		}
+		switch m.def.Aggregate {
+		case "count":
+			p.Value = float64(c.Count)
+		case "sum":
+			p.Value = c.Sum
+		case "avg":
+			p.Value = c.Sum / float64(c.Count)
+		case "min":
+			p.Value = c.Min
+		case "max":
+			p.Value = c.Max
+		}
+		out[i] = p
+	}
+	return out
+}
+
+// CustomMetrics maintains the tenants' derived metrics incrementally as
+// events are ingested. When a database is attached, definitions are saved on
+// change and state is flushed by Run.
+type CustomMetrics struct {
+	mu      sync.Mutex
+	metrics map[string]map[string]*customMetric // tenant -> name
+	db      *pgxpool.Pool
+}
+
+func NewCustomMetrics() *CustomMetrics {
+	return &CustomMetrics{metrics: make(map[string]map[string]*customMetric)}
+}
+
+// AttachDB connects the metrics to Postgres and loads the stored ones with
+// their last flushed state.
+func (s *CustomMetrics) AttachDB(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx, `SELECT tenant, name, definition, state FROM custom_metrics`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	loaded := make(map[string]map[string]*customMetric)
+	for rows.Next() {
+		var tenant, name string
+		var definition, state []byte
+		if err := rows.Scan(&tenant, &name, &definition, &state); err != nil {
+			return err
+		}
+		var d MetricDefinition
+		var cells []*metricCell
+		err := json.Unmarshal(definition, &d)
+		if err == nil {
+			err = json.Unmarshal(state, &cells)
+		}
+		var m *customMetric
+		if err == nil {
+			m, err = d.compile()
+		}
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"tenant": tenant, "metric": name}).Warn("skipping unreadable custom metric")
+			continue
+		}
+		m.def.Tenant = tenant
+		for _, c := range cells {
+			m.cells[metricKey{Bucket: c.Bucket, Group: c.Group}] = c
+		}
+		if loaded[tenant] == nil {
+			loaded[tenant] = make(map[string]*customMetric)
+		}
+		loaded[tenant][name] = m
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.metrics = loaded
+	s.db = db
+	s.mu.Unlock()
+	return nil
+}
+
+// Create validates and adds a metric for tenant. It starts empty and only
+// counts events ingested from then on.
+func (s *CustomMetrics) Create(ctx context.Context, d MetricDefinition, tenant string) (MetricDefinition, error) {
+	m, err := d.compile()
+	if err != nil {
+		return d, err
+	}
+	m.def.Tenant = tenant
+	m.def.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.metrics[tenant][m.def.Name]; exists {
+		return d, invalidParam("name", "metric %q already exists", m.def.Name)
+	}
+	if len(s.metrics[tenant]) >= maxMetricsPerTenant {
+		return d, invalidParam("name", "at most %d custom metrics per tenant", maxMetricsPerTenant)
+	}
+	if s.db != nil {
+		definition, err := json.Marshal(m.def)
+		if err != nil {
+			return d, err
+		}
+		if _, err := s.db.Exec(ctx, `INSERT INTO custom_metrics (tenant, name, definition) VALUES ($1, $2, $3)`,
+			tenant, m.def.Name, definition); err != nil {
+			return d, err
+		}
+	}
+	if s.metrics[tenant] == nil {
+		s.metrics[tenant] = make(map[string]*customMetric)
+	}
+	s.metrics[tenant][m.def.Name] = m
+	return m.def, nil
+}
+
+// List returns the definitions of tenant's metrics, sorted by name.
+func (s *CustomMetrics) List(tenant string) []MetricDefinition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]MetricDefinition, 0, len(s.metrics[tenant]))
+	for _, m := range s.metrics[tenant] {
+		out = append(out, m.def)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Series returns a metric of tenant with its points in [start, end).
+func (s *CustomMetrics) Series(tenant, name string, start, end time.Time) (MetricSeries, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.metrics[tenant][name]
+	if !ok {
+		return MetricSeries{}, false
+	}
+	return MetricSeries{MetricDefinition: m.def, Points: m.points(start, end), Dropped: m.dropped}, true
+}
+
+// Delete removes a metric of tenant and reports whether it existed.
+func (s *CustomMetrics) Delete(ctx context.Context, tenant, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db != nil {
+		if _, err := s.db.Exec(ctx, `DELETE FROM custom_metrics WHERE tenant = $1 AND name = $2`, tenant, name); err != nil {
+			return false, err
+		}
+	}
+	_, ok := s.metrics[tenant][name]
+	delete(s.metrics[tenant], name)
+	return ok, nil
+}
+
+// Observe folds an ingested event into the deployment-wide metrics and
+// those of the event's tenant. Each delivery is counted, so an event
+// redelivered by the source is counted again.
+func (s *CustomMetrics) Observe(ev *Event) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.metrics[""] {
+		m.observe(ev)
+	}
+	for _, tenant := range eventTenants(ev) {
+		for _, m := range s.metrics[tenant] {
+			m.observe(ev)
+		}
+	}
+}
+
+// Run flushes changed metric state to the attached database every
+// metricsFlushInterval until ctx is done.
+func (s *CustomMetrics) Run(ctx context.Context) {
+	ticker := time.NewTicker(metricsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Flush(ctx)
+		}
+	}
+}
+
+// Flush saves the state of the metrics that changed since the last flush.
+func (s *CustomMetrics) Flush(ctx context.Context) {
+	type pending struct {
+		tenant, name string
+		state        []byte
+	}
+	var changed []pending
+	s.mu.Lock()
+	db := s.db
+	if db == nil {
+		s.mu.Unlock()
+		return
+	}
+	for tenant, byName := range s.metrics {
+		for name, m := range byName {
+			if !m.dirty {
+				continue
+			}
+			cells := make([]*metricCell, 0, len(m.cells))
+			for _, c := range m.cells {
+				cells = append(cells, c)
+			}
+			state, err := json.Marshal(cells)
+			if err != nil {
+				log.WithError(err).WithField("metric", name).Error("failed to encode custom metric state")
+				continue
+			}
+			m.dirty = false
+			changed = append(changed, pending{tenant, name, state})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, p := range changed {
+		if _, err := db.Exec(ctx, `UPDATE custom_metrics SET state = $3, updated_at = NOW() WHERE tenant = $1 AND name = $2`,
+			p.tenant, p.name, p.state); err != nil {
+			log.WithError(err).WithFields(log.Fields{"tenant": p.tenant, "metric": p.name}).Warn("failed to save custom metric state")
+		}
+	}
+}
+
+// createCustomMetric defines a custom metric for the caller's tenant.
+func createCustomMetric(metrics *CustomMetrics, w http.ResponseWriter, r *http.Request) {
+	var d MetricDefinition
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&d); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	d, err := metrics.Create(r.Context(), d, tenantFrom(r.Context()))
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to store custom metric")
+		httpError(w, "could not store metric", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/stats/custom/"+d.Name)
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(d)
+}
+
+// listCustomMetrics returns the definitions of the caller's custom metrics.
+func listCustomMetrics(metrics *CustomMetrics, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(metrics.List(tenantFrom(r.Context())))
+}
+
+// getCustomMetric returns a custom metric's current values, optionally
+// limited to the buckets in [start, end).
+func getCustomMetric(metrics *CustomMetrics, w http.ResponseWriter, r *http.Request) {
+	p := newQueryParams(r)
+	var start, end time.Time
+	if t := p.Time("start"); t != nil {
+		start = *t
+	}
+	if t := p.Time("end"); t != nil {
+		end = *t
+	}
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	series, ok := metrics.Series(tenantFrom(r.Context()), chi.URLParam(r, "name"), start, end)
+	if !ok {
+		httpError(w, "metric not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(series)
+}
+
+// deleteCustomMetric removes a custom metric and its state.
+func deleteCustomMetric(metrics *CustomMetrics, w http.ResponseWriter, r *http.Request) {
+	ok, err := metrics.Delete(r.Context(), tenantFrom(r.Context()), chi.URLParam(r, "name"))
+	if err != nil {
+		log.WithError(err).Error("failed to delete custom metric")
+		httpError(w, "could not delete metric", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "metric not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
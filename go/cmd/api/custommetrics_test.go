@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCustomMetricsObserve(t *testing.T) {
+	ctx := context.Background()
+	metrics := NewCustomMetrics()
+	if _, err := metrics.Create(ctx, MetricDefinition{Name: "usdc", Aggregate: "sum", Value: "amount",
+		Where: `token == "USDC"`, GroupBy: "to", Interval: "1h"}, ""); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := metrics.Create(ctx, MetricDefinition{Name: "count", Aggregate: "count"}, "treasury"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	hour := time.Date(2025, 10, 14, 10, 0, 0, 0, time.UTC)
+	for _, ev := range []*Event{
+		makeEvent("1", aliceAddr, bobAddr, "2000000000000000000", hour.Add(5*time.Minute).Format(time.RFC3339), "USDC"),
+		makeEvent("2", aliceAddr, bobAddr, "1000000000000000000", hour.Add(50*time.Minute).Format(time.RFC3339), "USDC"),
+		makeEvent("3", aliceAddr, carolAddr, "4000000000000000000", hour.Add(70*time.Minute).Format(time.RFC3339), "USDC"),
+		makeEvent("4", aliceAddr, bobAddr, "9000000000000000000", hour.Format(time.RFC3339), "DAI"),
+	} {
+		if ev.EventID == "3" {
+			ev.Tenant = "treasury"
+		}
+		metrics.Observe(ev)
+	}
+
+	series, ok := metrics.Series("", "usdc", time.Time{}, time.Time{})
+	want := []MetricPoint{
+		{Bucket: "2025-10-14T10:00:00Z", Group: bobAddr, Value: 3, Count: 2},
+		{Bucket: "2025-10-14T11:00:00Z", Group: carolAddr, Value: 4, Count: 1},
+	}
+	if !ok || len(series.Points) != len(want) {
+		t.Fatalf("points = %+v", series.Points)
+	}
+	for i := range want {
+		if series.Points[i] != want[i] {
+			t.Errorf("point %d = %+v, want %+v", i, series.Points[i], want[i])
+		}
+	}
+	if series, _ := metrics.Series("", "usdc", hour.Add(time.Hour), time.Time{}); len(series.Points) != 1 {
+		t.Errorf("expected start to drop the first bucket, got %+v", series.Points)
+	}
+
+	// Tenant metrics only see the tenant's events
+	series, _ = metrics.Series("treasury", "count", time.Time{}, time.Time{})
+	if len(series.Points) != 1 || series.Points[0].Value != 1 {
+		t.Fatalf("tenant points = %+v", series.Points)
+	}
+}
+
+func TestCustomMetricHandlers(t *testing.T) {
+	metrics := NewCustomMetrics()
+	for body, field := range map[string]string{
+		`{"name":"Bad Name","aggregate":"count"}`:           "name",
+		`{"name":"x","aggregate":"median","value":"value"}`: "aggregate",
+		`{"name":"x","aggregate":"sum"}`:                    "value",
+		`{"name":"x","aggregate":"sum","value":"amount +"}`: "value",
+		`{"name":"x","aggregate":"count","interval":"1w"}`:  "interval",
+	} {
+		r := httptest.NewRecorder()
+		createCustomMetric(metrics, r, httptest.NewRequest(http.MethodPost, "/stats/custom", strings.NewReader(body)))
+		if r.Code != http.StatusBadRequest || !strings.Contains(r.Body.String(), field) {
+			t.Errorf("%s: expected 400 naming %s, got %d: %s", body, field, r.Code, r.Body.String())
+		}
+	}
+
+	r := httptest.NewRecorder()
+	createCustomMetric(metrics, r, httptest.NewRequest(http.MethodPost, "/stats/custom", strings.NewReader(`{"name":"big","aggregate":"max","value":"amount"}`)))
+	if r.Code != http.StatusCreated || r.Header().Get("Location") != "/stats/custom/big" {
+		t.Fatalf("expected 201, got %d: %s", r.Code, r.Body.String())
+	}
+	metrics.Observe(makeEvent("1", aliceAddr, bobAddr, "7000000000000000000", time.Now().UTC().Format(time.RFC3339), "USDC"))
+
+	r = httptest.NewRecorder()
+	getCustomMetric(metrics, r, withChiParam(httptest.NewRequest(http.MethodGet, "/stats/custom/big", nil), "name", "big"))
+	var series MetricSeries
+	if err := json.NewDecoder(r.Body).Decode(&series); err != nil || len(series.Points) != 1 || series.Points[0].Value != 7 {
+		t.Fatalf("series = %+v, %v", series, err)
+	}
+
+	r = httptest.NewRecorder()
+	deleteCustomMetric(metrics, r, withChiParam(httptest.NewRequest(http.MethodDelete, "/stats/custom/big", nil), "name", "big"))
+	if r.Code != http.StatusNoContent || len(metrics.List("")) != 0 {
+		t.Fatalf("delete: %d, %v", r.Code, metrics.List(""))
+	}
+	r = httptest.NewRecorder()
+	getCustomMetric(metrics, r, withChiParam(httptest.NewRequest(http.MethodGet, "/stats/custom/big", nil), "name", "big"))
+	if r.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", r.Code)
+	}
+}
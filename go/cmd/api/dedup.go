@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// dedupKey identifies the transfer ev records independently of its
+// event_id: chain, network, canonical tx hash and the transfer's position in
+// the transaction. The position is LogIndex when the source sent one, then
+// the log index of a listener event_id of the form eth:<hash>:log<n>, then
+// whatever follows the hash in the event_id (empty for a transaction's own
+// transfer), and otherwise the whole event_id, which only matches itself.
+// An amendment also records the transfer it corrects, so it is keyed by its
+// whole event_id too.
+func dedupKey(ev *Event) string {
+	hash := normalizeTxHash(ev.Chain, ev.TxHash)
+	return ev.Chain + ":" + ev.Network + ":" + hash + eventPosition(ev, hash)
+}
+
+// eventPosition is the position part of dedupKey. Positions from an index
+// start with "#", event_id suffixes with ":" and whole event_ids with "|",
+// so a suffix that merely looks like an index never matches a real one.
+func eventPosition(ev *Event, hash string) string {
+	if ev.Supersedes != "" {
+		return "|" + ev.EventID
+	}
+	if ev.LogIndex != nil {
+		return "#" + strconv.FormatUint(*ev.LogIndex, 10)
+	}
+	// Only locate the hash; base58 signatures keep their case below
+	i := strings.Index(strings.ToLower(ev.EventID), strings.ToLower(hash))
+	if hash == "" || i < 0 {
+		return "|" + ev.EventID
+	}
+	suffix := ev.EventID[i+len(hash):]
+	if n, ok := strings.CutPrefix(suffix, ":log"); ok {
+		if _, err := strconv.ParseUint(n, 10, 64); err == nil {
+			return "#" + n
+		}
+	}
+	return suffix
+}
+
+// mergeDuplicate returns kept with the fields it lacks filled in from dup, a
+// copy of the same transfer from another source, and whether any were.
+// Fields kept already has win, and identity, status and tenancy are never
+// taken from dup.
+func mergeDuplicate(kept, dup *Event) (*Event, bool) {
+	merged := *kept
+	changed := false
+	fillString := func(dst *string, src string) {
+		if *dst == "" && src != "" {
+			*dst, changed = src, true
+		}
+	}
+	fillUint := func(dst **uint64, src *uint64) {
+		if *dst == nil && src != nil {
+			v := *src
+			*dst, changed = &v, true
+		}
+	}
+	fillString(&merged.Timestamp, dup.Timestamp)
+	fillUint(&merged.BlockNumber, dup.BlockNumber)
+	fillUint(&merged.Slot, dup.Slot)
+	fillString(&merged.Fee, dup.Fee)
+	fillUint(&merged.GasUsed, dup.GasUsed)
+	fillString(&merged.PriorityFee, dup.PriorityFee)
+	fillUint(&merged.Nonce, dup.Nonce)
+	fillUint(&merged.LogIndex, dup.LogIndex)
+	fillString(&merged.ExecutedBy, dup.ExecutedBy)
+	fillString(&merged.Multisig, dup.Multisig)
+	fillString(&merged.Bridge, dup.Bridge)
+	fillString(&merged.SourceChain, dup.SourceChain)
+	fillString(&merged.DestChain, dup.DestChain)
+	fillString(&merged.Sequence, dup.Sequence)
+	fillString(&merged.SupersededBy, dup.SupersededBy)
+
+	if dup.Token != nil {
+		token := Token{}
+		if merged.Token != nil {
+			token = *merged.Token
+		}
+		before := token
+		fillString(&token.Address, dup.Token.Address)
+		fillString(&token.Symbol, dup.Token.Symbol)
+		if token.Decimals == 0 && dup.Token.Decimals != 0 {
+			token.Decimals, changed = dup.Token.Decimals, true
+		}
+		if token != before {
+			merged.Token = &token
+		}
+	}
+	if merged.Authority == nil && dup.Authority != nil {
+		authority := *dup.Authority
+		merged.Authority, changed = &authority, true
+	}
+	copied := false
+	for name, value := range dup.Annotations {
+		if _, ok := merged.Annotations[name]; ok {
+			continue
+		}
+		if !copied {
+			// kept's map is shared with readers
+			annotations := make(map[string]json.RawMessage, len(merged.Annotations)+len(dup.Annotations))
+			for k, v := range merged.Annotations {
+				annotations[k] = v
+			}
+			merged.Annotations, copied = annotations, true
+		}
+		merged.Annotations[name], changed = value, true
+	}
+	return &merged, changed
+}
+
+// Deduplicator drops events that are copies of a transfer already stored
+// under another event_id, as happens when several indexers watch
+// overlapping wallets, and stores what the copy adds to the kept event.
+// The repositories' unique dedup_key catches copies that arrive together.
+type Deduplicator struct {
+	store *EventStore
+
+	duplicates uint64
+	merged     uint64
+}
+
+// NewDeduplicator checks events against store.
+func NewDeduplicator(store *EventStore) *Deduplicator {
+	return &Deduplicator{store: store}
+}
+
+// Merge reports whether ev is a copy of a stored event with another
+// event_id. Its metadata is then merged into the stored event and ev should
+// be dropped. A redelivery of the same event_id is not a copy.
+func (d *Deduplicator) Merge(ctx context.Context, ev *Event) (bool, error) {
+	if d == nil {
+		return false, nil
+	}
+	kept := d.find(ctx, ev)
+	if kept == nil {
+		return false, nil
+	}
+	atomic.AddUint64(&d.duplicates, 1)
+	merged, changed := mergeDuplicate(kept, ev)
+	fields := log.Fields{"event_id": ev.EventID, "source": ev.Source, "kept": kept.EventID, "kept_source": kept.Source}
+	if !changed {
+		log.WithFields(fields).Debug("dropped duplicate event")
+		return true, nil
+	}
+	if err := d.store.UpdateMetadata(ctx, merged); err != nil {
+		return true, fmt.Errorf("merge duplicate of %s: %w", kept.EventID, err)
+	}
+	atomic.AddUint64(&d.merged, 1)
+	log.WithFields(fields).Info("merged duplicate event")
+	return true, nil
+}
+
+// find returns the stored event ev duplicates, or nil. The cache is checked
+// first, as it also holds events still queued for a batched write.
+func (d *Deduplicator) find(ctx context.Context, ev *Event) *Event {
+	key := dedupKey(ev)
+	hash := normalizeTxHash(ev.Chain, ev.TxHash)
+	match := func(events []*Event) *Event {
+		for _, stored := range events {
+			if stored.EventID == ev.EventID {
+				return nil
+			}
+		}
+		for _, stored := range events {
+			if dedupKey(stored) == key {
+				return stored
+			}
+		}
+		return nil
+	}
+	cached, _ := d.store.cache.ByTxHash(ctx, hash, ev.Chain)
+	if kept := match(cached); kept != nil || d.store.repo == nil {
+		return kept
+	}
+	stored, err := d.store.repo.ByTxHash(ctx, hash, ev.Chain)
+	if err != nil {
+		log.WithError(err).WithField("event_id", ev.EventID).Warn("duplicate check failed")
+		return nil
+	}
+	return match(stored)
+}
+
+// WriteMetrics writes the duplicate counters in the Prometheus text format.
+func (d *Deduplicator) WriteMetrics(out io.Writer) {
+	metrics := []struct {
+		name, help string
+		value      uint64
+	}{
+		{"tracker_dedup_duplicates_total", "Events dropped as copies of a stored transfer under another event_id.", atomic.LoadUint64(&d.duplicates)},
+		{"tracker_dedup_merged_total", "Dropped copies that added metadata to the stored event.", atomic.LoadUint64(&d.merged)},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", m.name, m.help, m.name, m.name, m.value)
+	}
+}
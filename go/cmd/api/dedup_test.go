@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDedupKey(t *testing.T) {
+	index := uint64(3)
+	listener := &Event{EventID: "eth:" + testEVMHash + ":log3", Chain: "ethereum", Network: "mainnet", TxHash: testEVMHash}
+	indexed := &Event{EventID: "other-7", Chain: "ethereum", Network: "mainnet", TxHash: strings.ToUpper(testEVMHash[2:]), LogIndex: &index}
+	if dedupKey(listener) != dedupKey(indexed) {
+		t.Fatalf("keys differ: %q vs %q", dedupKey(listener), dedupKey(indexed))
+	}
+	native := &Event{EventID: "eth:" + testEVMHash, Chain: "ethereum", Network: "mainnet", TxHash: testEVMHash}
+	if dedupKey(native) == dedupKey(listener) {
+		t.Fatal("a transaction's own transfer matched one of its logs")
+	}
+	// An ordinal in the event_id is not an instruction index
+	ordinal := &Event{EventID: "sol:" + testSolSig + ":3", Chain: "solana", Network: "devnet", TxHash: testSolSig}
+	instruction := &Event{EventID: "sol-b", Chain: "solana", Network: "devnet", TxHash: testSolSig, LogIndex: &index}
+	if dedupKey(ordinal) == dedupKey(instruction) {
+		t.Fatal("event_id suffix matched an instruction index")
+	}
+	other := &Event{EventID: "x", Chain: "ethereum", Network: "mainnet", TxHash: testEVMHash}
+	if dedupKey(other) == dedupKey(native) {
+		t.Fatal("unrelated event_id matched")
+	}
+}
+
+func TestIngestMergesDuplicateEvents(t *testing.T) {
+	ctx := context.Background()
+	store := NewEventStore(100, 50)
+	dedup := NewDeduplicator(store)
+	allowAll, _ := ParseNetworkAllowlist("")
+	hub := NewHub()
+	go hub.Run()
+	handle := ingestEvents(store, hub, allowAll, dedup, nil, nil, nil, nil, nil, nil)
+	ingest := func(payload string) {
+		t.Helper()
+		if err := handle(ctx, []byte(payload)); err != nil {
+			t.Fatalf("handle: %v", err)
+		}
+	}
+	transfer := `"chain":"ethereum","network":"mainnet","from":"` + aliceAddr + `","to":"` + bobAddr + `","value":"5","event_type":"erc20_transfer"`
+
+	ingest(`{"event_id":"eth:` + testEVMHash + `:log3","tx_hash":"` + testEVMHash + `",` + transfer + `}`)
+	// Another indexer's copy, with what the first one lacked
+	copyPayload := `{"event_id":"indexer-b-7","tx_hash":"` + strings.ToUpper(testEVMHash[2:]) + `","log_index":3,"block_number":12,"fee":"42",` + transfer + `}`
+	ingest(copyPayload)
+	ingest(copyPayload)
+	// Another log of the same transaction is a transfer of its own
+	ingest(`{"event_id":"eth:` + testEVMHash + `:log4","tx_hash":"` + testEVMHash + `",` + transfer + `}`)
+
+	events := store.GetRecent(EventFilter{})
+	if len(events) != 2 {
+		t.Fatalf("stored %d events, want 2", len(events))
+	}
+	kept, ok := store.GetByID("eth:" + testEVMHash + ":log3")
+	if !ok || kept.BlockNumber == nil || *kept.BlockNumber != 12 || kept.Fee != "42" || kept.LogIndex == nil || *kept.LogIndex != 3 {
+		t.Fatalf("kept event = %+v, want the copy's block, fee and log index merged in", kept)
+	}
+	if _, ok := store.GetByID("indexer-b-7"); ok {
+		t.Fatal("duplicate was stored")
+	}
+
+	rec := httptest.NewRecorder()
+	metricsHandler(metricSources{dedup: dedup}, rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{"tracker_dedup_duplicates_total 2", "tracker_dedup_merged_total 1"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("metrics missing %q:\n%s", want, rec.Body.String())
+		}
+	}
+}
+
+func TestSQLiteRepositoryKeepsOneCopyOfATransfer(t *testing.T) {
+	ctx := context.Background()
+	repo, err := OpenSQLiteRepository(ctx, filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer repo.Close()
+
+	index := uint64(1)
+	first := makeEvent("a", aliceAddr, bobAddr, "5", "2025-10-14T10:00:00Z", "USDC")
+	first.TxHash, first.LogIndex = testEVMHash, &index
+	second := makeEvent("b", aliceAddr, bobAddr, "5", "2025-10-14T10:00:00Z", "USDC")
+	second.TxHash, second.LogIndex = testEVMHash, &index
+	if err := repo.InsertBatch(ctx, []*Event{first, second}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if n, err := repo.Count(ctx, nil, EventFilter{}); err != nil || n != 1 {
+		t.Fatalf("count = %d, %v; want 1", n, err)
+	}
+
+	second.Fee = "42"
+	merged, changed := mergeDuplicate(first, second)
+	if !changed {
+		t.Fatal("expected the fee to be merged")
+	}
+	if err := repo.UpdateMetadata(ctx, merged); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	got, ok, err := repo.ByID(ctx, "a")
+	if err != nil || !ok || got.Fee != "42" || got.LogIndex == nil || *got.LogIndex != 1 {
+		t.Fatalf("stored event = %+v, %v", got, err)
+	}
+}
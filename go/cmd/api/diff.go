@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Sources of the events a diff compares.
+const (
+	// DiffRepository is the configured storage backend.
+	DiffRepository = "repository"
+	// DiffMemory is the in-memory cache in front of it.
+	DiffMemory = "memory"
+	// DiffSecondary is the backend configured with DIFF_STORAGE_BACKEND,
+	// such as the target of a migration.
+	DiffSecondary = "secondary"
+)
+
+const (
+	// maxDiffEvents caps the events a filter may match on each side.
+	maxDiffEvents = 50000
+	diffPageSize  = 1000
+	// defaultDiffLimit and maxDiffLimit bound the entries listed in each
+	// of added, removed and changed; the summary counts them all.
+	defaultDiffLimit = 100
+	maxDiffLimit     = 1000
+	// maxDiffSnapshots caps the results kept for later diffs; the oldest
+	// are forgotten first.
+	maxDiffSnapshots = 20
+	diffSnapshotTTL  = 24 * time.Hour
+)
+
+var errDiffSnapshotNotFound = errors.New("snapshot not found")
+
+// DiffSide is one side of a diff: a backend queried now, or the snapshot
+// of a result an earlier diff took. There is no way to query a backend as
+// of an earlier time; snapshots are the only past results.
+type DiffSide struct {
+	Backend  string `json:"backend,omitempty"`
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// DiffRequest is the body of POST /admin/diff. Params are the query
+// parameters of GET /transactions, or of GET /wallet/{address}/transactions
+// with Address; limit, offset and sorting are ignored, as every matching
+// event is compared. Tenant restricts the events to a tenant's.
+type DiffRequest struct {
+	Address string            `json:"address,omitempty"`
+	Params  map[string]string `json:"params,omitempty"`
+	Tenant  string            `json:"tenant,omitempty"`
+	Left    DiffSide          `json:"left"`
+	Right   DiffSide          `json:"right"`
+	Limit   int               `json:"limit,omitempty"`
+}
+
+// DiffRecord is what a diff compares of an event. Addresses are in
+// addressKey form, so the casing backends store them in does not count.
+type DiffRecord struct {
+	EventID      string `json:"event_id"`
+	Chain        string `json:"chain"`
+	Network      string `json:"network"`
+	TxHash       string `json:"tx_hash"`
+	Timestamp    string `json:"timestamp"`
+	From         string `json:"from"`
+	To           string `json:"to"`
+	Value        string `json:"value"`
+	Token        string `json:"token,omitempty"`
+	TokenAddress string `json:"token_address,omitempty"`
+	Status       string `json:"status,omitempty"`
+	// Height is the block number, or the slot on Solana.
+	Height       string `json:"height,omitempty"`
+	Fee          string `json:"fee,omitempty"`
+	Tenant       string `json:"tenant,omitempty"`
+	Source       string `json:"source,omitempty"`
+	SupersededBy string `json:"superseded_by,omitempty"`
+}
+
+func diffRecordOf(ev *Event) DiffRecord {
+	r := DiffRecord{
+		EventID: ev.EventID, Chain: ev.Chain, Network: ev.Network, TxHash: ev.TxHash, Timestamp: ev.Timestamp,
+		From: addressKey(ev.From), To: addressKey(ev.To), Value: ev.Value, Status: ev.Status,
+		Fee: ev.Fee, Tenant: ev.Tenant, Source: ev.Source, SupersededBy: ev.SupersededBy,
+	}
+	if ev.Token != nil {
+		r.Token, r.TokenAddress = ev.Token.Symbol, addressKey(ev.Token.Address)
+	}
+	if ev.BlockNumber != nil {
+		r.Height = strconv.FormatUint(*ev.BlockNumber, 10)
+	} else if ev.Slot != nil {
+		r.Height = strconv.FormatUint(*ev.Slot, 10)
+	}
+	return r
+}
+
+// fields lists the compared fields of r by name.
+func (r DiffRecord) fields() [][2]string {
+	return [][2]string{
+		{"chain", r.Chain}, {"network", r.Network}, {"tx_hash", r.TxHash}, {"timestamp", r.Timestamp},
+		{"from", r.From}, {"to", r.To}, {"value", r.Value}, {"token", r.Token}, {"token_address", r.TokenAddress},
+		{"status", r.Status}, {"height", r.Height}, {"fee", r.Fee}, {"tenant", r.Tenant}, {"source", r.Source},
+		{"superseded_by", r.SupersededBy},
+	}
+}
+
+// DiffValues are the values of a field on the left and right sides.
+type DiffValues struct {
+	Left  string `json:"left"`
+	Right string `json:"right"`
+}
+
+// DiffChange is an event on both sides whose fields differ.
+type DiffChange struct {
+	EventID string                `json:"event_id"`
+	Fields  map[string]DiffValues `json:"fields"`
+}
+
+// DiffSideResult describes a compared side. Snapshot identifies its
+// result for later diffs, such as after a backfill.
+type DiffSideResult struct {
+	Backend  string `json:"backend"`
+	Snapshot string `json:"snapshot"`
+	TakenAt  string `json:"taken_at"`
+	Events   int    `json:"events"`
+}
+
+// DiffSummary counts the events of each kind.
+type DiffSummary struct {
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Changed   int `json:"changed"`
+	Unchanged int `json:"unchanged"`
+}
+
+// DiffResult lists the events only on the right side (added), only on the
+// left side (removed) and on both with different fields (changed), by
+// event_id.
+type DiffResult struct {
+	Left    DiffSideResult `json:"left"`
+	Right   DiffSideResult `json:"right"`
+	Summary DiffSummary    `json:"summary"`
+	Added   []DiffRecord   `json:"added"`
+	Removed []DiffRecord   `json:"removed"`
+	Changed []DiffChange   `json:"changed"`
+	// Truncated is set when a list holds fewer entries than the summary
+	// counts.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// diffSnapshot is the result of a filter on a backend when a diff queried
+// it.
+type diffSnapshot struct {
+	id      string
+	backend string
+	// query identifies the filter the result is of.
+	query   string
+	takenAt time.Time
+	records map[string]DiffRecord
+}
+
+func (s *diffSnapshot) side() DiffSideResult {
+	return DiffSideResult{Backend: s.backend, Snapshot: s.id, TakenAt: s.takenAt.UTC().Format(time.RFC3339), Events: len(s.records)}
+}
+
+// ResultDiffs compares the results of a filter on two backends, or on a
+// backend and a snapshot: every result compared is kept in memory, for
+// diffSnapshotTTL and at most maxDiffSnapshots of them, as a snapshot a
+// later diff with the same filter can compare against.
+type ResultDiffs struct {
+	secondary        EventRepository
+	secondaryBackend string
+	now              func() time.Time
+
+	mu        sync.Mutex
+	snapshots map[string]*diffSnapshot
+	order     []string
+}
+
+// NewResultDiffs returns an empty snapshot store without a secondary
+// backend.
+func NewResultDiffs() *ResultDiffs {
+	return &ResultDiffs{now: time.Now, snapshots: make(map[string]*diffSnapshot)}
+}
+
+// AttachSecondary makes repo, a backend of kind backend, the secondary
+// side of diffs.
+func (d *ResultDiffs) AttachSecondary(repo EventRepository, backend string) {
+	d.secondary, d.secondaryBackend = repo, backend
+}
+
+// snapshot returns the snapshot id while it has not expired.
+func (d *ResultDiffs) snapshot(id string) (*diffSnapshot, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.snapshots[id]
+	if !ok || d.now().Sub(s.takenAt) > diffSnapshotTTL {
+		return nil, false
+	}
+	return s, true
+}
+
+// keep stores s, forgetting expired snapshots and the oldest beyond the
+// cap.
+func (d *ResultDiffs) keep(s *diffSnapshot) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	kept := d.order[:0]
+	for _, id := range d.order {
+		if d.now().Sub(d.snapshots[id].takenAt) > diffSnapshotTTL {
+			delete(d.snapshots, id)
+			continue
+		}
+		kept = append(kept, id)
+	}
+	d.order = append(kept, s.id)
+	d.snapshots[s.id] = s
+	for len(d.order) > maxDiffSnapshots {
+		delete(d.snapshots, d.order[0])
+		d.order = d.order[1:]
+	}
+}
+
+// diffQuery is a request's filter, parsed like the equivalent listing.
+type diffQuery struct {
+	key     string
+	address string
+	filter  EventFilter
+}
+
+func parseDiffQuery(ctx context.Context, req DiffRequest) (diffQuery, error) {
+	r, err := jobRequest(ctx, QueryRequest{Address: req.Address, Params: req.Params, Tenant: req.Tenant})
+	if err != nil {
+		return diffQuery{}, err
+	}
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		return diffQuery{}, err
+	}
+	q := diffQuery{filter: filter}
+	if req.Address != "" {
+		if q.address, err = pathAddress(r, filter.Chain); err != nil {
+			return diffQuery{}, err
+		}
+	}
+	values := url.Values{}
+	for k, v := range req.Params {
+		switch k {
+		case "limit", "offset", "sort_by", "sort_order", "include_total":
+		default:
+			values.Set(k, v)
+		}
+	}
+	q.key = addressKey(q.address) + "?" + values.Encode() + "#" + req.Tenant
+	// Every matching event is read, oldest first so events stored during
+	// the diff do not shift the pages still to read
+	q.filter.SortBy, q.filter.SortOrder, q.filter.IncludeTotal = SortTimestamp, "asc", false
+	return q, nil
+}
+
+// fetch reads every event q matches from repo.
+func (q diffQuery) fetch(ctx context.Context, repo EventRepository) (map[string]DiffRecord, error) {
+	records := make(map[string]DiffRecord)
+	filter := q.filter
+	for filter.Offset = 0; ; filter.Offset += diffPageSize {
+		filter.Limit = diffPageSize
+		var page []*Event
+		var err error
+		if q.address != "" {
+			page, err = repo.ByWallet(ctx, q.address, filter)
+		} else {
+			page, err = repo.Recent(ctx, filter)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range page {
+			records[ev.EventID] = diffRecordOf(ev)
+		}
+		if len(records) > maxDiffEvents {
+			return nil, invalidParam("params", "the filter matches more than %d events; narrow it, e.g. with start_time and end_time", maxDiffEvents)
+		}
+		if len(page) < diffPageSize {
+			return records, nil
+		}
+	}
+}
+
+// resolve returns the snapshot of a side: the one named, or a new one of
+// the backend's current result.
+func (d *ResultDiffs) resolve(ctx context.Context, store *EventStore, side DiffSide, q diffQuery, field string) (*diffSnapshot, error) {
+	if side.Snapshot != "" {
+		if side.Backend != "" {
+			return nil, invalidParam(field, "%s takes a backend or a snapshot, not both", field)
+		}
+		s, ok := d.snapshot(side.Snapshot)
+		if !ok {
+			return nil, errDiffSnapshotNotFound
+		}
+		if s.query != q.key {
+			return nil, invalidParam(field, "snapshot %s is of another filter", s.id)
+		}
+		return s, nil
+	}
+	var repo EventRepository
+	backend := side.Backend
+	switch side.Backend {
+	case DiffRepository:
+		repo = store.repo
+		backend = backendName(store.repo)
+	case DiffMemory:
+		repo = store.cache
+		backend = BackendMemory
+	case DiffSecondary:
+		repo = d.secondary
+		backend = DiffSecondary + ":" + d.secondaryBackend
+	default:
+		return nil, invalidParam(field, "%s.backend must be %s, %s or %s, or %s.snapshot set", field, DiffRepository, DiffMemory, DiffSecondary, field)
+	}
+	if repo == nil {
+		return nil, invalidParam(field, "no %s backend is configured", side.Backend)
+	}
+	taken := d.now()
+	records, err := q.fetch(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	s := &diffSnapshot{id: newRandomID(), backend: backend, query: q.key, takenAt: taken, records: records}
+	d.keep(s)
+	return s, nil
+}
+
+// Diff compares the two sides of req.
+func (d *ResultDiffs) Diff(ctx context.Context, store *EventStore, req DiffRequest) (DiffResult, error) {
+	if req.Limit == 0 {
+		req.Limit = defaultDiffLimit
+	}
+	if req.Limit < 1 || req.Limit > maxDiffLimit {
+		return DiffResult{}, invalidParam("limit", "limit must be between 1 and %d", maxDiffLimit)
+	}
+	q, err := parseDiffQuery(ctx, req)
+	if err != nil {
+		return DiffResult{}, err
+	}
+	left, err := d.resolve(ctx, store, req.Left, q, "left")
+	if err != nil {
+		return DiffResult{}, err
+	}
+	right, err := d.resolve(ctx, store, req.Right, q, "right")
+	if err != nil {
+		return DiffResult{}, err
+	}
+	return diffSnapshots(left, right, req.Limit), nil
+}
+
+// diffSnapshots lists what changed from left to right, at most limit
+// entries of each kind, by event_id.
+func diffSnapshots(left, right *diffSnapshot, limit int) DiffResult {
+	res := DiffResult{Left: left.side(), Right: right.side(), Added: []DiffRecord{}, Removed: []DiffRecord{}, Changed: []DiffChange{}}
+	for id, r := range right.records {
+		if _, ok := left.records[id]; !ok {
+			res.Summary.Added++
+			res.Added = append(res.Added, r)
+		}
+	}
+	for id, l := range left.records {
+		r, ok := right.records[id]
+		if !ok {
+			res.Summary.Removed++
+			res.Removed = append(res.Removed, l)
+			continue
+		}
+		lf, rf := l.fields(), r.fields()
+		fields := make(map[string]DiffValues)
+		for i := range lf {
+			if lf[i][1] != rf[i][1] {
+				fields[lf[i][0]] = DiffValues{Left: lf[i][1], Right: rf[i][1]}
+			}
+		}
+		if len(fields) == 0 {
+			res.Summary.Unchanged++
+			continue
+		}
+		res.Summary.Changed++
+		res.Changed = append(res.Changed, DiffChange{EventID: id, Fields: fields})
+	}
+	sort.Slice(res.Added, func(i, j int) bool { return res.Added[i].EventID < res.Added[j].EventID })
+	sort.Slice(res.Removed, func(i, j int) bool { return res.Removed[i].EventID < res.Removed[j].EventID })
+	sort.Slice(res.Changed, func(i, j int) bool { return res.Changed[i].EventID < res.Changed[j].EventID })
+	if len(res.Added) > limit || len(res.Removed) > limit || len(res.Changed) > limit {
+		res.Truncated = true
+	}
+	if len(res.Added) > limit {
+		res.Added = res.Added[:limit]
+	}
+	if len(res.Removed) > limit {
+		res.Removed = res.Removed[:limit]
+	}
+	if len(res.Changed) > limit {
+		res.Changed = res.Changed[:limit]
+	}
+	return res
+}
+
+// diffResults handles POST /admin/diff, comparing the result of a filter
+// on two backends, or on one backend now and at an earlier snapshot.
+func diffResults(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	var req DiffRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	res, err := store.diffs.Diff(r.Context(), store, req)
+	var fieldErr *FieldError
+	switch {
+	case errors.As(err, &fieldErr):
+		badRequest(w, err)
+		return
+	case errors.Is(err, errDiffSnapshotNotFound):
+		httpError(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		log.WithError(err).Error("result diff failed")
+		httpError(w, fmt.Sprintf("diff failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.WithFields(log.Fields{"left": res.Left.Backend, "right": res.Right.Backend, "added": res.Summary.Added,
+		"removed": res.Summary.Removed, "changed": res.Summary.Changed}).Info("admin: results diffed")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
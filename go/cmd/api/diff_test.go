@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestResultDiff(t *testing.T) {
+	store := NewEventStore(100, 50)
+	secondary := NewMemoryRepository(100, 50)
+	store.diffs.AttachSecondary(secondary, BackendPostgres)
+	ts := time.Now().Add(-time.Hour).UTC()
+	event := func(id, status string, at time.Duration) *Event {
+		ev := makeEvent(id, aliceAddr, bobAddr, "1000000", ts.Add(at).Format(time.RFC3339), "USDC")
+		ev.Status = status
+		return ev
+	}
+	store.Add(event("removed", StatusConfirmed, 0))
+	store.Add(event("changed", StatusConfirmed, time.Minute))
+	store.Add(event("same", StatusConfirmed, 2*time.Minute))
+	store.Add(makeEvent("sol", wrappedSOL, wrappedSOL, "5", ts.Format(time.RFC3339), ""))
+	for _, ev := range []*Event{event("changed", StatusFinalized, time.Minute), event("same", StatusConfirmed, 2*time.Minute), event("added", StatusConfirmed, 3*time.Minute)} {
+		if err := secondary.Insert(context.Background(), ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := chi.NewRouter()
+	mountAdmin(router, "s3cret", store, NewChainRegistry(nil, nil), nil, nil)
+	diff := func(body string) (*httptest.ResponseRecorder, DiffResult) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/admin/diff", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var res DiffResult
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		}
+		return rec, res
+	}
+
+	rec, res := diff(`{"params": {"chain": "ethereum", "limit": "1"}, "left": {"backend": "memory"}, "right": {"backend": "secondary"}}`)
+	if rec.Code != http.StatusOK || res.Summary != (DiffSummary{Added: 1, Removed: 1, Changed: 1, Unchanged: 1}) ||
+		res.Added[0].EventID != "added" || res.Removed[0].EventID != "removed" || res.Truncated {
+		t.Fatalf("diff: %d %s %+v", rec.Code, rec.Body, res)
+	}
+	if c := res.Changed[0]; c.EventID != "changed" || len(c.Fields) != 1 || c.Fields["status"] != (DiffValues{Left: StatusConfirmed, Right: StatusFinalized}) {
+		t.Fatalf("changed = %+v", res.Changed)
+	}
+	if res.Left.Backend != BackendMemory || res.Left.Events != 3 || res.Right.Backend != "secondary:postgres" || res.Left.Snapshot == "" {
+		t.Fatalf("sides = %+v %+v", res.Left, res.Right)
+	}
+
+	// A snapshot is compared with the same backend later, e.g. after a
+	// backfill
+	store.Add(event("backfilled", StatusConfirmed, -time.Hour))
+	rec, later := diff(`{"params": {"chain": "ethereum"}, "left": {"snapshot": "` + res.Left.Snapshot + `"}, "right": {"backend": "memory"}, "limit": 1}`)
+	if rec.Code != http.StatusOK || later.Summary != (DiffSummary{Added: 1, Unchanged: 3}) || later.Added[0].EventID != "backfilled" || later.Left.Snapshot != res.Left.Snapshot {
+		t.Fatalf("diff with snapshot: %d %s %+v", rec.Code, rec.Body, later)
+	}
+	if _, all := diff(`{"left": {"backend": "memory"}, "right": {"backend": "secondary"}, "limit": 1}`); all.Summary.Removed != 3 || len(all.Removed) != 1 || !all.Truncated {
+		t.Fatalf("truncated diff = %+v", all)
+	}
+
+	for body, want := range map[string]int{
+		`{"params": {"chain": "solana"}, "left": {"snapshot": "` + res.Left.Snapshot + `"}, "right": {"backend": "memory"}}`: http.StatusBadRequest,
+		`{"left": {"snapshot": "unknown"}, "right": {"backend": "memory"}}`:                                                  http.StatusNotFound,
+		`{"left": {"backend": "repository"}, "right": {"backend": "memory"}}`:                                                http.StatusBadRequest,
+		`{"left": {"backend": "memory"}, "right": {"backend": "mysql"}}`:                                                     http.StatusBadRequest,
+		`{"params": {"start_time": "yesterday"}, "left": {"backend": "memory"}, "right": {"backend": "memory"}}`:             http.StatusBadRequest,
+		`{"address": "0x12", "left": {"backend": "memory"}, "right": {"backend": "memory"}}`:                                 http.StatusBadRequest,
+		`{"left": {"backend": "memory"}, "right": {"backend": "memory"}, "limit": 5000}`:                                     http.StatusBadRequest,
+		`{"left": {"backend": "memory"}`: http.StatusBadRequest,
+	} {
+		if rec, _ := diff(body); rec.Code != want {
+			t.Errorf("diff %s: %d %s, want %d", body, rec.Code, rec.Body, want)
+		}
+	}
+	if _, wallet := diff(`{"address": "` + strings.ToLower(bobAddr) + `", "left": {"backend": "memory"}, "right": {"backend": "secondary"}}`); wallet.Summary.Removed != 2 {
+		t.Fatalf("wallet diff = %+v", wallet)
+	}
+}
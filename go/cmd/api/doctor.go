@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// doctorTimeout bounds each connectivity check of `api doctor`.
+const doctorTimeout = 10 * time.Second
+
+// Outcomes of a doctor check. A warning does not fail the run.
+const (
+	doctorOK   = "ok"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+)
+
+// doctorCheck is one line of the doctor's report.
+type doctorCheck struct {
+	Name, Status, Detail string
+}
+
+// doctorReport collects the outcome of every check.
+type doctorReport struct {
+	checks []doctorCheck
+}
+
+func (r *doctorReport) add(name, status, format string, args ...interface{}) {
+	r.checks = append(r.checks, doctorCheck{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Failed reports whether any check failed.
+func (r *doctorReport) Failed() bool {
+	for _, c := range r.checks {
+		if c.Status == doctorFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Write prints one line per check and a summary.
+func (r *doctorReport) Write(out io.Writer) {
+	width := 0
+	for _, c := range r.checks {
+		if len(c.Name) > width {
+			width = len(c.Name)
+		}
+	}
+	counts := make(map[string]int)
+	for _, c := range r.checks {
+		counts[c.Status]++
+		fmt.Fprintf(out, "%-6s %-*s  %s\n", "["+c.Status+"]", width, c.Name, c.Detail)
+	}
+	fmt.Fprintf(out, "\n%d ok, %d warnings, %d failed\n", counts[doctorOK], counts[doctorWarn], counts[doctorFail])
+}
+
+// runDoctor implements `api doctor`: it validates the configuration the
+// API would start with, connects to Redis, the storage backend, the RPC
+// providers and the Kafka REST Proxy, checks the schema version and the
+// indexes queries rely on, and prints a report to out. Nothing is written:
+// pending migrations are reported, not applied. It returns the exit code,
+// 1 when a check failed.
+func runDoctor(ctx context.Context, out io.Writer) int {
+	r := &doctorReport{}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if _, err := LoadConfigFile(path); err != nil {
+			r.add("config CONFIG_FILE", doctorFail, "%v", err)
+		} else {
+			r.add("config CONFIG_FILE", doctorOK, "loaded %s", path)
+		}
+	}
+	chains := doctorConfig(r)
+	doctorRedis(ctx, r, os.Getenv("REDIS_URL"))
+	doctorStorage(ctx, r, RepositoryConfigFromEnv())
+	if chains != nil {
+		doctorRPC(ctx, r, chains)
+		doctorEventSource(ctx, r, chains)
+	}
+	r.Write(out)
+	if r.Failed() {
+		return 1
+	}
+	return 0
+}
+
+// doctorConfig validates the settings the API refuses to start with, and
+// returns the chain registry they describe, or nil when NETWORKS or
+// RPC_URLS is invalid.
+func doctorConfig(r *doctorReport) *ChainRegistry {
+	valid := 0
+	check := func(name string, err error) {
+		if err != nil {
+			r.add("config "+name, doctorFail, "%v", err)
+			return
+		}
+		valid++
+	}
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		check("REDIS_URL", fmt.Errorf("must be set"))
+	} else {
+		_, err := redis.ParseURL(redisURL)
+		check("REDIS_URL", err)
+	}
+	repoCfg := RepositoryConfigFromEnv()
+	switch repoCfg.Backend {
+	case BackendMemory, BackendSQLite:
+		check("STORAGE_BACKEND", nil)
+	case BackendPostgres, BackendTimescale, BackendPartitioned:
+		if repoCfg.PostgresDSN == "" {
+			check("POSTGRES_DSN", fmt.Errorf("must be set for the %s backend", repoCfg.Backend))
+		} else {
+			_, err := pgxpool.ParseConfig(repoCfg.PostgresDSN)
+			check("POSTGRES_DSN", err)
+		}
+	default:
+		check("STORAGE_BACKEND", fmt.Errorf("unknown storage backend %q", repoCfg.Backend))
+	}
+	chainIDs, err := ParseChainIDs(os.Getenv("CHAIN_IDS"))
+	check("CHAIN_IDS", err)
+	SetChainIDs(chainIDs)
+	networks, networksErr := ParseNetworkAllowlist(os.Getenv("NETWORKS"))
+	check("NETWORKS", networksErr)
+	endpoints, rpcErr := ParseRPCURLs(os.Getenv("RPC_URLS"))
+	check("RPC_URLS", rpcErr)
+	if spec := os.Getenv("EXPLORER_URLS"); spec != "" {
+		_, err := ParseExplorerURLs(spec)
+		check("EXPLORER_URLS", err)
+	}
+	_, err = ParsePrices(os.Getenv("USD_PRICES"))
+	check("USD_PRICES", err)
+	_, err = ParseTenants(os.Getenv("TENANT_API_KEYS"), os.Getenv("TENANT_WALLETS"))
+	check("TENANT_API_KEYS", err)
+	_, err = ParseSequenceWallets(os.Getenv("SEQUENCE_WALLETS"))
+	check("SEQUENCE_WALLETS", err)
+	_, err = ParseOverflowPolicy(os.Getenv("HUB_QUEUE_OVERFLOW"))
+	check("HUB_QUEUE_OVERFLOW", err)
+	_, err = ParseSigningKey(os.Getenv("SIGNING_KEY"))
+	check("SIGNING_KEY", err)
+	_, err = NewMailer(os.Getenv("SMTP_URL"), os.Getenv("SMTP_FROM"))
+	check("SMTP_URL", err)
+	_, _, err = rateLimitFromEnv()
+	check("RATE_LIMIT_RPS", err)
+	if path := os.Getenv("INGEST_MAPPINGS_FILE"); path != "" {
+		_, err := LoadIngestMappings(path)
+		check("INGEST_MAPPINGS_FILE", err)
+	}
+	if os.Getenv("HISTORY_IMPORT") == "true" {
+		_, err := ParseExplorerAPIs(os.Getenv("EXPLORER_API_URLS"), os.Getenv("EXPLORER_API_KEYS"),
+			float64(envInt("EXPLORER_API_RPS", defaultExplorerRPS)))
+		check("EXPLORER_API_KEYS", err)
+	}
+	if spec := os.Getenv("RETENTION_DAYS"); spec != "" {
+		_, err := ParseRetentionPolicies(spec)
+		check("RETENTION_DAYS", err)
+		_, err = NewS3Archive(ArchiveConfigFromEnv())
+		check("ARCHIVE_S3_BUCKET", err)
+		if repoCfg.Backend == BackendMemory || repoCfg.Backend == BackendSQLite {
+			check("RETENTION_DAYS", fmt.Errorf("needs the postgres, timescale or partitioned backend, not %s", repoCfg.Backend))
+		}
+	}
+	r.add("config", doctorOK, "%d settings valid", valid)
+	if networksErr != nil || rpcErr != nil {
+		return nil
+	}
+	return NewChainRegistry(networks, endpoints)
+}
+
+// doctorRedis pings Redis.
+func doctorRedis(ctx context.Context, r *doctorReport, redisURL string) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	rdb := redis.NewClient(opt)
+	defer rdb.Close()
+	start := time.Now()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		r.add("redis", doctorFail, "%s: %v", opt.Addr, err)
+		return
+	}
+	r.add("redis", doctorOK, "%s answered in %s", opt.Addr, time.Since(start).Round(time.Millisecond))
+}
+
+// doctorSchema describes where a backend records its migrations and the
+// indexes on events its queries rely on.
+type doctorSchema struct {
+	table      string
+	migrations []migration
+	indexes    []string
+}
+
+// doctorSchemas lists the schema of every durable backend.
+var doctorSchemas = map[string]doctorSchema{
+	BackendPostgres: {"schema_migrations", postgresMigrations, []string{
+		"idx_events_dedup_key", "idx_events_created", "idx_events_from", "idx_events_to", "idx_events_tx_hash_lower",
+		"idx_events_chain_height", "idx_events_tenant_created"}},
+	BackendTimescale: {"timescale_migrations", timescaleMigrations, []string{
+		"idx_events_event_id_created", "idx_events_dedup_key_created", "idx_events_from", "idx_events_to",
+		"idx_events_tx_hash_lower", "idx_events_chain_height", "idx_events_tenant_created"}},
+	BackendPartitioned: {"partitioned_migrations", partitionedMigrations, []string{
+		"idx_events_event_id_created", "idx_events_dedup_key_created", "idx_events_created", "idx_events_from",
+		"idx_events_to", "idx_events_tx_hash_lower", "idx_events_chain_height", "idx_events_tenant_created"}},
+	BackendSQLite: {"schema_migrations", sqliteMigrations, []string{
+		"idx_events_dedup_key", "idx_events_created", "idx_events_from", "idx_events_to", "idx_events_tx_hash_lower",
+		"idx_events_chain_height", "idx_events_tenant_created"}},
+}
+
+// doctorStorage connects to the storage backend and checks its schema.
+func doctorStorage(ctx context.Context, r *doctorReport, cfg RepositoryConfig) {
+	schema, ok := doctorSchemas[cfg.Backend]
+	if !ok {
+		if cfg.Backend == BackendMemory {
+			r.add("storage", doctorWarn, "no durable backend; events are kept in memory only")
+		}
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	var applied map[int]bool
+	var indexes map[string]bool
+	var err error
+	if cfg.Backend == BackendSQLite {
+		if _, statErr := os.Stat(cfg.SQLitePath); os.IsNotExist(statErr) {
+			r.add("storage", doctorWarn, "sqlite: %s does not exist yet; it is created on start", cfg.SQLitePath)
+			return
+		}
+		applied, indexes, err = sqliteSchema(ctx, cfg.SQLitePath, schema.table)
+	} else {
+		if cfg.PostgresDSN == "" {
+			return
+		}
+		applied, indexes, err = postgresSchema(ctx, cfg.PostgresDSN, schema.table)
+	}
+	if err != nil {
+		r.add("storage", doctorFail, "%s: %v", cfg.Backend, err)
+		return
+	}
+	r.add("storage", doctorOK, "connected to the %s backend", cfg.Backend)
+	checkSchema(r, schema, applied, indexes)
+}
+
+// checkSchema reports the schema version against this build's migrations
+// and the required indexes that are missing. A database never migrated,
+// or with migrations pending, is migrated on start; missing indexes once
+// every migration ran mean the schema was changed by hand.
+func checkSchema(r *doctorReport, schema doctorSchema, applied map[int]bool, indexes map[string]bool) {
+	latest := len(schema.migrations)
+	version, pending := 0, 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	for _, m := range schema.migrations {
+		if !applied[m.Version] {
+			pending++
+		}
+	}
+	switch {
+	case len(applied) == 0:
+		r.add("schema", doctorWarn, "not migrated yet; the %d migrations are applied on start", latest)
+		return
+	case version > latest:
+		r.add("schema", doctorWarn, "version %d is newer than this build (%d); migrations only add, so it keeps working", version, latest)
+	case pending > 0:
+		r.add("schema", doctorWarn, "version %d, %d migrations pending; they are applied on start", version, pending)
+		return
+	default:
+		r.add("schema", doctorOK, "version %d, up to date", version)
+	}
+	var missing []string
+	for _, name := range schema.indexes {
+		if !indexes[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		r.add("indexes", doctorFail, "missing on events: %s", strings.Join(missing, ", "))
+		return
+	}
+	r.add("indexes", doctorOK, "%d required indexes present", len(schema.indexes))
+}
+
+// postgresSchema reads the applied migrations from table and the indexes
+// of events, without creating either.
+func postgresSchema(ctx context.Context, dsn, table string) (map[int]bool, map[string]bool, error) {
+	db, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+	if err := db.Ping(ctx); err != nil {
+		return nil, nil, err
+	}
+	applied := make(map[int]bool)
+	var exists bool
+	if err := db.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists); err != nil {
+		return nil, nil, err
+	}
+	if exists {
+		rows, err := db.Query(ctx, `SELECT version FROM `+table)
+		if err != nil {
+			return nil, nil, err
+		}
+		for rows.Next() {
+			var v int
+			if err := rows.Scan(&v); err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+			applied[v] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+	indexes := make(map[string]bool)
+	rows, err := db.Query(ctx, `SELECT indexname FROM pg_indexes WHERE schemaname = current_schema() AND tablename = 'events'`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, nil, err
+		}
+		indexes[name] = true
+	}
+	return applied, indexes, rows.Err()
+}
+
+// sqliteSchema is postgresSchema for a SQLite file, opened read-only.
+func sqliteSchema(ctx context.Context, path, table string) (map[int]bool, map[string]bool, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+	names := func(query string) ([]string, error) {
+		rows, err := db.QueryContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var out []string
+		for rows.Next() {
+			var s string
+			if err := rows.Scan(&s); err != nil {
+				return nil, err
+			}
+			out = append(out, s)
+		}
+		return out, rows.Err()
+	}
+	tables, err := names(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = '` + table + `'`)
+	if err != nil {
+		return nil, nil, err
+	}
+	applied := make(map[int]bool)
+	if len(tables) > 0 {
+		versions, err := names(`SELECT CAST(version AS TEXT) FROM ` + table)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, v := range versions {
+			var n int
+			if _, err := fmt.Sscan(v, &n); err == nil {
+				applied[n] = true
+			}
+		}
+	}
+	indexNames, err := names(`SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'events'`)
+	if err != nil {
+		return nil, nil, err
+	}
+	indexes := make(map[string]bool, len(indexNames))
+	for _, name := range indexNames {
+		indexes[name] = true
+	}
+	return applied, indexes, nil
+}
+
+// doctorRPC probes every configured RPC provider once with the head
+// request the RPC manager benchmarks them with. A network whose providers
+// all fail is a failure; a failing provider next to a healthy one is a
+// warning.
+func doctorRPC(ctx context.Context, r *doctorReport, chains *ChainRegistry) {
+	rpc := NewRPCManager(chains, 0)
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	rpc.probeAll(ctx)
+	probed := 0
+	for _, cs := range rpc.Status() {
+		if len(cs.Providers) == 0 {
+			continue
+		}
+		name := "rpc " + cs.Chain + ":" + cs.Network
+		var healthy, failing []string
+		for _, p := range cs.Providers {
+			probed++
+			if p.Errors > 0 {
+				failing = append(failing, p.Provider+": "+p.LastError)
+			} else {
+				healthy = append(healthy, fmt.Sprintf("%s at height %d in %.0fms", p.Provider, p.Height, p.LastLatencyMS))
+			}
+		}
+		switch {
+		case len(healthy) == 0:
+			r.add(name, doctorFail, "%s", strings.Join(failing, "; "))
+		case len(failing) > 0:
+			r.add(name, doctorWarn, "%s; failing: %s", strings.Join(healthy, ", "), strings.Join(failing, "; "))
+		default:
+			r.add(name, doctorOK, "%s", strings.Join(healthy, ", "))
+		}
+	}
+	if probed == 0 {
+		r.add("rpc", doctorWarn, "no RPC providers configured; raw transactions, sequence checks and RPC history imports are off")
+	}
+}
+
+// doctorEventSource checks that the Kafka REST Proxy answers and has the
+// configured topics. The Redis source needs nothing beyond Redis.
+func doctorEventSource(ctx context.Context, r *doctorReport, chains *ChainRegistry) {
+	source, err := EventSourceFromEnv(os.Getenv("REDIS_URL"), chains, NewEventStore(1, 1))
+	if err != nil {
+		r.add("event source", doctorFail, "%v", err)
+		return
+	}
+	kafka, ok := source.(*KafkaSource)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	var topics []string
+	if err := kafka.do(ctx, http.MethodGet, kafka.baseURL+"/topics", nil, "", &topics); err != nil {
+		r.add("kafka", doctorFail, "%v", err)
+		return
+	}
+	have := make(map[string]bool, len(topics))
+	for _, t := range topics {
+		have[t] = true
+	}
+	var missing []string
+	for _, t := range kafka.topics {
+		if !have[t] {
+			missing = append(missing, t)
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		r.add("kafka", doctorFail, "topics not found: %s", strings.Join(missing, ", "))
+		return
+	}
+	r.add("kafka", doctorOK, "%s serves %s", kafka.baseURL, strings.Join(kafka.topics, ", "))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDoctorChecksSQLiteSchema(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.db")
+	repo, err := OpenSQLiteRepository(ctx, path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	repo.Close()
+
+	cfg := RepositoryConfig{Backend: BackendSQLite, SQLitePath: path}
+	r := &doctorReport{}
+	doctorStorage(ctx, r, cfg)
+	if r.Failed() || len(r.checks) != 3 || r.checks[1].Status != doctorOK || r.checks[2].Status != doctorOK {
+		t.Fatalf("checks on a migrated database = %+v", r.checks)
+	}
+
+	repo, err = OpenSQLiteRepository(ctx, path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if _, err := repo.db.ExecContext(ctx, `DROP INDEX idx_events_created`); err != nil {
+		t.Fatalf("drop index: %v", err)
+	}
+	repo.Close()
+	r = &doctorReport{}
+	doctorStorage(ctx, r, cfg)
+	if !r.Failed() || !strings.Contains(r.checks[len(r.checks)-1].Detail, "idx_events_created") {
+		t.Fatalf("checks without idx_events_created = %+v", r.checks)
+	}
+}
+
+func TestCheckSchemaReportsPendingMigrations(t *testing.T) {
+	schema := doctorSchemas[BackendSQLite]
+	r := &doctorReport{}
+	checkSchema(r, schema, map[int]bool{1: true}, nil)
+	// Pending migrations may add the missing indexes, so those are not checked
+	if r.Failed() || len(r.checks) != 1 || r.checks[0].Status != doctorWarn {
+		t.Fatalf("checks = %+v", r.checks)
+	}
+}
+
+func TestRunDoctorFailsOnInvalidConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("REDIS_URL", "")
+	t.Setenv("STORAGE_BACKEND", "memory")
+	t.Setenv("NETWORKS", "")
+	t.Setenv("RPC_URLS", "ethereum:mainnet="+srv.URL+",ethereum:mainnet=http://127.0.0.1:1")
+	t.Setenv("HUB_QUEUE_OVERFLOW", "block")
+	t.Setenv("EVENT_SOURCE", "")
+	var out bytes.Buffer
+	if code := runDoctor(context.Background(), &out); code != 1 {
+		t.Fatalf("exit code = %d, want 1:\n%s", code, out.String())
+	}
+	for _, want := range []string{"[fail] config REDIS_URL", "[fail] config HUB_QUEUE_OVERFLOW", "[warn] storage", "[warn] rpc ethereum:mainnet", "at height 16", "2 failed"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultEnrichmentTimeout = 2 * time.Second
+	// maxEnrichmentResponse bounds the body read from the enrichment
+	// service.
+	maxEnrichmentResponse = 1 << 20
+)
+
+// Enricher POSTs ingested events to an external enrichment service and
+// merges the annotations it returns into them, so teams can attach their
+// own risk scores or model labels without forking the pipeline.
+type Enricher struct {
+	url    string
+	client *http.Client
+}
+
+// enrichmentResponse is the body the enrichment service answers with.
+type enrichmentResponse struct {
+	Annotations map[string]json.RawMessage `json:"annotations"`
+}
+
+// NewEnricher creates an enricher for the service at url, waiting at most
+// timeout for each event. It returns nil when url is empty.
+func NewEnricher(url string, timeout time.Duration) *Enricher {
+	if url == "" {
+		return nil
+	}
+	return &Enricher{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// Annotate sends ev to the enrichment service and merges the returned
+// annotations into ev.Annotations, replacing annotations of the same name.
+// It reports whether any were merged. Failures are logged and leave ev
+// unchanged, so an unavailable service delays ingestion by at most the
+// timeout and never drops events.
+func (e *Enricher) Annotate(ctx context.Context, ev *Event) bool {
+	if e == nil {
+		return false
+	}
+	annotations, err := e.fetch(ctx, ev)
+	if err != nil {
+		log.WithError(err).WithField("event_id", ev.EventID).Warn("event enrichment failed")
+		return false
+	}
+	if len(annotations) == 0 {
+		return false
+	}
+	if ev.Annotations == nil {
+		ev.Annotations = make(map[string]json.RawMessage, len(annotations))
+	}
+	for name, value := range annotations {
+		ev.Annotations[name] = value
+	}
+	return true
+}
+
+func (e *Enricher) fetch(ctx context.Context, ev *Event) (map[string]json.RawMessage, error) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("enrichment service returned %s", resp.Status)
+	}
+	var body enrichmentResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxEnrichmentResponse)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode enrichment response: %w", err)
+	}
+	return body.Annotations, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEnricherDisabledWithoutURL(t *testing.T) {
+	e := NewEnricher("", time.Second)
+	if e != nil {
+		t.Fatal("expected nil enricher without url")
+	}
+	if e.Annotate(context.Background(), &Event{}) {
+		t.Fatal("nil enricher annotated an event")
+	}
+}
+
+func TestEnricherMergesAnnotations(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev Event
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil || ev.EventID != "1" {
+			t.Errorf("unexpected request %+v, %v", ev, err)
+		}
+		_, _ = w.Write([]byte(`{"annotations":{"risk_score":0.87,"ml_label":"mixer"}}`))
+	}))
+	defer srv.Close()
+
+	ev := makeEvent("1", aliceAddr, bobAddr, "1", time.Now().UTC().Format(time.RFC3339), "")
+	ev.Annotations = map[string]json.RawMessage{"risk_score": json.RawMessage(`0.1`), "team": json.RawMessage(`"ops"`)}
+	if !NewEnricher(srv.URL, time.Second).Annotate(context.Background(), ev) {
+		t.Fatal("expected annotations to be merged")
+	}
+	got, _ := json.Marshal(ev.Annotations)
+	if string(got) != `{"ml_label":"mixer","risk_score":0.87,"team":"ops"}` {
+		t.Fatalf("annotations = %s", got)
+	}
+}
+
+func TestEnricherFailsOpen(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"annotations":{"risk_score":1}}`))
+	}))
+	defer slow.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model unavailable", http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	for name, e := range map[string]*Enricher{
+		"timeout": NewEnricher(slow.URL, 20*time.Millisecond),
+		"error":   NewEnricher(failing.URL, time.Second),
+	} {
+		ev := makeEvent("1", aliceAddr, bobAddr, "1", time.Now().UTC().Format(time.RFC3339), "")
+		if e.Annotate(context.Background(), ev) || ev.Annotations != nil {
+			t.Errorf("%s: event was annotated: %+v", name, ev.Annotations)
+		}
+	}
+}
+
+func TestIngestStoresAnnotations(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"annotations":{"risk_score":0.5}}`))
+	}))
+	defer srv.Close()
+
+	store := NewEventStore(10, 10)
+	hub := NewHub()
+	go hub.Run()
+	allowAll, _ := ParseNetworkAllowlist("")
+	handle := ingestEvents(store, hub, allowAll, nil, nil, NewEnricher(srv.URL, time.Second), nil, nil, nil, nil)
+	payload := `{"event_id":"1","chain":"ethereum","network":"sepolia","from":"` + aliceAddr + `","to":"` + bobAddr + `","value":"1"}`
+	if err := handle(context.Background(), []byte(payload)); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	got := store.GetRecent(EventFilter{})
+	if len(got) != 1 || string(got[0].Annotations["risk_score"]) != "0.5" {
+		t.Fatalf("stored events = %+v", got)
+	}
+}
@@ -0,0 +1,667 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+// Channels an escalation step notifies.
+const (
+	EscalateSlack     = "slack"
+	EscalatePagerDuty = "pagerduty"
+)
+
+const (
+	maxEscalationSteps = 10
+	// maxEscalationDelay bounds how long after the alert a step may fire.
+	maxEscalationDelay = 24 * 60
+	// maxTrackedAlerts caps the alerts kept for escalation; the oldest are
+	// forgotten first.
+	maxTrackedAlerts = 1000
+	// escalationInterval is how often due steps are looked for.
+	escalationInterval = 15 * time.Second
+	// defaultPagerDutyURL is the PagerDuty Events API v2 endpoint.
+	defaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+)
+
+// escalationSchema creates the table escalation policies are persisted
+// in, keyed on the tenant and the alert rule.
+const escalationSchema = `
+	CREATE TABLE IF NOT EXISTS escalation_policies (
+		tenant TEXT NOT NULL DEFAULT '',
+		rule TEXT NOT NULL,
+		definition JSONB NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (tenant, rule)
+	);
+`
+
+// EscalationStep notifies a channel once an alert has gone unacknowledged
+// for AfterMinutes.
+type EscalationStep struct {
+	AfterMinutes int    `json:"after_minutes"`
+	Channel      string `json:"channel"`
+	// WebhookURL is the Slack incoming webhook of a slack step.
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// RoutingKey is the PagerDuty integration key of a pagerduty step.
+	RoutingKey string `json:"routing_key,omitempty"`
+}
+
+// EscalationPolicy escalates the alert.triggered events whose data.rule
+// is Rule through Steps until the alert is acknowledged.
+type EscalationPolicy struct {
+	Rule      string           `json:"rule"`
+	Steps     []EscalationStep `json:"steps"`
+	UpdatedAt string           `json:"updated_at,omitempty"`
+	// Tenant owns the policy; it escalates the tenant's alerts and the
+	// deployment-wide ones.
+	Tenant string `json:"-"`
+}
+
+// Validate normalizes the policy and checks its steps.
+func (p *EscalationPolicy) Validate() error {
+	p.Rule = strings.TrimSpace(p.Rule)
+	if p.Rule == "" || len(p.Rule) > 128 {
+		return invalidParam("rule", "rule must be 1 to 128 characters")
+	}
+	if len(p.Steps) == 0 || len(p.Steps) > maxEscalationSteps {
+		return invalidParam("steps", "a policy has 1 to %d steps", maxEscalationSteps)
+	}
+	for i := range p.Steps {
+		s := &p.Steps[i]
+		if s.AfterMinutes < 0 || s.AfterMinutes > maxEscalationDelay {
+			return invalidParam("steps", "steps[%d].after_minutes must be between 0 and %d", i, maxEscalationDelay)
+		}
+		if i > 0 && s.AfterMinutes < p.Steps[i-1].AfterMinutes {
+			return invalidParam("steps", "steps[%d].after_minutes is earlier than the previous step", i)
+		}
+		s.Channel = strings.ToLower(strings.TrimSpace(s.Channel))
+		s.WebhookURL, s.RoutingKey = strings.TrimSpace(s.WebhookURL), strings.TrimSpace(s.RoutingKey)
+		switch s.Channel {
+		case EscalateSlack:
+			if u, err := url.Parse(s.WebhookURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return invalidParam("steps", "steps[%d].webhook_url must be an http(s) URL", i)
+			}
+			s.RoutingKey = ""
+		case EscalatePagerDuty:
+			if s.RoutingKey == "" || len(s.RoutingKey) > 64 {
+				return invalidParam("steps", "steps[%d].routing_key must be 1 to 64 characters", i)
+			}
+			s.WebhookURL = ""
+		default:
+			return invalidParam("steps", "steps[%d].channel must be %s or %s", i, EscalateSlack, EscalatePagerDuty)
+		}
+	}
+	return nil
+}
+
+// matches reports whether ev is an alert p escalates.
+func (p EscalationPolicy) matches(ev SystemEvent) bool {
+	rule, _ := ev.Data["rule"].(string)
+	return ev.Kind == SystemAlertTriggered && rule == p.Rule && (ev.Tenant == "" || ev.Tenant == p.Tenant)
+}
+
+// EscalationNotice records a step that was sent.
+type EscalationNotice struct {
+	Step    int    `json:"step"`
+	Channel string `json:"channel"`
+	At      string `json:"at"`
+	Error   string `json:"error,omitempty"`
+}
+
+// AlertEscalation is the escalation state of one alert for one tenant.
+type AlertEscalation struct {
+	AlertID        string             `json:"alert_id"`
+	Rule           string             `json:"rule"`
+	Severity       string             `json:"severity"`
+	Message        string             `json:"message,omitempty"`
+	TriggeredAt    string             `json:"triggered_at"`
+	Notified       []EscalationNotice `json:"notified"`
+	NextStepAt     string             `json:"next_step_at,omitempty"`
+	AcknowledgedAt string             `json:"acknowledged_at,omitempty"`
+
+	tenant    string
+	alert     SystemEvent
+	steps     []EscalationStep
+	triggered time.Time
+	next      int
+}
+
+// dueAt returns when the next step fires, and false when none is left.
+func (a *AlertEscalation) dueAt() (time.Time, bool) {
+	if a.AcknowledgedAt != "" || a.next >= len(a.steps) {
+		return time.Time{}, false
+	}
+	return a.triggered.Add(time.Duration(a.steps[a.next].AfterMinutes) * time.Minute), true
+}
+
+// view returns a copy of a for responses.
+func (a *AlertEscalation) view() AlertEscalation {
+	out := *a
+	out.Notified = append([]EscalationNotice{}, a.Notified...)
+	if due, ok := a.dueAt(); ok {
+		out.NextStepAt = due.UTC().Format(time.RFC3339)
+	}
+	return out
+}
+
+// escalationKey keys policies on their tenant and rule, and escalations
+// on their tenant and alert ID.
+type escalationKey struct{ tenant, id string }
+
+// escalationSend is a notification due to a channel.
+type escalationSend struct {
+	key    escalationKey
+	step   int
+	target EscalationStep
+	alert  SystemEvent
+	action string
+	after  int
+}
+
+// Escalator escalates alerts through the tenants' escalation policies:
+// each step of a policy notifies Slack or PagerDuty once the alert has
+// gone unacknowledged for its delay. Policies are persisted to the
+// escalation_policies table when a database is attached; the alerts being
+// escalated are kept in memory.
+type Escalator struct {
+	mu       sync.Mutex
+	policies map[escalationKey]EscalationPolicy
+	alerts   map[escalationKey]*AlertEscalation
+	// order lists the tracked alerts oldest first, for eviction.
+	order []escalationKey
+	// acks are PagerDuty acknowledgements waiting to be sent.
+	acks []escalationSend
+	db   *pgxpool.Pool
+
+	client       *http.Client
+	pagerDutyURL string
+	backoff      time.Duration
+	wake         chan struct{}
+}
+
+// NewEscalator creates an escalator sending PagerDuty events to
+// pagerDutyURL, or to the Events API v2 when it is empty.
+func NewEscalator(pagerDutyURL string) *Escalator {
+	if pagerDutyURL == "" {
+		pagerDutyURL = defaultPagerDutyURL
+	}
+	return &Escalator{
+		policies:     make(map[escalationKey]EscalationPolicy),
+		alerts:       make(map[escalationKey]*AlertEscalation),
+		client:       &http.Client{Timeout: webhookTimeout},
+		pagerDutyURL: pagerDutyURL,
+		backoff:      500 * time.Millisecond,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// AttachDB connects the escalator to Postgres and loads the stored
+// policies.
+func (e *Escalator) AttachDB(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx, `SELECT tenant, rule, definition, updated_at FROM escalation_policies`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	loaded := make(map[escalationKey]EscalationPolicy)
+	for rows.Next() {
+		var p EscalationPolicy
+		var tenant, rule string
+		var definition []byte
+		var updated time.Time
+		if err := rows.Scan(&tenant, &rule, &definition, &updated); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(definition, &p); err != nil {
+			log.WithError(err).WithField("rule", rule).Warn("skipping unreadable escalation policy")
+			continue
+		}
+		p.Tenant = tenant
+		p.UpdatedAt = updated.UTC().Format(time.RFC3339)
+		loaded[escalationKey{tenant, rule}] = p
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.policies = loaded
+	e.db = db
+	return nil
+}
+
+// Get returns tenant's policy for rule.
+func (e *Escalator) Get(tenant, rule string) (EscalationPolicy, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	p, ok := e.policies[escalationKey{tenant, rule}]
+	return p, ok
+}
+
+// List returns tenant's policies by rule.
+func (e *Escalator) List(tenant string) []EscalationPolicy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]EscalationPolicy, 0)
+	for key, p := range e.policies {
+		if key.tenant == tenant {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Rule < out[j].Rule })
+	return out
+}
+
+// Put validates and saves p as tenant's policy for its rule, replacing the
+// previous one, and reports whether it is new. Alerts already being
+// escalated keep the steps they started with.
+func (e *Escalator) Put(ctx context.Context, p EscalationPolicy, tenant string) (EscalationPolicy, bool, error) {
+	if err := p.Validate(); err != nil {
+		return p, false, err
+	}
+	p.Tenant = tenant
+	p.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if e.db != nil {
+		definition, err := json.Marshal(p)
+		if err != nil {
+			return p, false, err
+		}
+		if _, err := e.db.Exec(ctx, `
+			INSERT INTO escalation_policies (tenant, rule, definition) VALUES ($1, $2, $3)
+			ON CONFLICT (tenant, rule) DO UPDATE SET definition = EXCLUDED.definition, updated_at = NOW()`,
+			tenant, p.Rule, definition); err != nil {
+			return p, false, err
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := escalationKey{tenant, p.Rule}
+	_, existed := e.policies[key]
+	e.policies[key] = p
+	return p, !existed, nil
+}
+
+// Delete removes tenant's policy for rule and reports whether it existed.
+func (e *Escalator) Delete(ctx context.Context, tenant, rule string) (bool, error) {
+	if _, ok := e.Get(tenant, rule); !ok {
+		return false, nil
+	}
+	if e.db != nil {
+		if _, err := e.db.Exec(ctx, `DELETE FROM escalation_policies WHERE tenant = $1 AND rule = $2`, tenant, rule); err != nil {
+			return false, err
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := escalationKey{tenant, rule}
+	_, ok := e.policies[key]
+	delete(e.policies, key)
+	return ok, nil
+}
+
+// Observe starts escalating ev for every policy it matches. It never
+// blocks: the steps are sent by Run.
+func (e *Escalator) Observe(ev SystemEvent) {
+	if e == nil || ev.Kind != SystemAlertTriggered {
+		return
+	}
+	triggered, err := time.Parse(time.RFC3339, ev.At)
+	if err != nil {
+		triggered = time.Now()
+	}
+	e.mu.Lock()
+	started := false
+	for _, p := range e.policies {
+		key := escalationKey{p.Tenant, ev.ID}
+		if _, tracked := e.alerts[key]; tracked || !p.matches(ev) {
+			continue
+		}
+		e.alerts[key] = &AlertEscalation{
+			AlertID: ev.ID, Rule: p.Rule, Severity: ev.Severity, Message: ev.Message, TriggeredAt: ev.At,
+			Notified: []EscalationNotice{}, tenant: p.Tenant, alert: ev, steps: p.Steps, triggered: triggered,
+		}
+		e.order = append(e.order, key)
+		started = true
+	}
+	for len(e.order) > maxTrackedAlerts {
+		delete(e.alerts, e.order[0])
+		e.order = e.order[1:]
+	}
+	e.mu.Unlock()
+	if started {
+		e.signal()
+	}
+}
+
+// signal wakes Run without blocking.
+func (e *Escalator) signal() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Alert returns tenant's escalation of the alert id.
+func (e *Escalator) Alert(tenant, id string) (AlertEscalation, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	a, ok := e.alerts[escalationKey{tenant, id}]
+	if !ok {
+		return AlertEscalation{}, false
+	}
+	return a.view(), true
+}
+
+// Alerts returns up to limit of tenant's escalations, newest first.
+// status selects the open or the acknowledged ones; empty returns all.
+func (e *Escalator) Alerts(tenant, status string, limit int) []AlertEscalation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]AlertEscalation, 0)
+	for i := len(e.order) - 1; i >= 0 && len(out) < limit; i-- {
+		key := e.order[i]
+		a := e.alerts[key]
+		if key.tenant != tenant || (status == "open" && a.AcknowledgedAt != "") || (status == "acknowledged" && a.AcknowledgedAt == "") {
+			continue
+		}
+		out = append(out, a.view())
+	}
+	return out
+}
+
+// Acknowledge stops tenant's escalation of the alert id and queues the
+// acknowledgement of the PagerDuty incidents it opened. It returns false
+// when the alert is not being escalated for tenant.
+func (e *Escalator) Acknowledge(tenant, id string, now time.Time) (AlertEscalation, bool) {
+	e.mu.Lock()
+	key := escalationKey{tenant, id}
+	a, ok := e.alerts[key]
+	if !ok {
+		e.mu.Unlock()
+		return AlertEscalation{}, false
+	}
+	queued := false
+	if a.AcknowledgedAt == "" {
+		a.AcknowledgedAt = now.UTC().Format(time.RFC3339)
+		paged := make(map[string]struct{})
+		for _, n := range a.Notified {
+			s := a.steps[n.Step]
+			if _, dup := paged[s.RoutingKey]; s.Channel == EscalatePagerDuty && n.Error == "" && !dup {
+				paged[s.RoutingKey] = struct{}{}
+				e.acks = append(e.acks, escalationSend{key: key, step: n.Step, target: s, alert: a.alert, action: "acknowledge"})
+				queued = true
+			}
+		}
+	}
+	out := a.view()
+	e.mu.Unlock()
+	if queued {
+		e.signal()
+	}
+	return out, true
+}
+
+// Run sends due escalation steps and acknowledgements until ctx is
+// cancelled.
+func (e *Escalator) Run(ctx context.Context) {
+	ticker := time.NewTicker(escalationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-e.wake:
+		}
+		e.escalate(ctx, time.Now())
+	}
+}
+
+// escalate sends every step due at now and the pending acknowledgements.
+func (e *Escalator) escalate(ctx context.Context, now time.Time) {
+	e.mu.Lock()
+	sends := e.acks
+	e.acks = nil
+	for _, key := range e.order {
+		a := e.alerts[key]
+		for due, ok := a.dueAt(); ok && !due.After(now); due, ok = a.dueAt() {
+			step := a.steps[a.next]
+			sends = append(sends, escalationSend{key: key, step: a.next, target: step, alert: a.alert, action: "trigger",
+				after: int(now.Sub(a.triggered) / time.Minute)})
+			a.next++
+		}
+	}
+	e.mu.Unlock()
+
+	for _, s := range sends {
+		err := e.send(ctx, s)
+		fields := log.Fields{"alert_id": s.alert.ID, "tenant": s.key.tenant, "step": s.step, "channel": s.target.Channel, "action": s.action}
+		if err != nil {
+			log.WithError(err).WithFields(fields).Error("alert escalation failed")
+		} else {
+			log.WithFields(fields).Info("alert escalated")
+		}
+		if s.action != "trigger" {
+			continue
+		}
+		notice := EscalationNotice{Step: s.step, Channel: s.target.Channel, At: time.Now().UTC().Format(time.RFC3339)}
+		if err != nil {
+			notice.Error = err.Error()
+		}
+		e.mu.Lock()
+		if a, ok := e.alerts[s.key]; ok {
+			a.Notified = append(a.Notified, notice)
+		}
+		e.mu.Unlock()
+	}
+}
+
+// send delivers s to its channel, retrying like webhook deliveries.
+func (e *Escalator) send(ctx context.Context, s escalationSend) error {
+	target, body := s.target.WebhookURL, slackMessage(s)
+	if s.target.Channel == EscalatePagerDuty {
+		target, body = e.pagerDutyURL, pagerDutyEvent(s)
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	wait := e.backoff
+	var lastErr error
+	for attempt := 0; attempt < webhookAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := e.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s returned %s", s.target.Channel, resp.Status)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return lastErr
+		}
+	}
+	return lastErr
+}
+
+// escalationSummary describes an alert in one line.
+func escalationSummary(ev SystemEvent) string {
+	summary := ev.Message
+	if summary == "" {
+		rule, _ := ev.Data["rule"].(string)
+		summary = fmt.Sprintf("alert %s triggered", rule)
+	}
+	if ev.Chain != "" {
+		summary = fmt.Sprintf("%s (%s)", summary, ev.Chain)
+	}
+	return summary
+}
+
+// slackMessage is the incoming webhook body of a slack step.
+func slackMessage(s escalationSend) map[string]interface{} {
+	rule, _ := s.alert.Data["rule"].(string)
+	text := fmt.Sprintf("[%s] %s\nRule %s, alert %s.", strings.ToUpper(s.alert.Severity), escalationSummary(s.alert), rule, s.alert.ID)
+	if s.step > 0 {
+		text += fmt.Sprintf(" Unacknowledged for %d minutes.", s.after)
+	}
+	text += fmt.Sprintf(" Acknowledge with POST /alerts/%s/ack.", s.alert.ID)
+	return map[string]interface{}{"text": text}
+}
+
+// pagerDutyEvent is the Events API v2 body of a pagerduty step. The alert
+// ID deduplicates it, so acknowledging reaches the incident it opened.
+func pagerDutyEvent(s escalationSend) map[string]interface{} {
+	event := map[string]interface{}{
+		"routing_key":  s.target.RoutingKey,
+		"event_action": s.action,
+		"dedup_key":    s.alert.ID,
+	}
+	if s.action != "trigger" {
+		return event
+	}
+	summary := escalationSummary(s.alert)
+	if len(summary) > 1024 {
+		summary = summary[:1024]
+	}
+	payload := map[string]interface{}{
+		"summary":  summary,
+		"source":   "cross-chain-tracker",
+		"severity": s.alert.Severity,
+	}
+	if s.alert.At != "" {
+		payload["timestamp"] = s.alert.At
+	}
+	if s.alert.Chain != "" {
+		payload["component"] = s.alert.Chain
+	}
+	if s.alert.Network != "" {
+		payload["group"] = s.alert.Network
+	}
+	if len(s.alert.Data) > 0 {
+		payload["custom_details"] = s.alert.Data
+	}
+	event["payload"] = payload
+	return event
+}
+
+// escalationError answers err from a policy change: 400 for invalid input,
+// 500 otherwise.
+func escalationError(w http.ResponseWriter, err error) {
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		badRequest(w, err)
+		return
+	}
+	log.WithError(err).Error("failed to store escalation policy")
+	httpError(w, "could not store escalation policy", http.StatusInternalServerError)
+}
+
+func writeEscalationJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// putEscalationPolicy creates or replaces the caller's policy for the
+// {rule} alert rule.
+func putEscalationPolicy(escalator *Escalator, w http.ResponseWriter, r *http.Request) {
+	var p EscalationPolicy
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&p); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	p.Rule = chi.URLParam(r, "rule")
+	p, created, err := escalator.Put(r.Context(), p, tenantFrom(r.Context()))
+	if err != nil {
+		escalationError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeEscalationJSON(w, status, p)
+}
+
+// listEscalationPolicies returns the caller's escalation policies.
+func listEscalationPolicies(escalator *Escalator, w http.ResponseWriter, r *http.Request) {
+	writeEscalationJSON(w, http.StatusOK, escalator.List(tenantFrom(r.Context())))
+}
+
+// getEscalationPolicy returns the caller's policy for an alert rule.
+func getEscalationPolicy(escalator *Escalator, w http.ResponseWriter, r *http.Request) {
+	p, ok := escalator.Get(tenantFrom(r.Context()), chi.URLParam(r, "rule"))
+	if !ok {
+		httpError(w, "escalation policy not found", http.StatusNotFound)
+		return
+	}
+	writeEscalationJSON(w, http.StatusOK, p)
+}
+
+// deleteEscalationPolicy removes the caller's policy for an alert rule.
+func deleteEscalationPolicy(escalator *Escalator, w http.ResponseWriter, r *http.Request) {
+	ok, err := escalator.Delete(r.Context(), tenantFrom(r.Context()), chi.URLParam(r, "rule"))
+	if err != nil {
+		log.WithError(err).Error("failed to delete escalation policy")
+		httpError(w, "could not delete escalation policy", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "escalation policy not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listAlerts returns the alerts being escalated for the caller.
+func listAlerts(escalator *Escalator, w http.ResponseWriter, r *http.Request) {
+	p := newQueryParams(r)
+	status := p.Enum("status", "open", "acknowledged")
+	limit := p.Int("limit", defaultPageSize, 1, maxPageSize)
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	writeEscalationJSON(w, http.StatusOK, escalator.Alerts(tenantFrom(r.Context()), status, limit))
+}
+
+// acknowledgeAlert stops escalating the {id} alert for the caller.
+func acknowledgeAlert(escalator *Escalator, w http.ResponseWriter, r *http.Request) {
+	a, ok := escalator.Acknowledge(tenantFrom(r.Context()), chi.URLParam(r, "id"), time.Now())
+	if !ok {
+		httpError(w, "alert is not being escalated", http.StatusNotFound)
+		return
+	}
+	writeEscalationJSON(w, http.StatusOK, a)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// escalationReceiver records the bodies POSTed to it.
+type escalationReceiver struct {
+	mu     sync.Mutex
+	bodies []map[string]interface{}
+}
+
+func (rc *escalationReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	rc.mu.Lock()
+	rc.bodies = append(rc.bodies, body)
+	rc.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (rc *escalationReceiver) received() []map[string]interface{} {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]map[string]interface{}(nil), rc.bodies...)
+}
+
+func TestEscalatorEscalatesUntilAcknowledged(t *testing.T) {
+	slack, pagerDuty := &escalationReceiver{}, &escalationReceiver{}
+	slackServer, pagerDutyServer := httptest.NewServer(slack), httptest.NewServer(pagerDuty)
+	defer slackServer.Close()
+	defer pagerDutyServer.Close()
+
+	e := NewEscalator(pagerDutyServer.URL)
+	policy := EscalationPolicy{Rule: "bridge_stuck", Steps: []EscalationStep{
+		{Channel: "Slack", WebhookURL: slackServer.URL},
+		{AfterMinutes: 15, Channel: EscalatePagerDuty, RoutingKey: "R0UTING"},
+	}}
+	if _, created, err := e.Put(context.Background(), policy, "acme"); err != nil || !created {
+		t.Fatalf("put: created %v, err %v", created, err)
+	}
+
+	triggered := time.Date(2025, 10, 14, 12, 0, 0, 0, time.UTC)
+	alert := func(id, rule, tenant string) SystemEvent {
+		return SystemEvent{ID: id, Kind: SystemAlertTriggered, Severity: SeverityCritical, Tenant: tenant, Chain: "ethereum",
+			Message: "bridge transfer stuck", Data: map[string]interface{}{"rule": rule}, At: triggered.Format(time.RFC3339)}
+	}
+	e.Observe(alert("a1", "bridge_stuck", ""))
+	e.Observe(alert("a2", "bridge_stuck", "acme"))
+	e.Observe(alert("other-rule", "whale_transfer", "acme"))
+	e.Observe(alert("other-tenant", "bridge_stuck", "other"))
+
+	e.escalate(context.Background(), triggered)
+	if got := slack.received(); len(got) != 2 || !strings.Contains(got[0]["text"].(string), "[CRITICAL] bridge transfer stuck (ethereum)") {
+		t.Fatalf("slack received %v", got)
+	}
+	if len(pagerDuty.received()) != 0 {
+		t.Fatal("paged before the delay")
+	}
+
+	if _, ok := e.Acknowledge("acme", "a2", triggered.Add(5*time.Minute)); !ok {
+		t.Fatal("a2 is not being escalated")
+	}
+	e.escalate(context.Background(), triggered.Add(16*time.Minute))
+	got := pagerDuty.received()
+	if len(got) != 1 || got[0]["dedup_key"] != "a1" || got[0]["event_action"] != "trigger" || got[0]["routing_key"] != "R0UTING" {
+		t.Fatalf("pagerduty received %v", got)
+	}
+	if payload := got[0]["payload"].(map[string]interface{}); payload["severity"] != SeverityCritical || payload["component"] != "ethereum" {
+		t.Fatalf("pagerduty payload %v", payload)
+	}
+
+	a, ok := e.Acknowledge("acme", "a1", triggered.Add(20*time.Minute))
+	if !ok || a.AcknowledgedAt == "" || len(a.Notified) != 2 || a.NextStepAt != "" {
+		t.Fatalf("acknowledged escalation = %+v", a)
+	}
+	e.escalate(context.Background(), triggered.Add(time.Hour))
+	if got := pagerDuty.received(); len(got) != 2 || got[1]["event_action"] != "acknowledge" || got[1]["dedup_key"] != "a1" {
+		t.Fatalf("pagerduty received %v", got)
+	}
+	if len(slack.received()) != 2 {
+		t.Fatal("acknowledged alerts kept escalating")
+	}
+
+	if open := e.Alerts("acme", "open", maxPageSize); len(open) != 0 {
+		t.Fatalf("open alerts = %+v", open)
+	}
+	if all := e.Alerts("acme", "", maxPageSize); len(all) != 2 || all[0].AlertID != "a2" {
+		t.Fatalf("alerts = %+v", all)
+	}
+}
+
+func TestEscalationPolicyEndpoints(t *testing.T) {
+	e := NewEscalator("")
+	do := func(method, rule, tenant, body string, handler func(*Escalator, http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		t.Helper()
+		req := withChiParam(httptest.NewRequest(method, "/escalation-policies/"+rule, strings.NewReader(body)), "rule", rule)
+		req = req.WithContext(withTenant(req.Context(), tenant))
+		r := httptest.NewRecorder()
+		handler(e, r, req)
+		return r
+	}
+
+	body := `{"steps": [{"channel": "slack", "webhook_url": "https://hooks.slack.com/services/T/B/X"}, {"after_minutes": 10, "channel": "pagerduty", "routing_key": "key"}]}`
+	if r := do(http.MethodPut, "bridge_stuck", "acme", body, putEscalationPolicy); r.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", r.Code, r.Body.String())
+	}
+	if r := do(http.MethodPut, "bridge_stuck", "acme", body, putEscalationPolicy); r.Code != http.StatusOK {
+		t.Fatalf("replace: status %d", r.Code)
+	}
+	for _, bad := range []string{
+		`{"steps": []}`,
+		`{"steps": [{"channel": "email"}]}`,
+		`{"steps": [{"channel": "slack", "webhook_url": "hooks.slack.com"}]}`,
+		`{"steps": [{"channel": "pagerduty"}]}`,
+		`{"steps": [{"after_minutes": 10, "channel": "pagerduty", "routing_key": "k"}, {"after_minutes": 5, "channel": "pagerduty", "routing_key": "k"}]}`,
+	} {
+		if r := do(http.MethodPut, "bridge_stuck", "acme", bad, putEscalationPolicy); r.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", bad, r.Code)
+		}
+	}
+	if r := do(http.MethodGet, "bridge_stuck", "other", "", getEscalationPolicy); r.Code != http.StatusNotFound {
+		t.Fatalf("other tenant: status %d", r.Code)
+	}
+
+	req := withChiParam(httptest.NewRequest(http.MethodPost, "/alerts/nope/ack", nil), "id", "nope")
+	r := httptest.NewRecorder()
+	acknowledgeAlert(e, r, req)
+	if r.Code != http.StatusNotFound {
+		t.Fatalf("ack of an unknown alert: status %d", r.Code)
+	}
+	r = httptest.NewRecorder()
+	listAlerts(e, r, httptest.NewRequest(http.MethodGet, "/alerts?status=closed", nil))
+	if r.Code != http.StatusBadRequest {
+		t.Fatalf("status=closed: expected 400, got %d", r.Code)
+	}
+
+	if r := do(http.MethodDelete, "bridge_stuck", "acme", "", deleteEscalationPolicy); r.Code != http.StatusNoContent {
+		t.Fatalf("delete: status %d", r.Code)
+	}
+	if len(e.List("acme")) != 0 {
+		t.Fatal("policy still listed after delete")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// currentEventSchema is the newest version of the JSON event envelope the
+// listener publishes, in its schema_version field.
+const currentEventSchema = 1
+
+// eventDecoders turn each envelope version into the current Event. When the
+// envelope changes, the listener bumps schema_version and a decoder for the
+// new version is added here, while the old ones keep upgrading events from
+// listeners that were not redeployed yet.
+var eventDecoders = map[int]func(payload []byte) (*Event, error){
+	1: decodeEventV1,
+}
+
+// decodeEvent decodes a listener payload according to its schema_version.
+// Payloads without one predate versioning and are version 1.
+func decodeEvent(payload []byte) (ev *Event, version int, err error) {
+	var envelope struct {
+		SchemaVersion *int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return nil, 0, err
+	}
+	version = 1
+	if envelope.SchemaVersion != nil {
+		version = *envelope.SchemaVersion
+	}
+	decode, ok := eventDecoders[version]
+	if !ok {
+		return nil, version, fmt.Errorf("unsupported event schema version %d: this build reads up to %d", version, currentEventSchema)
+	}
+	ev, err = decode(payload)
+	return ev, version, err
+}
+
+// decodeEventV1 decodes the first envelope, whose fields are Event's.
+func decodeEventV1(payload []byte) (*Event, error) {
+	var ev Event
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestDecodeEventVersions(t *testing.T) {
+	for _, payload := range []string{
+		`{"event_id":"legacy","chain":"ethereum","from":"0xa"}`,
+		`{"schema_version":1,"event_id":"v1","chain":"ethereum","from":"0xa"}`,
+	} {
+		ev, version, err := decodeEvent([]byte(payload))
+		if err != nil || version != 1 || ev.Chain != "ethereum" || ev.From != "0xa" {
+			t.Errorf("%s: %+v, %d, %v", payload, ev, version, err)
+		}
+	}
+	if _, version, err := decodeEvent([]byte(`{"schema_version":99,"event_id":"future"}`)); err == nil || version != 99 {
+		t.Fatalf("future version = %d, %v", version, err)
+	}
+	if _, _, err := decodeEvent([]byte(`not json`)); err == nil {
+		t.Fatal("invalid payload decoded")
+	}
+}
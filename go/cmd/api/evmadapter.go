@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// evmChains are the EVM chains the listener ingests, by the symbol of
+// their native currency. They all use ether's 18 decimals.
+var evmChains = map[string]string{
+	"ethereum":  "ETH",
+	"base":      "ETH",
+	"arbitrum":  "ETH",
+	"optimism":  "ETH",
+	"polygon":   "POL",
+	"bsc":       "BNB",
+	"avalanche": "AVAX",
+}
+
+func init() {
+	for chain, symbol := range evmChains {
+		RegisterChainAdapter(evmAdapter{chain: chain, decimals: 18, symbol: symbol})
+	}
+}
+
+// evmAdapter handles an EVM chain: EIP-55 checksummed hex addresses,
+// 0x-prefixed 32-byte transaction hashes and the eth_ JSON-RPC API.
+type evmAdapter struct {
+	chain string
+	// decimals and symbol of the native currency; zero when unknown.
+	decimals int
+	symbol   string
+}
+
+func (a evmAdapter) Chain() string { return a.chain }
+
+func (a evmAdapter) Normalize(ev *Event) error { return normalizeEvent(a, ev) }
+
+// Validate returns the EIP-55 form of address. Mixed-case addresses must
+// carry a valid checksum; all-lowercase and all-uppercase ones carry none.
+func (a evmAdapter) Validate(address string) (string, error) {
+	if !isEVMAddress(address) {
+		return "", errNotChainAddress
+	}
+	canonical := checksumAddress(address)
+	hex := address[2:]
+	if hex != strings.ToLower(hex) && hex != strings.ToUpper(hex) && hex != canonical[2:] {
+		return "", errBadChecksum
+	}
+	return canonical, nil
+}
+
+// TxHash returns hash as 0x-prefixed lowercase hex.
+func (a evmAdapter) TxHash(hash string) (string, bool) {
+	if !isEVMHash(hash) {
+		return "", false
+	}
+	return "0x" + strings.ToLower(hash[len(hash)-64:]), true
+}
+
+func (a evmAdapter) Subscribe(network string) string { return eventsChannel(a.chain, network) }
+
+// Backfill returns the transaction and its receipt, which is null until the
+// transaction is mined.
+func (a evmAdapter) Backfill(ctx context.Context, call RPCCaller, hash string) (json.RawMessage, bool, error) {
+	tx, err := call(ctx, "eth_getTransactionByHash", hash)
+	if err != nil || tx == nil {
+		return nil, false, err
+	}
+	receipt, err := call(ctx, "eth_getTransactionReceipt", hash)
+	if err != nil {
+		return nil, false, err
+	}
+	if receipt == nil {
+		receipt = json.RawMessage("null")
+	}
+	raw, err := json.Marshal(struct {
+		Transaction json.RawMessage `json:"transaction"`
+		Receipt     json.RawMessage `json:"receipt"`
+	}{tx, receipt})
+	return raw, string(receipt) != "null", err
+}
+
+func (a evmAdapter) HeadMethod() string { return "eth_blockNumber" }
+
+func (a evmAdapter) NativeDecimals() (int, bool) { return a.decimals, a.decimals > 0 }
+
+func (a evmAdapter) NativeSymbol() string { return a.symbol }
+
+// VerifyMessage checks an EIP-191 personal_sign signature: 65 hex-encoded
+// bytes, r || s || v, whose recovered signer must be address.
+func (a evmAdapter) VerifyMessage(address, message, signature string) error {
+	signature = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(signature), "0x"), "0X")
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return errBadSignature
+	}
+	signer, err := ecrecoverAddress(eip191Hash(message), sig)
+	if err != nil {
+		return err
+	}
+	if addressKey(signer) != addressKey(address) {
+		return errBadSignature
+	}
+	return nil
+}
+
+const (
+	// erc20TransferTopic is topic0 of Transfer(address,address,uint256).
+	erc20TransferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+	// historyLogSpan is the block span of one eth_getLogs request, within
+	// what public providers accept.
+	historyLogSpan = 2000
+)
+
+// evmLog is an entry of eth_getLogs.
+type evmLog struct {
+	Address     string   `json:"address"`
+	Topics      []string `json:"topics"`
+	Data        string   `json:"data"`
+	BlockNumber string   `json:"blockNumber"`
+	TxHash      string   `json:"transactionHash"`
+	LogIndex    string   `json:"logIndex"`
+	Removed     bool     `json:"removed"`
+}
+
+// History returns the ERC-20 transfers from and to address, found with
+// eth_getLogs from the head back, newest first. Native transfers leave no
+// log and are not found.
+func (a evmAdapter) History(ctx context.Context, call RPCCaller, address string, bound HistoryBound) ([]*Event, error) {
+	result, err := call(ctx, "eth_blockNumber")
+	if err != nil {
+		return nil, err
+	}
+	head, err := parseHeight(result)
+	if err != nil {
+		return nil, err
+	}
+	var lowest uint64
+	if bound.Blocks > 0 && head >= bound.Blocks {
+		lowest = head - bound.Blocks + 1
+	}
+	topic := "0x000000000000000000000000" + strings.ToLower(address[2:])
+	times := make(map[uint64]time.Time)
+	var events []*Event
+	for to := head; ; {
+		from := lowest
+		if to-lowest >= historyLogSpan {
+			from = to - historyLogSpan + 1
+		}
+		var logs []evmLog
+		seen := make(map[string]bool)
+		// Sent, then received; a transfer to oneself matches both
+		for _, topics := range [][]interface{}{{erc20TransferTopic, topic}, {erc20TransferTopic, nil, topic}} {
+			result, err := call(ctx, "eth_getLogs", map[string]interface{}{
+				"fromBlock": fmt.Sprintf("0x%x", from), "toBlock": fmt.Sprintf("0x%x", to), "topics": topics,
+			})
+			if err != nil {
+				return nil, err
+			}
+			var page []evmLog
+			if result != nil {
+				if err := json.Unmarshal(result, &page); err != nil {
+					return nil, fmt.Errorf("unexpected eth_getLogs result: %w", err)
+				}
+			}
+			for _, l := range page {
+				if key := l.TxHash + l.LogIndex; !seen[key] && !l.Removed {
+					seen[key] = true
+					logs = append(logs, l)
+				}
+			}
+		}
+		sort.Slice(logs, func(i, j int) bool {
+			bi, _ := parseQuantity(logs[i].BlockNumber)
+			bj, _ := parseQuantity(logs[j].BlockNumber)
+			if bi != bj {
+				return bi > bj
+			}
+			li, _ := parseQuantity(logs[i].LogIndex)
+			lj, _ := parseQuantity(logs[j].LogIndex)
+			return li > lj
+		})
+		for _, l := range logs {
+			// ERC-721 transfers share the signature with an indexed token ID
+			if len(l.Topics) != 3 || len(l.Data) < 3 {
+				continue
+			}
+			block, err := parseQuantity(l.BlockNumber)
+			if err != nil {
+				continue
+			}
+			at, ok := times[block]
+			if !ok {
+				if at, err = evmBlockTime(ctx, call, block); err != nil {
+					return nil, err
+				}
+				times[block] = at
+			}
+			if at.Before(bound.Since) {
+				return a.withTokens(ctx, call, events), nil
+			}
+			index, _ := parseQuantity(l.LogIndex)
+			value, ok := new(big.Int).SetString(strings.TrimPrefix(l.Data, "0x"), 16)
+			if !ok {
+				continue
+			}
+			events = append(events, &Event{
+				EventID:     fmt.Sprintf("eth:%s:log%d", strings.ToLower(l.TxHash), index),
+				Chain:       a.chain,
+				TxHash:      l.TxHash,
+				Timestamp:   at.UTC().Format(time.RFC3339),
+				From:        "0x" + l.Topics[1][len(l.Topics[1])-40:],
+				To:          "0x" + l.Topics[2][len(l.Topics[2])-40:],
+				Value:       value.String(),
+				EventType:   "erc20_transfer",
+				BlockNumber: &block,
+				LogIndex:    &index,
+				Token:       &Token{Address: checksumAddress(l.Address)},
+			})
+			if bound.Limit > 0 && len(events) >= bound.Limit {
+				return a.withTokens(ctx, call, events), nil
+			}
+		}
+		if from == lowest {
+			return a.withTokens(ctx, call, events), nil
+		}
+		to = from - 1
+	}
+}
+
+// parseQuantity parses a 0x-prefixed hex quantity.
+func parseQuantity(s string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+}
+
+// evmBlockTime returns the timestamp of a block.
+func evmBlockTime(ctx context.Context, call RPCCaller, block uint64) (time.Time, error) {
+	result, err := call(ctx, "eth_getBlockByNumber", fmt.Sprintf("0x%x", block), false)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var header struct {
+		Timestamp string `json:"timestamp"`
+	}
+	if result == nil || json.Unmarshal(result, &header) != nil {
+		return time.Time{}, fmt.Errorf("block %d not found", block)
+	}
+	seconds, err := parseQuantity(header.Timestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("block %d: invalid timestamp %q", block, header.Timestamp)
+	}
+	return time.Unix(int64(seconds), 0), nil
+}
+
+// withTokens fills in the symbol and decimals of the events' tokens from
+// their contracts. Tokens that do not answer are left without.
+func (a evmAdapter) withTokens(ctx context.Context, call RPCCaller, events []*Event) []*Event {
+	tokens := make(map[string]Token)
+	for _, ev := range events {
+		token, ok := tokens[ev.Token.Address]
+		if !ok {
+			token = *ev.Token
+			if result, err := call(ctx, "eth_call", map[string]string{"to": token.Address, "data": "0x313ce567"}, "latest"); err == nil {
+				if decimals, ok := decodeABIUint(result); ok && decimals <= 255 {
+					token.Decimals = uint8(decimals)
+				}
+			}
+			if result, err := call(ctx, "eth_call", map[string]string{"to": token.Address, "data": "0x95d89b41"}, "latest"); err == nil {
+				token.Symbol = decodeABIString(result)
+			}
+			tokens[ev.Token.Address] = token
+		}
+		ev.Token = &token
+	}
+	return events
+}
+
+// decodeABIUint decodes an eth_call result holding one uint256 that fits
+// in a uint64.
+func decodeABIUint(result json.RawMessage) (uint64, bool) {
+	var s string
+	if json.Unmarshal(result, &s) != nil {
+		return 0, false
+	}
+	n, ok := new(big.Int).SetString(strings.TrimPrefix(s, "0x"), 16)
+	if !ok || !n.IsUint64() {
+		return 0, false
+	}
+	return n.Uint64(), true
+}
+
+// decodeABIString decodes an eth_call result holding one string, or ""
+// when it does not.
+func decodeABIString(result json.RawMessage) string {
+	var s string
+	if json.Unmarshal(result, &s) != nil {
+		return ""
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil || len(data) < 64 {
+		return ""
+	}
+	offset := new(big.Int).SetBytes(data[:32])
+	if !offset.IsUint64() || offset.Uint64()+32 > uint64(len(data)) {
+		return ""
+	}
+	start := offset.Uint64() + 32
+	length := new(big.Int).SetBytes(data[start-32 : start])
+	if !length.IsUint64() || start+length.Uint64() > uint64(len(data)) {
+		return ""
+	}
+	return string(data[start : start+length.Uint64()])
+}
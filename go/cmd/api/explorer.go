@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ExplorerLinks are block explorer URLs for an event's transaction and
+// addresses.
+type ExplorerLinks struct {
+	Tx   string `json:"tx,omitempty"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// explorerTemplate holds the URL templates of one network's explorer.
+// {hash} and {address} are replaced by the path-escaped value.
+type explorerTemplate struct {
+	tx, address string
+}
+
+// builtinExplorers cover the networks the listeners support out of the box.
+var builtinExplorers = map[string]explorerTemplate{
+	"ethereum:mainnet": {"https://etherscan.io/tx/{hash}", "https://etherscan.io/address/{address}"},
+	"ethereum:sepolia": {"https://sepolia.etherscan.io/tx/{hash}", "https://sepolia.etherscan.io/address/{address}"},
+	"ethereum:holesky": {"https://holesky.etherscan.io/tx/{hash}", "https://holesky.etherscan.io/address/{address}"},
+	"base:mainnet":     {"https://basescan.org/tx/{hash}", "https://basescan.org/address/{address}"},
+	"base:sepolia":     {"https://sepolia.basescan.org/tx/{hash}", "https://sepolia.basescan.org/address/{address}"},
+	"solana:mainnet":   {"https://solscan.io/tx/{hash}", "https://solscan.io/account/{address}"},
+	"solana:devnet":    {"https://solscan.io/tx/{hash}?cluster=devnet", "https://solscan.io/account/{address}?cluster=devnet"},
+	"solana:testnet":   {"https://solscan.io/tx/{hash}?cluster=testnet", "https://solscan.io/account/{address}?cluster=testnet"},
+}
+
+// Explorers resolves explorer links per chain/network.
+type Explorers struct {
+	templates map[string]explorerTemplate
+}
+
+// NewExplorers returns the built-in explorers.
+func NewExplorers() *Explorers {
+	e := &Explorers{templates: make(map[string]explorerTemplate, len(builtinExplorers))}
+	for pair, t := range builtinExplorers {
+		e.templates[pair] = t
+	}
+	return e
+}
+
+// ParseExplorerURLs adds or replaces explorers from a comma-separated list of
+// chain:network=tx_template|address_template entries, e.g.
+// "polygon:mainnet=https://polygonscan.com/tx/{hash}|https://polygonscan.com/address/{address}".
+func ParseExplorerURLs(spec string) (*Explorers, error) {
+	e := NewExplorers()
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pair, templates, ok := strings.Cut(item, "=")
+		parts := strings.Split(pair, ":")
+		tx, address, ok2 := strings.Cut(templates, "|")
+		if !ok || !ok2 || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid explorer %q: want chain:network=tx_template|address_template", item)
+		}
+		if !validExplorerTemplate(tx, "{hash}") {
+			return nil, fmt.Errorf("invalid explorer %q: the tx template must be an http(s) URL with {hash}", item)
+		}
+		if !validExplorerTemplate(address, "{address}") {
+			return nil, fmt.Errorf("invalid explorer %q: the address template must be an http(s) URL with {address}", item)
+		}
+		e.templates[strings.ToLower(parts[0])+":"+strings.ToLower(parts[1])] = explorerTemplate{tx: tx, address: address}
+	}
+	return e, nil
+}
+
+// LinkOne returns ev, or a copy of it with explorer links when its network
+// has an explorer.
+func (e *Explorers) LinkOne(ev *Event) *Event {
+	if e == nil || ev == nil {
+		return ev
+	}
+	t, ok := e.templates[strings.ToLower(ev.Chain)+":"+strings.ToLower(ev.Network)]
+	if !ok {
+		return ev
+	}
+	linked := *ev
+	linked.Explorer = &ExplorerLinks{
+		Tx:   fillExplorerTemplate(t.tx, "{hash}", ev.TxHash),
+		From: fillExplorerTemplate(t.address, "{address}", ev.From),
+		To:   fillExplorerTemplate(t.address, "{address}", ev.To),
+	}
+	return &linked
+}
+
+// validExplorerTemplate reports whether template is an http(s) URL with the
+// placeholder.
+func validExplorerTemplate(template, placeholder string) bool {
+	return (strings.HasPrefix(template, "https://") || strings.HasPrefix(template, "http://")) &&
+		strings.Contains(template, placeholder)
+}
+
+// fillExplorerTemplate fills a template, or returns "" when value is empty.
+func fillExplorerTemplate(template, placeholder, value string) string {
+	if value == "" {
+		return ""
+	}
+	return strings.ReplaceAll(template, placeholder, url.PathEscape(value))
+}
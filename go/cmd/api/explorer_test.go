@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExplorerLinks(t *testing.T) {
+	explorers, err := ParseExplorerURLs("polygon:mainnet=https://polygonscan.com/tx/{hash}|https://polygonscan.com/address/{address}," +
+		"ethereum:mainnet=https://eth.blockscout.com/tx/{hash}|https://eth.blockscout.com/address/{address}")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	sol := &Event{Chain: "solana", Network: "devnet", TxHash: "5sig", From: "Alice", To: ""}
+	linked := explorers.LinkOne(sol)
+	want := ExplorerLinks{Tx: "https://solscan.io/tx/5sig?cluster=devnet", From: "https://solscan.io/account/Alice?cluster=devnet"}
+	if linked == sol || linked.Explorer == nil || *linked.Explorer != want {
+		t.Fatalf("solana links = %+v, want %+v", linked.Explorer, want)
+	}
+	if sol.Explorer != nil {
+		t.Fatal("LinkOne modified the stored event")
+	}
+	if ev := explorers.LinkOne(&Event{Chain: "Ethereum", Network: "mainnet", TxHash: "0xabc"}); ev.Explorer.Tx != "https://eth.blockscout.com/tx/0xabc" {
+		t.Fatalf("overridden explorer = %+v", ev.Explorer)
+	}
+	if ev := explorers.LinkOne(&Event{Chain: "polygon", Network: "mainnet", To: "0xdef"}); ev.Explorer.To != "https://polygonscan.com/address/0xdef" {
+		t.Fatalf("added explorer = %+v", ev.Explorer)
+	}
+	unknown := &Event{Chain: "bitcoin", Network: "mainnet"}
+	if explorers.LinkOne(unknown) != unknown {
+		t.Fatal("network without an explorer was linked")
+	}
+
+	for _, spec := range []string{
+		"polygon:mainnet=https://polygonscan.com/tx/{hash}",
+		"polygon=https://polygonscan.com/tx/{hash}|https://polygonscan.com/address/{address}",
+		"polygon:mainnet=https://polygonscan.com/tx/|https://polygonscan.com/address/{address}",
+		"polygon:mainnet=ftp://polygonscan.com/tx/{hash}|https://polygonscan.com/address/{address}",
+	} {
+		if _, err := ParseExplorerURLs(spec); err == nil {
+			t.Errorf("ParseExplorerURLs(%q) accepted", spec)
+		}
+	}
+}
+
+func TestTransactionsIncludeExplorerLinks(t *testing.T) {
+	store := NewEventStore(100, 50)
+	ev := makeEvent("1", "alice", "bob", "1", time.Now().UTC().Format(time.RFC3339), "")
+	ev.Chain, ev.Network, ev.TxHash = "ethereum", "sepolia", "0xabc"
+	store.Add(ev)
+
+	rec := httptest.NewRecorder()
+	getTransactions(store, rec, httptest.NewRequest(http.MethodGet, "/transactions", nil))
+	var events []*Event
+	if err := json.NewDecoder(rec.Body).Decode(&events); err != nil || len(events) != 1 || events[0].Explorer == nil {
+		t.Fatalf("events = %+v, %v", events, err)
+	}
+	if got := events[0].Explorer; got.Tx != "https://sepolia.etherscan.io/tx/0xabc" || got.From != "https://sepolia.etherscan.io/address/alice" {
+		t.Fatalf("explorer = %+v", got)
+	}
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultExplorerRPS is how many requests per second each explorer API
+	// key makes, overridable with EXPLORER_API_RPS; Etherscan's free tier
+	// allows 5.
+	defaultExplorerRPS = 5
+	// explorerPageSize is how many transactions an explorer request asks
+	// for.
+	explorerPageSize = 100
+	// explorerMaxResults is the deepest Etherscan pages: page times
+	// offset may not exceed it.
+	explorerMaxResults = 10000
+	// explorerAttempts is how many keys a rate-limited or rejected request
+	// is tried with.
+	explorerAttempts = 3
+	// explorerMaxBody bounds the response read from an explorer.
+	explorerMaxBody = 32 << 20
+)
+
+// Kinds of explorer API: Etherscan-compatible ones serve EVM networks
+// and Helius serves Solana.
+const (
+	explorerEtherscan = "etherscan"
+	explorerHelius    = "helius"
+)
+
+// builtinExplorerAPIs are the explorer API endpoints of the networks the
+// listeners support: Etherscan's and Helius's. Both need a key, so they
+// are only used with one in EXPLORER_API_KEYS.
+var builtinExplorerAPIs = map[string]string{
+	"ethereum:mainnet": "https://api.etherscan.io/v2/api?chainid=1",
+	"ethereum:sepolia": "https://api.etherscan.io/v2/api?chainid=11155111",
+	"ethereum:holesky": "https://api.etherscan.io/v2/api?chainid=17000",
+	"base:mainnet":     "https://api.etherscan.io/v2/api?chainid=8453",
+	"base:sepolia":     "https://api.etherscan.io/v2/api?chainid=84532",
+	"solana:mainnet":   "https://api.helius.xyz/v0",
+	"solana:devnet":    "https://api-devnet.helius.xyz/v0",
+}
+
+// explorerKind is the kind of explorer API serving chain.
+func explorerKind(chain string) string {
+	if chain == "solana" {
+		return explorerHelius
+	}
+	return explorerEtherscan
+}
+
+// explorerKey is an API key and what it has been used for. A key shared
+// by several networks, like an Etherscan key, is one explorerKey.
+type explorerKey struct {
+	key         string
+	requests    int
+	rateLimited int
+	disabled    string // why the explorer rejected the key
+}
+
+// explorerAPI is the explorer API of one network and the keys it is
+// queried with, in turn; a keyless API has one empty key.
+type explorerAPI struct {
+	kind string
+	url  *url.URL
+	keys []*explorerKey
+	next int
+}
+
+// ExplorerAPIs imports the history of wallets from explorer APIs:
+// Etherscan-family ones (Etherscan and its per-chain variants, Blockscout)
+// on EVM networks, which know a wallet's native transfers as well as its
+// token transfers and reach back to genesis, and Helius on Solana.
+// Requests are spread over the configured keys, each held to a rate; a
+// key the explorer rejects is set aside.
+type ExplorerAPIs struct {
+	client  *http.Client
+	limiter *RateLimiter // per key, or per host for keyless APIs
+
+	mu   sync.Mutex
+	apis map[string]*explorerAPI // chain:network -> API
+}
+
+// ExplorerKeyStatus describes a key without revealing it.
+type ExplorerKeyStatus struct {
+	Key         string `json:"key"`
+	Requests    int    `json:"requests"`
+	RateLimited int    `json:"rate_limited"`
+	Disabled    string `json:"disabled,omitempty"`
+}
+
+// ExplorerAPIStatus is an entry of GET /admin/explorers.
+type ExplorerAPIStatus struct {
+	Chain   string              `json:"chain"`
+	Network string              `json:"network"`
+	Kind    string              `json:"kind"`
+	URL     string              `json:"url"`
+	Keys    []ExplorerKeyStatus `json:"keys"`
+}
+
+// ParseExplorerAPIs reads EXPLORER_API_URLS, a comma-separated list of
+// chain:network=url entries adding or replacing the built-in endpoints,
+// and EXPLORER_API_KEYS, a comma-separated list of chain:network=key
+// entries and of bare keys used on every EVM network. A network may have
+// several keys. Built-in endpoints are only used with a key; endpoints
+// from urls without one are queried without. It returns nil when both are
+// empty.
+func ParseExplorerAPIs(urls, keys string, rps float64) (*ExplorerAPIs, error) {
+	if strings.TrimSpace(urls) == "" && strings.TrimSpace(keys) == "" {
+		return nil, nil
+	}
+	endpoints := make(map[string]string, len(builtinExplorerAPIs))
+	for pair, u := range builtinExplorerAPIs {
+		endpoints[pair] = u
+	}
+	custom := make(map[string]bool)
+	for _, item := range strings.Split(urls, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pair, u, ok := strings.Cut(item, "=")
+		parts := strings.Split(pair, ":")
+		if !ok || len(parts) != 2 || parts[0] == "" || parts[1] == "" ||
+			!strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+			return nil, fmt.Errorf("invalid explorer API %q: want chain:network=http(s) URL", item)
+		}
+		pair = strings.ToLower(pair)
+		endpoints[pair], custom[pair] = u, true
+	}
+
+	shared := make(map[string]*explorerKey)
+	keyOf := func(key string) *explorerKey {
+		if k, ok := shared[key]; ok {
+			return k
+		}
+		k := &explorerKey{key: key}
+		shared[key] = k
+		return k
+	}
+	var global []*explorerKey
+	perNetwork := make(map[string][]*explorerKey)
+	for _, item := range strings.Split(keys, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pair, key, ok := strings.Cut(item, "=")
+		if !ok {
+			global = append(global, keyOf(item))
+			continue
+		}
+		if parts := strings.Split(pair, ":"); len(parts) != 2 || parts[0] == "" || parts[1] == "" || key == "" {
+			return nil, fmt.Errorf("invalid explorer API key entry for %q: want chain:network=key or a bare key", pair)
+		}
+		pair = strings.ToLower(pair)
+		perNetwork[pair] = append(perNetwork[pair], keyOf(key))
+	}
+
+	e := &ExplorerAPIs{
+		client:  &http.Client{Timeout: 30 * time.Second},
+		limiter: NewRateLimiter(rps, 0),
+		apis:    make(map[string]*explorerAPI),
+	}
+	for pair, raw := range endpoints {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid explorer API URL %q", raw)
+		}
+		chain, _, _ := strings.Cut(pair, ":")
+		api := &explorerAPI{kind: explorerKind(chain), url: u, keys: perNetwork[pair]}
+		if api.kind == explorerEtherscan {
+			api.keys = append(api.keys, global...)
+		}
+		if len(api.keys) == 0 {
+			if !custom[pair] {
+				continue
+			}
+			api.keys = []*explorerKey{{}}
+		}
+		e.apis[pair] = api
+	}
+	return e, nil
+}
+
+// Has reports whether chain and network have an explorer API. Etherscan
+// APIs only serve EVM chains.
+func (e *ExplorerAPIs) Has(chain, network string) bool {
+	if e == nil {
+		return false
+	}
+	api, ok := e.apis[chain+":"+network]
+	if ok && api.kind == explorerEtherscan {
+		_, ok = chainAdapter(chain).(evmAdapter)
+	}
+	return ok
+}
+
+// explorerTx is an entry of the txlist and tokentx results.
+type explorerTx struct {
+	BlockNumber     string `json:"blockNumber"`
+	TimeStamp       string `json:"timeStamp"`
+	Hash            string `json:"hash"`
+	Nonce           string `json:"nonce"`
+	From            string `json:"from"`
+	To              string `json:"to"`
+	Value           string `json:"value"`
+	GasPrice        string `json:"gasPrice"`
+	GasUsed         string `json:"gasUsed"`
+	IsError         string `json:"isError"`
+	ContractAddress string `json:"contractAddress"`
+	TokenSymbol     string `json:"tokenSymbol"`
+	TokenDecimal    string `json:"tokenDecimal"`
+	// LogIndex is only returned by some explorers, Blockscout among them.
+	LogIndex string `json:"logIndex"`
+}
+
+// History returns the transfers of address on chain and network within
+// bound, newest first; bound.Blocks does not apply. On EVM networks, token
+// transfers whose log index the explorer does not return get it from the
+// transaction receipt through call, when not nil.
+func (e *ExplorerAPIs) History(ctx context.Context, chain, network, address string, bound HistoryBound, call RPCCaller) ([]*Event, error) {
+	if explorerKind(chain) == explorerHelius {
+		return e.heliusHistory(ctx, chain+":"+network, address, bound)
+	}
+	var events []*Event
+	for _, action := range []string{"txlist", "tokentx"} {
+		txs, err := e.transactions(ctx, chain+":"+network, action, address, bound)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", action, err)
+		}
+		for _, tx := range txs {
+			if ev, ok := explorerEvent(chain, action, tx, bound.Since); ok {
+				events = append(events, ev)
+			}
+		}
+	}
+	resolveLogIndexes(ctx, call, events)
+	sort.SliceStable(events, func(i, j int) bool {
+		if *events[i].BlockNumber != *events[j].BlockNumber {
+			return *events[i].BlockNumber > *events[j].BlockNumber
+		}
+		return events[i].EventID > events[j].EventID
+	})
+	if bound.Limit > 0 && len(events) > bound.Limit {
+		events = events[:bound.Limit]
+	}
+	return events, nil
+}
+
+// transactions pages through an account action of address, newest first,
+// until bound's limit or date is reached.
+func (e *ExplorerAPIs) transactions(ctx context.Context, pair, action, address string, bound HistoryBound) ([]explorerTx, error) {
+	var out []explorerTx
+	for page := 1; page*explorerPageSize <= explorerMaxResults; page++ {
+		result, err := e.get(ctx, pair, "", url.Values{
+			"module": {"account"}, "action": {action}, "address": {address}, "sort": {"desc"},
+			"page": {strconv.Itoa(page)}, "offset": {strconv.Itoa(explorerPageSize)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		var txs []explorerTx
+		if err := json.Unmarshal(result, &txs); err != nil {
+			return nil, fmt.Errorf("unexpected result: %w", err)
+		}
+		out = append(out, txs...)
+		if len(txs) < explorerPageSize || bound.Limit > 0 && len(out) >= bound.Limit {
+			return out, nil
+		}
+		if ts, err := strconv.ParseInt(txs[len(txs)-1].TimeStamp, 10, 64); err == nil && time.Unix(ts, 0).Before(bound.Since) {
+			return out, nil
+		}
+	}
+	return out, nil
+}
+
+// get makes a request to path under the API of pair and returns its
+// result: the body, or its result field on Etherscan APIs. A rate-limited
+// request is retried after a pause and a rejected key set aside, both with
+// the next key.
+func (e *ExplorerAPIs) get(ctx context.Context, pair, path string, params url.Values) (json.RawMessage, error) {
+	e.mu.Lock()
+	api, ok := e.apis[pair]
+	e.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no explorer API configured for %s", pair)
+	}
+	keyParam := "apikey"
+	if api.kind == explorerHelius {
+		keyParam = "api-key"
+	}
+	for attempt := 0; attempt < explorerAttempts; attempt++ {
+		key, err := e.pick(api)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.wait(ctx, api, key); err != nil {
+			return nil, err
+		}
+		query := api.url.Query()
+		for name, values := range params {
+			query[name] = values
+		}
+		if key.key != "" {
+			query.Set(keyParam, key.key)
+		}
+		u := *api.url
+		u.Path += path
+		u.RawQuery = query.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := e.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, explorerMaxBody))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			e.rateLimited(ctx, api, key)
+			continue
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			e.reject(api, key, resp.Status)
+			continue
+		case resp.StatusCode/100 != 2:
+			return nil, fmt.Errorf("%s: unexpected response: %s", api.url.Host, resp.Status)
+		case api.kind != explorerEtherscan:
+			return body, nil
+		}
+
+		var envelope struct {
+			Status  string          `json:"status"`
+			Message string          `json:"message"`
+			Result  json.RawMessage `json:"result"`
+		}
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			return nil, fmt.Errorf("%s: unexpected response: %w", api.url.Host, err)
+		}
+		// "No transactions found" has status 0 and an empty list
+		var msg string
+		if envelope.Status == "1" || json.Unmarshal(envelope.Result, &msg) != nil {
+			return envelope.Result, nil
+		}
+		switch lower := strings.ToLower(msg); {
+		case strings.Contains(lower, "rate limit"):
+			e.rateLimited(ctx, api, key)
+		case strings.Contains(lower, "api key"):
+			e.reject(api, key, msg)
+		default:
+			return nil, fmt.Errorf("%s: %s: %s", api.url.Host, envelope.Message, msg)
+		}
+	}
+	return nil, fmt.Errorf("%s: rate limited", api.url.Host)
+}
+
+// reject sets key aside for the rest of the process.
+func (e *ExplorerAPIs) reject(api *explorerAPI, key *explorerKey, reason string) {
+	e.mu.Lock()
+	key.disabled = reason
+	e.mu.Unlock()
+	log.WithFields(log.Fields{"explorer": api.url.Host, "key": maskKey(key.key), "reason": reason}).Warn("explorer rejected API key")
+}
+
+// pick returns the next usable key of api.
+func (e *ExplorerAPIs) pick(api *explorerAPI) (*explorerKey, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for range api.keys {
+		key := api.keys[api.next%len(api.keys)]
+		api.next++
+		if key.disabled == "" {
+			key.requests++
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: every API key was rejected", api.url.Host)
+}
+
+// wait waits until key may make a request.
+func (e *ExplorerAPIs) wait(ctx context.Context, api *explorerAPI, key *explorerKey) error {
+	client := key.key
+	if client == "" {
+		client = "host:" + api.url.Host
+	}
+	for {
+		ok, delay := e.limiter.Allow(client)
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// rateLimited counts a rate-limited request and pauses before the retry.
+func (e *ExplorerAPIs) rateLimited(ctx context.Context, api *explorerAPI, key *explorerKey) {
+	e.mu.Lock()
+	key.rateLimited++
+	e.mu.Unlock()
+	log.WithFields(log.Fields{"explorer": api.url.Host, "key": maskKey(key.key)}).Debug("explorer rate limit reached")
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+	}
+}
+
+// Status describes the explorer APIs and their keys, by chain and network.
+func (e *ExplorerAPIs) Status() []ExplorerAPIStatus {
+	out := make([]ExplorerAPIStatus, 0)
+	if e == nil {
+		return out
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for pair, api := range e.apis {
+		chain, network, _ := strings.Cut(pair, ":")
+		status := ExplorerAPIStatus{Chain: chain, Network: network, Kind: api.kind, URL: api.url.String(), Keys: make([]ExplorerKeyStatus, 0, len(api.keys))}
+		for _, key := range api.keys {
+			status.Keys = append(status.Keys, ExplorerKeyStatus{Key: maskKey(key.key), Requests: key.requests,
+				RateLimited: key.rateLimited, Disabled: key.disabled})
+		}
+		out = append(out, status)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Chain+":"+out[i].Network < out[j].Chain+":"+out[j].Network
+	})
+	return out
+}
+
+// maskKey keeps the last four characters of an API key.
+func maskKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	if len(key) <= 4 {
+		return "…"
+	}
+	return "…" + key[len(key)-4:]
+}
+
+// explorerEvent converts a txlist or tokentx entry. Failed transactions,
+// those moving no value and those older than since are left out.
+func explorerEvent(chain, action string, tx explorerTx, since time.Time) (*Event, bool) {
+	block, err := strconv.ParseUint(tx.BlockNumber, 10, 64)
+	if err != nil || tx.Hash == "" {
+		return nil, false
+	}
+	seconds, err := strconv.ParseInt(tx.TimeStamp, 10, 64)
+	if err != nil || time.Unix(seconds, 0).Before(since) {
+		return nil, false
+	}
+	value, ok := new(big.Int).SetString(tx.Value, 10)
+	if !ok || value.Sign() == 0 || tx.To == "" || tx.IsError == "1" {
+		return nil, false
+	}
+	hash := strings.ToLower(tx.Hash)
+	ev := &Event{
+		EventID:     "eth:" + hash,
+		Chain:       chain,
+		TxHash:      tx.Hash,
+		Timestamp:   time.Unix(seconds, 0).UTC().Format(time.RFC3339),
+		From:        tx.From,
+		To:          tx.To,
+		Value:       value.String(),
+		EventType:   "native_transfer",
+		BlockNumber: &block,
+	}
+	if nonce, err := strconv.ParseUint(tx.Nonce, 10, 64); err == nil {
+		ev.Nonce = &nonce
+	}
+	if action == "txlist" {
+		gasUsed, ok1 := new(big.Int).SetString(tx.GasUsed, 10)
+		gasPrice, ok2 := new(big.Int).SetString(tx.GasPrice, 10)
+		if ok1 && ok2 {
+			ev.Fee = new(big.Int).Mul(gasUsed, gasPrice).String()
+			if gasUsed.IsUint64() {
+				used := gasUsed.Uint64()
+				ev.GasUsed = &used
+			}
+		}
+		return ev, true
+	}
+	decimals, err := strconv.ParseUint(tx.TokenDecimal, 10, 8)
+	if err != nil || tx.ContractAddress == "" {
+		return nil, false
+	}
+	ev.EventType = "erc20_transfer"
+	ev.Token = &Token{Address: checksumAddress(tx.ContractAddress), Symbol: tx.TokenSymbol, Decimals: uint8(decimals)}
+	ev.EventID = ""
+	if index, err := strconv.ParseUint(tx.LogIndex, 10, 64); err == nil {
+		ev.LogIndex = &index
+		ev.EventID = fmt.Sprintf("eth:%s:log%d", hash, index)
+	}
+	return ev, true
+}
+
+// resolveLogIndexes finds the log of each token transfer in events that
+// has none in its transaction's receipt, through call, and names the
+// transfer after it as the listener does. Transfers still without a log
+// index are numbered by their order in the transaction instead, an
+// event_id the listener never uses.
+func resolveLogIndexes(ctx context.Context, call RPCCaller, events []*Event) {
+	byTx := make(map[string][]*Event)
+	var hashes []string
+	for _, ev := range events {
+		if ev.EventType == "erc20_transfer" && ev.LogIndex == nil {
+			hash := strings.ToLower(ev.TxHash)
+			if _, ok := byTx[hash]; !ok {
+				hashes = append(hashes, hash)
+			}
+			byTx[hash] = append(byTx[hash], ev)
+		}
+	}
+	for _, hash := range hashes {
+		transfers := byTx[hash]
+		if call != nil {
+			matchReceiptLogs(ctx, call, hash, transfers)
+		}
+		for n, ev := range transfers {
+			if ev.LogIndex == nil {
+				ev.EventID = fmt.Sprintf("eth:%s:transfer%d", hash, n)
+			}
+		}
+	}
+}
+
+// matchReceiptLogs sets the log index of transfers, all of transaction
+// hash, from the Transfer logs of its receipt with the same token, sender,
+// recipient and amount. A receipt that cannot be fetched leaves them as
+// they are.
+func matchReceiptLogs(ctx context.Context, call RPCCaller, hash string, transfers []*Event) {
+	result, err := call(ctx, "eth_getTransactionReceipt", hash)
+	if err != nil {
+		log.WithError(err).WithField("tx_hash", hash).Debug("could not fetch receipt of imported transfer")
+		return
+	}
+	var receipt struct {
+		Logs []evmLog `json:"logs"`
+	}
+	if result == nil || json.Unmarshal(result, &receipt) != nil {
+		return
+	}
+	used := make(map[int]bool)
+	for _, ev := range transfers {
+		for i, l := range receipt.Logs {
+			if used[i] || len(l.Topics) != 3 || len(l.Topics[1]) < 40 || len(l.Topics[2]) < 40 || !strings.EqualFold(l.Topics[0], erc20TransferTopic) ||
+				!strings.EqualFold(l.Address, ev.Token.Address) ||
+				!strings.EqualFold(l.Topics[1][len(l.Topics[1])-40:], strings.TrimPrefix(ev.From, "0x")) ||
+				!strings.EqualFold(l.Topics[2][len(l.Topics[2])-40:], strings.TrimPrefix(ev.To, "0x")) {
+				continue
+			}
+			value, ok := new(big.Int).SetString(strings.TrimPrefix(l.Data, "0x"), 16)
+			index, err := parseQuantity(l.LogIndex)
+			if !ok || err != nil || value.String() != ev.Value {
+				continue
+			}
+			used[i] = true
+			ev.LogIndex = &index
+			ev.EventID = fmt.Sprintf("eth:%s:log%d", hash, index)
+			break
+		}
+	}
+}
+
+// getExplorerStatus handles GET /admin/explorers.
+func getExplorerStatus(h *HistoryImporter, w http.ResponseWriter, r *http.Request) {
+	var explorers *ExplorerAPIs
+	if h != nil {
+		explorers = h.explorers
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(explorers.Status())
+}
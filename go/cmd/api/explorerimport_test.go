@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseExplorerAPIs(t *testing.T) {
+	if e, err := ParseExplorerAPIs("", " ", 5); e != nil || err != nil {
+		t.Fatalf("unconfigured explorers = %v, %v", e, err)
+	}
+	e, err := ParseExplorerAPIs("polygon:mainnet=https://polygon.blockscout.com/api", "sharedkey1234, base:mainnet=basekey9876", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The bare key enables every built-in endpoint; the custom one needs none
+	for _, pair := range []string{"ethereum:mainnet", "base:sepolia", "polygon:mainnet"} {
+		chain, network, _ := strings.Cut(pair, ":")
+		if !e.Has(chain, network) {
+			t.Errorf("no explorer API for %s", pair)
+		}
+	}
+	if e.Has("solana", "mainnet") || e.Has("arbitrum", "mainnet") {
+		t.Error("explorer API for a network without one")
+	}
+	for _, api := range e.Status() {
+		if api.Chain == "base" && api.Network == "mainnet" && (len(api.Keys) != 2 || api.Keys[0].Key != "…9876" || api.Keys[1].Key != "…1234") {
+			t.Errorf("base:mainnet keys = %+v", api.Keys)
+		}
+		if api.Chain == "polygon" && (len(api.Keys) != 1 || strings.Contains(api.URL, "apikey")) {
+			t.Errorf("polygon:mainnet = %+v", api)
+		}
+	}
+
+	if e, _ := ParseExplorerAPIs("polygon:mainnet=https://polygon.blockscout.com/api", "", 5); e.Has("ethereum", "mainnet") || !e.Has("polygon", "mainnet") {
+		t.Error("built-in endpoint used without a key")
+	}
+	for _, spec := range [][2]string{{"polygon=https://x", ""}, {"polygon:mainnet=ftp://x", ""}, {"", "ethereum=key"}, {"", "ethereum:mainnet="}} {
+		if _, err := ParseExplorerAPIs(spec[0], spec[1], 5); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
+
+// explorerServer answers txlist and tokentx like Etherscan: "bad" is an
+// invalid key and the first request with any other is rate limited.
+func explorerServer(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	limited := false
+	at := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("chainid") != "1" || !strings.EqualFold(q.Get("address"), aliceAddr) || q.Get("sort") != "desc" {
+			t.Errorf("query = %s", r.URL.RawQuery)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case q.Get("apikey") == "bad":
+			_, _ = w.Write([]byte(`{"status": "0", "message": "NOTOK", "result": "Invalid API Key"}`))
+		case !limited:
+			limited = true
+			_, _ = w.Write([]byte(`{"status": "0", "message": "NOTOK", "result": "Max rate limit reached"}`))
+		case q.Get("action") == "txlist":
+			_, _ = w.Write([]byte(`{"status": "1", "message": "OK", "result": [
+				{"blockNumber": "20", "timeStamp": "` + at + `", "hash": "0xAAA1", "nonce": "7", "from": "` + aliceAddr + `", "to": "` + bobAddr + `",
+					"value": "1000", "gasPrice": "10", "gasUsed": "21000", "isError": "0"},
+				{"blockNumber": "19", "timeStamp": "` + at + `", "hash": "0xAAA2", "nonce": "6", "from": "` + aliceAddr + `", "to": "` + bobAddr + `",
+					"value": "5", "gasPrice": "10", "gasUsed": "21000", "isError": "1"},
+				{"blockNumber": "18", "timeStamp": "` + at + `", "hash": "0xAAA3", "nonce": "5", "from": "` + aliceAddr + `", "to": "` + usdcAddr + `",
+					"value": "0", "gasPrice": "10", "gasUsed": "50000", "isError": "0"}]}`))
+		case q.Get("action") == "tokentx":
+			_, _ = w.Write([]byte(`{"status": "1", "message": "OK", "result": [
+				{"blockNumber": "19", "timeStamp": "` + at + `", "hash": "0xBBB1", "nonce": "8", "from": "` + strings.ToLower(aliceAddr) + `",
+					"to": "` + strings.ToLower(bobAddr) + `", "value": "250", "contractAddress": "` + strings.ToLower(usdcAddr) + `",
+					"tokenSymbol": "USDC", "tokenDecimal": "6"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"status": "0", "message": "No transactions found", "result": []}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// receiptRPC answers eth_getTransactionReceipt with a USDC transfer from
+// aliceAddr to bobAddr at log index 5, after a log of another token.
+func receiptRPC(t *testing.T) *httptest.Server {
+	padded := func(address string) string { return "0x000000000000000000000000" + strings.ToLower(address[2:]) }
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Method != "eth_getTransactionReceipt" {
+			t.Errorf("unexpected %s call", req.Method)
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": {"logs": [
+			{"address": "0x0000000000000000000000000000000000000001", "topics": ["` + erc20TransferTopic + `", "` + padded(aliceAddr) + `", "` + padded(bobAddr) + `"],
+				"data": "0x00000000000000000000000000000000000000000000000000000000000000fa", "logIndex": "0x4"},
+			{"address": "` + strings.ToLower(usdcAddr) + `", "topics": ["` + erc20TransferTopic + `", "` + padded(aliceAddr) + `", "` + padded(bobAddr) + `"],
+				"data": "0x00000000000000000000000000000000000000000000000000000000000000fa", "logIndex": "0x5"}]}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHistoryImporterUsesExplorerAPIs(t *testing.T) {
+	explorer := explorerServer(t)
+	builtin := builtinExplorerAPIs["ethereum:mainnet"]
+	builtinExplorerAPIs["ethereum:mainnet"] = explorer.URL + "/v2/api?chainid=1"
+	defer func() { builtinExplorerAPIs["ethereum:mainnet"] = builtin }()
+	explorers, err := ParseExplorerAPIs("", "ethereum:mainnet=bad,goodkey1", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewEventStore(100, 50)
+	h := newTestHistoryImporter(store, receiptRPC(t).URL, time.Second)
+	h.AttachExplorers(explorers)
+	imports := h.Wait(context.Background(), h.ImportUnseen(aliceAddr, "", ""), 5*time.Second)
+	if len(imports) != 1 || imports[0].Status != HistoryDone || imports[0].Source != "explorer" || imports[0].Imported != 2 {
+		t.Fatalf("imports = %+v", imports)
+	}
+	events := store.GetByWallet(aliceAddr, EventFilter{})
+	if len(events) != 2 {
+		t.Fatalf("stored %d events, want 2", len(events))
+	}
+	native, token := events[0], events[1]
+	if native.EventID != "eth:0xaaa1" || native.EventType != "native_transfer" || native.Fee != "210000" || *native.Nonce != 7 ||
+		native.Provenance != ProvenanceImported || native.Source != "import:etherscan" {
+		t.Fatalf("native = %+v", native)
+	}
+	if token.EventID != "eth:0xbbb1:log5" || *token.LogIndex != 5 || token.Token.Symbol != "USDC" || token.Token.Decimals != 6 ||
+		token.Token.Address != usdcAddr || token.Provenance != ProvenanceImported {
+		t.Fatalf("token = %+v, token %+v", token, token.Token)
+	}
+
+	// The invalid key was set aside after one request
+	status := explorers.Status()
+	for _, api := range status {
+		if api.Chain != "ethereum" || api.Network != "mainnet" {
+			continue
+		}
+		if bad, good := api.Keys[0], api.Keys[1]; bad.Disabled != "Invalid API Key" || bad.Requests != 1 || good.RateLimited != 1 {
+			t.Fatalf("keys = %+v", api.Keys)
+		}
+	}
+}
+
+func TestExplorerTransfersWithoutReceipt(t *testing.T) {
+	at := strconv.FormatInt(time.Now().Unix(), 10)
+	tx := explorerTx{BlockNumber: "9", TimeStamp: at, Hash: "0xCCC", From: aliceAddr, To: bobAddr, Value: "1",
+		ContractAddress: usdcAddr, TokenSymbol: "USDC", TokenDecimal: "6"}
+	first, _ := explorerEvent("ethereum", "tokentx", tx, time.Time{})
+	second, _ := explorerEvent("ethereum", "tokentx", tx, time.Time{})
+	resolveLogIndexes(context.Background(), nil, []*Event{first, second})
+	if first.EventID != "eth:0xccc:transfer0" || second.EventID != "eth:0xccc:transfer1" || first.LogIndex != nil {
+		t.Fatalf("event ids = %s, %s", first.EventID, second.EventID)
+	}
+	if _, ok := explorerEvent("ethereum", "tokentx", tx, time.Now().Add(time.Hour)); ok {
+		t.Fatal("transfer older than the bound kept")
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxExprLength caps the source length of a metric expression.
+const maxExprLength = 1000
+
+var errMissingField = errors.New("field has no value")
+
+// exprFields are the event fields expressions can refer to. Fields an event
+// does not have evaluate to nil; amount is the value in whole units and
+// is nil when the asset's decimals are unknown.
+var exprFields = map[string]func(ev *Event) interface{}{
+	"chain":        func(ev *Event) interface{} { return ev.Chain },
+	"network":      func(ev *Event) interface{} { return ev.Network },
+	"tx_hash":      func(ev *Event) interface{} { return ev.TxHash },
+	"from":         func(ev *Event) interface{} { return ev.From },
+	"to":           func(ev *Event) interface{} { return ev.To },
+	"from_label":   func(ev *Event) interface{} { return ev.FromLabel },
+	"to_label":     func(ev *Event) interface{} { return ev.ToLabel },
+	"event_type":   func(ev *Event) interface{} { return ev.EventType },
+	"status":       func(ev *Event) interface{} { return ev.Status },
+	"bridge":       func(ev *Event) interface{} { return ev.Bridge },
+	"source_chain": func(ev *Event) interface{} { return ev.SourceChain },
+	"dest_chain":   func(ev *Event) interface{} { return ev.DestChain },
+	"source":       func(ev *Event) interface{} { return ev.Source },
+	"token": func(ev *Event) interface{} {
+		if ev.Token == nil {
+			return nativeToken
+		}
+		return ev.Token.Symbol
+	},
+	"token_address": func(ev *Event) interface{} {
+		if ev.Token == nil {
+			return nil
+		}
+		return ev.Token.Address
+	},
+	"value": func(ev *Event) interface{} {
+		v, ok := new(big.Float).SetString(ev.Value)
+		if !ok {
+			return nil
+		}
+		f, _ := v.Float64()
+		return f
+	},
+	"amount": func(ev *Event) interface{} {
+		a, ok := eventAmount(ev)
+		if !ok {
+			return nil
+		}
+		f, _ := a.Whole().Float64()
+		return f
+	},
+	"block_number": func(ev *Event) interface{} {
+		if ev.BlockNumber == nil {
+			return nil
+		}
+		return float64(*ev.BlockNumber)
+	},
+	"slot": func(ev *Event) interface{} {
+		if ev.Slot == nil {
+			return nil
+		}
+		return float64(*ev.Slot)
+	},
+}
+
+// Expr is a compiled expression over an event's fields. Values are
+// float64 numbers, strings, booleans, or nil for missing fields.
+type Expr struct {
+	src  string
+	eval func(ev *Event) (interface{}, error)
+}
+
+func (e *Expr) String() string { return e.src }
+
+// Eval evaluates the expression for ev.
+func (e *Expr) Eval(ev *Event) (interface{}, error) { return e.eval(ev) }
+
+// EvalBool evaluates a condition. Conditions that cannot be evaluated for
+// ev, e.g. because a field is missing, are false.
+func (e *Expr) EvalBool(ev *Event) bool {
+	v, err := e.eval(ev)
+	b, ok := v.(bool)
+	return err == nil && ok && b
+}
+
+// EvalNumber evaluates a numeric expression.
+func (e *Expr) EvalNumber(ev *Event) (float64, error) {
+	v, err := e.eval(ev)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%s is not a number", formatExprValue(v))
+	}
+	return f, nil
+}
+
+// formatExprValue renders a value as a string, e.g. for grouping.
+func formatExprValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// ParseExpr compiles an expression. The grammar, loosest first:
+//
+//	a || b    a && b    !a
+//	a == b    a != b    a < b    a <= b    a > b    a >= b
+//	a + b     a - b     a * b    a / b     -a
+//	123.5  "text"  true  false  (a)  field  annotations.name
+func ParseExpr(src string) (*Expr, error) {
+	if len(src) > maxExprLength {
+		return nil, fmt.Errorf("expression longer than %d characters", maxExprLength)
+	}
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	eval, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return &Expr{src: src, eval: eval}, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string
+	pos  int
+	num  float64
+}
+
+func lexExpr(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c >= '0' && c <= '9' || c == '.':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			n, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at %d", src[i:j], i)
+			}
+			tokens = append(tokens, exprToken{kind: tokNumber, text: src[i:j], pos: i, num: n})
+			i = j
+		case c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			s, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d", i)
+			}
+			tokens = append(tokens, exprToken{kind: tokString, text: s, pos: i})
+			i = j + 1
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '.' || src[j] >= '0' && src[j] <= '9' || unicode.IsLetter(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: tokIdent, text: src[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "(", ")"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, exprToken{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, exprToken{kind: tokEOF, pos: len(src)}), nil
+}
+
+type evalFunc = func(ev *Event) (interface{}, error)
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek() exprToken { return p.tokens[p.pos] }
+
+// accept consumes the next token if it is one of ops.
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+// binary parses a left-associative chain of operands joined by ops.
+func (p *exprParser) binary(operand func() (evalFunc, error), apply func(op string, a, b interface{}) (interface{}, error), ops ...string) (evalFunc, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(ev *Event) (interface{}, error) {
+			a, err := l(ev)
+			if err != nil {
+				return nil, err
+			}
+			// Short-circuit logical operators
+			if b, ok := a.(bool); ok && (op == "&&" && !b || op == "||" && b) {
+				return b, nil
+			}
+			b, err := right(ev)
+			if err != nil {
+				return nil, err
+			}
+			return apply(op, a, b)
+		}
+	}
+}
+
+func (p *exprParser) or() (evalFunc, error) {
+	return p.binary(p.and, applyLogical, "||")
+}
+
+func (p *exprParser) and() (evalFunc, error) {
+	return p.binary(p.comparison, applyLogical, "&&")
+}
+
+func (p *exprParser) comparison() (evalFunc, error) {
+	return p.binary(p.additive, applyComparison, "==", "!=", "<=", ">=", "<", ">")
+}
+
+func (p *exprParser) additive() (evalFunc, error) {
+	return p.binary(p.multiplicative, applyArithmetic, "+", "-")
+}
+
+func (p *exprParser) multiplicative() (evalFunc, error) {
+	return p.binary(p.unary, applyArithmetic, "*", "/")
+}
+
+func (p *exprParser) unary() (evalFunc, error) {
+	op, ok := p.accept("!", "-")
+	if !ok {
+		return p.primary()
+	}
+	operand, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return func(ev *Event) (interface{}, error) {
+		v, err := operand(ev)
+		if err != nil {
+			return nil, err
+		}
+		if op == "!" {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("! needs a boolean, got %s", formatExprValue(v))
+			}
+			return !b, nil
+		}
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("- needs a number, got %s", formatExprValue(v))
+		}
+		return -f, nil
+	}, nil
+}
+
+func (p *exprParser) primary() (evalFunc, error) {
+	t := p.peek()
+	p.pos++
+	switch t.kind {
+	case tokNumber:
+		return func(*Event) (interface{}, error) { return t.num, nil }, nil
+	case tokString:
+		return func(*Event) (interface{}, error) { return t.text, nil }, nil
+	case tokIdent:
+		return identifier(t)
+	case tokOp:
+		if t.text == "(" {
+			inner, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.accept(")"); !ok {
+				return nil, fmt.Errorf("missing ) at %d", p.peek().pos)
+			}
+			return inner, nil
+		}
+	case tokEOF:
+		return nil, errors.New("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func identifier(t exprToken) (evalFunc, error) {
+	switch t.text {
+	case "true", "false":
+		b := t.text == "true"
+		return func(*Event) (interface{}, error) { return b, nil }, nil
+	}
+	if name := strings.TrimPrefix(t.text, "annotations."); name != t.text && name != "" {
+		return func(ev *Event) (interface{}, error) {
+			raw, ok := ev.Annotations[name]
+			if !ok {
+				return nil, nil
+			}
+			var v interface{}
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return nil, err
+			}
+			switch v.(type) {
+			case nil, float64, string, bool:
+				return v, nil
+			}
+			return nil, fmt.Errorf("annotation %s is not a number, string or boolean", name)
+		}, nil
+	}
+	field, ok := exprFields[t.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q at %d", t.text, t.pos)
+	}
+	return func(ev *Event) (interface{}, error) { return field(ev), nil }, nil
+}
+
+func applyLogical(op string, a, b interface{}) (interface{}, error) {
+	x, okA := a.(bool)
+	y, okB := b.(bool)
+	if !okA || !okB {
+		return nil, fmt.Errorf("%s needs booleans, got %s and %s", op, formatExprValue(a), formatExprValue(b))
+	}
+	if op == "&&" {
+		return x && y, nil
+	}
+	return x || y, nil
+}
+
+func applyComparison(op string, a, b interface{}) (interface{}, error) {
+	switch op {
+	case "==":
+		return a == b, nil
+	case "!=":
+		return a != b, nil
+	}
+	if a == nil || b == nil {
+		return nil, errMissingField
+	}
+	var cmp int
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare %s with %s", formatExprValue(a), formatExprValue(b))
+		}
+		switch {
+		case x < y:
+			cmp = -1
+		case x > y:
+			cmp = 1
+		}
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare %s with %s", formatExprValue(a), formatExprValue(b))
+		}
+		cmp = strings.Compare(x, y)
+	default:
+		return nil, fmt.Errorf("%s needs numbers or strings", op)
+	}
+	switch op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+func applyArithmetic(op string, a, b interface{}) (interface{}, error) {
+	if a == nil || b == nil {
+		return nil, errMissingField
+	}
+	x, okA := a.(float64)
+	y, okB := b.(float64)
+	if !okA || !okB {
+		return nil, fmt.Errorf("%s needs numbers, got %s and %s", op, formatExprValue(a), formatExprValue(b))
+	}
+	switch op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	}
+	if y == 0 {
+		return nil, errors.New("division by zero")
+	}
+	return x / y, nil
+}
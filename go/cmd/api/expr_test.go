@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestExprEval(t *testing.T) {
+	ev := makeEvent("1", aliceAddr, bobAddr, "2500000000000000000", time.Now().UTC().Format(time.RFC3339), "USDC")
+	ev.Annotations = map[string]json.RawMessage{"risk": json.RawMessage(`0.9`)}
+
+	for src, want := range map[string]interface{}{
+		`amount * 2 + 1`:                       6.0,
+		`-(amount - 0.5) / 2`:                  -1.0,
+		`token == "USDC" && amount >= 2.5`:     true,
+		`chain == "solana" || !(slot == slot)`: false,
+		`annotations.risk > 0.5`:               true,
+		`annotations.missing == annotations.x`: true,
+		`bridge`:                               "",
+		`token_address == "So11111111111111"`:  false,
+		`"b" > "a" && (1 < 2) == true`:         true,
+	} {
+		e, err := ParseExpr(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		got, err := e.Eval(ev)
+		if err != nil || got != want {
+			t.Errorf("%s = %v, %v; want %v", src, got, err, want)
+		}
+	}
+
+	// Missing fields make comparisons and arithmetic fail, and conditions false
+	e, _ := ParseExpr(`block_number > 10`)
+	if _, err := e.Eval(ev); err == nil || e.EvalBool(ev) {
+		t.Error("expected an error comparing a missing field")
+	}
+	e, _ = ParseExpr(`amount / 0`)
+	if _, err := e.EvalNumber(ev); err == nil {
+		t.Error("expected division by zero to fail")
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, src := range []string{``, `amount +`, `(amount`, `nonsense > 1`, `amount $ 2`, `"open`, `1 2`, `1..2`} {
+		if _, err := ParseExpr(src); err == nil {
+			t.Errorf("%q: expected a parse error", src)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+// Event confirmation statuses. Events default to confirmed (included in a
+// block) unless the indexer says otherwise.
+const (
+	StatusPending   = "pending"
+	StatusConfirmed = "confirmed"
+	StatusFinalized = "finalized"
+	StatusOrphaned  = "orphaned"
+)
+
+// confirmationsChannel is the Redis Pub/Sub channel indexers publish
+// confirmation and reorg updates on.
+const confirmationsChannel = "cross_chain_confirmations"
+
+// ConfirmationUpdate is published by indexers when events gain confirmations,
+// become final, or are dropped by a reorg. Either EventIDs is set, updating
+// exactly those events, or BlockNumber is set together with Chain:
+//
+//   - confirmed/finalized: every pending/confirmed event at or below
+//     BlockNumber moves to Status.
+//   - orphaned: every event at or above BlockNumber is orphaned (reorg).
+//
+// For Solana the slot is used as the block height.
+type ConfirmationUpdate struct {
+	Chain       string   `json:"chain"`
+	Network     string   `json:"network,omitempty"`
+	Status      string   `json:"status"`
+	EventIDs    []string `json:"event_ids,omitempty"`
+	BlockNumber *uint64  `json:"block_number,omitempty"`
+}
+
+// StatusChange is broadcast to live subscribers whenever an event's status
+// changes so UIs can promote pending transfers or remove orphaned ones.
+type StatusChange struct {
+	Type           string `json:"type"`
+	EventID        string `json:"event_id"`
+	Chain          string `json:"chain"`
+	Network        string `json:"network"`
+	TxHash         string `json:"tx_hash"`
+	Status         string `json:"status"`
+	PreviousStatus string `json:"previous_status"`
+	// Tenant owns the event; the change is only delivered to its
+	// subscribers and to those of the tenants in SharedWith.
+	Tenant     string   `json:"tenant,omitempty"`
+	SharedWith []string `json:"-"`
+	// From and To route the change to the watchers of the event's wallets.
+	From string `json:"-"`
+	To   string `json:"-"`
+	// Height and Timestamp locate the event for the reorg ledger.
+	Height    *uint64 `json:"-"`
+	Timestamp string  `json:"-"`
+}
+
+func validStatus(status string) bool {
+	switch status {
+	case StatusPending, StatusConfirmed, StatusFinalized, StatusOrphaned:
+		return true
+	}
+	return false
+}
+
+// Validate checks that the update targets either explicit events or a block
+// height on a chain.
+func (u ConfirmationUpdate) Validate() error {
+	if !validStatus(u.Status) {
+		return fmt.Errorf("invalid status %q", u.Status)
+	}
+	if len(u.EventIDs) == 0 {
+		if u.Chain == "" || u.BlockNumber == nil {
+			return fmt.Errorf("update needs event_ids or chain and block_number")
+		}
+		if u.Status == StatusPending {
+			return fmt.Errorf("block-range updates cannot mark events pending")
+		}
+	}
+	return nil
+}
+
+// eventHeight returns the block number, or the slot for Solana events.
+func eventHeight(ev *Event) (uint64, bool) {
+	if ev.BlockNumber != nil {
+		return *ev.BlockNumber, true
+	}
+	if ev.Slot != nil {
+		return *ev.Slot, true
+	}
+	return 0, false
+}
+
+// appliesTo reports whether the update moves the event to the new
+// status. Finalized events are never touched by a range update, not even
+// by a reorg.
+func (u ConfirmationUpdate) appliesTo(ev *Event) bool {
+	if ev.Status == u.Status {
+		return false
+	}
+	if len(u.EventIDs) > 0 {
+		for _, id := range u.EventIDs {
+			if id == ev.EventID {
+				return true
+			}
+		}
+		return false
+	}
+	if ev.Chain != u.Chain || (u.Network != "" && ev.Network != u.Network) {
+		return false
+	}
+	height, ok := eventHeight(ev)
+	if !ok {
+		return false
+	}
+	if ev.Status == StatusFinalized {
+		return false
+	}
+	if u.Status == StatusOrphaned {
+		return height >= *u.BlockNumber
+	}
+	if ev.Status == StatusOrphaned {
+		return false
+	}
+	return height <= *u.BlockNumber
+}
+
+// ApplyConfirmation updates event statuses in the cache and, when attached,
+// in the repository. It returns one StatusChange per event whose status
+// changed.
+func (s *EventStore) ApplyConfirmation(ctx context.Context, u ConfirmationUpdate) ([]StatusChange, error) {
+	if err := u.Validate(); err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]StatusChange)
+	if s.batch != nil {
+		// Buffered events must be stored before their status can change
+		if err := s.batch.Flush(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if s.repo != nil {
+		repoChanges, err := s.repo.ApplyConfirmation(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range repoChanges {
+			changes[c.EventID] = c
+		}
+	}
+	cacheChanges, _ := s.cache.ApplyConfirmation(ctx, u)
+	for _, c := range cacheChanges {
+		if _, ok := changes[c.EventID]; !ok {
+			changes[c.EventID] = c
+		}
+	}
+
+	out := make([]StatusChange, 0, len(changes))
+	for _, c := range changes {
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// handleConfirmation applies a single update and broadcasts the resulting
+// status changes to live subscribers. Reorgs are also announced as a system
+// notice when system is set.
+func handleConfirmation(ctx context.Context, store *EventStore, hub *Hub, system *SystemEvents, u ConfirmationUpdate) {
+	u.Chain = strings.ToLower(u.Chain)
+	changes, err := store.ApplyConfirmation(ctx, u)
+	if err != nil {
+		log.WithError(err).Warn("failed to apply confirmation update")
+		return
+	}
+	if len(changes) > 0 {
+		store.responses.InvalidateAll(ctx)
+		store.reorgs.Record(ctx, u, changes)
+		log.WithFields(log.Fields{"status": u.Status, "chain": u.Chain, "events": len(changes)}).Info("event statuses updated")
+	}
+	for _, c := range changes {
+		payload, err := json.Marshal(c)
+		if err != nil {
+			continue
+		}
+		hub.PublishEvent(&Event{Tenant: c.Tenant, SharedWith: c.SharedWith, From: c.From, To: c.To}, payload)
+	}
+	if system != nil && u.Status == StatusOrphaned && len(changes) > 0 {
+		notice := SystemEvent{
+			Kind:    SystemReorgDetected,
+			Chain:   u.Chain,
+			Network: u.Network,
+			Message: fmt.Sprintf("chain reorganization orphaned %d events", len(changes)),
+			Data:    map[string]interface{}{"orphaned": len(changes)},
+		}
+		if u.BlockNumber != nil {
+			notice.Data["block_number"] = *u.BlockNumber
+		}
+		if err := system.Emit(notice); err != nil {
+			log.WithError(err).Warn("failed to emit reorg notice")
+		}
+	}
+}
+
+// subscribeToConfirmations consumes confirmation and reorg updates published
+// by indexers on Redis.
+func subscribeToConfirmations(ctx context.Context, redisURL string, store *EventStore, hub *Hub, system *SystemEvents) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		log.Fatalf("could not parse redis url: %v", err)
+	}
+
+	rdb := redis.NewClient(opt)
+	pubsub := rdb.Subscribe(ctx, confirmationsChannel)
+	defer pubsub.Close()
+
+	log.Infof("subscribing to %s", confirmationsChannel)
+
+	for msg := range pubsub.Channel() {
+		var u ConfirmationUpdate
+		if err := json.Unmarshal([]byte(msg.Payload), &u); err != nil {
+			log.WithError(err).Error("could not unmarshal confirmation update")
+			continue
+		}
+		updateCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		handleConfirmation(updateCtx, store, hub, system, u)
+		cancel()
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeBlockEvent(id string, block uint64) *Event {
+	ev := makeEvent(id, "alice", "bob", "1", time.Now().UTC().Format(time.RFC3339), "")
+	ev.Chain = "ethereum"
+	ev.Network = "sepolia"
+	ev.BlockNumber = &block
+	return ev
+}
+
+func TestApplyConfirmationFinalizesUpToBlock(t *testing.T) {
+	store := NewEventStore(100, 50)
+	store.Add(makeBlockEvent("a", 10))
+	store.Add(makeBlockEvent("b", 11))
+	store.Add(makeBlockEvent("c", 12))
+
+	height := uint64(11)
+	changes, err := store.ApplyConfirmation(context.Background(), ConfirmationUpdate{
+		Chain: "ethereum", Status: StatusFinalized, BlockNumber: &height,
+	})
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 status changes, got %+v", changes)
+	}
+	for _, c := range changes {
+		if c.PreviousStatus != StatusConfirmed || c.Status != StatusFinalized {
+			t.Fatalf("unexpected change: %+v", c)
+		}
+	}
+	if got := store.GetRecent(EventFilter{Limit: 10, Status: StatusFinalized}); len(got) != 2 {
+		t.Fatalf("expected 2 finalized events, got %d", len(got))
+	}
+}
+
+func TestApplyConfirmationOrphansOnReorg(t *testing.T) {
+	store := NewEventStore(100, 50)
+	store.Add(makeBlockEvent("a", 10))
+	store.Add(makeBlockEvent("b", 11))
+
+	height := uint64(11)
+	if _, err := store.ApplyConfirmation(context.Background(), ConfirmationUpdate{
+		Chain: "ethereum", Status: StatusOrphaned, BlockNumber: &height,
+	}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	// Orphaned events are dropped by default and visible only on request
+	if got := store.GetByWallet("alice", EventFilter{Limit: 10}); len(got) != 1 || got[0].EventID != "a" {
+		t.Fatalf("expected only event a, got %+v", got)
+	}
+	if got := store.GetRecent(EventFilter{Limit: 10, Status: StatusOrphaned}); len(got) != 1 || got[0].EventID != "b" {
+		t.Fatalf("expected orphaned event b, got %+v", got)
+	}
+}
+
+func TestConfirmationUpdateValidate(t *testing.T) {
+	height := uint64(1)
+	cases := []struct {
+		u     ConfirmationUpdate
+		valid bool
+	}{
+		{ConfirmationUpdate{Status: StatusFinalized, EventIDs: []string{"x"}}, true},
+		{ConfirmationUpdate{Status: StatusFinalized, Chain: "ethereum", BlockNumber: &height}, true},
+		{ConfirmationUpdate{Status: "bogus", EventIDs: []string{"x"}}, false},
+		{ConfirmationUpdate{Status: StatusFinalized, Chain: "ethereum"}, false},
+		{ConfirmationUpdate{Status: StatusPending, Chain: "ethereum", BlockNumber: &height}, false},
+	}
+	for i, tc := range cases {
+		if err := tc.u.Validate(); (err == nil) != tc.valid {
+			t.Fatalf("case %d: expected valid=%v, got err=%v", i, tc.valid, err)
+		}
+	}
+}
+
+func TestHandleConfirmationBroadcastsStatusChange(t *testing.T) {
+	store := NewEventStore(100, 50)
+	store.Add(makeBlockEvent("a", 10))
+
+	hub := NewHub()
+	go hub.Run()
+	tw := newTestRW()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/events/subscribe", nil).WithContext(ctx)
+	go serveSSE(hub, tw, req)
+
+	waitUntil := time.Now().Add(time.Second)
+	for time.Now().Before(waitUntil) {
+		hub.mu.Lock()
+		n := len(hub.clients)
+		hub.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	handleConfirmation(context.Background(), store, hub, nil, ConfirmationUpdate{Status: StatusFinalized, EventIDs: []string{"a"}})
+
+	select {
+	case b := <-tw.writes:
+		var c StatusChange
+		data := b[strings.Index(string(b), "data: ")+len("data: "):]
+		if err := json.Unmarshal(data, &c); err != nil {
+			t.Fatalf("decode: %v (%s)", err, b)
+		}
+		if c.Type != "status_change" || c.EventID != "a" || c.Status != StatusFinalized {
+			t.Fatalf("unexpected status change: %+v", c)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not receive status change")
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultGraphDepth = 2
+	maxGraphDepth     = 4
+	// maxGraphNodes bounds the size of an exported graph so a hub wallet
+	// (exchange, bridge) can't explode the response.
+	maxGraphNodes = 500
+)
+
+// GraphNode is a wallet in the money-flow graph. Depth is the number of hops
+// from the root wallet.
+type GraphNode struct {
+	ID    string `json:"id"`
+	Depth int    `json:"depth"`
+}
+
+// GraphEdge aggregates all transfers between two wallets in one asset.
+// Value is the exact sum of their raw values, as a decimal string.
+type GraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Chain  string `json:"chain"`
+	Token  string `json:"token,omitempty"`
+	Value  string `json:"value"`
+	Count  int    `json:"count"`
+}
+
+// MoneyFlowGraph is the directed graph of transfers reachable from a wallet.
+type MoneyFlowGraph struct {
+	Root  string      `json:"root"`
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// BuildMoneyFlowGraph walks transfers breadth-first from root, in both
+// directions, up to depth hops. Edges between the same pair of wallets in the
+// same asset are merged and their values summed.
+func BuildMoneyFlowGraph(store *EventStore, root string, depth int, filter EventFilter) MoneyFlowGraph {
+	g := MoneyFlowGraph{Root: root, Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+
+	// Wallets are keyed by addressKey, so differently-cased forms of an EVM
+	// address are one node, named as first seen
+	depths := map[string]int{addressKey(root): 0}
+	names := map[string]string{addressKey(root): root}
+	order := []string{root}
+	edges := make(map[string]*GraphEdge)
+	sums := make(map[string]*big.Int)
+	seenEvents := make(map[string]bool)
+
+	frontier := []string{root}
+	for d := 0; d < depth && len(frontier) > 0; d++ {
+		var next []string
+		for _, addr := range frontier {
+			f := filter
+			f.Offset = 0
+			f.Limit = maxEventsPerWallet
+			for _, ev := range store.GetByWallet(addr, f) {
+				if seenEvents[ev.EventID] {
+					continue
+				}
+				seenEvents[ev.EventID] = true
+
+				for _, peer := range []string{ev.From, ev.To} {
+					if _, ok := depths[addressKey(peer)]; ok || len(depths) >= maxGraphNodes {
+						continue
+					}
+					depths[addressKey(peer)] = d + 1
+					names[addressKey(peer)] = peer
+					order = append(order, peer)
+					next = append(next, peer)
+				}
+				_, fromOK := depths[addressKey(ev.From)]
+				_, toOK := depths[addressKey(ev.To)]
+				if !fromOK || !toOK {
+					continue
+				}
+
+				token := ""
+				if ev.Token != nil {
+					token = ev.Token.Symbol
+				}
+				from, to := names[addressKey(ev.From)], names[addressKey(ev.To)]
+				key := from + "|" + to + "|" + ev.Chain + "|" + token
+				edge, ok := edges[key]
+				if !ok {
+					edge = &GraphEdge{Source: from, Target: to, Chain: ev.Chain, Token: token}
+					edges[key] = edge
+					sums[key] = new(big.Int)
+				}
+				if val, ok := new(big.Int).SetString(ev.Value, 10); ok && val.Sign() >= 0 {
+					sums[key].Add(sums[key], val)
+				}
+				edge.Count++
+			}
+		}
+		frontier = next
+	}
+
+	for _, id := range order {
+		g.Nodes = append(g.Nodes, GraphNode{ID: id, Depth: depths[addressKey(id)]})
+	}
+	for key, e := range edges {
+		e.Value = sums[key].String()
+		g.Edges = append(g.Edges, *e)
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].Source != g.Edges[j].Source {
+			return g.Edges[i].Source < g.Edges[j].Source
+		}
+		if g.Edges[i].Target != g.Edges[j].Target {
+			return g.Edges[i].Target < g.Edges[j].Target
+		}
+		return g.Edges[i].Token < g.Edges[j].Token
+	})
+	return g
+}
+
+// writeGraphML renders the graph as GraphML for tools such as yEd or Gephi.
+func writeGraphML(w io.Writer, g MoneyFlowGraph) error {
+	esc := func(s string) string {
+		var b strings.Builder
+		_ = xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	b.WriteString(`  <key id="depth" for="node" attr.name="depth" attr.type="int"/>` + "\n")
+	b.WriteString(`  <key id="chain" for="edge" attr.name="chain" attr.type="string"/>` + "\n")
+	b.WriteString(`  <key id="token" for="edge" attr.name="token" attr.type="string"/>` + "\n")
+	b.WriteString(`  <key id="value" for="edge" attr.name="value" attr.type="double"/>` + "\n")
+	b.WriteString(`  <key id="count" for="edge" attr.name="count" attr.type="int"/>` + "\n")
+	fmt.Fprintf(&b, "  <graph id=\"%s\" edgedefault=\"directed\">\n", esc(g.Root))
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "    <node id=\"%s\"><data key=\"depth\">%d</data></node>\n", esc(n.ID), n.Depth)
+	}
+	for i, e := range g.Edges {
+		fmt.Fprintf(&b, "    <edge id=\"e%d\" source=\"%s\" target=\"%s\">", i, esc(e.Source), esc(e.Target))
+		fmt.Fprintf(&b, "<data key=\"chain\">%s</data><data key=\"token\">%s</data>", esc(e.Chain), esc(e.Token))
+		fmt.Fprintf(&b, "<data key=\"value\">%s</data><data key=\"count\">%d</data></edge>\n", e.Value, e.Count)
+	}
+	b.WriteString("  </graph>\n</graphml>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeDOT renders the graph in Graphviz DOT format.
+func writeDOT(w io.Writer, g MoneyFlowGraph) error {
+	var b strings.Builder
+	b.WriteString("digraph money_flow {\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "  %s [depth=%d];\n", strconv.Quote(n.ID), n.Depth)
+	}
+	for _, e := range g.Edges {
+		label := e.Value
+		if e.Token != "" {
+			label += " " + e.Token
+		}
+		fmt.Fprintf(&b, "  %s -> %s [label=%s, chain=%s, count=%d];\n",
+			strconv.Quote(e.Source), strconv.Quote(e.Target), strconv.Quote(label), strconv.Quote(e.Chain), e.Count)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeGephiCSV renders the graph as a Gephi edge-list CSV. Gephi derives the
+// node table from the Source and Target columns.
+func writeGephiCSV(w io.Writer, g MoneyFlowGraph) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"Source", "Target", "Type", "Weight", "Chain", "Token", "Count"})
+	for _, e := range g.Edges {
+		_ = cw.Write([]string{
+			e.Source, e.Target, "Directed",
+			e.Value,
+			e.Chain, e.Token, strconv.Itoa(e.Count),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// getWalletGraph exports the money-flow graph around a wallet in the format
+// requested via ?format= (json, graphml, dot or csv).
+func getWalletGraph(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	p := newQueryParams(r)
+	depth := p.Int("depth", defaultGraphDepth, 1, maxGraphDepth)
+	chain, network := p.Network()
+	filter := EventFilter{
+		Chain:   chain,
+		Network: network,
+		Token:   p.String("token"),
+		Tenant:  tenantFrom(r.Context()),
+	}
+	format := p.String("format")
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	address, err := pathAddress(r, filter.Chain)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	g := BuildMoneyFlowGraph(store, address, depth, filter)
+
+	switch format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(g)
+	case "graphml":
+		w.Header().Set("Content-Type", "application/graphml+xml")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", address+".graphml"))
+		err = writeGraphML(w, g)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", address+".dot"))
+		err = writeDOT(w, g)
+	case "csv", "gephi":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", address+".csv"))
+		err = writeGephiCSV(w, g)
+	default:
+		badRequest(w, invalidParam("format", "unsupported format: %s", format))
+		return
+	}
+	if err != nil {
+		log.WithError(err).Warn("failed to write graph export")
+	}
+}
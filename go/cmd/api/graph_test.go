@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newGraphTestStore() *EventStore {
+	store := NewEventStore(1000, 100)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	store.Add(makeEvent("1", "root", "a", "10", ts, ""))
+	store.Add(makeEvent("2", "root", "a", "5", ts, ""))
+	store.Add(makeEvent("3", "b", "root", "7", ts, "USDC"))
+	store.Add(makeEvent("4", "a", "c", "3", ts, ""))
+	store.Add(makeEvent("5", "c", "d", "1", ts, ""))
+	return store
+}
+
+func TestBuildMoneyFlowGraph(t *testing.T) {
+	g := BuildMoneyFlowGraph(newGraphTestStore(), "ROOT", 2, EventFilter{})
+
+	if g.Root != "ROOT" {
+		t.Fatalf("expected root as requested, got %q", g.Root)
+	}
+	// depth 2 reaches root, a, b, c but not d, matching root in any case
+	if len(g.Nodes) != 4 {
+		t.Fatalf("expected 4 nodes, got %+v", g.Nodes)
+	}
+	if len(g.Edges) != 3 {
+		t.Fatalf("expected 3 edges, got %+v", g.Edges)
+	}
+	for _, e := range g.Edges {
+		if e.Source == "root" && e.Target == "a" && (e.Value != "15" || e.Count != 2) {
+			t.Fatalf("expected merged root->a edge with value 15, got %+v", e)
+		}
+	}
+}
+
+func TestBuildMoneyFlowGraphSumsExactly(t *testing.T) {
+	store := NewEventStore(100, 50)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	// 2^53+1 lamports twice; float64 would lose the odd lamports
+	store.Add(makeEvent("1", "root", "a", "9007199254740993", ts, ""))
+	store.Add(makeEvent("2", "root", "a", "9007199254740993", ts, ""))
+
+	g := BuildMoneyFlowGraph(store, "root", 1, EventFilter{})
+	if len(g.Edges) != 1 || g.Edges[0].Value != "18014398509481986" {
+		t.Fatalf("expected an exact sum, got %+v", g.Edges)
+	}
+}
+
+func TestGraphRenderers(t *testing.T) {
+	g := BuildMoneyFlowGraph(newGraphTestStore(), "root", 1, EventFilter{})
+
+	var gml bytes.Buffer
+	if err := writeGraphML(&gml, g); err != nil {
+		t.Fatalf("graphml: %v", err)
+	}
+	if err := xml.Unmarshal(gml.Bytes(), new(struct{})); err != nil {
+		t.Fatalf("graphml is not well-formed XML: %v", err)
+	}
+
+	var dot bytes.Buffer
+	if err := writeDOT(&dot, g); err != nil {
+		t.Fatalf("dot: %v", err)
+	}
+	if !strings.HasPrefix(dot.String(), "digraph") || !strings.Contains(dot.String(), `"root" -> "a"`) {
+		t.Fatalf("unexpected dot output: %s", dot.String())
+	}
+
+	var buf bytes.Buffer
+	if err := writeGephiCSV(&buf, g); err != nil {
+		t.Fatalf("csv: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("csv parse: %v", err)
+	}
+	if len(rows) != len(g.Edges)+1 || rows[0][0] != "Source" {
+		t.Fatalf("unexpected csv rows: %v", rows)
+	}
+}
+
+func TestGetWalletGraphFormats(t *testing.T) {
+	store := newGraphTestStore()
+	store.Add(makeEvent("6", aliceAddr, "root", "1", time.Now().UTC().Format(time.RFC3339), ""))
+
+	cases := map[string]int{"": http.StatusOK, "graphml": http.StatusOK, "dot": http.StatusOK, "csv": http.StatusOK, "pdf": http.StatusBadRequest}
+	for format, want := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/wallet/"+aliceAddr+"/graph?format="+format, nil)
+		req = withChiParam(req, "address", aliceAddr)
+		r := httptest.NewRecorder()
+		getWalletGraph(store, r, req)
+		if r.Code != want {
+			t.Fatalf("format %q: expected %d, got %d", format, want, r.Code)
+		}
+		if format == "" {
+			var g MoneyFlowGraph
+			if err := json.NewDecoder(r.Body).Decode(&g); err != nil || g.Root != checksumAddress(aliceAddr) || len(g.Nodes) < 2 {
+				t.Fatalf("expected json graph, got err=%v %+v", err, g)
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/KonstantinosChonas/cross-chain-tracker/go/proto/trackerpb"
+)
+
+// grpcSubscriberBuffer is the number of events a gRPC subscriber may fall
+// behind before the hub drops it.
+const grpcSubscriberBuffer = 64
+
+// trackerServer implements the gRPC Tracker service on top of the same store
+// and hub that back the REST and SSE endpoints.
+type trackerServer struct {
+	trackerpb.UnimplementedTrackerServer
+	store *EventStore
+	hub   *Hub
+}
+
+// ListTransactions returns recent events across all wallets the caller's
+// tenant may see.
+func (s *trackerServer) ListTransactions(ctx context.Context, req *trackerpb.ListTransactionsRequest) (*trackerpb.ListTransactionsResponse, error) {
+	filter := filterFromProto(req.GetFilter())
+	filter.Tenant = tenantFrom(ctx)
+	events := s.store.GetRecent(filter)
+	return &trackerpb.ListTransactionsResponse{Events: toProtoEvents(events)}, nil
+}
+
+// GetWallet returns a wallet's event history.
+func (s *trackerServer) GetWallet(ctx context.Context, req *trackerpb.GetWalletRequest) (*trackerpb.GetWalletResponse, error) {
+	if req.GetAddress() == "" {
+		return nil, status.Error(codes.InvalidArgument, "address is required")
+	}
+	address, err := canonicalAddress(req.GetFilter().GetChain(), req.GetAddress())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "address: %v", err)
+	}
+	filter := filterFromProto(req.GetFilter())
+	filter.Tenant = tenantFrom(ctx)
+	events := s.store.GetByWallet(address, filter)
+	return &trackerpb.GetWalletResponse{Address: address, Events: toProtoEvents(events)}, nil
+}
+
+// SubscribeEvents streams live events matching the request filter until the
+// client disconnects or the hub drops the subscriber for falling behind.
+func (s *trackerServer) SubscribeEvents(req *trackerpb.SubscribeEventsRequest, stream trackerpb.Tracker_SubscribeEventsServer) error {
+	ctx := stream.Context()
+	filter := filterFromProto(req.GetFilter())
+	filter.Tenant = tenantFrom(ctx)
+
+	messageChan := make(chan Frame, grpcSubscriberBuffer)
+	if filter.Tenant != "" {
+		s.hub.join(messageChan, filter.Tenant)
+	} else {
+		s.hub.register <- messageChan
+	}
+	defer func() {
+		s.hub.unregister <- messageChan
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case frame, ok := <-messageChan:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "subscriber fell behind")
+			}
+			// Status changes and other typed notifications are not events
+			var probe struct {
+				Type string `json:"type"`
+			}
+			if err := json.Unmarshal(frame.Data, &probe); err == nil && probe.Type != "" {
+				continue
+			}
+			var event Event
+			if err := json.Unmarshal(frame.Data, &event); err != nil {
+				log.WithError(err).Warn("grpc: could not decode broadcast event")
+				continue
+			}
+			if !filter.Matches(&event) {
+				continue
+			}
+			if err := stream.Send(toProtoEvent(&event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// grpcTenant resolves the tenant of a gRPC call from its x-api-key metadata,
+// the same way requireTenant does for HTTP requests.
+func grpcTenant(ctx context.Context, tenants *Tenants) (context.Context, error) {
+	if !tenants.Enabled() {
+		return ctx, nil
+	}
+	var key string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-api-key"); len(values) > 0 {
+			key = values[0]
+		}
+	}
+	tenant, ok := tenants.Authenticate(key)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid API key")
+	}
+	return withTenant(ctx, tenant), nil
+}
+
+// tenantUnaryInterceptor scopes unary calls to the caller's tenant.
+func tenantUnaryInterceptor(tenants *Tenants) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := grpcTenant(ctx, tenants)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// tenantStreamInterceptor scopes streaming calls to the caller's tenant.
+func tenantStreamInterceptor(tenants *Tenants) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := grpcTenant(ss.Context(), tenants)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &tenantStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// tenantStream is a ServerStream carrying the tenant-scoped context.
+type tenantStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tenantStream) Context() context.Context { return s.ctx }
+
+// newGRPCServer returns a gRPC server for the tracker service that
+// authenticates callers against tenants.
+func newGRPCServer(store *EventStore, hub *Hub, tenants *Tenants) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(tenantUnaryInterceptor(tenants)),
+		grpc.StreamInterceptor(tenantStreamInterceptor(tenants)),
+	)
+	trackerpb.RegisterTrackerServer(srv, &trackerServer{store: store, hub: hub})
+	return srv
+}
+
+// serveGRPC starts the gRPC server on bindAddr. It blocks until the listener
+// fails.
+func serveGRPC(bindAddr string, store *EventStore, hub *Hub, tenants *Tenants) error {
+	lis, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		return err
+	}
+	srv := newGRPCServer(store, hub, tenants)
+	log.Infof("grpc: listening on %s", bindAddr)
+	return srv.Serve(lis)
+}
+
+// filterFromProto converts a protobuf filter into an EventFilter, applying the
+// same defaults and page-size cap as the REST handlers.
+func filterFromProto(f *trackerpb.EventFilter) EventFilter {
+	// An unknown chain id matches nothing, as an unknown chain name does
+	chain, network, err := resolveNetwork(f.GetChain(), f.GetNetwork())
+	if err != nil {
+		chain, network = f.GetChain(), f.GetNetwork()
+	}
+	filter := EventFilter{
+		Chain:   chain,
+		Network: network,
+		Token:   f.GetToken(),
+		From:    f.GetFrom(),
+		To:      f.GetTo(),
+		Limit:   defaultPageSize,
+		Offset:  0,
+	}
+	if f.GetMinValue() > 0 {
+		// Parse the shortest decimal form, so 0.1 means 0.1 exactly
+		filter.MinValue, _ = new(big.Rat).SetString(strconv.FormatFloat(f.GetMinValue(), 'g', -1, 64))
+	}
+	switch {
+	case f.GetLimit() > maxPageSize:
+		filter.Limit = maxPageSize
+	case f.GetLimit() > 0:
+		filter.Limit = int(f.GetLimit())
+	}
+	if f.GetOffset() > 0 {
+		filter.Offset = int(f.GetOffset())
+	}
+	if startTime, err := time.Parse(time.RFC3339, f.GetStartTime()); err == nil {
+		filter.StartTime = &startTime
+	}
+	if endTime, err := time.Parse(time.RFC3339, f.GetEndTime()); err == nil {
+		filter.EndTime = &endTime
+	}
+	return filter
+}
+
+// toProtoEvent converts a normalized event into its protobuf representation.
+func toProtoEvent(ev *Event) *trackerpb.Event {
+	out := &trackerpb.Event{
+		EventId:   ev.EventID,
+		Chain:     ev.Chain,
+		Network:   ev.Network,
+		TxHash:    ev.TxHash,
+		Timestamp: ev.Timestamp,
+		From:      ev.From,
+		To:        ev.To,
+		Value:     ev.Value,
+		EventType: ev.EventType,
+		Slot:      ev.Slot,
+	}
+	if ev.Token != nil {
+		out.Token = &trackerpb.Token{
+			Address:  ev.Token.Address,
+			Symbol:   ev.Token.Symbol,
+			Decimals: uint32(ev.Token.Decimals),
+		}
+	}
+	return out
+}
+
+func toProtoEvents(events []*Event) []*trackerpb.Event {
+	out := make([]*trackerpb.Event, 0, len(events))
+	for _, ev := range events {
+		out = append(out, toProtoEvent(ev))
+	}
+	return out
+}
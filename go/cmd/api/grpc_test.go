@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/KonstantinosChonas/cross-chain-tracker/go/proto/trackerpb"
+)
+
+// newTestGRPCClient serves the tracker service over an in-memory listener and
+// returns a connected client.
+func newTestGRPCClient(t *testing.T, store *EventStore, hub *Hub) trackerpb.TrackerClient {
+	return newTestTenantGRPCClient(t, store, hub, nil)
+}
+
+// newTestTenantGRPCClient is newTestGRPCClient for a server that
+// authenticates callers against tenants.
+func newTestTenantGRPCClient(t *testing.T, store *EventStore, hub *Hub, tenants *Tenants) trackerpb.TrackerClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(store, hub, tenants)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return trackerpb.NewTrackerClient(conn)
+}
+
+func TestGRPCListAndGetWallet(t *testing.T) {
+	store := NewEventStore(1000, 100)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	store.Add(makeEvent("1", aliceAddr, bobAddr, "1.0", ts, ""))
+	store.Add(makeEvent("2", carolAddr, aliceAddr, "2.0", ts, "USDC"))
+
+	client := newTestGRPCClient(t, store, NewHub())
+	ctx := context.Background()
+
+	list, err := client.ListTransactions(ctx, &trackerpb.ListTransactionsRequest{})
+	if err != nil {
+		t.Fatalf("ListTransactions: %v", err)
+	}
+	if len(list.GetEvents()) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(list.GetEvents()))
+	}
+
+	wallet, err := client.GetWallet(ctx, &trackerpb.GetWalletRequest{
+		Address: checksumAddress(aliceAddr),
+		Filter:  &trackerpb.EventFilter{Token: "USDC"},
+	})
+	if err != nil {
+		t.Fatalf("GetWallet: %v", err)
+	}
+	if len(wallet.GetEvents()) != 1 || wallet.GetEvents()[0].GetToken().GetSymbol() != "USDC" {
+		t.Fatalf("expected one USDC event, got %+v", wallet.GetEvents())
+	}
+
+	if _, err := client.GetWallet(ctx, &trackerpb.GetWalletRequest{}); err == nil {
+		t.Fatalf("expected error for empty address")
+	}
+}
+
+func TestGRPCScopesCallsToTenant(t *testing.T) {
+	tenants, err := ParseTenants("treasury=k1,ops=k2", "treasury="+aliceAddr+",ops="+carolAddr)
+	if err != nil {
+		t.Fatalf("ParseTenants: %v", err)
+	}
+	store := NewEventStore(1000, 100)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	for _, ev := range []*Event{
+		makeEvent("1", aliceAddr, bobAddr, "1.0", ts, ""),
+		makeEvent("2", carolAddr, bobAddr, "2.0", ts, ""),
+	} {
+		tenants.Tag(ev)
+		store.Add(ev)
+	}
+	client := newTestTenantGRPCClient(t, store, NewHub(), tenants)
+
+	_, err = client.ListTransactions(context.Background(), &trackerpb.ListTransactionsRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without a key, got %v", err)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "k2")
+	list, err := client.ListTransactions(ctx, &trackerpb.ListTransactionsRequest{})
+	if err != nil {
+		t.Fatalf("ListTransactions: %v", err)
+	}
+	if len(list.GetEvents()) != 1 || list.GetEvents()[0].GetEventId() != "2" {
+		t.Fatalf("expected only ops' event, got %+v", list.GetEvents())
+	}
+
+	wallet, err := client.GetWallet(ctx, &trackerpb.GetWalletRequest{Address: bobAddr})
+	if err != nil {
+		t.Fatalf("GetWallet: %v", err)
+	}
+	if len(wallet.GetEvents()) != 1 || wallet.GetEvents()[0].GetEventId() != "2" {
+		t.Fatalf("expected only ops' event for bob, got %+v", wallet.GetEvents())
+	}
+
+	stream, err := client.SubscribeEvents(context.Background(), &trackerpb.SubscribeEventsRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated subscription without a key, got %v", err)
+	}
+}
+
+func TestGRPCSubscribeEvents(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	client := newTestGRPCClient(t, NewEventStore(100, 10), hub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.SubscribeEvents(ctx, &trackerpb.SubscribeEventsRequest{
+		Filter: &trackerpb.EventFilter{Chain: "solana"},
+	})
+	if err != nil {
+		t.Fatalf("SubscribeEvents: %v", err)
+	}
+
+	// wait until the subscriber is registered with the hub
+	waitUntil := time.Now().Add(2 * time.Second)
+	for time.Now().Before(waitUntil) {
+		hub.mu.Lock()
+		n := len(hub.clients)
+		hub.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	hub.broadcast <- []byte(`{"event_id":"skip","chain":"ethereum","from":"x","to":"y","value":"1"}`)
+	hub.broadcast <- []byte(`{"event_id":"abc","chain":"solana","from":"X","to":"y","value":"1"}`)
+
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	if ev.GetEventId() != "abc" || ev.GetFrom() != "X" {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+}
+
+func TestFilterFromProtoClampsLimit(t *testing.T) {
+	if got := filterFromProto(&trackerpb.EventFilter{Limit: 1 << 30}).Limit; got != maxPageSize {
+		t.Fatalf("limit = %d, want %d", got, maxPageSize)
+	}
+	if got := filterFromProto(nil).Limit; got != defaultPageSize {
+		t.Fatalf("default limit = %d, want %d", got, defaultPageSize)
+	}
+}
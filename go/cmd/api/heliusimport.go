@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// heliusPageSize is the most transactions a Helius request returns.
+const heliusPageSize = 100
+
+// heliusTx is the part of an enhanced transaction of the Helius API the
+// importer reads: the transaction parsed into its native and token
+// transfers.
+type heliusTx struct {
+	Signature        string          `json:"signature"`
+	Slot             uint64          `json:"slot"`
+	Timestamp        int64           `json:"timestamp"`
+	Fee              uint64          `json:"fee"`
+	FeePayer         string          `json:"feePayer"`
+	TransactionError json.RawMessage `json:"transactionError"`
+	NativeTransfers  []struct {
+		FromUserAccount string      `json:"fromUserAccount"`
+		ToUserAccount   string      `json:"toUserAccount"`
+		Amount          json.Number `json:"amount"`
+	} `json:"nativeTransfers"`
+	TokenTransfers []struct {
+		FromUserAccount  string `json:"fromUserAccount"`
+		ToUserAccount    string `json:"toUserAccount"`
+		FromTokenAccount string `json:"fromTokenAccount"`
+		ToTokenAccount   string `json:"toTokenAccount"`
+		Mint             string `json:"mint"`
+		// TokenAmount is in whole tokens.
+		TokenAmount json.Number `json:"tokenAmount"`
+	} `json:"tokenTransfers"`
+	AccountData []struct {
+		TokenBalanceChanges []struct {
+			Mint           string `json:"mint"`
+			RawTokenAmount struct {
+				Decimals int `json:"decimals"`
+			} `json:"rawTokenAmount"`
+		} `json:"tokenBalanceChanges"`
+	} `json:"accountData"`
+}
+
+// heliusHistory returns the transfers of address's recent successful
+// transactions from the Helius API of pair, newest first, paging back
+// with before. At most bound.Limit transactions are fetched.
+func (e *ExplorerAPIs) heliusHistory(ctx context.Context, pair, address string, bound HistoryBound) ([]*Event, error) {
+	var events []*Event
+	before := ""
+	for scanned := 0; bound.Limit <= 0 || scanned < bound.Limit; {
+		limit := heliusPageSize
+		if bound.Limit > 0 && bound.Limit-scanned < limit {
+			limit = bound.Limit - scanned
+		}
+		params := url.Values{"limit": {strconv.Itoa(limit)}}
+		if before != "" {
+			params.Set("before", before)
+		}
+		result, err := e.get(ctx, pair, "/addresses/"+url.PathEscape(address)+"/transactions", params)
+		if err != nil {
+			return nil, err
+		}
+		var txs []heliusTx
+		if err := json.Unmarshal(result, &txs); err != nil {
+			return nil, fmt.Errorf("unexpected Helius response: %w", err)
+		}
+		// Helius may return a short page before the end of the history
+		if len(txs) == 0 {
+			return events, nil
+		}
+		for _, tx := range txs {
+			if time.Unix(tx.Timestamp, 0).Before(bound.Since) {
+				return events, nil
+			}
+			events = append(events, heliusEvents(tx, address)...)
+		}
+		scanned += len(txs)
+		before = txs[len(txs)-1].Signature
+	}
+	return events, nil
+}
+
+// heliusEvents maps an enhanced transaction onto the listener's events,
+// whatever its Helius type (TRANSFER, SWAP, NFT_SALE...): the token transfers from and to address
+// become spl_transfer events and its SOL transfers native_transfer events,
+// numbered like the listener's. A transaction address paid for without
+// moving funds itself becomes a solana_tx event. Failed transactions and
+// token mints and burns, which have no sender or recipient, are left out.
+func heliusEvents(tx heliusTx, address string) []*Event {
+	if len(tx.TransactionError) > 0 && string(tx.TransactionError) != "null" {
+		return nil
+	}
+	timestamp := time.Unix(tx.Timestamp, 0).UTC().Format(time.RFC3339)
+	slot, fee := tx.Slot, strconv.FormatUint(tx.Fee, 10)
+	event := func(id, from, to, value, eventType string) *Event {
+		return &Event{EventID: "sol:" + tx.Signature + id, Chain: "solana", TxHash: tx.Signature, Timestamp: timestamp,
+			From: from, To: to, Value: value, EventType: eventType, Slot: &slot, Fee: fee}
+	}
+	decimals := make(map[string]int)
+	for _, account := range tx.AccountData {
+		for _, change := range account.TokenBalanceChanges {
+			decimals[change.Mint] = change.RawTokenAmount.Decimals
+		}
+	}
+
+	var tokens, natives []*Event
+	for _, t := range tx.TokenTransfers {
+		if t.FromUserAccount != address && t.ToUserAccount != address && t.FromTokenAccount != address && t.ToTokenAccount != address {
+			continue
+		}
+		from, to := t.FromUserAccount, t.ToUserAccount
+		if from == "" {
+			from = t.FromTokenAccount
+		}
+		if to == "" {
+			to = t.ToTokenAccount
+		}
+		d, ok := decimals[t.Mint]
+		if from == "" || to == "" || !ok || d < 0 || d > 255 {
+			continue
+		}
+		amount, ok := heliusRawAmount(t.TokenAmount, d)
+		if !ok {
+			continue
+		}
+		ev := event(fmt.Sprintf(":%d", len(tokens)), from, to, amount, "spl_transfer")
+		ev.Token = &Token{Address: t.Mint, Decimals: uint8(d)}
+		tokens = append(tokens, ev)
+	}
+	for _, n := range tx.NativeTransfers {
+		lamports, err := strconv.ParseUint(n.Amount.String(), 10, 64)
+		if err != nil || lamports == 0 || n.FromUserAccount != address && n.ToUserAccount != address {
+			continue
+		}
+		natives = append(natives, event(fmt.Sprintf(":native%d", len(natives)), n.FromUserAccount, n.ToUserAccount,
+			strconv.FormatUint(lamports, 10), "native_transfer"))
+	}
+	events := append(tokens, natives...)
+	if len(events) == 0 && tx.FeePayer == address {
+		events = append(events, event("", address, "", "", "solana_tx"))
+	}
+	return events
+}
+
+// heliusRawAmount converts an amount in whole tokens to the token's
+// smallest unit, rounding away the binary noise of Helius's floats.
+func heliusRawAmount(amount json.Number, decimals int) (string, bool) {
+	r, ok := new(big.Rat).SetString(amount.String())
+	if !ok || r.Sign() <= 0 {
+		return "", false
+	}
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	return r.FloatString(0), true
+}
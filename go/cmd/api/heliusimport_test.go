@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHeliusHistory(t *testing.T) {
+	const (
+		wallet = "Wallet1111111111111111111111111111111111111"
+		peer   = "Peer111111111111111111111111111111111111111"
+		usdc   = "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v"
+	)
+	at := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	var paths []string
+	// Pages shrink to what is left of the 10-transaction limit
+	limits := []string{"10", "8", "7"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path+"?"+r.URL.Query().Get("before"))
+		if len(paths) > len(limits) || r.URL.Query().Get("api-key") != "heliuskey" || r.URL.Query().Get("limit") != limits[len(paths)-1] {
+			t.Errorf("query = %s", r.URL.RawQuery)
+		}
+		switch r.URL.Query().Get("before") {
+		case "":
+			_, _ = w.Write([]byte(`[
+				{"signature": "sig1", "slot": 300, "timestamp": ` + at + `, "type": "TRANSFER", "fee": 5000, "feePayer": "` + wallet + `",
+					"transactionError": null,
+					"nativeTransfers": [{"fromUserAccount": "` + wallet + `", "toUserAccount": "` + peer + `", "amount": 1000},
+						{"fromUserAccount": "` + peer + `", "toUserAccount": "Other11111111111111111111111111111111111111", "amount": 7}],
+					"tokenTransfers": [
+						{"fromUserAccount": "", "toUserAccount": "` + wallet + `", "toTokenAccount": "Ata1", "mint": "` + usdc + `", "tokenAmount": 3},
+						{"fromUserAccount": "` + wallet + `", "toUserAccount": "` + peer + `", "fromTokenAccount": "Ata1", "toTokenAccount": "Ata2",
+							"mint": "` + usdc + `", "tokenAmount": 1.1}],
+					"accountData": [{"tokenBalanceChanges": [{"mint": "` + usdc + `", "rawTokenAmount": {"tokenAmount": "-1100000", "decimals": 6}}]}]},
+				{"signature": "sig2", "slot": 299, "timestamp": ` + at + `, "type": "SWAP", "fee": 5000, "feePayer": "` + wallet + `",
+					"transactionError": {"InstructionError": [0, "Custom"]},
+					"nativeTransfers": [{"fromUserAccount": "` + wallet + `", "toUserAccount": "` + peer + `", "amount": 50}]}]`))
+		case "sig2":
+			_, _ = w.Write([]byte(`[{"signature": "sig3", "slot": 200, "timestamp": ` + at + `, "type": "UNKNOWN", "fee": 5000,
+				"feePayer": "` + wallet + `", "transactionError": null, "nativeTransfers": [], "tokenTransfers": []}]`))
+		default:
+			_, _ = w.Write([]byte(`[]`))
+		}
+	}))
+	defer srv.Close()
+
+	e, err := ParseExplorerAPIs("solana:devnet="+srv.URL+"/v0", "solana:devnet=heliuskey", 100)
+	if err != nil || !e.Has("solana", "devnet") {
+		t.Fatalf("explorers = %v, %v", e, err)
+	}
+	events, err := e.History(context.Background(), "solana", "devnet", wallet, HistoryBound{Limit: 10}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 3 || paths[0] != "/v0/addresses/"+wallet+"/transactions?" || paths[1] != "/v0/addresses/"+wallet+"/transactions?sig2" {
+		t.Fatalf("requests = %v", paths)
+	}
+	if len(events) != 3 {
+		t.Fatalf("events = %d, want 3", len(events))
+	}
+	spl, native, tx := events[0], events[1], events[2]
+	if spl.EventID != "sol:sig1:0" || spl.EventType != "spl_transfer" || spl.From != wallet || spl.To != peer || spl.Value != "1100000" ||
+		spl.Token.Address != usdc || spl.Token.Decimals != 6 || *spl.Slot != 300 || spl.Fee != "5000" {
+		t.Fatalf("spl = %+v, token %+v", spl, spl.Token)
+	}
+	if native.EventID != "sol:sig1:native0" || native.EventType != "native_transfer" || native.Value != "1000" || native.To != peer {
+		t.Fatalf("native = %+v", native)
+	}
+	if tx.EventID != "sol:sig3" || tx.EventType != "solana_tx" || tx.From != wallet || tx.Value != "" {
+		t.Fatalf("tx = %+v", tx)
+	}
+
+	// Bare keys are Etherscan keys, never sent to Helius
+	if e, _ := ParseExplorerAPIs("", "etherscankey", 5); e.Has("solana", "mainnet") {
+		t.Fatal("bare key used for Helius")
+	}
+}
+
+func TestHeliusRawAmount(t *testing.T) {
+	for _, tc := range []struct {
+		amount   string
+		decimals int
+		want     string
+	}{
+		{"1.1", 6, "1100000"},
+		{"0.30000000000000004", 6, "300000"},
+		{"1e-9", 9, "1"},
+		{"42", 0, "42"},
+	} {
+		if got, ok := heliusRawAmount(json.Number(tc.amount), tc.decimals); !ok || got != tc.want {
+			t.Errorf("heliusRawAmount(%s, %d) = %s, %v", tc.amount, tc.decimals, got, ok)
+		}
+	}
+	if _, ok := heliusRawAmount("-1", 6); ok {
+		t.Error("negative amount accepted")
+	}
+}
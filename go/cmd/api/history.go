@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Default bounds of an on-demand history import, overridable with
+	// HISTORY_IMPORT_BLOCKS, HISTORY_IMPORT_WINDOW and HISTORY_IMPORT_LIMIT.
+	defaultHistoryBlocks = 10000
+	defaultHistoryWindow = 30 * 24 * time.Hour
+	defaultHistoryLimit  = 200
+	// defaultHistoryWait is how long a wallet history request waits for
+	// the import it started, overridable with HISTORY_IMPORT_WAIT.
+	defaultHistoryWait = 3 * time.Second
+	// historyWorkers is how many imports run at once.
+	historyWorkers = 2
+	// historyTimeout bounds one import of one network.
+	historyTimeout = 2 * time.Minute
+	// historyRetryAfter is how long a failed import is remembered before
+	// the wallet can be imported again.
+	historyRetryAfter = 10 * time.Minute
+	// historyMaxTracked bounds the finished imports remembered, which keep
+	// a wallet without history from being imported on every request.
+	historyMaxTracked = 10000
+)
+
+// States of a history import.
+const (
+	HistoryPending = "pending"
+	HistoryRunning = "running"
+	HistoryDone    = "done"
+	HistoryFailed  = "failed"
+)
+
+// HistoryBound limits a history import: at most Limit transfers, from the
+// last Blocks blocks (EVM) and no older than Since.
+type HistoryBound struct {
+	Blocks uint64
+	Since  time.Time
+	Limit  int
+}
+
+// HistoryConfig bounds imports to the last Blocks blocks (EVM), Window
+// back from now and Limit transfers (EVM) or transactions (Solana). Wallet
+// history requests wait up to Wait for the imports they start.
+type HistoryConfig struct {
+	Blocks uint64
+	Window time.Duration
+	Limit  int
+	Wait   time.Duration
+}
+
+// HistoryConfigFromEnv reads HISTORY_IMPORT_BLOCKS, HISTORY_IMPORT_WINDOW,
+// HISTORY_IMPORT_LIMIT and HISTORY_IMPORT_WAIT.
+func HistoryConfigFromEnv() HistoryConfig {
+	return HistoryConfig{
+		Blocks: uint64(envInt("HISTORY_IMPORT_BLOCKS", defaultHistoryBlocks)),
+		Window: envDuration("HISTORY_IMPORT_WINDOW", defaultHistoryWindow),
+		Limit:  envInt("HISTORY_IMPORT_LIMIT", defaultHistoryLimit),
+		Wait:   envDuration("HISTORY_IMPORT_WAIT", defaultHistoryWait),
+	}
+}
+
+// HistoryImport is the state of the import of one wallet on one network.
+type HistoryImport struct {
+	Chain    string `json:"chain"`
+	Network  string `json:"network"`
+	Address  string `json:"address"`
+	Status   string `json:"status"`
+	Imported int    `json:"imported"`
+	// Source is where the history comes from: rpc or explorer.
+	Source     string `json:"source"`
+	Error      string `json:"error,omitempty"`
+	StartedAt  string `json:"started_at"`
+	FinishedAt string `json:"finished_at,omitempty"`
+}
+
+// historyJob is an import and the channel closed when it finishes.
+type historyJob struct {
+	state    HistoryImport
+	done     chan struct{}
+	finished time.Time
+}
+
+// historyNetworks lists the chain/network pairs imports can run on;
+// *ChainRegistry is the production implementation.
+type historyNetworks interface {
+	List() []ChainConfig
+}
+
+// HistoryImporter fills in the recent history of wallets the tracker has
+// never seen, from the network's explorer API when one is attached and
+// otherwise from the chain's RPC providers through ChainAdapter.History,
+// so a wallet queried or added to a watchlist after the listener started
+// does not come up empty. Imported events are stored like ingested ones,
+// tagged and deduplicated, but not published on the live stream.
+type HistoryImporter struct {
+	store     *EventStore
+	networks  historyNetworks
+	endpoints rpcEndpoints
+	client    *http.Client
+	tenants   *Tenants
+	dedup     *Deduplicator
+	events    *SystemEvents
+	explorers *ExplorerAPIs
+	cfg       HistoryConfig
+	slots     chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*historyJob // chain:network:addressKey -> job
+}
+
+// NewHistoryImporter returns an importer storing into store, bounded by
+// cfg; tenants, dedup and events may be nil.
+func NewHistoryImporter(store *EventStore, networks historyNetworks, endpoints rpcEndpoints, tenants *Tenants, dedup *Deduplicator, events *SystemEvents, cfg HistoryConfig) *HistoryImporter {
+	return &HistoryImporter{
+		store:     store,
+		networks:  networks,
+		endpoints: endpoints,
+		client:    &http.Client{Timeout: 15 * time.Second},
+		tenants:   tenants,
+		dedup:     dedup,
+		events:    events,
+		cfg:       cfg,
+		slots:     make(chan struct{}, historyWorkers),
+		jobs:      make(map[string]*historyJob),
+	}
+}
+
+// AttachExplorers makes imports on the networks explorers has an API for
+// go through it.
+func (h *HistoryImporter) AttachExplorers(explorers *ExplorerAPIs) {
+	h.explorers = explorers
+}
+
+// Seen reports whether the tracker holds any event of address on chain
+// and network; empty ones match every chain or network.
+func (h *HistoryImporter) Seen(address, chain, network string) bool {
+	return len(h.store.GetByWallet(address, EventFilter{Chain: chain, Network: network, Limit: 1})) > 0
+}
+
+// ImportUnseen starts importing address on every enabled network with an
+// explorer API or RPC provider where it is a valid address, restricted to chain and network
+// when set, unless the tracker already holds events of it. It returns the
+// imports of address, started now or earlier.
+func (h *HistoryImporter) ImportUnseen(address, chain, network string) []HistoryImport {
+	if h == nil || h.Seen(address, chain, network) {
+		return nil
+	}
+	var out []HistoryImport
+	for _, c := range h.networks.List() {
+		if !c.Enabled || chain != "" && c.Chain != chain || network != "" && c.Network != network {
+			continue
+		}
+		if _, ok := h.endpoints.RPCURL(c.Chain, c.Network); !ok && !h.explorers.Has(c.Chain, c.Network) {
+			continue
+		}
+		canonical, err := chainAdapter(c.Chain).Validate(address)
+		if err != nil {
+			continue
+		}
+		out = append(out, h.start(c.Chain, c.Network, canonical))
+	}
+	return out
+}
+
+// start returns the import of address on chain and network, starting it
+// unless one is running, done, or failed recently.
+func (h *HistoryImporter) start(chain, network, address string) HistoryImport {
+	key := chain + ":" + network + ":" + addressKey(address)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if job, ok := h.jobs[key]; ok && (job.state.Status != HistoryFailed || time.Since(job.finished) < historyRetryAfter) {
+		return job.state
+	}
+	if len(h.jobs) >= historyMaxTracked {
+		h.pruneLocked()
+	}
+	source := "rpc"
+	if h.explorers.Has(chain, network) {
+		source = "explorer"
+	}
+	job := &historyJob{
+		state: HistoryImport{Chain: chain, Network: network, Address: address, Status: HistoryPending, Source: source,
+			StartedAt: time.Now().UTC().Format(time.RFC3339)},
+		done: make(chan struct{}),
+	}
+	h.jobs[key] = job
+	go h.run(job)
+	return job.state
+}
+
+// pruneLocked forgets the oldest half of the finished imports. Callers
+// hold mu.
+func (h *HistoryImporter) pruneLocked() {
+	var finished []string
+	for key, job := range h.jobs {
+		if !job.finished.IsZero() {
+			finished = append(finished, key)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return h.jobs[finished[i]].finished.Before(h.jobs[finished[j]].finished) })
+	for _, key := range finished[:len(finished)/2+len(finished)%2] {
+		delete(h.jobs, key)
+	}
+}
+
+// ImportWatched imports the history of the watchlists' wallets the
+// tracker has never seen: the tenants' wallets on every network and the
+// sequence wallets on theirs.
+func (h *HistoryImporter) ImportWatched(tenants *Tenants, sequences []SequenceWallet) {
+	if h == nil {
+		return
+	}
+	for _, address := range tenants.Wallets() {
+		h.ImportUnseen(address, "", "")
+	}
+	for _, w := range sequences {
+		h.ImportUnseen(w.Address, w.Chain, w.Network)
+	}
+}
+
+// Wait waits until imports finish, ctx is done or timeout passes, and
+// returns their current state.
+func (h *HistoryImporter) Wait(ctx context.Context, imports []HistoryImport, timeout time.Duration) []HistoryImport {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	out := make([]HistoryImport, 0, len(imports))
+	for _, imp := range imports {
+		h.mu.Lock()
+		job := h.jobs[imp.Chain+":"+imp.Network+":"+addressKey(imp.Address)]
+		h.mu.Unlock()
+		if job == nil {
+			out = append(out, imp)
+			continue
+		}
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+		}
+		h.mu.Lock()
+		out = append(out, job.state)
+		h.mu.Unlock()
+	}
+	return out
+}
+
+// historyFinished reports whether none of imports is still pending or
+// running.
+func historyFinished(imports []HistoryImport) bool {
+	for _, imp := range imports {
+		if imp.Status == HistoryPending || imp.Status == HistoryRunning {
+			return false
+		}
+	}
+	return true
+}
+
+// Status returns the imports of address, or every import when address is
+// empty.
+func (h *HistoryImporter) Status(address string) []HistoryImport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]HistoryImport, 0)
+	for _, job := range h.jobs {
+		if address == "" || addressKey(job.state.Address) == addressKey(address) {
+			out = append(out, job.state)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt > out[j].StartedAt })
+	return out
+}
+
+func (h *HistoryImporter) run(job *historyJob) {
+	h.slots <- struct{}{}
+	defer func() { <-h.slots }()
+	h.mu.Lock()
+	job.state.Status = HistoryRunning
+	imp := job.state
+	h.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), historyTimeout)
+	defer cancel()
+	imported, err := h.importHistory(ctx, imp.Chain, imp.Network, imp.Address)
+	fields := log.Fields{"chain": imp.Chain, "network": imp.Network, "address": imp.Address, "source": imp.Source, "imported": imported}
+
+	h.mu.Lock()
+	job.state.Imported, job.state.Status = imported, HistoryDone
+	if err != nil {
+		job.state.Status, job.state.Error = HistoryFailed, err.Error()
+	}
+	job.finished = time.Now()
+	job.state.FinishedAt = job.finished.UTC().Format(time.RFC3339)
+	h.mu.Unlock()
+	close(job.done)
+
+	if err != nil {
+		log.WithError(err).WithFields(fields).Warn("history import failed")
+		return
+	}
+	log.WithFields(fields).Info("history imported")
+	if h.events != nil && imported > 0 {
+		if err := h.events.Emit(SystemEvent{
+			Kind:    SystemBackfillCompleted,
+			Chain:   imp.Chain,
+			Network: imp.Network,
+			Message: fmt.Sprintf("imported %d past transfers of %s", imported, imp.Address),
+			Data:    map[string]interface{}{"address": imp.Address, "imported": imported, "reason": "history_import"},
+		}); err != nil {
+			log.WithError(err).Warn("could not emit history import")
+		}
+	}
+}
+
+// importHistory fetches and stores the history of address, returning how
+// many events were stored. Events get source import:<api>, where api is
+// rpc or the explorer API's kind.
+func (h *HistoryImporter) importHistory(ctx context.Context, chain, network, address string) (int, error) {
+	var call RPCCaller
+	if url, ok := h.endpoints.RPCURL(chain, network); ok {
+		call = func(ctx context.Context, method string, params ...interface{}) (json.RawMessage, error) {
+			return rpcCall(ctx, h.client, url, method, params...)
+		}
+	}
+	adapter := chainAdapter(chain)
+	bound := HistoryBound{Blocks: h.cfg.Blocks, Since: time.Now().Add(-h.cfg.Window), Limit: h.cfg.Limit}
+	var events []*Event
+	var err error
+	source := "import:rpc"
+	switch {
+	case h.explorers.Has(chain, network):
+		source = "import:" + explorerKind(chain)
+		events, err = h.explorers.History(ctx, chain, network, address, bound, call)
+	case call != nil:
+		events, err = adapter.History(ctx, call, address, bound)
+	default:
+		err = errNoRPC
+	}
+	if err != nil {
+		return 0, err
+	}
+	imported := 0
+	// Oldest first, so the newest end up first in the in-memory lists
+	for i := len(events) - 1; i >= 0; i-- {
+		ev := events[i]
+		ev.Chain, ev.Network, ev.Source = chain, network, source
+		if err := adapter.Normalize(ev); err != nil {
+			log.WithError(err).WithField("event_id", ev.EventID).Warn("dropping imported event with invalid address")
+			continue
+		}
+		stored, err := h.store.ImportEvent(ctx, ev, h.dedup, h.tenants)
+		if err != nil {
+			return imported, err
+		}
+		if stored {
+			imported++
+		}
+	}
+	return imported, nil
+}
+
+// ImportEvent stores an event fetched from outside the event source, as
+// ingestEvents would, with provenance imported, and reports whether it
+// was new. Copies of stored transfers are merged into them instead.
+func (s *EventStore) ImportEvent(ctx context.Context, ev *Event, dedup *Deduplicator, tenants *Tenants) (bool, error) {
+	if ev.Status == "" {
+		ev.Status = StatusConfirmed
+	}
+	ev.Provenance = ProvenanceImported
+	canonicalizeEvent(ev)
+	// Events still queued for a batched write are only in the cache
+	if _, ok, _ := s.cache.ByID(ctx, ev.EventID); ok {
+		return false, nil
+	}
+	if _, ok := s.GetByID(ev.EventID); ok {
+		return false, nil
+	}
+	if dup, err := dedup.Merge(ctx, ev); err != nil || dup {
+		return false, err
+	}
+	tenants.Tag(ev)
+	if err := s.Persist(ctx, ev); err != nil {
+		return false, err
+	}
+	s.Add(ev)
+	s.responses.Invalidate(ctx, ev)
+	return true, nil
+}
+
+// getHistoryImports handles GET /wallet/{address}/import: the state of
+// the wallet's history imports.
+func getHistoryImports(h *HistoryImporter, w http.ResponseWriter, r *http.Request) {
+	address, err := pathAddress(r, "")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	imports := []HistoryImport{}
+	if h != nil {
+		imports = h.Status(address)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(imports)
+}
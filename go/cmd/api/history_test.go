@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const usdcAddr = "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+
+// historyRPC answers the calls of an EVM history import: a head of block
+// 0x10 and one USDC transfer from aliceAddr to bobAddr in block 0xf. It
+// blocks until release is closed, when set.
+func historyRPC(t *testing.T, release chan struct{}) *httptest.Server {
+	padded := func(address string) string { return "0x000000000000000000000000" + address[2:] }
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if release != nil {
+			<-release
+		}
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode rpc request: %v", err)
+		}
+		result := "null"
+		switch req.Method {
+		case "eth_blockNumber":
+			result = `"0x10"`
+		case "eth_getLogs":
+			var filter struct {
+				Topics []*string `json:"topics"`
+			}
+			_ = json.Unmarshal(req.Params[0], &filter)
+			result = `[]`
+			// Only the sent filter, whose second topic is alice, matches
+			if len(filter.Topics) == 2 && *filter.Topics[1] == padded(aliceAddr) {
+				result = `[{"address": "` + strings.ToLower(usdcAddr) + `", "topics": ["` + erc20TransferTopic + `", "` + padded(aliceAddr) + `", "` + padded(bobAddr) + `"],
+					"data": "0x00000000000000000000000000000000000000000000000000000000000f4240", "blockNumber": "0xf",
+					"transactionHash": "0xABC", "logIndex": "0x2", "removed": false}]`
+			}
+		case "eth_getBlockByNumber":
+			result = `{"timestamp": "0x` + strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 16) + `"}`
+		case "eth_call":
+			var call struct {
+				Data string `json:"data"`
+			}
+			_ = json.Unmarshal(req.Params[0], &call)
+			if call.Data == "0x313ce567" {
+				result = `"0x` + strings.Repeat("0", 63) + `6"`
+			} else {
+				result = `"0x` + strings.Repeat("0", 62) + "20" + strings.Repeat("0", 63) + "4" + "55534443" + strings.Repeat("0", 56) + `"`
+			}
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": ` + result + `}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestHistoryImporter(store *EventStore, url string, wait time.Duration) *HistoryImporter {
+	chains := NewChainRegistry(nil, map[string][]string{"ethereum:mainnet": {url}})
+	return NewHistoryImporter(store, chains, NewRPCManager(chains, 0), nil, nil, nil,
+		HistoryConfig{Blocks: 100, Window: 24 * time.Hour, Limit: 10, Wait: wait})
+}
+
+func TestHistoryImporterImportsUnseenWallets(t *testing.T) {
+	store := NewEventStore(100, 50)
+	h := newTestHistoryImporter(store, historyRPC(t, nil).URL, time.Second)
+
+	imports := h.Wait(context.Background(), h.ImportUnseen(aliceAddr, "", ""), time.Second)
+	if len(imports) != 1 || imports[0].Status != HistoryDone || imports[0].Imported != 1 {
+		t.Fatalf("imports = %+v", imports)
+	}
+	events := store.GetByWallet(aliceAddr, EventFilter{})
+	if len(events) != 1 {
+		t.Fatalf("stored %d events, want 1", len(events))
+	}
+	ev := events[0]
+	if ev.EventID != "eth:0xabc:log2" || ev.Network != "mainnet" || ev.Value != "1000000" || addressKey(ev.To) != bobAddr ||
+		ev.Token == nil || ev.Token.Symbol != "USDC" || ev.Token.Decimals != 6 || ev.Token.Address != usdcAddr {
+		t.Fatalf("event = %+v, token %+v", ev, ev.Token)
+	}
+
+	// Seen wallets, and those already imported, are not imported again
+	if imports := h.ImportUnseen(aliceAddr, "", ""); imports != nil {
+		t.Fatalf("seen wallet imported again: %+v", imports)
+	}
+	if imports := h.ImportUnseen(aliceAddr, "solana", ""); len(imports) != 0 {
+		t.Fatalf("imported on a network without an RPC provider: %+v", imports)
+	}
+	if status := h.Status(aliceAddr); len(status) != 1 || status[0].Status != HistoryDone {
+		t.Fatalf("status = %+v", status)
+	}
+}
+
+func TestWalletTransactionsImportsHistory(t *testing.T) {
+	release := make(chan struct{})
+	store := NewEventStore(100, 50)
+	h := newTestHistoryImporter(store, historyRPC(t, release).URL, 10*time.Millisecond)
+	store.AttachHistory(h)
+	router := chi.NewRouter()
+	router.Get("/wallet/{address}/transactions", func(w http.ResponseWriter, r *http.Request) {
+		getWalletTransactions(store, w, r)
+	})
+	router.Get("/wallet/{address}/import", func(w http.ResponseWriter, r *http.Request) {
+		getHistoryImports(h, w, r)
+	})
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	// The import outlasts the wait: come back later
+	rec := get("/wallet/" + aliceAddr + "/transactions")
+	var imports []HistoryImport
+	if err := json.Unmarshal(rec.Body.Bytes(), &imports); err != nil || rec.Code != http.StatusAccepted ||
+		rec.Header().Get("Retry-After") == "" || len(imports) != 1 || imports[0].Chain != "ethereum" {
+		t.Fatalf("status %d, imports %s", rec.Code, rec.Body)
+	}
+	close(release)
+	h.Wait(context.Background(), imports, time.Second)
+	rec = get("/wallet/" + aliceAddr + "/import")
+	if err := json.Unmarshal(rec.Body.Bytes(), &imports); err != nil || len(imports) != 1 || imports[0].Status != HistoryDone {
+		t.Fatalf("imports = %s", rec.Body)
+	}
+	if rec = get("/wallet/" + aliceAddr + "/transactions"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "eth:0xabc:log2") {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+
+	// A wallet without history is still not found once imported
+	h.Wait(context.Background(), h.ImportUnseen(bobAddr[:41]+"1", "", ""), time.Second)
+	if rec = get("/wallet/" + bobAddr[:41] + "1/transactions"); rec.Code != http.StatusNotFound {
+		t.Fatalf("status %d, want 404", rec.Code)
+	}
+}
+
+func TestSolanaTransfersNumbering(t *testing.T) {
+	const (
+		sender   = "Sender1111111111111111111111111111111111111"
+		receiver = "Receiver11111111111111111111111111111111111"
+		srcATA   = "SrcATA11111111111111111111111111111111111111"
+		dstATA   = "DstATA11111111111111111111111111111111111111"
+	)
+	raw := json.RawMessage(`{
+		"slot": 77, "blockTime": 1700000000,
+		"meta": {
+			"fee": 5000,
+			"innerInstructions": [{"index": 1, "instructions": [
+				{"programId": "TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5DA", "parsed": {"type": "transfer",
+					"info": {"source": "` + srcATA + `", "destination": "` + dstATA + `", "authority": "` + sender + `", "amount": "250"}}}
+			]}],
+			"postTokenBalances": [
+				{"accountIndex": 2, "mint": "` + wrappedSOL + `", "owner": "` + sender + `", "uiTokenAmount": {"decimals": 9}},
+				{"accountIndex": 3, "mint": "` + wrappedSOL + `", "owner": "` + receiver + `", "uiTokenAmount": {"decimals": 9}}
+			]
+		},
+		"transaction": {"message": {
+			"accountKeys": [{"pubkey": "` + sender + `"}, {"pubkey": "` + receiver + `"}, {"pubkey": "` + srcATA + `"}, {"pubkey": "` + dstATA + `"}],
+			"instructions": [
+				{"programId": "11111111111111111111111111111111", "parsed": {"type": "transfer",
+					"info": {"source": "` + sender + `", "destination": "` + receiver + `", "lamports": 1000}}},
+				{"programId": "ComputeBudget111111111111111111111111111111", "parsed": {"type": "other", "info": {}}}
+			]
+		}}
+	}`)
+	events := solanaTransfers(raw, "sig", receiver)
+	if len(events) != 2 {
+		t.Fatalf("events = %d, want 2", len(events))
+	}
+	spl, native := events[0], events[1]
+	if spl.EventID != "sol:sig:0" || *spl.LogIndex != 2 || spl.From != sender || spl.To != receiver || spl.Value != "250" ||
+		spl.Token.Address != wrappedSOL || spl.Token.Decimals != 9 {
+		t.Fatalf("spl = %+v, token %+v", spl, spl.Token)
+	}
+	if native.EventID != "sol:sig:native0" || *native.LogIndex != 0 || native.Value != "1000" || *native.Slot != 77 || native.Fee != "5000" ||
+		native.Timestamp != "2023-11-14T22:13:20Z" {
+		t.Fatalf("native = %+v", native)
+	}
+	if events := solanaTransfers(raw, "sig", "Someone1111111111111111111111111111111111111"); len(events) != 0 {
+		t.Fatalf("uninvolved address got %d events", len(events))
+	}
+}
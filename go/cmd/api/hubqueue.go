@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// defaultHubQueueSize is how many event frames may wait for the hub before
+// the overflow policy applies.
+const defaultHubQueueSize = 4096
+
+// Overflow policies of the hub queue.
+const (
+	// OverflowDropOldest drops the longest waiting frame to make room.
+	OverflowDropOldest = "drop_oldest"
+	// OverflowDropNewest drops the frame being published.
+	OverflowDropNewest = "drop_newest"
+)
+
+// ParseOverflowPolicy validates a HUB_QUEUE_OVERFLOW value; empty means
+// drop_oldest.
+func ParseOverflowPolicy(s string) (string, error) {
+	switch p := strings.TrimSpace(strings.ToLower(s)); p {
+	case "":
+		return OverflowDropOldest, nil
+	case OverflowDropOldest, OverflowDropNewest:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy %q (want %s or %s)", s, OverflowDropOldest, OverflowDropNewest)
+	}
+}
+
+// hubQueue holds the event frames published for a hub until its Run loop
+// takes them, so publishers never wait for a slow or stuck hub. A frame
+// for an event that is still waiting replaces it in place, and a full
+// queue drops a frame according to its overflow policy.
+type hubQueue struct {
+	mu     sync.Mutex
+	frames []Frame
+	keys   []string
+	size   int
+	policy string
+	// ready holds a token while frames are waiting
+	ready chan struct{}
+
+	merged, dropped uint64
+}
+
+func newHubQueue(size int, policy string) *hubQueue {
+	return &hubQueue{size: size, policy: policy, ready: make(chan struct{}, 1)}
+}
+
+// push queues frame under key, an event_id or "" for frames that are
+// never merged.
+func (q *hubQueue) push(key string, frame Frame) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if key != "" {
+		for i := range q.keys {
+			if q.keys[i] == key {
+				q.frames[i] = frame
+				q.merged++
+				return
+			}
+		}
+	}
+	if len(q.frames) >= q.size {
+		q.dropped++
+		if q.policy == OverflowDropNewest {
+			return
+		}
+		q.frames, q.keys = q.frames[1:], q.keys[1:]
+	}
+	q.frames = append(q.frames, frame)
+	q.keys = append(q.keys, key)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take removes and returns every waiting frame, oldest first.
+func (q *hubQueue) take() []Frame {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	frames := q.frames
+	q.frames, q.keys = nil, nil
+	return frames
+}
+
+// SetQueue resizes the queue of event frames and sets its overflow policy,
+// discarding waiting frames. It is meant to be called before Run.
+func (h *Hub) SetQueue(size int, policy string) {
+	h.queue = newHubQueue(size, policy)
+}
+
+// WriteMetrics writes the hub queue's depth and counters in the Prometheus
+// text format.
+func (h *Hub) WriteMetrics(out io.Writer) {
+	q := h.queue
+	q.mu.Lock()
+	depth, merged, dropped := len(q.frames), q.merged, q.dropped
+	q.mu.Unlock()
+	metrics := []struct {
+		name, kind, help string
+		value            interface{}
+	}{
+		{"tracker_hub_queue_depth", "gauge", "Event frames waiting for the live stream hub.", depth},
+		{"tracker_hub_queue_capacity", "gauge", "Maximum event frames waiting before frames are dropped.", q.size},
+		{"tracker_hub_queue_merged_total", "counter", "Event frames replaced by a newer frame for the same event before delivery.", merged},
+		{"tracker_hub_queue_dropped_total", "counter", "Event frames dropped because the hub queue was full.", dropped},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+}
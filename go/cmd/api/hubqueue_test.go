@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublishEventNeverBlocks(t *testing.T) {
+	hub := NewHub()
+	hub.SetQueue(2, OverflowDropOldest)
+	// Nothing runs the hub yet, as if it were stuck
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		hub.PublishEvent(&Event{EventID: "a"}, []byte(`"a1"`))
+		hub.PublishEvent(&Event{EventID: "b"}, []byte(`"b"`))
+		// A newer frame for a waiting event replaces it in place
+		hub.PublishEvent(&Event{EventID: "a"}, []byte(`"a2"`))
+		// Full: the oldest frame makes room
+		hub.PublishEvent(&Event{EventID: "c"}, []byte(`"c"`))
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("PublishEvent blocked on a hub that is not running")
+	}
+
+	client := make(chan Frame, 4)
+	hub.subscribe(client, "", replayCursor{})
+	go hub.Run()
+	var got []string
+	for len(got) < 2 {
+		select {
+		case f := <-client:
+			got = append(got, string(f.Data))
+		case <-time.After(time.Second):
+			t.Fatalf("got %v, want the queued frames", got)
+		}
+	}
+	if strings.Join(got, ",") != `"b","c"` {
+		t.Fatalf("delivered %v, want b then c", got)
+	}
+
+	var out bytes.Buffer
+	hub.WriteMetrics(&out)
+	for _, want := range []string{"tracker_hub_queue_capacity 2", "tracker_hub_queue_merged_total 1", "tracker_hub_queue_dropped_total 1"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestHubQueueDropNewest(t *testing.T) {
+	q := newHubQueue(1, OverflowDropNewest)
+	q.push("a", Frame{Data: []byte("a")})
+	q.push("b", Frame{Data: []byte("b")})
+	// Frames without an event are never merged
+	q.push("", Frame{Data: []byte("status")})
+	if frames := q.take(); len(frames) != 1 || string(frames[0].Data) != "a" || q.dropped != 2 {
+		t.Fatalf("frames = %v, dropped = %d", frames, q.dropped)
+	}
+	if _, err := ParseOverflowPolicy("block"); err == nil {
+		t.Fatal("expected an unknown policy to be rejected")
+	}
+	if p, _ := ParseOverflowPolicy(" Drop_Newest "); p != OverflowDropNewest {
+		t.Fatalf("policy = %q", p)
+	}
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+// Evidence an address was linked to an identity on.
+const (
+	// EvidenceManual links were asserted by the tenant.
+	EvidenceManual = "manual"
+	// EvidenceBridge links come from a completed bridge transfer: its
+	// sender on the source chain and the recipients of its destination
+	// legs.
+	EvidenceBridge = "bridge"
+)
+
+// maxIdentityName caps the length of an identity's name.
+const maxIdentityName = 200
+
+// identitiesSchema creates the tables identities and their linked
+// addresses are persisted in. An address, keyed on its addressKey, belongs
+// to at most one identity of a tenant.
+const identitiesSchema = `
+	CREATE TABLE IF NOT EXISTS identities (
+		tenant TEXT NOT NULL DEFAULT '',
+		id TEXT NOT NULL,
+		name TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (tenant, id)
+	);
+	CREATE TABLE IF NOT EXISTS identity_addresses (
+		tenant TEXT NOT NULL DEFAULT '',
+		address TEXT NOT NULL,
+		display_address TEXT NOT NULL,
+		chain TEXT NOT NULL,
+		identity_id TEXT NOT NULL,
+		evidence TEXT NOT NULL,
+		event_id TEXT NOT NULL DEFAULT '',
+		linked_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (tenant, address)
+	);
+`
+
+var (
+	errIdentityNotFound = errors.New("identity not found")
+	// errAddressLinked is returned for addresses already linked to
+	// another identity of the tenant.
+	errAddressLinked = errors.New("address is linked to another identity")
+)
+
+// Identity is one entity's addresses across chains, linked by a tenant so
+// their activity can be viewed together.
+type Identity struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name,omitempty"`
+	CreatedAt string          `json:"created_at"`
+	Addresses []LinkedAddress `json:"addresses"`
+}
+
+// LinkedAddress is an address of an identity and the evidence it was
+// linked on.
+type LinkedAddress struct {
+	Address  string `json:"address"`
+	Chain    string `json:"chain"`
+	Evidence string `json:"evidence"`
+	// EventID is the bridge transfer EvidenceBridge links come from.
+	EventID  string `json:"event_id,omitempty"`
+	LinkedAt string `json:"linked_at"`
+}
+
+// NewIdentity is the body of POST /identities.
+type NewIdentity struct {
+	Name string `json:"name,omitempty"`
+}
+
+// IdentityLink is the body of POST /identities/{id}/links: an address to
+// link manually, or the event ID of a completed bridge transfer whose
+// sender and recipients are linked.
+type IdentityLink struct {
+	Address string `json:"address,omitempty"`
+	Chain   string `json:"chain,omitempty"`
+	EventID string `json:"event_id,omitempty"`
+}
+
+// identityKey keys identities on the tenant and their ID, and linked
+// addresses on the tenant and addressKey.
+type identityKey struct{ tenant, id string }
+
+// Identities keeps the identities of each tenant, persisted to the
+// identities and identity_addresses tables when a database is attached.
+type Identities struct {
+	mu         sync.RWMutex
+	identities map[identityKey]*Identity
+	// byAddress maps linked addresses to their identity's ID.
+	byAddress map[identityKey]string
+	db        *pgxpool.Pool
+}
+
+func NewIdentities() *Identities {
+	return &Identities{identities: make(map[identityKey]*Identity), byAddress: make(map[identityKey]string)}
+}
+
+// AttachDB connects the identities to Postgres and loads them.
+func (s *Identities) AttachDB(ctx context.Context, db *pgxpool.Pool) error {
+	identities := make(map[identityKey]*Identity)
+	byAddress := make(map[identityKey]string)
+	rows, err := db.Query(ctx, `SELECT tenant, id, name, created_at FROM identities`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var tenant string
+		var created time.Time
+		id := &Identity{Addresses: []LinkedAddress{}}
+		if err := rows.Scan(&tenant, &id.ID, &id.Name, &created); err != nil {
+			rows.Close()
+			return err
+		}
+		id.CreatedAt = created.UTC().Format(time.RFC3339)
+		identities[identityKey{tenant, id.ID}] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query(ctx, `SELECT tenant, identity_id, display_address, chain, evidence, event_id, linked_at FROM identity_addresses ORDER BY linked_at`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tenant, identity string
+		var a LinkedAddress
+		var linked time.Time
+		if err := rows.Scan(&tenant, &identity, &a.Address, &a.Chain, &a.Evidence, &a.EventID, &linked); err != nil {
+			return err
+		}
+		id, ok := identities[identityKey{tenant, identity}]
+		if !ok {
+			continue
+		}
+		a.LinkedAt = linked.UTC().Format(time.RFC3339)
+		id.Addresses = append(id.Addresses, a)
+		byAddress[identityKey{tenant, addressKey(a.Address)}] = identity
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.identities, s.byAddress, s.db = identities, byAddress, db
+	return nil
+}
+
+// copyIdentity returns a copy of id callers may keep.
+func copyIdentity(id *Identity) Identity {
+	out := *id
+	out.Addresses = append([]LinkedAddress{}, id.Addresses...)
+	return out
+}
+
+// Create adds an identity without addresses for tenant.
+func (s *Identities) Create(ctx context.Context, tenant, name string, now time.Time) (Identity, error) {
+	id := &Identity{ID: newRandomID(), Name: name, CreatedAt: now.UTC().Format(time.RFC3339), Addresses: []LinkedAddress{}}
+	if s.db != nil {
+		if _, err := s.db.Exec(ctx, `INSERT INTO identities (tenant, id, name, created_at) VALUES ($1, $2, $3, $4)`,
+			tenant, id.ID, name, now); err != nil {
+			return Identity{}, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.identities[identityKey{tenant, id.ID}] = id
+	return copyIdentity(id), nil
+}
+
+// Get returns tenant's identity id.
+func (s *Identities) Get(tenant, id string) (Identity, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	identity, ok := s.identities[identityKey{tenant, id}]
+	if !ok {
+		return Identity{}, false
+	}
+	return copyIdentity(identity), true
+}
+
+// Resolve returns the identity of tenant address is linked to.
+func (s *Identities) Resolve(tenant, address string) (Identity, bool) {
+	s.mu.RLock()
+	id, ok := s.byAddress[identityKey{tenant, addressKey(address)}]
+	s.mu.RUnlock()
+	if !ok {
+		return Identity{}, false
+	}
+	return s.Get(tenant, id)
+}
+
+// List returns tenant's identities, oldest first.
+func (s *Identities) List(tenant string) []Identity {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Identity, 0)
+	for key, id := range s.identities {
+		if key.tenant == tenant {
+			out = append(out, copyIdentity(id))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt != out[j].CreatedAt {
+			return out[i].CreatedAt < out[j].CreatedAt
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// Link links addresses to tenant's identity id, all or none. Addresses
+// already linked to it are skipped and addresses linked to another of
+// tenant's identities fail with errAddressLinked.
+func (s *Identities) Link(ctx context.Context, tenant, id string, addresses []LinkedAddress) (Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	identity, ok := s.identities[identityKey{tenant, id}]
+	if !ok {
+		return Identity{}, errIdentityNotFound
+	}
+	var added []LinkedAddress
+	for _, a := range addresses {
+		other, linked := s.byAddress[identityKey{tenant, addressKey(a.Address)}]
+		if linked && other != id {
+			return Identity{}, fmt.Errorf("%w: %s belongs to %s", errAddressLinked, a.Address, other)
+		}
+		if !linked {
+			added = append(added, a)
+		}
+	}
+	if s.db != nil && len(added) > 0 {
+		tx, err := s.db.Begin(ctx)
+		if err != nil {
+			return Identity{}, err
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+		for _, a := range added {
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO identity_addresses (tenant, address, display_address, chain, identity_id, evidence, event_id, linked_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+				tenant, addressKey(a.Address), a.Address, a.Chain, id, a.Evidence, a.EventID, a.LinkedAt); err != nil {
+				return Identity{}, err
+			}
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return Identity{}, err
+		}
+	}
+	for _, a := range added {
+		identity.Addresses = append(identity.Addresses, a)
+		s.byAddress[identityKey{tenant, addressKey(a.Address)}] = id
+	}
+	return copyIdentity(identity), nil
+}
+
+// Unlink removes address from tenant's identity id and reports whether it
+// was linked to it.
+func (s *Identities) Unlink(ctx context.Context, tenant, id, address string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := identityKey{tenant, addressKey(address)}
+	identity, ok := s.identities[identityKey{tenant, id}]
+	if !ok || s.byAddress[key] != id {
+		return false, nil
+	}
+	if s.db != nil {
+		if _, err := s.db.Exec(ctx, `DELETE FROM identity_addresses WHERE tenant = $1 AND address = $2`, tenant, key.id); err != nil {
+			return false, err
+		}
+	}
+	delete(s.byAddress, key)
+	kept := identity.Addresses[:0]
+	for _, a := range identity.Addresses {
+		if addressKey(a.Address) != key.id {
+			kept = append(kept, a)
+		}
+	}
+	identity.Addresses = kept
+	return true, nil
+}
+
+// Delete removes tenant's identity id with its links and reports whether
+// it existed.
+func (s *Identities) Delete(ctx context.Context, tenant, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	identity, ok := s.identities[identityKey{tenant, id}]
+	if !ok {
+		return false, nil
+	}
+	if s.db != nil {
+		tx, err := s.db.Begin(ctx)
+		if err != nil {
+			return false, err
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+		if _, err := tx.Exec(ctx, `DELETE FROM identity_addresses WHERE tenant = $1 AND identity_id = $2`, tenant, id); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM identities WHERE tenant = $1 AND id = $2`, tenant, id); err != nil {
+			return false, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return false, err
+		}
+	}
+	for _, a := range identity.Addresses {
+		delete(s.byAddress, identityKey{tenant, addressKey(a.Address)})
+	}
+	delete(s.identities, identityKey{tenant, id})
+	return true, nil
+}
+
+// bridgeEvidence returns the addresses a completed bridge transfer links:
+// its sender on the source chain and the recipients of its destination
+// legs.
+func bridgeEvidence(store *EventStore, tenant, eventID string, now time.Time) ([]LinkedAddress, error) {
+	ev, ok := store.GetByID(eventID)
+	if !ok || !tenantSees(tenant, ev) {
+		return nil, invalidParam("event_id", "event %s not found", eventID)
+	}
+	if ev.Bridge == "" {
+		return nil, invalidParam("event_id", "event %s is not a bridge transfer", eventID)
+	}
+	t := store.BridgeTransfer(ev, tenant, now)
+	if t.Status != TransferCompleted {
+		return nil, invalidParam("event_id", "bridge transfer %s has no destination leg yet", eventID)
+	}
+	linkedAt := now.UTC().Format(time.RFC3339)
+	seen := make(map[string]bool)
+	var out []LinkedAddress
+	add := func(address, chain string) {
+		if address == "" || seen[addressKey(address)] {
+			return
+		}
+		seen[addressKey(address)] = true
+		out = append(out, LinkedAddress{Address: address, Chain: chain, Evidence: EvidenceBridge, EventID: ev.EventID, LinkedAt: linkedAt})
+	}
+	add(ev.From, ev.Chain)
+	for _, leg := range t.Legs {
+		add(leg.To, leg.Chain)
+	}
+	return out, nil
+}
+
+// WalletView answers GET /wallet/{id}: the activity of an identity's
+// addresses, or of a single address, in one view.
+type WalletView struct {
+	// Identity is the identity viewed, or the one the address viewed is
+	// linked to; it is left out for unlinked addresses.
+	Identity  *Identity `json:"identity,omitempty"`
+	Addresses []string  `json:"addresses"`
+	// Stats are the flows of each address, in the order of Addresses.
+	Stats []WalletStats `json:"stats"`
+	// Transactions are the most recent transfers touching any of the
+	// addresses, newest first, each once.
+	Transactions []WalletEvent `json:"transactions"`
+	Limit        int           `json:"limit"`
+	Offset       int           `json:"offset"`
+	Total        int           `json:"total"`
+}
+
+// getWalletView serves the activity of the {address} identity, or of an
+// address together with the rest of its identity's addresses.
+func getWalletView(store *EventStore, identities *Identities, w http.ResponseWriter, r *http.Request) {
+	p := newQueryParams(r)
+	limit, offset := p.Page()
+	profile, err := parseProfile(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	tenant := tenantFrom(r.Context())
+	var view WalletView
+	identity, ok := identities.Get(tenant, chi.URLParam(r, "address"))
+	if !ok {
+		address, err := pathAddress(r, "")
+		if err != nil {
+			httpError(w, "no identity or address "+chi.URLParam(r, "address"), http.StatusNotFound)
+			return
+		}
+		if identity, ok = identities.Resolve(tenant, address); !ok {
+			view.Addresses = []string{address}
+		}
+	}
+	if ok {
+		view.Identity = &identity
+		for _, a := range identity.Addresses {
+			view.Addresses = append(view.Addresses, a.Address)
+		}
+	}
+
+	view.Stats, view.Transactions = []WalletStats{}, []WalletEvent{}
+	view.Limit, view.Offset = limit, offset
+	if len(view.Addresses) == 0 {
+		view.Addresses = []string{}
+		writeIdentityJSON(w, http.StatusOK, view)
+		return
+	}
+	wanted := make(map[string]struct{}, len(view.Addresses))
+	for _, a := range view.Addresses {
+		wanted[addressKey(a)] = struct{}{}
+		stats, err := store.WalletStats(a, EventFilter{Tenant: tenant})
+		if err != nil {
+			log.WithError(err).Error("failed to compute wallet stats")
+			httpError(w, "could not compute wallet stats", http.StatusInternalServerError)
+			return
+		}
+		view.Stats = append(view.Stats, stats)
+	}
+	filter := EventFilter{Tenant: tenant, Limit: limit, Offset: offset}
+	events := withProfile(profile, store.Enrich(store.GetByWallets(view.Addresses, filter)))
+	view.Transactions = attributeWallets(events, wanted)
+	view.Total = store.Count(view.Addresses, filter)
+	w.Header().Set("X-Total-Count", strconv.Itoa(view.Total))
+	writeIdentityJSON(w, http.StatusOK, view)
+}
+
+func writeIdentityJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// listIdentities returns the caller's identities.
+func listIdentities(identities *Identities, w http.ResponseWriter, r *http.Request) {
+	writeIdentityJSON(w, http.StatusOK, identities.List(tenantFrom(r.Context())))
+}
+
+// createIdentity adds an identity for the caller, to link addresses to.
+func createIdentity(identities *Identities, w http.ResponseWriter, r *http.Request) {
+	var body NewIdentity
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if len(body.Name) > maxIdentityName {
+		badRequest(w, invalidParam("name", "name is at most %d bytes", maxIdentityName))
+		return
+	}
+	identity, err := identities.Create(r.Context(), tenantFrom(r.Context()), body.Name, time.Now())
+	if err != nil {
+		log.WithError(err).Error("failed to store identity")
+		httpError(w, "could not store identity", http.StatusInternalServerError)
+		return
+	}
+	writeIdentityJSON(w, http.StatusCreated, identity)
+}
+
+// getIdentity returns one of the caller's identities.
+func getIdentity(identities *Identities, w http.ResponseWriter, r *http.Request) {
+	identity, ok := identities.Get(tenantFrom(r.Context()), chi.URLParam(r, "id"))
+	if !ok {
+		httpError(w, errIdentityNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	writeIdentityJSON(w, http.StatusOK, identity)
+}
+
+// deleteIdentity removes one of the caller's identities and its links.
+func deleteIdentity(identities *Identities, w http.ResponseWriter, r *http.Request) {
+	ok, err := identities.Delete(r.Context(), tenantFrom(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		log.WithError(err).Error("failed to delete identity")
+		httpError(w, "could not delete identity", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, errIdentityNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// linkIdentity links an address, or the addresses of a bridge transfer, to
+// one of the caller's identities.
+func linkIdentity(store *EventStore, identities *Identities, w http.ResponseWriter, r *http.Request) {
+	var body IdentityLink
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	tenant := tenantFrom(r.Context())
+	now := time.Now()
+	var addresses []LinkedAddress
+	switch {
+	case body.EventID != "" && body.Address != "":
+		badRequest(w, invalidParam("event_id", "set either address or event_id"))
+		return
+	case body.EventID != "":
+		var err error
+		if addresses, err = bridgeEvidence(store, tenant, body.EventID, now); err != nil {
+			badRequest(w, err)
+			return
+		}
+	case body.Address != "":
+		chain, _, err := resolveNetwork(body.Chain, "")
+		if err != nil {
+			badRequest(w, invalidParam("chain", "%v", err))
+			return
+		}
+		address, err := canonicalAddress(chain, body.Address)
+		if err != nil {
+			badRequest(w, invalidParam("address", "address: %v", err))
+			return
+		}
+		if chain == "" {
+			chain = addressChain(address)
+		}
+		addresses = []LinkedAddress{{Address: address, Chain: chain, Evidence: EvidenceManual, LinkedAt: now.UTC().Format(time.RFC3339)}}
+	default:
+		badRequest(w, invalidParam("address", "address or event_id is required"))
+		return
+	}
+
+	identity, err := identities.Link(r.Context(), tenant, chi.URLParam(r, "id"), addresses)
+	switch {
+	case errors.Is(err, errIdentityNotFound):
+		httpError(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errAddressLinked):
+		httpError(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.WithError(err).Error("failed to store identity links")
+		httpError(w, "could not store identity links", http.StatusInternalServerError)
+		return
+	}
+	writeIdentityJSON(w, http.StatusOK, identity)
+}
+
+// unlinkIdentity removes an address from one of the caller's identities.
+func unlinkIdentity(identities *Identities, w http.ResponseWriter, r *http.Request) {
+	address, err := pathAddress(r, "")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	ok, err := identities.Unlink(r.Context(), tenantFrom(r.Context()), chi.URLParam(r, "id"), address)
+	if err != nil {
+		log.WithError(err).Error("failed to delete identity link")
+		httpError(w, "could not delete identity link", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "address is not linked to this identity", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
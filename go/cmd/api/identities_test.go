@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestIdentityLinkingAndWalletView(t *testing.T) {
+	store := NewEventStore(100, 50)
+	identities := NewIdentities()
+	ts := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	add := func(id, chain, txHash, sequence string) {
+		ev := makeEvent(id, aliceAddr, bobAddr, "1000000", ts, "USDC")
+		ev.Chain, ev.Network, ev.TxHash, ev.Tenant = chain, "mainnet", txHash, "acme"
+		ev.Bridge, ev.SourceChain, ev.DestChain, ev.Sequence = BridgeCCTP, "ethereum", "base", sequence
+		store.Add(ev)
+	}
+	add("burn", "ethereum", "0x01", "1")
+	add("mint", "base", "0x02", "1")
+	add("pending", "ethereum", "0x03", "2")
+	sol := makeEvent("sol", wrappedSOL, wrappedSOL, "5", ts, "")
+	sol.Tenant = "acme"
+	store.Add(sol)
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, req.WithContext(withTenant(req.Context(), req.Header.Get("X-Tenant"))))
+		})
+	})
+	r.Get("/wallet/{address}", func(w http.ResponseWriter, req *http.Request) { getWalletView(store, identities, w, req) })
+	r.Post("/identities", func(w http.ResponseWriter, req *http.Request) { createIdentity(identities, w, req) })
+	r.Get("/identities/{id}", func(w http.ResponseWriter, req *http.Request) { getIdentity(identities, w, req) })
+	r.Delete("/identities/{id}", func(w http.ResponseWriter, req *http.Request) { deleteIdentity(identities, w, req) })
+	r.Post("/identities/{id}/links", func(w http.ResponseWriter, req *http.Request) { linkIdentity(store, identities, w, req) })
+	r.Delete("/identities/{id}/links/{address}", func(w http.ResponseWriter, req *http.Request) { unlinkIdentity(identities, w, req) })
+	do := func(method, path, tenant, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Tenant", tenant)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	create := func(tenant, name string) Identity {
+		t.Helper()
+		rec := do(http.MethodPost, "/identities", tenant, `{"name": "`+name+`"}`)
+		var identity Identity
+		if err := json.NewDecoder(rec.Body).Decode(&identity); err != nil || rec.Code != http.StatusCreated || identity.ID == "" {
+			t.Fatalf("create: %d %+v %v", rec.Code, identity, err)
+		}
+		return identity
+	}
+
+	acme := create("acme", "Acme treasury")
+	// The bridge transfer links its sender and recipient, then the Solana
+	// wallet is linked by hand
+	rec := do(http.MethodPost, "/identities/"+acme.ID+"/links", "acme", `{"event_id": "burn"}`)
+	var identity Identity
+	if err := json.NewDecoder(rec.Body).Decode(&identity); err != nil || rec.Code != http.StatusOK || len(identity.Addresses) != 2 ||
+		identity.Addresses[0].Chain != "ethereum" || identity.Addresses[1].Chain != "base" || identity.Addresses[1].Evidence != EvidenceBridge || identity.Addresses[1].EventID != "burn" {
+		t.Fatalf("bridge link: %d %+v %v", rec.Code, identity, err)
+	}
+	if rec := do(http.MethodPost, "/identities/"+acme.ID+"/links", "acme", `{"address": "`+wrappedSOL+`", "chain": "sol"}`); rec.Code != http.StatusOK ||
+		!strings.Contains(rec.Body.String(), `"chain":"solana","evidence":"manual"`) {
+		t.Fatalf("manual link: %d %s", rec.Code, rec.Body.String())
+	}
+	for body, want := range map[string]int{
+		`{"event_id": "pending"}`:          http.StatusBadRequest,
+		`{"event_id": "sol"}`:              http.StatusBadRequest,
+		`{"address": "0x12"}`:              http.StatusBadRequest,
+		`{}`:                               http.StatusBadRequest,
+		`{"address": "` + aliceAddr + `"}`: http.StatusOK,
+		`{"address": "` + aliceAddr + `", "event_id": "burn"}`: http.StatusBadRequest,
+	} {
+		if rec := do(http.MethodPost, "/identities/"+acme.ID+"/links", "acme", body); rec.Code != want {
+			t.Errorf("link %s: %d %s, want %d", body, rec.Code, rec.Body.String(), want)
+		}
+	}
+	// An address belongs to one identity of a tenant; tenants are apart
+	other := create("acme", "")
+	if rec := do(http.MethodPost, "/identities/"+other.ID+"/links", "acme", `{"address": "`+strings.ToLower(bobAddr)+`"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("link of a linked address: %d %s", rec.Code, rec.Body.String())
+	}
+	// Tron addresses given in hex are linked in base58check
+	if rec := do(http.MethodPost, "/identities/"+other.ID+"/links", "acme", `{"address": "41a614f803b6fd780986a42c78ec9c7f77e6ded13c", "chain": "trx"}`); rec.Code != http.StatusOK ||
+		!strings.Contains(rec.Body.String(), `"address":"`+tronUSDT+`","chain":"tron","evidence":"manual"`) {
+		t.Fatalf("tron link: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodGet, "/identities/"+acme.ID, "globex", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("other tenant's identity: %d", rec.Code)
+	}
+
+	view := func(id string) WalletView {
+		t.Helper()
+		rec := do(http.MethodGet, "/wallet/"+id, "", "")
+		var v WalletView
+		if err := json.NewDecoder(rec.Body).Decode(&v); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("view %s: %d %v", id, rec.Code, err)
+		}
+		return v
+	}
+	if rec := do(http.MethodGet, "/wallet/"+acme.ID, "globex", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("other tenant's view: %d", rec.Code)
+	}
+	rec = do(http.MethodGet, "/wallet/"+acme.ID, "acme", "")
+	var v WalletView
+	if err := json.NewDecoder(rec.Body).Decode(&v); err != nil || v.Identity == nil || v.Identity.Name != "Acme treasury" ||
+		len(v.Addresses) != 3 || len(v.Stats) != 3 || v.Total != 4 || len(v.Transactions) != 4 || rec.Header().Get("X-Total-Count") != "4" {
+		t.Fatalf("identity view: %d %+v %v", rec.Code, v, err)
+	}
+	// Tenant "" has no identities: an address is viewed alone
+	if v := view(strings.ToLower(bobAddr)); v.Identity != nil || len(v.Addresses) != 1 || addressKey(v.Addresses[0]) != bobAddr || v.Total != 3 {
+		t.Fatalf("unlinked view = %+v", v)
+	}
+	rec = do(http.MethodGet, "/wallet/"+wrappedSOL, "acme", "")
+	if err := json.NewDecoder(rec.Body).Decode(&v); err != nil || v.Identity == nil || v.Identity.ID != acme.ID || len(v.Addresses) != 3 {
+		t.Fatalf("linked address view: %d %+v %v", rec.Code, v, err)
+	}
+
+	if rec := do(http.MethodDelete, "/identities/"+acme.ID+"/links/"+wrappedSOL, "acme", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("unlink: %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/identities/"+acme.ID+"/links/"+wrappedSOL, "acme", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("unlink twice: %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/identities/"+acme.ID, "acme", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d", rec.Code)
+	}
+	if _, ok := identities.Resolve("acme", aliceAddr); ok {
+		t.Fatal("address still linked after its identity was deleted")
+	}
+	if rec := do(http.MethodGet, "/wallet/not-an-identity", "acme", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown identity view: %d", rec.Code)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+// eventSourceRetry is how long consumeEvents waits before restarting a
+// source that stopped with an error.
+const eventSourceRetry = 5 * time.Second
+
+// EventHandler processes one event published by the listener. An error
+// means the event could not be stored and should be delivered again.
+type EventHandler func(ctx context.Context, payload []byte) error
+
+// EventSource delivers listener events to a handler until ctx is done.
+type EventSource interface {
+	Consume(ctx context.Context, handle EventHandler) error
+}
+
+// EventSourceFromEnv selects the event source with EVENT_SOURCE: redis (the
+// default) or kafka.
+func EventSourceFromEnv(redisURL string, networks NetworkFilter, store *EventStore) (EventSource, error) {
+	switch kind := strings.ToLower(os.Getenv("EVENT_SOURCE")); kind {
+	case "", "redis":
+		return &RedisEventSource{URL: redisURL, Networks: networks}, nil
+	case "kafka":
+		restURL := os.Getenv("KAFKA_REST_URL")
+		if restURL == "" {
+			return nil, fmt.Errorf("KAFKA_REST_URL must be set for EVENT_SOURCE=kafka")
+		}
+		topics := []string{defaultKafkaTopic}
+		if spec := os.Getenv("KAFKA_TOPICS"); spec != "" {
+			topics = nil
+			for _, t := range strings.Split(spec, ",") {
+				if t = strings.TrimSpace(t); t != "" {
+					topics = append(topics, t)
+				}
+			}
+		}
+		group := os.Getenv("KAFKA_GROUP")
+		if group == "" {
+			group = defaultKafkaGroup
+		}
+		return NewKafkaSource(restURL, group, topics, store.Checkpoint), nil
+	default:
+		return nil, fmt.Errorf("unknown event source %q: want redis or kafka", kind)
+	}
+}
+
+// eventSourceKey is the context key of the transport an event arrived on.
+type eventSourceKey struct{}
+
+// withEventSource returns ctx for handling events that arrived on source,
+// such as a Redis channel or Kafka topic.
+func withEventSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, eventSourceKey{}, source)
+}
+
+// eventSourceFrom returns the transport set by withEventSource, or "".
+func eventSourceFrom(ctx context.Context) string {
+	source, _ := ctx.Value(eventSourceKey{}).(string)
+	return source
+}
+
+// consumeEvents runs source until ctx is done, restarting it after errors.
+func consumeEvents(ctx context.Context, source EventSource, handle EventHandler) {
+	for {
+		err := source.Consume(ctx, handle)
+		if ctx.Err() != nil {
+			return
+		}
+		log.WithError(err).Warn("event source stopped; restarting")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventSourceRetry):
+		}
+	}
+}
+
+// ingestEvents returns the handler that forwards events to the store, the
+// optional repository and the SSE hub. Events for networks outside the
+// allowlist (checked once chain and network aliases are resolved and the
+// chain id is set), and payloads that are not events, are dropped rather than
+// retried, as are copies of a stored transfer under another event_id once
+// what they add is merged into it (see Deduplicator). Events without a status are treated as confirmed (included in a
+// block), and untagged events are assigned to the tenant watching them.
+// When an enricher is configured, the annotations it returns are merged in
+// before the event is stored; plugins then annotate or drop it. Events
+// their producer did not stamp with a source get the transport they arrived
+// on (redis:<channel>, kafka:<topic>). An amendment marks the version it
+// supersedes once it is stored. Only events
+// that were persisted (or queued for a batched write) are cached, published
+// folded into the custom metrics and checked against the treasury
+// policies. Payloads of older envelope versions
+// are re-encoded from the decoded event, so subscribers see current fields.
+// The latency tracker times when events are received and broadcast.
+func ingestEvents(store *EventStore, hub *Hub, networks NetworkFilter, dedup *Deduplicator, tenants *Tenants, enricher *Enricher, plugins *Plugins, metrics *CustomMetrics, treasuries *TreasuryPolicies, latency *LatencyTracker) EventHandler {
+	return func(ctx context.Context, payload []byte) error {
+		event, version, err := decodeEvent(payload)
+		if err != nil {
+			log.WithError(err).Error("could not unmarshal event")
+			return nil
+		}
+		chain, network, chainID := event.Chain, event.Network, event.ChainID
+		canonicalizeEvent(event)
+		renamed := event.Chain != chain || event.Network != network || event.ChainID != chainID
+		if !networks.Allows(event.Chain, event.Network) {
+			log.WithFields(log.Fields{"chain": event.Chain, "network": event.Network, "event_id": event.EventID}).
+				Warn("rejecting event for network outside allowlist")
+			return nil
+		}
+		if err := chainAdapter(event.Chain).Normalize(event); err != nil {
+			log.WithError(err).WithField("event_id", event.EventID).Warn("rejecting event with invalid address")
+			return nil
+		}
+		log.Infof("received event: %+v", *event)
+		latency.Observe(event.Chain, StageIngest, event.Timestamp)
+		if event.Status == "" {
+			event.Status = StatusConfirmed
+		}
+		if event.Source == "" {
+			event.Source = eventSourceFrom(ctx)
+		}
+		if err := store.prepareAmendment(ctx, event); err != nil {
+			log.WithError(err).WithField("event_id", event.EventID).Warn("rejecting amendment")
+			return nil
+		}
+		// A copy of a stored transfer is merged into it, not stored again
+		dup, err := dedup.Merge(ctx, event)
+		if err != nil {
+			log.WithError(err).Warn("failed to merge duplicate event")
+			return err
+		}
+		if dup {
+			return nil
+		}
+		owner, shared := event.Tenant, len(event.SharedWith)
+		tenants.Tag(event)
+		tagged := event.Tenant != owner || len(event.SharedWith) != shared
+		annotated := enricher.Annotate(ctx, event)
+		pluginAnnotated, drop := plugins.Apply(ctx, event)
+		if drop {
+			log.WithField("event_id", event.EventID).Debug("event dropped by plugin")
+			return nil
+		}
+		annotated = annotated || pluginAnnotated
+
+		// Persist first (idempotent on event_id). With batching this blocks
+		// while the write buffer is full. An event that fails is neither
+		// cached nor announced, so a source that redelivers it announces
+		// it once, when it is stored. One the database rejects would fail
+		// again, so it is acknowledged once dead-lettered.
+		if err := store.Persist(ctx, event); err != nil {
+			if persistClass(err) != PersistTransient {
+				log.WithError(err).WithField("event_id", event.EventID).Warn("event rejected by the database")
+				return nil
+			}
+			log.WithError(err).Warn("failed to persist event")
+			return err
+		}
+		if err := store.markSuperseded(ctx, event); err != nil {
+			log.WithError(err).WithField("supersedes", event.Supersedes).Warn("failed to mark superseded event")
+			return err
+		}
+
+		store.Add(event)
+		store.responses.Invalidate(ctx, event)
+		// Stored first, so the price resolved later has an event to annotate
+		store.queuePrice(event)
+		labeled := store.EnrichOne(event)
+		metrics.Observe(labeled)
+		treasuries.Check(event)
+		if labeled != event || annotated || tagged || renamed || version != currentEventSchema {
+			if b, err := json.Marshal(labeled); err == nil {
+				payload = b
+			}
+		}
+		hub.PublishEvent(event, payload)
+		latency.Observe(event.Chain, StageBroadcast, event.Timestamp)
+		return nil
+	}
+}
+
+// RedisEventSource consumes the Redis Pub/Sub channels: the per-network
+// channels of the allowlist (or all of them when the allowlist is empty)
+// plus the legacy shared channel. Pub/Sub delivers at most once, so events
+// published while the API is down, or that fail to persist, are lost.
+type RedisEventSource struct {
+	URL      string
+	Networks NetworkFilter
+}
+
+// Consume subscribes to the channels and handles messages until ctx is done.
+func (s *RedisEventSource) Consume(ctx context.Context, handle EventHandler) error {
+	opt, err := redis.ParseURL(s.URL)
+	if err != nil {
+		return fmt.Errorf("could not parse redis url: %w", err)
+	}
+
+	rdb := redis.NewClient(opt)
+	defer rdb.Close()
+	// A registry can enable networks at runtime; take its change signal
+	// before reading the channels so no change is missed
+	var changed <-chan struct{}
+	registry, _ := s.Networks.(*ChainRegistry)
+	if registry != nil {
+		changed = registry.Changed()
+	}
+	subscribed := s.Networks.Channels()
+	channels := append([]string{legacyEventsChannel}, subscribed...)
+	pubsub := rdb.Subscribe(ctx, channels...)
+	defer pubsub.Close()
+	// Wait for Redis to confirm the subscription, so startup is not
+	// reported complete before events can arrive
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return fmt.Errorf("could not subscribe: %w", err)
+	}
+	markSubscribed(ctx)
+	if s.Networks.Empty() {
+		if err := pubsub.PSubscribe(ctx, eventsChannelPrefix+"*"); err != nil {
+			log.WithError(err).Error("could not subscribe to per-network channels")
+		}
+		channels = append(channels, eventsChannelPrefix+"*")
+	}
+
+	ch := pubsub.Channel()
+
+	log.WithField("channels", channels).Info("subscribing to events")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+			changed = registry.Changed()
+			subscribed = resubscribe(ctx, pubsub, subscribed, registry.Channels())
+		case msg, ok := <-ch:
+			if !ok {
+				return fmt.Errorf("redis subscription closed")
+			}
+			// Failures are logged by the handler; there is no redelivery
+			_ = handle(withEventSource(ctx, "redis:"+msg.Channel), []byte(msg.Payload))
+		}
+	}
+}
+
+// resubscribe moves pubsub from the per-network channels in have to those
+// in want, both sorted, and returns the channels now subscribed.
+func resubscribe(ctx context.Context, pubsub *redis.PubSub, have, want []string) []string {
+	var add, remove []string
+	i, j := 0, 0
+	for i < len(have) || j < len(want) {
+		switch {
+		case j == len(want) || (i < len(have) && have[i] < want[j]):
+			remove = append(remove, have[i])
+			i++
+		case i == len(have) || want[j] < have[i]:
+			add = append(add, want[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	if len(add) > 0 {
+		if err := pubsub.Subscribe(ctx, add...); err != nil {
+			log.WithError(err).Error("could not subscribe to enabled networks")
+			return have
+		}
+	}
+	if len(remove) > 0 {
+		if err := pubsub.Unsubscribe(ctx, remove...); err != nil {
+			log.WithError(err).Warn("could not unsubscribe from disabled networks")
+		}
+	}
+	if len(add) > 0 || len(remove) > 0 {
+		log.WithFields(log.Fields{"subscribed": add, "unsubscribed": remove}).Info("event channels updated")
+	}
+	return want
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Conversions a mapping can apply to a field.
+const (
+	ConvertUnixSeconds  = "unix_s"  // seconds since the epoch to RFC 3339
+	ConvertUnixMillis   = "unix_ms" // milliseconds since the epoch to RFC 3339
+	ConvertString       = "string"  // numbers and booleans to strings
+	ConvertNumber       = "number"  // numeric strings to numbers
+	ConvertLowercase    = "lowercase"
+	ConvertHexToDecimal = "hex_to_decimal" // 0x-prefixed hex to a decimal string
+)
+
+// IngestMappings is the YAML document of INGEST_MAPPINGS_FILE: rules that
+// rewrite the payloads of producers whose envelopes differ slightly from
+// the listener's into it, so onboarding them needs no code change.
+// Mappings run before the payload is decoded and validated, and the first
+// that matches a payload is the only one applied.
+type IngestMappings struct {
+	Mappings []IngestMapping `yaml:"mappings"`
+}
+
+// IngestMapping rewrites the payloads it matches: fields are renamed
+// first, then the defaults fill in missing fields, then the conversions
+// run on the renamed fields. Field names are dotted paths into nested
+// objects, such as "meta.sender".
+type IngestMapping struct {
+	Name  string       `yaml:"name"`
+	Match MappingMatch `yaml:"match"`
+	// Rename maps a producer's field to the envelope field it holds.
+	Rename   map[string]string      `yaml:"rename"`
+	Defaults map[string]interface{} `yaml:"defaults"`
+	// Convert maps an envelope field to one of the Convert* conversions.
+	Convert map[string]string `yaml:"convert"`
+}
+
+// MappingMatch selects the payloads a mapping applies to; every condition
+// set must hold, and an empty match applies to every payload.
+type MappingMatch struct {
+	// Source is the event source, "redis:<channel>" or "kafka:<topic>";
+	// a trailing * matches a prefix.
+	Source string `yaml:"source"`
+	// Has lists fields the payload must have.
+	Has []string `yaml:"has"`
+	// Equals maps fields to the value they must have, compared as text.
+	Equals map[string]string `yaml:"equals"`
+}
+
+// ParseIngestMappings parses and validates an INGEST_MAPPINGS_FILE
+// document. Unknown keys are errors, as in CONFIG_FILE.
+func ParseIngestMappings(data []byte) (*IngestMappings, error) {
+	var m IngestMappings
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	names := make(map[string]bool, len(m.Mappings))
+	for i, mapping := range m.Mappings {
+		if mapping.Name == "" {
+			return nil, fmt.Errorf("mapping %d: name is required", i+1)
+		}
+		if names[mapping.Name] {
+			return nil, fmt.Errorf("mapping %q is defined twice", mapping.Name)
+		}
+		names[mapping.Name] = true
+		if err := mapping.validate(); err != nil {
+			return nil, fmt.Errorf("mapping %q: %w", mapping.Name, err)
+		}
+	}
+	return &m, nil
+}
+
+// LoadIngestMappings reads and parses the mappings file at path.
+func LoadIngestMappings(path string) (*IngestMappings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseIngestMappings(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+func (m *IngestMapping) validate() error {
+	fields := append([]string(nil), m.Match.Has...)
+	for field := range m.Match.Equals {
+		fields = append(fields, field)
+	}
+	for field := range m.Defaults {
+		fields = append(fields, field)
+	}
+	targets := make(map[string]bool, len(m.Rename))
+	for from, to := range m.Rename {
+		if targets[to] {
+			return fmt.Errorf("several fields are renamed to %q", to)
+		}
+		targets[to] = true
+		fields = append(fields, from, to)
+	}
+	for field, conversion := range m.Convert {
+		switch conversion {
+		case ConvertUnixSeconds, ConvertUnixMillis, ConvertString, ConvertNumber, ConvertLowercase, ConvertHexToDecimal:
+		default:
+			return fmt.Errorf("field %q: unknown conversion %q; use unix_s, unix_ms, string, number, lowercase or hex_to_decimal", field, conversion)
+		}
+		fields = append(fields, field)
+	}
+	for _, field := range fields {
+		for _, part := range strings.Split(field, ".") {
+			if part == "" {
+				return fmt.Errorf("invalid field %q", field)
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether the mapping applies to a payload from source.
+func (m *IngestMapping) matches(source string, payload map[string]interface{}) bool {
+	if pattern := m.Match.Source; pattern != "" {
+		if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern {
+			if !strings.HasPrefix(source, prefix) {
+				return false
+			}
+		} else if source != pattern {
+			return false
+		}
+	}
+	for _, field := range m.Match.Has {
+		if _, ok := lookupPath(payload, field); !ok {
+			return false
+		}
+	}
+	for field, want := range m.Match.Equals {
+		if v, ok := lookupPath(payload, field); !ok || fmt.Sprint(v) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply rewrites payload with the first mapping matching it, returning the
+// payload unchanged when none does or it is not a JSON object, and the
+// name of the mapping applied.
+func (ms *IngestMappings) Apply(source string, payload []byte) ([]byte, string, error) {
+	if ms == nil || len(ms.Mappings) == 0 {
+		return payload, "", nil
+	}
+	var fields map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	// Numbers stay exact, as values and block numbers exceed float64
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil || fields == nil {
+		return payload, "", nil
+	}
+	for i := range ms.Mappings {
+		m := &ms.Mappings[i]
+		if !m.matches(source, fields) {
+			continue
+		}
+		if err := m.rewrite(fields); err != nil {
+			return nil, m.Name, err
+		}
+		out, err := json.Marshal(fields)
+		return out, m.Name, err
+	}
+	return payload, "", nil
+}
+
+// rewrite applies the mapping to fields in place.
+func (m *IngestMapping) rewrite(fields map[string]interface{}) error {
+	// Every renamed value is taken out before any is set, so renames can
+	// swap fields
+	moved := make(map[string]interface{}, len(m.Rename))
+	for from, to := range m.Rename {
+		if v, ok := lookupPath(fields, from); ok {
+			moved[to] = v
+			deletePath(fields, from)
+		}
+	}
+	for to, v := range moved {
+		setPath(fields, to, v)
+	}
+	for field, v := range m.Defaults {
+		if _, ok := lookupPath(fields, field); !ok {
+			setPath(fields, field, v)
+		}
+	}
+	for field, conversion := range m.Convert {
+		v, ok := lookupPath(fields, field)
+		if !ok || v == nil {
+			continue
+		}
+		converted, err := convertField(v, conversion)
+		if err != nil {
+			return fmt.Errorf("field %q: %w", field, err)
+		}
+		setPath(fields, field, converted)
+	}
+	return nil
+}
+
+// convertField applies a Convert* conversion to a decoded JSON value.
+func convertField(v interface{}, conversion string) (interface{}, error) {
+	text := fmt.Sprint(v)
+	switch conversion {
+	case ConvertUnixSeconds, ConvertUnixMillis:
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer timestamp", text)
+		}
+		t := time.Unix(n, 0)
+		if conversion == ConvertUnixMillis {
+			t = time.UnixMilli(n)
+		}
+		return t.UTC().Format(time.RFC3339), nil
+	case ConvertString:
+		return text, nil
+	case ConvertNumber:
+		if _, ok := new(big.Float).SetString(text); !ok {
+			return nil, fmt.Errorf("%q is not a number", text)
+		}
+		return json.Number(text), nil
+	case ConvertLowercase:
+		return strings.ToLower(text), nil
+	case ConvertHexToDecimal:
+		n, ok := new(big.Int).SetString(strings.TrimPrefix(strings.TrimPrefix(text, "0x"), "0X"), 16)
+		if !ok || !strings.HasPrefix(strings.ToLower(text), "0x") {
+			return nil, fmt.Errorf("%q is not a 0x-prefixed hex number", text)
+		}
+		return n.String(), nil
+	}
+	return v, nil
+}
+
+// lookupPath returns the value at a dotted path.
+func lookupPath(fields map[string]interface{}, path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := fields[part].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		fields = next
+	}
+	v, ok := fields[parts[len(parts)-1]]
+	return v, ok
+}
+
+// setPath sets the value at a dotted path, creating the objects on the way
+// and replacing values that are not objects.
+func setPath(fields map[string]interface{}, path string, v interface{}) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := fields[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			fields[part] = next
+		}
+		fields = next
+	}
+	fields[parts[len(parts)-1]] = v
+}
+
+// deletePath removes the value at a dotted path.
+func deletePath(fields map[string]interface{}, path string) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := fields[part].(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields = next
+	}
+	delete(fields, parts[len(parts)-1])
+}
+
+// Handler returns handle with the mappings applied to every payload first.
+// A payload a matching mapping cannot convert is logged and skipped, like
+// one that does not decode.
+func (ms *IngestMappings) Handler(handle EventHandler) EventHandler {
+	if ms == nil || len(ms.Mappings) == 0 {
+		return handle
+	}
+	return func(ctx context.Context, payload []byte) error {
+		mapped, name, err := ms.Apply(eventSourceFrom(ctx), payload)
+		if err != nil {
+			log.WithError(err).WithField("mapping", name).Error("could not map event")
+			return nil
+		}
+		return handle(ctx, mapped)
+	}
+}
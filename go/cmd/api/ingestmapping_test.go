@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const testMappings = `
+mappings:
+  - name: acme
+    match:
+      source: "kafka:acme-*"
+      has: [sender]
+      equals: {meta.producer: acme}
+    rename:
+      sender: from
+      recipient: to
+      meta.hash: tx_hash
+      time_ms: timestamp
+    defaults:
+      chain: ethereum
+      network: mainnet
+    convert:
+      timestamp: unix_ms
+      value: hex_to_decimal
+      block: number
+      from: lowercase
+  - name: catch-all
+    rename:
+      id: event_id
+`
+
+func TestIngestMappingsApply(t *testing.T) {
+	mappings, err := ParseIngestMappings([]byte(testMappings))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	payload := `{"id": "acme:1", "sender": "0xABCDEF", "recipient": "0x123", "time_ms": 1760443200123, "value": "0xde0b6b3a7640000",
+		"block": "123456789012345678901", "meta": {"producer": "acme", "hash": "0xfeed"}}`
+
+	out, name, err := mappings.Apply("kafka:acme-transfers", []byte(payload))
+	if err != nil || name != "acme" {
+		t.Fatalf("apply: mapping %q, err %v", name, err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	for field, want := range map[string]interface{}{
+		"from": "0xabcdef", "to": "0x123", "tx_hash": "0xfeed", "timestamp": "2025-10-14T12:00:00Z",
+		"value": "1000000000000000000", "chain": "ethereum", "network": "mainnet", "id": "acme:1",
+	} {
+		if got[field] != want {
+			t.Errorf("%s = %v, want %v", field, got[field], want)
+		}
+	}
+	if _, ok := got["sender"]; ok {
+		t.Error("renamed field kept")
+	}
+	if !strings.Contains(string(out), `"block":123456789012345678901`) {
+		t.Errorf("block number lost precision: %s", out)
+	}
+
+	// Another source falls through to the catch-all
+	if out, name, _ := mappings.Apply("redis:cross_chain_events", []byte(payload)); name != "catch-all" || !strings.Contains(string(out), `"event_id":"acme:1"`) {
+		t.Fatalf("catch-all: mapping %q, %s", name, out)
+	}
+	if _, name, err := mappings.Apply("kafka:acme-transfers", []byte(`{"sender": "0x1", "time_ms": "yesterday", "meta": {"producer": "acme"}}`)); err == nil || name != "acme" {
+		t.Fatalf("unconvertible timestamp: mapping %q, err %v", name, err)
+	}
+	if out, name, err := mappings.Apply("", []byte(`[1, 2]`)); err != nil || name != "" || string(out) != `[1, 2]` {
+		t.Fatalf("non-object payload: %s, %q, %v", out, name, err)
+	}
+}
+
+func TestParseIngestMappingsRejectsInvalidRules(t *testing.T) {
+	for _, doc := range []string{
+		"mappings:\n  - rename: {a: b}\n",
+		"mappings:\n  - name: a\n  - name: a\n",
+		"mappings:\n  - name: a\n    convert: {timestamp: iso8601}\n",
+		"mappings:\n  - name: a\n    rename: {a: from, b: from}\n",
+		"mappings:\n  - name: a\n    rename: {meta..hash: tx_hash}\n",
+		"mappings:\n  - name: a\n    renames: {a: b}\n",
+	} {
+		if _, err := ParseIngestMappings([]byte(doc)); err == nil {
+			t.Errorf("accepted %q", doc)
+		}
+	}
+	if m, err := ParseIngestMappings(nil); err != nil || len(m.Mappings) != 0 {
+		t.Fatalf("empty document: %+v, %v", m, err)
+	}
+}
+
+func TestIngestMappingsRunBeforeValidation(t *testing.T) {
+	mappings, err := ParseIngestMappings([]byte("mappings:\n  - name: legacy\n    rename: {sender: from, recipient: to, id: event_id}\n    defaults: {chain: solana, network: devnet}\n"))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	store := NewEventStore(100, 50)
+	allowAll, _ := ParseNetworkAllowlist("")
+	handle := mappings.Handler(ingestEvents(store, NewHub(), allowAll, nil, nil, nil, nil, nil, nil, nil))
+
+	payload := `{"id": "1", "sender": "` + wrappedSOL + `", "recipient": "` + wrappedSOL + `", "value": "1"}`
+	if err := handle(context.Background(), []byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+	if ev, ok := store.GetByID("1"); !ok || ev.From != wrappedSOL || ev.Chain != "solana" {
+		t.Fatalf("mapped event = %+v", ev)
+	}
+}
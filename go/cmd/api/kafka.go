@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Kafka ingestion defaults, overridable with KAFKA_TOPICS and KAFKA_GROUP.
+const (
+	defaultKafkaTopic = "cross_chain_events"
+	defaultKafkaGroup = "cross-chain-tracker-api"
+)
+
+const (
+	kafkaContentType  = "application/vnd.kafka.v2+json"
+	kafkaBinaryAccept = "application/vnd.kafka.binary.v2+json"
+	// kafkaPollTimeout is how long the proxy waits for records on a poll.
+	kafkaPollTimeout = time.Second
+)
+
+// KafkaSource consumes listener events from Kafka topics as a member of a
+// consumer group, through a Confluent-compatible REST Proxy (v2 API). The
+// API does not speak the Kafka protocol itself, so the proxy must run
+// alongside the brokers.
+// Offsets are committed only after the events of a poll are handled and
+// stored, so delivery is at least once: after a crash or a failed write the
+// group resumes from the last committed offset. Redelivered events are
+// harmless because persistence is idempotent on event_id.
+type KafkaSource struct {
+	baseURL    string
+	group      string
+	topics     []string
+	checkpoint func() Checkpoint
+	client     *http.Client
+}
+
+// NewKafkaSource returns a source joining group on the REST Proxy at
+// restURL. checkpoint is taken before each poll is handled and must succeed
+// before its offsets are committed.
+func NewKafkaSource(restURL, group string, topics []string, checkpoint func() Checkpoint) *KafkaSource {
+	return &KafkaSource{
+		baseURL:    strings.TrimRight(restURL, "/"),
+		group:      group,
+		topics:     topics,
+		checkpoint: checkpoint,
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// kafkaRecord is a record of a binary-format poll; Value is base64 on the
+// wire, which encoding/json decodes into []byte.
+type kafkaRecord struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Value     []byte `json:"value"`
+}
+
+type kafkaPartition struct {
+	Topic     string
+	Partition int
+}
+
+type kafkaOffset struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+// Consume joins the consumer group and handles records until ctx is done.
+// It returns an error when the consumer instance is lost, e.g. expired on
+// the proxy, so the caller can rejoin.
+func (k *KafkaSource) Consume(ctx context.Context, handle EventHandler) error {
+	consumer, err := k.join(ctx)
+	if err != nil {
+		return err
+	}
+	defer k.leave(consumer)
+	markSubscribed(ctx)
+	log.WithFields(log.Fields{"group": k.group, "topics": k.topics}).Info("consuming events from kafka")
+
+	for ctx.Err() == nil {
+		var records []kafkaRecord
+		url := fmt.Sprintf("%s/records?timeout=%d", consumer, kafkaPollTimeout.Milliseconds())
+		if err := k.do(ctx, http.MethodGet, url, nil, kafkaBinaryAccept, &records); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return fmt.Errorf("poll: %w", err)
+		}
+		if err := k.process(ctx, consumer, records, handle); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.WithError(err).Warn("kafka records not committed; they will be redelivered")
+			select {
+			case <-ctx.Done():
+			case <-time.After(eventSourceRetry):
+			}
+		}
+	}
+	return nil
+}
+
+// process handles records in order and commits the offsets of those handled
+// once they are stored. After a failure the remaining records are skipped
+// and every partition is rewound to its first uncommitted record, which the
+// next poll then returns again.
+func (k *KafkaSource) process(ctx context.Context, consumer string, records []kafkaRecord, handle EventHandler) error {
+	if len(records) == 0 {
+		return nil
+	}
+	checkpoint := k.checkpoint()
+	first := make(map[kafkaPartition]int64)
+	handled := make(map[kafkaPartition]int64)
+	var failure error
+	for _, rec := range records {
+		p := kafkaPartition{rec.Topic, rec.Partition}
+		if _, ok := first[p]; !ok {
+			first[p] = rec.Offset
+		}
+		if failure != nil {
+			continue
+		}
+		if err := handle(withEventSource(ctx, "kafka:"+rec.Topic), rec.Value); err != nil {
+			failure = fmt.Errorf("%s/%d@%d: %w", rec.Topic, rec.Partition, rec.Offset, err)
+			continue
+		}
+		handled[p] = rec.Offset
+	}
+	// Records already handled are stored and committed even when ctx is
+	// cancelled mid-poll, so a shutdown does not redeliver them
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if len(handled) > 0 {
+		if err := checkpoint(ctx); err != nil {
+			failure = err
+			handled = nil
+		} else if err := k.commit(ctx, consumer, handled); err != nil {
+			return err
+		}
+	}
+	if failure == nil {
+		return nil
+	}
+
+	positions := make([]kafkaOffset, 0, len(first))
+	for p, offset := range first {
+		if last, ok := handled[p]; ok {
+			offset = last + 1
+		}
+		positions = append(positions, kafkaOffset{Topic: p.Topic, Partition: p.Partition, Offset: offset})
+	}
+	body := map[string]interface{}{"offsets": positions}
+	if err := k.do(ctx, http.MethodPost, consumer+"/positions", body, "", nil); err != nil {
+		return fmt.Errorf("%v (rewind failed: %v)", failure, err)
+	}
+	return failure
+}
+
+// commit commits the last handled offset of each partition. The proxy
+// stores offset+1, the next record the group reads.
+func (k *KafkaSource) commit(ctx context.Context, consumer string, handled map[kafkaPartition]int64) error {
+	offsets := make([]kafkaOffset, 0, len(handled))
+	for p, offset := range handled {
+		offsets = append(offsets, kafkaOffset{Topic: p.Topic, Partition: p.Partition, Offset: offset})
+	}
+	if err := k.do(ctx, http.MethodPost, consumer+"/offsets", map[string]interface{}{"offsets": offsets}, "", nil); err != nil {
+		return fmt.Errorf("commit offsets: %w", err)
+	}
+	return nil
+}
+
+// join creates a consumer instance in the group, subscribed to the topics,
+// and returns its base URI. Offsets are only committed explicitly, and a
+// new group starts from the earliest retained record.
+func (k *KafkaSource) join(ctx context.Context) (string, error) {
+	var instance struct {
+		BaseURI string `json:"base_uri"`
+	}
+	config := map[string]string{
+		"format":             "binary",
+		"auto.offset.reset":  "earliest",
+		"auto.commit.enable": "false",
+	}
+	if err := k.do(ctx, http.MethodPost, k.baseURL+"/consumers/"+k.group, config, "", &instance); err != nil {
+		return "", fmt.Errorf("create consumer: %w", err)
+	}
+	consumer := strings.TrimRight(instance.BaseURI, "/")
+	if err := k.do(ctx, http.MethodPost, consumer+"/subscription", map[string][]string{"topics": k.topics}, "", nil); err != nil {
+		k.leave(consumer)
+		return "", fmt.Errorf("subscribe: %w", err)
+	}
+	return consumer, nil
+}
+
+// leave deletes the consumer instance so the group rebalances right away
+// instead of waiting for the proxy to expire it.
+func (k *KafkaSource) leave(consumer string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := k.do(ctx, http.MethodDelete, consumer, nil, "", nil); err != nil {
+		log.WithError(err).Warn("could not delete kafka consumer")
+	}
+}
+
+// do sends a REST Proxy request with an optional JSON body and decodes a
+// JSON response into out, if given.
+func (k *KafkaSource) do(ctx context.Context, method, url string, body interface{}, accept string, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", kafkaContentType)
+	}
+	if accept == "" {
+		accept = kafkaContentType
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var proxyErr struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&proxyErr)
+		return fmt.Errorf("%s %s: %s %s", method, url, resp.Status, proxyErr.Message)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
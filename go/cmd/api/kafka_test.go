@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKafkaProxy is a REST Proxy with one consumer instance that serves
+// the queued polls in order and records commits, rewinds and deletion.
+type fakeKafkaProxy struct {
+	*httptest.Server
+	mu        sync.Mutex
+	polls     [][]kafkaRecord
+	topics    []string
+	commits   [][]kafkaOffset
+	positions [][]kafkaOffset
+	deleted   bool
+}
+
+func newFakeKafkaProxy(t *testing.T, polls ...[]kafkaRecord) *fakeKafkaProxy {
+	p := &fakeKafkaProxy{polls: polls}
+	base := "/consumers/group/instances/c1"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/consumers/group", func(w http.ResponseWriter, r *http.Request) {
+		var config map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&config)
+		if config["auto.commit.enable"] != "false" || config["format"] != "binary" {
+			t.Errorf("consumer config = %v", config)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"instance_id": "c1", "base_uri": p.URL + base})
+	})
+	mux.HandleFunc(base+"/subscription", func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Topics []string }
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		p.mu.Lock()
+		p.topics = body.Topics
+		p.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc(base+"/records", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		records := []kafkaRecord{}
+		if len(p.polls) > 0 {
+			records, p.polls = p.polls[0], p.polls[1:]
+		}
+		p.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(records)
+	})
+	offsets := func(dst *[][]kafkaOffset) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var body struct{ Offsets []kafkaOffset }
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			p.mu.Lock()
+			*dst = append(*dst, body.Offsets)
+			p.mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+	mux.HandleFunc(base+"/offsets", offsets(&p.commits))
+	mux.HandleFunc(base+"/positions", offsets(&p.positions))
+	mux.HandleFunc(base, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			p.mu.Lock()
+			p.deleted = true
+			p.mu.Unlock()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func okCheckpoint() Checkpoint { return func(context.Context) error { return nil } }
+
+func records(partition int, offsets ...int64) []kafkaRecord {
+	out := make([]kafkaRecord, 0, len(offsets))
+	for _, o := range offsets {
+		out = append(out, kafkaRecord{Topic: "events", Partition: partition, Offset: o, Value: []byte(`{}`)})
+	}
+	return out
+}
+
+func TestKafkaSourceConsumeCommitsAfterHandling(t *testing.T) {
+	first := kafkaRecord{Topic: "events", Partition: 0, Offset: 7, Value: []byte(`{"event_id":"a"}`)}
+	second := kafkaRecord{Topic: "events", Partition: 0, Offset: 8, Value: []byte(`{"event_id":"b"}`)}
+	proxy := newFakeKafkaProxy(t, []kafkaRecord{first, second})
+	source := NewKafkaSource(proxy.URL+"/", "group", []string{"events"}, okCheckpoint)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var got []string
+	done := make(chan error)
+	go func() {
+		done <- source.Consume(ctx, func(_ context.Context, payload []byte) error {
+			got = append(got, string(payload))
+			if len(got) == 2 {
+				cancel()
+			}
+			return nil
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("consume: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("consume did not stop")
+	}
+
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	if len(got) != 2 || got[0] != `{"event_id":"a"}` {
+		t.Fatalf("handled %v", got)
+	}
+	if len(proxy.topics) != 1 || proxy.topics[0] != "events" {
+		t.Fatalf("subscribed to %v", proxy.topics)
+	}
+	// The poll is committed even though ctx was cancelled while handling it
+	if len(proxy.commits) != 1 || len(proxy.commits[0]) != 1 || proxy.commits[0][0].Offset != 8 {
+		t.Fatalf("commits = %v, want offset 8", proxy.commits)
+	}
+	if !proxy.deleted {
+		t.Fatal("consumer instance not deleted")
+	}
+}
+
+func TestKafkaSourceRewindsAfterHandlerFailure(t *testing.T) {
+	proxy := newFakeKafkaProxy(t)
+	source := NewKafkaSource(proxy.URL, "group", nil, okCheckpoint)
+	polled := append(records(0, 10, 11, 12), records(1, 3)...)
+
+	calls := 0
+	err := source.process(context.Background(), proxy.URL+"/consumers/group/instances/c1", polled,
+		func(context.Context, []byte) error {
+			calls++
+			if calls == 2 {
+				return errors.New("database down")
+			}
+			return nil
+		})
+	if err == nil {
+		t.Fatal("expected the handler failure")
+	}
+	if calls != 2 {
+		t.Fatalf("handled %d records after the failure, want to stop at 2", calls)
+	}
+	if len(proxy.commits) != 1 || len(proxy.commits[0]) != 1 || proxy.commits[0][0] != (kafkaOffset{"events", 0, 10}) {
+		t.Fatalf("commits = %v, want partition 0 at 10", proxy.commits)
+	}
+	rewound := map[int]int64{}
+	for _, p := range proxy.positions[0] {
+		rewound[p.Partition] = p.Offset
+	}
+	if len(rewound) != 2 || rewound[0] != 11 || rewound[1] != 3 {
+		t.Fatalf("positions = %v, want partition 0 at 11 and 1 at 3", proxy.positions)
+	}
+}
+
+func TestKafkaSourceSkipsCommitWhenNotStored(t *testing.T) {
+	proxy := newFakeKafkaProxy(t)
+	failing := func() Checkpoint {
+		return func(context.Context) error { return errors.New("events failed to persist") }
+	}
+	source := NewKafkaSource(proxy.URL, "group", nil, failing)
+
+	err := source.process(context.Background(), proxy.URL+"/consumers/group/instances/c1", records(0, 5, 6),
+		func(context.Context, []byte) error { return nil })
+	if err == nil {
+		t.Fatal("expected the checkpoint failure")
+	}
+	if len(proxy.commits) != 0 {
+		t.Fatalf("committed %v after a failed write", proxy.commits)
+	}
+	if len(proxy.positions) != 1 || proxy.positions[0][0].Offset != 5 {
+		t.Fatalf("positions = %v, want rewind to 5", proxy.positions)
+	}
+}
+
+// failingRepository fails every insert.
+type failingRepository struct{ *MemoryRepository }
+
+func (failingRepository) InsertBatch(context.Context, []*Event) error {
+	return errors.New("disk full")
+}
+
+func (failingRepository) Insert(context.Context, *Event) error {
+	return errors.New("disk full")
+}
+
+func TestIngestSkipsEventsThatFailToPersist(t *testing.T) {
+	store := NewEventStore(100, 50)
+	store.AttachRepository(failingRepository{NewMemoryRepository(100, 50)})
+	allowAll, _ := ParseNetworkAllowlist("")
+	handle := ingestEvents(store, NewHub(), allowAll, nil, nil, nil, nil, nil, nil, nil)
+
+	payload := `{"event_id":"1","chain":"solana","network":"devnet","from":"` + wrappedSOL + `","to":"` + wrappedSOL + `","value":"1"}`
+	if err := handle(context.Background(), []byte(payload)); err == nil {
+		t.Fatal("expected the persist failure, so the event is redelivered")
+	}
+	if got := store.cache.Stats().Events; got != 0 {
+		t.Fatalf("cached %d events that were not stored", got)
+	}
+}
+
+func TestIngestStampsTransportSource(t *testing.T) {
+	store := NewEventStore(100, 50)
+	allowAll, _ := ParseNetworkAllowlist("")
+	hub := NewHub()
+	go hub.Run()
+	handle := ingestEvents(store, hub, allowAll, nil, nil, nil, nil, nil, nil, nil)
+
+	ctx := withEventSource(context.Background(), "kafka:events")
+	unstamped := `{"event_id":"1","chain":"solana","network":"devnet","from":"` + wrappedSOL + `","to":"` + wrappedSOL + `","value":"1"}`
+	stamped := `{"event_id":"2","chain":"solana","network":"devnet","from":"` + wrappedSOL + `","to":"` + wrappedSOL + `","value":"2",` +
+		`"source":"indexer-solana@v0.1.0"}`
+	for _, payload := range []string{unstamped, stamped} {
+		if err := handle(ctx, []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, want := range map[string]string{"1": "kafka:events", "2": "indexer-solana@v0.1.0"} {
+		if ev, ok := store.GetByID(id); !ok || ev.Source != want {
+			t.Fatalf("event %s = %+v, want source %s", id, ev, want)
+		}
+	}
+}
+
+func TestEventStoreCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	store := NewEventStore(100, 50)
+	if err := store.Checkpoint()(ctx); err != nil {
+		t.Fatalf("checkpoint without repository: %v", err)
+	}
+
+	repo := failingRepository{NewMemoryRepository(100, 50)}
+	store.AttachRepository(repo)
+	batch := NewBatchWriter(repo, 10, time.Hour, 10)
+	defer batch.Close(ctx)
+	store.AttachBatchWriter(batch)
+
+	checkpoint := store.Checkpoint()
+	if err := store.Persist(ctx, makeEvent("1", "a", "b", "1", "", "")); err != nil {
+		t.Fatalf("persist: %v", err)
+	}
+	if err := checkpoint(ctx); err == nil {
+		t.Fatal("checkpoint succeeded although the batch failed")
+	}
+	if err := store.Checkpoint()(ctx); err != nil {
+		t.Fatalf("later checkpoint: %v", err)
+	}
+}
+
+func TestEventSourceFromEnv(t *testing.T) {
+	store := NewEventStore(100, 50)
+	networks, _ := ParseNetworkAllowlist("")
+
+	if source, err := EventSourceFromEnv("redis://localhost:6379", networks, store); err != nil {
+		t.Fatalf("default source: %v", err)
+	} else if _, ok := source.(*RedisEventSource); !ok {
+		t.Fatalf("default source = %T, want redis", source)
+	}
+
+	t.Setenv("EVENT_SOURCE", "kafka")
+	if _, err := EventSourceFromEnv("", networks, store); err == nil {
+		t.Fatal("kafka without KAFKA_REST_URL: expected error")
+	}
+	t.Setenv("KAFKA_REST_URL", "http://proxy:8082")
+	t.Setenv("KAFKA_TOPICS", "a, b")
+	source, err := EventSourceFromEnv("", networks, store)
+	kafka, ok := source.(*KafkaSource)
+	if err != nil || !ok || len(kafka.topics) != 2 || kafka.topics[1] != "b" || kafka.group != defaultKafkaGroup {
+		t.Fatalf("kafka source = %+v, %v", source, err)
+	}
+
+	t.Setenv("EVENT_SOURCE", "nats")
+	if _, err := EventSourceFromEnv("", networks, store); err == nil {
+		t.Fatal("unknown source: expected error")
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+// Label categories.
+const (
+	LabelExchange = "exchange"
+	LabelBridge   = "bridge"
+	LabelContract = "contract"
+	LabelTeam     = "team"
+	LabelOther    = "other"
+	// LabelSanctioned marks addresses on a sanctions list; transfers with
+	// them are reported in the daily reports.
+	LabelSanctioned = "sanctioned"
+)
+
+// maxLabelImportBytes caps the size of a CSV bulk import.
+const maxLabelImportBytes = 10 << 20
+
+// Label maps an address to a human-readable name and category.
+type Label struct {
+	Address   string `json:"address"`
+	Name      string `json:"name"`
+	Category  string `json:"category"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+// LabelImportResult summarizes a CSV bulk import.
+type LabelImportResult struct {
+	Imported int      `json:"imported"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// Validate normalizes the label and checks its fields.
+func (l *Label) Validate() error {
+	l.Address = addressKey(strings.TrimSpace(l.Address))
+	l.Name = strings.TrimSpace(l.Name)
+	l.Category = strings.ToLower(strings.TrimSpace(l.Category))
+	if l.Category == "" {
+		l.Category = LabelOther
+	}
+	if l.Address == "" {
+		return errors.New("address is required")
+	}
+	if l.Name == "" {
+		return errors.New("name is required")
+	}
+	switch l.Category {
+	case LabelExchange, LabelBridge, LabelContract, LabelTeam, LabelOther, LabelSanctioned:
+		return nil
+	}
+	return fmt.Errorf("category must be one of exchange, bridge, contract, team, sanctioned, other")
+}
+
+// LabelStore keeps all labels in memory for fast enrichment and, when a
+// database is attached, persists them to the labels table.
+type LabelStore struct {
+	mu     sync.RWMutex
+	labels map[string]Label
+	db     *pgxpool.Pool
+	// owners withholds the labels of private owned wallets from the
+	// tenants that do not own them.
+	owners *WalletOwners
+}
+
+func NewLabelStore() *LabelStore {
+	return &LabelStore{labels: make(map[string]Label)}
+}
+
+// AttachDB connects the store to Postgres and loads the existing labels.
+func (s *LabelStore) AttachDB(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx, `SELECT address, name, category, updated_at FROM labels`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	loaded := make(map[string]Label)
+	for rows.Next() {
+		var l Label
+		var updated time.Time
+		if err := rows.Scan(&l.Address, &l.Name, &l.Category, &updated); err != nil {
+			return err
+		}
+		l.UpdatedAt = updated.UTC().Format(time.RFC3339)
+		loaded[l.Address] = l
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.labels = loaded
+	s.db = db
+	s.mu.Unlock()
+	return nil
+}
+
+// AttachOwners hides the labels of wallets their owners made private from
+// the other tenants.
+func (s *LabelStore) AttachOwners(owners *WalletOwners) {
+	s.owners = owners
+}
+
+// Get returns the label for an address as tenant sees it.
+func (s *LabelStore) Get(tenant, address string) (Label, bool) {
+	if s == nil {
+		return Label{}, false
+	}
+	s.mu.RLock()
+	l, ok := s.labels[addressKey(address)]
+	s.mu.RUnlock()
+	if ok && s.owners.LabelHidden(tenant, address) {
+		return Label{}, false
+	}
+	return l, ok
+}
+
+// List returns the labels tenant sees, optionally restricted to a
+// category, sorted by address.
+func (s *LabelStore) List(tenant, category string) []Label {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Label, 0, len(s.labels))
+	for _, l := range s.labels {
+		if (category == "" || l.Category == category) && !s.owners.LabelHidden(tenant, l.Address) {
+			out = append(out, l)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Address < out[j].Address })
+	return out
+}
+
+// Put creates or replaces a label.
+func (s *LabelStore) Put(ctx context.Context, l Label) (Label, error) {
+	if err := l.Validate(); err != nil {
+		return l, err
+	}
+	l.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if s.db != nil {
+		_, err := s.db.Exec(ctx, `
+			INSERT INTO labels (address, name, category, updated_at) VALUES ($1, $2, $3, NOW())
+			ON CONFLICT (address) DO UPDATE SET name = EXCLUDED.name, category = EXCLUDED.category, updated_at = NOW()
+		`, l.Address, l.Name, l.Category)
+		if err != nil {
+			return l, err
+		}
+	}
+	s.mu.Lock()
+	s.labels[l.Address] = l
+	s.mu.Unlock()
+	return l, nil
+}
+
+// Delete removes a label and reports whether it existed.
+func (s *LabelStore) Delete(ctx context.Context, address string) (bool, error) {
+	address = addressKey(address)
+	if s.db != nil {
+		if _, err := s.db.Exec(ctx, `DELETE FROM labels WHERE address = $1`, address); err != nil {
+			return false, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.labels[address]
+	delete(s.labels, address)
+	return ok, nil
+}
+
+// Import reads address,name,category rows from CSV. A header row is skipped
+// when present. Invalid rows are reported and skipped; valid rows are
+// imported.
+func (s *LabelStore) Import(ctx context.Context, r io.Reader) (LabelImportResult, error) {
+	var res LabelImportResult
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+		if line == 1 && len(rec) > 0 && strings.EqualFold(strings.TrimSpace(rec[0]), "address") {
+			continue
+		}
+		if len(rec) < 2 {
+			res.Errors = append(res.Errors, fmt.Sprintf("line %d: expected address,name[,category]", line))
+			continue
+		}
+		l := Label{Address: rec[0], Name: rec[1]}
+		if len(rec) > 2 {
+			l.Category = rec[2]
+		}
+		if _, err := s.Put(ctx, l); err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		res.Imported++
+	}
+	return res, nil
+}
+
+// Enrich returns the events with from_label/to_label filled in. Labeled
+// events are copied, since stored events are shared with other readers.
+func (s *LabelStore) Enrich(events []*Event) []*Event {
+	if s == nil {
+		return events
+	}
+	out := make([]*Event, len(events))
+	for i, ev := range events {
+		out[i] = s.EnrichOne(ev)
+	}
+	return out
+}
+
+// EnrichOne returns ev, or a labeled copy of it. Labels are those the
+// event's tenant sees, which the tenants it is shared with see too.
+func (s *LabelStore) EnrichOne(ev *Event) *Event {
+	if s == nil || ev == nil {
+		return ev
+	}
+	from, fromOK := s.Get(ev.Tenant, ev.From)
+	to, toOK := s.Get(ev.Tenant, ev.To)
+	if !fromOK && !toOK {
+		return ev
+	}
+	labeled := *ev
+	if fromOK {
+		labeled.FromLabel = from.Name
+	}
+	if toOK {
+		labeled.ToLabel = to.Name
+	}
+	return &labeled
+}
+
+// listLabels returns all labels, optionally filtered by ?category=.
+func listLabels(labels *LabelStore, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(labels.List(tenantFrom(r.Context()), strings.ToLower(r.URL.Query().Get("category"))))
+}
+
+// getLabel returns the label for a single address.
+func getLabel(labels *LabelStore, w http.ResponseWriter, r *http.Request) {
+	l, ok := labels.Get(tenantFrom(r.Context()), chi.URLParam(r, "address"))
+	if !ok {
+		httpError(w, "label not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(l)
+}
+
+// putLabel creates or replaces the label for an address.
+func putLabel(labels *LabelStore, w http.ResponseWriter, r *http.Request) {
+	var l Label
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&l); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	l.Address = chi.URLParam(r, "address")
+	if err := l.Validate(); err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	l, err := labels.Put(r.Context(), l)
+	if err != nil {
+		log.WithError(err).Error("failed to store label")
+		httpError(w, "could not store label", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(l)
+}
+
+// deleteLabel removes the label for an address.
+func deleteLabel(labels *LabelStore, w http.ResponseWriter, r *http.Request) {
+	ok, err := labels.Delete(r.Context(), chi.URLParam(r, "address"))
+	if err != nil {
+		log.WithError(err).Error("failed to delete label")
+		httpError(w, "could not delete label", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "label not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// importLabels bulk-imports labels from a CSV body.
+func importLabels(labels *LabelStore, w http.ResponseWriter, r *http.Request) {
+	res, err := labels.Import(r.Context(), http.MaxBytesReader(w, r.Body, maxLabelImportBytes))
+	if err != nil {
+		httpError(w, fmt.Sprintf("invalid CSV: %v", err), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLabelImportCSV(t *testing.T) {
+	labels := NewLabelStore()
+	csvBody := "address,name,category\n" +
+		"0xABC,Binance Hot Wallet,exchange\n" +
+		"0xdef,Wormhole,bridge\n" +
+		"0x123,Unknown,casino\n" +
+		"0x456\n" +
+		"0x789,Treasury\n"
+
+	res, err := labels.Import(context.Background(), strings.NewReader(csvBody))
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if res.Imported != 3 || len(res.Errors) != 2 {
+		t.Fatalf("expected 3 imported and 2 errors, got %+v", res)
+	}
+	if l, ok := labels.Get("", "0xabc"); !ok || l.Name != "Binance Hot Wallet" || l.Category != LabelExchange {
+		t.Fatalf("unexpected label %+v", l)
+	}
+	if l, _ := labels.Get("", "0x789"); l.Category != LabelOther {
+		t.Fatalf("expected missing category to default to other, got %q", l.Category)
+	}
+	if got := labels.List("", LabelBridge); len(got) != 1 || got[0].Address != "0xdef" {
+		t.Fatalf("unexpected bridge labels %+v", got)
+	}
+}
+
+func TestLabelHandlersCRUD(t *testing.T) {
+	labels := NewLabelStore()
+
+	r := httptest.NewRecorder()
+	req := withChiParam(httptest.NewRequest(http.MethodPut, "/labels/0xAbC", strings.NewReader(`{"name":"Team multisig","category":"team"}`)), "address", "0xAbC")
+	putLabel(labels, r, req)
+	if r.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", r.Code, r.Body.String())
+	}
+
+	r = httptest.NewRecorder()
+	getLabel(labels, r, withChiParam(httptest.NewRequest(http.MethodGet, "/labels/0xabc", nil), "address", "0xabc"))
+	var l Label
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil || l.Name != "Team multisig" {
+		t.Fatalf("unexpected label %+v err=%v", l, err)
+	}
+
+	r = httptest.NewRecorder()
+	req = withChiParam(httptest.NewRequest(http.MethodPut, "/labels/0xabc", strings.NewReader(`{"name":"x","category":"nope"}`)), "address", "0xabc")
+	putLabel(labels, r, req)
+	if r.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid category, got %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	deleteLabel(labels, r, withChiParam(httptest.NewRequest(http.MethodDelete, "/labels/0xabc", nil), "address", "0xabc"))
+	if r.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", r.Code)
+	}
+	r = httptest.NewRecorder()
+	getLabel(labels, r, withChiParam(httptest.NewRequest(http.MethodGet, "/labels/0xabc", nil), "address", "0xabc"))
+	if r.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", r.Code)
+	}
+}
+
+func TestTransactionsEnrichedWithLabels(t *testing.T) {
+	store := NewEventStore(100, 50)
+	labels := NewLabelStore()
+	store.AttachLabels(labels)
+	if _, err := labels.Put(context.Background(), Label{Address: "alice", Name: "Alice Exchange", Category: LabelExchange}); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	stored := makeEvent("1", "alice", "bob", "1", time.Now().UTC().Format(time.RFC3339), "")
+	store.Add(stored)
+
+	r := httptest.NewRecorder()
+	getTransactions(store, r, httptest.NewRequest(http.MethodGet, "/transactions", nil))
+	var events []*Event
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(events) != 1 || events[0].FromLabel != "Alice Exchange" || events[0].ToLabel != "" {
+		t.Fatalf("expected from_label only, got %+v", events)
+	}
+	if stored.FromLabel != "" {
+		t.Fatalf("enrichment must not modify stored events")
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Stages of an event's way through the tracker. Each is timed from the
+// event's block timestamp.
+const (
+	// StageIngest is when the API received the event from its source.
+	StageIngest = "ingest"
+	// StageBroadcast is when it was published on the live streams.
+	StageBroadcast = "broadcast"
+	// StageWebhook is when a webhook acknowledged a system event about it,
+	// such as an alert, that carries its timestamp in data.event_timestamp.
+	StageWebhook = "webhook"
+)
+
+// Defaults of the latency SLO: 99% of events broadcast within 30s, with a
+// notice when the error budget burns 14.4 times too fast, which spends a
+// 30-day budget in two days.
+const (
+	defaultSLOTarget    = 30 * time.Second
+	defaultSLOObjective = 0.99
+	defaultSLOBurnRate  = 14.4
+	// sloMinEvents is how many events the short window needs before its
+	// burn rate is trusted, so a single slow event on a quiet chain does
+	// not raise a notice.
+	sloMinEvents = 10
+)
+
+// SLO burn rates are computed over a short and a long window; a notice is
+// raised when both burn too fast and cleared when the short one recovers.
+const (
+	sloShortWindow = 5 * time.Minute
+	sloLongWindow  = time.Hour
+)
+
+// latencyBuckets are the upper bounds of the latency histograms, in
+// seconds. Block timestamps have a one-second resolution.
+var latencyBuckets = []float64{0.5, 1, 2, 5, 10, 15, 30, 60, 120, 300, 600}
+
+// LatencySLO is the latency objective of the broadcast stage: Objective of
+// the events are broadcast within Target of their block timestamp.
+type LatencySLO struct {
+	Target    time.Duration
+	Objective float64
+	// BurnRate is how many times faster than sustainable the error budget
+	// must burn for a notice.
+	BurnRate float64
+}
+
+// LatencySLOFromEnv reads SLO_LATENCY_TARGET, SLO_LATENCY_OBJECTIVE (a
+// fraction below 1, e.g. 0.99) and SLO_BURN_RATE.
+func LatencySLOFromEnv() LatencySLO {
+	slo := LatencySLO{Target: envDuration("SLO_LATENCY_TARGET", defaultSLOTarget), Objective: defaultSLOObjective, BurnRate: defaultSLOBurnRate}
+	if v := os.Getenv("SLO_LATENCY_OBJECTIVE"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 && f < 1 {
+			slo.Objective = f
+		} else {
+			log.Warnf("invalid SLO_LATENCY_OBJECTIVE %q; using %g", v, defaultSLOObjective)
+		}
+	}
+	if v := os.Getenv("SLO_BURN_RATE"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+			slo.BurnRate = f
+		} else {
+			log.Warnf("invalid SLO_BURN_RATE %q; using %g", v, defaultSLOBurnRate)
+		}
+	}
+	return slo
+}
+
+// latencyKey identifies a histogram.
+type latencyKey struct{ chain, stage string }
+
+// latencyHistogram counts observations per bucket; the last count is
+// the +Inf bucket.
+type latencyHistogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// sloMinute counts the broadcasts of one minute that met the target and
+// those that did not.
+type sloMinute struct {
+	minute    int64
+	good, bad uint64
+}
+
+// LatencyTracker records how long events take from their block to each
+// stage, per chain, and watches the broadcast stage's SLO burn rate.
+// A nil tracker records nothing.
+type LatencyTracker struct {
+	slo LatencySLO
+	now func() time.Time
+
+	mu         sync.Mutex
+	histograms map[latencyKey]*latencyHistogram
+	// minutes is a ring of the last hour of broadcasts per chain
+	minutes  map[string][]sloMinute
+	alerting map[string]bool
+}
+
+// NewLatencyTracker creates a tracker for slo.
+func NewLatencyTracker(slo LatencySLO) *LatencyTracker {
+	return &LatencyTracker{
+		slo:        slo,
+		now:        time.Now,
+		histograms: make(map[latencyKey]*latencyHistogram),
+		minutes:    make(map[string][]sloMinute),
+		alerting:   make(map[string]bool),
+	}
+}
+
+// Observe records that an event of chain with the RFC3339 block timestamp
+// reached stage now. Events without a valid timestamp are not counted, and
+// blocks timestamped in the future, by clock skew, count as no latency.
+func (t *LatencyTracker) Observe(chain, stage, timestamp string) {
+	if t == nil || chain == "" {
+		return
+	}
+	block, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return
+	}
+	now := t.now()
+	latency := now.Sub(block)
+	if latency < 0 {
+		latency = 0
+	}
+	seconds := latency.Seconds()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := latencyKey{chain, stage}
+	h := t.histograms[key]
+	if h == nil {
+		h = &latencyHistogram{counts: make([]uint64, len(latencyBuckets)+1)}
+		t.histograms[key] = h
+	}
+	i := sort.SearchFloat64s(latencyBuckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+
+	if stage != StageBroadcast {
+		return
+	}
+	ring := t.minutes[chain]
+	if ring == nil {
+		ring = make([]sloMinute, int(sloLongWindow/time.Minute))
+		t.minutes[chain] = ring
+	}
+	minute := now.Unix() / 60
+	slot := &ring[minute%int64(len(ring))]
+	if slot.minute != minute {
+		*slot = sloMinute{minute: minute}
+	}
+	if latency <= t.slo.Target {
+		slot.good++
+	} else {
+		slot.bad++
+	}
+}
+
+// burnRate returns how many times faster than the objective allows the
+// error budget of chain burned over the window ending now, and how many
+// events the window saw. t.mu must be held.
+func (t *LatencyTracker) burnRate(chain string, window time.Duration, now time.Time) (float64, uint64) {
+	since := now.Unix()/60 - int64(window/time.Minute)
+	var good, bad uint64
+	for _, m := range t.minutes[chain] {
+		if m.minute > since {
+			good += m.good
+			bad += m.bad
+		}
+	}
+	total := good + bad
+	if total == 0 {
+		return 0, 0
+	}
+	return float64(bad) / float64(total) / (1 - t.slo.Objective), total
+}
+
+// Evaluate checks every chain's burn rate and emits slo.burn when both
+// windows burn at least the SLO's burn rate, and slo.recovered once the
+// short window no longer does.
+func (t *LatencyTracker) Evaluate(events *SystemEvents) {
+	if t == nil {
+		return
+	}
+	now := t.now()
+	var notices []SystemEvent
+	t.mu.Lock()
+	for chain := range t.minutes {
+		short, n := t.burnRate(chain, sloShortWindow, now)
+		long, _ := t.burnRate(chain, sloLongWindow, now)
+		burning := n >= sloMinEvents && short >= t.slo.BurnRate && long >= t.slo.BurnRate
+		recovered := t.alerting[chain] && short < t.slo.BurnRate
+		data := map[string]interface{}{
+			"burn_rate_5m": short, "burn_rate_1h": long,
+			"target_seconds": t.slo.Target.Seconds(), "objective": t.slo.Objective,
+		}
+		switch {
+		case burning && !t.alerting[chain]:
+			t.alerting[chain] = true
+			notices = append(notices, SystemEvent{Kind: SystemSLOBurn, Chain: chain, Data: data,
+				Message: fmt.Sprintf("events are broadcast later than %s too often: burning the latency error budget %.1fx too fast",
+					t.slo.Target, short),
+				// The notice stays active until the burn stops
+				ExpiresAt: now.Add(24 * time.Hour).UTC().Format(time.RFC3339)})
+		case recovered:
+			t.alerting[chain] = false
+			notices = append(notices, SystemEvent{Kind: SystemSLORecovered, Chain: chain, Data: data,
+				Message: "broadcast latency is back within its objective"})
+		}
+	}
+	t.mu.Unlock()
+	for _, notice := range notices {
+		if err := events.Emit(notice); err != nil {
+			log.WithError(err).WithField("chain", notice.Chain).Warn("failed to emit latency SLO notice")
+		}
+	}
+}
+
+// Run evaluates the burn rates every minute until ctx is done.
+func (t *LatencyTracker) Run(ctx context.Context, events *SystemEvents) {
+	if t == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Evaluate(events)
+		}
+	}
+}
+
+// WriteMetrics writes the latency histograms and burn rates in the
+// Prometheus text format.
+func (t *LatencyTracker) WriteMetrics(out io.Writer) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys := make([]latencyKey, 0, len(t.histograms))
+	for k := range t.histograms {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].chain != keys[j].chain {
+			return keys[i].chain < keys[j].chain
+		}
+		return keys[i].stage < keys[j].stage
+	})
+	const name = "tracker_event_latency_seconds"
+	fmt.Fprintf(out, "# HELP %s Time from an event's block timestamp to each stage: ingest, broadcast and webhook.\n# TYPE %s histogram\n", name, name)
+	for _, k := range keys {
+		h := t.histograms[k]
+		labels := fmt.Sprintf("chain=%q,stage=%q", k.chain, k.stage)
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(out, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(out, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(out, "%s_sum{%s} %g\n%s_count{%s} %d\n", name, labels, h.sum, name, labels, h.count)
+	}
+
+	chains := make([]string, 0, len(t.minutes))
+	for chain := range t.minutes {
+		chains = append(chains, chain)
+	}
+	sort.Strings(chains)
+	now := t.now()
+	fmt.Fprintf(out, "# HELP tracker_latency_slo_burn_rate How many times faster than the objective allows the broadcast latency error budget burns.\n"+
+		"# TYPE tracker_latency_slo_burn_rate gauge\n")
+	for _, chain := range chains {
+		short, _ := t.burnRate(chain, sloShortWindow, now)
+		long, _ := t.burnRate(chain, sloLongWindow, now)
+		fmt.Fprintf(out, "tracker_latency_slo_burn_rate{chain=%q,window=\"5m\"} %g\n", chain, short)
+		fmt.Fprintf(out, "tracker_latency_slo_burn_rate{chain=%q,window=\"1h\"} %g\n", chain, long)
+	}
+	fmt.Fprintf(out, "# HELP tracker_latency_slo_target_seconds Broadcast latency the SLO allows.\n# TYPE tracker_latency_slo_target_seconds gauge\n"+
+		"tracker_latency_slo_target_seconds %g\n", t.slo.Target.Seconds())
+	fmt.Fprintf(out, "# HELP tracker_latency_slo_objective Fraction of events the SLO wants broadcast within the target.\n"+
+		"# TYPE tracker_latency_slo_objective gauge\ntracker_latency_slo_objective %g\n", t.slo.Objective)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyTrackerHistograms(t *testing.T) {
+	now := time.Date(2025, 10, 14, 12, 0, 0, 0, time.UTC)
+	tracker := NewLatencyTracker(LatencySLO{Target: 30 * time.Second, Objective: 0.99, BurnRate: 14.4})
+	tracker.now = func() time.Time { return now }
+
+	tracker.Observe("ethereum", StageIngest, now.Add(-3*time.Second).Format(time.RFC3339))
+	tracker.Observe("ethereum", StageBroadcast, now.Add(-4*time.Second).Format(time.RFC3339))
+	// Skewed clocks count as no latency; missing timestamps are skipped
+	tracker.Observe("ethereum", StageBroadcast, now.Add(time.Minute).Format(time.RFC3339))
+	tracker.Observe("ethereum", StageBroadcast, "")
+
+	var out bytes.Buffer
+	tracker.WriteMetrics(&out)
+	for _, want := range []string{
+		`tracker_event_latency_seconds_bucket{chain="ethereum",stage="ingest",le="2"} 0`,
+		`tracker_event_latency_seconds_bucket{chain="ethereum",stage="ingest",le="5"} 1`,
+		`tracker_event_latency_seconds_bucket{chain="ethereum",stage="broadcast",le="0.5"} 1`,
+		`tracker_event_latency_seconds_sum{chain="ethereum",stage="broadcast"} 4`,
+		`tracker_event_latency_seconds_count{chain="ethereum",stage="broadcast"} 2`,
+		`tracker_latency_slo_burn_rate{chain="ethereum",window="5m"} 0`,
+		`tracker_latency_slo_target_seconds 30`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, out.String())
+		}
+	}
+
+	var nilTracker *LatencyTracker
+	nilTracker.Observe("ethereum", StageIngest, now.Format(time.RFC3339))
+	nilTracker.WriteMetrics(&out)
+}
+
+func TestLatencyTrackerBurnRateNotices(t *testing.T) {
+	hub, systemHub := NewHub(), NewHub()
+	go hub.Run()
+	go systemHub.Run()
+	events := NewSystemEvents(hub, systemHub, nil)
+
+	now := time.Date(2025, 10, 14, 12, 0, 0, 0, time.UTC)
+	tracker := NewLatencyTracker(LatencySLO{Target: 30 * time.Second, Objective: 0.99, BurnRate: 14.4})
+	tracker.now = func() time.Time { return now }
+	broadcast := func(n int, latency time.Duration) {
+		for i := 0; i < n; i++ {
+			tracker.Observe("solana", StageBroadcast, now.Add(-latency).Format(time.RFC3339))
+		}
+	}
+
+	// A few slow events are not enough to trust the burn rate
+	broadcast(sloMinEvents-1, time.Minute)
+	tracker.Evaluate(events)
+	if active := events.Active(now); len(active) != 0 {
+		t.Fatalf("expected no notice yet, got %+v", active)
+	}
+
+	broadcast(1, time.Minute)
+	tracker.Evaluate(events)
+	active := events.Active(now)
+	if len(active) != 1 || active[0].Kind != SystemSLOBurn || active[0].Chain != "solana" || active[0].Severity != SeverityCritical {
+		t.Fatalf("expected an slo.burn notice, got %+v", active)
+	}
+	// The notice is raised once while the burn lasts
+	tracker.Evaluate(events)
+	if n := len(events.Active(now)); n != 1 {
+		t.Fatalf("expected one active notice, got %d", n)
+	}
+
+	// Past the short window, fast events bring its burn rate down
+	now = now.Add(10 * time.Minute)
+	broadcast(50, time.Second)
+	tracker.Evaluate(events)
+	if active := events.Active(now); len(active) != 0 {
+		t.Fatalf("expected slo.recovered to clear the notice, got %+v", active)
+	}
+}
+
+func TestWebhookDeliveriesObserveLatency(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	tracker := NewLatencyTracker(LatencySLOFromEnv())
+	d := NewWebhookDispatcher(srv.URL, "", "")
+	d.AttachLatency(tracker)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	ts := time.Now().Add(-2 * time.Second).UTC().Format(time.RFC3339)
+	d.Notify(SystemEvent{ID: "a", Kind: SystemAlertTriggered, Chain: "base", Data: map[string]interface{}{"event_timestamp": ts}})
+	want := `tracker_event_latency_seconds_count{chain="base",stage="webhook"} 1`
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var out bytes.Buffer
+		tracker.WriteMetrics(&out)
+		if strings.Contains(out.String(), want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("webhook delivery was not timed:\n%s", out.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
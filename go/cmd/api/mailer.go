@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Mailer sends notification emails, such as the download links of finished
+// query jobs, through an SMTP relay.
+type Mailer struct {
+	addr string // host:port
+	auth smtp.Auth
+	from string
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewMailer reads SMTP_URL, smtp://[user:password@]host[:port] (port 587
+// by default), and SMTP_FROM, the sender address. It returns nil when
+// rawURL is empty. The relay's STARTTLS is used when it offers it.
+func NewMailer(rawURL, from string) (*Mailer, error) {
+	if strings.TrimSpace(rawURL) == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Scheme != "smtp" || u.Hostname() == "" {
+		return nil, errors.New("want smtp://[user:password@]host[:port]")
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("SMTP_FROM: %w", err)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "587"
+	}
+	m := &Mailer{addr: net.JoinHostPort(u.Hostname(), port), from: sender.Address, send: smtp.SendMail}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		m.auth = smtp.PlainAuth("", u.User.Username(), password, u.Hostname())
+	}
+	return m, nil
+}
+
+// Send emails a plain-text message to one recipient.
+func (m *Mailer) Send(to, subject, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return errors.New("header contains a line break")
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n", m.from, to, subject, time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return m.send(m.addr, m.auth, m.from, []string{to}, []byte(msg.String()))
+}
@@ -1,19 +1,24 @@
 // Package main contains the HTTP API for the Cross-Chain Transaction Tracker.
 //
-// It ingests normalized events from Redis Pub/Sub, optionally persists them to
-// Postgres for durability and idempotency, and exposes REST endpoints and an
-// SSE feed for clients to query or subscribe to live updates.
+// It ingests normalized events from Redis Pub/Sub or Kafka, optionally persists them to
+// Postgres, TimescaleDB or SQLite for durability and idempotency, and exposes REST endpoints
+// and an SSE feed for clients to query or subscribe to live updates.
 package main
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
 	"net/http"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -32,338 +37,760 @@ type Health struct {
 }
 
 // Token describes an ERC-20 or SPL token when the event pertains to a token
-// transfer. Fields are omitted if the event is a native transfer.
+// transfer. Fields are omitted if the event is a native transfer. Verified
+// is set in responses when the address is on a curated token list; the
+// symbol of an unverified token is whatever the contract claims.
 type Token struct {
 	Address  string `json:"address"`
 	Symbol   string `json:"symbol"`
 	Decimals uint8  `json:"decimals"`
+	Verified bool   `json:"verified"`
+	// Scam tags known scam tokens and tokens impersonating a listed symbol.
+	Scam bool `json:"scam,omitempty"`
 }
 
 // Event is the normalized, chain-agnostic representation of a transaction
 // event emitted by the listener and served by this API.
 type Event struct {
-	EventID   string  `json:"event_id"`
-	Chain     string  `json:"chain"`
-	Network   string  `json:"network"`
-	TxHash    string  `json:"tx_hash"`
-	Timestamp string  `json:"timestamp"`
-	From      string  `json:"from"`
-	To        string  `json:"to"`
-	Value     string  `json:"value"`
-	EventType string  `json:"event_type"`
-	Slot      *uint64 `json:"slot,omitempty"`
-	Token     *Token  `json:"token,omitempty"`
+	EventID string `json:"event_id"`
+	Chain   string `json:"chain"`
+	Network string `json:"network"`
+	// ChainID is the CAIP-2 identifier of Chain and Network, e.g.
+	// eip155:1, when the pair is known (see ChainID).
+	ChainID   string `json:"chain_id,omitempty"`
+	TxHash    string `json:"tx_hash"`
+	Timestamp string `json:"timestamp"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	FromLabel string `json:"from_label,omitempty"`
+	ToLabel   string `json:"to_label,omitempty"`
+	Value     string `json:"value"`
+	// ValueDecimal is Value in whole units of the asset, e.g. "1.5" for a
+	// Value of 1500000 USDC. It is only set in responses, and only when the
+	// asset's decimals are known.
+	ValueDecimal string  `json:"value_decimal,omitempty"`
+	EventType    string  `json:"event_type"`
+	BlockNumber  *uint64 `json:"block_number,omitempty"`
+	Slot         *uint64 `json:"slot,omitempty"`
+	Status       string  `json:"status,omitempty"`
+	Token        *Token  `json:"token,omitempty"`
+	// Fee is what the transaction cost in the chain's native smallest unit
+	// (wei, lamports), paid by ExecutedBy when set and by From otherwise.
+	// GasUsed is the gas (EVM) or compute units (Solana) it consumed, and
+	// PriorityFee the part of Fee paid as a tip above the base fee. Every
+	// event of a transaction repeats them.
+	Fee         string  `json:"fee,omitempty"`
+	GasUsed     *uint64 `json:"gas_used,omitempty"`
+	PriorityFee string  `json:"priority_fee,omitempty"`
+	// Nonce is the account nonce of an EVM transaction, sent by ExecutedBy
+	// when set and by From otherwise. Every event of a transaction repeats
+	// it.
+	Nonce *uint64 `json:"nonce,omitempty"`
+	// LogIndex is the position of the transfer in its transaction: the log
+	// index of an EVM token transfer, the instruction index (in execution
+	// order, inner instructions included) of a Solana one. With the tx hash
+	// it identifies the transfer whatever event_id a source gave it.
+	LogIndex *uint64 `json:"log_index,omitempty"`
+
+	// ExecutedBy is the account that submitted a transfer on behalf of
+	// From: the executing member when From is a multisig of kind Multisig
+	// (gnosis_safe or squads), or the bundler when From is an ERC-4337
+	// smart account.
+	ExecutedBy string `json:"executed_by,omitempty"`
+	Multisig   string `json:"multisig,omitempty"`
+	// Authority is set on Solana token transfers signed by a
+	// program-derived address, naming the program that controls it.
+	Authority *Authority `json:"authority,omitempty"`
+	// Bridge names the protocol (wormhole, layerzero or cctp) of the
+	// cross-chain message the transaction sent or received. Both legs of a
+	// bridge transfer share Bridge, SourceChain and Sequence; DestChain is
+	// empty when the sending side does not record it.
+	Bridge      string `json:"bridge,omitempty"`
+	SourceChain string `json:"source_chain,omitempty"`
+	DestChain   string `json:"dest_chain,omitempty"`
+	Sequence    string `json:"sequence,omitempty"`
+	// Tenant owns the event in multi-tenant deployments; only requests
+	// with one of the tenant's API keys, or of a tenant in SharedWith,
+	// see it. A transfer between wallets of two tenants is owned by one
+	// and shared with the other.
+	Tenant     string   `json:"tenant,omitempty"`
+	SharedWith []string `json:"shared_with,omitempty"`
+	// Annotations holds the values an external enrichment service attached
+	// to the event at ingest, such as risk scores or model labels, by name.
+	Annotations map[string]json.RawMessage `json:"annotations,omitempty"`
+	// Provenance is how the tracker got the event: empty when it was
+	// ingested from the event source, ProvenanceImported when it was
+	// fetched afterwards by a history import.
+	Provenance string `json:"provenance,omitempty"`
+	// Source names the producer of the event, such as the listener build
+	// that indexed it (indexer-ethereum@v0.1.0) or the API a history import
+	// read (import:etherscan), so conflicting copies can be traced. It is
+	// kept from the first copy stored.
+	Source string `json:"source,omitempty"`
+	// Supersedes is the event_id of the version an amendment corrects,
+	// such as a transfer stored with the wrong decimals; SupersededBy is
+	// set on that version once the amendment is stored. Listings leave
+	// superseded versions out unless asked for the history.
+	Supersedes   string `json:"supersedes,omitempty"`
+	SupersededBy string `json:"superseded_by,omitempty"`
+	// Explorer links the transaction and addresses on the network's block
+	// explorer. It is only set in responses.
+	Explorer *ExplorerLinks `json:"explorer,omitempty"`
+
+	// profile is the response profile the event is marshaled with; empty
+	// marshals every field.
+	profile string
+}
+
+// ProvenanceImported marks events a history import fetched from an RPC
+// provider or explorer API.
+const ProvenanceImported = "imported"
+
+// Authority is the program-derived address that signed a token transfer
+// and the program that signed for it.
+type Authority struct {
+	Address string `json:"address"`
+	Program string `json:"program"`
 }
 
 // EventFilter holds filter, sort, and pagination parameters for list queries.
 type EventFilter struct {
-	Chain     string
-	Token     string
-	From      string
-	To        string
-	MinValue  float64
+	Chain   string
+	Network string
+	Token   string
+	// Tokens matches events in any of these token symbols.
+	Tokens []string
+	From   string
+	To     string
+	// MinValue and MaxValue bound the value in whole units of each
+	// event's asset; nil means no bound.
+	MinValue *big.Rat
+	MaxValue *big.Rat
+	Status   string
+	// Bridge and Sequence select the legs of bridge transfers.
+	Bridge   string
+	Sequence string
+	// Source matches the event's producer exactly or, when it ends in *,
+	// by prefix ignoring case (see sourcePattern).
+	Source string
+	// Tenant restricts results to the events a tenant owns or shares;
+	// empty sees all.
+	Tenant string
+	// Search matches events whose tx hash contains the fragment or whose
+	// addresses, token symbol or token address start with it, ignoring
+	// case (see searchSQL).
+	Search string
+	// StartTime and EndTime bound the event timestamp, inclusively.
+	// Events whose timestamp is not RFC3339 never match a bound.
 	StartTime *time.Time
 	EndTime   *time.Time
+	// SortBy is one of the sortColumns, newest first when empty, and
+	// SortOrder "asc" or "desc" (the default).
 	SortBy    string
 	SortOrder string
 	Limit     int
 	Offset    int
+	// IncludeHistory lists superseded versions of amended events too.
+	IncludeHistory bool
+	// IncludeTotal asks a listing to count every matching event for
+	// X-Total-Count; repositories ignore it.
+	IncludeTotal bool
+}
+
+// Sort fields of event listings.
+const (
+	SortTimestamp   = "timestamp"
+	SortValue       = "value"
+	SortBlockNumber = "block_number"
+)
+
+// sortColumns are the SQL expressions the sort fields order by. value sorts
+// by the amount in whole units, so assets with different decimals compare
+// correctly; block_number falls back to the Solana slot.
+var sortColumns = map[string]string{
+	SortTimestamp:   "timestamp",
+	SortValue:       "amount",
+	SortBlockNumber: "COALESCE(block_number, slot)",
+}
+
+// sqliteSortColumns are sortColumns for SQLite, which keeps amounts as
+// amountKey text.
+var sqliteSortColumns = map[string]string{
+	SortTimestamp:   "timestamp",
+	SortValue:       "amount_key",
+	SortBlockNumber: "COALESCE(block_number, slot)",
+}
+
+// orderSQL renders the ORDER BY clause of a listing: the requested sort
+// column from columns with events lacking the field last, then newest, the
+// default order.
+func (f EventFilter) orderSQL(columns map[string]string, newest string) string {
+	column, ok := columns[f.SortBy]
+	if !ok {
+		return " ORDER BY " + newest
+	}
+	dir := "DESC"
+	if f.SortOrder == "asc" {
+		dir = "ASC"
+	}
+	return " ORDER BY " + column + " " + dir + " NULLS LAST, " + newest
+}
+
+// sortEvents orders an in-memory listing like orderSQL, keeping the
+// newest-first order among equal events.
+func sortEvents(events []*Event, f EventFilter) {
+	// key returns the sort field of an event as a comparable number, and
+	// false when the event lacks it
+	var key func(ev *Event) (*big.Rat, bool)
+	switch f.SortBy {
+	case SortTimestamp:
+		key = func(ev *Event) (*big.Rat, bool) {
+			ts, err := time.Parse(time.RFC3339, ev.Timestamp)
+			if err != nil {
+				return nil, false
+			}
+			return new(big.Rat).SetInt64(ts.UnixNano()), true
+		}
+	case SortValue:
+		key = func(ev *Event) (*big.Rat, bool) {
+			amount, ok := eventAmount(ev)
+			if !ok {
+				return nil, false
+			}
+			return amount.Whole(), true
+		}
+	case SortBlockNumber:
+		key = func(ev *Event) (*big.Rat, bool) {
+			height := ev.BlockNumber
+			if height == nil {
+				height = ev.Slot
+			}
+			if height == nil {
+				return nil, false
+			}
+			return new(big.Rat).SetInt(new(big.Int).SetUint64(*height)), true
+		}
+	default:
+		return
+	}
+	desc := f.SortOrder != "asc"
+	sort.SliceStable(events, func(i, j int) bool {
+		a, okA := key(events[i])
+		b, okB := key(events[j])
+		if okA != okB || !okA {
+			return okA && !okB
+		}
+		if desc {
+			return a.Cmp(b) > 0
+		}
+		return a.Cmp(b) < 0
+	})
+}
+
+// Matches reports whether a single event satisfies the filter's predicates.
+// Sorting and pagination fields are ignored.
+func (f EventFilter) Matches(event *Event) bool {
+	if f.Chain != "" && event.Chain != f.Chain {
+		return false
+	}
+	if f.Network != "" && event.Network != f.Network {
+		return false
+	}
+	if !tenantSees(f.Tenant, event) {
+		return false
+	}
+	if f.Bridge != "" && event.Bridge != f.Bridge {
+		return false
+	}
+	if f.Sequence != "" && event.Sequence != f.Sequence {
+		return false
+	}
+	if f.Source != "" && !matchesSource(event.Source, f.Source) {
+		return false
+	}
+	if f.Token != "" && (event.Token == nil || event.Token.Symbol != f.Token) {
+		return false
+	}
+	if len(f.Tokens) > 0 && (event.Token == nil || !containsToken(f.Tokens, event.Token.Symbol)) {
+		return false
+	}
+	if f.From != "" && addressKey(event.From) != addressKey(f.From) {
+		return false
+	}
+	if f.To != "" && addressKey(event.To) != addressKey(f.To) {
+		return false
+	}
+	if f.Search != "" && !matchesSearch(event, f.Search) {
+		return false
+	}
+	// Events whose amount is unknown are not filtered out, as in SQL
+	if f.MinValue != nil || f.MaxValue != nil {
+		if amount, ok := eventAmount(event); ok && (f.MinValue != nil && !amount.AtLeast(f.MinValue) ||
+			f.MaxValue != nil && !amount.AtMost(f.MaxValue)) {
+			return false
+		}
+	}
+	if f.StartTime != nil || f.EndTime != nil {
+		ts, err := time.Parse(time.RFC3339, event.Timestamp)
+		if err != nil || f.StartTime != nil && ts.Before(*f.StartTime) || f.EndTime != nil && ts.After(*f.EndTime) {
+			return false
+		}
+	}
+	if event.SupersededBy != "" && !f.IncludeHistory {
+		return false
+	}
+	// Orphaned events are dropped unless explicitly requested
+	if f.Status != "" {
+		if event.Status != f.Status {
+			return false
+		}
+	} else if event.Status == StatusOrphaned {
+		return false
+	}
+	return true
+}
+
+// containsToken reports whether symbol is one of tokens.
+func containsToken(tokens []string, symbol string) bool {
+	for _, t := range tokens {
+		if t == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// sourcePattern returns the LIKE pattern of a source filter ending in *,
+// which matches sources by prefix ignoring case.
+func sourcePattern(filter string) (string, bool) {
+	if !strings.HasSuffix(filter, "*") {
+		return "", false
+	}
+	_, prefix := searchPatterns(strings.TrimSuffix(filter, "*"))
+	return prefix, true
+}
+
+// matchesSource is the source filter of sqlWhere for the in-memory backend.
+func matchesSource(source, filter string) bool {
+	if prefix, ok := strings.CutSuffix(filter, "*"); ok {
+		return hasFold(source, prefix, false)
+	}
+	return source == filter
 }
 
+// sqlWhere renders the filter's predicates as " AND ..." clauses whose
+// numbered placeholders use prefix ("$" for Postgres, "?" for SQLite) and
+// start at idx. Orphaned events are excluded unless a status is
+// requested, and superseded ones unless the history is.
+func (f EventFilter) sqlWhere(prefix string, idx int) (string, []interface{}) {
+	var q string
+	var args []interface{}
+	add := func(clause string, arg interface{}) {
+		q += fmt.Sprintf(clause, prefix, idx+len(args))
+		args = append(args, arg)
+	}
+	if f.Chain != "" {
+		add(" AND chain = %s%d", f.Chain)
+	}
+	if f.Network != "" {
+		add(" AND network = %s%d", f.Network)
+	}
+	if f.Tenant != "" {
+		add(" AND "+tenantSQL(prefix), f.Tenant)
+	}
+	if f.Bridge != "" {
+		add(" AND bridge = %s%d", f.Bridge)
+	}
+	if f.Sequence != "" {
+		add(" AND bridge_sequence = %s%d", f.Sequence)
+	}
+	if pattern, ok := sourcePattern(f.Source); ok {
+		add(` AND LOWER(source) LIKE %s%d ESCAPE '\'`, pattern)
+	} else if f.Source != "" {
+		add(" AND source = %s%d", f.Source)
+	}
+	if f.Token != "" {
+		add(" AND token_symbol = %s%d", f.Token)
+	}
+	if len(f.Tokens) > 0 {
+		ph := make([]string, len(f.Tokens))
+		for i, t := range f.Tokens {
+			ph[i] = fmt.Sprintf("%s%d", prefix, idx+len(args))
+			args = append(args, t)
+		}
+		q += " AND token_symbol IN (" + strings.Join(ph, ", ") + ")"
+	}
+	if f.From != "" {
+		add(" AND "+addressColumn("from_addr", f.From)+" = %s%d", addressKey(f.From))
+	}
+	if f.To != "" {
+		add(" AND "+addressColumn("to_addr", f.To)+" = %s%d", addressKey(f.To))
+	}
+	if f.Search != "" {
+		contains, start := searchPatterns(f.Search)
+		n := idx + len(args)
+		q += fmt.Sprintf(searchSQL, prefix, n, prefix, n+1)
+		args = append(args, contains, start)
+	}
+	// SQLite compares amountKey text, which is exact where its NUMERIC
+	// is a double
+	amount, bound := "amount", func(r *big.Rat) interface{} { return r.FloatString(minValuePrecision) }
+	if prefix == "?" {
+		amount, bound = "amount_key", func(r *big.Rat) interface{} { return amountKey(r) }
+	}
+	if f.MinValue != nil {
+		add(" AND ("+amount+" IS NULL OR "+amount+" >= %s%d)", bound(f.MinValue))
+	}
+	if f.MaxValue != nil {
+		add(" AND ("+amount+" IS NULL OR "+amount+" <= %s%d)", bound(f.MaxValue))
+	}
+	if f.StartTime != nil || f.EndTime != nil {
+		// Postgres compares as timestamptz, guarding the cast against rows
+		// that are not RFC3339; SQLite's julianday is NULL for those
+		ts, bound := `(CASE WHEN timestamp ~ '^\d{4}-\d{2}-\d{2}T' THEN timestamp::timestamptz END)`, "%s%d"
+		arg := func(t time.Time) interface{} { return t }
+		if prefix == "?" {
+			ts, bound = "julianday(timestamp)", "julianday(%s%d)"
+			arg = func(t time.Time) interface{} { return t.UTC().Format(time.RFC3339Nano) }
+		}
+		if f.StartTime != nil {
+			add(" AND "+ts+" >= "+bound, arg(*f.StartTime))
+		}
+		if f.EndTime != nil {
+			add(" AND "+ts+" <= "+bound, arg(*f.EndTime))
+		}
+	}
+	if f.Status != "" {
+		add(" AND status = %s%d", f.Status)
+	} else {
+		q += fmt.Sprintf(" AND status <> '%s'", StatusOrphaned)
+	}
+	if !f.IncludeHistory {
+		q += " AND superseded_by = ''"
+	}
+	return q, args
+}
+
+// walletWhere renders a predicate matching events from or to any of
+// addresses, with numbered placeholders that use prefix and start at idx.
+// Solana and Tron addresses match exactly, other addresses
+// case-insensitively, as addressKey compares them.
+func walletWhere(prefix string, idx int, addresses []string) (string, []interface{}) {
+	var lower, exact []interface{}
+	for _, a := range addresses {
+		if isCaseSensitiveAddress(a) {
+			exact = append(exact, a)
+		} else {
+			lower = append(lower, addressKey(a))
+		}
+	}
+	var clauses []string
+	var args []interface{}
+	in := func(list []interface{}, columns ...string) {
+		if len(list) == 0 {
+			return
+		}
+		ph := make([]string, len(list))
+		for i := range ph {
+			ph[i] = fmt.Sprintf("%s%d", prefix, idx+len(args)+i)
+		}
+		for _, c := range columns {
+			clauses = append(clauses, c+" IN ("+strings.Join(ph, ", ")+")")
+		}
+		args = append(args, list...)
+	}
+	in(lower, "LOWER(from_addr)", "LOWER(to_addr)")
+	in(exact, "from_addr", "to_addr")
+	if len(clauses) == 0 {
+		return "1=0", nil
+	}
+	return "(" + strings.Join(clauses, " OR ") + ")", args
+}
+
+// listingWhere is the WHERE clause of a listing for addresses (every event
+// when empty) and filter, with arguments starting at 1.
+func listingWhere(prefix string, addresses []string, filter EventFilter) (string, []interface{}) {
+	q := "1=1"
+	var args []interface{}
+	if len(addresses) > 0 {
+		var wallets string
+		wallets, args = walletWhere(prefix, 1, addresses)
+		q += " AND " + wallets
+	}
+	where, whereArgs := filter.sqlWhere(prefix, len(args)+1)
+	return q + where, append(args, whereArgs...)
+}
+
+// EventStore is the API's view of event storage: a bounded in-memory cache
+// that serves live traffic, in front of an optional EventRepository for
+// durable storage.
 type EventStore struct {
-	mu                 sync.RWMutex
-	events             []*Event
-	eventsByWallet     map[string][]*Event
-	maxTotalEvents     int
-	maxEventsPerWallet int
-	db                 *pgxpool.Pool
+	cache     *MemoryRepository
+	repo      EventRepository
+	batch     *BatchWriter
+	retry     *PersistRetry
+	labels    *LabelStore
+	tokens    *TokenList
+	explorers *Explorers
+	responses *ResponseCache
+	// correlations holds operators' verdicts on bridge leg pairings.
+	correlations *CorrelationStore
+	// routes holds the expected latency and fees of bridge routes.
+	routes *BridgeRouteRegistry
+	// prices values bridge fees in USD.
+	prices *PriceTable
+	// pricesAtBlock values high-value transfers at their block's prices.
+	pricesAtBlock *PriceResolver
+	// history imports the past transfers of wallets never seen.
+	history *HistoryImporter
+	// reorgs records the reorgs confirmation updates applied.
+	reorgs *ReorgLedger
+	// diffs keeps the results compared by POST /admin/diff.
+	diffs *ResultDiffs
 }
 
 // NewEventStore constructs an in-memory store with soft limits for total
-// events and per-wallet history. It can be augmented with a Postgres backend
-// via AttachDB for durability.
+// events and per-wallet history. It can be augmented with a durable backend
+// via AttachRepository.
 func NewEventStore(maxTotalEvents, maxEventsPerWallet int) *EventStore {
 	return &EventStore{
-		events:             make([]*Event, 0),
-		eventsByWallet:     make(map[string][]*Event),
-		maxTotalEvents:     maxTotalEvents,
-		maxEventsPerWallet: maxEventsPerWallet,
+		cache:        NewMemoryRepository(maxTotalEvents, maxEventsPerWallet),
+		tokens:       NewTokenList(),
+		explorers:    NewExplorers(),
+		correlations: NewCorrelationStore(),
+		routes:       NewBridgeRouteRegistry(),
+		reorgs:       NewReorgLedger(),
+		diffs:        NewResultDiffs(),
 	}
 }
 
-// AttachDB connects the store to a Postgres pool to persist events and serve
-// queries from durable storage.
-func (s *EventStore) AttachDB(db *pgxpool.Pool) {
-	s.db = db
+// AttachRepository makes repo the source of truth for queries and the target
+// of Persist.
+func (s *EventStore) AttachRepository(repo EventRepository) {
+	s.repo = repo
 }
 
-// Add inserts an event into the in-memory indexes. Addresses are normalized to
-// lowercase for case-insensitive lookups. Oldest entries are trimmed when
-// limits are exceeded.
-func (s *EventStore) Add(event *Event) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Normalize addresses to lowercase for case-insensitive lookups
-	event.From = strings.ToLower(event.From)
-	event.To = strings.ToLower(event.To)
+// AttachBatchWriter makes Persist queue events on w instead of inserting
+// them one at a time. Persist then blocks while w's buffer is full.
+func (s *EventStore) AttachBatchWriter(w *BatchWriter) {
+	s.batch = w
+	w.AttachRetry(s.retry)
+}
 
-	// Add to global list and trim
-	s.events = append([]*Event{event}, s.events...)
-	if len(s.events) > s.maxTotalEvents {
-		s.events = s.events[:s.maxTotalEvents]
+// AttachPersistRetry retries transient write errors with r and routes
+// events the database rejects to its dead-letter queue.
+func (s *EventStore) AttachPersistRetry(r *PersistRetry) {
+	s.retry = r
+	if s.batch != nil {
+		s.batch.AttachRetry(r)
 	}
+}
 
-	// Add to "from" wallet and trim
-	fromEvents := s.eventsByWallet[event.From]
-	fromEvents = append([]*Event{event}, fromEvents...)
-	if len(fromEvents) > s.maxEventsPerWallet {
-		fromEvents = fromEvents[:s.maxEventsPerWallet]
-	}
-	s.eventsByWallet[event.From] = fromEvents
+// AttachHistory makes wallet history requests import the history of
+// wallets the tracker has never seen.
+func (s *EventStore) AttachHistory(h *HistoryImporter) {
+	s.history = h
+}
+
+// AttachPrices sets the USD prices bridge fees are valued with.
+func (s *EventStore) AttachPrices(prices *PriceTable) {
+	s.prices = prices
+}
+
+// AttachPriceResolver values high-value transfers at the prices at their
+// block as they are ingested.
+func (s *EventStore) AttachPriceResolver(r *PriceResolver) {
+	s.pricesAtBlock = r
+}
+
+// queuePrice queues a stored high-value transfer to be annotated with the
+// price at its block.
+func (s *EventStore) queuePrice(ev *Event) {
+	s.pricesAtBlock.Queue(s.prices, ev)
+}
 
-	// Add to "to" wallet and trim
-	toEvents := s.eventsByWallet[event.To]
-	toEvents = append([]*Event{event}, toEvents...)
-	if len(toEvents) > s.maxEventsPerWallet {
-		toEvents = toEvents[:s.maxEventsPerWallet]
+// AttachLabels enables from_label/to_label enrichment of API responses.
+func (s *EventStore) AttachLabels(labels *LabelStore) {
+	s.labels = labels
+}
+
+// AttachTokens replaces the built-in token list used to verify token
+// symbols in responses.
+func (s *EventStore) AttachTokens(tokens *TokenList) {
+	s.tokens = tokens
+}
+
+// AttachResponseCache caches event listings in c.
+func (s *EventStore) AttachResponseCache(c *ResponseCache) {
+	s.responses = c
+}
+
+// AttachExplorers replaces the built-in block explorers used for explorer
+// links in responses.
+func (s *EventStore) AttachExplorers(explorers *Explorers) {
+	s.explorers = explorers
+}
+
+// Enrich prepares events for a response: labels are filled in, tokens are
+// checked against the token list and explorer links are added. Stored
+// events are never modified.
+func (s *EventStore) Enrich(events []*Event) []*Event {
+	out := make([]*Event, len(events))
+	for i, ev := range events {
+		out[i] = s.EnrichOne(ev)
 	}
-	s.eventsByWallet[event.To] = toEvents
+	return out
 }
 
-type Hub struct {
-	clients    map[chan []byte]struct{}
-	register   chan chan []byte
-	unregister chan chan []byte
-	broadcast  chan []byte
-	mu         sync.Mutex
+// EnrichOne is Enrich for a single event.
+func (s *EventStore) EnrichOne(ev *Event) *Event {
+	return decimalizeOne(s.explorers.LinkOne(s.tokens.ResolveOne(s.labels.EnrichOne(ev))))
 }
 
-func (s *EventStore) GetByWallet(address string, filter EventFilter) []*Event {
-	// If DB is attached, read from DB for persistence/idempotency
-	if s.db != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+// Add inserts an event into the in-memory cache.
+func (s *EventStore) Add(event *Event) {
+	_ = s.cache.Insert(context.Background(), event)
+}
 
-		// Build simple query ordering by created_at desc (server-side timestamp)
-		// We intentionally keep filtering minimal to avoid complexity.
-		q := `
-			SELECT event_id, chain, network, tx_hash, timestamp, from_addr, to_addr, value, event_type, slot,
-				   token_address, token_symbol, token_decimals
-			FROM events
-			WHERE (LOWER(from_addr) = $1 OR LOWER(to_addr) = $1)
-		`
-		args := []interface{}{strings.ToLower(address)}
-		idx := 2
-		if filter.Chain != "" {
-			q += fmt.Sprintf(" AND chain = $%d", idx)
-			args = append(args, filter.Chain)
-			idx++
-		}
-		if filter.Token != "" {
-			q += fmt.Sprintf(" AND token_symbol = $%d", idx)
-			args = append(args, filter.Token)
-			idx++
-		}
-		if filter.From != "" {
-			q += fmt.Sprintf(" AND LOWER(from_addr) = $%d", idx)
-			args = append(args, strings.ToLower(filter.From))
-			idx++
-		}
-		if filter.To != "" {
-			q += fmt.Sprintf(" AND LOWER(to_addr) = $%d", idx)
-			args = append(args, strings.ToLower(filter.To))
-			idx++
-		}
-		// Order and paginate using created_at for stability
-		q += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d OFFSET $%d", idx, idx+1)
-		if filter.Limit == 0 {
-			filter.Limit = 50
-		}
-		args = append(args, filter.Limit, filter.Offset)
-
-		rows, err := s.db.Query(ctx, q, args...)
-		if err != nil {
-			log.WithError(err).Warn("db query failed; falling back to in-memory")
-		} else {
-			defer rows.Close()
-			out := make([]*Event, 0)
-			for rows.Next() {
-				var ev Event
-				var slot *int64
-				var tokAddr, tokSym *string
-				var tokDec *int32
-				if err := rows.Scan(&ev.EventID, &ev.Chain, &ev.Network, &ev.TxHash, &ev.Timestamp,
-					&ev.From, &ev.To, &ev.Value, &ev.EventType, &slot, &tokAddr, &tokSym, &tokDec); err != nil {
-					log.WithError(err).Warn("db scan failed")
-					continue
-				}
-				if slot != nil {
-					// G115: Safe conversion - values from DB are already validated
-					if *slot < 0 {
-						log.Warnf("negative slot value in DB: %d", *slot)
-						continue
-					}
-					s := uint64(*slot)
-					ev.Slot = &s
-				}
-				if tokAddr != nil || tokSym != nil || tokDec != nil {
-					ev.Token = &Token{Address: getOrEmpty(tokAddr), Symbol: getOrEmpty(tokSym)}
-					if tokDec != nil {
-						// G115: Safe conversion - decimals validated at persistence
-						if *tokDec < 0 || *tokDec > 255 {
-							log.Warnf("invalid token decimals in DB: %d", *tokDec)
-						} else {
-							ev.Token.Decimals = uint8(*tokDec)
-						}
-					}
-				}
-				out = append(out, &ev)
+// Persist stores an event in the attached repository, if any (idempotent on
+// event_id). With a batch writer attached the event is only queued.
+// Transient errors are retried; an event the database rejects is
+// dead-lettered, and the *PersistError returned says which happened.
+func (s *EventStore) Persist(ctx context.Context, event *Event) error {
+	if s.repo == nil {
+		return nil
+	}
+	if s.batch != nil {
+		return s.batch.Add(ctx, event)
+	}
+	err := s.retry.Do(ctx, func(ctx context.Context) error { return s.repo.Insert(ctx, event) })
+	if err != nil {
+		s.retry.DeadLetter(ctx, event, err)
+	}
+	return err
+}
+
+// UpdateMetadata stores what mergeDuplicate filled in on ev in the
+// repository and the cache. Queued writes are flushed first, so the update
+// cannot precede the insert it changes.
+func (s *EventStore) UpdateMetadata(ctx context.Context, ev *Event) error {
+	if s.repo != nil {
+		if s.batch != nil {
+			if err := s.batch.Flush(ctx); err != nil {
+				return err
 			}
-			return out
+		}
+		if err := s.repo.UpdateMetadata(ctx, ev); err != nil {
+			return err
 		}
 	}
+	_ = s.cache.UpdateMetadata(ctx, ev)
+	s.responses.Invalidate(ctx, ev)
+	return nil
+}
 
-	// Fallback: in-memory filtering (legacy)
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// Checkpoint waits until the events persisted since it was taken are
+// stored, failing if any of them could not be written.
+type Checkpoint func(ctx context.Context) error
 
-	var filteredEvents []*Event
-	for _, event := range s.eventsByWallet[address] {
-		if filter.Chain != "" && event.Chain != filter.Chain {
-			continue
-		}
-		if filter.Token != "" && (event.Token == nil || event.Token.Symbol != filter.Token) {
-			continue
-		}
-		if filter.From != "" && event.From != filter.From {
-			continue
-		}
-		if filter.To != "" && event.To != filter.To {
-			continue
+// Checkpoint returns a Checkpoint covering events persisted from now on.
+// Without a batch writer Persist already reports write errors, so it only
+// has to wait for buffered events.
+func (s *EventStore) Checkpoint() Checkpoint {
+	if s.batch == nil {
+		return func(context.Context) error { return nil }
+	}
+	failed := s.batch.Failed()
+	return func(ctx context.Context) error {
+		if err := s.batch.Flush(ctx); err != nil {
+			return err
 		}
-		if filter.MinValue > 0 {
-			if val, err := strconv.ParseFloat(event.Value, 64); err == nil {
-				if val < filter.MinValue {
-					continue
-				}
-			}
+		if s.batch.Failed() != failed {
+			return errors.New("events failed to persist")
 		}
-		filteredEvents = append(filteredEvents, event)
+		return nil
 	}
+}
 
-	if filter.Offset >= len(filteredEvents) {
-		return []*Event{}
-	}
-	end := filter.Offset + filter.Limit
-	if end > len(filteredEvents) {
-		end = len(filteredEvents)
+// read runs a query against the repository, falling back to the cache when
+// none is attached or the query fails.
+func (s *EventStore) read(query func(ctx context.Context, r EventRepository) ([]*Event, error)) []*Event {
+	if s.repo != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		events, err := query(ctx, s.repo)
+		if err == nil {
+			return events
+		}
+		log.WithError(err).Warn("repository query failed; falling back to in-memory")
 	}
-	return filteredEvents[filter.Offset:end]
+	events, _ := query(context.Background(), s.cache)
+	return events
+}
+
+// Hub fans frames out to live subscribers. Frames sent on broadcast reach
+// every unscoped subscriber; frames published for a tenant also reach that
+// tenant's subscribers. Wallet subscribers are indexed by address, so a
+// frame only visits the watchers of the addresses it concerns.
+type Hub struct {
+	clients map[chan Frame]string // subscriber -> tenant, "" for unscoped
+	// wallets maps an addressKey to its watchers and their tenant, and
+	// walletOf a watcher back to its address.
+	wallets    map[string]map[chan Frame]string
+	walletOf   map[chan Frame]string
+	register   chan chan Frame
+	unregister chan chan Frame
+	broadcast  chan []byte
+	publish    chan Frame
+	queue      *hubQueue     // event frames, see PublishEvent
+	probe      chan struct{} // received by Run between frames; see Watchdog
+	mu         sync.Mutex
+	lastID     uint64
+	replay     *replayBuffer
+}
+
+func (s *EventStore) GetByWallet(address string, filter EventFilter) []*Event {
+	return s.read(func(ctx context.Context, r EventRepository) ([]*Event, error) {
+		return r.ByWallet(ctx, address, filter)
+	})
 }
 
 func (s *EventStore) GetRecent(filter EventFilter) []*Event {
-	if s.db != nil {
+	return s.read(func(ctx context.Context, r EventRepository) ([]*Event, error) {
+		return r.Recent(ctx, filter)
+	})
+}
+
+// GetByID looks up a single event by its event_id, preferring the repository
+// when attached. Orphaned events are returned too; callers decide whether to
+// hide them.
+func (s *EventStore) GetByID(eventID string) (*Event, bool) {
+	if s.repo != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-
-		q := `
-			SELECT event_id, chain, network, tx_hash, timestamp, from_addr, to_addr, value, event_type, slot,
-				   token_address, token_symbol, token_decimals
-			FROM events
-			WHERE 1=1
-		`
-		args := []interface{}{}
-		idx := 1
-		if filter.Chain != "" {
-			q += fmt.Sprintf(" AND chain = $%d", idx)
-			args = append(args, filter.Chain)
-			idx++
-		}
-		if filter.Token != "" {
-			q += fmt.Sprintf(" AND token_symbol = $%d", idx)
-			args = append(args, filter.Token)
-			idx++
-		}
-		if filter.From != "" {
-			q += fmt.Sprintf(" AND LOWER(from_addr) = $%d", idx)
-			args = append(args, strings.ToLower(filter.From))
-			idx++
-		}
-		if filter.To != "" {
-			q += fmt.Sprintf(" AND LOWER(to_addr) = $%d", idx)
-			args = append(args, strings.ToLower(filter.To))
-			idx++
-		}
-		// Order by created_at desc for recency
-		q += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d OFFSET $%d", idx, idx+1)
-		if filter.Limit == 0 {
-			filter.Limit = 50
-		}
-		args = append(args, filter.Limit, filter.Offset)
-
-		rows, err := s.db.Query(ctx, q, args...)
+		ev, ok, err := s.repo.ByID(ctx, eventID)
 		if err == nil {
-			defer rows.Close()
-			out := make([]*Event, 0)
-			for rows.Next() {
-				var ev Event
-				var slot *int64
-				var tokAddr, tokSym *string
-				var tokDec *int32
-				if err := rows.Scan(&ev.EventID, &ev.Chain, &ev.Network, &ev.TxHash, &ev.Timestamp,
-					&ev.From, &ev.To, &ev.Value, &ev.EventType, &slot, &tokAddr, &tokSym, &tokDec); err != nil {
-					log.WithError(err).Warn("db scan failed")
-					continue
-				}
-				if slot != nil {
-					// G115: Safe conversion - values from DB are already validated
-					if *slot < 0 {
-						log.Warnf("negative slot value in DB: %d", *slot)
-						continue
-					}
-					s := uint64(*slot)
-					ev.Slot = &s
-				}
-				if tokAddr != nil || tokSym != nil || tokDec != nil {
-					ev.Token = &Token{Address: getOrEmpty(tokAddr), Symbol: getOrEmpty(tokSym)}
-					if tokDec != nil {
-						// G115: Safe conversion - decimals validated at persistence
-						if *tokDec < 0 || *tokDec > 255 {
-							log.Warnf("invalid token decimals in DB: %d", *tokDec)
-						} else {
-							ev.Token.Decimals = uint8(*tokDec)
-						}
-					}
-				}
-				out = append(out, &ev)
-			}
-			return out
+			return ev, ok
 		}
+		log.WithError(err).Warn("repository query failed; falling back to in-memory")
 	}
-
-	// Fallback in-memory
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	filteredEvents := make([]*Event, len(s.events))
-	copy(filteredEvents, s.events)
-	if filter.Offset >= len(filteredEvents) {
-		return []*Event{}
-	}
-	end := filter.Offset + filter.Limit
-	if end > len(filteredEvents) {
-		end = len(filteredEvents)
-	}
-	return filteredEvents[filter.Offset:end]
+	ev, ok, _ := s.cache.ByID(context.Background(), eventID)
+	return ev, ok
 }
 
-// NewHub creates a simple in-process broadcaster for Server-Sent Events.
 func NewHub() *Hub {
 	return &Hub{
-		clients:    make(map[chan []byte]struct{}),
-		register:   make(chan chan []byte),
-		unregister: make(chan chan []byte),
+		clients:    make(map[chan Frame]string),
+		wallets:    make(map[string]map[chan Frame]string),
+		walletOf:   make(map[chan Frame]string),
+		register:   make(chan chan Frame),
+		unregister: make(chan chan Frame),
 		broadcast:  make(chan []byte),
+		publish:    make(chan Frame),
+		probe:      make(chan struct{}),
+		queue:      newHubQueue(defaultHubQueueSize, OverflowDropOldest),
+		lastID:     initialFrameID(),
+		replay:     newReplayBuffer(defaultReplayBufferSize),
 	}
 }
 
@@ -373,85 +800,170 @@ func (h *Hub) Run() {
 		select {
 		case client := <-h.register:
 			h.mu.Lock()
-			h.clients[client] = struct{}{}
+			h.clients[client] = ""
 			h.mu.Unlock()
 			log.Info("client registered")
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client)
+			if h.dropLocked(client) {
 				log.Info("client unregistered")
 			}
 			h.mu.Unlock()
 		case message := <-h.broadcast:
-			h.mu.Lock()
-			for client := range h.clients {
-				select {
-				case client <- message:
-				default:
-					close(client)
-					delete(h.clients, client)
-				}
+			h.deliver(Frame{Data: message})
+		case frame := <-h.publish:
+			h.deliver(frame)
+		case <-h.queue.ready:
+			for _, frame := range h.queue.take() {
+				h.deliver(frame)
 			}
-			h.mu.Unlock()
+		case <-h.probe:
 		}
 	}
 }
 
-// healthHandler returns a simple JSON health status.
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(Health{Status: "OK"})
-}
-
-// subscribeToEvents consumes the Redis Pub/Sub channel and forwards events to
-// the in-memory store, the optional database, and the SSE hub.
-func subscribeToEvents(ctx context.Context, redisURL string, store *EventStore, hub *Hub) {
-	opt, err := redis.ParseURL(redisURL)
-	if err != nil {
-		log.Fatalf("could not parse redis url: %v", err)
+// Publish sends data to the unscoped subscribers and, when tenant is set,
+// to that tenant's subscribers.
+func (h *Hub) Publish(tenant string, data []byte) {
+	var tenants []string
+	if tenant != "" {
+		tenants = []string{tenant}
 	}
+	h.PublishTo(tenants, data)
+}
 
-	rdb := redis.NewClient(opt)
-	pubsub := rdb.Subscribe(ctx, "cross_chain_events")
-	defer pubsub.Close()
+// PublishTo sends data to the unscoped subscribers and to the subscribers
+// of each of tenants.
+func (h *Hub) PublishTo(tenants []string, data []byte) {
+	h.publish <- Frame{Data: data, Tenants: tenants}
+}
 
-	ch := pubsub.Channel()
+// PublishEvent sends data about ev to the subscribers that see ev and to
+// the watchers of its sender and recipient. It never blocks: the frame is
+// queued for Run, replacing a frame for the same event that is still
+// waiting, so a slow hub cannot hold up ingestion. Event frames are
+// encoded in every payload shape here, once for all subscribers.
+func (h *Hub) PublishEvent(ev *Event, data []byte) {
+	h.queue.push(ev.EventID, Frame{Data: data, Tenants: eventTenants(ev), Addresses: eventAddressKeys(ev), Shapes: payloadShapes(data)})
+}
 
-	log.Info("subscribing to cross_chain_events")
+// join registers a client that only receives the frames of tenant.
+func (h *Hub) join(client chan Frame, tenant string) {
+	h.mu.Lock()
+	h.clients[client] = tenant
+	h.mu.Unlock()
+	log.WithField("tenant", tenant).Info("client registered")
+}
 
-	for msg := range ch {
-		var event Event
-		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
-			log.WithError(err).Error("could not unmarshal event")
-			continue
+// deliver numbers a frame, buffers it for replay and sends it to the
+// subscribers that may see it, dropping those that fell behind.
+func (h *Hub) deliver(frame Frame) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastID++
+	frame.ID, frame.At = h.lastID, time.Now()
+	h.replay.add(frame)
+	for client, tenant := range h.clients {
+		h.sendLocked(client, tenant, frame)
+	}
+	for _, address := range frame.Addresses {
+		for client, tenant := range h.wallets[address] {
+			h.sendLocked(client, tenant, frame)
 		}
-		log.Infof("received event: %+v", event)
+	}
+}
 
-		// Attempt to persist to DB first (idempotent on event_id)
-		if store.db != nil {
-			if err := persistEvent(ctx, store.db, &event); err != nil {
-				log.WithError(err).Warn("failed to persist event to db")
-			}
-		}
+// sendLocked sends frame to client if its tenant may see it, dropping the
+// client when it fell behind. Callers hold the lock.
+func (h *Hub) sendLocked(client chan Frame, tenant string, frame Frame) {
+	if !frame.visibleTo(tenant) {
+		return
+	}
+	select {
+	case client <- frame:
+	default:
+		h.dropLocked(client)
+	}
+}
 
-		// Always add to in-memory cache for SSE and fast reads
-		store.Add(&event)
-		hub.broadcast <- []byte(msg.Payload)
+// dropLocked unregisters and closes client, reporting whether it was
+// registered. Callers hold the lock.
+func (h *Hub) dropLocked(client chan Frame) bool {
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+	} else if address, ok := h.walletOf[client]; ok {
+		delete(h.walletOf, client)
+		delete(h.wallets[address], client)
+		if len(h.wallets[address]) == 0 {
+			delete(h.wallets, address)
+		}
+	} else {
+		return false
 	}
+	close(client)
+	return true
+}
+
+// healthHandler returns a simple JSON health status.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(Health{Status: "OK"})
 }
 
-// serveSSE upgrades an HTTP connection to a Server-Sent Events stream.
+// serveSSE upgrades an HTTP connection to a Server-Sent Events stream. Clients
+// reconnecting with a Last-Event-ID header (or ?since=) first receive the
+// buffered frames they missed.
+// Requests scoped to a tenant only receive that tenant's frames.
 func serveSSE(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	streamSSE(hub, w, r, "", nil, nil)
+}
+
+// streamSSE serves a hub as an SSE stream, with event frames in the
+// payload shape the client picked. When wallet is set, the client only
+// receives the frames concerning that addressKey. Preamble messages
+// are written without an ID once the client is registered and before live
+// frames. When keep is set, only the frames it accepts are written.
+func streamSSE(hub *Hub, w http.ResponseWriter, r *http.Request, wallet string, preamble [][]byte, keep func(Frame) bool) {
+	shape, err := parsePayloadShape(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	messageChan := make(chan []byte)
-	hub.register <- messageChan
+	messageChan := make(chan Frame, sseClientBuffer)
+	cursor, resume := parseReplayCursor(r)
+	tenant := tenantFrom(r.Context())
+	switch {
+	case wallet != "":
+		for _, frame := range hub.watch(messageChan, tenant, wallet, cursor, resume) {
+			if keep == nil || keep(frame) {
+				writeSSEFrame(w, frame, shape)
+			}
+		}
+	case resume:
+		for _, frame := range hub.subscribe(messageChan, tenant, cursor) {
+			if keep == nil || keep(frame) {
+				writeSSEFrame(w, frame, shape)
+			}
+		}
+	case tenant != "":
+		hub.join(messageChan, tenant)
+	default:
+		hub.register <- messageChan
+	}
+	for _, data := range preamble {
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	if len(preamble) > 0 {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
 	defer func() {
 		hub.unregister <- messageChan
 	}()
@@ -464,13 +976,12 @@ func serveSSE(hub *Hub, w http.ResponseWriter, r *http.Request) {
 
 	for {
 		select {
-		case message, ok := <-messageChan:
+		case frame, ok := <-messageChan:
 			if !ok {
 				return
 			}
-			fmt.Fprintf(w, "data: %s\n\n", message)
-			if f, ok := w.(http.Flusher); ok {
-				f.Flush()
+			if keep == nil || keep(frame) {
+				writeSSEFrame(w, frame, shape)
 			}
 		case <-time.After(30 * time.Second): // Keep-alive
 			fmt.Fprintf(w, ": keep-alive\n\n")
@@ -482,153 +993,793 @@ func serveSSE(hub *Hub, w http.ResponseWriter, r *http.Request) {
 }
 
 // getWalletTransactions returns a wallet's event history with basic filters.
+// An address with no events at all is reported as 404, while a filter that
+// matches none of a known wallet's events yields an empty page.
 func getWalletTransactions(store *EventStore, w http.ResponseWriter, r *http.Request) {
-	address := strings.ToLower(chi.URLParam(r, "address"))
-
-	filter := EventFilter{
-		Limit:  50,
-		Offset: 0,
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	address, err := pathAddress(r, filter.Chain)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	profile, err := parseProfile(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	sample, err := parseSample(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if sample > 0 {
+		writeSample(store, w, []string{address}, filter, sample, profile)
+		return
 	}
 
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if limit, err := strconv.Atoi(limitStr); err == nil {
-			filter.Limit = limit
+	store.responses.Serve(w, r, walletScope(address), filter, profile, func(w http.ResponseWriter) {
+		load := func() eventPage {
+			return store.Page([]string{address}, filter, func(f EventFilter) []*Event { return store.GetByWallet(address, f) })
 		}
-	}
-	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
-		if offset, err := strconv.Atoi(offsetStr); err == nil {
-			filter.Offset = offset
+		page := load()
+		if len(page.events) == 0 && len(store.GetByWallet(address, EventFilter{Tenant: filter.Tenant, Limit: 1})) == 0 {
+			// A wallet never seen gets its recent history imported; the
+			// request waits a little for it before asking to come back
+			imports := store.history.ImportUnseen(address, filter.Chain, filter.Network)
+			if len(imports) > 0 {
+				imports = store.history.Wait(r.Context(), imports, store.history.cfg.Wait)
+				if !historyFinished(imports) {
+					w.Header().Set("Retry-After", "2")
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusAccepted)
+					_ = json.NewEncoder(w).Encode(imports)
+					return
+				}
+				page = load()
+			}
+		}
+		if len(page.events) == 0 && len(store.GetByWallet(address, EventFilter{Tenant: filter.Tenant, Limit: 1})) == 0 {
+			httpError(w, "no events for address "+address, http.StatusNotFound)
+			return
 		}
+		setPaginationHeaders(w, r, filter, page)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(withProfile(profile, store.Enrich(page.events)))
+	})
+}
+
+// getTransactions returns recent events across all wallets with filters.
+func getTransactions(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	profile, err := parseProfile(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	sample, err := parseSample(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if sample > 0 {
+		writeSample(store, w, nil, filter, sample, profile)
+		return
 	}
 
-	filter.Chain = r.URL.Query().Get("chain")
-	filter.Token = r.URL.Query().Get("token")
-	filter.From = r.URL.Query().Get("from")
-	filter.To = r.URL.Query().Get("to")
+	store.responses.Serve(w, r, recentScope(filter.Tenant), filter, profile, func(w http.ResponseWriter) {
+		page := store.Page(nil, filter, store.GetRecent)
+		setPaginationHeaders(w, r, filter, page)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(withProfile(profile, store.Enrich(page.events)))
+	})
+}
 
-	if minValueStr := r.URL.Query().Get("min_value"); minValueStr != "" {
-		if minValue, err := strconv.ParseFloat(minValueStr, 64); err == nil {
-			filter.MinValue = minValue
+// main bootstraps the API server, wiring Redis, optional Postgres, routes, and
+// conservative HTTP server timeouts.
+func main() {
+	log.SetFormatter(&log.JSONFormatter{})
+	// `api doctor` checks the deployment and exits instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(runDoctor(context.Background(), os.Stdout))
+	}
+	// `api sdk` writes the TypeScript client tarball and exits
+	if len(os.Args) > 1 && os.Args[1] == "sdk" {
+		os.Exit(runSDK(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	log.Info("starting api server")
+	// Cold-start phase timings, served at /status; a start over its
+	// optional time or memory budget keeps the API not ready
+	startup := NewStartupTracker(time.Now())
+	configured := startup.Begin(PhaseConfig)
+
+	// Optional config file, filling in the settings the environment does
+	// not set; watchlists and rate limits reload without a restart
+	var configFile *ConfigFile
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		cf, err := LoadConfigFile(path)
+		if err != nil {
+			log.Fatalf("invalid CONFIG_FILE: %v", err)
 		}
+		configFile = cf
+		log.WithField("path", path).Info("api: config file loaded")
 	}
+	startup.SetBudget(envDuration("STARTUP_BUDGET", 0), uint64(envInt("STARTUP_MAX_HEAP_MB", 0))<<20)
 
-	if startTimeStr := r.URL.Query().Get("start_time"); startTimeStr != "" {
-		if startTime, err := time.Parse(time.RFC3339, startTimeStr); err == nil {
-			filter.StartTime = &startTime
-		}
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		log.Fatalf("REDIS_URL must be set")
 	}
 
-	if endTimeStr := r.URL.Query().Get("end_time"); endTimeStr != "" {
-		if endTime, err := time.Parse(time.RFC3339, endTimeStr); err == nil {
-			filter.EndTime = &endTime
+	store := NewEventStore(envInt("CACHE_MAX_EVENTS", maxEvents), envInt("CACHE_MAX_EVENTS_PER_WALLET", maxEventsPerWallet))
+	// Optional age and memory bounds on the events held in memory, for
+	// ephemeral deployments running on the in-memory store alone
+	store.cache.SetBudget(envDuration("CACHE_EVENT_TTL", 0), int64(envInt("CACHE_MAX_MEMORY_MB", 0))<<20)
+	labels := NewLabelStore()
+	store.AttachLabels(labels)
+	views := NewViewStore()
+	customMetrics := NewCustomMetrics()
+	treasuries := NewTreasuryPolicies()
+	// Alerts escalate through the tenants' policies, to Slack and then
+	// PagerDuty, until acknowledged
+	escalator := NewEscalator(os.Getenv("PAGERDUTY_EVENTS_URL"))
+	owners := NewWalletOwners()
+	// Owners of verified wallets may hide their labels from other tenants
+	labels.AttachOwners(owners)
+	// Addresses linked across chains as one identity, viewed together
+	identities := NewIdentities()
+	// Optional tenant-uploaded WASM plugins run on every ingested event
+	var plugins *Plugins
+	if os.Getenv("WASM_PLUGINS") == "true" {
+		plugins = NewPlugins(envInt("PLUGIN_MEMORY_MB", defaultPluginMemoryMB), envDuration("PLUGIN_TIMEOUT", defaultPluginTimeout))
+	}
+	if sources := os.Getenv("TOKEN_LISTS"); sources != "" {
+		tokens := NewTokenList()
+		if n, err := tokens.LoadSources(context.Background(), sources); err != nil {
+			log.WithError(err).Warn("failed to load token lists; only built-in tokens are verified")
+		} else {
+			log.WithField("tokens", n).Info("api: token lists loaded")
 		}
+		store.AttachTokens(tokens)
 	}
+	if sources := os.Getenv("SCAM_TOKEN_LISTS"); sources != "" {
+		if n, err := store.tokens.LoadScamSources(context.Background(), sources); err != nil {
+			log.WithError(err).Warn("failed to load scam token lists")
+		} else {
+			log.WithField("tokens", n).Info("api: scam token lists loaded")
+		}
+	}
+	if spec := os.Getenv("EXPLORER_URLS"); spec != "" {
+		explorers, err := ParseExplorerURLs(spec)
+		if err != nil {
+			log.Fatalf("invalid EXPLORER_URLS: %v", err)
+		}
+		store.AttachExplorers(explorers)
+	}
+	// Chains to ingest and their RPC endpoints, changeable at runtime. Both
+	// may name pairs by CAIP-2 chain id, so the ids are read first.
+	chainIDs, err := ParseChainIDs(os.Getenv("CHAIN_IDS"))
+	if err != nil {
+		log.Fatalf("invalid CHAIN_IDS: %v", err)
+	}
+	SetChainIDs(chainIDs)
+	networks, err := ParseNetworkAllowlist(os.Getenv("NETWORKS"))
+	if err != nil {
+		log.Fatalf("invalid NETWORKS: %v", err)
+	}
+	endpoints, err := ParseRPCURLs(os.Getenv("RPC_URLS"))
+	if err != nil {
+		log.Fatalf("invalid RPC_URLS: %v", err)
+	}
+	chains := NewChainRegistry(networks, endpoints)
+	prices, err := ParsePrices(os.Getenv("USD_PRICES"))
+	if err != nil {
+		log.Fatalf("invalid USD_PRICES: %v", err)
+	}
+	store.AttachPrices(prices)
+	priceFeeds, err := ParsePriceFeeds(os.Getenv("PRICE_FEEDS"))
+	if err != nil {
+		log.Fatalf("invalid PRICE_FEEDS: %v", err)
+	}
+	priceAtBlockMinUSD := big.NewRat(defaultPriceAtBlockMinUSD, 1)
+	if v := os.Getenv("PRICE_AT_BLOCK_MIN_USD"); v != "" {
+		threshold, ok := new(big.Rat).SetString(v)
+		if !ok || threshold.Sign() < 0 {
+			log.Fatalf("invalid PRICE_AT_BLOCK_MIN_USD %q", v)
+		}
+		priceAtBlockMinUSD = threshold
+	}
+	// Optional tenants: API keys and the wallets each tenant watches
+	tenants, err := ParseTenants(os.Getenv("TENANT_API_KEYS"), os.Getenv("TENANT_WALLETS"))
+	if err != nil {
+		log.Fatalf("invalid tenants: %v", err)
+	}
+	// Daily digests of each tenant's largest transfers, new sanctioned
+	// counterparties, stuck bridge transfers and indexer incidents
+	reports := NewDailyReports(store, labels, tenants, envInt("DAILY_REPORT_TOP", defaultReportTopTransfers))
+	// Live raw transactions on the detail endpoints, from the fastest healthy
+	// RPC provider of the chain
+	var rawTxCacheClient rawTxCache
+	if opt, err := redis.ParseURL(redisURL); err == nil {
+		rawTxCacheClient = redisRawTxCache{redis.NewClient(opt)}
+	}
+	rpc := NewRPCManager(chains, envDuration("RPC_PROBE_INTERVAL", defaultRPCProbeInterval))
+	go rpc.Run(context.Background())
+	// High-value transfers are valued at the Chainlink prices at their block
+	store.AttachPriceResolver(NewPriceResolver(priceFeeds, priceAtBlockMinUSD, rpc, envDuration("PRICE_AT_BLOCK_TIMEOUT", defaultPriceAtBlockTimeout)))
+	rawTx := NewRawTxFetcher(rpc, rawTxCacheClient, envDuration("RAW_TX_CACHE_TTL", defaultRawTxCacheTTL))
+	// Optional read-through cache of event listings in Redis
+	if ttl := envDuration("RESPONSE_CACHE_TTL", 0); ttl > 0 {
+		if opt, err := redis.ParseURL(redisURL); err == nil {
+			store.AttachResponseCache(NewResponseCache(redisResponseCacheBackend{redis.NewClient(opt)}, ttl))
+			// Entries cached by a previous run may carry labels or token
+			// lists that have since changed
+			store.responses.InvalidateAll(context.Background())
+			log.WithField("ttl", ttl).Info("api: response cache enabled")
+		}
+	}
+	// Transient write errors are retried; events the database rejects are
+	// kept on a Redis dead-letter list
+	var deadLetters deadLetterQueue
+	if opt, err := redis.ParseURL(redisURL); err == nil {
+		deadLetters = redisDeadLetterQueue{redis.NewClient(opt)}
+	}
+	persistRetry := NewPersistRetry(envInt("PERSIST_RETRY_ATTEMPTS", defaultPersistAttempts),
+		envDuration("PERSIST_RETRY_BACKOFF", defaultPersistBackoff), deadLetters)
+	store.AttachPersistRetry(persistRetry)
+	// Optional durable backend (Postgres, Timescale, partitioned Postgres or
+	// SQLite)
+	var batch *BatchWriter
+	repoCfg := RepositoryConfigFromEnv()
+	configured()
+	stored := startup.Begin(PhaseStorage)
+	repo, err := OpenRepository(context.Background(), repoCfg)
+	stored()
+	hydrated := startup.Begin(PhaseHydrate)
+	if err != nil {
+		log.WithError(err).WithField("backend", repoCfg.Backend).Warn("failed to open storage backend; running in memory-only mode")
+	} else if repo != nil {
+		store.AttachRepository(repo)
+		if pg, ok := repo.(interface{ Pool() *pgxpool.Pool }); ok {
+			if err := labels.AttachDB(context.Background(), pg.Pool()); err != nil {
+				log.WithError(err).Warn("failed to load labels; labels are memory-only")
+			}
+			if err := chains.AttachDB(context.Background(), pg.Pool()); err != nil {
+				log.WithError(err).Warn("failed to load chains; chain changes are memory-only")
+			}
+			if err := views.AttachDB(context.Background(), pg.Pool()); err != nil {
+				log.WithError(err).Warn("failed to load views; saved views are memory-only")
+			}
+			if err := customMetrics.AttachDB(context.Background(), pg.Pool()); err != nil {
+				log.WithError(err).Warn("failed to load custom metrics; custom metrics are memory-only")
+			}
+			if err := treasuries.AttachDB(context.Background(), pg.Pool()); err != nil {
+				log.WithError(err).Warn("failed to load treasury policies; treasury policies are memory-only")
+			}
+			if err := escalator.AttachDB(context.Background(), pg.Pool()); err != nil {
+				log.WithError(err).Warn("failed to load escalation policies; escalation policies are memory-only")
+			}
+			if err := owners.AttachDB(context.Background(), pg.Pool()); err != nil {
+				log.WithError(err).Warn("failed to load wallet ownership; verified wallets are memory-only")
+			}
+			if err := identities.AttachDB(context.Background(), pg.Pool()); err != nil {
+				log.WithError(err).Warn("failed to load identities; identities are memory-only")
+			}
+			if err := reports.AttachDB(context.Background(), pg.Pool()); err != nil {
+				log.WithError(err).Warn("failed to attach daily reports; daily reports are memory-only")
+			}
+			if err := store.correlations.AttachDB(context.Background(), pg.Pool()); err != nil {
+				log.WithError(err).Warn("failed to load correlation overrides; overrides are memory-only")
+			}
+			if err := store.routes.AttachDB(context.Background(), pg.Pool()); err != nil {
+				log.WithError(err).Warn("failed to load bridge routes; route changes are memory-only")
+			}
+			if err := store.reorgs.AttachDB(context.Background(), pg.Pool()); err != nil {
+				log.WithError(err).Warn("failed to load the reorg ledger; reorgs are memory-only")
+			}
+			if plugins != nil {
+				if err := plugins.AttachDB(context.Background(), pg.Pool()); err != nil {
+					log.WithError(err).Warn("failed to load plugins; plugins are memory-only")
+				}
+			}
+		}
+		batch = NewBatchWriter(repo, envInt("BATCH_INSERT_SIZE", defaultBatchSize),
+			envDuration("BATCH_INSERT_INTERVAL", defaultBatchInterval), envInt("BATCH_INSERT_BUFFER", defaultBatchBuffer))
+		store.AttachBatchWriter(batch)
+		log.WithField("backend", repoCfg.Backend).Info("api: storage backend ready")
+	}
+	hydrated()
+	// Optional second backend POST /admin/diff compares results against,
+	// such as the target of a migration. It is only read, never migrated
+	if diffCfg := repositoryConfigFromEnv("DIFF_"); diffCfg.Backend != BackendMemory {
+		if diffRepo, err := OpenReadOnlyRepository(context.Background(), diffCfg); err != nil {
+			log.WithError(err).WithField("backend", diffCfg.Backend).Warn("failed to open the diff backend; diffs against it are disabled")
+		} else {
+			store.diffs.AttachSecondary(diffRepo, diffCfg.Backend)
+			defer diffRepo.Close()
+			log.WithField("backend", diffCfg.Backend).Info("api: diff backend ready")
+		}
+	}
+	dedup := NewDeduplicator(store)
+	hub := NewHub()
+	if sizeStr := os.Getenv("SSE_REPLAY_BUFFER"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size > 0 {
+			hub.SetReplayBufferSize(size)
+		}
+	}
+	overflow, err := ParseOverflowPolicy(os.Getenv("HUB_QUEUE_OVERFLOW"))
+	if err != nil {
+		log.Fatalf("invalid HUB_QUEUE_OVERFLOW: %v", err)
+	}
+	hub.SetQueue(envInt("HUB_QUEUE_SIZE", defaultHubQueueSize), overflow)
+	go hub.Run()
 
-	events := store.GetByWallet(address, filter)
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(events)
-}
+	enricher := NewEnricher(os.Getenv("ENRICHMENT_URL"), envDuration("ENRICHMENT_TIMEOUT", defaultEnrichmentTimeout))
+	if enricher != nil {
+		log.Info("api: event enrichment callback enabled")
+	}
 
-// getTransactions returns recent events across all wallets with filters.
-func getTransactions(store *EventStore, w http.ResponseWriter, r *http.Request) {
-	filter := EventFilter{
-		Limit:  50,
-		Offset: 0,
+	source, err := EventSourceFromEnv(redisURL, chains, store)
+	if err != nil {
+		log.Fatalf("invalid event source: %v", err)
 	}
 
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if limit, err := strconv.Atoi(limitStr); err == nil {
-			filter.Limit = limit
+	// Stop consuming on SIGINT/SIGTERM so buffered events can be flushed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// End-to-end latency per chain, from block timestamp to broadcast and
+	// webhook acknowledgement, with an SLO on the broadcast stage
+	latency := NewLatencyTracker(LatencySLOFromEnv())
+	// The watchdog marks the API not ready when the hubs or the ingest
+	// handler stop making progress
+	watchdog := NewWatchdog(envDuration("WATCHDOG_THRESHOLD", defaultWatchdogThreshold))
+	watchdog.WatchHub("hub", hub)
+	// Optional mapping rules rewriting other producers' envelopes into the
+	// listener's before events are decoded
+	var mappings *IngestMappings
+	if path := os.Getenv("INGEST_MAPPINGS_FILE"); path != "" {
+		if mappings, err = LoadIngestMappings(path); err != nil {
+			log.Fatalf("invalid INGEST_MAPPINGS_FILE: %v", err)
 		}
+		log.WithFields(log.Fields{"path": path, "mappings": len(mappings.Mappings)}).Info("api: ingest mappings loaded")
 	}
-	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
-		if offset, err := strconv.Atoi(offsetStr); err == nil {
-			filter.Offset = offset
-		}
+	handle := watchdog.WatchHandler("ingest", mappings.Handler(ingestEvents(store, hub, chains, dedup, tenants, enricher, plugins, customMetrics, treasuries, latency)))
+	go consumeEvents(withSubscribed(ctx, startup.Begin(PhaseSubscribe)), source, handle)
+	go customMetrics.Run(ctx)
+	go store.cache.RunExpiry(ctx)
+	go store.pricesAtBlock.Run(ctx, store, envInt("PRICE_AT_BLOCK_WORKERS", defaultPriceAtBlockWorkers))
+
+	// Optional deployment key signing webhook bodies and exports, published
+	// at /.well-known/tracker-key
+	signer, err := ParseSigningKey(os.Getenv("SIGNING_KEY"))
+	if err != nil {
+		log.Fatalf("invalid SIGNING_KEY: %v", err)
+	}
+	if signer != nil {
+		log.WithField("key_id", signer.KeyID()).Info("api: signing webhooks and exports")
 	}
 
-	filter.Chain = r.URL.Query().Get("chain")
-	filter.Token = r.URL.Query().Get("token")
-	filter.From = r.URL.Query().Get("from")
-	filter.To = r.URL.Query().Get("to")
-
-	if minValueStr := r.URL.Query().Get("min_value"); minValueStr != "" {
-		if minValue, err := strconv.ParseFloat(minValueStr, 64); err == nil {
-			filter.MinValue = minValue
+	// System events (watchlist, backfill, indexer, alert and maintenance
+	// notices) get their own stream, are mirrored on the live stream and are
+	// optionally pushed to webhooks
+	systemHub := NewHub()
+	go systemHub.Run()
+	watchdog.WatchHub("system_hub", systemHub)
+	go watchdog.Run(ctx)
+	webhooks := NewWebhookDispatcher(os.Getenv("WEBHOOK_URLS"), os.Getenv("WEBHOOK_SECRET"), os.Getenv("WEBHOOK_EVENTS"))
+	if webhooks != nil {
+		webhooks.AttachLatency(latency)
+		webhooks.AttachSigner(signer)
+		go webhooks.Run(context.Background())
+	}
+	systemEvents := NewSystemEvents(hub, systemHub, webhooks)
+	treasuries.AttachAlerts(systemEvents)
+	systemEvents.AttachEscalator(escalator)
+	systemEvents.AttachReports(reports)
+	go escalator.Run(ctx)
+	go latency.Run(ctx, systemEvents)
+	go subscribeToSystemEvents(context.Background(), redisURL, systemEvents)
+	go subscribeToConfirmations(context.Background(), redisURL, store, hub, systemEvents)
+
+	// Optional checks of watched wallets for transactions the listener
+	// missed, which are reported and queued for backfill
+	sequenceWallets, err := ParseSequenceWallets(os.Getenv("SEQUENCE_WALLETS"))
+	if err != nil {
+		log.Fatalf("invalid SEQUENCE_WALLETS: %v", err)
+	}
+	// The tracker runs without wallets too, so a config reload can add them
+	var sequences *SequenceTracker
+	if opt, err := redis.ParseURL(redisURL); err == nil {
+		sequences = NewSequenceTracker(store, rpc, redisBackfillQueue{redis.NewClient(opt)}, systemEvents, sequenceWallets,
+			envDuration("SEQUENCE_CHECK_INTERVAL", defaultSequenceInterval))
+		go sequences.Run(ctx)
+		if len(sequenceWallets) > 0 {
+			log.WithField("wallets", len(sequenceWallets)).Info("api: sequence tracking enabled")
 		}
 	}
 
-	if startTimeStr := r.URL.Query().Get("start_time"); startTimeStr != "" {
-		if startTime, err := time.Parse(time.RFC3339, startTimeStr); err == nil {
-			filter.StartTime = &startTime
+	// Optional imports of the recent history of wallets the tracker has
+	// never seen, when they are queried or added to a watchlist
+	var history *HistoryImporter
+	if os.Getenv("HISTORY_IMPORT") == "true" {
+		history = NewHistoryImporter(store, chains, rpc, tenants, dedup, systemEvents, HistoryConfigFromEnv())
+		// Etherscan-family explorer APIs, used instead of RPC providers on
+		// the networks they are configured for
+		explorers, err := ParseExplorerAPIs(os.Getenv("EXPLORER_API_URLS"), os.Getenv("EXPLORER_API_KEYS"),
+			float64(envInt("EXPLORER_API_RPS", defaultExplorerRPS)))
+		if err != nil {
+			log.Fatalf("invalid explorer APIs: %v", err)
 		}
+		history.AttachExplorers(explorers)
+		store.AttachHistory(history)
+		history.ImportWatched(tenants, sequenceWallets)
+		log.Info("api: history imports enabled")
 	}
 
-	if endTimeStr := r.URL.Query().Get("end_time"); endTimeStr != "" {
-		if endTime, err := time.Parse(time.RFC3339, endTimeStr); err == nil {
-			filter.EndTime = &endTime
+	// Optional retention job expiring aged events per chain and network,
+	// exporting them to S3-compatible storage first when a bucket is set
+	var retention *RetentionJob
+	if spec := os.Getenv("RETENTION_DAYS"); spec != "" {
+		policies, err := ParseRetentionPolicies(spec)
+		if err != nil {
+			log.Fatalf("invalid RETENTION_DAYS: %v", err)
 		}
+		archive, err := NewS3Archive(ArchiveConfigFromEnv())
+		if err != nil {
+			log.Fatalf("invalid archive settings: %v", err)
+		}
+		target, ok := store.repo.(RetentionStore)
+		if !ok {
+			log.Fatalf("RETENTION_DAYS needs the postgres, timescale or partitioned backend, not %s", backendName(store.repo))
+		}
+		retention = NewRetentionJob(store, target, policies, archive, envDuration("RETENTION_INTERVAL", defaultRetentionInterval))
+		retention.AttachSigner(signer)
+		go retention.Run(ctx)
+		log.WithFields(log.Fields{"policies": spec, "archive": archive.String()}).Info("api: retention enabled")
 	}
 
-	filter.SortBy = r.URL.Query().Get("sort_by")
-	filter.SortOrder = r.URL.Query().Get("sort_order")
+	// Optional per-client rate limits, off unless RATE_LIMIT_RPS is set
+	rate, burst, err := rateLimitFromEnv()
+	if err != nil {
+		log.Fatalf("invalid rate limits: %v", err)
+	}
+	limiter := NewRateLimiter(rate, burst)
+	if configFile != nil {
+		go configFile.Watch(ctx, envDuration("CONFIG_POLL_INTERVAL", defaultConfigPollInterval), applyConfig(tenants, sequences, history, limiter))
+	}
 
-	events := store.GetRecent(filter)
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(events)
-}
+	// Optional gRPC server for internal consumers
+	if grpcAddr := os.Getenv("GRPC_BIND_ADDR"); grpcAddr != "" {
+		go func() {
+			if err := serveGRPC(grpcAddr, store, hub, tenants); err != nil {
+				log.WithError(err).Error("grpc server stopped")
+			}
+		}()
+	}
 
-// main bootstraps the API server, wiring Redis, optional Postgres, routes, and
-// conservative HTTP server timeouts.
-func main() {
-	log.SetFormatter(&log.JSONFormatter{})
-	log.Info("starting api server")
+	queryJobs := NewQueryJobs(store, envInt("QUERY_JOB_WORKERS", 2),
+		envDuration("QUERY_JOB_TIMEOUT", 10*time.Minute), envDuration("QUERY_RESULT_TTL", time.Hour))
+	go queryJobs.Run(context.Background())
 
-	redisURL := os.Getenv("REDIS_URL")
-	if redisURL == "" {
-		log.Fatalf("REDIS_URL must be set")
+	shareLinks := NewShareLinks([]byte(os.Getenv("SHARE_SIGNING_KEY")), os.Getenv("PUBLIC_BASE_URL"))
+	// Optional SMTP relay emailing download links of finished query jobs
+	mailer, err := NewMailer(os.Getenv("SMTP_URL"), os.Getenv("SMTP_FROM"))
+	if err != nil {
+		log.Fatalf("invalid SMTP_URL: %v", err)
 	}
-
-	store := NewEventStore(maxEvents, maxEventsPerWallet)
-	// Optional Postgres backing for persistence
-	if dsn := os.Getenv("POSTGRES_DSN"); dsn != "" {
-		db, err := pgxpool.New(context.Background(), dsn)
+	queryJobs.AttachMailer(mailer, shareLinks)
+	// Optional job compiling the daily reports after midnight UTC and
+	// delivering them on the tenants' streams, webhooks and email
+	if os.Getenv("DAILY_REPORTS") == "true" {
+		recipients, err := ParseReportRecipients(os.Getenv("DAILY_REPORT_EMAILS"))
 		if err != nil {
-			log.WithError(err).Warn("failed to connect to postgres; running in memory-only mode")
-		} else {
-			if err := initDB(context.Background(), db); err != nil {
-				log.WithError(err).Warn("failed to init db schema; running in memory-only mode")
-			} else {
-				store.AttachDB(db)
-				log.Info("api: connected to postgres and initialized schema")
-			}
+			log.Fatalf("invalid DAILY_REPORT_EMAILS: %v", err)
+		}
+		if len(recipients) > 0 && mailer == nil {
+			log.Fatal("DAILY_REPORT_EMAILS requires SMTP_URL")
 		}
+		reports.AttachDelivery(systemEvents, mailer, recipients)
+		go reports.Run(ctx)
+		log.Info("api: daily reports enabled")
 	}
-	hub := NewHub()
-	go hub.Run()
 
-	go subscribeToEvents(context.Background(), redisURL, store, hub)
+	// Optional unauthenticated, read-only mirror of the latest transactions
+	// and stats, served from snapshots so it can sit behind a CDN
+	var mirror *PublicMirror
+	if os.Getenv("PUBLIC_MIRROR") == "true" {
+		mirror = NewPublicMirror(store, os.Getenv("PUBLIC_MIRROR_TENANT"), envInt("PUBLIC_MIRROR_EVENTS", defaultMirrorEvents),
+			envDuration("PUBLIC_MIRROR_REFRESH", defaultMirrorRefresh))
+		mirror.AttachOwners(owners)
+		go mirror.Run(ctx)
+		log.WithField("tenant", os.Getenv("PUBLIC_MIRROR_TENANT")).Info("api: public mirror enabled")
+	}
+
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	sharedWrite := requireSharedWrite(adminToken, tenants)
+	pluginOwner := requirePluginOwner(adminToken)
 
 	r := chi.NewRouter()
+	r.Use(limiter.Middleware)
+	r.NotFound(notFoundHandler)
+	r.MethodNotAllowed(methodNotAllowedHandler)
+	metrics := metricSources{cache: store.cache, batch: batch, retry: persistRetry, dedup: dedup, hub: hub,
+		latency: latency, watchdog: watchdog, startup: startup, prices: store.pricesAtBlock}
 	r.Get("/health", healthHandler)
-	r.Get("/events/subscribe", func(w http.ResponseWriter, r *http.Request) {
-		serveSSE(hub, w, r)
+	r.Get("/ready", func(w http.ResponseWriter, r *http.Request) {
+		readyHandler(watchdog, startup, w, r)
 	})
-	r.Get("/wallet/{address}/transactions", func(w http.ResponseWriter, r *http.Request) {
-		getWalletTransactions(store, w, r)
+	r.Get("/status", func(w http.ResponseWriter, r *http.Request) {
+		statusHandler(watchdog, startup, w, r)
 	})
-	r.Get("/transactions", func(w http.ResponseWriter, r *http.Request) {
-		getTransactions(store, w, r)
+	r.Get("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		metricsHandler(metrics, w, r)
 	})
+	r.Get("/openapi.json", serveOpenAPI)
+	r.Get("/docs", serveDocs)
+	r.Get("/sdk/typescript.tgz", serveSDK)
+	r.Get("/.well-known/tracker-key", func(w http.ResponseWriter, r *http.Request) {
+		getTrackerKey(signer, w, r)
+	})
+	// Once tenants are configured these routes need an API key, and event
+	// reads only see the key's tenant; chains, labels and system notices
+	// stay shared, and label writes need the admin token
+	r.Group(func(r chi.Router) {
+		r.Use(requireTenant(tenants))
+		r.Get("/events/subscribe", func(w http.ResponseWriter, r *http.Request) {
+			serveSSE(hub, w, r)
+		})
+		r.Get("/events/system", func(w http.ResponseWriter, r *http.Request) {
+			serveSystemSSE(systemEvents, w, r)
+		})
+		r.Get("/wallet/{address}/transactions", func(w http.ResponseWriter, r *http.Request) {
+			getWalletTransactions(store, w, r)
+		})
+		r.Get("/wallet/{address}/import", func(w http.ResponseWriter, r *http.Request) {
+			getHistoryImports(history, w, r)
+		})
+		r.Get("/wallet/{address}/subscribe", func(w http.ResponseWriter, r *http.Request) {
+			subscribeWallet(hub, w, r)
+		})
+		r.Get("/wallet/{address}/stats", func(w http.ResponseWriter, r *http.Request) {
+			getWalletStats(store, w, r)
+		})
+		r.Get("/wallet/{address}/peel-chain", func(w http.ResponseWriter, r *http.Request) {
+			getPeelChain(store, w, r)
+		})
+		r.With(signResponses(signer)).Get("/wallet/{address}/graph", func(w http.ResponseWriter, r *http.Request) {
+			getWalletGraph(store, w, r)
+		})
+		r.Post("/wallet/{address}/export", func(w http.ResponseWriter, r *http.Request) {
+			createWalletExport(queryJobs, w, r)
+		})
+		r.Post("/wallet/{address}/verify", func(w http.ResponseWriter, r *http.Request) {
+			verifyWallet(owners, w, r)
+		})
+		r.Delete("/wallet/{address}/ownership", func(w http.ResponseWriter, r *http.Request) {
+			releaseWallet(owners, w, r)
+		})
+		r.Get("/wallet/{address}/notes", func(w http.ResponseWriter, r *http.Request) {
+			getWalletNotes(owners, w, r)
+		})
+		r.Put("/wallet/{address}/notes", func(w http.ResponseWriter, r *http.Request) {
+			putWalletNotes(owners, w, r)
+		})
+		r.Get("/wallet/{address}/privacy", func(w http.ResponseWriter, r *http.Request) {
+			getWalletPrivacy(owners, w, r)
+		})
+		r.Put("/wallet/{address}/privacy", func(w http.ResponseWriter, r *http.Request) {
+			putWalletPrivacy(owners, w, r)
+		})
+		r.Get("/wallets/owned", func(w http.ResponseWriter, r *http.Request) {
+			listOwnedWallets(owners, w, r)
+		})
+		r.Get("/wallet/{address}", func(w http.ResponseWriter, r *http.Request) {
+			getWalletView(store, identities, w, r)
+		})
+		r.Get("/identities", func(w http.ResponseWriter, r *http.Request) {
+			listIdentities(identities, w, r)
+		})
+		r.Post("/identities", func(w http.ResponseWriter, r *http.Request) {
+			createIdentity(identities, w, r)
+		})
+		r.Get("/identities/{id}", func(w http.ResponseWriter, r *http.Request) {
+			getIdentity(identities, w, r)
+		})
+		r.Delete("/identities/{id}", func(w http.ResponseWriter, r *http.Request) {
+			deleteIdentity(identities, w, r)
+		})
+		r.Post("/identities/{id}/links", func(w http.ResponseWriter, r *http.Request) {
+			linkIdentity(store, identities, w, r)
+		})
+		r.Delete("/identities/{id}/links/{address}", func(w http.ResponseWriter, r *http.Request) {
+			unlinkIdentity(identities, w, r)
+		})
+		r.Get("/transactions", func(w http.ResponseWriter, r *http.Request) {
+			getTransactions(store, w, r)
+		})
+		r.Post("/wallets/transactions", func(w http.ResponseWriter, r *http.Request) {
+			getBulkWalletTransactions(store, w, r)
+		})
+		r.Get("/transactions/{event_id}", func(w http.ResponseWriter, r *http.Request) {
+			getEventDetail(store, rawTx, w, r)
+		})
+		r.Get("/bridges/transfers", func(w http.ResponseWriter, r *http.Request) {
+			getBridgeTransfers(store, w, r)
+		})
+		r.Get("/tx/{hash}", func(w http.ResponseWriter, r *http.Request) {
+			getTransactionByHash(store, w, r)
+		})
+		r.Get("/tx/{chain}/{hash}", func(w http.ResponseWriter, r *http.Request) {
+			getTransactionDetail(store, rawTx, w, r)
+		})
+		r.Get("/search", func(w http.ResponseWriter, r *http.Request) {
+			search(store, labels, w, r)
+		})
+		r.Get("/chains", func(w http.ResponseWriter, r *http.Request) {
+			listChains(chains, w, r)
+		})
+		r.Get("/chains/status", func(w http.ResponseWriter, r *http.Request) {
+			getChainStatus(rpc, w, r)
+		})
+		r.Get("/chains/{chain}/reorgs", func(w http.ResponseWriter, r *http.Request) {
+			getChainReorgs(store.reorgs, w, r)
+		})
+		r.Get("/analytics/volume", func(w http.ResponseWriter, r *http.Request) {
+			getVolumeAnalytics(store, w, r)
+		})
+		r.Get("/analytics/active-wallets", func(w http.ResponseWriter, r *http.Request) {
+			getActiveWalletsAnalytics(store, w, r)
+		})
+		r.Post("/stats/custom", func(w http.ResponseWriter, r *http.Request) {
+			createCustomMetric(customMetrics, w, r)
+		})
+		r.Get("/stats/bridge-fees", func(w http.ResponseWriter, r *http.Request) {
+			getBridgeFeeStats(store, w, r)
+		})
+		r.Get("/stats/custom", func(w http.ResponseWriter, r *http.Request) {
+			listCustomMetrics(customMetrics, w, r)
+		})
+		r.Get("/stats/custom/{name}", func(w http.ResponseWriter, r *http.Request) {
+			getCustomMetric(customMetrics, w, r)
+		})
+		r.Delete("/stats/custom/{name}", func(w http.ResponseWriter, r *http.Request) {
+			deleteCustomMetric(customMetrics, w, r)
+		})
+		r.Get("/labels", func(w http.ResponseWriter, r *http.Request) {
+			listLabels(labels, w, r)
+		})
+		r.With(sharedWrite, store.responses.InvalidateAfter).Post("/labels/import", func(w http.ResponseWriter, r *http.Request) {
+			importLabels(labels, w, r)
+		})
+		r.Get("/labels/{address}", func(w http.ResponseWriter, r *http.Request) {
+			getLabel(labels, w, r)
+		})
+		r.With(sharedWrite, store.responses.InvalidateAfter).Put("/labels/{address}", func(w http.ResponseWriter, r *http.Request) {
+			putLabel(labels, w, r)
+		})
+		r.With(sharedWrite, store.responses.InvalidateAfter).Delete("/labels/{address}", func(w http.ResponseWriter, r *http.Request) {
+			deleteLabel(labels, w, r)
+		})
+		r.Post("/queries", func(w http.ResponseWriter, r *http.Request) {
+			createQuery(queryJobs, w, r)
+		})
+		r.Get("/queries/{id}", func(w http.ResponseWriter, r *http.Request) {
+			getQuery(queryJobs, w, r)
+		})
+		r.With(signResponses(signer)).Get("/queries/{id}/result", func(w http.ResponseWriter, r *http.Request) {
+			getQueryResult(queryJobs, w, r)
+		})
+		r.Delete("/queries/{id}", func(w http.ResponseWriter, r *http.Request) {
+			cancelQuery(queryJobs, w, r)
+		})
+		r.Post("/share", func(w http.ResponseWriter, r *http.Request) {
+			createShare(shareLinks, store, w, r)
+		})
+		r.Post("/views", func(w http.ResponseWriter, r *http.Request) {
+			createView(views, w, r)
+		})
+		r.Get("/views", func(w http.ResponseWriter, r *http.Request) {
+			listViews(views, w, r)
+		})
+		r.Get("/views/{id}", func(w http.ResponseWriter, r *http.Request) {
+			getView(views, w, r)
+		})
+		r.Delete("/views/{id}", func(w http.ResponseWriter, r *http.Request) {
+			deleteView(views, w, r)
+		})
+		r.Get("/views/{id}/transactions", func(w http.ResponseWriter, r *http.Request) {
+			getViewTransactions(views, store, w, r)
+		})
+		r.Get("/views/{id}/subscribe", func(w http.ResponseWriter, r *http.Request) {
+			subscribeView(views, hub, w, r)
+		})
+		r.Get("/treasuries", func(w http.ResponseWriter, r *http.Request) {
+			listTreasuryPolicies(treasuries, w, r)
+		})
+		r.Get("/treasuries/{address}", func(w http.ResponseWriter, r *http.Request) {
+			getTreasuryPolicy(treasuries, w, r)
+		})
+		r.Put("/treasuries/{address}", func(w http.ResponseWriter, r *http.Request) {
+			putTreasuryPolicy(treasuries, w, r)
+		})
+		r.Delete("/treasuries/{address}", func(w http.ResponseWriter, r *http.Request) {
+			deleteTreasuryPolicy(treasuries, w, r)
+		})
+		r.Post("/treasuries/{address}/approved", func(w http.ResponseWriter, r *http.Request) {
+			approveTreasuryRecipients(treasuries, w, r)
+		})
+		r.Delete("/treasuries/{address}/approved/{counterparty}", func(w http.ResponseWriter, r *http.Request) {
+			revokeTreasuryRecipient(treasuries, w, r)
+		})
+		r.Get("/escalation-policies", func(w http.ResponseWriter, r *http.Request) {
+			listEscalationPolicies(escalator, w, r)
+		})
+		r.Get("/escalation-policies/{rule}", func(w http.ResponseWriter, r *http.Request) {
+			getEscalationPolicy(escalator, w, r)
+		})
+		r.Put("/escalation-policies/{rule}", func(w http.ResponseWriter, r *http.Request) {
+			putEscalationPolicy(escalator, w, r)
+		})
+		r.Delete("/escalation-policies/{rule}", func(w http.ResponseWriter, r *http.Request) {
+			deleteEscalationPolicy(escalator, w, r)
+		})
+		r.Get("/alerts", func(w http.ResponseWriter, r *http.Request) {
+			listAlerts(escalator, w, r)
+		})
+		r.Post("/alerts/{id}/ack", func(w http.ResponseWriter, r *http.Request) {
+			acknowledgeAlert(escalator, w, r)
+		})
+		r.Get("/reports/daily/{date}", func(w http.ResponseWriter, r *http.Request) {
+			getDailyReport(reports, w, r)
+		})
+		if plugins != nil {
+			r.Get("/plugins", func(w http.ResponseWriter, r *http.Request) {
+				listPlugins(plugins, w, r)
+			})
+			r.With(pluginOwner, store.responses.InvalidateAfter).Put("/plugins/{name}", func(w http.ResponseWriter, r *http.Request) {
+				putPlugin(plugins, w, r)
+			})
+			r.With(pluginOwner, store.responses.InvalidateAfter).Delete("/plugins/{name}", func(w http.ResponseWriter, r *http.Request) {
+				deletePlugin(plugins, w, r)
+			})
+		}
+	})
+	r.Get("/shared/{token}", func(w http.ResponseWriter, r *http.Request) {
+		getShared(shareLinks, store, w, r)
+	})
+	r.With(signResponses(signer)).Get("/downloads/{token}", func(w http.ResponseWriter, r *http.Request) {
+		getDownload(shareLinks, queryJobs, w, r)
+	})
+
+	if mirror != nil {
+		r.Get("/public/transactions", func(w http.ResponseWriter, r *http.Request) {
+			getPublicTransactions(mirror, w, r)
+		})
+		r.Get("/public/stats", func(w http.ResponseWriter, r *http.Request) {
+			getPublicStats(mirror, w, r)
+		})
+	}
+
+	// Housekeeping endpoints - only enabled with an admin token
+	if adminToken != "" {
+		mountAdmin(r, adminToken, store, chains, sequences, retention)
+	}
 
 	// Test endpoint - only enabled in test mode
 	if os.Getenv("TEST_MODE") == "true" {
 		r.Get("/internal/last-received", func(w http.ResponseWriter, r *http.Request) {
-			limit := 1
-			if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-				if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-					limit = l
-				}
+			p := newQueryParams(r)
+			limit := p.Int("limit", 1, 1, maxPageSize)
+			if err := p.Err(); err != nil {
+				badRequest(w, err)
+				return
 			}
 
 			filter := EventFilter{
@@ -657,75 +1808,94 @@ func main() {
 		MaxHeaderBytes:    1 << 20, // 1 MB
 	}
 
-	log.Infof("api: listening on %s", bindAddr)
-	log.Fatalf("server failed to start: %v", server.ListenAndServe())
-}
-
-// --- DB helpers ---
-
-// initDB creates the minimal schema if it does not already exist.
-func initDB(ctx context.Context, db *pgxpool.Pool) error {
-	_, err := db.Exec(ctx, `
-		CREATE TABLE IF NOT EXISTS events (
-			event_id TEXT PRIMARY KEY,
-			chain TEXT NOT NULL,
-			network TEXT NOT NULL,
-			tx_hash TEXT NOT NULL,
-			timestamp TEXT NOT NULL,
-			from_addr TEXT NOT NULL,
-			to_addr TEXT NOT NULL,
-			value TEXT NOT NULL,
-			event_type TEXT NOT NULL,
-			slot BIGINT NULL,
-			token_address TEXT NULL,
-			token_symbol TEXT NULL,
-			token_decimals INT NULL,
-			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-		);
-		CREATE INDEX IF NOT EXISTS idx_events_from ON events (LOWER(from_addr));
-		CREATE INDEX IF NOT EXISTS idx_events_to ON events (LOWER(to_addr));
-		CREATE INDEX IF NOT EXISTS idx_events_created ON events (created_at DESC);
-	`)
-	return err
+	go func() {
+		log.Infof("api: listening on %s", bindAddr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("server failed to start: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Info("api: shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.WithError(err).Warn("http server shutdown failed")
+	}
+	if batch != nil {
+		if err := batch.Close(shutdownCtx); err != nil {
+			log.WithError(err).WithField("pending", batch.Depth()).Warn("failed to flush event buffer")
+		}
+	}
+	customMetrics.Flush(shutdownCtx)
+	if store.repo != nil {
+		store.repo.Close()
+	}
 }
 
-// persistEvent stores a single event idempotently (on event_id).
-func persistEvent(ctx context.Context, db *pgxpool.Pool, ev *Event) error {
-	var slot *int64
-	if ev.Slot != nil {
-		// G115: Safe conversion - Solana slots fit in int64 range
-		if *ev.Slot > uint64(^uint64(0)>>1) {
-			return fmt.Errorf("slot value too large: %d", *ev.Slot)
-		}
-		tmp := int64(*ev.Slot)
-		slot = &tmp
-	}
-	var tokAddr, tokSym *string
-	var tokDec *int32
-	if ev.Token != nil {
-		ta := ev.Token.Address
-		ts := ev.Token.Symbol
-		// G115: Safe conversion - decimals are bounded by ERC20/SPL token standards (max 255)
-		td := int32(ev.Token.Decimals)
-		tokAddr = &ta
-		tokSym = &ts
-		tokDec = &td
-	}
-	_, err := db.Exec(ctx, `
-		INSERT INTO events (event_id, chain, network, tx_hash, timestamp, from_addr, to_addr, value, event_type, slot, token_address, token_symbol, token_decimals)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
-		ON CONFLICT (event_id) DO NOTHING
-	`,
-		ev.EventID, ev.Chain, ev.Network, ev.TxHash, ev.Timestamp,
-		ev.From, ev.To, ev.Value, ev.EventType, slot, tokAddr, tokSym, tokDec,
-	)
-	return err
+// metricSources are the components /metrics reports on; nil ones are
+// left out.
+type metricSources struct {
+	cache    *MemoryRepository
+	batch    *BatchWriter
+	retry    *PersistRetry
+	dedup    *Deduplicator
+	hub      *Hub
+	latency  *LatencyTracker
+	watchdog *Watchdog
+	startup  *StartupTracker
+	prices   *PriceResolver
+}
+
+// metricsHandler serves process metrics in the Prometheus text format.
+func metricsHandler(m metricSources, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if m.cache != nil {
+		m.cache.WriteMetrics(w)
+	}
+	if m.batch != nil {
+		m.batch.WriteMetrics(w)
+	}
+	if m.retry != nil {
+		m.retry.WriteMetrics(w)
+	}
+	if m.dedup != nil {
+		m.dedup.WriteMetrics(w)
+	}
+	if m.hub != nil {
+		m.hub.WriteMetrics(w)
+	}
+	m.latency.WriteMetrics(w)
+	if m.watchdog != nil {
+		m.watchdog.WriteMetrics(w)
+	}
+	if m.startup != nil {
+		m.startup.WriteMetrics(w)
+	}
+	if m.prices != nil {
+		m.prices.WriteMetrics(w)
+	}
 }
 
-// getOrEmpty safely dereferences an optional string.
-func getOrEmpty(s *string) string {
-	if s == nil {
-		return ""
+// envInt reads a positive integer from the environment, or returns def.
+func envInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		log.Warnf("invalid %s %q; using %d", key, v, def)
+	}
+	return def
+}
+
+// envDuration reads a positive duration (e.g. 30s, 10m) from the
+// environment, or returns def.
+func envDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Warnf("invalid %s %q; using %s", key, v, def)
 	}
-	return *s
+	return def
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+// migrationLockID is the Postgres advisory lock replicas take while
+// migrating, so two instances starting together do not both apply a
+// migration.
+const migrationLockID = 7_264_310_051
+
+// migration is one schema change. Versions start at 1 and increase by one;
+// a migration is never edited once released, later changes get a new
+// version.
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// pendingMigrations returns the migrations not in applied, in order. It
+// fails when the versions are not 1, 2, 3...
+func pendingMigrations(migrations []migration, applied map[int]bool) ([]migration, error) {
+	var pending []migration
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %q has version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// warnNewerSchema logs when the database has migrations this build does not
+// know, i.e. a newer version migrated it. Older builds keep working as long
+// as migrations only add to the schema.
+func warnNewerSchema(table string, applied map[int]bool, migrations []migration) {
+	for v := range applied {
+		if v > len(migrations) {
+			log.WithFields(log.Fields{"table": table, "version": v, "known": len(migrations)}).
+				Warn("database schema is newer than this build")
+			return
+		}
+	}
+}
+
+// migratePostgres applies the pending migrations, each in its own
+// transaction, and records them in table.
+func migratePostgres(ctx context.Context, db *pgxpool.Pool, table string, migrations []migration) error {
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+	}()
+
+	if _, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS `+table+` (
+			version INT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`); err != nil {
+		return err
+	}
+	rows, err := conn.Query(ctx, `SELECT version FROM `+table)
+	if err != nil {
+		return err
+	}
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	warnNewerSchema(table, applied, migrations)
+
+	pending, err := pendingMigrations(migrations, applied)
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, m.SQL); err != nil {
+			_ = tx.Rollback(ctx)
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO `+table+` (version, name) VALUES ($1, $2)`, m.Version, m.Name); err != nil {
+			_ = tx.Rollback(ctx)
+			return err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{"version": m.Version, "name": m.Name}).Info("applied schema migration")
+	}
+	return nil
+}
+
+// migrateSQLite applies the pending migrations to a SQLite database, each
+// in its own transaction. There is no lock: SQLite has a single writer.
+func migrateSQLite(ctx context.Context, db *sql.DB, migrations []migration) error {
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+		)`); err != nil {
+		return err
+	}
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	warnNewerSchema("schema_migrations", applied, migrations)
+
+	pending, err := pendingMigrations(migrations, applied)
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.Version, m.Name); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{"version": m.Version, "name": m.Name}).Info("applied schema migration")
+	}
+	return nil
+}
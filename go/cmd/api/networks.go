@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// legacyEventsChannel is the single channel listeners published on before
+	// per-network channels existed. It is still consumed for compatibility.
+	legacyEventsChannel = "cross_chain_events"
+	// eventsChannelPrefix prefixes the per-network channels, which are named
+	// cross_chain_events:<chain>:<network>.
+	eventsChannelPrefix = legacyEventsChannel + ":"
+)
+
+// eventsChannel returns the Redis channel for a single chain/network pair.
+func eventsChannel(chain, network string) string {
+	return eventsChannelPrefix + chain + ":" + network
+}
+
+// NetworkFilter decides which chain/network pairs are ingested and which
+// per-network channels are subscribed to. NetworkAllowlist is fixed at
+// startup; ChainRegistry can change at runtime.
+type NetworkFilter interface {
+	Allows(chain, network string) bool
+	// Empty reports whether every pair not explicitly disabled is accepted,
+	// in which case the channel pattern is subscribed to.
+	Empty() bool
+	Channels() []string
+}
+
+// NetworkAllowlist is the set of chain/network pairs the API accepts events
+// for. An empty allowlist accepts every pair.
+type NetworkAllowlist struct {
+	pairs map[string]struct{}
+}
+
+// ParseNetworkAllowlist parses a comma-separated list of chain:network pairs,
+// e.g. "ethereum:mainnet,solana:devnet". Pairs may use chain aliases or be
+// CAIP-2 identifiers such as eip155:1. An empty spec accepts everything.
+func ParseNetworkAllowlist(spec string) (*NetworkAllowlist, error) {
+	a := &NetworkAllowlist{pairs: make(map[string]struct{})}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		chain, network, ok := parseNetworkPair(item)
+		if !ok {
+			return nil, fmt.Errorf("invalid network %q: want chain:network", item)
+		}
+		a.pairs[chain+":"+network] = struct{}{}
+	}
+	return a, nil
+}
+
+// Empty reports whether the allowlist accepts every chain/network pair.
+func (a *NetworkAllowlist) Empty() bool {
+	return a == nil || len(a.pairs) == 0
+}
+
+// Allows reports whether events for the chain/network pair are accepted.
+func (a *NetworkAllowlist) Allows(chain, network string) bool {
+	if a.Empty() {
+		return true
+	}
+	chain, network = canonicalNetwork(chain, network)
+	_, ok := a.pairs[chain+":"+network]
+	return ok
+}
+
+// Channels returns the per-network Redis channels to subscribe to, sorted for
+// stable logging. It is empty when the allowlist accepts everything; callers
+// then subscribe to the channel pattern instead.
+func (a *NetworkAllowlist) Channels() []string {
+	if a.Empty() {
+		return nil
+	}
+	out := make([]string, 0, len(a.pairs))
+	for pair := range a.pairs {
+		parts := strings.SplitN(pair, ":", 2)
+		out = append(out, chainAdapter(parts[0]).Subscribe(parts[1]))
+	}
+	sort.Strings(out)
+	return out
+}
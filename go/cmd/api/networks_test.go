@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseNetworkAllowlist(t *testing.T) {
+	a, err := ParseNetworkAllowlist(" Ethereum:Mainnet, solana:devnet ,")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !a.Allows("ethereum", "mainnet") || !a.Allows("SOLANA", "devnet") {
+		t.Fatalf("expected configured pairs to be allowed")
+	}
+	if a.Allows("ethereum", "sepolia") || a.Allows("solana", "mainnet") {
+		t.Fatalf("expected other pairs to be rejected")
+	}
+	want := []string{"cross_chain_events:ethereum:mainnet", "cross_chain_events:solana:devnet"}
+	if got := a.Channels(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("channels: want %v, got %v", want, got)
+	}
+
+	for _, bad := range []string{"ethereum", "ethereum:", ":mainnet", "a:b:c"} {
+		if _, err := ParseNetworkAllowlist(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestEmptyNetworkAllowlistAllowsAll(t *testing.T) {
+	a, err := ParseNetworkAllowlist("")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !a.Empty() || !a.Allows("ethereum", "anything") || a.Channels() != nil {
+		t.Fatalf("expected empty allowlist to accept every network")
+	}
+	var nilList *NetworkAllowlist
+	if !nilList.Allows("solana", "devnet") {
+		t.Fatalf("expected nil allowlist to accept every network")
+	}
+}
+
+func TestTransactionsFilterByNetwork(t *testing.T) {
+	store := NewEventStore(100, 50)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	sepolia := makeEvent("1", aliceAddr, bobAddr, "1", ts, "")
+	mainnet := makeEvent("2", aliceAddr, carolAddr, "1", ts, "")
+	mainnet.Network = "mainnet"
+	store.Add(sepolia)
+	store.Add(mainnet)
+
+	r := httptest.NewRecorder()
+	getTransactions(store, r, httptest.NewRequest(http.MethodGet, "/transactions?network=mainnet", nil))
+	var events []*Event
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(events) != 1 || events[0].EventID != "2" {
+		t.Fatalf("expected only the mainnet event, got %+v", events)
+	}
+
+	r = httptest.NewRecorder()
+	req := withChiParam(httptest.NewRequest(http.MethodGet, "/wallet/"+aliceAddr+"/transactions?network=sepolia", nil), "address", aliceAddr)
+	getWalletTransactions(store, r, req)
+	events = nil
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(events) != 1 || events[0].EventID != "1" {
+		t.Fatalf("expected only the sepolia event, got %+v", events)
+	}
+}
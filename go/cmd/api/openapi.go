@@ -0,0 +1,682 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The REST surface is declared here, next to the handlers, and served as an
+// OpenAPI 3 document at /openapi.json with Swagger UI at /docs. Component
+// schemas are generated from the Go types' json tags so they cannot drift
+// from what the handlers encode; fields without omitempty are required.
+
+// apiOperation describes one route of the REST API.
+type apiOperation struct {
+	Method      string
+	Path        string
+	OperationID string
+	Tag         string
+	Summary     string
+	Params      []apiParam
+	// Body is the JSON request body type, or a string content type for
+	// non-JSON bodies.
+	Body interface{}
+	// Response is the 2xx JSON response type; nil for no JSON body.
+	Response interface{}
+	// Status is the success status, http.StatusOK when zero.
+	Status int
+	// Accepted is the JSON response type of a 202 Accepted the operation
+	// answers while work it started is still running.
+	Accepted interface{}
+	// Produces lists non-JSON content types of the success response.
+	Produces []string
+	// Headers documents success response headers.
+	Headers map[string]string
+	// Errors lists the error statuses, each answered with ErrorResponse.
+	Errors []int
+	// Admin marks operations that require the admin bearer token. With
+	// Tenant also set, the token is only required when configured.
+	Admin bool
+	// Tenant marks operations that need a tenant API key once tenants are
+	// configured; they answer 401 without one.
+	Tenant bool
+}
+
+// apiParam is a path or query parameter.
+type apiParam struct {
+	Name        string
+	In          string
+	Type        string
+	Description string
+	Enum        []string
+}
+
+func queryParam(name, typ, description string) apiParam {
+	return apiParam{Name: name, In: "query", Type: typ, Description: description}
+}
+
+func pathParam(name, description string) apiParam {
+	return apiParam{Name: name, In: "path", Type: "string", Description: description}
+}
+
+// Schema shapes that cannot be derived from a Go type alone.
+type (
+	apiArray  struct{ Of interface{} }
+	apiSeries struct{ Points interface{} }
+)
+
+var (
+	chainParam   = queryParam("chain", "string", "Only events on this chain, e.g. ethereum, eth or solana, or on the network of a CAIP-2 chain id such as eip155:1.")
+	networkParam = queryParam("network", "string", "Only events on this network, e.g. mainnet, sepolia or devnet.")
+	tokenParam   = queryParam("token", "string", "Only transfers of this token symbol.")
+	statusParam  = apiParam{Name: "status", In: "query", Type: "string", Enum: []string{StatusPending, StatusConfirmed, StatusFinalized, StatusOrphaned},
+		Description: "Only events with this status. Orphaned events are excluded unless requested."}
+	profileParam = apiParam{Name: "profile", In: "query", Type: "string", Enum: []string{ProfileFull, ProfileCompact, ProfileExplorer},
+		Description: "Field set of the returned events: full (default), compact or explorer."}
+	limitParam   = queryParam("limit", "integer", fmt.Sprintf("Page size (default %d, at most %d).", defaultPageSize, maxPageSize))
+	offsetParam  = queryParam("offset", "integer", "Number of events to skip.")
+	totalParam   = queryParam("include_total", "boolean", "Count every matching event for X-Total-Count (default true); false skips the count, which scans every match.")
+	historyParam = queryParam("include_history", "boolean", "Include the versions amendments superseded, which are left out otherwise.")
+	sampleParam  = queryParam("sample", "integer", fmt.Sprintf("Instead of a page, return up to this many matching events drawn uniformly at random (at most %d), with their estimated number in X-Total-Estimate. Cannot be combined with offset.", maxPageSize))
+
+	eventFilterParams = []apiParam{
+		chainParam, networkParam, tokenParam,
+		queryParam("from", "string", "Only events sent by this EVM (any case) or Solana address."),
+		queryParam("to", "string", "Only events received by this EVM (any case) or Solana address."),
+		statusParam,
+		queryParam("min_value", "number", "Only events with at least this value, in whole units of the asset (e.g. 1.5 ETH or USDC)."),
+		queryParam("start_time", "string", "RFC3339 lower bound on the event timestamp."),
+		queryParam("end_time", "string", "RFC3339 upper bound on the event timestamp."),
+		{Name: "bridge", In: "query", Type: "string", Enum: []string{BridgeWormhole, BridgeLayerZero, BridgeCCTP}, Description: "Only legs of transfers over this bridge."},
+		queryParam("sequence", "string", "Only legs of the bridge message with this sequence (the GUID for LayerZero)."),
+		queryParam("source", "string", "Only events from this producer (e.g. import:etherscan); a trailing * matches by prefix, ignoring case."),
+		historyParam, limitParam, offsetParam, totalParam, profileParam,
+	}
+	analyticsParams = []apiParam{
+		chainParam, networkParam, tokenParam,
+		{Name: "interval", In: "query", Type: "string", Enum: []string{"1h", "1d"}, Description: "Bucket size (default 1h)."},
+		queryParam("start", "string", "RFC3339 start of the range (default 24 hours or 30 days before end)."),
+		queryParam("end", "string", "RFC3339 end of the range (default now)."),
+	}
+	sinceParam = queryParam("since", "integer", "Resume after this event ID, like the Last-Event-ID header.")
+	// sseParams are the parameters of the event streams.
+	sseParams = []apiParam{
+		sinceParam,
+		{Name: "payload", In: "query", Type: "string", Enum: []string{PayloadFull, PayloadCompact, PayloadIDsOnly},
+			Description: "Shape of event frames: full (default), compact (the compact profile's fields) or ids-only ({\"event_id\": ...}, to fetch from GET /transactions/{event_id}). Status changes are always sent whole."},
+	}
+	// treasuryParam and ruleParam identify the policies of the /treasuries
+	// and /escalation-policies routes.
+	treasuryParam     = pathParam("address", "Treasury wallet address.")
+	ruleParam         = pathParam("rule", "Alert rule, matched against data.rule of alert.triggered events.")
+	paginationHeaders = map[string]string{
+		"X-Total-Count": "Number of events matching the filters, unless include_total=false.",
+		"Link":          `RFC 5988 links to the rel="next" and rel="prev" pages.`,
+	}
+	// listingHeaders are the headers of cacheable event listings, which
+	// answer a matching If-None-Match with 304 Not Modified.
+	listingHeaders = map[string]string{
+		"X-Total-Count":    paginationHeaders["X-Total-Count"],
+		"Link":             paginationHeaders["Link"],
+		"ETag":             "Entity tag of the response; send it back in If-None-Match to get 304 Not Modified while the page is unchanged.",
+		"X-Total-Estimate": "Estimated number of matching events, with sample.",
+	}
+	// mirrorHeaders are the headers of public mirror responses.
+	mirrorHeaders = map[string]string{
+		"Cache-Control": "How long shared caches may keep the response: the mirror's refresh interval.",
+		"Last-Modified": "When the snapshot the response comes from was built.",
+		"ETag":          listingHeaders["ETag"],
+	}
+	// signatureHeaders are the headers of exports, sent when SIGNING_KEY
+	// is set.
+	signatureHeaders = map[string]string{
+		keyIDHeader:     "Id of the key that signed the body; see /.well-known/tracker-key.",
+		signatureHeader: "Base64 Ed25519 signature of the SHA-256 digest of the body.",
+	}
+)
+
+// apiOperations is the REST surface of the API, in route order.
+var apiOperations = []apiOperation{
+	{Method: "GET", Path: "/health", OperationID: "getHealth", Tag: "system", Summary: "Liveness check",
+		Response: Health{}},
+	{Method: "GET", Path: "/ready", OperationID: "getReady", Tag: "system",
+		Summary:  "Readiness check, failing while starting, after a startup over budget or while the broadcast or ingest loops are stalled",
+		Response: Health{}, Errors: []int{503}},
+	{Method: "GET", Path: "/status", OperationID: "getStatus", Tag: "system", Summary: "Readiness, uptime and cold-start phase timings",
+		Response: StatusReport{}},
+	{Method: "GET", Path: "/metrics", OperationID: "getMetrics", Tag: "system", Summary: "Prometheus metrics",
+		Produces: []string{"text/plain"}},
+	{Method: "GET", Path: "/.well-known/tracker-key", OperationID: "getTrackerKey", Tag: "system", Summary: "Public key export and webhook signatures are checked with",
+		Response: TrackerKey{}, Errors: []int{404}},
+	{Method: "GET", Path: "/sdk/typescript.tgz", OperationID: "getTypeScriptClient", Tag: "system", Summary: "npm package of the TypeScript client generated from this document",
+		Produces: []string{"application/gzip"}, Headers: map[string]string{"ETag": listingHeaders["ETag"]}},
+	{Method: "GET", Path: "/events/subscribe", OperationID: "subscribeEvents", Tag: "events", Summary: "Live event feed (Server-Sent Events)",
+		Params: sseParams, Produces: []string{"text/event-stream"}, Tenant: true},
+	{Method: "GET", Path: "/events/system", OperationID: "subscribeSystemEvents", Tag: "events", Summary: "Live system event feed (Server-Sent Events)",
+		Params: []apiParam{sinceParam}, Produces: []string{"text/event-stream"}, Tenant: true},
+	{Method: "GET", Path: "/wallet/{address}/transactions", OperationID: "getWalletTransactions", Tag: "transactions", Summary: "A wallet's transaction history, newest first",
+		Params:   append(append([]apiParam{pathParam("address", "EVM (any case) or Solana wallet address.")}, eventFilterParams...), sampleParam),
+		Response: apiArray{Event{}}, Headers: listingHeaders, Accepted: apiArray{HistoryImport{}}, Errors: []int{400, 404}, Tenant: true},
+	{Method: "GET", Path: "/wallet/{address}/import", OperationID: "getHistoryImports", Tag: "transactions", Summary: "The state of a wallet's on-demand history imports",
+		Params:   []apiParam{pathParam("address", "EVM (any case) or Solana wallet address.")},
+		Response: apiArray{HistoryImport{}}, Errors: []int{400}, Tenant: true},
+	{Method: "GET", Path: "/wallet/{address}/subscribe", OperationID: "subscribeWallet", Tag: "events", Summary: "Live feed of one wallet's events (Server-Sent Events)",
+		Params:   append([]apiParam{pathParam("address", "EVM (any case) or Solana wallet address.")}, sseParams...),
+		Produces: []string{"text/event-stream"}, Errors: []int{400}, Tenant: true},
+	{Method: "GET", Path: "/wallet/{address}/stats", OperationID: "getWalletStats", Tag: "transactions", Summary: "A wallet's totals sent, received and paid in fees per asset",
+		Params: []apiParam{pathParam("address", "EVM (any case) or Solana wallet address."), chainParam, networkParam, statusParam,
+			queryParam("start_time", "string", "RFC3339 lower bound on the event timestamp."),
+			queryParam("end_time", "string", "RFC3339 upper bound on the event timestamp.")},
+		Response: WalletStats{}, Errors: []int{400, 500}, Tenant: true},
+	{Method: "GET", Path: "/wallet/{address}/peel-chain", OperationID: "getPeelChain", Tag: "investigation", Summary: "Trace a peel chain from a flagged wallet",
+		Params: []apiParam{pathParam("address", "Flagged wallet address."), chainParam, networkParam,
+			queryParam("max_hops", "integer", fmt.Sprintf("Maximum hops to follow (default %d, at most %d).", defaultPeelMaxHops, maxPeelMaxHops)),
+			queryParam("min_ratio", "number", fmt.Sprintf("Minimum share of the inflow forwarded per hop (between 0 and 1, default %g).", defaultPeelMinRatio))},
+		Response: Journey{}, Errors: []int{400}, Tenant: true},
+	{Method: "GET", Path: "/wallet/{address}/graph", OperationID: "getWalletGraph", Tag: "investigation", Summary: "Export the money-flow graph around a wallet",
+		Params: []apiParam{pathParam("address", "Root wallet address."), chainParam, networkParam, tokenParam,
+			queryParam("depth", "integer", fmt.Sprintf("Hops from the root (default %d, at most %d).", defaultGraphDepth, maxGraphDepth)),
+			{Name: "format", In: "query", Type: "string", Enum: []string{"json", "graphml", "dot", "csv", "gephi"}, Description: "Export format (default json)."}},
+		Response: MoneyFlowGraph{}, Produces: []string{"application/graphml+xml", "text/vnd.graphviz", "text/csv"}, Headers: signatureHeaders, Errors: []int{400}, Tenant: true},
+	{Method: "POST", Path: "/wallet/{address}/export", OperationID: "createWalletExport", Tag: "queries", Summary: "Export a wallet's complete history as a zip archive, as an async query",
+		Params: []apiParam{pathParam("address", "Wallet address.")}, Body: WalletExportRequest{}, Response: QueryJob{}, Status: http.StatusAccepted,
+		Headers: map[string]string{"Location": "URL to poll for the job's status."}, Errors: []int{400, 503}, Tenant: true},
+	{Method: "POST", Path: "/wallet/{address}/verify", OperationID: "verifyWallet", Tag: "ownership",
+		Summary: "Prove ownership of a wallet: without a signature, issue a challenge; with the signed challenge, verify it",
+		Params:  []apiParam{pathParam("address", "EVM (any case) or Solana wallet address."), chainParam},
+		Body:    OwnershipProof{}, Response: WalletVerification{}, Errors: []int{400, 500}, Tenant: true},
+	{Method: "DELETE", Path: "/wallet/{address}/ownership", OperationID: "releaseWallet", Tag: "ownership", Summary: "Forget a verified wallet and its notes",
+		Params: []apiParam{pathParam("address", "Owned wallet address.")}, Status: http.StatusNoContent, Errors: []int{400, 404, 500}, Tenant: true},
+	{Method: "GET", Path: "/wallet/{address}/notes", OperationID: "getWalletNotes", Tag: "ownership", Summary: "Private notes on an owned wallet",
+		Params: []apiParam{pathParam("address", "Owned wallet address.")}, Response: WalletNotes{}, Errors: []int{400, 403}, Tenant: true},
+	{Method: "PUT", Path: "/wallet/{address}/notes", OperationID: "putWalletNotes", Tag: "ownership", Summary: "Replace the private notes on an owned wallet",
+		Params: []apiParam{pathParam("address", "Owned wallet address.")}, Body: WalletNotes{}, Response: WalletNotes{}, Errors: []int{400, 403, 500}, Tenant: true},
+	{Method: "GET", Path: "/wallet/{address}/privacy", OperationID: "getWalletPrivacy", Tag: "ownership", Summary: "Privacy settings of an owned wallet",
+		Params: []apiParam{pathParam("address", "Owned wallet address.")}, Response: WalletPrivacy{}, Errors: []int{400, 403}, Tenant: true},
+	{Method: "PUT", Path: "/wallet/{address}/privacy", OperationID: "putWalletPrivacy", Tag: "ownership",
+		Summary: "Hide an owned wallet's label from other tenants or its transactions from the public mirror",
+		Params:  []apiParam{pathParam("address", "Owned wallet address.")}, Body: WalletPrivacy{}, Response: WalletPrivacy{}, Errors: []int{400, 403, 500}, Tenant: true},
+	{Method: "GET", Path: "/transactions", OperationID: "listTransactions", Tag: "transactions", Summary: "Recent transactions across all wallets",
+		Params: append(append([]apiParam{}, eventFilterParams...),
+			apiParam{Name: "sort_by", In: "query", Type: "string", Enum: []string{SortTimestamp, SortValue, SortBlockNumber},
+				Description: "Sort field (default: newest received first). value sorts by the amount in whole units; events without the field come last."},
+			apiParam{Name: "sort_order", In: "query", Type: "string", Enum: []string{"asc", "desc"}, Description: "Sort direction (default desc)."}, sampleParam),
+		Response: apiArray{Event{}}, Headers: listingHeaders, Errors: []int{400}, Tenant: true},
+	{Method: "POST", Path: "/wallets/transactions", OperationID: "getBulkWalletTransactions", Tag: "transactions",
+		Summary: fmt.Sprintf("Merged transaction history of up to %d wallets", maxBulkWallets),
+		Params:  []apiParam{profileParam}, Body: BulkWalletRequest{}, Response: BulkWalletResponse{},
+		Headers: map[string]string{"X-Total-Count": "Number of events matching the filters."}, Errors: []int{400}, Tenant: true},
+	{Method: "GET", Path: "/wallets/owned", OperationID: "listOwnedWallets", Tag: "ownership", Summary: "Wallets the caller verified it owns",
+		Response: apiArray{WalletOwnership{}}, Tenant: true},
+	{Method: "GET", Path: "/wallet/{address}", OperationID: "getWalletView", Tag: "identities",
+		Summary:  "The activity of an identity's addresses across chains, or of an address with the rest of its identity's",
+		Params:   []apiParam{pathParam("address", "Identity ID, or a wallet address."), limitParam, offsetParam, profileParam},
+		Response: WalletView{}, Headers: map[string]string{"X-Total-Count": "Number of transactions touching the addresses."}, Errors: []int{400, 404, 500}, Tenant: true},
+	{Method: "GET", Path: "/identities", OperationID: "listIdentities", Tag: "identities", Summary: "The caller's identities and their linked addresses",
+		Response: apiArray{Identity{}}, Tenant: true},
+	{Method: "POST", Path: "/identities", OperationID: "createIdentity", Tag: "identities", Summary: "Create an identity to link addresses across chains to",
+		Body: NewIdentity{}, Response: Identity{}, Status: http.StatusCreated, Errors: []int{400, 500}, Tenant: true},
+	{Method: "GET", Path: "/identities/{id}", OperationID: "getIdentity", Tag: "identities", Summary: "An identity and its linked addresses",
+		Params: []apiParam{pathParam("id", "Identity ID.")}, Response: Identity{}, Errors: []int{404}, Tenant: true},
+	{Method: "DELETE", Path: "/identities/{id}", OperationID: "deleteIdentity", Tag: "identities", Summary: "Delete an identity and unlink its addresses",
+		Params: []apiParam{pathParam("id", "Identity ID.")}, Status: http.StatusNoContent, Errors: []int{404, 500}, Tenant: true},
+	{Method: "POST", Path: "/identities/{id}/links", OperationID: "linkIdentity", Tag: "identities",
+		Summary: "Link an address, or the sender and recipients of a completed bridge transfer, to an identity",
+		Params:  []apiParam{pathParam("id", "Identity ID.")}, Body: IdentityLink{}, Response: Identity{}, Errors: []int{400, 404, 409, 500}, Tenant: true},
+	{Method: "DELETE", Path: "/identities/{id}/links/{address}", OperationID: "unlinkIdentity", Tag: "identities", Summary: "Unlink an address from an identity",
+		Params: []apiParam{pathParam("id", "Identity ID."), pathParam("address", "Linked address.")}, Status: http.StatusNoContent, Errors: []int{400, 404, 500}, Tenant: true},
+	{Method: "GET", Path: "/transactions/{event_id}", OperationID: "getEventDetail", Tag: "transactions", Summary: "An event with the raw on-chain transaction and its bridge legs",
+		Params: []apiParam{pathParam("event_id", "Event ID, e.g. eth:0x...:log2."),
+			queryParam("include_history", "boolean", "List every version of an amended event in history, oldest first."), profileParam},
+		Response: EventDetail{}, Errors: []int{400, 404}, Tenant: true},
+	{Method: "GET", Path: "/bridges/transfers", OperationID: "getBridgeTransfers", Tag: "transactions", Summary: "Recent bridge transfers with their destination legs and route SLA",
+		Params: []apiParam{
+			queryParam("bridge", "string", "Only this protocol: wormhole, layerzero or cctp."),
+			queryParam("status", "string", "Only transfers with this status: completed, pending or stuck."),
+			limitParam,
+		},
+		Response: apiArray{BridgeTransfer{}}, Errors: []int{400}, Tenant: true},
+	{Method: "GET", Path: "/tx/{hash}", OperationID: "getTransactionByHash", Tag: "transactions", Summary: "Every event of a transaction",
+		Params:   []apiParam{pathParam("hash", "Transaction hash, with or without 0x and in any case, or a Solana signature."), chainParam, historyParam, profileParam},
+		Response: apiArray{Event{}}, Errors: []int{400, 404}, Tenant: true},
+	{Method: "GET", Path: "/tx/{chain}/{hash}", OperationID: "getTransactionDetail", Tag: "transactions", Summary: "A transaction's events with the raw on-chain transaction",
+		Params: []apiParam{pathParam("chain", "Chain, e.g. ethereum or solana."), pathParam("hash", "Transaction hash or Solana signature."),
+			queryParam("network", "string", "Only events on this network."), historyParam, profileParam},
+		Response: TransactionDetail{}, Errors: []int{400, 404}, Tenant: true},
+	{Method: "GET", Path: "/search", OperationID: "search", Tag: "transactions", Summary: "Find events, wallets and tokens from a pasted fragment",
+		Params: []apiParam{queryParam("q", "string", fmt.Sprintf("A tx hash fragment, the start of an address or token symbol, or part of a label; at least %d characters.", minSearchLength)),
+			chainParam, networkParam,
+			queryParam("limit", "integer", fmt.Sprintf("Results of each type (default %d, at most %d).", defaultSearchLimit, maxSearchLimit))},
+		Response: SearchResponse{}, Errors: []int{400}, Tenant: true},
+	{Method: "GET", Path: "/analytics/volume", OperationID: "getVolumeAnalytics", Tag: "analytics", Summary: "Transfer volume per time bucket and asset",
+		Params:   append(analyticsParams[:len(analyticsParams):len(analyticsParams)], queryParam("include_scam", "boolean", "Keep scam tokens, which are left out by default.")),
+		Response: apiSeries{VolumePoint{}}, Errors: []int{400, 500}, Tenant: true},
+	{Method: "GET", Path: "/analytics/active-wallets", OperationID: "getActiveWalletsAnalytics", Tag: "analytics", Summary: "Distinct active wallets per time bucket",
+		Params: analyticsParams, Response: apiSeries{ActiveWalletsPoint{}}, Errors: []int{400, 500}, Tenant: true},
+	{Method: "POST", Path: "/stats/custom", OperationID: "createCustomMetric", Tag: "analytics", Summary: "Define a derived metric maintained as events arrive",
+		Body: MetricDefinition{}, Response: MetricDefinition{}, Status: http.StatusCreated,
+		Headers: map[string]string{"Location": "URL of the metric."}, Errors: []int{400, 500}, Tenant: true},
+	{Method: "GET", Path: "/stats/bridge-fees", OperationID: "getBridgeFeeStats", Tag: "analytics", Summary: "Fees of recent completed bridge transfers by route, in USD",
+		Params:   []apiParam{queryParam("bridge", "string", "Only this protocol: wormhole, layerzero or cctp.")},
+		Response: apiArray{BridgeFeeStats{}}, Errors: []int{400}, Tenant: true},
+	{Method: "GET", Path: "/stats/custom", OperationID: "listCustomMetrics", Tag: "analytics", Summary: "List derived metric definitions",
+		Response: apiArray{MetricDefinition{}}, Tenant: true},
+	{Method: "GET", Path: "/stats/custom/{name}", OperationID: "getCustomMetric", Tag: "analytics", Summary: "Current values of a derived metric",
+		Params: []apiParam{pathParam("name", "Metric name."),
+			queryParam("start", "string", "Only buckets starting at or after this RFC3339 time."),
+			queryParam("end", "string", "Only buckets starting before this RFC3339 time.")},
+		Response: MetricSeries{}, Errors: []int{400, 404}, Tenant: true},
+	{Method: "DELETE", Path: "/stats/custom/{name}", OperationID: "deleteCustomMetric", Tag: "analytics", Summary: "Delete a derived metric",
+		Params: []apiParam{pathParam("name", "Metric name.")}, Status: http.StatusNoContent, Errors: []int{404, 500}, Tenant: true},
+	{Method: "GET", Path: "/chains", OperationID: "listChains", Tag: "chains", Summary: "Chains and networks, whether they are ingested and have an RPC endpoint",
+		Response: apiArray{ChainConfig{}}, Tenant: true},
+	{Method: "GET", Path: "/chains/status", OperationID: "getChainStatus", Tag: "chains", Summary: "Latency and errors of every RPC provider, preferred first",
+		Response: apiArray{ChainStatus{}}, Tenant: true},
+	{Method: "GET", Path: "/chains/{chain}/reorgs", OperationID: "listChainReorgs", Tag: "chains", Summary: "Reorgs that orphaned stored events on a chain, newest first",
+		Params: []apiParam{pathParam("chain", "Chain, e.g. ethereum, eth or solana, or a CAIP-2 chain id such as eip155:1."), networkParam,
+			queryParam("min_depth", "integer", "Only reorgs at least this many blocks deep."),
+			queryParam("start_time", "string", "RFC3339 lower bound on when the reorg was detected."),
+			queryParam("end_time", "string", "RFC3339 upper bound on when the reorg was detected."),
+			limitParam},
+		Response: apiArray{Reorg{}}, Errors: []int{400, 500}, Tenant: true},
+	{Method: "GET", Path: "/labels", OperationID: "listLabels", Tag: "labels", Summary: "List address labels",
+		Params: []apiParam{{Name: "category", In: "query", Type: "string", Enum: []string{LabelExchange, LabelBridge, LabelContract, LabelTeam, LabelSanctioned, LabelOther},
+			Description: "Only labels in this category."}},
+		Response: apiArray{Label{}}, Tenant: true},
+	{Method: "POST", Path: "/labels/import", OperationID: "importLabels", Tag: "labels", Summary: "Bulk-import labels from CSV (address,name,category)",
+		Body: "text/csv", Response: LabelImportResult{}, Errors: []int{400, 401, 403}, Tenant: true, Admin: true},
+	{Method: "GET", Path: "/labels/{address}", OperationID: "getLabel", Tag: "labels", Summary: "Get the label of an address",
+		Params: []apiParam{pathParam("address", "Labeled address.")}, Response: Label{}, Errors: []int{404}, Tenant: true},
+	{Method: "PUT", Path: "/labels/{address}", OperationID: "putLabel", Tag: "labels", Summary: "Create or replace the label of an address",
+		Params: []apiParam{pathParam("address", "Labeled address.")}, Body: Label{}, Response: Label{}, Errors: []int{400, 401, 403, 500}, Tenant: true, Admin: true},
+	{Method: "DELETE", Path: "/labels/{address}", OperationID: "deleteLabel", Tag: "labels", Summary: "Delete the label of an address",
+		Params: []apiParam{pathParam("address", "Labeled address.")}, Status: http.StatusNoContent, Errors: []int{401, 403, 404, 500}, Tenant: true, Admin: true},
+	{Method: "POST", Path: "/queries", OperationID: "createQuery", Tag: "queries", Summary: "Submit an async query",
+		Body: QueryRequest{}, Response: QueryJob{}, Status: http.StatusAccepted,
+		Headers: map[string]string{"Location": "URL to poll for the job's status."}, Errors: []int{400, 503}, Tenant: true},
+	{Method: "GET", Path: "/queries/{id}", OperationID: "getQuery", Tag: "queries", Summary: "Poll an async query",
+		Params: []apiParam{pathParam("id", "Job ID.")}, Response: QueryJob{}, Errors: []int{404}, Tenant: true},
+	{Method: "GET", Path: "/queries/{id}/result", OperationID: "getQueryResult", Tag: "queries", Summary: "Download the result of a succeeded query",
+		Params:   []apiParam{pathParam("id", "Job ID.")},
+		Produces: []string{"application/json", "application/graphml+xml", "text/vnd.graphviz", "text/csv", "application/zip"}, Headers: signatureHeaders, Errors: []int{404, 409}, Tenant: true},
+	{Method: "DELETE", Path: "/queries/{id}", OperationID: "cancelQuery", Tag: "queries", Summary: "Cancel a pending query or delete a finished one",
+		Params: []apiParam{pathParam("id", "Job ID.")}, Status: http.StatusNoContent, Errors: []int{404}, Tenant: true},
+	{Method: "POST", Path: "/share", OperationID: "createShare", Tag: "sharing", Summary: "Create a read-only share link",
+		Body: ShareRequest{}, Response: ShareLink{}, Status: http.StatusCreated, Errors: []int{400, 404, 500}, Tenant: true},
+	{Method: "POST", Path: "/views", OperationID: "createView", Tag: "views", Summary: "Save a named filter set",
+		Body: View{}, Response: View{}, Status: http.StatusCreated,
+		Headers: map[string]string{"Location": "URL of the saved view."}, Errors: []int{400, 500}, Tenant: true},
+	{Method: "GET", Path: "/views", OperationID: "listViews", Tag: "views", Summary: "List saved views, newest first",
+		Response: apiArray{View{}}, Tenant: true},
+	{Method: "GET", Path: "/views/{id}", OperationID: "getView", Tag: "views", Summary: "Get a saved view",
+		Params: []apiParam{pathParam("id", "View ID.")}, Response: View{}, Errors: []int{404}, Tenant: true},
+	{Method: "DELETE", Path: "/views/{id}", OperationID: "deleteView", Tag: "views", Summary: "Delete a saved view",
+		Params: []apiParam{pathParam("id", "View ID.")}, Status: http.StatusNoContent, Errors: []int{404, 500}, Tenant: true},
+	{Method: "GET", Path: "/views/{id}/transactions", OperationID: "getViewTransactions", Tag: "views", Summary: "Events matching a saved view, newest first",
+		Params:   []apiParam{pathParam("id", "View ID."), limitParam, offsetParam, totalParam, profileParam},
+		Response: apiArray{Event{}}, Headers: paginationHeaders, Errors: []int{400, 404}, Tenant: true},
+	{Method: "GET", Path: "/views/{id}/subscribe", OperationID: "subscribeView", Tag: "views", Summary: "Live events matching a saved view (Server-Sent Events)",
+		Params:   append([]apiParam{pathParam("id", "View ID.")}, sseParams...),
+		Produces: []string{"text/event-stream"}, Errors: []int{404}, Tenant: true},
+	{Method: "GET", Path: "/treasuries", OperationID: "listTreasuryPolicies", Tag: "treasuries", Summary: "List treasury allowlist policies by address",
+		Response: apiArray{TreasuryPolicy{}}, Tenant: true},
+	{Method: "GET", Path: "/treasuries/{address}", OperationID: "getTreasuryPolicy", Tag: "treasuries", Summary: "Get a treasury's allowlist policy",
+		Params: []apiParam{treasuryParam}, Response: TreasuryPolicy{}, Errors: []int{404}, Tenant: true},
+	{Method: "PUT", Path: "/treasuries/{address}", OperationID: "putTreasuryPolicy", Tag: "treasuries", Summary: "Put a treasury in allowlist mode, or replace its policy (201 when new)",
+		Params: []apiParam{treasuryParam}, Body: TreasuryPolicy{}, Response: TreasuryPolicy{}, Errors: []int{400, 500}, Tenant: true},
+	{Method: "DELETE", Path: "/treasuries/{address}", OperationID: "deleteTreasuryPolicy", Tag: "treasuries", Summary: "Take a treasury out of allowlist mode",
+		Params: []apiParam{treasuryParam}, Status: http.StatusNoContent, Errors: []int{404, 500}, Tenant: true},
+	{Method: "POST", Path: "/treasuries/{address}/approved", OperationID: "approveTreasuryRecipients", Tag: "treasuries", Summary: "Add addresses to a treasury's approved recipients",
+		Params: []apiParam{treasuryParam}, Body: ApprovedAddresses{}, Response: TreasuryPolicy{}, Errors: []int{400, 404, 500}, Tenant: true},
+	{Method: "DELETE", Path: "/treasuries/{address}/approved/{counterparty}", OperationID: "revokeTreasuryRecipient", Tag: "treasuries", Summary: "Remove an address from a treasury's approved recipients",
+		Params: []apiParam{treasuryParam, pathParam("counterparty", "Approved address.")}, Response: TreasuryPolicy{}, Errors: []int{404, 500}, Tenant: true},
+	{Method: "GET", Path: "/escalation-policies", OperationID: "listEscalationPolicies", Tag: "alerts", Summary: "List alert escalation policies by rule",
+		Response: apiArray{EscalationPolicy{}}, Tenant: true},
+	{Method: "GET", Path: "/escalation-policies/{rule}", OperationID: "getEscalationPolicy", Tag: "alerts", Summary: "Get an alert rule's escalation policy",
+		Params: []apiParam{ruleParam}, Response: EscalationPolicy{}, Errors: []int{404}, Tenant: true},
+	{Method: "PUT", Path: "/escalation-policies/{rule}", OperationID: "putEscalationPolicy", Tag: "alerts", Summary: "Escalate an alert rule through Slack and PagerDuty steps, replacing its policy (201 when new)",
+		Params: []apiParam{ruleParam}, Body: EscalationPolicy{}, Response: EscalationPolicy{}, Errors: []int{400, 500}, Tenant: true},
+	{Method: "DELETE", Path: "/escalation-policies/{rule}", OperationID: "deleteEscalationPolicy", Tag: "alerts", Summary: "Stop escalating an alert rule",
+		Params: []apiParam{ruleParam}, Status: http.StatusNoContent, Errors: []int{404, 500}, Tenant: true},
+	{Method: "GET", Path: "/alerts", OperationID: "listAlerts", Tag: "alerts", Summary: "Alerts being escalated, newest first",
+		Params:   []apiParam{{Name: "status", In: "query", Type: "string", Enum: []string{"open", "acknowledged"}, Description: "Only unacknowledged (open) or acknowledged alerts."}, limitParam},
+		Response: apiArray{AlertEscalation{}}, Errors: []int{400}, Tenant: true},
+	{Method: "POST", Path: "/alerts/{id}/ack", OperationID: "acknowledgeAlert", Tag: "alerts", Summary: "Acknowledge an alert, stopping its escalation",
+		Params: []apiParam{pathParam("id", "Alert (system event) ID.")}, Response: AlertEscalation{}, Errors: []int{404}, Tenant: true},
+	{Method: "GET", Path: "/reports/daily/{date}", OperationID: "getDailyReport", Tag: "alerts",
+		Summary: "The caller's daily report: largest transfers, new sanctioned counterparties, stuck bridge transfers and indexer incidents",
+		Params:  []apiParam{pathParam("date", "UTC day, YYYY-MM-DD.")}, Response: DailyReport{}, Errors: []int{400, 404, 500}, Tenant: true},
+	{Method: "GET", Path: "/plugins", OperationID: "listPlugins", Tag: "plugins", Summary: "List WASM plugins (only with WASM_PLUGINS=true)",
+		Response: apiArray{Plugin{}}, Tenant: true},
+	{Method: "PUT", Path: "/plugins/{name}", OperationID: "putPlugin", Tag: "plugins", Summary: "Upload or replace a WASM plugin",
+		Params: []apiParam{pathParam("name", "Plugin name: lowercase letters, digits, '-' and '_'.")}, Body: "application/wasm",
+		Response: Plugin{}, Errors: []int{400, 401, 403, 500}, Tenant: true, Admin: true},
+	{Method: "DELETE", Path: "/plugins/{name}", OperationID: "deletePlugin", Tag: "plugins", Summary: "Delete a WASM plugin",
+		Params: []apiParam{pathParam("name", "Plugin name.")}, Status: http.StatusNoContent, Errors: []int{401, 403, 404, 500}, Tenant: true, Admin: true},
+	{Method: "GET", Path: "/shared/{token}", OperationID: "getShared", Tag: "sharing", Summary: "Open a share link",
+		Params:   []apiParam{pathParam("token", "Share token."), limitParam, offsetParam, totalParam, profileParam},
+		Response: SharedView{}, Headers: paginationHeaders, Errors: []int{400, 404, 410}},
+	{Method: "GET", Path: "/downloads/{token}", OperationID: "getDownload", Tag: "queries", Summary: "Download a query result through an emailed link",
+		Params:   []apiParam{pathParam("token", "Download token.")},
+		Produces: []string{"application/json", "application/graphml+xml", "text/vnd.graphviz", "text/csv", "application/zip"}, Headers: signatureHeaders, Errors: []int{404, 409, 410}},
+	{Method: "GET", Path: "/public/transactions", OperationID: "getPublicTransactions", Tag: "public", Summary: "Latest transactions from the public mirror (only with PUBLIC_MIRROR=true)",
+		Params: []apiParam{chainParam, networkParam,
+			queryParam("limit", "integer", fmt.Sprintf("Number of events (default %d, at most PUBLIC_MIRROR_EVENTS).", defaultPageSize)), profileParam},
+		Response: apiArray{Event{}}, Headers: mirrorHeaders, Errors: []int{400, 503}},
+	{Method: "GET", Path: "/public/stats", OperationID: "getPublicStats", Tag: "public", Summary: "Activity over the last 24 hours from the public mirror",
+		Response: PublicStats{}, Headers: mirrorHeaders, Errors: []int{400, 503}},
+	{Method: "GET", Path: "/admin/stats", OperationID: "getAdminStats", Tag: "admin", Summary: "Event store statistics",
+		Response: AdminStats{}, Errors: []int{401, 500}, Admin: true},
+	{Method: "POST", Path: "/admin/purge", OperationID: "purgeEvents", Tag: "admin", Summary: "Delete events older than a number of days",
+		Body: PurgeRequest{}, Response: PurgeResult{}, Errors: []int{400, 401, 500}, Admin: true},
+	{Method: "POST", Path: "/admin/diff", OperationID: "diffResults", Tag: "admin", Summary: "Compare the result of a filter on two backends, or on one against an earlier diff's snapshot",
+		Body: DiffRequest{}, Response: DiffResult{}, Errors: []int{400, 401, 404, 500}, Admin: true},
+	{Method: "PUT", Path: "/admin/cache/limits", OperationID: "resizeCache", Tag: "admin", Summary: "Change the in-memory cache limits",
+		Body: CacheLimits{}, Response: CacheResizeResult{}, Errors: []int{400, 401}, Admin: true},
+	{Method: "GET", Path: "/admin/snapshot", OperationID: "getSnapshot", Tag: "admin", Summary: "Snapshot the in-memory cache",
+		Response: apiArray{Event{}}, Errors: []int{401}, Admin: true},
+	{Method: "PUT", Path: "/admin/snapshot", OperationID: "restoreSnapshot", Tag: "admin", Summary: "Replace the in-memory cache with a snapshot",
+		Body: apiArray{Event{}}, Response: CacheStats{}, Errors: []int{400, 401}, Admin: true},
+	{Method: "GET", Path: "/admin/chains", OperationID: "listChainConfigs", Tag: "admin", Summary: "Chains and networks with their RPC endpoints",
+		Response: apiArray{ChainConfig{}}, Errors: []int{401}, Admin: true},
+	{Method: "PUT", Path: "/admin/chains/{chain}/{network}", OperationID: "updateChain", Tag: "admin", Summary: "Enable or disable a network or change its RPC endpoint",
+		Params: []apiParam{pathParam("chain", "Chain, e.g. ethereum."), pathParam("network", "Network, e.g. mainnet.")},
+		Body:   ChainUpdate{}, Response: ChainConfig{}, Errors: []int{400, 401, 500}, Admin: true},
+	{Method: "GET", Path: "/admin/sequences", OperationID: "getSequenceStatus", Tag: "admin", Summary: "Watched wallets checked for missed transactions",
+		Response: apiArray{SequenceStatus{}}, Errors: []int{401}, Admin: true},
+	{Method: "GET", Path: "/admin/explorers", OperationID: "getExplorerStatus", Tag: "admin", Summary: "Explorer APIs used by history imports and the use of their keys",
+		Response: apiArray{ExplorerAPIStatus{}}, Errors: []int{401}, Admin: true},
+	{Method: "GET", Path: "/admin/retention", OperationID: "getRetentionStatus", Tag: "admin", Summary: "Retention policies, the last retention run and the event partitions",
+		Response: RetentionStatus{}, Errors: []int{401}, Admin: true},
+	{Method: "POST", Path: "/admin/retention/run", OperationID: "runRetention", Tag: "admin", Summary: "Start a retention run now",
+		Response: RetentionStatus{}, Status: http.StatusAccepted, Errors: []int{401, 404, 409}, Admin: true},
+	{Method: "GET", Path: "/admin/correlation/state", OperationID: "getCorrelationState", Tag: "admin", Summary: "Why an event was or was not paired with other bridge legs",
+		Params:   []apiParam{queryParam("transfer_id", "string", "Event ID of the transfer.")},
+		Response: CorrelationState{}, Errors: []int{400, 401, 404}, Admin: true},
+	{Method: "GET", Path: "/admin/correlation/overrides", OperationID: "listCorrelationOverrides", Tag: "admin", Summary: "Operator verdicts on bridge leg pairings, most recent first",
+		Response: apiArray{CorrelationOverride{}}, Errors: []int{401}, Admin: true},
+	{Method: "PUT", Path: "/admin/correlation/overrides/{event_id}/{leg_id}", OperationID: "putCorrelationOverride", Tag: "admin", Summary: "Confirm, reject or manually link two events as bridge legs",
+		Params: []apiParam{pathParam("event_id", "Event ID of one leg."), pathParam("leg_id", "Event ID of the other leg.")},
+		Body:   CorrelationVerdict{}, Response: CorrelationOverride{}, Errors: []int{400, 401, 404, 500}, Admin: true},
+	{Method: "DELETE", Path: "/admin/correlation/overrides/{event_id}/{leg_id}", OperationID: "deleteCorrelationOverride", Tag: "admin", Summary: "Remove a verdict, returning the pair to the pairing rules",
+		Params: []apiParam{pathParam("event_id", "Event ID of one leg."), pathParam("leg_id", "Event ID of the other leg.")}, Status: http.StatusNoContent, Errors: []int{401, 404, 500}, Admin: true},
+	{Method: "GET", Path: "/admin/bridges/routes", OperationID: "listBridgeRoutes", Tag: "admin", Summary: "Known bridge routes with expected latency and fees",
+		Response: apiArray{BridgeRoute{}}, Errors: []int{401}, Admin: true},
+	{Method: "PUT", Path: "/admin/bridges/routes/{bridge}/{source_chain}/{dest_chain}", OperationID: "putBridgeRoute", Tag: "admin", Summary: "Add or replace a bridge route",
+		Params: []apiParam{pathParam("bridge", "Bridge protocol."), pathParam("source_chain", "Sending chain."), pathParam("dest_chain", "Receiving chain."),
+			queryParam("token", "string", "Token symbol; omit for the route's default.")},
+		Body: BridgeRouteUpdate{}, Response: BridgeRoute{}, Errors: []int{400, 401, 500}, Admin: true},
+	{Method: "DELETE", Path: "/admin/bridges/routes/{bridge}/{source_chain}/{dest_chain}", OperationID: "deleteBridgeRoute", Tag: "admin", Summary: "Remove a bridge route",
+		Params: []apiParam{pathParam("bridge", "Bridge protocol."), pathParam("source_chain", "Sending chain."), pathParam("dest_chain", "Receiving chain."),
+			queryParam("token", "string", "Token symbol; omit for the route's default.")},
+		Status: http.StatusNoContent, Errors: []int{401, 404, 500}, Admin: true},
+}
+
+// schemaGen builds component schemas from Go types.
+type schemaGen struct {
+	schemas map[string]interface{}
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// schema returns the schema of a value: a Go value, apiArray or apiSeries.
+func (g *schemaGen) schema(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case apiArray:
+		return map[string]interface{}{"type": "array", "items": g.schema(v.Of)}
+	case apiSeries:
+		return map[string]interface{}{"allOf": []interface{}{
+			g.typeSchema(reflect.TypeOf(AnalyticsSeries{})),
+			map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+				"points": map[string]interface{}{"type": "array", "items": g.schema(v.Points)},
+			}},
+		}}
+	}
+	return g.typeSchema(reflect.TypeOf(v))
+}
+
+func (g *schemaGen) typeSchema(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(json.RawMessage(nil)) {
+		return map[string]interface{}{} // any JSON value
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.typeSchema(t.Elem())}
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			g.schemas[t.Name()] = nil // placeholder while the fields are walked
+			g.schemas[t.Name()] = g.object(t)
+		}
+		return schemaRef(t.Name())
+	}
+	return map[string]interface{}{}
+}
+
+// object renders a struct's json fields. Embedded structs, whose fields
+// encoding/json flattens, become an allOf.
+func (g *schemaGen) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	var embedded []interface{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if f.Anonymous && tag == "" {
+			embedded = append(embedded, g.typeSchema(f.Type))
+			continue
+		}
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.typeSchema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	obj := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		obj["required"] = required
+	}
+	if len(embedded) > 0 {
+		return map[string]interface{}{"allOf": append(embedded, obj)}
+	}
+	return obj
+}
+
+// buildOpenAPISpec renders the OpenAPI 3 document for ops.
+func buildOpenAPISpec(ops []apiOperation) map[string]interface{} {
+	g := &schemaGen{schemas: map[string]interface{}{}}
+	errorResponse := g.schema(ErrorResponse{})
+
+	paths := map[string]map[string]interface{}{}
+	for _, op := range ops {
+		var params []interface{}
+		for _, p := range op.Params {
+			schema := map[string]interface{}{"type": p.Type}
+			if len(p.Enum) > 0 {
+				schema["enum"] = p.Enum
+			}
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"required":    p.In == "path",
+				"description": p.Description,
+				"schema":      schema,
+			})
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		content := map[string]interface{}{}
+		if op.Response != nil {
+			content["application/json"] = map[string]interface{}{"schema": g.schema(op.Response)}
+		}
+		for _, ct := range op.Produces {
+			if _, ok := content[ct]; !ok {
+				content[ct] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+			}
+		}
+		if len(content) > 0 {
+			success["content"] = content
+		}
+		if len(op.Headers) > 0 {
+			headers := map[string]interface{}{}
+			for name, description := range op.Headers {
+				headers[name] = map[string]interface{}{"description": description, "schema": map[string]interface{}{"type": "string"}}
+			}
+			success["headers"] = headers
+		}
+		responses := map[string]interface{}{strconv.Itoa(status): success}
+		if op.Accepted != nil {
+			responses[strconv.Itoa(http.StatusAccepted)] = map[string]interface{}{
+				"description": http.StatusText(http.StatusAccepted),
+				"headers": map[string]interface{}{
+					"Retry-After": map[string]interface{}{"description": "Seconds to wait before asking again.", "schema": map[string]interface{}{"type": "integer"}},
+				},
+				"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": g.schema(op.Accepted)}},
+			}
+		}
+		errs := op.Errors
+		if op.Tenant {
+			errs = append(errs[:len(errs):len(errs)], http.StatusUnauthorized)
+		}
+		for _, code := range errs {
+			responses[strconv.Itoa(code)] = map[string]interface{}{
+				"description": http.StatusText(code),
+				"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": errorResponse}},
+			}
+		}
+
+		operation := map[string]interface{}{
+			"operationId": op.OperationID,
+			"tags":        []string{op.Tag},
+			"summary":     op.Summary,
+			"responses":   responses,
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		switch {
+		case op.Admin && op.Tenant:
+			// Writes to shared data take the admin token, next to the
+			// tenant's key once tenants are configured
+			operation["security"] = []interface{}{map[string]interface{}{"tenantKey": []string{}, "adminToken": []string{}},
+				map[string]interface{}{"adminToken": []string{}}, map[string]interface{}{}}
+		case op.Admin:
+			operation["security"] = []interface{}{map[string]interface{}{"adminToken": []string{}}}
+		case op.Tenant:
+			// The empty requirement keeps the key optional for
+			// single-tenant deployments
+			operation["security"] = []interface{}{map[string]interface{}{"tenantKey": []string{}}, map[string]interface{}{}}
+		}
+		switch body := op.Body.(type) {
+		case nil:
+		case string:
+			operation["requestBody"] = map[string]interface{}{"required": true,
+				"content": map[string]interface{}{body: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}}}
+		default:
+			operation["requestBody"] = map[string]interface{}{"required": true,
+				"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": g.schema(body)}}}
+		}
+
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]interface{}{}
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Cross-Chain Transaction Tracker API",
+			"version":     "1.0.0",
+			"description": "Normalized Ethereum and Solana transfer events. Errors are returned as an ErrorResponse.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"adminToken": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "The ADMIN_TOKEN of the API."},
+				"tenantKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key",
+					"description": "A tenant API key from TENANT_API_KEYS; SSE clients may pass it as ?api_key= instead."},
+			},
+		},
+	}
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+// openAPIDocument returns the OpenAPI document, rendered once.
+func openAPIDocument() []byte {
+	openAPIOnce.Do(func() {
+		openAPIJSON, _ = json.MarshalIndent(buildOpenAPISpec(apiOperations), "", "  ")
+	})
+	return openAPIJSON
+}
+
+// serveOpenAPI serves the OpenAPI document.
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPIDocument())
+}
+
+// swaggerUIPage loads Swagger UI from a CDN and points it at /openapi.json.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Cross-Chain Transaction Tracker API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => { window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" }); };
+  </script>
+</body>
+</html>
+`
+
+// serveDocs serves Swagger UI for the OpenAPI document.
+func serveDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(swaggerUIPage))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	r := httptest.NewRecorder()
+	serveOpenAPI(r, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var spec struct {
+		OpenAPI    string                                       `json:"openapi"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if spec.OpenAPI != "3.0.3" || len(spec.Paths) == 0 {
+		t.Fatalf("spec = %s with %d paths", spec.OpenAPI, len(spec.Paths))
+	}
+
+	event := spec.Components.Schemas["Event"]
+	props, _ := event["properties"].(map[string]interface{})
+	if props["event_id"] == nil || props["token"] == nil {
+		t.Fatalf("Event schema = %+v", event)
+	}
+	if req, _ := event["required"].([]interface{}); len(req) == 0 || req[0] != "event_id" {
+		t.Fatalf("Event required = %v", event["required"])
+	}
+	if spec.Components.Schemas["ErrorResponse"] == nil || spec.Components.Schemas["WalletEvent"]["allOf"] == nil {
+		t.Fatalf("missing ErrorResponse or embedded WalletEvent schema")
+	}
+
+	// Every path parameter is declared and every operation is unique
+	seen := map[string]bool{}
+	placeholder := regexp.MustCompile(`\{(\w+)\}`)
+	for path, methods := range spec.Paths {
+		for method, op := range methods {
+			id, _ := op["operationId"].(string)
+			if id == "" || seen[id] {
+				t.Fatalf("%s %s: missing or duplicate operationId %q", method, path, id)
+			}
+			seen[id] = true
+			declared := map[string]bool{}
+			params, _ := op["parameters"].([]interface{})
+			for _, p := range params {
+				p := p.(map[string]interface{})
+				if p["in"] == "path" {
+					declared[p["name"].(string)] = true
+				}
+			}
+			for _, m := range placeholder.FindAllStringSubmatch(path, -1) {
+				if !declared[m[1]] {
+					t.Fatalf("%s %s: path parameter %s not declared", method, path, m[1])
+				}
+			}
+		}
+	}
+
+	list := spec.Paths["/transactions"]["get"]
+	var names []string
+	for _, p := range list["parameters"].([]interface{}) {
+		names = append(names, p.(map[string]interface{})["name"].(string))
+	}
+	if got := strings.Join(names, ","); !strings.Contains(got, "chain,network,token,from,to,status,min_value") {
+		t.Fatalf("/transactions params = %s", got)
+	}
+}
+
+func TestServeDocs(t *testing.T) {
+	r := httptest.NewRecorder()
+	serveDocs(r, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if !strings.HasPrefix(r.Header().Get("Content-Type"), "text/html") || !strings.Contains(r.Body.String(), "/openapi.json") {
+		t.Fatalf("docs page = %q", r.Body.String())
+	}
+}
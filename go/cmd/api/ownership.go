@@ -0,0 +1,544 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ownershipChallengeTTL is how long a verification nonce can be signed.
+	ownershipChallengeTTL = 10 * time.Minute
+	// maxOwnershipChallenges caps the nonces waiting for a signature; the
+	// oldest are dropped first.
+	maxOwnershipChallenges = 10000
+	// maxWalletNotes caps the length of an owned wallet's notes.
+	maxWalletNotes = 10000
+)
+
+// ownershipSchema creates the table verified wallet ownership is persisted
+// in, keyed on the tenant and the wallet's addressKey.
+const ownershipSchema = `
+	CREATE TABLE IF NOT EXISTS wallet_ownership (
+		tenant TEXT NOT NULL DEFAULT '',
+		address TEXT NOT NULL,
+		display_address TEXT NOT NULL,
+		chain TEXT NOT NULL,
+		notes TEXT NOT NULL DEFAULT '',
+		verified_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (tenant, address)
+	);
+`
+
+// walletPrivacySchema adds the privacy settings of owned wallets.
+const walletPrivacySchema = `
+	ALTER TABLE wallet_ownership ADD COLUMN IF NOT EXISTS hide_labels BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE wallet_ownership ADD COLUMN IF NOT EXISTS hide_from_public BOOLEAN NOT NULL DEFAULT FALSE;
+`
+
+// errNotOwned is returned for private features of wallets the caller has
+// not verified.
+var errNotOwned = errors.New("wallet ownership is not verified; sign a challenge from POST /wallet/{address}/verify first")
+
+// ownershipChallenge is a message a wallet signs to prove its owner
+// controls it. Its nonce is single-use and expires after
+// ownershipChallengeTTL.
+type ownershipChallenge struct {
+	tenant, address, chain string
+	nonce, message         string
+	expires                time.Time
+}
+
+// OwnershipProof is the body of POST /wallet/{address}/verify completing a
+// challenge. Without a signature the request issues a new challenge.
+type OwnershipProof struct {
+	Nonce     string `json:"nonce,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// WalletOwnership records that the tenant proved it controls a wallet.
+type WalletOwnership struct {
+	Address    string `json:"address"`
+	Chain      string `json:"chain"`
+	VerifiedAt string `json:"verified_at"`
+	// Notes are private to the owning tenant.
+	Notes   string        `json:"notes,omitempty"`
+	Privacy WalletPrivacy `json:"privacy"`
+	Tenant  string        `json:"-"`
+}
+
+// WalletPrivacy is what an owner withholds about its wallet, and the body
+// of PUT /wallet/{address}/privacy. It matters on multi-tenant
+// deployments, where the other tenants and the public mirror would
+// otherwise see it.
+type WalletPrivacy struct {
+	// HideLabels shows the wallet's label to the tenants owning it only.
+	HideLabels bool `json:"hide_labels"`
+	// HideFromPublic keeps the wallet's transactions out of the public
+	// mirror.
+	HideFromPublic bool `json:"hide_from_public"`
+}
+
+// WalletVerification answers POST /wallet/{address}/verify: a challenge
+// to sign while Verified is false, and the recorded ownership once the
+// signature checked out.
+type WalletVerification struct {
+	Address  string `json:"address"`
+	Chain    string `json:"chain"`
+	Verified bool   `json:"verified"`
+	// Nonce and Message are the challenge; sign Message with the wallet
+	// and post it back with Nonce before ExpiresAt.
+	Nonce      string `json:"nonce,omitempty"`
+	Message    string `json:"message,omitempty"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+	VerifiedAt string `json:"verified_at,omitempty"`
+}
+
+// WalletNotes is the body of PUT /wallet/{address}/notes.
+type WalletNotes struct {
+	Notes string `json:"notes"`
+}
+
+// ownershipMessage is the text wallets sign. It names the address and
+// chain so a signature cannot be replayed for another wallet.
+func ownershipMessage(address, chain, nonce string, issued, expires time.Time) string {
+	return fmt.Sprintf("Cross-chain tracker wallet ownership verification\n\nAddress: %s\nChain: %s\nNonce: %s\nIssued at: %s\nExpires at: %s",
+		address, chain, nonce, issued.UTC().Format(time.RFC3339), expires.UTC().Format(time.RFC3339))
+}
+
+// ownershipKey keys owned wallets on the tenant and addressKey.
+type ownershipKey struct{ tenant, address string }
+
+// WalletOwners issues ownership challenges, verifies their signatures and
+// keeps the wallets each tenant proved it owns, persisted to the
+// wallet_ownership table when a database is attached. Owned wallets unlock
+// private features, such as notes and privacy settings.
+type WalletOwners struct {
+	mu         sync.RWMutex
+	challenges map[string]ownershipChallenge // nonce -> challenge
+	// pending lists the nonces oldest first, for eviction.
+	pending []string
+	owned   map[ownershipKey]WalletOwnership
+	// private merges the privacy settings of every owner of an addressKey,
+	// for the lookups made on each served event.
+	private map[string]WalletPrivacy
+	db      *pgxpool.Pool
+}
+
+func NewWalletOwners() *WalletOwners {
+	return &WalletOwners{
+		challenges: make(map[string]ownershipChallenge),
+		owned:      make(map[ownershipKey]WalletOwnership),
+		private:    make(map[string]WalletPrivacy),
+	}
+}
+
+// AttachDB connects the owners to Postgres and loads the verified wallets.
+func (o *WalletOwners) AttachDB(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx, `SELECT tenant, display_address, chain, notes, hide_labels, hide_from_public, verified_at FROM wallet_ownership`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	owned := make(map[ownershipKey]WalletOwnership)
+	for rows.Next() {
+		var w WalletOwnership
+		var verified time.Time
+		if err := rows.Scan(&w.Tenant, &w.Address, &w.Chain, &w.Notes, &w.Privacy.HideLabels, &w.Privacy.HideFromPublic, &verified); err != nil {
+			return err
+		}
+		w.VerifiedAt = verified.UTC().Format(time.RFC3339)
+		owned[ownershipKey{w.Tenant, addressKey(w.Address)}] = w
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.owned = owned
+	o.private = make(map[string]WalletPrivacy)
+	for key := range owned {
+		o.reindex(key.address)
+	}
+	o.db = db
+	return nil
+}
+
+// Challenge issues tenant a nonce to sign with address's key on chain.
+func (o *WalletOwners) Challenge(tenant, chain, address string, now time.Time) WalletVerification {
+	c := ownershipChallenge{tenant: tenant, address: address, chain: chain, nonce: newRandomID(), expires: now.Add(ownershipChallengeTTL)}
+	c.message = ownershipMessage(address, chain, c.nonce, now, c.expires)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.challenges[c.nonce] = c
+	o.pending = append(o.pending, c.nonce)
+	for len(o.pending) > maxOwnershipChallenges || (len(o.pending) > 0 && o.expired(o.pending[0], now)) {
+		delete(o.challenges, o.pending[0])
+		o.pending = o.pending[1:]
+	}
+	return WalletVerification{Address: address, Chain: chain, Nonce: c.nonce, Message: c.message, ExpiresAt: c.expires.UTC().Format(time.RFC3339)}
+}
+
+// expired reports whether the challenge of nonce is gone or expired.
+// Callers hold the lock.
+func (o *WalletOwners) expired(nonce string, now time.Time) bool {
+	c, ok := o.challenges[nonce]
+	return !ok || !now.Before(c.expires)
+}
+
+// Verify checks proof against the challenge it answers, which is used up
+// either way, and records tenant as address's owner when the signature
+// matches.
+func (o *WalletOwners) Verify(ctx context.Context, tenant, address string, proof OwnershipProof, now time.Time) (WalletOwnership, error) {
+	o.mu.Lock()
+	c, ok := o.challenges[proof.Nonce]
+	if ok && c.tenant == tenant && addressKey(c.address) == addressKey(address) {
+		delete(o.challenges, proof.Nonce)
+	} else {
+		ok = false
+	}
+	o.mu.Unlock()
+	if !ok || !now.Before(c.expires) {
+		return WalletOwnership{}, invalidParam("nonce", "unknown or expired nonce; request a new challenge")
+	}
+	if err := chainAdapter(c.chain).VerifyMessage(c.address, c.message, proof.Signature); err != nil {
+		return WalletOwnership{}, invalidParam("signature", "%v", err)
+	}
+
+	w := WalletOwnership{Address: c.address, Chain: c.chain, VerifiedAt: now.UTC().Format(time.RFC3339), Tenant: tenant}
+	if previous, ok := o.Get(tenant, address); ok {
+		w.Notes, w.Privacy = previous.Notes, previous.Privacy
+	}
+	if err := o.save(ctx, w, now); err != nil {
+		return w, err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.owned[ownershipKey{tenant, addressKey(address)}] = w
+	o.reindex(addressKey(address))
+	return w, nil
+}
+
+// reindex recomputes the merged privacy settings of an addressKey. Callers
+// hold the lock.
+func (o *WalletOwners) reindex(address string) {
+	var merged WalletPrivacy
+	for key, w := range o.owned {
+		if key.address == address {
+			merged.HideLabels = merged.HideLabels || w.Privacy.HideLabels
+			merged.HideFromPublic = merged.HideFromPublic || w.Privacy.HideFromPublic
+		}
+	}
+	if merged == (WalletPrivacy{}) {
+		delete(o.private, address)
+	} else {
+		o.private[address] = merged
+	}
+}
+
+// save persists w when a database is attached.
+func (o *WalletOwners) save(ctx context.Context, w WalletOwnership, verified time.Time) error {
+	if o.db == nil {
+		return nil
+	}
+	_, err := o.db.Exec(ctx, `
+		INSERT INTO wallet_ownership (tenant, address, display_address, chain, notes, hide_labels, hide_from_public, verified_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (tenant, address) DO UPDATE SET display_address = EXCLUDED.display_address, chain = EXCLUDED.chain,
+			notes = EXCLUDED.notes, hide_labels = EXCLUDED.hide_labels, hide_from_public = EXCLUDED.hide_from_public,
+			verified_at = EXCLUDED.verified_at`,
+		w.Tenant, addressKey(w.Address), w.Address, w.Chain, w.Notes, w.Privacy.HideLabels, w.Privacy.HideFromPublic, verified)
+	return err
+}
+
+// Owns reports whether tenant verified it owns address.
+func (o *WalletOwners) Owns(tenant, address string) bool {
+	_, ok := o.Get(tenant, address)
+	return ok
+}
+
+// Get returns tenant's ownership of address.
+func (o *WalletOwners) Get(tenant, address string) (WalletOwnership, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	w, ok := o.owned[ownershipKey{tenant, addressKey(address)}]
+	return w, ok
+}
+
+// List returns the wallets tenant owns, by address.
+func (o *WalletOwners) List(tenant string) []WalletOwnership {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	out := make([]WalletOwnership, 0)
+	for key, w := range o.owned {
+		if key.tenant == tenant {
+			out = append(out, w)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Address < out[j].Address })
+	return out
+}
+
+// SetNotes replaces the notes of a wallet tenant owns, failing with
+// errNotOwned otherwise.
+func (o *WalletOwners) SetNotes(ctx context.Context, tenant, address, notes string) (WalletOwnership, error) {
+	w, ok := o.Get(tenant, address)
+	if !ok {
+		return w, errNotOwned
+	}
+	w.Notes = notes
+	if o.db != nil {
+		if _, err := o.db.Exec(ctx, `UPDATE wallet_ownership SET notes = $3 WHERE tenant = $1 AND address = $2`,
+			tenant, addressKey(address), notes); err != nil {
+			return w, err
+		}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.owned[ownershipKey{tenant, addressKey(address)}] = w
+	return w, nil
+}
+
+// SetPrivacy replaces the privacy settings of a wallet tenant owns,
+// failing with errNotOwned otherwise.
+func (o *WalletOwners) SetPrivacy(ctx context.Context, tenant, address string, privacy WalletPrivacy) (WalletOwnership, error) {
+	w, ok := o.Get(tenant, address)
+	if !ok {
+		return w, errNotOwned
+	}
+	w.Privacy = privacy
+	if o.db != nil {
+		if _, err := o.db.Exec(ctx, `UPDATE wallet_ownership SET hide_labels = $3, hide_from_public = $4 WHERE tenant = $1 AND address = $2`,
+			tenant, addressKey(address), privacy.HideLabels, privacy.HideFromPublic); err != nil {
+			return w, err
+		}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.owned[ownershipKey{tenant, addressKey(address)}] = w
+	o.reindex(addressKey(address))
+	return w, nil
+}
+
+// Privacy returns the privacy settings of address merged over its owners:
+// a setting any owner turned on applies.
+func (o *WalletOwners) Privacy(address string) WalletPrivacy {
+	if o == nil {
+		return WalletPrivacy{}
+	}
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.private[addressKey(address)]
+}
+
+// LabelHidden reports whether address's label is withheld from tenant: an
+// owner hid it and tenant does not own the wallet itself.
+func (o *WalletOwners) LabelHidden(tenant, address string) bool {
+	if o == nil {
+		return false
+	}
+	key := addressKey(address)
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if !o.private[key].HideLabels {
+		return false
+	}
+	_, owner := o.owned[ownershipKey{tenant, key}]
+	return !owner
+}
+
+// Release forgets that tenant owns address, with its notes and privacy
+// settings, and reports whether it did.
+func (o *WalletOwners) Release(ctx context.Context, tenant, address string) (bool, error) {
+	if !o.Owns(tenant, address) {
+		return false, nil
+	}
+	if o.db != nil {
+		if _, err := o.db.Exec(ctx, `DELETE FROM wallet_ownership WHERE tenant = $1 AND address = $2`, tenant, addressKey(address)); err != nil {
+			return false, err
+		}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	key := ownershipKey{tenant, addressKey(address)}
+	_, ok := o.owned[key]
+	delete(o.owned, key)
+	o.reindex(key.address)
+	return ok, nil
+}
+
+func writeOwnershipJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// verifyWallet issues an ownership challenge for the {address} wallet, or
+// completes one when the body carries its nonce and signature.
+func verifyWallet(owners *WalletOwners, w http.ResponseWriter, r *http.Request) {
+	p := newQueryParams(r)
+	chain, _ := p.Network()
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	address, err := pathAddress(r, chain)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if chain == "" {
+		chain = addressChain(address)
+	}
+
+	var proof OwnershipProof
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&proof); err != nil && !errors.Is(err, io.EOF) {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	tenant := tenantFrom(r.Context())
+	if strings.TrimSpace(proof.Signature) == "" {
+		writeOwnershipJSON(w, http.StatusOK, owners.Challenge(tenant, chain, address, time.Now()))
+		return
+	}
+	owned, err := owners.Verify(r.Context(), tenant, address, proof, time.Now())
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to store wallet ownership")
+		httpError(w, "could not store wallet ownership", http.StatusInternalServerError)
+		return
+	}
+	log.WithFields(log.Fields{"tenant": tenant, "address": address, "chain": chain}).Info("wallet ownership verified")
+	writeOwnershipJSON(w, http.StatusOK, WalletVerification{Address: owned.Address, Chain: owned.Chain, Verified: true, VerifiedAt: owned.VerifiedAt})
+}
+
+// listOwnedWallets returns the wallets the caller verified it owns.
+func listOwnedWallets(owners *WalletOwners, w http.ResponseWriter, r *http.Request) {
+	writeOwnershipJSON(w, http.StatusOK, owners.List(tenantFrom(r.Context())))
+}
+
+// releaseWallet forgets the caller's ownership of the {address} wallet.
+func releaseWallet(owners *WalletOwners, w http.ResponseWriter, r *http.Request) {
+	address, err := pathAddress(r, "")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	ok, err := owners.Release(r.Context(), tenantFrom(r.Context()), address)
+	if err != nil {
+		log.WithError(err).Error("failed to release wallet ownership")
+		httpError(w, "could not release wallet ownership", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "wallet ownership not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getWalletNotes returns the caller's private notes on a wallet it owns.
+func getWalletNotes(owners *WalletOwners, w http.ResponseWriter, r *http.Request) {
+	address, err := pathAddress(r, "")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	owned, ok := owners.Get(tenantFrom(r.Context()), address)
+	if !ok {
+		httpError(w, errNotOwned.Error(), http.StatusForbidden)
+		return
+	}
+	writeOwnershipJSON(w, http.StatusOK, WalletNotes{Notes: owned.Notes})
+}
+
+// putWalletNotes replaces the caller's private notes on a wallet it owns.
+func putWalletNotes(owners *WalletOwners, w http.ResponseWriter, r *http.Request) {
+	address, err := pathAddress(r, "")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	var body WalletNotes
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(body.Notes) > maxWalletNotes {
+		badRequest(w, invalidParam("notes", "notes are at most %d bytes", maxWalletNotes))
+		return
+	}
+	owned, err := owners.SetNotes(r.Context(), tenantFrom(r.Context()), address, body.Notes)
+	if errors.Is(err, errNotOwned) {
+		httpError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to store wallet notes")
+		httpError(w, "could not store wallet notes", http.StatusInternalServerError)
+		return
+	}
+	writeOwnershipJSON(w, http.StatusOK, WalletNotes{Notes: owned.Notes})
+}
+
+// getWalletPrivacy returns the caller's privacy settings of a wallet it
+// owns.
+func getWalletPrivacy(owners *WalletOwners, w http.ResponseWriter, r *http.Request) {
+	address, err := pathAddress(r, "")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	owned, ok := owners.Get(tenantFrom(r.Context()), address)
+	if !ok {
+		httpError(w, errNotOwned.Error(), http.StatusForbidden)
+		return
+	}
+	writeOwnershipJSON(w, http.StatusOK, owned.Privacy)
+}
+
+// putWalletPrivacy replaces the caller's privacy settings of a wallet it
+// owns.
+func putWalletPrivacy(owners *WalletOwners, w http.ResponseWriter, r *http.Request) {
+	address, err := pathAddress(r, "")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	var body WalletPrivacy
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	tenant := tenantFrom(r.Context())
+	owned, err := owners.SetPrivacy(r.Context(), tenant, address, body)
+	if errors.Is(err, errNotOwned) {
+		httpError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to store wallet privacy")
+		httpError(w, "could not store wallet privacy", http.StatusInternalServerError)
+		return
+	}
+	log.WithFields(log.Fields{"tenant": tenant, "address": address, "hide_labels": body.HideLabels, "hide_from_public": body.HideFromPublic}).
+		Info("wallet privacy updated")
+	writeOwnershipJSON(w, http.StatusOK, owned.Privacy)
+}
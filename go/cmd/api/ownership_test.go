@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWalletVerificationEndpoints(t *testing.T) {
+	owners := NewWalletOwners()
+	d := big.NewInt(0xC0FFEE)
+	address := secpAddress(d)
+	do := func(method, path, tenant, body string, handler func(*WalletOwners, http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		t.Helper()
+		req := withChiParam(httptest.NewRequest(method, path, strings.NewReader(body)), "address", strings.ToLower(address))
+		req = req.WithContext(withTenant(req.Context(), tenant))
+		r := httptest.NewRecorder()
+		handler(owners, r, req)
+		return r
+	}
+	challenge := func(tenant string) WalletVerification {
+		t.Helper()
+		r := do(http.MethodPost, "/wallet/x/verify", tenant, "", verifyWallet)
+		var v WalletVerification
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil || r.Code != http.StatusOK || v.Verified || v.Nonce == "" {
+			t.Fatalf("challenge: status %d, %+v, %v", r.Code, v, err)
+		}
+		return v
+	}
+
+	// Notes are locked until the wallet is verified
+	if r := do(http.MethodPut, "/wallet/x/notes", "acme", `{"notes": "cold wallet"}`, putWalletNotes); r.Code != http.StatusForbidden {
+		t.Fatalf("notes before verification: status %d", r.Code)
+	}
+
+	c := challenge("acme")
+	if c.Address != address || c.Chain != "ethereum" || !strings.Contains(c.Message, c.Nonce) || !strings.Contains(c.Message, address) {
+		t.Fatalf("challenge = %+v", c)
+	}
+	// A signature by another key uses the nonce up
+	wrong := signEIP191(big.NewInt(42), big.NewInt(7), c.Message)
+	if r := do(http.MethodPost, "/wallet/x/verify", "acme", `{"nonce": "`+c.Nonce+`", "signature": "`+wrong+`"}`, verifyWallet); r.Code != http.StatusBadRequest {
+		t.Fatalf("wrong signer: status %d", r.Code)
+	}
+	sig := signEIP191(d, big.NewInt(0xBEEF), c.Message)
+	if r := do(http.MethodPost, "/wallet/x/verify", "acme", `{"nonce": "`+c.Nonce+`", "signature": "`+sig+`"}`, verifyWallet); r.Code != http.StatusBadRequest {
+		t.Fatalf("reused nonce: status %d", r.Code)
+	}
+
+	c = challenge("acme")
+	sig = signEIP191(d, big.NewInt(0xBEEF), c.Message)
+	// The nonce belongs to the tenant it was issued to
+	if r := do(http.MethodPost, "/wallet/x/verify", "other", `{"nonce": "`+c.Nonce+`", "signature": "`+sig+`"}`, verifyWallet); r.Code != http.StatusBadRequest {
+		t.Fatalf("other tenant's nonce: status %d", r.Code)
+	}
+	r := do(http.MethodPost, "/wallet/x/verify", "acme", `{"nonce": "`+c.Nonce+`", "signature": "`+sig+`"}`, verifyWallet)
+	var v WalletVerification
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil || r.Code != http.StatusOK || !v.Verified || v.VerifiedAt == "" {
+		t.Fatalf("verify: status %d, %+v, %v", r.Code, v, err)
+	}
+	if !owners.Owns("acme", strings.ToLower(address)) || owners.Owns("other", address) {
+		t.Fatal("ownership not recorded for acme alone")
+	}
+
+	if r := do(http.MethodPut, "/wallet/x/notes", "acme", `{"notes": "cold wallet"}`, putWalletNotes); r.Code != http.StatusOK {
+		t.Fatalf("put notes: status %d", r.Code)
+	}
+	if r := do(http.MethodGet, "/wallet/x/notes", "acme", "", getWalletNotes); r.Code != http.StatusOK || !strings.Contains(r.Body.String(), "cold wallet") {
+		t.Fatalf("get notes: status %d: %s", r.Code, r.Body.String())
+	}
+	if r := do(http.MethodGet, "/wallet/x/notes", "other", "", getWalletNotes); r.Code != http.StatusForbidden {
+		t.Fatalf("other tenant's notes: status %d", r.Code)
+	}
+
+	if r := do(http.MethodDelete, "/wallet/x/ownership", "acme", "", releaseWallet); r.Code != http.StatusNoContent {
+		t.Fatalf("release: status %d", r.Code)
+	}
+	if r := do(http.MethodDelete, "/wallet/x/ownership", "acme", "", releaseWallet); r.Code != http.StatusNotFound {
+		t.Fatalf("second release: status %d", r.Code)
+	}
+}
+
+func TestWalletOwnersVerifySolana(t *testing.T) {
+	owners := NewWalletOwners()
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	address := encodeBase58(key.Public().(ed25519.PublicKey))
+	now := time.Now()
+
+	c := owners.Challenge("", "solana", address, now)
+	sig := encodeBase58(ed25519.Sign(key, []byte(c.Message)))
+	if _, err := owners.Verify(context.Background(), "", address, OwnershipProof{Nonce: c.Nonce, Signature: sig}, now.Add(ownershipChallengeTTL)); err == nil {
+		t.Fatal("expired challenge accepted")
+	}
+
+	c = owners.Challenge("", "solana", address, now)
+	sig = encodeBase58(ed25519.Sign(key, []byte(c.Message)))
+	w, err := owners.Verify(context.Background(), "", address, OwnershipProof{Nonce: c.Nonce, Signature: sig}, now)
+	if err != nil || w.Address != address || w.Chain != "solana" {
+		t.Fatalf("verify: %+v, %v", w, err)
+	}
+	if owned := owners.List(""); len(owned) != 1 || owned[0].Address != address {
+		t.Fatalf("owned = %+v", owned)
+	}
+}
+
+func TestWalletPrivacy(t *testing.T) {
+	owners := NewWalletOwners()
+	d := big.NewInt(0xC0FFEE)
+	address := secpAddress(d)
+	now := time.Now()
+	c := owners.Challenge("acme", "ethereum", address, now)
+	if _, err := owners.Verify(context.Background(), "acme", address, OwnershipProof{Nonce: c.Nonce, Signature: signEIP191(d, big.NewInt(0xBEEF), c.Message)}, now); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	labels := NewLabelStore()
+	labels.AttachOwners(owners)
+	if _, err := labels.Put(context.Background(), Label{Address: address, Name: "Acme cold wallet", Category: LabelTeam}); err != nil {
+		t.Fatalf("put label: %v", err)
+	}
+	store := NewEventStore(100, 50)
+	store.AttachLabels(labels)
+	ts := now.UTC().Format(time.RFC3339)
+	for _, ev := range []*Event{makeEvent("1", address, bobAddr, "5", ts, ""), makeEvent("2", aliceAddr, bobAddr, "5", ts, "")} {
+		store.Add(ev)
+	}
+	mirror := NewPublicMirror(store, "", 10, time.Minute)
+	mirror.AttachOwners(owners)
+
+	put := func(tenant, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := withChiParam(httptest.NewRequest(http.MethodPut, "/wallet/x/privacy", strings.NewReader(body)), "address", strings.ToLower(address))
+		r := httptest.NewRecorder()
+		putWalletPrivacy(owners, r, req.WithContext(withTenant(req.Context(), tenant)))
+		return r
+	}
+	if r := put("other", `{"hide_labels": true}`); r.Code != http.StatusForbidden {
+		t.Fatalf("privacy of a wallet the tenant does not own: status %d", r.Code)
+	}
+	if r := put("acme", `{"hide_labels": true}`); r.Code != http.StatusOK {
+		t.Fatalf("hide labels: status %d: %s", r.Code, r.Body.String())
+	}
+
+	if _, ok := labels.Get("other", address); ok || len(labels.List("other", "")) != 0 {
+		t.Fatal("private label visible to another tenant")
+	}
+	if l, ok := labels.Get("acme", strings.ToLower(address)); !ok || l.Name != "Acme cold wallet" {
+		t.Fatalf("owner's label = %+v, %v", l, ok)
+	}
+	ev := makeEvent("3", address, bobAddr, "5", ts, "")
+	if labeled := labels.EnrichOne(ev); labeled.FromLabel != "" {
+		t.Fatalf("deployment-wide event labeled %q", labeled.FromLabel)
+	}
+	ev.Tenant = "acme"
+	if labeled := labels.EnrichOne(ev); labeled.FromLabel != "Acme cold wallet" {
+		t.Fatalf("owner's event labeled %q", labeled.FromLabel)
+	}
+	mirror.Refresh()
+	if events := mirror.snapshot.events; len(events) != 2 || events[0].FromLabel != "" || events[1].FromLabel != "" {
+		t.Fatalf("mirror events = %+v", events)
+	}
+
+	if r := put("acme", `{"hide_labels": true, "hide_from_public": true}`); r.Code != http.StatusOK {
+		t.Fatalf("hide from public: status %d", r.Code)
+	}
+	mirror.Refresh()
+	if events := mirror.snapshot.events; len(events) != 1 || events[0].EventID != "2" {
+		t.Fatalf("mirror events = %+v", events)
+	}
+
+	// Releasing the wallet drops its privacy settings
+	if _, err := owners.Release(context.Background(), "acme", address); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if _, ok := labels.Get("other", address); !ok || owners.Privacy(address) != (WalletPrivacy{}) {
+		t.Fatal("privacy outlived the ownership")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Count returns the number of events a listing for addresses (every event
+// when empty) pages through, from the repository when one is attached and
+// from the cache otherwise.
+func (s *EventStore) Count(addresses []string, filter EventFilter) int {
+	if s.repo != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		n, err := s.repo.Count(ctx, addresses, filter)
+		if err == nil {
+			return n
+		}
+		log.WithError(err).Warn("repository count failed; falling back to in-memory")
+	}
+	n, _ := s.cache.Count(context.Background(), addresses, filter)
+	return n
+}
+
+// eventPage is one limit/offset page of a listing. total is the number of
+// matching events and is only known when counted.
+type eventPage struct {
+	events  []*Event
+	total   int
+	counted bool
+	hasNext bool
+}
+
+// Page fetches the page of a listing for addresses (every event when empty)
+// with fetch. Only filter.IncludeTotal counts the matching events, which
+// scans all of them; otherwise one extra event is fetched to tell whether a
+// next page exists.
+func (s *EventStore) Page(addresses []string, filter EventFilter, fetch func(EventFilter) []*Event) eventPage {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if filter.IncludeTotal {
+		total := s.Count(addresses, filter)
+		return eventPage{events: fetch(filter), total: total, counted: true, hasNext: filter.Offset+limit < total}
+	}
+	probe := filter
+	probe.Limit = limit + 1
+	events := fetch(probe)
+	if len(events) > limit {
+		return eventPage{events: events[:limit], hasNext: true}
+	}
+	return eventPage{events: events}
+}
+
+// setPaginationHeaders sets RFC 5988 Link headers with rel="next" and
+// rel="prev" for a limit/offset page of a GET listing, and X-Total-Count
+// when the page was counted. Links keep the request's other query
+// parameters. It must be called before the body is written.
+func setPaginationHeaders(w http.ResponseWriter, r *http.Request, filter EventFilter, page eventPage) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if page.counted {
+		w.Header().Set("X-Total-Count", strconv.Itoa(page.total))
+	}
+
+	var links []string
+	if page.hasNext {
+		links = append(links, pageLink(r.URL, limit, filter.Offset+limit, "next"))
+	}
+	if filter.Offset > 0 {
+		prev := filter.Offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, pageLink(r.URL, limit, prev, "prev"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// pageLink renders one Link header value pointing at the page of u starting
+// at offset.
+func pageLink(u *url.URL, limit, offset int, rel string) string {
+	q := u.Query()
+	q.Set("limit", strconv.Itoa(limit))
+	q.Set("offset", strconv.Itoa(offset))
+	return fmt.Sprintf(`<%s?%s>; rel="%s"`, u.Path, q.Encode(), rel)
+}
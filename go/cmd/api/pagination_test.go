@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestPaginationHeaders(t *testing.T) {
+	store := NewEventStore(1000, 100)
+	now := time.Now().UTC()
+	for i := 0; i < 5; i++ {
+		ts := now.Add(time.Duration(-i) * time.Minute).Format(time.RFC3339)
+		store.Add(makeEvent(strconv.Itoa(i), aliceAddr, bobAddr, "1", ts, ""))
+	}
+
+	cases := []struct {
+		query, link string
+	}{
+		{"limit=2", `</transactions?chain=ethereum&limit=2&offset=2>; rel="next"`},
+		{"limit=2&offset=1", `</transactions?chain=ethereum&limit=2&offset=3>; rel="next", </transactions?chain=ethereum&limit=2&offset=0>; rel="prev"`},
+		{"limit=2&offset=4", `</transactions?chain=ethereum&limit=2&offset=2>; rel="prev"`},
+		{"limit=10", ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRecorder()
+		getTransactions(store, r, httptest.NewRequest(http.MethodGet, "/transactions?chain=ethereum&"+c.query, nil))
+		if got := r.Header().Get("X-Total-Count"); got != "5" {
+			t.Fatalf("%s: X-Total-Count = %q, want 5", c.query, got)
+		}
+		if got := r.Header().Get("Link"); got != c.link {
+			t.Fatalf("%s: Link = %q, want %q", c.query, got, c.link)
+		}
+
+		// With include_total=false the next link comes from fetching one
+		// extra event, and no total is sent
+		r = httptest.NewRecorder()
+		getTransactions(store, r, httptest.NewRequest(http.MethodGet, "/transactions?chain=ethereum&include_total=false&"+c.query, nil))
+		if got := r.Header().Get("X-Total-Count"); got != "" {
+			t.Fatalf("%s: X-Total-Count = %q, want none", c.query, got)
+		}
+		if got, want := r.Header().Get("Link"), strings.ReplaceAll(c.link, "?chain=ethereum&", "?chain=ethereum&include_total=false&"); got != want {
+			t.Fatalf("%s: uncounted Link = %q, want %q", c.query, got, want)
+		}
+	}
+
+	// Filters apply to the total
+	r := httptest.NewRecorder()
+	getTransactions(store, r, httptest.NewRequest(http.MethodGet, "/transactions?chain=solana", nil))
+	if got := r.Header().Get("X-Total-Count"); got != "0" {
+		t.Fatalf("filtered X-Total-Count = %q, want 0", got)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/wallet/{address}/transactions", func(w http.ResponseWriter, r *http.Request) {
+		getWalletTransactions(store, w, r)
+	})
+	// Looked up by its checksummed form, alice matches case-insensitively
+	wallet := checksumAddress(aliceAddr)
+	r = httptest.NewRecorder()
+	router.ServeHTTP(r, httptest.NewRequest(http.MethodGet, "/wallet/"+wallet+"/transactions?limit=3", nil))
+	if got := r.Header().Get("X-Total-Count"); got != "5" {
+		t.Fatalf("wallet X-Total-Count = %q, want 5", got)
+	}
+	if want := `</wallet/` + wallet + `/transactions?limit=3&offset=3>; rel="next"`; r.Header().Get("Link") != want {
+		t.Fatalf("wallet Link = %q, want %q", r.Header().Get("Link"), want)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Payload shapes a live stream subscriber picks with ?payload=, to trade
+// event detail for bandwidth on high-volume subscriptions.
+const (
+	// PayloadFull streams events as the REST API returns them.
+	PayloadFull = "full"
+	// PayloadCompact streams the fields of the compact response profile.
+	PayloadCompact = "compact"
+	// PayloadIDsOnly streams event IDs only; clients fetch the events
+	// they need from GET /transactions/{event_id}.
+	PayloadIDsOnly = "ids-only"
+)
+
+// payloadFields lists the fields of each shape but full.
+var payloadFields = map[string][]string{
+	PayloadCompact: profileFields[ProfileCompact],
+	PayloadIDsOnly: {"event_id"},
+}
+
+// parsePayloadShape reads ?payload=, which defaults to full.
+func parsePayloadShape(r *http.Request) (string, error) {
+	p := newQueryParams(r)
+	shape := p.Enum("payload", PayloadFull, PayloadCompact, PayloadIDsOnly)
+	if shape == "" {
+		shape = PayloadFull
+	}
+	return shape, p.Err()
+}
+
+// payloadShapes encodes an event frame's data in every shape but full, or
+// returns nil for other frames, such as status changes and system notices,
+// which every subscriber receives whole.
+func payloadShapes(data []byte) map[string][]byte {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil
+	}
+	if _, typed := all["type"]; typed || all["event_id"] == nil {
+		return nil
+	}
+	shapes := make(map[string][]byte, len(payloadFields))
+	for shape, fields := range payloadFields {
+		shapes[shape] = selectFields(all, fields)
+	}
+	return shapes
+}
+
+// payload returns the frame's data in shape.
+func (f Frame) payload(shape string) []byte {
+	if data, ok := f.Shapes[shape]; ok {
+		return data
+	}
+	return f.Data
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPayloadShapes(t *testing.T) {
+	ev := makeEvent("eth:1", aliceAddr, bobAddr, "1000", "2025-01-01T00:00:00Z", "USDC")
+	ev.Annotations = map[string]json.RawMessage{"note": json.RawMessage(`"x"`)}
+	data, _ := json.Marshal(ev)
+	shapes := payloadShapes(data)
+
+	if got := string(shapes[PayloadIDsOnly]); got != `{"event_id":"eth:1"}` {
+		t.Fatalf("ids-only = %s", got)
+	}
+	var compact map[string]json.RawMessage
+	if err := json.Unmarshal(shapes[PayloadCompact], &compact); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if _, ok := compact["token"]; !ok || compact["annotations"] != nil || len(compact) > len(profileFields[ProfileCompact]) {
+		t.Fatalf("compact = %s", shapes[PayloadCompact])
+	}
+	if f := (Frame{Data: data, Shapes: shapes}); string(f.payload(PayloadFull)) != string(data) {
+		t.Fatalf("full payload = %s", f.payload(PayloadFull))
+	}
+
+	// Status changes and notices are sent whole in every shape
+	change, _ := json.Marshal(StatusChange{Type: "status", EventID: "eth:1", Status: StatusOrphaned})
+	if shapes := payloadShapes(change); shapes != nil {
+		t.Fatalf("status change shapes = %v", shapes)
+	}
+	if f := (Frame{Data: change}); string(f.payload(PayloadIDsOnly)) != string(change) {
+		t.Fatalf("status change payload = %s", f.payload(PayloadIDsOnly))
+	}
+}
+
+func TestSSEStreamsChosenPayloadShape(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	ev := makeEvent("eth:1", aliceAddr, bobAddr, "1000", "2025-01-01T00:00:00Z", "")
+	data, _ := json.Marshal(ev)
+	hub.PublishEvent(ev, data)
+	waitUntil := time.Now().Add(time.Second)
+	for time.Now().Before(waitUntil) {
+		hub.mu.Lock()
+		n := len(hub.replay.since(replayCursor{}, ""))
+		hub.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	tw := newTestRW()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go serveSSE(hub, tw, httptest.NewRequest(http.MethodGet, "/events/subscribe?since=0&payload=ids-only", nil).WithContext(ctx))
+	select {
+	case b := <-tw.writes:
+		if !strings.Contains(string(b), `data: {"event_id":"eth:1"}`) {
+			t.Fatalf("expected an ids-only frame, got %q", b)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("frame was not replayed")
+	}
+
+	rec := httptest.NewRecorder()
+	serveSSE(hub, rec, httptest.NewRequest(http.MethodGet, "/events/subscribe?payload=tiny", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown payload = %d, want 400", rec.Code)
+	}
+}
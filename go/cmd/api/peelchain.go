@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPeelMaxHops  = 10
+	maxPeelMaxHops      = 50
+	defaultPeelMinRatio = 0.7
+	// minPeelHopsForPattern is the number of hops after which a chain of
+	// send-most transfers is considered a full peel-chain pattern.
+	minPeelHopsForPattern = 3
+)
+
+// PeelHop is a single step of a peel chain: the bulk of the funds moving to
+// the next wallet while a small remainder is "peeled" off or kept.
+type PeelHop struct {
+	EventID    string  `json:"event_id"`
+	Chain      string  `json:"chain"`
+	TxHash     string  `json:"tx_hash"`
+	Timestamp  string  `json:"timestamp"`
+	From       string  `json:"from"`
+	To         string  `json:"to"`
+	Value      string  `json:"value"`
+	Received   string  `json:"received"`
+	Peeled     float64 `json:"peeled"`
+	Ratio      float64 `json:"ratio"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Journey is the ordered path of hops followed from a flagged wallet along with
+// an overall confidence that the path is a peel chain.
+type Journey struct {
+	Origin     string    `json:"origin"`
+	Terminal   string    `json:"terminal"`
+	Token      string    `json:"token,omitempty"`
+	Hops       []PeelHop `json:"hops"`
+	Confidence float64   `json:"confidence"`
+}
+
+// PeelChainOptions tunes the peel-chain detector.
+type PeelChainOptions struct {
+	Chain    string
+	Network  string
+	MaxHops  int
+	MinRatio float64
+	// Tenant restricts the trace to one tenant's events.
+	Tenant string
+}
+
+// DetectPeelChain follows the largest outgoing transfer from a flagged wallet,
+// hop by hop, for as long as each wallet forwards most of what it received and
+// keeps (or peels off) only a small remainder. The first hop has no known
+// inflow, so it is taken as the starting point of the journey.
+func DetectPeelChain(store *EventStore, origin string, opts PeelChainOptions) Journey {
+	if opts.MaxHops <= 0 {
+		opts.MaxHops = defaultPeelMaxHops
+	}
+	if opts.MinRatio <= 0 || opts.MinRatio >= 1 {
+		opts.MinRatio = defaultPeelMinRatio
+	}
+
+	journey := Journey{Origin: origin, Terminal: origin, Hops: []PeelHop{}}
+	visited := map[string]bool{addressKey(origin): true}
+
+	current := origin
+	// prev is the previous hop; later hops must move the same asset, so
+	// their raw amounts compare exactly
+	var prev *Event
+	var inflow *big.Int
+	var after time.Time
+	for len(journey.Hops) < opts.MaxHops {
+		next, amount := largestOutgoing(store, current, opts, prev, after)
+		if next == nil || visited[addressKey(next.To)] || amount.Raw.Sign() <= 0 {
+			break
+		}
+
+		hop := PeelHop{
+			EventID:   next.EventID,
+			Chain:     next.Chain,
+			TxHash:    next.TxHash,
+			Timestamp: next.Timestamp,
+			From:      next.From,
+			To:        next.To,
+			Value:     next.Value,
+		}
+		if prev == nil {
+			// Nothing is known about the origin's inflow; the first hop only
+			// establishes the amount and asset being traced.
+			if next.Token != nil {
+				journey.Token = next.Token.Symbol
+			}
+			hop.Received = next.Value
+			hop.Ratio = 1
+			hop.Confidence = 1
+		} else {
+			ratio, _ := new(big.Rat).SetFrac(amount.Raw, inflow).Float64()
+			// A hop forwarding more than it received or less than the
+			// threshold is not a peel.
+			if ratio < opts.MinRatio || ratio >= 1 {
+				break
+			}
+			hop.Received = inflow.String()
+			hop.Peeled, _ = new(big.Float).SetInt(new(big.Int).Sub(inflow, amount.Raw)).Float64()
+			hop.Ratio = ratio
+			hop.Confidence = peelHopConfidence(ratio, opts.MinRatio)
+		}
+
+		journey.Hops = append(journey.Hops, hop)
+		journey.Terminal = next.To
+		visited[addressKey(next.To)] = true
+		current = next.To
+		prev = next
+		inflow = amount.Raw
+		if ts, err := time.Parse(time.RFC3339, next.Timestamp); err == nil {
+			after = ts
+		}
+	}
+
+	journey.Confidence = journeyConfidence(journey.Hops)
+	return journey
+}
+
+// largestOutgoing returns the largest transfer sent by address at or after
+// the given time, on the chain, network and tenant of opts, with its
+// amount. When asset is set only transfers of the same asset on the same
+// chain and network are considered; otherwise, for the first hop from the
+// origin, amounts of different assets are compared in whole units.
+// Transfers whose amount cannot be read are skipped.
+func largestOutgoing(store *EventStore, address string, opts PeelChainOptions, asset *Event, after time.Time) (*Event, Amount) {
+	events := store.GetByWallet(address, EventFilter{
+		Chain:   opts.Chain,
+		Network: opts.Network,
+		Tenant:  opts.Tenant,
+		From:    address,
+		Limit:   maxEventsPerWallet,
+	})
+
+	var best *Event
+	var bestAmount Amount
+	var bestWhole *big.Rat
+	for _, ev := range events {
+		if asset != nil && !sameAsset(ev, asset) {
+			continue
+		}
+		if !after.IsZero() {
+			if ts, err := time.Parse(time.RFC3339, ev.Timestamp); err == nil && ts.Before(after) {
+				continue
+			}
+		}
+		amount, ok := eventAmount(ev)
+		if !ok {
+			continue
+		}
+		whole := amount.Whole()
+		if best == nil || whole.Cmp(bestWhole) > 0 {
+			best, bestAmount, bestWhole = ev, amount, whole
+		}
+	}
+	return best, bestAmount
+}
+
+// sameAsset reports whether a and b move the same asset: the native
+// currency or the same token of one chain and network.
+func sameAsset(a, b *Event) bool {
+	if !strings.EqualFold(a.Chain, b.Chain) || a.Network != b.Network || (a.Token == nil) != (b.Token == nil) {
+		return false
+	}
+	return a.Token == nil || (a.Token.Symbol == b.Token.Symbol && addressKey(a.Token.Address) == addressKey(b.Token.Address))
+}
+
+// peelHopConfidence scores a hop higher the closer it is to forwarding almost
+// everything: a hop at the threshold scores 0.5, one keeping almost nothing
+// approaches 1.
+func peelHopConfidence(ratio, minRatio float64) float64 {
+	return 0.5 + 0.5*(ratio-minRatio)/(1-minRatio)
+}
+
+// journeyConfidence averages the confidence of the peel hops (excluding the
+// first, untested hop) and discounts journeys too short to show a repeated
+// pattern.
+func journeyConfidence(hops []PeelHop) float64 {
+	if len(hops) < 2 {
+		return 0
+	}
+	var sum float64
+	for _, h := range hops[1:] {
+		sum += h.Confidence
+	}
+	peels := len(hops) - 1
+	conf := sum / float64(peels)
+	if peels < minPeelHopsForPattern {
+		conf *= float64(peels) / float64(minPeelHopsForPattern)
+	}
+	return conf
+}
+
+// getPeelChain traces a peel chain starting at the flagged wallet.
+func getPeelChain(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	p := newQueryParams(r)
+	chain, network := p.Network()
+	opts := PeelChainOptions{
+		Chain:    chain,
+		Network:  network,
+		MaxHops:  p.Int("max_hops", 0, 1, maxPeelMaxHops),
+		MinRatio: p.Float("min_ratio", 0, 0, 1),
+		Tenant:   tenantFrom(r.Context()),
+	}
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	address, err := pathAddress(r, opts.Chain)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	journey := DetectPeelChain(store, address, opts)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(journey)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDetectPeelChain(t *testing.T) {
+	store := NewEventStore(1000, 100)
+	base := time.Now().Add(-time.Hour).UTC()
+	ts := func(i int) string { return base.Add(time.Duration(i) * time.Minute).Format(time.RFC3339) }
+
+	// thief -> a (100), a -> b (90, peels 10), b -> c (85, peels 5),
+	// c -> d (80, peels 5); d -> e (20) is not a peel and ends the chain.
+	store.Add(makeEvent("1", "thief", "a", "100", ts(0), ""))
+	store.Add(makeEvent("2", "a", "b", "90", ts(1), ""))
+	store.Add(makeEvent("3", "a", "cashout", "10", ts(1), ""))
+	store.Add(makeEvent("4", "b", "c", "85", ts(2), ""))
+	store.Add(makeEvent("5", "c", "d", "80", ts(3), ""))
+	store.Add(makeEvent("6", "d", "e", "20", ts(4), ""))
+
+	j := DetectPeelChain(store, "THIEF", PeelChainOptions{})
+	if j.Origin != "THIEF" || j.Terminal != "d" {
+		t.Fatalf("unexpected origin/terminal: %s -> %s", j.Origin, j.Terminal)
+	}
+	if len(j.Hops) != 4 {
+		t.Fatalf("expected 4 hops, got %d: %+v", len(j.Hops), j.Hops)
+	}
+	if j.Hops[1].Peeled != 10 {
+		t.Fatalf("expected 10 peeled at hop 1, got %v", j.Hops[1].Peeled)
+	}
+	if j.Confidence <= 0.5 || j.Confidence > 1 {
+		t.Fatalf("expected confidence in (0.5, 1], got %v", j.Confidence)
+	}
+}
+
+func TestDetectPeelChainComparesAmountsExactly(t *testing.T) {
+	store := NewEventStore(1000, 100)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	// 1 SOL outweighs 0.5 of an 18-decimal token despite the smaller raw
+	// value, and the trace then stays with SOL
+	store.Add(makeEvent("1", "thief", "a", "1000000000", ts, ""))
+	store.Add(makeEvent("2", "thief", "decoy", "500000000000000000", ts, "USDC"))
+	store.Add(makeEvent("3", "a", "decoy", "999999999999", ts, "USDC"))
+	// Amounts beyond float64 precision still differ
+	store.Add(makeEvent("4", "a", "b", "900000000", ts, ""))
+	store.Add(makeEvent("5", "b", "c", "900000000000000000000000001", ts, ""))
+	store.Add(makeEvent("6", "b", "d", "900000000000000000000000000", ts, ""))
+
+	j := DetectPeelChain(store, "thief", PeelChainOptions{MinRatio: 0.5})
+	if len(j.Hops) != 2 || j.Hops[0].To != "a" || j.Hops[1].To != "b" || j.Hops[1].Received != "1000000000" || j.Hops[1].Peeled != 100000000 {
+		t.Fatalf("unexpected journey: %+v", j)
+	}
+	if next, _ := largestOutgoing(store, "b", PeelChainOptions{}, nil, time.Time{}); next == nil || next.To != "c" {
+		t.Fatalf("largest outgoing = %+v, want the transfer to c", next)
+	}
+}
+
+func TestDetectPeelChainShortJourneyDiscounted(t *testing.T) {
+	store := NewEventStore(1000, 100)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	store.Add(makeEvent("1", "x", "y", "100", ts, ""))
+	store.Add(makeEvent("2", "y", "z", "95", ts, ""))
+
+	j := DetectPeelChain(store, "x", PeelChainOptions{})
+	if len(j.Hops) != 2 {
+		t.Fatalf("expected 2 hops, got %d", len(j.Hops))
+	}
+	if j.Confidence >= j.Hops[1].Confidence {
+		t.Fatalf("expected single-peel journey to be discounted, got %v", j.Confidence)
+	}
+}
+
+func TestGetPeelChainHandler(t *testing.T) {
+	store := NewEventStore(1000, 100)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	store.Add(makeEvent("1", aliceAddr, bobAddr, "100", ts, ""))
+
+	req := httptest.NewRequest(http.MethodGet, "/wallet/"+aliceAddr+"/peel-chain?max_hops=5", nil)
+	req = withChiParam(req, "address", aliceAddr)
+	r := httptest.NewRecorder()
+	getPeelChain(store, r, req)
+	if r.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", r.Code)
+	}
+	var j Journey
+	if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(j.Hops) != 1 || j.Confidence != 0 {
+		t.Fatalf("expected a single untested hop with zero confidence, got %+v", j)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Batch writer defaults, overridable with BATCH_INSERT_SIZE,
+// BATCH_INSERT_INTERVAL and BATCH_INSERT_BUFFER.
+const (
+	defaultBatchSize     = 500
+	defaultBatchInterval = time.Second
+	defaultBatchBuffer   = 10000
+)
+
+var errBatchWriterClosed = errors.New("batch writer closed")
+
+// BatchWriter sits between the event consumer and the repository: events
+// are queued in a bounded buffer and written with InsertBatch when a batch
+// fills up or the flush interval elapses. Add blocks while the buffer is
+// full, so a slow database pushes back on the consumer instead of growing
+// memory without bound. Transient write errors are retried; a batch the
+// database rejects is written one event at a time, so only the events at
+// fault are dead-lettered.
+type BatchWriter struct {
+	repo     EventRepository
+	size     int
+	interval time.Duration
+	retry    *PersistRetry
+
+	queue   chan *Event
+	flushCh chan chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	// closing is held for reading by Add while it enqueues and for writing
+	// by Close, so no event can be queued after the final drain.
+	closing   sync.RWMutex
+	closeOnce sync.Once
+
+	depth    int64
+	batches  uint64
+	written  uint64
+	failed   uint64
+	rejected uint64
+	blocked  uint64
+}
+
+// NewBatchWriter starts a writer that flushes batches of up to size events
+// to repo every interval, buffering at most capacity events. Non-positive
+// arguments fall back to the defaults.
+func NewBatchWriter(repo EventRepository, size int, interval time.Duration, capacity int) *BatchWriter {
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	if interval <= 0 {
+		interval = defaultBatchInterval
+	}
+	if capacity <= 0 {
+		capacity = defaultBatchBuffer
+	}
+	w := &BatchWriter{
+		repo:     repo,
+		size:     size,
+		interval: interval,
+		queue:    make(chan *Event, capacity),
+		flushCh:  make(chan chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// AttachRetry retries and dead-letters failed writes with r. It is meant
+// to be called before events are added.
+func (w *BatchWriter) AttachRetry(r *PersistRetry) {
+	w.retry = r
+}
+
+// Add queues a copy of ev for the next batch, blocking while the buffer is
+// full until ctx is done.
+func (w *BatchWriter) Add(ctx context.Context, ev *Event) error {
+	w.closing.RLock()
+	defer w.closing.RUnlock()
+	select {
+	case <-w.stop:
+		return errBatchWriterClosed
+	default:
+	}
+
+	cp := *ev
+	select {
+	case w.queue <- &cp:
+	default:
+		atomic.AddUint64(&w.blocked, 1)
+		select {
+		case w.queue <- &cp:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	atomic.AddInt64(&w.depth, 1)
+	return nil
+}
+
+// Flush writes everything queued so far and waits for it to be stored.
+func (w *BatchWriter) Flush(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case w.flushCh <- reply:
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting events, writes the remaining buffer and waits for
+// the writer to finish or ctx to be done.
+func (w *BatchWriter) Close(ctx context.Context) error {
+	w.closeOnce.Do(func() {
+		w.closing.Lock()
+		close(w.stop)
+		w.closing.Unlock()
+	})
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Failed returns the number of events dropped because their batch failed
+// to write with a transient error. Events the database rejects are not
+// counted: writing them again would fail again.
+func (w *BatchWriter) Failed() uint64 {
+	return atomic.LoadUint64(&w.failed)
+}
+
+// Depth returns the number of events queued but not yet written.
+func (w *BatchWriter) Depth() int {
+	return int(atomic.LoadInt64(&w.depth))
+}
+
+func (w *BatchWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	batch := make([]*Event, 0, w.size)
+	write := func() {
+		if len(batch) > 0 {
+			w.write(batch)
+			batch = make([]*Event, 0, w.size)
+		}
+	}
+	drain := func() {
+		for {
+			select {
+			case ev := <-w.queue:
+				batch = append(batch, ev)
+				if len(batch) >= w.size {
+					write()
+				}
+			default:
+				write()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case ev := <-w.queue:
+			batch = append(batch, ev)
+			if len(batch) >= w.size {
+				write()
+			}
+		case <-ticker.C:
+			write()
+		case reply := <-w.flushCh:
+			drain()
+			close(reply)
+		case <-w.stop:
+			drain()
+			return
+		}
+	}
+}
+
+func (w *BatchWriter) write(batch []*Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := w.retry.Do(ctx, func(ctx context.Context) error { return w.repo.InsertBatch(ctx, batch) })
+	atomic.AddInt64(&w.depth, -int64(len(batch)))
+	atomic.AddUint64(&w.batches, 1)
+	if err == nil {
+		atomic.AddUint64(&w.written, uint64(len(batch)))
+		log.WithFields(log.Fields{"events": len(batch), "duration": time.Since(start)}).Debug("persisted event batch")
+		return
+	}
+	if persistClass(err) == PersistTransient {
+		atomic.AddUint64(&w.failed, uint64(len(batch)))
+		log.WithError(err).WithField("events", len(batch)).Warn("failed to persist event batch")
+		return
+	}
+	log.WithError(err).WithField("events", len(batch)).Warn("event batch rejected; writing its events one at a time")
+	for _, ev := range batch {
+		ev := ev
+		err := w.retry.Do(ctx, func(ctx context.Context) error { return w.repo.Insert(ctx, ev) })
+		switch {
+		case err == nil:
+			atomic.AddUint64(&w.written, 1)
+		case persistClass(err) == PersistTransient:
+			atomic.AddUint64(&w.failed, 1)
+			log.WithError(err).WithField("event_id", ev.EventID).Warn("failed to persist event")
+		default:
+			atomic.AddUint64(&w.rejected, 1)
+			if !w.retry.DeadLetter(ctx, ev, err) {
+				log.WithError(err).WithField("event_id", ev.EventID).Error("event rejected by the database")
+			}
+		}
+	}
+}
+
+// WriteMetrics writes the writer's buffer and throughput counters in the
+// Prometheus text format.
+func (w *BatchWriter) WriteMetrics(out io.Writer) {
+	metrics := []struct {
+		name, kind, help string
+		value            interface{}
+	}{
+		{"tracker_persist_buffer_depth", "gauge", "Events queued for persistence but not yet written.", w.Depth()},
+		{"tracker_persist_buffer_capacity", "gauge", "Maximum events queued before the consumer is blocked.", cap(w.queue)},
+		{"tracker_persist_batches_total", "counter", "Batches written to the repository.", atomic.LoadUint64(&w.batches)},
+		{"tracker_persist_events_total", "counter", "Events written to the repository.", atomic.LoadUint64(&w.written)},
+		{"tracker_persist_failed_events_total", "counter", "Events dropped because their batch failed to write.", atomic.LoadUint64(&w.failed)},
+		{"tracker_persist_rejected_events_total", "counter", "Events dropped because the database rejected them.", atomic.LoadUint64(&w.rejected)},
+		{"tracker_persist_blocked_total", "counter", "Events that waited for buffer space.", atomic.LoadUint64(&w.blocked)},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+}
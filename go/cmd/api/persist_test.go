@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingRepository records the size of every batch it stores.
+type recordingRepository struct {
+	*MemoryRepository
+	mu      sync.Mutex
+	batches []int
+	block   chan struct{}
+}
+
+func (r *recordingRepository) InsertBatch(ctx context.Context, events []*Event) error {
+	if r.block != nil {
+		<-r.block
+	}
+	r.mu.Lock()
+	r.batches = append(r.batches, len(events))
+	r.mu.Unlock()
+	return r.MemoryRepository.InsertBatch(ctx, events)
+}
+
+func (r *recordingRepository) sizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.batches...)
+}
+
+func TestBatchWriterFlushesOnSizeAndClose(t *testing.T) {
+	repo := &recordingRepository{MemoryRepository: NewMemoryRepository(100, 50)}
+	w := NewBatchWriter(repo, 2, time.Hour, 10)
+	ctx := context.Background()
+
+	for _, id := range []string{"1", "2", "3"} {
+		if err := w.Add(ctx, makeEvent(id, "a", "b", "1", "", "")); err != nil {
+			t.Fatalf("add %s: %v", id, err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(repo.sizes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := repo.sizes(); len(got) != 1 || got[0] != 2 {
+		t.Fatalf("batches after full batch = %v, want [2]", got)
+	}
+
+	if err := w.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if got := repo.sizes(); len(got) != 2 || got[1] != 1 {
+		t.Fatalf("batches after close = %v, want [2 1]", got)
+	}
+	if recent, _ := repo.Recent(ctx, EventFilter{}); len(recent) != 3 {
+		t.Fatalf("stored %d events, want 3", len(recent))
+	}
+	if w.Depth() != 0 {
+		t.Fatalf("depth after close = %d, want 0", w.Depth())
+	}
+	if err := w.Add(ctx, makeEvent("4", "a", "b", "1", "", "")); err != errBatchWriterClosed {
+		t.Fatalf("add after close = %v, want errBatchWriterClosed", err)
+	}
+}
+
+func TestBatchWriterFlush(t *testing.T) {
+	repo := &recordingRepository{MemoryRepository: NewMemoryRepository(100, 50)}
+	w := NewBatchWriter(repo, 100, time.Hour, 10)
+	defer w.Close(context.Background())
+	ctx := context.Background()
+
+	_ = w.Add(ctx, makeEvent("1", "a", "b", "1", "", ""))
+	if err := w.Flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if ev, ok, _ := repo.ByID(ctx, "1"); !ok || ev.EventID != "1" {
+		t.Fatal("event not stored after flush")
+	}
+}
+
+func TestBatchWriterBackpressure(t *testing.T) {
+	repo := &recordingRepository{MemoryRepository: NewMemoryRepository(100, 50), block: make(chan struct{})}
+	w := NewBatchWriter(repo, 1, time.Hour, 1)
+
+	// The first event is taken by the blocked writer, the second fills the
+	// buffer and the third must wait
+	ctx := context.Background()
+	_ = w.Add(ctx, makeEvent("1", "a", "b", "1", "", ""))
+	for deadline := time.Now().Add(2 * time.Second); len(w.queue) > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	_ = w.Add(ctx, makeEvent("2", "a", "b", "1", "", ""))
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := w.Add(waitCtx, makeEvent("3", "a", "b", "1", "", "")); err != context.DeadlineExceeded {
+		t.Fatalf("add to full buffer = %v, want deadline exceeded", err)
+	}
+
+	rec := httptest.NewRecorder()
+	metricsHandler(metricSources{batch: w}, rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{"tracker_persist_buffer_depth 2", "tracker_persist_buffer_capacity 1", "tracker_persist_blocked_total 1"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics missing %q:\n%s", want, body)
+		}
+	}
+
+	close(repo.block)
+	if err := w.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if recent, _ := repo.Recent(ctx, EventFilter{}); len(recent) != 2 {
+		t.Fatalf("stored %d events, want 2", len(recent))
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5/pgconn"
+	log "github.com/sirupsen/logrus"
+)
+
+// Classes of persistence errors.
+const (
+	// PersistTransient errors may succeed when retried: lost connections,
+	// timeouts, deadlocks, serialization failures and an overloaded or
+	// restarting database.
+	PersistTransient = "transient"
+	// PersistConflict errors are unique constraint violations other than
+	// the event_id one inserts skip: the row collides with a stored one.
+	PersistConflict = "conflict"
+	// PersistPermanent errors fail the same way every time, such as values
+	// the schema rejects.
+	PersistPermanent = "permanent"
+)
+
+// Persistence retry defaults, overridable with PERSIST_RETRY_ATTEMPTS and
+// PERSIST_RETRY_BACKOFF.
+const (
+	defaultPersistAttempts = 4
+	defaultPersistBackoff  = 200 * time.Millisecond
+)
+
+// deadLetterKey is the Redis list failed events are pushed on, newest
+// first; deadLetterMax bounds it.
+const (
+	deadLetterKey = "cross_chain_dead_letters"
+	deadLetterMax = 10000
+)
+
+// PersistError is a persistence failure with its class.
+type PersistError struct {
+	Class string
+	Err   error
+}
+
+func (e *PersistError) Error() string { return e.Class + ": " + e.Err.Error() }
+func (e *PersistError) Unwrap() error { return e.Err }
+
+// persistClass returns the class of a persistence error: the one recorded
+// on a PersistError, or else the result of ClassifyPersistError.
+func persistClass(err error) string {
+	var pe *PersistError
+	if errors.As(err, &pe) {
+		return pe.Class
+	}
+	return ClassifyPersistError(err)
+}
+
+// ClassifyPersistError tells transient persistence errors from permanent
+// ones and from constraint conflicts. Postgres errors are classified by
+// SQLSTATE and SQLite errors by result code; errors the database never
+// answered, and unknown ones, are transient.
+func ClassifyPersistError(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "23505":
+			return PersistConflict
+		// serialization_failure, deadlock_detected, lock_not_available
+		case pgErr.Code == "40001", pgErr.Code == "40P01", pgErr.Code == "55P03":
+			return PersistTransient
+		// connection exceptions, insufficient resources, operator
+		// intervention (shutdowns, cannot connect now) and system errors
+		case strings.HasPrefix(pgErr.Code, "08"), strings.HasPrefix(pgErr.Code, "53"),
+			strings.HasPrefix(pgErr.Code, "57"), strings.HasPrefix(pgErr.Code, "58"):
+			return PersistTransient
+		default:
+			return PersistPermanent
+		}
+	}
+	var sqliteErr interface{ Code() int }
+	if errors.As(err, &sqliteErr) {
+		switch code := sqliteErr.Code(); {
+		// SQLITE_CONSTRAINT_PRIMARYKEY, SQLITE_CONSTRAINT_UNIQUE
+		case code == 1555 || code == 2067:
+			return PersistConflict
+		// SQLITE_BUSY, SQLITE_LOCKED, SQLITE_IOERR
+		case code&0xff == 5 || code&0xff == 6 || code&0xff == 10:
+			return PersistTransient
+		default:
+			return PersistPermanent
+		}
+	}
+	return PersistTransient
+}
+
+// DeadLetter is an event that could not be stored, with why.
+type DeadLetter struct {
+	Event    *Event `json:"event"`
+	Class    string `json:"class"`
+	Error    string `json:"error"`
+	FailedAt string `json:"failed_at"`
+}
+
+// deadLetterQueue keeps events that failed permanently;
+// redisDeadLetterQueue is the production implementation.
+type deadLetterQueue interface {
+	Push(ctx context.Context, letter DeadLetter) error
+}
+
+type redisDeadLetterQueue struct{ rdb *redis.Client }
+
+func (q redisDeadLetterQueue) Push(ctx context.Context, letter DeadLetter) error {
+	payload, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	pipe := q.rdb.TxPipeline()
+	pipe.LPush(ctx, deadLetterKey, payload)
+	pipe.LTrim(ctx, deadLetterKey, 0, deadLetterMax-1)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// PersistRetry retries transient persistence errors with exponential
+// backoff, routes events that failed otherwise to a dead-letter queue and
+// counts both. A nil PersistRetry tries once and dead-letters nothing.
+type PersistRetry struct {
+	attempts int
+	backoff  time.Duration
+	dlq      deadLetterQueue
+
+	transient, conflict, permanent uint64
+	retries, deadLettered          uint64
+}
+
+// NewPersistRetry makes up to attempts tries, waiting backoff after the
+// first failure and twice as long after each further one. dlq may be nil.
+func NewPersistRetry(attempts int, backoff time.Duration, dlq deadLetterQueue) *PersistRetry {
+	if attempts <= 0 {
+		attempts = 1
+	}
+	return &PersistRetry{attempts: attempts, backoff: backoff, dlq: dlq}
+}
+
+// Do runs write until it succeeds, fails with an error that is not
+// transient, runs out of attempts or ctx is done. The error returned is a
+// *PersistError carrying its class.
+func (p *PersistRetry) Do(ctx context.Context, write func(ctx context.Context) error) error {
+	attempts, backoff := 1, time.Duration(0)
+	if p != nil {
+		attempts, backoff = p.attempts, p.backoff
+	}
+	for attempt := 1; ; attempt++ {
+		err := write(ctx)
+		if err == nil {
+			return nil
+		}
+		class := persistClass(err)
+		p.count(class)
+		if class != PersistTransient || attempt >= attempts || ctx.Err() != nil {
+			return &PersistError{Class: class, Err: err}
+		}
+		atomic.AddUint64(&p.retries, 1)
+		select {
+		case <-ctx.Done():
+			return &PersistError{Class: class, Err: err}
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (p *PersistRetry) count(class string) {
+	if p == nil {
+		return
+	}
+	switch class {
+	case PersistTransient:
+		atomic.AddUint64(&p.transient, 1)
+	case PersistConflict:
+		atomic.AddUint64(&p.conflict, 1)
+	default:
+		atomic.AddUint64(&p.permanent, 1)
+	}
+}
+
+// DeadLetter routes ev, which failed with err, to the dead-letter queue
+// when err is not transient, and reports whether it did. Transient errors
+// are left to the caller, since the event may still be stored later.
+func (p *PersistRetry) DeadLetter(ctx context.Context, ev *Event, err error) bool {
+	class := persistClass(err)
+	if p == nil || p.dlq == nil || class == PersistTransient {
+		return false
+	}
+	letter := DeadLetter{Event: ev, Class: class, Error: err.Error(), FailedAt: time.Now().UTC().Format(time.RFC3339)}
+	if err := p.dlq.Push(ctx, letter); err != nil {
+		log.WithError(err).WithField("event_id", ev.EventID).Error("failed to dead-letter event")
+		return false
+	}
+	atomic.AddUint64(&p.deadLettered, 1)
+	log.WithFields(log.Fields{"event_id": ev.EventID, "class": class}).Warn("event dead-lettered: " + err.Error())
+	return true
+}
+
+// WriteMetrics writes the error, retry and dead-letter counters in the
+// Prometheus text format.
+func (p *PersistRetry) WriteMetrics(out io.Writer) {
+	fmt.Fprintf(out, "# HELP tracker_persist_errors_total Persistence errors by class.\n# TYPE tracker_persist_errors_total counter\n")
+	for _, c := range []struct {
+		class string
+		n     *uint64
+	}{{PersistTransient, &p.transient}, {PersistConflict, &p.conflict}, {PersistPermanent, &p.permanent}} {
+		fmt.Fprintf(out, "tracker_persist_errors_total{class=%q} %d\n", c.class, atomic.LoadUint64(c.n))
+	}
+	fmt.Fprintf(out, "# HELP tracker_persist_retries_total Writes retried after a transient error.\n# TYPE tracker_persist_retries_total counter\ntracker_persist_retries_total %d\n",
+		atomic.LoadUint64(&p.retries))
+	fmt.Fprintf(out, "# HELP tracker_persist_dead_lettered_total Events routed to the dead-letter queue.\n# TYPE tracker_persist_dead_lettered_total counter\ntracker_persist_dead_lettered_total %d\n",
+		atomic.LoadUint64(&p.deadLettered))
+}
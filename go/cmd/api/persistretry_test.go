@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// sqliteCodeError mimics the result code the SQLite driver reports.
+type sqliteCodeError int
+
+func (e sqliteCodeError) Error() string { return fmt.Sprintf("sqlite error %d", int(e)) }
+func (e sqliteCodeError) Code() int     { return int(e) }
+
+func TestClassifyPersistError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{&pgconn.PgError{Code: "23505"}, PersistConflict},
+		{&pgconn.PgError{Code: "40P01"}, PersistTransient},
+		{fmt.Errorf("insert: %w", &pgconn.PgError{Code: "57P01"}), PersistTransient},
+		{&pgconn.PgError{Code: "22001"}, PersistPermanent},
+		{&pgconn.PgError{Code: "23502"}, PersistPermanent},
+		{sqliteCodeError(2067), PersistConflict},
+		{sqliteCodeError(5), PersistTransient},
+		{sqliteCodeError(19), PersistPermanent},
+		{context.DeadlineExceeded, PersistTransient},
+		{errors.New("connection reset by peer"), PersistTransient},
+	} {
+		if got := ClassifyPersistError(tc.err); got != tc.want {
+			t.Errorf("ClassifyPersistError(%v) = %s, want %s", tc.err, got, tc.want)
+		}
+	}
+}
+
+// memoryDeadLetters collects dead letters.
+type memoryDeadLetters struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+func (q *memoryDeadLetters) Push(ctx context.Context, letter DeadLetter) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.letters = append(q.letters, letter)
+	return nil
+}
+
+func TestPersistRetryRetriesTransientErrors(t *testing.T) {
+	retry := NewPersistRetry(3, time.Millisecond, nil)
+	calls := 0
+	err := retry.Do(context.Background(), func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return &pgconn.PgError{Code: "40001"}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("err = %v after %d calls, want success on the third", err, calls)
+	}
+
+	calls = 0
+	err = retry.Do(context.Background(), func(context.Context) error {
+		calls++
+		return &pgconn.PgError{Code: "22P02"}
+	})
+	var pe *PersistError
+	if !errors.As(err, &pe) || pe.Class != PersistPermanent || calls != 1 {
+		t.Fatalf("err = %v after %d calls, want one permanent failure", err, calls)
+	}
+
+	var out bytes.Buffer
+	retry.WriteMetrics(&out)
+	for _, want := range []string{`tracker_persist_errors_total{class="transient"} 2`, `tracker_persist_errors_total{class="permanent"} 1`, "tracker_persist_retries_total 2"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+// rejectingRepository rejects every batch holding the event "bad", and
+// that event on its own.
+type rejectingRepository struct {
+	*MemoryRepository
+}
+
+func (r rejectingRepository) Insert(ctx context.Context, ev *Event) error {
+	if ev.EventID == "bad" {
+		return &pgconn.PgError{Code: "22001", Message: "value too long"}
+	}
+	return r.MemoryRepository.Insert(ctx, ev)
+}
+
+func (r rejectingRepository) InsertBatch(ctx context.Context, events []*Event) error {
+	for _, ev := range events {
+		if ev.EventID == "bad" {
+			return &pgconn.PgError{Code: "22001", Message: "value too long"}
+		}
+	}
+	return r.MemoryRepository.InsertBatch(ctx, events)
+}
+
+func TestBatchWriterDeadLettersRejectedEvents(t *testing.T) {
+	repo := rejectingRepository{NewMemoryRepository(100, 50)}
+	dlq := &memoryDeadLetters{}
+	w := NewBatchWriter(repo, 10, time.Hour, 10)
+	w.AttachRetry(NewPersistRetry(2, time.Millisecond, dlq))
+	ctx := context.Background()
+
+	for _, id := range []string{"1", "bad", "2"} {
+		_ = w.Add(ctx, makeEvent(id, "a", "b", "1", "", ""))
+	}
+	if err := w.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if recent, _ := repo.Recent(ctx, EventFilter{}); len(recent) != 2 {
+		t.Fatalf("stored %d events, want the 2 good ones", len(recent))
+	}
+	// A rejected event would fail again, so it does not fail checkpoints
+	if w.Failed() != 0 {
+		t.Fatalf("failed = %d, want 0", w.Failed())
+	}
+	if len(dlq.letters) != 1 || dlq.letters[0].Event.EventID != "bad" || dlq.letters[0].Class != PersistPermanent {
+		t.Fatalf("dead letters = %+v", dlq.letters)
+	}
+	var out bytes.Buffer
+	w.WriteMetrics(&out)
+	if !strings.Contains(out.String(), "tracker_persist_rejected_events_total 1") {
+		t.Fatalf("metrics:\n%s", out.String())
+	}
+}
+
+func TestIngestAcknowledgesRejectedEvents(t *testing.T) {
+	store := NewEventStore(100, 50)
+	store.AttachRepository(rejectingRepository{NewMemoryRepository(100, 50)})
+	dlq := &memoryDeadLetters{}
+	store.AttachPersistRetry(NewPersistRetry(2, time.Millisecond, dlq))
+	allowAll, _ := ParseNetworkAllowlist("")
+	handle := ingestEvents(store, NewHub(), allowAll, nil, nil, nil, nil, nil, nil, nil)
+
+	payload := `{"event_id":"bad","chain":"ethereum","network":"mainnet","from":"` + aliceAddr + `","to":"` + bobAddr + `","value":"5"}`
+	if err := handle(context.Background(), []byte(payload)); err != nil {
+		t.Fatalf("handle = %v, want the rejected event acknowledged", err)
+	}
+	if _, ok, _ := store.cache.ByID(context.Background(), "bad"); ok {
+		t.Fatal("rejected event was cached")
+	}
+	if len(dlq.letters) != 1 {
+		t.Fatalf("dead letters = %+v", dlq.letters)
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+const (
+	defaultPluginMemoryMB = 16
+	defaultPluginTimeout  = 100 * time.Millisecond
+	// maxPluginBytes caps the size of an uploaded module.
+	maxPluginBytes = 10 << 20
+	// maxPluginOutput caps the result a plugin can return for one event.
+	maxPluginOutput = 1 << 20
+	// wasmPageSize is the size of a WebAssembly memory page.
+	wasmPageSize = 64 << 10
+)
+
+// pluginsSchema creates the table uploaded plugins are persisted in.
+const pluginsSchema = `
+	CREATE TABLE IF NOT EXISTS plugins (
+		tenant TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL,
+		module BYTEA NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (tenant, name)
+	);
+`
+
+var pluginName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Plugin describes an uploaded WASM plugin.
+type Plugin struct {
+	Name      string `json:"name"`
+	Size      int    `json:"size"`
+	SHA256    string `json:"sha256"`
+	UpdatedAt string `json:"updated_at"`
+	// Tenant owns the plugin, which only runs on the tenant's events; a
+	// plugin without a tenant runs on every event.
+	Tenant string `json:"-"`
+}
+
+// pluginResult is the JSON a plugin returns for an event.
+type pluginResult struct {
+	Drop        bool                       `json:"drop"`
+	Annotations map[string]json.RawMessage `json:"annotations"`
+}
+
+type loadedPlugin struct {
+	Plugin
+	compiled wazero.CompiledModule
+	// runs counts the calls in flight, so a replaced or deleted plugin is
+	// closed once they finish rather than under them
+	runs sync.WaitGroup
+}
+
+// retire closes lp's module once its runs finish. lp must already be
+// removed from Plugins, so no run can start after the wait begins.
+func (lp *loadedPlugin) retire() {
+	go func() {
+		lp.runs.Wait()
+		lp.compiled.Close(context.Background())
+	}()
+}
+
+// Plugins runs sandboxed WASM enrichment and filter plugins on ingested
+// events. A plugin is a module without imports that exports its memory as
+// "memory" and two functions:
+//
+//	alloc(size i32) i32               returns a buffer for the event JSON
+//	enrich(ptr i32, len i32) i64      returns result_ptr<<32 | result_len
+//
+// The result is a JSON object with "annotations" to merge into the event
+// and "drop" to discard it; a zero result leaves the event unchanged. Each
+// call gets a fresh instance, bounded in memory by the runtime and in time
+// by the timeout.
+type Plugins struct {
+	runtime wazero.Runtime
+	timeout time.Duration
+
+	mu      sync.RWMutex
+	plugins map[string]map[string]*loadedPlugin // tenant -> name -> plugin
+	db      *pgxpool.Pool
+}
+
+// NewPlugins creates a plugin runtime whose instances get at most memoryMB
+// of memory and timeout per event.
+func NewPlugins(memoryMB int, timeout time.Duration) *Plugins {
+	cfg := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(memoryMB << 20 / wasmPageSize)).
+		WithCloseOnContextDone(true)
+	return &Plugins{
+		runtime: wazero.NewRuntimeWithConfig(context.Background(), cfg),
+		timeout: timeout,
+		plugins: make(map[string]map[string]*loadedPlugin),
+	}
+}
+
+// AttachDB connects the plugins to Postgres and loads the stored ones.
+// Modules that no longer compile are logged and skipped.
+func (p *Plugins) AttachDB(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx, `SELECT tenant, name, module, updated_at FROM plugins`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var loaded []*loadedPlugin
+	for rows.Next() {
+		var tenant, name string
+		var module []byte
+		var updated time.Time
+		if err := rows.Scan(&tenant, &name, &module, &updated); err != nil {
+			return err
+		}
+		lp, err := p.compile(ctx, tenant, name, module)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"tenant": tenant, "plugin": name}).Warn("skipping plugin that does not compile")
+			continue
+		}
+		lp.UpdatedAt = updated.UTC().Format(time.RFC3339)
+		loaded = append(loaded, lp)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	for _, lp := range loaded {
+		p.setLocked(lp)
+	}
+	p.db = db
+	p.mu.Unlock()
+	return nil
+}
+
+// compile validates module against the plugin interface.
+func (p *Plugins) compile(ctx context.Context, tenant, name string, module []byte) (*loadedPlugin, error) {
+	compiled, err := p.runtime.CompileModule(ctx, module)
+	if err != nil {
+		return nil, fmt.Errorf("invalid module: %w", err)
+	}
+	fail := func(format string, args ...interface{}) (*loadedPlugin, error) {
+		compiled.Close(ctx)
+		return nil, fmt.Errorf(format, args...)
+	}
+	if imports := compiled.ImportedFunctions(); len(imports) > 0 {
+		module, fn, _ := imports[0].Import()
+		return fail("plugins cannot import host functions, found %s.%s", module, fn)
+	}
+	if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+		return fail("module must export its memory as \"memory\"")
+	}
+	exports := compiled.ExportedFunctions()
+	for fn, sig := range map[string][2][]api.ValueType{
+		"alloc":  {{api.ValueTypeI32}, {api.ValueTypeI32}},
+		"enrich": {{api.ValueTypeI32, api.ValueTypeI32}, {api.ValueTypeI64}},
+	} {
+		def, ok := exports[fn]
+		if !ok || !sameTypes(def.ParamTypes(), sig[0]) || !sameTypes(def.ResultTypes(), sig[1]) {
+			return fail("module must export %s with the plugin signature", fn)
+		}
+	}
+	sum := sha256.Sum256(module)
+	return &loadedPlugin{
+		Plugin: Plugin{
+			Name:   name,
+			Size:   len(module),
+			SHA256: hex.EncodeToString(sum[:]),
+			Tenant: tenant,
+		},
+		compiled: compiled,
+	}, nil
+}
+
+func sameTypes(a, b []api.ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Plugins) setLocked(lp *loadedPlugin) {
+	byName := p.plugins[lp.Tenant]
+	if byName == nil {
+		byName = make(map[string]*loadedPlugin)
+		p.plugins[lp.Tenant] = byName
+	}
+	old, ok := byName[lp.Name]
+	byName[lp.Name] = lp
+	if ok {
+		old.retire()
+	}
+}
+
+// Put compiles and stores a plugin for tenant, replacing one of the same
+// name.
+func (p *Plugins) Put(ctx context.Context, tenant, name string, module []byte) (Plugin, error) {
+	if !pluginName.MatchString(name) {
+		return Plugin{}, invalidParam("name", "name must be 1-64 lowercase letters, digits, '-' or '_'")
+	}
+	lp, err := p.compile(ctx, tenant, name, module)
+	if err != nil {
+		return Plugin{}, invalidParam("module", "%v", err)
+	}
+	lp.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if p.db != nil {
+		_, err := p.db.Exec(ctx, `
+			INSERT INTO plugins (tenant, name, module, updated_at) VALUES ($1, $2, $3, NOW())
+			ON CONFLICT (tenant, name) DO UPDATE SET module = EXCLUDED.module, updated_at = NOW()
+		`, tenant, name, module)
+		if err != nil {
+			lp.compiled.Close(ctx)
+			return Plugin{}, err
+		}
+	}
+	p.mu.Lock()
+	p.setLocked(lp)
+	p.mu.Unlock()
+	return lp.Plugin, nil
+}
+
+// List returns tenant's plugins sorted by name.
+func (p *Plugins) List(tenant string) []Plugin {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]Plugin, 0, len(p.plugins[tenant]))
+	for _, lp := range p.plugins[tenant] {
+		out = append(out, lp.Plugin)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Delete removes a plugin of tenant and reports whether it existed.
+func (p *Plugins) Delete(ctx context.Context, tenant, name string) (bool, error) {
+	if p.db != nil {
+		if _, err := p.db.Exec(ctx, `DELETE FROM plugins WHERE tenant = $1 AND name = $2`, tenant, name); err != nil {
+			return false, err
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	lp, ok := p.plugins[tenant][name]
+	if ok {
+		delete(p.plugins[tenant], name)
+		lp.retire()
+	}
+	return ok, nil
+}
+
+// forEvent returns the plugins that run on ev: the deployment-wide ones,
+// then those of the event's tenant, each sorted by name. Each counts as
+// running until the caller calls runs.Done on it.
+func (p *Plugins) forEvent(ev *Event) []*loadedPlugin {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	tenants := []string{""}
+	if ev.Tenant != "" {
+		tenants = append(tenants, ev.Tenant)
+	}
+	var out []*loadedPlugin
+	for _, tenant := range tenants {
+		byName := p.plugins[tenant]
+		names := make([]string, 0, len(byName))
+		for name := range byName {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			byName[name].runs.Add(1)
+			out = append(out, byName[name])
+		}
+	}
+	return out
+}
+
+// Apply runs the plugins for ev in turn, merging their annotations into
+// it, and reports whether any annotated the event and whether one dropped
+// it. A plugin that fails, runs out of time or memory, or returns invalid
+// JSON is logged and skipped.
+func (p *Plugins) Apply(ctx context.Context, ev *Event) (annotated, drop bool) {
+	if p == nil {
+		return false, false
+	}
+	plugins := p.forEvent(ev)
+	defer func() {
+		for _, lp := range plugins {
+			lp.runs.Done()
+		}
+	}()
+	for _, lp := range plugins {
+		res, err := p.run(ctx, lp, ev)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"plugin": lp.Name, "tenant": lp.Tenant, "event_id": ev.EventID}).Warn("plugin failed")
+			continue
+		}
+		if res.Drop {
+			return annotated, true
+		}
+		if len(res.Annotations) > 0 {
+			if ev.Annotations == nil {
+				ev.Annotations = make(map[string]json.RawMessage, len(res.Annotations))
+			}
+			for name, value := range res.Annotations {
+				ev.Annotations[name] = value
+			}
+			annotated = true
+		}
+	}
+	return annotated, false
+}
+
+// run calls a fresh instance of lp with the event JSON.
+func (p *Plugins) run(ctx context.Context, lp *loadedPlugin, ev *Event) (pluginResult, error) {
+	var res pluginResult
+	input, err := json.Marshal(ev)
+	if err != nil {
+		return res, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	// An empty name lets instances of the same module run concurrently
+	mod, err := p.runtime.InstantiateModule(ctx, lp.compiled, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return res, err
+	}
+	defer mod.Close(context.Background())
+
+	alloc, err := mod.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return res, fmt.Errorf("alloc: %w", err)
+	}
+	ptr := uint32(alloc[0])
+	if !mod.Memory().Write(ptr, input) {
+		return res, errors.New("alloc returned a buffer outside memory")
+	}
+	out, err := mod.ExportedFunction("enrich").Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return res, fmt.Errorf("enrich: %w", err)
+	}
+	if out[0] == 0 {
+		return res, nil
+	}
+	resPtr, resLen := uint32(out[0]>>32), uint32(out[0])
+	if resLen > maxPluginOutput {
+		return res, fmt.Errorf("result of %d bytes exceeds %d", resLen, maxPluginOutput)
+	}
+	data, ok := mod.Memory().Read(resPtr, resLen)
+	if !ok {
+		return res, errors.New("result is outside memory")
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return res, fmt.Errorf("decode result: %w", err)
+	}
+	return res, nil
+}
+
+// requirePluginOwner lets tenants manage their own plugins. A plugin
+// without a tenant runs on every event, so managing those takes the admin
+// token, and is refused when none is configured.
+func requirePluginOwner(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		admin := requireAdminToken(token)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case tenantFrom(r.Context()) != "":
+				next.ServeHTTP(w, r)
+			case token != "":
+				admin.ServeHTTP(w, r)
+			default:
+				httpError(w, "plugins without a tenant can only be changed with ADMIN_TOKEN", http.StatusForbidden)
+			}
+		})
+	}
+}
+
+// putPlugin uploads a WASM module as the caller's plugin {name}.
+func putPlugin(plugins *Plugins, w http.ResponseWriter, r *http.Request) {
+	module, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPluginBytes))
+	if err != nil {
+		httpError(w, fmt.Sprintf("module exceeds %d bytes", maxPluginBytes), http.StatusBadRequest)
+		return
+	}
+	plugin, err := plugins.Put(r.Context(), tenantFrom(r.Context()), chi.URLParam(r, "name"), module)
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to store plugin")
+		httpError(w, "could not store plugin", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(plugin)
+}
+
+// listPlugins returns the caller's plugins.
+func listPlugins(plugins *Plugins, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(plugins.List(tenantFrom(r.Context())))
+}
+
+// deletePlugin removes one of the caller's plugins.
+func deletePlugin(plugins *Plugins, w http.ResponseWriter, r *http.Request) {
+	ok, err := plugins.Delete(r.Context(), tenantFrom(r.Context()), chi.URLParam(r, "name"))
+	if err != nil {
+		log.WithError(err).Error("failed to delete plugin")
+		httpError(w, "could not delete plugin", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "plugin not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pluginModule assembles a plugin that returns result, stored at offset
+// 1024 of its memory, for every event. A looping plugin never returns.
+func pluginModule(result string, loop bool) []byte {
+	uleb := func(v uint64) []byte {
+		var out []byte
+		for {
+			b := byte(v & 0x7f)
+			v >>= 7
+			if v == 0 {
+				return append(out, b)
+			}
+			out = append(out, b|0x80)
+		}
+	}
+	sleb := func(v int64) []byte {
+		var out []byte
+		for {
+			b := byte(v & 0x7f)
+			v >>= 7
+			if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+				return append(out, b)
+			}
+			out = append(out, b|0x80)
+		}
+	}
+	vec := func(items ...[]byte) []byte {
+		out := uleb(uint64(len(items)))
+		for _, it := range items {
+			out = append(out, it...)
+		}
+		return out
+	}
+	section := func(id byte, content []byte) []byte {
+		return append(append([]byte{id}, uleb(uint64(len(content)))...), content...)
+	}
+	name := func(s string) []byte { return append(uleb(uint64(len(s))), s...) }
+	body := func(code ...byte) []byte { return append(uleb(uint64(len(code)+1)), append([]byte{0}, code...)...) }
+
+	var packed int64
+	if result != "" {
+		packed = 1024<<32 | int64(len(result))
+	}
+	enrich := []byte{}
+	if loop {
+		enrich = append(enrich, 0x03, 0x40, 0x0c, 0x00, 0x0b) // loop br 0 end
+	}
+	enrich = append(append(append(enrich, 0x42), sleb(packed)...), 0x0b) // i64.const packed end
+
+	var m []byte
+	m = append(m, 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00)
+	m = append(m, section(1, vec(
+		[]byte{0x60, 0x01, 0x7f, 0x01, 0x7f},       // (i32) -> i32
+		[]byte{0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e}, // (i32, i32) -> i64
+	))...)
+	m = append(m, section(3, vec([]byte{0}, []byte{1}))...)
+	m = append(m, section(5, vec([]byte{0x00, 0x01}))...)
+	m = append(m, section(7, vec(
+		append(name("memory"), 0x02, 0x00),
+		append(name("alloc"), 0x00, 0x00),
+		append(name("enrich"), 0x00, 0x01),
+	))...)
+	m = append(m, section(10, vec(
+		body(append(append([]byte{0x41}, sleb(2048)...), 0x0b)...), // i32.const 2048
+		body(enrich...),
+	))...)
+	m = append(m, section(11, vec(
+		append(append([]byte{0x00, 0x41}, sleb(1024)...), append([]byte{0x0b}, name(result)...)...),
+	))...)
+	return m
+}
+
+func TestPluginsAnnotateAndDrop(t *testing.T) {
+	ctx := context.Background()
+	plugins := NewPlugins(defaultPluginMemoryMB, time.Second)
+	if _, err := plugins.Put(ctx, "", "score", pluginModule(`{"annotations":{"ml_label":"bridge"}}`, false)); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err := plugins.Put(ctx, "treasury", "drop", pluginModule(`{"drop":true}`, false)); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	ev := makeEvent("1", aliceAddr, bobAddr, "1", time.Now().UTC().Format(time.RFC3339), "")
+	annotated, drop := plugins.Apply(ctx, ev)
+	if !annotated || drop || string(ev.Annotations["ml_label"]) != `"bridge"` {
+		t.Fatalf("annotated=%v drop=%v annotations=%v", annotated, drop, ev.Annotations)
+	}
+
+	// Tenant plugins only run on the tenant's events
+	ev.Tenant = "treasury"
+	if _, drop := plugins.Apply(ctx, ev); !drop {
+		t.Fatal("expected the tenant's plugin to drop its event")
+	}
+}
+
+func TestPluginsEnforceLimits(t *testing.T) {
+	ctx := context.Background()
+	plugins := NewPlugins(defaultPluginMemoryMB, 50*time.Millisecond)
+	if _, err := plugins.Put(ctx, "", "spin", pluginModule(`{"drop":true}`, true)); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	ev := makeEvent("1", aliceAddr, bobAddr, "1", time.Now().UTC().Format(time.RFC3339), "")
+	start := time.Now()
+	if annotated, drop := plugins.Apply(ctx, ev); annotated || drop {
+		t.Fatalf("a plugin that timed out changed the event: annotated=%v drop=%v", annotated, drop)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("plugin ran for %s despite the timeout", elapsed)
+	}
+
+	// A memory limit below the module's minimum rejects it at upload
+	small := NewPlugins(0, time.Second)
+	if _, err := small.Put(ctx, "", "score", pluginModule(`{}`, false)); err == nil {
+		t.Fatal("expected a module over the memory limit to be rejected")
+	}
+}
+
+func TestPluginHandlers(t *testing.T) {
+	plugins := NewPlugins(defaultPluginMemoryMB, time.Second)
+
+	r := httptest.NewRecorder()
+	req := withChiParam(httptest.NewRequest(http.MethodPut, "/plugins/score", bytes.NewReader(pluginModule(`{}`, false))), "name", "score")
+	putPlugin(plugins, r, req)
+	if r.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", r.Code, r.Body.String())
+	}
+	var p Plugin
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil || p.Name != "score" || len(p.SHA256) != 64 {
+		t.Fatalf("plugin = %+v, %v", p, err)
+	}
+
+	for name, body := range map[string]string{"Bad Name": "", "junk": "not wasm"} {
+		r = httptest.NewRecorder()
+		putPlugin(plugins, r, withChiParam(httptest.NewRequest(http.MethodPut, "/plugins/x", strings.NewReader(body)), "name", name))
+		if r.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, r.Code)
+		}
+	}
+
+	r = httptest.NewRecorder()
+	listPlugins(plugins, r, httptest.NewRequest(http.MethodGet, "/plugins", nil))
+	if !strings.Contains(r.Body.String(), `"name":"score"`) {
+		t.Fatalf("list = %s", r.Body.String())
+	}
+
+	r = httptest.NewRecorder()
+	deletePlugin(plugins, r, withChiParam(httptest.NewRequest(http.MethodDelete, "/plugins/score", nil), "name", "score"))
+	if r.Code != http.StatusNoContent || len(plugins.List("")) != 0 {
+		t.Fatalf("delete: %d, %v", r.Code, plugins.List(""))
+	}
+}
+
+func TestPluginReplacedWhileRunning(t *testing.T) {
+	ctx := context.Background()
+	plugins := NewPlugins(defaultPluginMemoryMB, time.Second)
+	if _, err := plugins.Put(ctx, "", "score", pluginModule(`{"annotations":{"v":1}}`, false)); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	ev := makeEvent("1", aliceAddr, bobAddr, "1", time.Now().UTC().Format(time.RFC3339), "")
+	running := plugins.forEvent(ev)
+
+	// The old module stays usable until its run is done
+	if _, err := plugins.Put(ctx, "", "score", pluginModule(`{"annotations":{"v":2}}`, false)); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	res, err := plugins.run(ctx, running[0], ev)
+	running[0].runs.Done()
+	if err != nil || string(res.Annotations["v"]) != "1" {
+		t.Fatalf("run of the replaced plugin = %+v, %v", res, err)
+	}
+	if annotated, _ := plugins.Apply(ctx, ev); !annotated || string(ev.Annotations["v"]) != "2" {
+		t.Fatalf("annotations after the replacement = %v", ev.Annotations)
+	}
+}
+
+func TestRequirePluginOwner(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	for _, tc := range []struct {
+		token, tenant, auth string
+		want                int
+	}{
+		{"", "treasury", "", http.StatusNoContent},
+		{"", "", "", http.StatusForbidden},
+		{"s3cret", "", "", http.StatusUnauthorized},
+		{"s3cret", "", "Bearer s3cret", http.StatusNoContent},
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/plugins/score", nil)
+		req = req.WithContext(withTenant(req.Context(), tc.tenant))
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		r := httptest.NewRecorder()
+		requirePluginOwner(tc.token)(ok).ServeHTTP(r, req)
+		if r.Code != tc.want {
+			t.Errorf("token %q, tenant %q, auth %q: got %d, want %d", tc.token, tc.tenant, tc.auth, r.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPriceAtBlockTimeout = 5 * time.Second
+	// defaultPriceAtBlockMinUSD is the value at USD_PRICES from which
+	// transfers are valued at their block's price.
+	defaultPriceAtBlockMinUSD = 10000
+	// defaultPriceAtBlockWorkers resolve prices at once, from a queue of
+	// defaultPriceAtBlockQueue transfers; transfers that find it full keep
+	// the USD_PRICES value.
+	defaultPriceAtBlockWorkers = 4
+	defaultPriceAtBlockQueue   = 1000
+	// maxCachedBlockTimes caps the block timestamps remembered per chain
+	// to narrow the search for the block at a time.
+	maxCachedBlockTimes = 10000
+	// PriceAtBlockAnnotation is the annotation holding the price a
+	// transfer was valued at, resolved at its block.
+	PriceAtBlockAnnotation = "price_at_block"
+
+	// Selectors of the Chainlink aggregator functions called.
+	chainlinkLatestRoundData = "0xfeaf968c"
+	chainlinkDecimals        = "0x313ce567"
+)
+
+// PriceFeed is the Chainlink USD aggregator of an asset on an EVM chain.
+type PriceFeed struct {
+	Symbol  string
+	Chain   string
+	Network string
+	Address string
+}
+
+// ParsePriceFeeds parses a comma-separated list of
+// SYMBOL=chain:network:aggregator entries, e.g.
+// "ETH=ethereum:mainnet:0x5f4eC3Df9cbd43714FE2740f5E3616155c5b8419".
+func ParsePriceFeeds(spec string) (map[string]PriceFeed, error) {
+	feeds := make(map[string]PriceFeed)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		symbol, feed, ok := strings.Cut(item, "=")
+		parts := strings.Split(feed, ":")
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if !ok || symbol == "" || len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid price feed %q: want SYMBOL=chain:network:aggregator", item)
+		}
+		chain := strings.ToLower(parts[0])
+		if _, evm := chainAdapter(chain).(evmAdapter); !evm {
+			return nil, fmt.Errorf("invalid price feed %q: %s is not an EVM chain", item, chain)
+		}
+		address, err := canonicalAddress(chain, strings.TrimSpace(parts[2]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid price feed %q: %v", item, err)
+		}
+		feeds[symbol] = PriceFeed{Symbol: symbol, Chain: chain, Network: strings.ToLower(parts[1]), Address: address}
+	}
+	return feeds, nil
+}
+
+// BlockPrice is the price of one whole unit of an asset when a transfer
+// happened, stored in its price_at_block annotation.
+type BlockPrice struct {
+	Symbol string `json:"symbol"`
+	USD    string `json:"usd"`
+	Source string `json:"source"`
+	// Chain, Network and Block locate the aggregator state read: the
+	// transfer's own block when the feed is on its chain, otherwise the
+	// last block of the feed's chain at or before the transfer.
+	Chain     string `json:"chain"`
+	Network   string `json:"network"`
+	Block     uint64 `json:"block"`
+	RoundID   string `json:"round_id"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// blockPrice returns the resolved price of symbol ev carries, if any.
+func blockPrice(ev *Event, symbol string) (*big.Rat, bool) {
+	raw, ok := ev.Annotations[PriceAtBlockAnnotation]
+	if !ok {
+		return nil, false
+	}
+	var p BlockPrice
+	if json.Unmarshal(raw, &p) != nil || !strings.EqualFold(p.Symbol, symbol) {
+		return nil, false
+	}
+	usd, ok := new(big.Rat).SetString(p.USD)
+	return usd, ok && usd.Sign() > 0
+}
+
+// PriceResolver values high-value transfers at the price of their asset at
+// their block, read from the asset's Chainlink aggregator, instead of the
+// fixed USD_PRICES. Transfers valued below the threshold at USD_PRICES
+// keep those prices, so only the transfers that matter for reporting cost
+// the RPC calls. Ingestion only queues transfers: workers resolve their
+// prices and annotate the stored events afterwards, so a slow RPC provider
+// never holds up the events behind them.
+type PriceResolver struct {
+	feeds     map[string]PriceFeed
+	minUSD    *big.Rat
+	endpoints rpcEndpoints
+	client    *http.Client
+	timeout   time.Duration
+	queue     chan *Event
+	dropped   uint64
+
+	mu sync.Mutex
+	// decimals caches the decimals of each aggregator.
+	decimals map[string]int
+	// blockTimes caches the timestamps of the blocks read while searching
+	// for the block at a time, by chain:network.
+	blockTimes map[string]map[uint64]time.Time
+}
+
+// NewPriceResolver returns a resolver of transfers worth at least minUSD
+// at the prices of the table, spending at most timeout on each. It returns
+// nil without feeds.
+func NewPriceResolver(feeds map[string]PriceFeed, minUSD *big.Rat, endpoints rpcEndpoints, timeout time.Duration) *PriceResolver {
+	if len(feeds) == 0 {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = defaultPriceAtBlockTimeout
+	}
+	return &PriceResolver{
+		feeds:      feeds,
+		minUSD:     minUSD,
+		endpoints:  endpoints,
+		client:     &http.Client{Timeout: timeout},
+		timeout:    timeout,
+		queue:      make(chan *Event, defaultPriceAtBlockQueue),
+		decimals:   make(map[string]int),
+		blockTimes: make(map[string]map[uint64]time.Time),
+	}
+}
+
+// feed returns the feed ev is valued with when it is worth at least the
+// threshold at the prices of the table.
+func (r *PriceResolver) feed(prices *PriceTable, ev *Event) (PriceFeed, bool) {
+	feed, ok := r.feeds[eventAsset(ev)]
+	if !ok {
+		return PriceFeed{}, false
+	}
+	if _, usd, _ := usdOf(prices, ev, false); usd == nil || (r.minUSD != nil && usd.Cmp(r.minUSD) < 0) {
+		return PriceFeed{}, false
+	}
+	return feed, true
+}
+
+// Queue hands a stored event to the workers when it is worth resolving,
+// without waiting. An event that finds the queue full keeps the prices of
+// the table.
+func (r *PriceResolver) Queue(prices *PriceTable, ev *Event) {
+	if r == nil {
+		return
+	}
+	if _, ok := r.feed(prices, ev); !ok {
+		return
+	}
+	select {
+	case r.queue <- ev:
+	default:
+		atomic.AddUint64(&r.dropped, 1)
+		log.WithField("event_id", ev.EventID).Warn("price at block queue full; valuing at USD_PRICES")
+	}
+}
+
+// Run resolves the prices of queued events with workers goroutines until
+// ctx is done, storing each in a copy of its event that is merged into the
+// stored one.
+func (r *PriceResolver) Run(ctx context.Context, store *EventStore, workers int) {
+	if r == nil {
+		return
+	}
+	if workers <= 0 {
+		workers = defaultPriceAtBlockWorkers
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case ev := <-r.queue:
+					annotated := *ev
+					annotated.Annotations = make(map[string]json.RawMessage, len(ev.Annotations)+1)
+					for k, v := range ev.Annotations {
+						annotated.Annotations[k] = v
+					}
+					if !r.Resolve(ctx, store.prices, &annotated) {
+						continue
+					}
+					if err := store.UpdateMetadata(ctx, &annotated); err != nil {
+						log.WithError(err).WithField("event_id", ev.EventID).Warn("failed to store price at block")
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// WriteMetrics writes the queue depth and the transfers it turned away.
+func (r *PriceResolver) WriteMetrics(out io.Writer) {
+	fmt.Fprintf(out, "# HELP tracker_price_at_block_queue Transfers waiting for their price at block.\n# TYPE tracker_price_at_block_queue gauge\ntracker_price_at_block_queue %d\n", len(r.queue))
+	fmt.Fprintf(out, "# HELP tracker_price_at_block_dropped_total Transfers valued at USD_PRICES because the queue was full.\n# TYPE tracker_price_at_block_dropped_total counter\ntracker_price_at_block_dropped_total %d\n", atomic.LoadUint64(&r.dropped))
+}
+
+// Resolve stores the price of ev's asset at its block in its
+// price_at_block annotation when ev is worth at least the threshold at the
+// prices of the table, and reports whether it did. Failures are logged and
+// leave ev valued at the table's prices.
+func (r *PriceResolver) Resolve(ctx context.Context, prices *PriceTable, ev *Event) bool {
+	if r == nil {
+		return false
+	}
+	feed, ok := r.feed(prices, ev)
+	if !ok {
+		return false
+	}
+	symbol := feed.Symbol
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	price, err := r.resolve(ctx, feed, ev)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"event_id": ev.EventID, "symbol": symbol}).
+			Warn("could not resolve price at block; valuing at USD_PRICES")
+		return false
+	}
+	raw, _ := json.Marshal(price)
+	if ev.Annotations == nil {
+		ev.Annotations = make(map[string]json.RawMessage)
+	}
+	ev.Annotations[PriceAtBlockAnnotation] = raw
+	return true
+}
+
+func (r *PriceResolver) resolve(ctx context.Context, feed PriceFeed, ev *Event) (*BlockPrice, error) {
+	url, ok := r.endpoints.RPCURL(feed.Chain, feed.Network)
+	if !ok {
+		return nil, errNoRPC
+	}
+	call := func(ctx context.Context, method string, params ...interface{}) (json.RawMessage, error) {
+		return rpcCall(ctx, r.client, url, method, params...)
+	}
+	var block uint64
+	if ev.Chain == feed.Chain && ev.Network == feed.Network && ev.BlockNumber != nil {
+		block = *ev.BlockNumber
+	} else {
+		at, err := time.Parse(time.RFC3339, ev.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", ev.Timestamp)
+		}
+		if block, err = r.blockAt(ctx, call, feed, at); err != nil {
+			return nil, err
+		}
+	}
+	decimals, err := r.feedDecimals(ctx, call, feed)
+	if err != nil {
+		return nil, err
+	}
+	result, err := call(ctx, "eth_call", map[string]string{"to": feed.Address, "data": chainlinkLatestRoundData}, fmt.Sprintf("0x%x", block))
+	if err != nil {
+		return nil, err
+	}
+	round, answer, updatedAt, err := decodeRoundData(result)
+	if err != nil {
+		return nil, err
+	}
+	usd := new(big.Rat).SetFrac(answer, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+	return &BlockPrice{
+		Symbol:    feed.Symbol,
+		USD:       usd.FloatString(decimals),
+		Source:    "chainlink",
+		Chain:     feed.Chain,
+		Network:   feed.Network,
+		Block:     block,
+		RoundID:   round.String(),
+		UpdatedAt: updatedAt.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// feedDecimals returns the decimals of the feed's answers.
+func (r *PriceResolver) feedDecimals(ctx context.Context, call RPCCaller, feed PriceFeed) (int, error) {
+	key := feed.Chain + ":" + feed.Network + ":" + feed.Address
+	r.mu.Lock()
+	d, ok := r.decimals[key]
+	r.mu.Unlock()
+	if ok {
+		return d, nil
+	}
+	result, err := call(ctx, "eth_call", map[string]string{"to": feed.Address, "data": chainlinkDecimals}, "latest")
+	if err != nil {
+		return 0, err
+	}
+	n, ok := decodeABIUint(result)
+	if !ok || n > 36 {
+		return 0, fmt.Errorf("aggregator %s: unexpected decimals() result", feed.Address)
+	}
+	r.mu.Lock()
+	r.decimals[key] = int(n)
+	r.mu.Unlock()
+	return int(n), nil
+}
+
+// decodeRoundData decodes the result of latestRoundData(): the round, its
+// answer and when it was updated. Answers that are not positive are
+// errors.
+func decodeRoundData(result json.RawMessage) (round, answer *big.Int, updatedAt time.Time, err error) {
+	var s string
+	if json.Unmarshal(result, &s) != nil {
+		return nil, nil, time.Time{}, fmt.Errorf("unexpected latestRoundData() result")
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil || len(data) < 5*32 {
+		return nil, nil, time.Time{}, fmt.Errorf("unexpected latestRoundData() result")
+	}
+	round = new(big.Int).SetBytes(data[:32])
+	// The answer is an int256; a set top bit makes it negative
+	if data[32]&0x80 != 0 {
+		return nil, nil, time.Time{}, fmt.Errorf("aggregator answered a negative price")
+	}
+	answer = new(big.Int).SetBytes(data[32:64])
+	if answer.Sign() == 0 {
+		return nil, nil, time.Time{}, fmt.Errorf("aggregator has no answer at this block")
+	}
+	updated := new(big.Int).SetBytes(data[96:128])
+	if !updated.IsInt64() {
+		return nil, nil, time.Time{}, fmt.Errorf("unexpected latestRoundData() updatedAt")
+	}
+	return round, answer, time.Unix(updated.Int64(), 0), nil
+}
+
+// blockAt returns the last block of the feed's chain at or before t, found
+// by binary search between the closest blocks whose timestamps are cached,
+// or the genesis and the head. Transfers close in time then share most of
+// the blocks read.
+func (r *PriceResolver) blockAt(ctx context.Context, call RPCCaller, feed PriceFeed, t time.Time) (uint64, error) {
+	key := feed.Chain + ":" + feed.Network
+	// Invariant: block low is at or before t and block high after it
+	var low, high uint64
+	haveHigh := false
+	r.mu.Lock()
+	for block, at := range r.blockTimes[key] {
+		if at.After(t) {
+			if !haveHigh || block < high {
+				high, haveHigh = block, true
+			}
+		} else if block > low {
+			low = block
+		}
+	}
+	r.mu.Unlock()
+	timeOf := func(block uint64) (time.Time, error) {
+		at, err := evmBlockTime(ctx, call, block)
+		if err != nil {
+			return time.Time{}, err
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		times := r.blockTimes[key]
+		if times == nil || len(times) >= maxCachedBlockTimes {
+			times = make(map[uint64]time.Time)
+			r.blockTimes[key] = times
+		}
+		times[block] = at
+		return at, nil
+	}
+	if !haveHigh {
+		result, err := call(ctx, "eth_blockNumber")
+		if err != nil {
+			return 0, err
+		}
+		head, err := parseHeight(result)
+		if err != nil {
+			return 0, err
+		}
+		if at, err := timeOf(head); err != nil {
+			return 0, err
+		} else if !at.After(t) {
+			return head, nil
+		}
+		high = head
+	}
+	for high-low > 1 {
+		mid := low + (high-low)/2
+		at, err := timeOf(mid)
+		if err != nil {
+			return 0, err
+		}
+		if at.After(t) {
+			high = mid
+		} else {
+			low = mid
+		}
+	}
+	return low, nil
+}
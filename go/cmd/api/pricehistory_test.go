@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const ethUSDFeed = "0x5f4eC3Df9cbd43714FE2740f5E3616155c5b8419"
+
+// feedGenesis is the timestamp of block 0 of feedRPC's chain, whose blocks
+// are 12s apart.
+var feedGenesis = time.Date(2025, 10, 14, 0, 0, 0, 0, time.UTC)
+
+// feedRPC answers for a chain with a head of block 100 and an 8-decimal
+// aggregator whose answer at block n is 2000+n USD. It records the blocks
+// latestRoundData() is read at.
+func feedRPC(t *testing.T, blocks *[]uint64) *httptest.Server {
+	word := func(n *big.Int) string { return fmt.Sprintf("%064x", n) }
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode rpc request: %v", err)
+		}
+		result := "null"
+		switch req.Method {
+		case "eth_blockNumber":
+			result = `"0x64"`
+		case "eth_getBlockByNumber":
+			var tag string
+			_ = json.Unmarshal(req.Params[0], &tag)
+			n, _ := parseQuantity(tag)
+			result = fmt.Sprintf(`{"timestamp": "0x%x"}`, feedGenesis.Unix()+int64(n)*12)
+		case "eth_call":
+			var call struct {
+				Data string `json:"data"`
+			}
+			var tag string
+			_ = json.Unmarshal(req.Params[0], &call)
+			_ = json.Unmarshal(req.Params[1], &tag)
+			if call.Data == chainlinkDecimals {
+				result = `"0x` + word(big.NewInt(8)) + `"`
+				break
+			}
+			n, _ := parseQuantity(tag)
+			mu.Lock()
+			*blocks = append(*blocks, n)
+			mu.Unlock()
+			answer := new(big.Int).Mul(big.NewInt(2000+int64(n)), big.NewInt(1e8))
+			updated := big.NewInt(feedGenesis.Unix() + int64(n)*12)
+			result = `"0x` + word(big.NewInt(7)) + word(answer) + word(updated) + word(updated) + word(big.NewInt(7)) + `"`
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": ` + result + `}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPriceResolverValuesHighValueTransfersAtTheirBlock(t *testing.T) {
+	var blocks []uint64
+	chains := NewChainRegistry(nil, map[string][]string{"ethereum:mainnet": {feedRPC(t, &blocks).URL}})
+	feeds, err := ParsePriceFeeds("eth=ethereum:mainnet:" + ethUSDFeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := NewPriceResolver(feeds, big.NewRat(10000, 1), NewRPCManager(chains, 0), time.Second)
+	prices, _ := ParsePrices("ETH=3000")
+	transfer := func(chain, network, eth string, block *uint64, at time.Time) *Event {
+		ev := makeEvent("1", aliceAddr, bobAddr, eth+"000000000000000000", at.Format(time.RFC3339), "")
+		ev.Chain, ev.Network, ev.BlockNumber = chain, network, block
+		return ev
+	}
+	ctx := context.Background()
+
+	// On the feed's chain, at the transfer's own block
+	block := uint64(42)
+	ev := transfer("ethereum", "mainnet", "10", &block, feedGenesis)
+	if !resolver.Resolve(ctx, prices, ev) {
+		t.Fatal("high-value transfer not resolved")
+	}
+	var price BlockPrice
+	if err := json.Unmarshal(ev.Annotations[PriceAtBlockAnnotation], &price); err != nil || price.USD != "2042.00000000" || price.Block != 42 || price.RoundID != "7" || price.Source != "chainlink" {
+		t.Fatalf("price = %+v, %v", price, err)
+	}
+	if _, usd, _ := usdOf(prices, ev, false); usd.FloatString(2) != "20420.00" {
+		t.Fatalf("valued at %s", usd.FloatString(2))
+	}
+
+	// On another chain, at the last block of the feed's chain before it
+	ev = transfer("base", "mainnet", "5", nil, feedGenesis.Add(57*12*time.Second+5*time.Second))
+	if !resolver.Resolve(ctx, prices, ev) || !strings.Contains(string(ev.Annotations[PriceAtBlockAnnotation]), `"block":57`) {
+		t.Fatalf("cross-chain price = %s", ev.Annotations[PriceAtBlockAnnotation])
+	}
+	// A transfer in the same block reuses the block timestamps read
+	read := len(resolver.blockTimes["ethereum:mainnet"])
+	ev = transfer("base", "mainnet", "5", nil, feedGenesis.Add(57*12*time.Second+9*time.Second))
+	if !resolver.Resolve(ctx, prices, ev) || !strings.Contains(string(ev.Annotations[PriceAtBlockAnnotation]), `"block":57`) ||
+		len(resolver.blockTimes["ethereum:mainnet"]) != read {
+		t.Fatalf("cached cross-chain price = %s after reading %d blocks", ev.Annotations[PriceAtBlockAnnotation], len(resolver.blockTimes["ethereum:mainnet"])-read)
+	}
+
+	// Below the threshold at USD_PRICES, and assets without a feed
+	if resolver.Resolve(ctx, prices, transfer("ethereum", "mainnet", "3", &block, feedGenesis)) {
+		t.Fatal("low-value transfer resolved")
+	}
+	if resolver.Resolve(ctx, prices, makeEvent("2", aliceAddr, bobAddr, "1", feedGenesis.Format(time.RFC3339), "USDC")) {
+		t.Fatal("transfer without a feed resolved")
+	}
+	if len(blocks) != 3 || blocks[0] != 42 || blocks[1] != 57 || blocks[2] != 57 {
+		t.Fatalf("latestRoundData read at %v", blocks)
+	}
+
+	// Without an RPC endpoint for the feed's chain the table's price stays
+	unreachable := NewPriceResolver(feeds, nil, NewRPCManager(NewChainRegistry(nil, nil), 0), time.Second)
+	ev = transfer("ethereum", "mainnet", "10", &block, feedGenesis)
+	if unreachable.Resolve(ctx, prices, ev) {
+		t.Fatal("resolved without an RPC endpoint")
+	}
+	if _, usd, _ := usdOf(prices, ev, false); usd.FloatString(2) != "30000.00" {
+		t.Fatalf("fallback valued at %s", usd.FloatString(2))
+	}
+}
+
+func TestPriceResolverAnnotatesStoredTransfers(t *testing.T) {
+	var blocks []uint64
+	chains := NewChainRegistry(nil, map[string][]string{"ethereum:mainnet": {feedRPC(t, &blocks).URL}})
+	feeds, _ := ParsePriceFeeds("ETH=ethereum:mainnet:" + ethUSDFeed)
+	resolver := NewPriceResolver(feeds, big.NewRat(10000, 1), NewRPCManager(chains, 0), time.Second)
+	store := NewEventStore(100, 50)
+	prices, _ := ParsePrices("ETH=3000")
+	store.AttachPrices(prices)
+	store.AttachPriceResolver(resolver)
+	block := uint64(42)
+	add := func(id, eth string) {
+		ev := makeEvent(id, aliceAddr, bobAddr, eth+"000000000000000000", feedGenesis.Format(time.RFC3339), "")
+		ev.Network, ev.BlockNumber = "mainnet", &block
+		store.Add(ev)
+		store.queuePrice(ev)
+	}
+	add("small", "1")
+	add("large", "10")
+	if len(resolver.queue) != 1 {
+		t.Fatalf("queued %d transfers, want 1", len(resolver.queue))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		resolver.Run(ctx, store, 2)
+		close(done)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if ev, ok := store.GetByID("large"); ok && ev.Annotations[PriceAtBlockAnnotation] != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stored transfer not annotated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if ev, _ := store.GetByID("small"); ev.Annotations[PriceAtBlockAnnotation] != nil {
+		t.Fatal("low-value transfer annotated")
+	}
+	rec := httptest.NewRecorder()
+	metricsHandler(metricSources{prices: resolver}, rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "tracker_price_at_block_queue 0\n") {
+		t.Fatalf("metrics:\n%s", rec.Body)
+	}
+}
+
+func TestParsePriceFeeds(t *testing.T) {
+	for _, spec := range []string{"ETH", "ETH=ethereum:" + ethUSDFeed, "=ethereum:mainnet:" + ethUSDFeed, "SOL=solana:mainnet:" + wrappedSOL, "ETH=ethereum:mainnet:0x12"} {
+		if _, err := ParsePriceFeeds(spec); err == nil {
+			t.Errorf("ParsePriceFeeds(%q) accepted", spec)
+		}
+	}
+	if resolver := NewPriceResolver(nil, nil, nil, 0); resolver != nil || resolver.Resolve(context.Background(), nil, &Event{}) {
+		t.Fatal("resolver without feeds")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+// Response profiles select the event fields a response carries, so clients
+// pick a field set maintained here instead of each trimming events its own
+// way.
+const (
+	ProfileFull     = "full"
+	ProfileCompact  = "compact"
+	ProfileExplorer = "explorer"
+)
+
+// profileFields lists the fields of each profile but full, in output order.
+// Fields an event omits stay omitted.
+var profileFields = map[string][]string{
+	// compact is the minimum to render a transfer, for mobile clients
+	ProfileCompact: {"event_id", "chain", "network", "chain_id", "tx_hash", "timestamp", "from", "to", "value", "value_decimal", "event_type", "status", "token"},
+	// explorer adds what a block explorer view shows
+	ProfileExplorer: {"event_id", "chain", "network", "chain_id", "tx_hash", "block_number", "slot", "timestamp", "status",
+		"from", "from_label", "to", "to_label", "value", "value_decimal", "event_type", "token", "fee", "gas_used", "priority_fee", "nonce", "log_index", "explorer",
+		"bridge", "source_chain", "dest_chain", "sequence"},
+}
+
+// parseProfile reads ?profile=, which defaults to full.
+func parseProfile(r *http.Request) (string, error) {
+	p := newQueryParams(r)
+	profile := p.Enum("profile", ProfileFull, ProfileCompact, ProfileExplorer)
+	if profile == "" {
+		profile = ProfileFull
+	}
+	return profile, p.Err()
+}
+
+// withProfile returns copies of events that marshal with the profile's
+// fields only. The full profile returns events unchanged.
+func withProfile(profile string, events []*Event) []*Event {
+	if _, ok := profileFields[profile]; !ok {
+		return events
+	}
+	out := make([]*Event, len(events))
+	for i, ev := range events {
+		out[i] = withProfileOne(profile, ev)
+	}
+	return out
+}
+
+// withProfileOne is withProfile for a single event.
+func withProfileOne(profile string, ev *Event) *Event {
+	if _, ok := profileFields[profile]; !ok || ev == nil {
+		return ev
+	}
+	shaped := *ev
+	shaped.profile = profile
+	return &shaped
+}
+
+// eventJSON is Event without its MarshalJSON method.
+type eventJSON Event
+
+// MarshalJSON encodes the event, keeping only its profile's fields when it
+// has one.
+func (e Event) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(eventJSON(e))
+	fields, ok := profileFields[e.profile]
+	if err != nil || !ok {
+		return data, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	return selectFields(all, fields), nil
+}
+
+// selectFields encodes the fields of all that are listed in fields, in
+// that order.
+func selectFields(all map[string]json.RawMessage, fields []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, field := range fields {
+		value, ok := all[field]
+		if !ok {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(field)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// MarshalJSON appends wallets to the event's fields; without it the
+// embedded event's MarshalJSON would drop them.
+func (we WalletEvent) MarshalJSON() ([]byte, error) {
+	wallets, err := json.Marshal(we.Wallets)
+	if err != nil {
+		return nil, err
+	}
+	data := []byte("{}")
+	if we.Event != nil {
+		if data, err = json.Marshal(we.Event); err != nil {
+			return nil, err
+		}
+	}
+	out := append([]byte{}, data[:len(data)-1]...)
+	if len(out) > 1 {
+		out = append(out, ',')
+	}
+	out = append(out, `"wallets":`...)
+	out = append(out, wallets...)
+	return append(out, '}'), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseProfiles(t *testing.T) {
+	store := NewEventStore(100, 50)
+	ev := makeEvent("1", "alice", "bob", "1", time.Now().UTC().Format(time.RFC3339), "USDC")
+	ev.Chain, ev.Network, ev.TxHash, ev.ExecutedBy = "ethereum", "sepolia", "0xabc", "0xowner"
+	// Ingestion sets the chain id, which compact events carry
+	ev.ChainID = "eip155:11155111"
+	store.Add(ev)
+
+	list := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		getTransactions(store, rec, httptest.NewRequest(http.MethodGet, "/transactions"+query, nil))
+		return rec
+	}
+	fields := func(query string) []string {
+		var events []map[string]json.RawMessage
+		rec := list(query)
+		if err := json.NewDecoder(rec.Body).Decode(&events); err != nil || len(events) != 1 {
+			t.Fatalf("%s = %d, %v", query, rec.Code, err)
+		}
+		var keys []string
+		for k := range events[0] {
+			keys = append(keys, k)
+		}
+		return keys
+	}
+	has := func(keys []string, key string) bool {
+		for _, k := range keys {
+			if k == key {
+				return true
+			}
+		}
+		return false
+	}
+
+	full := fields("")
+	if !has(full, "executed_by") || !has(full, "explorer") {
+		t.Fatalf("full fields = %v", full)
+	}
+	compact := fields("?profile=compact")
+	if len(compact) != len(profileFields[ProfileCompact]) || has(compact, "executed_by") || has(compact, "explorer") || !has(compact, "token") {
+		t.Fatalf("compact fields = %v", compact)
+	}
+	explorer := fields("?profile=explorer")
+	if !has(explorer, "explorer") || has(explorer, "executed_by") {
+		t.Fatalf("explorer fields = %v", explorer)
+	}
+	if rec := list("?profile=tiny"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown profile = %d, want 400", rec.Code)
+	}
+	if ev.profile != "" {
+		t.Fatal("a profile was set on the stored event")
+	}
+}
+
+func TestWalletEventKeepsWalletsWithProfile(t *testing.T) {
+	ev := makeEvent("1", "alice", "bob", "1", time.Now().UTC().Format(time.RFC3339), "")
+	for _, profile := range []string{ProfileFull, ProfileCompact} {
+		data, err := json.Marshal(WalletEvent{Event: withProfileOne(profile, ev), Wallets: []string{"alice"}})
+		if err != nil || !strings.Contains(string(data), `"wallets":["alice"]`) || !strings.Contains(string(data), `"event_id":"1"`) {
+			t.Fatalf("%s wallet event = %s, %v", profile, data, err)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultMirrorEvents  = 500
+	defaultMirrorRefresh = 15 * time.Second
+	// mirrorStaleIfError is how long a CDN may keep serving a stale
+	// snapshot while the mirror is failing.
+	mirrorStaleIfError = 24 * time.Hour
+)
+
+// PublicStats is the activity over the last 24 hours the public mirror
+// serves at /public/stats.
+type PublicStats struct {
+	GeneratedAt string `json:"generated_at"`
+	Start       string `json:"start"`
+	End         string `json:"end"`
+	Transfers   int64  `json:"transfers"`
+	// Volume is the volume of each asset over the whole window; the
+	// bucket of every point is the window start.
+	Volume []VolumePoint `json:"volume"`
+	// ActiveWallets counts distinct addresses per hour.
+	ActiveWallets []ActiveWalletsPoint `json:"active_wallets"`
+}
+
+// mirrorSnapshot is what the public mirror serves until its next refresh.
+type mirrorSnapshot struct {
+	built  time.Time
+	events []*Event // enriched, newest first
+	stats  []byte   // encoded PublicStats
+}
+
+// PublicMirror serves a read-only subset of the API, the latest
+// transactions and headline stats, without authentication and from
+// snapshots rebuilt every refresh interval. Requests never reach the
+// repository, and responses carry Cache-Control headers matching the
+// interval, so the mirror can sit directly behind a CDN.
+type PublicMirror struct {
+	store *EventStore
+	// tenant scopes the snapshots like an API key would; empty sees every
+	// event.
+	tenant string
+	// owners keeps the wallets their owners hid from the public out of
+	// the snapshots.
+	owners  *WalletOwners
+	size    int
+	refresh time.Duration
+	now     func() time.Time
+
+	mu       sync.RWMutex
+	snapshot *mirrorSnapshot
+}
+
+// NewPublicMirror returns a mirror of the size latest events and of the
+// stats tenant sees, rebuilt every refresh.
+func NewPublicMirror(store *EventStore, tenant string, size int, refresh time.Duration) *PublicMirror {
+	if size <= 0 {
+		size = defaultMirrorEvents
+	}
+	if refresh < time.Second {
+		refresh = defaultMirrorRefresh
+	}
+	return &PublicMirror{store: store, tenant: tenant, size: size, refresh: refresh, now: time.Now}
+}
+
+// AttachOwners applies the privacy settings of owned wallets to the
+// snapshots: transactions of wallets hidden from the public are left out,
+// and private labels are removed.
+func (m *PublicMirror) AttachOwners(owners *WalletOwners) {
+	m.owners = owners
+}
+
+// Run rebuilds the snapshot now and then every refresh interval until ctx
+// is done.
+func (m *PublicMirror) Run(ctx context.Context) {
+	m.Refresh()
+	ticker := time.NewTicker(m.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Refresh()
+		}
+	}
+}
+
+// Refresh rebuilds the snapshot. Stats that cannot be computed keep their
+// previous value.
+func (m *PublicMirror) Refresh() {
+	now := m.now().UTC()
+	events := m.public(m.store.Enrich(m.store.GetRecent(EventFilter{Tenant: m.tenant, Limit: m.size})))
+	next := &mirrorSnapshot{built: now, events: events}
+
+	stats, err := m.stats(now)
+	if err == nil {
+		next.stats, err = json.Marshal(stats)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		log.WithError(err).Warn("public mirror: stats refresh failed")
+		if m.snapshot != nil {
+			next.stats = m.snapshot.stats
+		}
+	}
+	m.snapshot = next
+}
+
+// public drops the events of wallets hidden from the public and the labels
+// of private wallets, which nobody owns on the mirror.
+func (m *PublicMirror) public(events []*Event) []*Event {
+	if m.owners == nil {
+		return events
+	}
+	out := make([]*Event, 0, len(events))
+	for _, ev := range events {
+		from, to := m.owners.Privacy(ev.From), m.owners.Privacy(ev.To)
+		if from.HideFromPublic || to.HideFromPublic {
+			continue
+		}
+		if from.HideLabels && ev.FromLabel != "" || to.HideLabels && ev.ToLabel != "" {
+			redacted := *ev
+			if from.HideLabels {
+				redacted.FromLabel = ""
+			}
+			if to.HideLabels {
+				redacted.ToLabel = ""
+			}
+			ev = &redacted
+		}
+		out = append(out, ev)
+	}
+	return out
+}
+
+// stats computes the PublicStats of the 24 hours up to now.
+func (m *PublicMirror) stats(now time.Time) (PublicStats, error) {
+	q := AnalyticsQuery{Tenant: m.tenant, Interval: "1h", Start: now.Add(-24 * time.Hour), End: now}
+	points, err := m.store.VolumeSeries(q)
+	if err != nil {
+		return PublicStats{}, err
+	}
+	wallets, err := m.store.ActiveWalletsSeries(q)
+	if err != nil {
+		return PublicStats{}, err
+	}
+	stats := PublicStats{
+		GeneratedAt:   now.Format(time.RFC3339),
+		Start:         q.Start.Format(time.RFC3339),
+		End:           q.End.Format(time.RFC3339),
+		Volume:        sumVolume(points, q.Start.Format(time.RFC3339)),
+		ActiveWallets: wallets,
+	}
+	for _, p := range stats.Volume {
+		stats.Transfers += p.Transfers
+	}
+	if stats.ActiveWallets == nil {
+		stats.ActiveWallets = []ActiveWalletsPoint{}
+	}
+	return stats, nil
+}
+
+// sumVolume merges the buckets of a volume series into one point per
+// asset, labelled bucket.
+func sumVolume(points []VolumePoint, bucket string) []VolumePoint {
+	type key struct{ chain, network, token, address string }
+	sums := make(map[key]*VolumePoint)
+	totals := make(map[key]*big.Int)
+	for _, p := range points {
+		k := key{p.Chain, p.Network, p.Token, p.TokenAddress}
+		v, ok := new(big.Int).SetString(p.Volume, 10)
+		if !ok {
+			continue
+		}
+		if sums[k] == nil {
+			merged := p
+			merged.Bucket, merged.Transfers = bucket, 0
+			sums[k], totals[k] = &merged, new(big.Int)
+		}
+		totals[k].Add(totals[k], v)
+		sums[k].Transfers += p.Transfers
+	}
+	out := make([]VolumePoint, 0, len(sums))
+	for k, p := range sums {
+		p.Volume = totals[k].String()
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Chain != b.Chain {
+			return a.Chain < b.Chain
+		}
+		if a.Network != b.Network {
+			return a.Network < b.Network
+		}
+		if a.Token != b.Token {
+			return a.Token < b.Token
+		}
+		return a.TokenAddress < b.TokenAddress
+	})
+	return out
+}
+
+// current returns the snapshot, or replies 503 when the first one is not
+// built yet.
+func (m *PublicMirror) current(w http.ResponseWriter) *mirrorSnapshot {
+	m.mu.RLock()
+	snap := m.snapshot
+	m.mu.RUnlock()
+	if snap == nil {
+		w.Header().Set("Retry-After", "1")
+		httpError(w, "public mirror is warming up", http.StatusServiceUnavailable)
+	}
+	return snap
+}
+
+// write serves body from snap with caching headers: shared caches may keep
+// it for the refresh interval, serve it stale while revalidating for as
+// long again, and for a day while the mirror fails.
+func (m *PublicMirror) write(w http.ResponseWriter, r *http.Request, snap *mirrorSnapshot, body []byte) {
+	maxAge := int(m.refresh / time.Second)
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d, stale-while-revalidate=%d, stale-if-error=%d",
+		maxAge, maxAge, int(mirrorStaleIfError/time.Second)))
+	header.Set("Last-Modified", snap.built.Format(http.TimeFormat))
+	header.Set("Access-Control-Allow-Origin", "*")
+	writeWithETag(w, r, header, body)
+}
+
+// onlyParams rejects query parameters other than allowed, so clients
+// cannot split a CDN's cache with parameters the mirror ignores.
+func onlyParams(r *http.Request, allowed ...string) error {
+	for name := range r.URL.Query() {
+		if !containsToken(allowed, name) {
+			if len(allowed) == 0 {
+				return invalidParam(name, "%s is not supported; this endpoint takes no parameters", name)
+			}
+			return invalidParam(name, "%s is not supported; use %s", name, strings.Join(allowed, ", "))
+		}
+	}
+	return nil
+}
+
+// getPublicTransactions serves the latest transactions of the snapshot,
+// optionally narrowed to a chain or network.
+func getPublicTransactions(m *PublicMirror, w http.ResponseWriter, r *http.Request) {
+	if err := onlyParams(r, "chain", "network", "limit", "profile"); err != nil {
+		badRequest(w, err)
+		return
+	}
+	p := newQueryParams(r)
+	chain, network := p.Network()
+	limit := p.Int("limit", defaultPageSize, 1, m.size)
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	profile, err := parseProfile(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	snap := m.current(w)
+	if snap == nil {
+		return
+	}
+	filter := EventFilter{Chain: chain, Network: network}
+	events := make([]*Event, 0, limit)
+	for _, ev := range snap.events {
+		if len(events) == limit {
+			break
+		}
+		if filter.Matches(ev) {
+			events = append(events, ev)
+		}
+	}
+	body, err := json.Marshal(withProfile(profile, events))
+	if err != nil {
+		httpError(w, "could not encode transactions", http.StatusInternalServerError)
+		return
+	}
+	m.write(w, r, snap, append(body, '\n'))
+}
+
+// getPublicStats serves the stats of the snapshot.
+func getPublicStats(m *PublicMirror, w http.ResponseWriter, r *http.Request) {
+	if err := onlyParams(r); err != nil {
+		badRequest(w, err)
+		return
+	}
+	snap := m.current(w)
+	if snap == nil {
+		return
+	}
+	if snap.stats == nil {
+		w.Header().Set("Retry-After", "1")
+		httpError(w, "stats are not available yet", http.StatusServiceUnavailable)
+		return
+	}
+	m.write(w, r, snap, snap.stats)
+}
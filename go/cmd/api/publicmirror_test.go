@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublicMirrorServesSnapshots(t *testing.T) {
+	store := NewEventStore(100, 50)
+	now := time.Now().UTC()
+	ts := now.Add(-time.Hour).Format(time.RFC3339)
+	shared := func(ev *Event) *Event {
+		ev.SharedWith = []string{"acme"}
+		return ev
+	}
+	store.Add(shared(makeEvent("1", aliceAddr, bobAddr, "100", ts, "")))
+	store.Add(shared(makeEvent("2", bobAddr, aliceAddr, "50", ts, "")))
+	store.Add(shared(makeEvent("3", "carol", "dave", "7", ts, "")))
+	private := makeEvent("4", aliceAddr, bobAddr, "999", ts, "")
+	private.Tenant = "other"
+	store.Add(private)
+	store.Add(shared(makeEvent("5", aliceAddr, bobAddr, "1", now.Add(-48*time.Hour).Format(time.RFC3339), "")))
+
+	mirror := NewPublicMirror(store, "acme", 10, 30*time.Second)
+	get := func(target string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		if strings.HasPrefix(target, "/public/stats") {
+			getPublicStats(mirror, rec, req)
+		} else {
+			getPublicTransactions(mirror, rec, req)
+		}
+		return rec
+	}
+
+	if rec := get("/public/transactions", nil); rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("before the first snapshot: status %d", rec.Code)
+	}
+	mirror.Refresh()
+
+	rec := get("/public/transactions?chain=eth&limit=5", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if cc := rec.Header().Get("Cache-Control"); !strings.Contains(cc, "public") || !strings.Contains(cc, "max-age=30") {
+		t.Fatalf("Cache-Control = %q", cc)
+	}
+	var events []*Event
+	if err := json.NewDecoder(rec.Body).Decode(&events); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	// The event owned by another tenant is not mirrored
+	if len(events) != 3 {
+		t.Fatalf("expected 3 ethereum events, got %d", len(events))
+	}
+	for _, ev := range events {
+		if ev.EventID == "4" || ev.Chain != "ethereum" {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	}
+
+	// Events added after the snapshot are served at the next refresh only
+	store.Add(shared(makeEvent("6", aliceAddr, bobAddr, "3", now.Format(time.RFC3339), "")))
+	etag := rec.Header().Get("ETag")
+	if rec := get("/public/transactions?chain=eth&limit=5", http.Header{"If-None-Match": {etag}}); rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 before the refresh, got %d", rec.Code)
+	}
+	mirror.Refresh()
+	if rec := get("/public/transactions?chain=eth&limit=5", http.Header{"If-None-Match": {etag}}); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 after the refresh, got %d", rec.Code)
+	}
+
+	for _, target := range []string{"/public/transactions?offset=10", "/public/transactions?limit=0", "/public/stats?chain=eth"} {
+		if rec := get(target, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, rec.Code)
+		}
+	}
+
+	rec = get("/public/stats", nil)
+	var stats PublicStats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatalf("decode stats: %v", err)
+	}
+	// Events 1, 2 and 6 on ethereum and 3 on solana fall in the window
+	if rec.Code != http.StatusOK || stats.Transfers != 4 || len(stats.Volume) != 2 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if v := stats.Volume[0]; v.Chain != "ethereum" || v.Volume != "153" || v.Transfers != 3 || v.Bucket != stats.Start {
+		t.Fatalf("ethereum volume = %+v", v)
+	}
+}
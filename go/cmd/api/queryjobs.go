@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// Query job statuses.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+const (
+	// maxQueryJobs bounds the number of jobs kept at once, finished or not.
+	maxQueryJobs = 1000
+	// maxQueryResultBytes bounds the size of a stored result.
+	maxQueryResultBytes = 64 << 20
+)
+
+var (
+	errResultTooLarge = fmt.Errorf("result exceeds %d bytes", maxQueryResultBytes)
+	errTooManyJobs    = errors.New("too many queries; try again later")
+)
+
+// queryKind is a read endpoint that can also run as an async job.
+type queryKind struct {
+	needsAddress bool
+	handler      func(store *EventStore, w http.ResponseWriter, r *http.Request)
+}
+
+// queryKinds maps job kinds to the handlers of the equivalent GET endpoints.
+var queryKinds = map[string]queryKind{
+	"transactions":        {handler: getTransactions},
+	"wallet_transactions": {needsAddress: true, handler: getWalletTransactions},
+	"wallet_graph":        {needsAddress: true, handler: getWalletGraph},
+	"peel_chain":          {needsAddress: true, handler: getPeelChain},
+	"wallet_export":       {needsAddress: true, handler: getWalletExport},
+}
+
+// QueryRequest is the body of POST /queries. Params are the query parameters
+// of the equivalent GET endpoint (e.g. depth and format for wallet_graph).
+type QueryRequest struct {
+	Kind    string            `json:"kind"`
+	Address string            `json:"address,omitempty"`
+	Params  map[string]string `json:"params,omitempty"`
+	// Email, when set, is sent a link to download the result once the job
+	// finishes, or the error it failed with.
+	Email string `json:"email,omitempty"`
+	// Tenant is the tenant of the submitter; the job only sees and is only
+	// visible to that tenant.
+	Tenant string `json:"-"`
+}
+
+// QueryJob is the public state of an async query.
+type QueryJob struct {
+	ID          string `json:"id"`
+	Kind        string `json:"kind"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	CreatedAt   string `json:"created_at"`
+	StartedAt   string `json:"started_at,omitempty"`
+	FinishedAt  string `json:"finished_at,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int    `json:"size,omitempty"`
+	ResultURL   string `json:"result_url,omitempty"`
+}
+
+type queryJob struct {
+	QueryJob
+	req      QueryRequest
+	cancel   context.CancelFunc
+	header   http.Header
+	body     []byte
+	finished time.Time
+}
+
+func (j *queryJob) done() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCanceled
+}
+
+// QueryJobs runs long queries in the background so clients can poll for and
+// download results instead of holding a request open past the HTTP timeout.
+// At most `workers` jobs run at once; finished jobs are kept for ttl.
+type QueryJobs struct {
+	store   *EventStore
+	slots   chan struct{}
+	timeout time.Duration
+	ttl     time.Duration
+	mailer  *Mailer
+	links   *ShareLinks
+
+	mu   sync.Mutex
+	jobs map[string]*queryJob
+}
+
+func NewQueryJobs(store *EventStore, workers int, timeout, ttl time.Duration) *QueryJobs {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &QueryJobs{
+		store:   store,
+		slots:   make(chan struct{}, workers),
+		timeout: timeout,
+		ttl:     ttl,
+		jobs:    make(map[string]*queryJob),
+	}
+}
+
+// AttachMailer emails submitters who ask for it when their job finishes,
+// with download links signed by links.
+func (q *QueryJobs) AttachMailer(mailer *Mailer, links *ShareLinks) {
+	q.mailer, q.links = mailer, links
+}
+
+// Submit validates req and queues it.
+func (q *QueryJobs) Submit(req QueryRequest) (QueryJob, error) {
+	kind, ok := queryKinds[req.Kind]
+	if !ok {
+		return QueryJob{}, fmt.Errorf("unknown query kind %q", req.Kind)
+	}
+	if kind.needsAddress && strings.TrimSpace(req.Address) == "" {
+		return QueryJob{}, fmt.Errorf("address is required for %s queries", req.Kind)
+	}
+	if kind.needsAddress {
+		if _, err := canonicalAddress(req.Params["chain"], req.Address); err != nil {
+			return QueryJob{}, fmt.Errorf("address: %w", err)
+		}
+	}
+	if req.Email != "" {
+		if q.mailer == nil {
+			return QueryJob{}, errors.New("email delivery is not configured; set SMTP_URL")
+		}
+		addr, err := mail.ParseAddress(req.Email)
+		if err != nil {
+			return QueryJob{}, fmt.Errorf("email: %w", err)
+		}
+		req.Email = addr.Address
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), q.timeout)
+	job := &queryJob{
+		QueryJob: QueryJob{
+			ID:        newRandomID(),
+			Kind:      req.Kind,
+			Status:    JobQueued,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		},
+		req:    req,
+		cancel: cancel,
+	}
+
+	q.mu.Lock()
+	q.expireLocked(time.Now())
+	if len(q.jobs) >= maxQueryJobs {
+		q.mu.Unlock()
+		cancel()
+		return QueryJob{}, errTooManyJobs
+	}
+	q.jobs[job.ID] = job
+	q.mu.Unlock()
+
+	go q.run(ctx, job, kind)
+	return job.QueryJob, nil
+}
+
+// run waits for a worker slot, then executes the job's handler against an
+// in-memory response.
+func (q *QueryJobs) run(ctx context.Context, job *queryJob, kind queryKind) {
+	defer job.cancel()
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		q.finish(job, ctx.Err(), nil)
+		return
+	}
+
+	q.mu.Lock()
+	job.Status = JobRunning
+	job.StartedAt = time.Now().UTC().Format(time.RFC3339)
+	q.mu.Unlock()
+
+	result := make(chan *resultWriter, 1)
+	go func() {
+		// The slot is held until the handler returns, even if the job
+		// times out first, so abandoned work still counts against workers.
+		defer func() { <-q.slots }()
+		rw := newResultWriter()
+		req, err := jobRequest(ctx, job.req)
+		if err != nil {
+			rw.err = err
+		} else {
+			kind.handler(q.store, rw, req)
+		}
+		result <- rw
+	}()
+
+	select {
+	case rw := <-result:
+		q.finish(job, nil, rw)
+	case <-ctx.Done():
+		q.finish(job, ctx.Err(), nil)
+	}
+}
+
+// jobRequest builds the GET request the handler would have received.
+func jobRequest(ctx context.Context, req QueryRequest) (*http.Request, error) {
+	values := url.Values{}
+	for k, v := range req.Params {
+		values.Set(k, v)
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/?"+values.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("address", req.Address)
+	return r.WithContext(context.WithValue(withTenant(ctx, req.Tenant), chi.RouteCtxKey, rctx)), nil
+}
+
+func (q *QueryJobs) finish(job *queryJob, err error, rw *resultWriter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job.done() {
+		return
+	}
+	job.finished = time.Now()
+	job.FinishedAt = job.finished.UTC().Format(time.RFC3339)
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.Status = JobCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		job.Status = JobFailed
+		job.Error = "query timed out"
+	case rw.err != nil:
+		job.Status = JobFailed
+		job.Error = rw.err.Error()
+	case rw.status >= http.StatusBadRequest:
+		job.Status = JobFailed
+		var e ErrorResponse
+		if json.Unmarshal(rw.buf.Bytes(), &e) == nil && e.Message != "" {
+			job.Error = e.Message
+		} else {
+			job.Error = strings.TrimSpace(rw.buf.String())
+		}
+	default:
+		job.Status = JobSucceeded
+		job.header = rw.header
+		job.body = rw.buf.Bytes()
+		job.ContentType = rw.header.Get("Content-Type")
+		job.Size = len(job.body)
+		job.ResultURL = "/queries/" + job.ID + "/result"
+	}
+	log.WithFields(log.Fields{"job": job.ID, "kind": job.Kind, "status": job.Status}).Info("query job finished")
+	if job.req.Email != "" && job.Status != JobCanceled {
+		go q.notify(job.QueryJob, job.req, job.finished.Add(q.ttl))
+	}
+}
+
+// notify emails the submitter of a finished job. The download link needs
+// no API key and expires with the result.
+func (q *QueryJobs) notify(job QueryJob, req QueryRequest, expires time.Time) {
+	what := strings.ReplaceAll(job.Kind, "_", " ")
+	if req.Address != "" {
+		what += " of " + req.Address
+	}
+	subject := fmt.Sprintf("Your %s %s", what, job.Status)
+	body := fmt.Sprintf("The %s submitted at %s failed: %s\n", what, job.CreatedAt, job.Error)
+	if job.Status == JobSucceeded {
+		token, err := q.links.Issue(shareClaims{Kind: ShareKindDownload, Target: job.ID, Tenant: req.Tenant, Expires: expires.Unix()})
+		if err != nil {
+			log.WithError(err).WithField("job", job.ID).Error("could not issue download link")
+			return
+		}
+		subject = fmt.Sprintf("Your %s is ready", what)
+		body = fmt.Sprintf("The %s submitted at %s is ready. Download it until %s from:\n\n%s/downloads/%s\n",
+			what, job.CreatedAt, expires.UTC().Format(time.RFC3339), q.links.baseURL, token)
+	}
+	if err := q.mailer.Send(req.Email, subject, body); err != nil {
+		log.WithError(err).WithField("job", job.ID).Warn("could not email query job result")
+	}
+}
+
+// lookupLocked returns a job submitted by tenant. Callers hold q.mu.
+func (q *QueryJobs) lookupLocked(id, tenant string) (*queryJob, bool) {
+	job, ok := q.jobs[id]
+	if !ok || job.req.Tenant != tenant {
+		return nil, false
+	}
+	return job, true
+}
+
+// Get returns the state of a job submitted by tenant.
+func (q *QueryJobs) Get(id, tenant string) (QueryJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.lookupLocked(id, tenant)
+	if !ok {
+		return QueryJob{}, false
+	}
+	return job.QueryJob, true
+}
+
+// Result returns the stored response of a succeeded job submitted by tenant.
+func (q *QueryJobs) Result(id, tenant string) (QueryJob, http.Header, []byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.lookupLocked(id, tenant)
+	if !ok {
+		return QueryJob{}, nil, nil, false
+	}
+	return job.QueryJob, job.header, job.body, true
+}
+
+// Cancel stops a queued or running job submitted by tenant and forgets a
+// finished one.
+func (q *QueryJobs) Cancel(id, tenant string) bool {
+	q.mu.Lock()
+	job, ok := q.lookupLocked(id, tenant)
+	if ok && job.done() {
+		delete(q.jobs, id)
+	}
+	q.mu.Unlock()
+	if ok {
+		job.cancel()
+	}
+	return ok
+}
+
+// expireLocked drops finished jobs older than the ttl. Callers hold q.mu.
+func (q *QueryJobs) expireLocked(now time.Time) {
+	for id, job := range q.jobs {
+		if job.done() && now.Sub(job.finished) > q.ttl {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// Run expires finished jobs until ctx is done.
+func (q *QueryJobs) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			q.mu.Lock()
+			q.expireLocked(now)
+			q.mu.Unlock()
+		}
+	}
+}
+
+// resultWriter captures a handler's response in memory.
+type resultWriter struct {
+	header http.Header
+	status int
+	buf    bytes.Buffer
+	err    error
+}
+
+func newResultWriter() *resultWriter {
+	return &resultWriter{header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *resultWriter) Header() http.Header { return w.header }
+
+func (w *resultWriter) WriteHeader(status int) { w.status = status }
+
+func (w *resultWriter) Write(b []byte) (int, error) {
+	if w.buf.Len()+len(b) > maxQueryResultBytes {
+		w.err = errResultTooLarge
+		return 0, w.err
+	}
+	return w.buf.Write(b)
+}
+
+// createQuery submits an async query and returns 202 with the job.
+func createQuery(jobs *QueryJobs, w http.ResponseWriter, r *http.Request) {
+	var req QueryRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Tenant = tenantFrom(r.Context())
+	submitQuery(jobs, w, req)
+}
+
+// submitQuery submits req and returns 202 with the job.
+func submitQuery(jobs *QueryJobs, w http.ResponseWriter, req QueryRequest) {
+	job, err := jobs.Submit(req)
+	if errors.Is(err, errTooManyJobs) {
+		httpError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/queries/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(job)
+}
+
+// getQuery reports the status of a job.
+func getQuery(jobs *QueryJobs, w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.Get(chi.URLParam(r, "id"), tenantFrom(r.Context()))
+	if !ok {
+		httpError(w, "query not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(job)
+}
+
+// getQueryResult downloads the result of a succeeded job with the headers
+// the synchronous endpoint would have sent.
+func getQueryResult(jobs *QueryJobs, w http.ResponseWriter, r *http.Request) {
+	job, header, body, ok := jobs.Result(chi.URLParam(r, "id"), tenantFrom(r.Context()))
+	writeQueryResult(w, job, header, body, ok)
+}
+
+// getDownload serves the result of a job through the link emailed to its
+// submitter. No authentication is required; the token names the job.
+func getDownload(links *ShareLinks, jobs *QueryJobs, w http.ResponseWriter, r *http.Request) {
+	claims, err := links.Verify(chi.URLParam(r, "token"), time.Now())
+	if errors.Is(err, errShareExpired) {
+		httpError(w, "download link expired", http.StatusGone)
+		return
+	}
+	if err != nil || claims.Kind != ShareKindDownload {
+		httpError(w, "download not found", http.StatusNotFound)
+		return
+	}
+	job, header, body, ok := jobs.Result(claims.Target, claims.Tenant)
+	writeQueryResult(w, job, header, body, ok)
+}
+
+// writeQueryResult writes the stored response of a succeeded job.
+func writeQueryResult(w http.ResponseWriter, job QueryJob, header http.Header, body []byte, ok bool) {
+	if !ok {
+		httpError(w, "query not found", http.StatusNotFound)
+		return
+	}
+	if job.Status != JobSucceeded {
+		httpError(w, "query is "+job.Status, http.StatusConflict)
+		return
+	}
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	_, _ = w.Write(body)
+}
+
+// cancelQuery cancels a pending job or deletes a finished one.
+func cancelQuery(jobs *QueryJobs, w http.ResponseWriter, r *http.Request) {
+	if !jobs.Cancel(chi.URLParam(r, "id"), tenantFrom(r.Context())) {
+		httpError(w, "query not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
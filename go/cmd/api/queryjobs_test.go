@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitForJob(t *testing.T, jobs *QueryJobs, id string) QueryJob {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, ok := jobs.Get(id, ""); ok && job.Status != JobQueued && job.Status != JobRunning {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return QueryJob{}
+}
+
+func TestQueryJobLifecycle(t *testing.T) {
+	store := NewEventStore(100, 50)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	store.Add(makeEvent("1", aliceAddr, "a", "10", ts, ""))
+	jobs := NewQueryJobs(store, 1, time.Minute, time.Hour)
+
+	body, _ := json.Marshal(QueryRequest{Kind: "wallet_graph", Address: aliceAddr, Params: map[string]string{"format": "dot"}})
+	rr := httptest.NewRecorder()
+	createQuery(jobs, rr, httptest.NewRequest(http.MethodPost, "/queries", bytes.NewReader(body)))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("create status = %d: %s", rr.Code, rr.Body.String())
+	}
+	var created QueryJob
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if rr.Header().Get("Location") != "/queries/"+created.ID {
+		t.Fatalf("location = %q", rr.Header().Get("Location"))
+	}
+
+	job := waitForJob(t, jobs, created.ID)
+	if job.Status != JobSucceeded || job.ResultURL != "/queries/"+created.ID+"/result" {
+		t.Fatalf("job = %+v", job)
+	}
+
+	rr = httptest.NewRecorder()
+	getQueryResult(jobs, rr, withChiParam(httptest.NewRequest(http.MethodGet, job.ResultURL, nil), "id", created.ID))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/vnd.graphviz" {
+		t.Fatalf("result status = %d, content type %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rr.Body.String(), `"`+checksumAddress(aliceAddr)+`" -> "a"`) {
+		t.Fatalf("unexpected result body: %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	cancelQuery(jobs, rr, withChiParam(httptest.NewRequest(http.MethodDelete, "/", nil), "id", created.ID))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d", rr.Code)
+	}
+	if _, ok := jobs.Get(created.ID, ""); ok {
+		t.Fatal("finished job should be forgotten after delete")
+	}
+}
+
+func TestQueryJobFailures(t *testing.T) {
+	jobs := NewQueryJobs(NewEventStore(10, 10), 1, time.Minute, time.Hour)
+
+	if _, err := jobs.Submit(QueryRequest{Kind: "nope"}); err == nil {
+		t.Fatal("expected error for unknown kind")
+	}
+	if _, err := jobs.Submit(QueryRequest{Kind: "peel_chain"}); err == nil {
+		t.Fatal("expected error for missing address")
+	}
+	if _, err := jobs.Submit(QueryRequest{Kind: "peel_chain", Address: "nobody"}); err == nil {
+		t.Fatal("expected error for invalid address")
+	}
+
+	created, err := jobs.Submit(QueryRequest{Kind: "wallet_graph", Address: aliceAddr, Params: map[string]string{"format": "bogus"}})
+	if err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	job := waitForJob(t, jobs, created.ID)
+	if job.Status != JobFailed || !strings.Contains(job.Error, "unsupported format") {
+		t.Fatalf("job = %+v", job)
+	}
+
+	rr := httptest.NewRecorder()
+	getQueryResult(jobs, rr, withChiParam(httptest.NewRequest(http.MethodGet, "/", nil), "id", created.ID))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("result of failed job status = %d, want 409", rr.Code)
+	}
+}
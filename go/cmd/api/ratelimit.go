@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitPruneSize is how many clients the limiter tracks before it drops
+// those whose bucket has refilled, which are indistinguishable from new
+// ones.
+const rateLimitPruneSize = 10000
+
+// bucket is a client's token bucket: tokens as of last.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter limits each client, identified by its API key or else its IP
+// address, to a sustained rate of requests with bursts of up to burst.
+// The limits can be changed at runtime by a config reload; a rate of 0
+// turns limiting off.
+type RateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+// NewRateLimiter returns a limiter allowing rate requests per second and
+// bursts of burst per client. A burst below 1 is set to the rate, rounded
+// up.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	l := &RateLimiter{buckets: make(map[string]*bucket), now: time.Now}
+	l.SetLimit(rate, burst)
+	return l
+}
+
+// SetLimit changes the limits. Clients keep the tokens they have, up to the
+// new burst.
+func (l *RateLimiter) SetLimit(rate float64, burst int) {
+	if rate < 0 {
+		rate = 0
+	}
+	if burst < 1 {
+		burst = int(math.Ceil(rate))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate, l.burst = rate, float64(burst)
+}
+
+// Allow takes a token from client's bucket. When the bucket is empty it
+// returns false and how long until a token is available.
+func (l *RateLimiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return true, 0
+	}
+	now := l.now()
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= rateLimitPruneSize {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops the buckets that have refilled. Callers hold mu.
+func (l *RateLimiter) prune(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// rateLimitClient identifies the caller of r: a digest of its API key, so
+// keys are not held in memory, or else the remote IP address.
+func rateLimitClient(r *http.Request) string {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = r.URL.Query().Get("api_key")
+	}
+	if key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + string(sum[:])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// Middleware replies 429 with a Retry-After header to clients over their
+// limit. Health and readiness checks and metrics scrapes are never limited.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/ready" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := l.Allow(rateLimitClient(r)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			httpError(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := NewRateLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d of the burst refused", i)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("over the burst: %v, wait %s", ok, wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Fatal("another client was limited")
+	}
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("refilled token refused")
+	}
+
+	// A reload can lift the limit altogether
+	l.SetLimit(0, 0)
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatal("limited with limiting off")
+		}
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	l := NewRateLimiter(1, 1)
+	handler := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func(path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/transactions", "k1"); rec.Code != 200 {
+		t.Fatalf("first request: %d", rec.Code)
+	}
+	rec := get("/transactions", "k1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" || decodeError(t, rec).Code != ErrCodeRateLimited {
+		t.Fatalf("second request: %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// Keys are limited separately, even from the same address
+	if rec := get("/transactions", "k2"); rec.Code != 200 {
+		t.Fatalf("other key: %d", rec.Code)
+	}
+	if rec := get("/health", "k1"); rec.Code != 200 {
+		t.Fatalf("health check limited: %d", rec.Code)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultRawTxCacheTTL is how long fetched raw transactions stay in Redis,
+// overridable with RAW_TX_CACHE_TTL.
+const defaultRawTxCacheTTL = time.Hour
+
+var errNoRPC = errors.New("no RPC endpoint configured for this network")
+
+// rawTxCache stores fetched raw transactions; *redis.Client satisfies it
+// through redisRawTxCache.
+type rawTxCache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+type redisRawTxCache struct{ rdb *redis.Client }
+
+func (c redisRawTxCache) Get(ctx context.Context, key string) ([]byte, error) {
+	return c.rdb.Get(ctx, key).Bytes()
+}
+
+func (c redisRawTxCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.rdb.Set(ctx, key, value, ttl).Err()
+}
+
+// RawTxFetcher fetches the on-chain transaction behind an event from the
+// chain's JSON-RPC endpoint, so support engineers can inspect it without
+// leaving the tracker. Results are cached, since mined transactions do not
+// change.
+type RawTxFetcher struct {
+	endpoints rpcEndpoints
+	client    *http.Client
+	cache     rawTxCache
+	ttl       time.Duration
+}
+
+// rpcEndpoints resolves the JSON-RPC endpoint of a chain/network pair;
+// *RPCManager satisfies it.
+type rpcEndpoints interface {
+	RPCURL(chain, network string) (string, bool)
+}
+
+// validRPCURL reports whether url can be used as a JSON-RPC endpoint.
+func validRPCURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// ParseRPCURLs parses a comma-separated list of chain:network=url entries,
+// e.g. "ethereum:mainnet=https://eth.example,solana:devnet=https://api.devnet.solana.com".
+// Pairs are read like NETWORKS entries. A pair listed more than once gets
+// several providers, in order.
+func ParseRPCURLs(spec string) (map[string][]string, error) {
+	out := make(map[string][]string)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pair, url, ok := strings.Cut(item, "=")
+		chain, network, pairOK := parseNetworkPair(pair)
+		if !ok || !pairOK || !validRPCURL(url) {
+			return nil, fmt.Errorf("invalid RPC URL %q: want chain:network=http(s)://...", item)
+		}
+		pair = chain + ":" + network
+		out[pair] = append(out[pair], url)
+	}
+	return out, nil
+}
+
+// NewRawTxFetcher returns a fetcher for endpoints, caching in cache (nil
+// disables caching) for ttl.
+func NewRawTxFetcher(endpoints rpcEndpoints, cache rawTxCache, ttl time.Duration) *RawTxFetcher {
+	if ttl <= 0 {
+		ttl = defaultRawTxCacheTTL
+	}
+	return &RawTxFetcher{
+		endpoints: endpoints,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		cache:     cache,
+		ttl:       ttl,
+	}
+}
+
+// Fetch returns the raw transaction: {"transaction", "receipt"} from
+// eth_getTransactionByHash and eth_getTransactionReceipt on EVM chains, and
+// the jsonParsed getTransaction result on Solana. It returns nil when the
+// node does not know the transaction.
+func (f *RawTxFetcher) Fetch(ctx context.Context, chain, network, hash string) (json.RawMessage, error) {
+	if f == nil {
+		return nil, errNoRPC
+	}
+	url, ok := f.endpoints.RPCURL(chain, network)
+	if !ok {
+		return nil, errNoRPC
+	}
+	key := "rawtx:" + chain + ":" + network + ":" + hash
+	if f.cache != nil {
+		if cached, err := f.cache.Get(ctx, key); err == nil {
+			return cached, nil
+		} else if !errors.Is(err, redis.Nil) {
+			log.WithError(err).Warn("raw transaction cache read failed")
+		}
+	}
+
+	call := func(ctx context.Context, method string, params ...interface{}) (json.RawMessage, error) {
+		return rpcCall(ctx, f.client, url, method, params...)
+	}
+	raw, complete, err := chainAdapter(chain).Backfill(ctx, call, hash)
+	if err != nil || raw == nil {
+		return nil, err
+	}
+	// Pending transactions are not cached, so the receipt shows up once mined
+	if f.cache != nil && complete {
+		if err := f.cache.Set(ctx, key, raw, f.ttl); err != nil {
+			log.WithError(err).Warn("raw transaction cache write failed")
+		}
+	}
+	return raw, nil
+}
+
+// rpcCall makes a JSON-RPC request and returns its result, or nil for a
+// null result.
+func rpcCall(ctx context.Context, client *http.Client, url, method string, params ...interface{}) (json.RawMessage, error) {
+	body, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected status %s", method, resp.Status)
+	}
+	var out struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 10<<20)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+	if out.Error != nil {
+		return nil, fmt.Errorf("%s: %s", method, out.Error.Message)
+	}
+	if len(out.Result) == 0 || string(out.Result) == "null" {
+		return nil, nil
+	}
+	return out.Result, nil
+}
+
+// RawTransaction is the live on-chain data attached to detail responses.
+// Raw is omitted when no RPC endpoint is configured for the network or the
+// node does not know the transaction; RawError says why a fetch failed.
+type RawTransaction struct {
+	Raw      json.RawMessage `json:"raw,omitempty"`
+	RawError string          `json:"raw_error,omitempty"`
+}
+
+// EventDetail is the response of GET /transactions/{event_id}. BridgeLegs
+// holds the other side's events when the event belongs to a bridge
+// transfer, and Correlations how confident each pairing is.
+type EventDetail struct {
+	Event        *Event        `json:"event"`
+	BridgeLegs   []*Event      `json:"bridge_legs,omitempty"`
+	Correlations []Correlation `json:"correlations,omitempty"`
+	// History lists every version of an amended event, oldest first, with
+	// ?include_history=true.
+	History []*Event `json:"history,omitempty"`
+	RawTransaction
+}
+
+// TransactionDetail is the response of GET /tx/{chain}/{hash}.
+type TransactionDetail struct {
+	Events []*Event `json:"events"`
+	RawTransaction
+}
+
+func (f *RawTxFetcher) attach(ctx context.Context, ev *Event) RawTransaction {
+	raw, err := f.Fetch(ctx, ev.Chain, ev.Network, normalizeTxHash(ev.Chain, ev.TxHash))
+	switch {
+	case errors.Is(err, errNoRPC):
+		return RawTransaction{}
+	case err != nil:
+		log.WithError(err).WithField("tx_hash", ev.TxHash).Warn("raw transaction fetch failed")
+		return RawTransaction{RawError: err.Error()}
+	}
+	return RawTransaction{Raw: raw}
+}
+
+// getEventDetail returns one event with its raw on-chain transaction and
+// the other legs of its bridge transfer. Another tenant's event is
+// reported as not found. An amended event is answered with its latest
+// version, and ?include_history=true adds all of its versions.
+func getEventDetail(store *EventStore, fetcher *RawTxFetcher, w http.ResponseWriter, r *http.Request) {
+	profile, err := parseProfile(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	p := newQueryParams(r)
+	includeHistory := p.Bool("include_history", false)
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	tenant := tenantFrom(r.Context())
+	ev, ok := store.GetByID(chi.URLParam(r, "event_id"))
+	if !ok || !tenantSees(tenant, ev) {
+		httpError(w, "event not found", http.StatusNotFound)
+		return
+	}
+	// An amended event is answered with its latest version
+	if latest := store.LatestVersion(r.Context(), ev); tenantSees(tenant, latest) {
+		ev = latest
+	}
+	legs, correlations := store.Correlations(ev, tenant)
+	detail := EventDetail{
+		Event:          withProfileOne(profile, store.EnrichOne(ev)),
+		BridgeLegs:     withProfile(profile, store.Enrich(legs)),
+		Correlations:   correlations,
+		RawTransaction: fetcher.attach(r.Context(), ev),
+	}
+	if includeHistory && (ev.Supersedes != "" || ev.SupersededBy != "") {
+		detail.History = withProfile(profile, store.Enrich(tenantEvents(tenant, store.Lineage(r.Context(), ev))))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(detail)
+}
+
+// getTransactionDetail returns every event of a transaction on one chain,
+// optionally narrowed to ?network=, with the raw on-chain transaction.
+func getTransactionDetail(store *EventStore, fetcher *RawTxFetcher, w http.ResponseWriter, r *http.Request) {
+	chain, network := canonicalNetwork(chi.URLParam(r, "chain"), newQueryParams(r).String("network"))
+	hash, ok := chainAdapter(chain).TxHash(strings.TrimSpace(chi.URLParam(r, "hash")))
+	if !ok {
+		badRequest(w, invalidParam("hash", "%v for chain %s", errUnrecognizedHash, chain))
+		return
+	}
+	profile, err := parseProfile(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	current, err := currentVersions(r, store.GetByTxHash(hash, chain))
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	var events []*Event
+	for _, ev := range tenantEvents(tenantFrom(r.Context()), current) {
+		if network == "" || strings.EqualFold(ev.Network, network) {
+			events = append(events, ev)
+		}
+	}
+	if len(events) == 0 {
+		httpError(w, "transaction not found", http.StatusNotFound)
+		return
+	}
+	detail := TransactionDetail{Events: withProfile(profile, store.Enrich(events)), RawTransaction: fetcher.attach(r.Context(), events[0])}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(detail)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-redis/redis/v8"
+)
+
+type mapRawTxCache struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func (c *mapRawTxCache) Get(_ context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.data[key]; ok {
+		return v, nil
+	}
+	return nil, redis.Nil
+}
+
+func (c *mapRawTxCache) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[key] = value
+	return nil
+}
+
+// fakeRPC answers eth_getTransactionByHash, eth_getTransactionReceipt and
+// getTransaction, counting calls.
+func fakeRPC(t *testing.T, receipt string) (*httptest.Server, *int) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string        `json:"method"`
+			Params []interface{} `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode rpc request: %v", err)
+		}
+		calls++
+		result := "null"
+		switch req.Method {
+		case "eth_getTransactionByHash":
+			result = `{"hash": "` + req.Params[0].(string) + `", "input": "0x"}`
+		case "eth_getTransactionReceipt":
+			result = receipt
+		case "getTransaction":
+			result = `{"slot": 42}`
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": ` + result + `}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestParseRPCURLs(t *testing.T) {
+	got, err := ParseRPCURLs("Ethereum:Mainnet=https://eth.example, solana:devnet=http://sol.example?key=1, ethereum:mainnet=https://eth2.example")
+	if err != nil || len(got["ethereum:mainnet"]) != 2 || got["ethereum:mainnet"][1] != "https://eth2.example" || got["solana:devnet"][0] != "http://sol.example?key=1" {
+		t.Fatalf("parsed %v, %v", got, err)
+	}
+	for _, spec := range []string{"ethereum=https://x", "ethereum:mainnet", "ethereum:mainnet=wss://x"} {
+		if _, err := ParseRPCURLs(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestRawTxFetcherCaches(t *testing.T) {
+	srv, calls := fakeRPC(t, `{"status": "0x1"}`)
+	cache := &mapRawTxCache{data: map[string][]byte{}}
+	f := NewRawTxFetcher(NewRPCManager(NewChainRegistry(nil, map[string][]string{"ethereum:mainnet": {srv.URL}, "solana:devnet": {srv.URL}}), 0), cache, time.Minute)
+	ctx := context.Background()
+
+	raw, err := f.Fetch(ctx, "ethereum", "mainnet", "0xabc")
+	if err != nil || !strings.Contains(string(raw), `"receipt":{"status":"0x1"}`) || !strings.Contains(string(raw), `"hash":"0xabc"`) {
+		t.Fatalf("raw = %s, %v", raw, err)
+	}
+	if _, err := f.Fetch(ctx, "ethereum", "mainnet", "0xabc"); err != nil || *calls != 2 {
+		t.Fatalf("second fetch made %d RPC calls, want 2 (cached)", *calls)
+	}
+	if raw, err := f.Fetch(ctx, "solana", "devnet", "sig"); err != nil || string(raw) != `{"slot": 42}` {
+		t.Fatalf("solana raw = %s, %v", raw, err)
+	}
+	if _, err := f.Fetch(ctx, "ethereum", "sepolia", "0xabc"); err != errNoRPC {
+		t.Fatalf("unconfigured network error = %v", err)
+	}
+}
+
+func TestRawTxFetcherSkipsCachingPending(t *testing.T) {
+	srv, calls := fakeRPC(t, "null")
+	f := NewRawTxFetcher(NewRPCManager(NewChainRegistry(nil, map[string][]string{"ethereum:mainnet": {srv.URL}}), 0), &mapRawTxCache{data: map[string][]byte{}}, time.Minute)
+	for i := 0; i < 2; i++ {
+		raw, err := f.Fetch(context.Background(), "ethereum", "mainnet", "0xabc")
+		if err != nil || !strings.Contains(string(raw), `"receipt":null`) {
+			t.Fatalf("raw = %s, %v", raw, err)
+		}
+	}
+	if *calls != 4 {
+		t.Fatalf("made %d RPC calls, want 4 (pending transactions are not cached)", *calls)
+	}
+}
+
+func TestTransactionDetailEndpoints(t *testing.T) {
+	srv, _ := fakeRPC(t, `{"status": "0x1"}`)
+	fetcher := NewRawTxFetcher(NewRPCManager(NewChainRegistry(nil, map[string][]string{"ethereum:mainnet": {srv.URL}}), 0), nil, 0)
+	store := NewEventStore(100, 50)
+	hash := "0x" + strings.Repeat("ab", 32)
+	ev := makeEvent("eth:"+hash+":log0", "alice", "bob", "5", time.Now().UTC().Format(time.RFC3339), "")
+	ev.Chain, ev.Network, ev.TxHash = "ethereum", "mainnet", strings.ToUpper(hash[2:])
+	store.Add(ev)
+	devnet := makeEvent("sol-1", "alice", "bob", "5", time.Now().UTC().Format(time.RFC3339), "")
+	store.Add(devnet)
+
+	router := chi.NewRouter()
+	router.Get("/transactions/{event_id}", func(w http.ResponseWriter, r *http.Request) {
+		getEventDetail(store, fetcher, w, r)
+	})
+	router.Get("/tx/{chain}/{hash}", func(w http.ResponseWriter, r *http.Request) {
+		getTransactionDetail(store, fetcher, w, r)
+	})
+	get := func(path string, out interface{}) int {
+		r := httptest.NewRecorder()
+		router.ServeHTTP(r, httptest.NewRequest(http.MethodGet, path, nil))
+		if out != nil && r.Code == http.StatusOK {
+			if err := json.NewDecoder(r.Body).Decode(out); err != nil {
+				t.Fatalf("%s: decode: %v", path, err)
+			}
+		}
+		return r.Code
+	}
+
+	var detail EventDetail
+	if code := get("/transactions/eth:"+hash+":log0", &detail); code != http.StatusOK || detail.Event.EventID != ev.EventID ||
+		!strings.Contains(string(detail.Raw), `"hash":"`+hash+`"`) {
+		t.Fatalf("event detail = %d %+v raw=%s", code, detail, detail.Raw)
+	}
+	// No RPC endpoint for devnet: the event is returned without raw data
+	detail = EventDetail{}
+	if code := get("/transactions/sol-1", &detail); code != http.StatusOK || detail.Raw != nil || detail.RawError != "" {
+		t.Fatalf("unconfigured detail = %d %+v", code, detail)
+	}
+	if code := get("/transactions/missing", nil); code != http.StatusNotFound {
+		t.Fatalf("missing event = %d", code)
+	}
+
+	var tx TransactionDetail
+	if code := get("/tx/ethereum/"+strings.ToUpper(hash[2:]), &tx); code != http.StatusOK || len(tx.Events) != 1 || tx.Raw == nil {
+		t.Fatalf("tx detail = %d %+v", code, tx)
+	}
+	if code := get("/tx/ethereum/"+hash+"?network=sepolia", nil); code != http.StatusNotFound {
+		t.Fatalf("other network = %d, want 404", code)
+	}
+	if code := get("/tx/ethereum/not-a-hash", nil); code != http.StatusBadRequest {
+		t.Fatalf("bad hash = %d, want 400", code)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxReorgs bounds the reorgs the ledger keeps in memory; older ones are
+// only in the database, when one is attached.
+const maxReorgs = 1000
+
+// reorgsSchema creates the table the reorg ledger is persisted in.
+// tenant_events counts the orphaned events each tenant sees.
+const reorgsSchema = `
+	CREATE TABLE IF NOT EXISTS reorgs (
+		id BIGSERIAL PRIMARY KEY,
+		chain TEXT NOT NULL,
+		network TEXT NOT NULL,
+		fork_block BIGINT NOT NULL,
+		tip_block BIGINT NOT NULL,
+		affected_events INTEGER NOT NULL,
+		tenant_events JSONB NOT NULL DEFAULT '{}',
+		oldest_event_at TEXT NOT NULL DEFAULT '',
+		newest_event_at TEXT NOT NULL DEFAULT '',
+		detected_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_reorgs_chain_detected ON reorgs (chain, network, detected_at DESC);
+`
+
+// Reorg is a chain reorganization the tracker applied: an indexer orphaned
+// the blocks from ForkBlock on, and with them the events the tracker had
+// stored there. Downstream consumers use the ledger to find which ranges
+// of their own materializations to rebuild.
+type Reorg struct {
+	ID      int64  `json:"id"`
+	Chain   string `json:"chain"`
+	Network string `json:"network"`
+	// ForkBlock is the first orphaned height, and TipBlock the highest
+	// height an orphaned event was at (the slot on Solana).
+	ForkBlock uint64 `json:"fork_block"`
+	TipBlock  uint64 `json:"tip_block"`
+	// Depth is the number of blocks from ForkBlock to TipBlock. The
+	// orphaned tip may have been higher when no event was stored there.
+	Depth uint64 `json:"depth"`
+	// AffectedEvents is the number of events the reorg orphaned that the
+	// caller sees.
+	AffectedEvents int `json:"affected_events"`
+	// OldestEventAt and NewestEventAt bound the timestamps of every
+	// orphaned event.
+	OldestEventAt string `json:"oldest_event_at,omitempty"`
+	NewestEventAt string `json:"newest_event_at,omitempty"`
+	DetectedAt    string `json:"detected_at"`
+
+	// tenantEvents counts the orphaned events of each tenant, counting
+	// shared events for every tenant they are shared with.
+	tenantEvents map[string]int
+}
+
+// visibleTo returns the reorg as tenant sees it; "" sees every event.
+func (r Reorg) visibleTo(tenant string) Reorg {
+	if tenant != "" {
+		r.AffectedEvents = r.tenantEvents[tenant]
+	}
+	return r
+}
+
+// ReorgQuery selects reorgs from the ledger, newest first.
+type ReorgQuery struct {
+	Chain    string
+	Network  string
+	MinDepth uint64
+	Start    *time.Time
+	End      *time.Time
+	Limit    int
+}
+
+func (q ReorgQuery) matches(r Reorg) bool {
+	if r.Chain != q.Chain || (q.Network != "" && r.Network != q.Network) || r.Depth < q.MinDepth {
+		return false
+	}
+	detected, err := time.Parse(time.RFC3339, r.DetectedAt)
+	if err != nil {
+		return false
+	}
+	if q.Start != nil && detected.Before(*q.Start) {
+		return false
+	}
+	return q.End == nil || !detected.After(*q.End)
+}
+
+// ReorgLedger records every reorg that orphaned stored events. It keeps the
+// latest maxReorgs in memory and, when a database is attached, persists
+// all of them to the reorgs table.
+type ReorgLedger struct {
+	mu     sync.RWMutex
+	reorgs []Reorg // oldest first
+	nextID int64
+	db     *pgxpool.Pool
+	now    func() time.Time
+}
+
+func NewReorgLedger() *ReorgLedger {
+	return &ReorgLedger{nextID: 1, now: time.Now}
+}
+
+// AttachDB connects the ledger to Postgres and loads the latest reorgs.
+func (l *ReorgLedger) AttachDB(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx, `SELECT `+reorgColumns+` FROM reorgs ORDER BY id DESC LIMIT $1`, maxReorgs)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	loaded, err := scanReorgs(rows)
+	if err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].ID < loaded[j].ID })
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reorgs = loaded
+	if len(loaded) > 0 {
+		l.nextID = loaded[len(loaded)-1].ID + 1
+	}
+	l.db = db
+	return nil
+}
+
+// Record adds the reorgs an orphaning update applied, one per network
+// whose events it orphaned. Updates by event ID are corrections rather
+// than reorgs and are not recorded.
+func (l *ReorgLedger) Record(ctx context.Context, u ConfirmationUpdate, changes []StatusChange) []Reorg {
+	if l == nil || u.Status != StatusOrphaned || u.BlockNumber == nil || len(u.EventIDs) > 0 {
+		return nil
+	}
+	byNetwork := make(map[string]*Reorg)
+	var networks []string
+	detected := l.now().UTC().Format(time.RFC3339)
+	for _, c := range changes {
+		r := byNetwork[c.Network]
+		if r == nil {
+			r = &Reorg{Chain: u.Chain, Network: c.Network, ForkBlock: *u.BlockNumber, TipBlock: *u.BlockNumber,
+				DetectedAt: detected, tenantEvents: make(map[string]int)}
+			byNetwork[c.Network] = r
+			networks = append(networks, c.Network)
+		}
+		r.AffectedEvents++
+		if c.Tenant != "" {
+			r.tenantEvents[c.Tenant]++
+		}
+		for _, t := range c.SharedWith {
+			if t != c.Tenant {
+				r.tenantEvents[t]++
+			}
+		}
+		if c.Height != nil && *c.Height > r.TipBlock {
+			r.TipBlock = *c.Height
+		}
+		if c.Timestamp != "" {
+			if r.OldestEventAt == "" || c.Timestamp < r.OldestEventAt {
+				r.OldestEventAt = c.Timestamp
+			}
+			if c.Timestamp > r.NewestEventAt {
+				r.NewestEventAt = c.Timestamp
+			}
+		}
+	}
+	sort.Strings(networks)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]Reorg, 0, len(networks))
+	for _, network := range networks {
+		r := byNetwork[network]
+		r.Depth = r.TipBlock - r.ForkBlock + 1
+		r.ID = l.nextID
+		if l.db != nil {
+			if id, err := l.insert(ctx, r); err != nil {
+				log.WithError(err).WithFields(log.Fields{"chain": r.Chain, "network": r.Network}).Warn("failed to persist reorg; it is memory-only")
+			} else {
+				r.ID = id
+			}
+		}
+		l.nextID = r.ID + 1
+		l.reorgs = append(l.reorgs, *r)
+		out = append(out, *r)
+	}
+	if excess := len(l.reorgs) - maxReorgs; excess > 0 {
+		l.reorgs = append(l.reorgs[:0:0], l.reorgs[excess:]...)
+	}
+	return out
+}
+
+// insert persists r and returns the ID the database assigned.
+func (l *ReorgLedger) insert(ctx context.Context, r *Reorg) (int64, error) {
+	tenants, err := json.Marshal(r.tenantEvents)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	// G115: Safe conversion - block heights fit in int64 range
+	err = l.db.QueryRow(ctx, `
+		INSERT INTO reorgs (chain, network, fork_block, tip_block, affected_events, tenant_events, oldest_event_at, newest_event_at, detected_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		r.Chain, r.Network, int64(r.ForkBlock), int64(r.TipBlock), r.AffectedEvents, tenants, r.OldestEventAt, r.NewestEventAt, r.DetectedAt).Scan(&id)
+	return id, err
+}
+
+// List returns the reorgs matching q as tenant sees them, newest first.
+// Reorgs older than the in-memory window are read from the database when
+// one is attached.
+func (l *ReorgLedger) List(ctx context.Context, tenant string, q ReorgQuery) ([]Reorg, error) {
+	l.mu.RLock()
+	db := l.db
+	full := len(l.reorgs) >= maxReorgs
+	out := make([]Reorg, 0)
+	for i := len(l.reorgs) - 1; i >= 0 && len(out) < q.Limit; i-- {
+		if q.matches(l.reorgs[i]) {
+			out = append(out, l.reorgs[i].visibleTo(tenant))
+		}
+	}
+	var oldest int64
+	if len(l.reorgs) > 0 {
+		oldest = l.reorgs[0].ID
+	}
+	l.mu.RUnlock()
+
+	if db == nil || !full || len(out) == q.Limit {
+		return out, nil
+	}
+	older, err := l.listDB(ctx, db, tenant, q, oldest, q.Limit-len(out))
+	if err != nil {
+		return nil, err
+	}
+	return append(out, older...), nil
+}
+
+// listDB reads the reorgs older than the one with ID before from the
+// database.
+func (l *ReorgLedger) listDB(ctx context.Context, db *pgxpool.Pool, tenant string, q ReorgQuery, before int64, limit int) ([]Reorg, error) {
+	// G115: Safe conversion - depths are bounded by block heights
+	rows, err := db.Query(ctx, `
+		SELECT `+reorgColumns+` FROM reorgs
+		WHERE id < $1 AND chain = $2 AND ($3 = '' OR network = $3) AND tip_block - fork_block + 1 >= $4
+			AND ($5::timestamptz IS NULL OR detected_at >= $5) AND ($6::timestamptz IS NULL OR detected_at <= $6)
+		ORDER BY id DESC LIMIT $7`,
+		before, q.Chain, q.Network, int64(q.MinDepth), q.Start, q.End, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	older, err := scanReorgs(rows)
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range older {
+		older[i] = older[i].visibleTo(tenant)
+	}
+	return older, nil
+}
+
+// reorgColumns are the columns scanReorgs reads.
+const reorgColumns = `id, chain, network, fork_block, tip_block, affected_events, tenant_events, oldest_event_at, newest_event_at, detected_at`
+
+// scanReorgs decodes rows selected with reorgColumns.
+func scanReorgs(rows rowScanner) ([]Reorg, error) {
+	var out []Reorg
+	for rows.Next() {
+		var r Reorg
+		var fork, tip int64
+		var tenants []byte
+		var detected time.Time
+		if err := rows.Scan(&r.ID, &r.Chain, &r.Network, &fork, &tip, &r.AffectedEvents, &tenants, &r.OldestEventAt, &r.NewestEventAt, &detected); err != nil {
+			return nil, err
+		}
+		// G115: Safe conversion - heights are stored from uint64 values
+		r.ForkBlock, r.TipBlock = uint64(fork), uint64(tip)
+		r.Depth = r.TipBlock - r.ForkBlock + 1
+		r.DetectedAt = detected.UTC().Format(time.RFC3339)
+		if err := json.Unmarshal(tenants, &r.tenantEvents); err != nil {
+			log.WithError(err).WithField("reorg", r.ID).Warn("unreadable reorg tenant counts")
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// heightFromDB converts a nullable block height column.
+func heightFromDB(v *int64) *uint64 {
+	if v == nil || *v < 0 {
+		return nil
+	}
+	// G115: Safe conversion - checked non-negative
+	h := uint64(*v)
+	return &h
+}
+
+// getChainReorgs lists the reorgs detected on a chain, newest first.
+func getChainReorgs(ledger *ReorgLedger, w http.ResponseWriter, r *http.Request) {
+	p := newQueryParams(r)
+	chain, network, err := resolveNetwork(chi.URLParam(r, "chain"), p.String("network"))
+	if err != nil {
+		badRequest(w, invalidParam("chain", "%v", err))
+		return
+	}
+	q := ReorgQuery{
+		Chain:    chain,
+		Network:  network,
+		MinDepth: uint64(p.Int("min_depth", 0, 0, 1<<30)),
+		Start:    p.Time("start_time"),
+		End:      p.Time("end_time"),
+		Limit:    p.Int("limit", defaultPageSize, 1, maxPageSize),
+	}
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	reorgs, err := ledger.List(r.Context(), tenantFrom(r.Context()), q)
+	if err != nil {
+		log.WithError(err).Error("failed to list reorgs")
+		httpError(w, "could not list reorgs", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(reorgs)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReorgLedgerRecordsOrphaningUpdates(t *testing.T) {
+	store := NewEventStore(100, 50)
+	for i, block := range []uint64{98, 100, 101, 103} {
+		ev := makeBlockEvent(string(rune('a'+i)), block)
+		ev.Tenant = "acme"
+		if block == 103 {
+			ev.Tenant, ev.SharedWith = "other", []string{"acme"}
+		}
+		store.Add(ev)
+	}
+	mainnet := makeBlockEvent("m", 120)
+	mainnet.Network = "mainnet"
+	store.Add(mainnet)
+
+	hub := NewHub()
+	go hub.Run()
+	block := uint64(100)
+	handleConfirmation(context.Background(), store, hub, nil, ConfirmationUpdate{Chain: "ethereum", Status: StatusOrphaned, BlockNumber: &block})
+	// Orphaning by event ID is a correction, not a reorg
+	handleConfirmation(context.Background(), store, hub, nil, ConfirmationUpdate{Status: StatusOrphaned, EventIDs: []string{"a"}})
+
+	get := func(target, tenant string) []Reorg {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req = withChiParam(req, "chain", "eth")
+		if tenant != "" {
+			req = req.WithContext(withTenant(req.Context(), tenant))
+		}
+		r := httptest.NewRecorder()
+		getChainReorgs(store.reorgs, r, req)
+		if r.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", target, r.Code, r.Body.String())
+		}
+		var reorgs []Reorg
+		if err := json.NewDecoder(r.Body).Decode(&reorgs); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return reorgs
+	}
+
+	reorgs := get("/chains/eth/reorgs", "")
+	if len(reorgs) != 2 {
+		t.Fatalf("expected a reorg per network, got %+v", reorgs)
+	}
+	sepolia := reorgs[0]
+	if sepolia.Network != "sepolia" {
+		sepolia = reorgs[1]
+	}
+	if sepolia.ForkBlock != 100 || sepolia.TipBlock != 103 || sepolia.Depth != 4 || sepolia.AffectedEvents != 3 || sepolia.DetectedAt == "" {
+		t.Fatalf("sepolia reorg = %+v", sepolia)
+	}
+
+	// Tenants count the orphaned events they see, shared ones included
+	if reorgs := get("/chains/eth/reorgs?network=sepolia", "acme"); len(reorgs) != 1 || reorgs[0].AffectedEvents != 3 {
+		t.Fatalf("acme sees %+v", reorgs)
+	}
+	if reorgs := get("/chains/eth/reorgs?network=sepolia", "other"); len(reorgs) != 1 || reorgs[0].AffectedEvents != 1 {
+		t.Fatalf("other sees %+v", reorgs)
+	}
+	if reorgs := get("/chains/eth/reorgs?min_depth=5", ""); len(reorgs) != 1 || reorgs[0].Network != "mainnet" {
+		t.Fatalf("min_depth=5 returned %+v", reorgs)
+	}
+
+	r := httptest.NewRecorder()
+	getChainReorgs(store.reorgs, r, withChiParam(httptest.NewRequest(http.MethodGet, "/chains/eth/reorgs?limit=0", nil), "chain", "eth"))
+	if r.Code != http.StatusBadRequest {
+		t.Fatalf("limit=0: expected 400, got %d", r.Code)
+	}
+}
+
+func TestReorgLedgerKeepsLatest(t *testing.T) {
+	ledger := NewReorgLedger()
+	for i := 0; i < maxReorgs+5; i++ {
+		block := uint64(i)
+		ledger.Record(context.Background(), ConfirmationUpdate{Chain: "ethereum", Status: StatusOrphaned, BlockNumber: &block},
+			[]StatusChange{{EventID: "e", Network: "mainnet"}})
+	}
+	reorgs, err := ledger.List(context.Background(), "", ReorgQuery{Chain: "ethereum", Limit: maxPageSize})
+	if err != nil || len(reorgs) != maxPageSize {
+		t.Fatalf("got %d reorgs, err %v", len(reorgs), err)
+	}
+	if reorgs[0].ID != maxReorgs+5 || reorgs[0].ForkBlock != maxReorgs+4 {
+		t.Fatalf("newest reorg = %+v", reorgs[0])
+	}
+	if len(ledger.reorgs) != maxReorgs {
+		t.Fatalf("ledger keeps %d reorgs in memory", len(ledger.reorgs))
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultReplayBufferSize is how many recent frames the hub keeps for
+	// clients resuming with Last-Event-ID. Override with SSE_REPLAY_BUFFER.
+	defaultReplayBufferSize = 1000
+	// sseClientBuffer lets an SSE client fall slightly behind (e.g. while a
+	// replay is being written) before the hub drops it.
+	sseClientBuffer = 64
+)
+
+// Frame is a broadcast message tagged with a monotonically increasing ID,
+// which SSE clients echo back in Last-Event-ID when they reconnect.
+type Frame struct {
+	ID   uint64
+	Data []byte
+	At   time.Time
+	// Tenants are the tenants whose subscribers may also see the frame;
+	// unscoped subscribers see every frame.
+	Tenants []string
+	// Addresses are the addressKeys of the wallets the frame concerns,
+	// whose watchers receive it too.
+	Addresses []string
+	// Shapes holds an event frame's data in the payload shapes other than
+	// full (see payloadShapes).
+	Shapes map[string][]byte
+}
+
+// visibleTo reports whether a subscriber scoped to tenant may see the frame.
+func (f Frame) visibleTo(tenant string) bool {
+	return tenant == "" || containsToken(f.Tenants, tenant)
+}
+
+// initialFrameID seeds frame IDs from the wall clock (milliseconds x 1000) so
+// IDs keep increasing across restarts and a Last-Event-ID from a previous
+// process never hides new frames. The result stays below 2^53, so JavaScript
+// clients can treat IDs as numbers.
+func initialFrameID() uint64 {
+	// G115: Safe conversion - the Unix epoch is in the past
+	return uint64(time.Now().UnixMilli()) * 1000
+}
+
+// replayBuffer is a fixed-size ring of the most recent frames.
+type replayBuffer struct {
+	frames []Frame
+	next   int
+	full   bool
+}
+
+func newReplayBuffer(size int) *replayBuffer {
+	if size <= 0 {
+		size = defaultReplayBufferSize
+	}
+	return &replayBuffer{frames: make([]Frame, size)}
+}
+
+func (b *replayBuffer) add(f Frame) {
+	b.frames[b.next] = f
+	b.next = (b.next + 1) % len(b.frames)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// since returns buffered frames, oldest first, that the cursor has not seen
+// and a subscriber scoped to tenant may see.
+func (b *replayBuffer) since(c replayCursor, tenant string) []Frame {
+	var ordered []Frame
+	if b.full {
+		ordered = append(ordered, b.frames[b.next:]...)
+	}
+	ordered = append(ordered, b.frames[:b.next]...)
+
+	out := make([]Frame, 0)
+	for _, f := range ordered {
+		if c.after(f) && f.visibleTo(tenant) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// replayCursor marks the position a client resumes from: either the last
+// frame ID it received or a point in time.
+type replayCursor struct {
+	lastID uint64
+	since  time.Time
+}
+
+func (c replayCursor) after(f Frame) bool {
+	if !c.since.IsZero() {
+		return !f.At.Before(c.since)
+	}
+	return f.ID > c.lastID
+}
+
+// parseReplayCursor reads the resume position from the Last-Event-ID header
+// or the since query parameter, which accepts a frame ID or an RFC3339 time.
+func parseReplayCursor(r *http.Request) (replayCursor, bool) {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		if n, err := strconv.ParseUint(id, 10, 64); err == nil {
+			return replayCursor{lastID: n}, true
+		}
+	}
+	if since := r.URL.Query().Get("since"); since != "" {
+		if n, err := strconv.ParseUint(since, 10, 64); err == nil {
+			return replayCursor{lastID: n}, true
+		}
+		if t, err := time.Parse(time.RFC3339, since); err == nil {
+			return replayCursor{since: t}, true
+		}
+	}
+	return replayCursor{}, false
+}
+
+// SetReplayBufferSize resizes the replay buffer, discarding buffered frames.
+// It is meant to be called before Run.
+func (h *Hub) SetReplayBufferSize(size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.replay = newReplayBuffer(size)
+}
+
+// subscribe registers a client and returns the frames it missed. Both happen
+// under the hub lock so no frame is lost or delivered twice between the
+// replay and the live stream.
+func (h *Hub) subscribe(client chan Frame, tenant string, c replayCursor) []Frame {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[client] = tenant
+	missed := h.replay.since(c, tenant)
+	log.WithField("replayed", len(missed)).Info("client registered")
+	return missed
+}
+
+// writeSSEFrame writes a frame in a payload shape with its ID so the
+// browser tracks Last-Event-ID for reconnects.
+func writeSSEFrame(w http.ResponseWriter, f Frame, shape string) {
+	fmt.Fprintf(w, "id: %d\ndata: %s\n\n", f.ID, f.payload(shape))
+	if fl, ok := w.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayBufferWrapsAndFiltersByID(t *testing.T) {
+	b := newReplayBuffer(3)
+	for i := uint64(1); i <= 5; i++ {
+		b.add(Frame{ID: i, Data: []byte("x")})
+	}
+
+	all := b.since(replayCursor{}, "")
+	if len(all) != 3 || all[0].ID != 3 || all[2].ID != 5 {
+		t.Fatalf("expected frames 3..5 oldest first, got %+v", all)
+	}
+	if got := b.since(replayCursor{lastID: 4}, ""); len(got) != 1 || got[0].ID != 5 {
+		t.Fatalf("expected only frame 5 after id 4, got %+v", got)
+	}
+}
+
+func TestParseReplayCursor(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/events/subscribe", nil)
+	if _, ok := parseReplayCursor(req); ok {
+		t.Fatalf("expected no cursor without header or since")
+	}
+
+	req.Header.Set("Last-Event-ID", "42")
+	if c, ok := parseReplayCursor(req); !ok || c.lastID != 42 {
+		t.Fatalf("expected cursor at 42, got %+v", c)
+	}
+
+	ts := time.Now().UTC().Truncate(time.Second)
+	req = httptest.NewRequest(http.MethodGet, "/events/subscribe?since="+ts.Format(time.RFC3339), nil)
+	if c, ok := parseReplayCursor(req); !ok || !c.since.Equal(ts) {
+		t.Fatalf("expected time cursor at %v, got %+v", ts, c)
+	}
+}
+
+func TestSSEReplaysMissedFramesOnReconnect(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	// Broadcast two frames before the client connects
+	hub.broadcast <- []byte(`{"event_id":"first"}`)
+	hub.broadcast <- []byte(`{"event_id":"second"}`)
+
+	// Wait until both frames are buffered
+	var frames []Frame
+	waitUntil := time.Now().Add(time.Second)
+	for time.Now().Before(waitUntil) {
+		hub.mu.Lock()
+		frames = hub.replay.since(replayCursor{}, "")
+		hub.mu.Unlock()
+		if len(frames) == 2 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(frames) != 2 || frames[1].ID != frames[0].ID+1 {
+		t.Fatalf("expected two consecutive frames, got %+v", frames)
+	}
+
+	tw := newTestRW()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/events/subscribe", nil).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", itoa(frames[0].ID))
+	go serveSSE(hub, tw, req)
+
+	select {
+	case b := <-tw.writes:
+		s := string(b)
+		if !strings.Contains(s, `"event_id":"second"`) || !strings.HasPrefix(s, "id: "+itoa(frames[1].ID)) {
+			t.Fatalf("expected replay of the second frame, got %q", s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("missed frame was not replayed")
+	}
+}
+
+func itoa(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
@@ -0,0 +1,624 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Reasons the cache evicts events, as reported by WriteMetrics.
+const (
+	evictLimit  = "limit"  // over the global or per-wallet event limit
+	evictTTL    = "ttl"    // held longer than the event TTL
+	evictMemory = "memory" // over the memory budget
+)
+
+// eventOverhead approximates what the cache spends on an event besides its
+// encoded size: the struct, its pointers and the index entries.
+const eventOverhead = 512
+
+// MemoryRepository keeps the most recent events in memory, bounded by a
+// global limit and a per-wallet limit, and optionally by age and estimated
+// memory. It is the default backend and also serves as the EventStore's
+// cache in front of durable backends.
+type MemoryRepository struct {
+	mu                 sync.RWMutex
+	events             []*Event
+	eventsByWallet     map[string][]*Event
+	entries            map[string]*cacheEntry // event_id -> entry
+	maxTotalEvents     int
+	maxEventsPerWallet int
+	// ttl and maxBytes bound how long events are held and the estimated
+	// bytes they take; zero leaves them unbounded.
+	ttl       time.Duration
+	maxBytes  int64
+	bytes     int64
+	evictions map[string]uint64 // by reason
+	now       func() time.Time
+}
+
+// cacheEntry tracks a held event.
+type cacheEntry struct {
+	refs  int       // number of lists holding it
+	added time.Time // when it was inserted, which the TTL counts from
+	size  int64     // estimated bytes, see eventSize
+}
+
+func NewMemoryRepository(maxTotalEvents, maxEventsPerWallet int) *MemoryRepository {
+	return &MemoryRepository{
+		events:             make([]*Event, 0),
+		eventsByWallet:     make(map[string][]*Event),
+		entries:            make(map[string]*cacheEntry),
+		maxTotalEvents:     maxTotalEvents,
+		maxEventsPerWallet: maxEventsPerWallet,
+		evictions:          make(map[string]uint64),
+		now:                time.Now,
+	}
+}
+
+// eventSize estimates the memory ev takes from the size of its JSON
+// encoding.
+func eventSize(ev *Event) int64 {
+	b, _ := json.Marshal(ev)
+	return int64(len(b)) + eventOverhead
+}
+
+// SetBudget bounds how long events are held and the estimated memory they
+// take, evicting what is already over the new bounds. Zero leaves a bound
+// off. Expired events are dropped by Expire, so RunExpiry must run for the
+// TTL to apply.
+func (m *MemoryRepository) SetBudget(ttl time.Duration, maxBytes int64) {
+	m.mu.Lock()
+	m.ttl, m.maxBytes = ttl, maxBytes
+	m.shrink()
+	m.mu.Unlock()
+	m.Expire()
+}
+
+// prepend adds ev to the front of list and trims it to max, releasing the
+// references of dropped events. Callers hold the write lock.
+func (m *MemoryRepository) prepend(list []*Event, ev *Event, max int) []*Event {
+	list = append([]*Event{ev}, list...)
+	m.entries[ev.EventID].refs++
+	if len(list) > max {
+		for _, dropped := range list[max:] {
+			m.release(dropped)
+		}
+		list = list[:max]
+	}
+	return list
+}
+
+// release drops a reference to ev, forgetting it once no list holds it.
+// Callers hold the write lock.
+func (m *MemoryRepository) release(ev *Event) {
+	e, ok := m.entries[ev.EventID]
+	if !ok {
+		return
+	}
+	if e.refs--; e.refs <= 0 {
+		delete(m.entries, ev.EventID)
+		m.bytes -= e.size
+		m.evictions[evictLimit]++
+	}
+}
+
+// Insert adds an event to the in-memory indexes. Addresses are normalized to
+// lowercase for case-insensitive lookups and the tx hash to its canonical
+// form. Events already held are ignored. Oldest entries are trimmed when
+// limits, or the memory budget, are exceeded.
+func (m *MemoryRepository) Insert(_ context.Context, event *Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.insert(event)
+	return nil
+}
+
+// insert is Insert with the write lock held.
+func (m *MemoryRepository) insert(event *Event) {
+	if _, ok := m.entries[event.EventID]; ok && event.EventID != "" {
+		return
+	}
+
+	event.TxHash = normalizeTxHash(event.Chain, event.TxHash)
+	if event.Status == "" {
+		event.Status = StatusConfirmed
+	}
+
+	// Events without an event_id share an entry, which holds their sizes
+	e, ok := m.entries[event.EventID]
+	if !ok {
+		e = &cacheEntry{added: m.now()}
+		m.entries[event.EventID] = e
+	}
+	size := eventSize(event)
+	e.size += size
+	m.bytes += size
+
+	m.events = m.prepend(m.events, event, m.maxTotalEvents)
+	// Wallets are indexed by addressKey, so EVM lookups ignore case
+	from, to := addressKey(event.From), addressKey(event.To)
+	m.eventsByWallet[from] = m.prepend(m.eventsByWallet[from], event, m.maxEventsPerWallet)
+	m.eventsByWallet[to] = m.prepend(m.eventsByWallet[to], event, m.maxEventsPerWallet)
+	m.shrink()
+}
+
+// shrink evicts the longest-held events while the cache is over its memory
+// budget, down to 90% of it so that inserts do not evict one event at a
+// time. Callers hold the write lock.
+func (m *MemoryRepository) shrink() {
+	if m.maxBytes <= 0 || m.bytes <= m.maxBytes {
+		return
+	}
+	ids := make([]string, 0, len(m.entries))
+	for id := range m.entries {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return m.entries[ids[i]].added.Before(m.entries[ids[j]].added) })
+	evict := make(map[string]bool)
+	for bytes, target := m.bytes, m.maxBytes-m.maxBytes/10; bytes > target && len(evict) < len(ids); {
+		id := ids[len(evict)]
+		evict[id] = true
+		bytes -= m.entries[id].size
+	}
+	m.evictions[evictMemory] += uint64(m.retain(func(ev *Event) bool { return !evict[ev.EventID] }))
+}
+
+// Expire drops the events held longer than the TTL and returns how many.
+func (m *MemoryRepository) Expire() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ttl <= 0 {
+		return 0
+	}
+	cutoff := m.now().Add(-m.ttl)
+	n := m.retain(func(ev *Event) bool {
+		e, ok := m.entries[ev.EventID]
+		return !ok || e.added.After(cutoff)
+	})
+	m.evictions[evictTTL] += uint64(n)
+	return n
+}
+
+// RunExpiry expires events every tenth of the TTL, between once a second
+// and once a minute, until ctx is done. Expired events are served until
+// they are dropped.
+func (m *MemoryRepository) RunExpiry(ctx context.Context) {
+	m.mu.RLock()
+	interval := m.ttl / 10
+	m.mu.RUnlock()
+	if interval <= 0 {
+		return
+	}
+	if interval < time.Second {
+		interval = time.Second
+	} else if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Expire()
+		}
+	}
+}
+
+func (m *MemoryRepository) InsertBatch(ctx context.Context, events []*Event) error {
+	for _, ev := range events {
+		if err := m.Insert(ctx, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryRepository) ByWallet(_ context.Context, address string, filter EventFilter) ([]*Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var filtered []*Event
+	for _, event := range m.eventsByWallet[addressKey(address)] {
+		if filter.Matches(event) {
+			filtered = append(filtered, event)
+		}
+	}
+	sortEvents(filtered, filter)
+	return paginate(filtered, filter), nil
+}
+
+func (m *MemoryRepository) ByWallets(_ context.Context, addresses []string, filter EventFilter) ([]*Event, error) {
+	m.mu.RLock()
+	seen := make(map[*Event]struct{})
+	var merged []*Event
+	for _, address := range addresses {
+		for _, ev := range m.eventsByWallet[addressKey(address)] {
+			if _, dup := seen[ev]; dup || !filter.Matches(ev) {
+				continue
+			}
+			seen[ev] = struct{}{}
+			merged = append(merged, ev)
+		}
+	}
+	m.mu.RUnlock()
+
+	// RFC3339 UTC timestamps sort chronologically as strings
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Timestamp > merged[j].Timestamp })
+	sortEvents(merged, filter)
+	return paginate(merged, filter), nil
+}
+
+func (m *MemoryRepository) Recent(_ context.Context, filter EventFilter) ([]*Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	filtered := make([]*Event, 0, len(m.events))
+	for _, event := range m.events {
+		if filter.Matches(event) {
+			filtered = append(filtered, event)
+		}
+	}
+	sortEvents(filtered, filter)
+	return paginate(filtered, filter), nil
+}
+
+func (m *MemoryRepository) Count(_ context.Context, addresses []string, filter EventFilter) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n := 0
+	if len(addresses) == 0 {
+		for _, ev := range m.events {
+			if filter.Matches(ev) {
+				n++
+			}
+		}
+		return n, nil
+	}
+	seen := make(map[*Event]struct{})
+	for _, address := range addresses {
+		for _, ev := range m.eventsByWallet[addressKey(address)] {
+			if _, dup := seen[ev]; dup || !filter.Matches(ev) {
+				continue
+			}
+			seen[ev] = struct{}{}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Sample draws from the cached events matching the listing and returns
+// their exact count.
+func (m *MemoryRepository) Sample(ctx context.Context, addresses []string, filter EventFilter, size int) ([]*Event, int, error) {
+	all := filter
+	all.Limit, all.Offset = math.MaxInt32, 0
+	var events []*Event
+	if len(addresses) > 0 {
+		events, _ = m.ByWallets(ctx, addresses, all)
+	} else {
+		events, _ = m.Recent(ctx, all)
+	}
+	return reservoirSample(events, size), len(events), nil
+}
+
+func (m *MemoryRepository) ByID(_ context.Context, eventID string) (*Event, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, event := range m.events {
+		if event.EventID == eventID {
+			return event, true, nil
+		}
+	}
+	for _, events := range m.eventsByWallet {
+		for _, event := range events {
+			if event.EventID == eventID {
+				return event, true, nil
+			}
+		}
+	}
+	return nil, false, nil
+}
+
+func (m *MemoryRepository) ByTxHash(_ context.Context, hash, chain string) ([]*Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]*Event, 0)
+	for _, ev := range m.events {
+		if chain != "" && ev.Chain != chain {
+			continue
+		}
+		if normalizeTxHash(ev.Chain, ev.TxHash) == hash {
+			out = append(out, ev)
+		}
+	}
+	return out, nil
+}
+
+// ApplyConfirmation moves matching events to the update's status.
+func (m *MemoryRepository) ApplyConfirmation(_ context.Context, u ConfirmationUpdate) ([]StatusChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var changes []StatusChange
+	// Events are shared by pointer with readers, so replace them with updated
+	// copies rather than mutating in place.
+	replaced := make(map[*Event]*Event)
+	replace := func(list []*Event) {
+		for i, ev := range list {
+			if updated, ok := replaced[ev]; ok {
+				list[i] = updated
+				continue
+			}
+			if !u.appliesTo(ev) {
+				continue
+			}
+			updated := *ev
+			updated.Status = u.Status
+			replaced[ev] = &updated
+			list[i] = &updated
+			var height *uint64
+			if h, ok := eventHeight(ev); ok {
+				height = &h
+			}
+			changes = append(changes, StatusChange{
+				Type:           "status_change",
+				EventID:        ev.EventID,
+				Chain:          ev.Chain,
+				Network:        ev.Network,
+				TxHash:         ev.TxHash,
+				Status:         u.Status,
+				PreviousStatus: ev.Status,
+				Tenant:         ev.Tenant,
+				SharedWith:     ev.SharedWith,
+				From:           ev.From,
+				To:             ev.To,
+				Height:         height,
+				Timestamp:      ev.Timestamp,
+			})
+		}
+	}
+	replace(m.events)
+	for _, list := range m.eventsByWallet {
+		replace(list)
+	}
+	return changes, nil
+}
+
+// UpdateMetadata merges ev into the held event with its event_id. Like
+// ApplyConfirmation it replaces the event with an updated copy.
+func (m *MemoryRepository) UpdateMetadata(_ context.Context, ev *Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var updated *Event
+	replace := func(list []*Event) {
+		for i, held := range list {
+			if held.EventID != ev.EventID {
+				continue
+			}
+			if updated == nil {
+				updated, _ = mergeDuplicate(held, ev)
+			}
+			list[i] = updated
+		}
+	}
+	replace(m.events)
+	for _, list := range m.eventsByWallet {
+		replace(list)
+	}
+	return nil
+}
+
+// snapshot copies the global list so aggregation runs without the lock.
+func (m *MemoryRepository) snapshot() []*Event {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]*Event(nil), m.events...)
+}
+
+func (m *MemoryRepository) VolumeSeries(_ context.Context, q AnalyticsQuery) ([]VolumePoint, error) {
+	return aggregateVolume(m.snapshot(), q), nil
+}
+
+func (m *MemoryRepository) ActiveWalletsSeries(_ context.Context, q AnalyticsQuery) ([]ActiveWalletsPoint, error) {
+	return aggregateActiveWallets(m.snapshot(), q), nil
+}
+
+func (m *MemoryRepository) WalletStats(_ context.Context, address string, filter EventFilter) (WalletStats, error) {
+	m.mu.RLock()
+	var events []*Event
+	for _, ev := range m.eventsByWallet[addressKey(address)] {
+		if filter.Matches(ev) {
+			events = append(events, ev)
+		}
+	}
+	m.mu.RUnlock()
+	return aggregateWalletStats(address, events), nil
+}
+
+// PurgeBefore drops the cached events whose timestamp is before cutoff; the
+// cache does not record when events were received. Events with an
+// unparsable timestamp are kept.
+func (m *MemoryRepository) PurgeBefore(_ context.Context, cutoff time.Time, _ int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.retain(func(ev *Event) bool {
+		ts, err := time.Parse(time.RFC3339, ev.Timestamp)
+		return err != nil || !ts.Before(cutoff)
+	}), nil
+}
+
+// PurgeSegment drops the cached events of seg's chain and network whose
+// timestamp falls in seg's month. Events with an unparsable timestamp are
+// kept.
+func (m *MemoryRepository) PurgeSegment(seg RetentionSegment) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.retain(func(ev *Event) bool {
+		ts, err := time.Parse(time.RFC3339, ev.Timestamp)
+		return err != nil || ev.Chain != seg.Chain || ev.Network != seg.Network || ts.Before(seg.Month) || !ts.Before(seg.End())
+	})
+}
+
+// CacheStats describes the contents and limits of a MemoryRepository.
+type CacheStats struct {
+	Events             int `json:"events"`
+	Wallets            int `json:"wallets"`
+	MaxEvents          int `json:"max_events"`
+	MaxEventsPerWallet int `json:"max_events_per_wallet"`
+	// Bytes estimates the memory the events take; MaxBytes and TTL are
+	// the memory budget and event TTL, when set.
+	Bytes    int64  `json:"bytes"`
+	MaxBytes int64  `json:"max_bytes,omitempty"`
+	TTL      string `json:"ttl,omitempty"`
+}
+
+// Stats returns the number of distinct events and wallets held, their
+// estimated size and the current limits.
+func (m *MemoryRepository) Stats() CacheStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stats := CacheStats{
+		Events:             len(m.entries),
+		Wallets:            len(m.eventsByWallet),
+		MaxEvents:          m.maxTotalEvents,
+		MaxEventsPerWallet: m.maxEventsPerWallet,
+		Bytes:              m.bytes,
+		MaxBytes:           m.maxBytes,
+	}
+	if m.ttl > 0 {
+		stats.TTL = m.ttl.String()
+	}
+	return stats
+}
+
+// WriteMetrics writes the size of the cache, its memory budget and how many
+// events it evicted, by reason, in the Prometheus text format.
+func (m *MemoryRepository) WriteMetrics(out io.Writer) {
+	stats := m.Stats()
+	m.mu.RLock()
+	evictions := make(map[string]uint64, len(m.evictions))
+	for reason, n := range m.evictions {
+		evictions[reason] = n
+	}
+	m.mu.RUnlock()
+	fmt.Fprintf(out, "# HELP tracker_cache_events Distinct events held in memory.\n# TYPE tracker_cache_events gauge\ntracker_cache_events %d\n", stats.Events)
+	fmt.Fprintf(out, "# HELP tracker_cache_wallets Wallets with events held in memory.\n# TYPE tracker_cache_wallets gauge\ntracker_cache_wallets %d\n", stats.Wallets)
+	fmt.Fprintf(out, "# HELP tracker_cache_bytes Estimated memory taken by the events held.\n# TYPE tracker_cache_bytes gauge\ntracker_cache_bytes %d\n", stats.Bytes)
+	fmt.Fprintf(out, "# HELP tracker_cache_max_bytes Memory budget of the events held, 0 when unbounded.\n# TYPE tracker_cache_max_bytes gauge\ntracker_cache_max_bytes %d\n", stats.MaxBytes)
+	fmt.Fprintf(out, "# HELP tracker_cache_evictions_total Events dropped from memory, by reason.\n# TYPE tracker_cache_evictions_total counter\n")
+	for _, reason := range []string{evictLimit, evictTTL, evictMemory} {
+		fmt.Fprintf(out, "tracker_cache_evictions_total{reason=%q} %d\n", reason, evictions[reason])
+	}
+}
+
+// Resize changes the global and per-wallet limits, trimming the oldest
+// events from lists over the new limits, and returns how many events were
+// dropped.
+func (m *MemoryRepository) Resize(maxTotalEvents, maxEventsPerWallet int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxTotalEvents = maxTotalEvents
+	m.maxEventsPerWallet = maxEventsPerWallet
+	n := m.retain(func(*Event) bool { return true })
+	m.evictions[evictLimit] += uint64(n)
+	return n
+}
+
+// Dump returns every distinct event held, in the global list or only in a
+// wallet's history, newest first.
+func (m *MemoryRepository) Dump() []*Event {
+	m.mu.RLock()
+	seen := make(map[*Event]struct{}, len(m.entries))
+	out := make([]*Event, 0, len(m.entries))
+	add := func(list []*Event) {
+		for _, ev := range list {
+			if _, dup := seen[ev]; !dup {
+				seen[ev] = struct{}{}
+				out = append(out, ev)
+			}
+		}
+	}
+	add(m.events)
+	for _, list := range m.eventsByWallet {
+		add(list)
+	}
+	m.mu.RUnlock()
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Timestamp > out[j].Timestamp })
+	return out
+}
+
+// Restore replaces the cache contents with events, given newest first as
+// Dump returns them. They are inserted oldest first, so the limits keep
+// the newest, and their TTL starts over.
+func (m *MemoryRepository) Restore(events []*Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = make([]*Event, 0)
+	m.eventsByWallet = make(map[string][]*Event)
+	m.entries = make(map[string]*cacheEntry)
+	m.bytes = 0
+	for i := len(events) - 1; i >= 0; i-- {
+		m.insert(events[i])
+	}
+}
+
+// retain removes the events keep rejects, trims every list to the current
+// limits and recounts references and bytes. It returns how many distinct
+// events are no longer held. Callers hold the write lock.
+func (m *MemoryRepository) retain(keep func(*Event) bool) int {
+	held := len(m.entries)
+	filter := func(list []*Event, max int) []*Event {
+		out := make([]*Event, 0, len(list))
+		for _, ev := range list {
+			if len(out) == max {
+				break
+			}
+			if keep(ev) {
+				out = append(out, ev)
+			}
+		}
+		return out
+	}
+	entries := make(map[string]*cacheEntry, len(m.entries))
+	hold := func(ev *Event) {
+		e, ok := entries[ev.EventID]
+		if !ok {
+			e = &cacheEntry{added: m.now()}
+			if old, ok := m.entries[ev.EventID]; ok {
+				e.added, e.size = old.added, old.size
+			}
+			entries[ev.EventID] = e
+		}
+		e.refs++
+	}
+	m.events = filter(m.events, m.maxTotalEvents)
+	for _, ev := range m.events {
+		hold(ev)
+	}
+	for wallet, list := range m.eventsByWallet {
+		list = filter(list, m.maxEventsPerWallet)
+		if len(list) == 0 {
+			delete(m.eventsByWallet, wallet)
+			continue
+		}
+		m.eventsByWallet[wallet] = list
+		for _, ev := range list {
+			hold(ev)
+		}
+	}
+	m.entries, m.bytes = entries, 0
+	for _, e := range entries {
+		m.bytes += e.size
+	}
+	return held - len(entries)
+}
+
+func (m *MemoryRepository) Close() {}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+// PartitionedRepository stores events in a plain Postgres table partitioned
+// by month of created_at, for deployments that want cheap retention without
+// TimescaleDB: a month that has aged out is dropped as a whole table
+// instead of deleted row by row. Reads and status updates are shared with
+// PostgresRepository and writes with TimescaleRepository; created_at is
+// the event's own timestamp, so a redelivered event lands in the same
+// partition. Partitions are created as events for their month arrive.
+type PartitionedRepository struct {
+	*PostgresRepository
+
+	mu     sync.Mutex
+	months map[string]bool // partitions known to exist, by name
+}
+
+// EventPartition is a monthly partition of the events table.
+type EventPartition struct {
+	Name  string `json:"name"`
+	Month string `json:"month"`
+	// EstimatedRows is Postgres's estimate, as of the last ANALYZE.
+	EstimatedRows int64 `json:"estimated_rows"`
+}
+
+// pgExecer is the Exec of a pool or a transaction.
+type pgExecer interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// OpenPartitionedRepository connects to dsn and creates the partitioned
+// events table if needed.
+func OpenPartitionedRepository(ctx context.Context, dsn string) (*PartitionedRepository, error) {
+	db, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := initPartitioned(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	p := &PartitionedRepository{PostgresRepository: &PostgresRepository{db: db}, months: make(map[string]bool)}
+	// The current and next months are ready before their first event
+	now := time.Now().UTC()
+	for _, month := range []time.Time{monthStart(now), monthStart(now).AddDate(0, 1, 0)} {
+		if err := p.ensureMonth(ctx, month); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// partitionedMigrations are the schema changes of the partitioned backend,
+// tracked apart from the others like timescaleMigrations. They run before
+// the events table is partitioned, so they must also apply to a
+// partitioned table.
+var partitionedMigrations = []migration{
+	{Version: 1, Name: "baseline", SQL: keylessEventsSchema + chainsSchema + viewsSchema + pluginsSchema + metricsSchema + correlationsSchema + bridgeRoutesSchema},
+	timescaleMigrations[1],
+	timescaleMigrations[2],
+	timescaleMigrations[3],
+	timescaleMigrations[4],
+	timescaleMigrations[5],
+	timescaleMigrations[6],
+	timescaleMigrations[7],
+	timescaleMigrations[8],
+	timescaleMigrations[9],
+	timescaleMigrations[10],
+	timescaleMigrations[11],
+	timescaleMigrations[12],
+	timescaleMigrations[13],
+	timescaleMigrations[14],
+}
+
+// initPartitioned migrates the schema, then converts a plain events table,
+// new or left by the postgres backend, into a partitioned one: its rows
+// are copied into monthly partitions, which can take a while on a large
+// table. Unique keys must include created_at, as on the timescale backend.
+func initPartitioned(ctx context.Context, db *pgxpool.Pool) error {
+	if err := migratePostgres(ctx, db, "partitioned_migrations", partitionedMigrations); err != nil {
+		return err
+	}
+	if err := partitionEvents(ctx, db); err != nil {
+		return err
+	}
+	_, err := db.Exec(ctx, `
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_events_event_id_created ON events (event_id, created_at);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_events_dedup_key_created ON events (dedup_key, created_at);
+		CREATE INDEX IF NOT EXISTS idx_events_created ON events (created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_events_from ON events (LOWER(from_addr), created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_events_to ON events (LOWER(to_addr), created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_events_from_exact ON events (from_addr, created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_events_to_exact ON events (to_addr, created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_events_tx_hash ON events (tx_hash);
+		CREATE INDEX IF NOT EXISTS idx_events_tx_hash_lower ON events (LOWER(tx_hash));
+		CREATE INDEX IF NOT EXISTS idx_events_chain_height ON events (chain, network, (COALESCE(block_number, slot)));
+		CREATE INDEX IF NOT EXISTS idx_events_tenant_created ON events (tenant, created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_events_bridge_sequence ON events (bridge, bridge_sequence) WHERE bridge <> '';
+		CREATE INDEX IF NOT EXISTS idx_events_tx_hash_trgm ON events USING GIN (LOWER(tx_hash) gin_trgm_ops);
+		CREATE INDEX IF NOT EXISTS idx_events_from_trgm ON events USING GIN (LOWER(from_addr) gin_trgm_ops);
+		CREATE INDEX IF NOT EXISTS idx_events_to_trgm ON events USING GIN (LOWER(to_addr) gin_trgm_ops);
+		CREATE INDEX IF NOT EXISTS idx_events_token_symbol_trgm ON events USING GIN (LOWER(token_symbol) gin_trgm_ops);
+		CREATE INDEX IF NOT EXISTS idx_events_token_address_trgm ON events USING GIN (LOWER(token_address) gin_trgm_ops);
+	`)
+	return err
+}
+
+// partitionEvents replaces a plain events table with a partitioned copy, in
+// one transaction under the migration lock. The old table's indexes are
+// dropped with it.
+func partitionEvents(ctx context.Context, db *pgxpool.Pool) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return err
+	}
+	var kind string
+	if err := tx.QueryRow(ctx, `SELECT relkind::text FROM pg_class WHERE oid = to_regclass('events')`).Scan(&kind); err != nil {
+		return err
+	}
+	if kind != "r" {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, `
+		ALTER TABLE events RENAME TO events_unpartitioned;
+		CREATE TABLE events (LIKE events_unpartitioned INCLUDING DEFAULTS) PARTITION BY RANGE (created_at);
+	`); err != nil {
+		return err
+	}
+	var oldest, newest *time.Time
+	if err := tx.QueryRow(ctx, `SELECT MIN(created_at), MAX(created_at) FROM events_unpartitioned`).Scan(&oldest, &newest); err != nil {
+		return err
+	}
+	if oldest != nil {
+		for month := monthStart(*oldest); !month.After(*newest); month = month.AddDate(0, 1, 0) {
+			if err := createPartition(ctx, tx, month); err != nil {
+				return err
+			}
+		}
+	}
+	tag, err := tx.Exec(ctx, `INSERT INTO events SELECT * FROM events_unpartitioned`)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DROP TABLE events_unpartitioned`); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	log.WithField("events", tag.RowsAffected()).Info("events table partitioned by month")
+	return nil
+}
+
+// monthStart returns the first instant of t's month, in UTC.
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// partitionName is the table holding the events of month.
+func partitionName(month time.Time) string {
+	return "events_" + month.UTC().Format("2006_01")
+}
+
+// partitionMonth parses the month of a partitionName.
+func partitionMonth(name string) (time.Time, bool) {
+	month, err := time.Parse("2006_01", strings.TrimPrefix(name, "events_"))
+	return month, err == nil && strings.HasPrefix(name, "events_")
+}
+
+// createPartition creates the partition of month if it does not exist.
+func createPartition(ctx context.Context, db pgExecer, month time.Time) error {
+	_, err := db.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+partitionName(month)+` PARTITION OF events
+		FOR VALUES FROM ('`+month.Format(time.RFC3339)+`') TO ('`+month.AddDate(0, 1, 0).Format(time.RFC3339)+`')`)
+	return err
+}
+
+// ensureMonth creates the partition of month unless it is known to exist.
+// Another replica creating it at the same moment is not an error.
+func (p *PartitionedRepository) ensureMonth(ctx context.Context, month time.Time) error {
+	name := partitionName(month)
+	p.mu.Lock()
+	known := p.months[name]
+	p.mu.Unlock()
+	if known {
+		return nil
+	}
+	if err := createPartition(ctx, p.db, month); err != nil {
+		var exists bool
+		if p.db.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists) != nil || !exists {
+			return err
+		}
+	}
+	p.mu.Lock()
+	p.months[name] = true
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *PartitionedRepository) Insert(ctx context.Context, ev *Event) error {
+	return p.InsertBatch(ctx, []*Event{ev})
+}
+
+// InsertBatch creates the partitions the events need, then stores them
+// like TimescaleRepository.InsertBatch. When another replica's retention
+// job dropped a partition this one knew of, the partitions are checked
+// again and the batch retried once.
+func (p *PartitionedRepository) InsertBatch(ctx context.Context, events []*Event) error {
+	months := make(map[time.Time]bool)
+	for _, ev := range events {
+		months[monthStart(partitionTime(ev))] = true
+	}
+	for attempt := 0; ; attempt++ {
+		for month := range months {
+			if err := p.ensureMonth(ctx, month); err != nil {
+				return err
+			}
+		}
+		err := insertStaged(ctx, p.db, events)
+		var pgErr *pgconn.PgError
+		// check_violation: no partition of relation found for row
+		if attempt > 0 || !errors.As(err, &pgErr) || pgErr.Code != "23514" {
+			return err
+		}
+		p.mu.Lock()
+		p.months = make(map[string]bool)
+		p.mu.Unlock()
+	}
+}
+
+// Partitions lists the monthly partitions, oldest first.
+func (p *PartitionedRepository) Partitions(ctx context.Context) ([]EventPartition, error) {
+	rows, err := p.db.Query(ctx, `
+		SELECT c.relname, c.reltuples::bigint
+		FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'events'::regclass
+		ORDER BY c.relname
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]EventPartition, 0)
+	for rows.Next() {
+		var part EventPartition
+		if err := rows.Scan(&part.Name, &part.EstimatedRows); err != nil {
+			return nil, err
+		}
+		if month, ok := partitionMonth(part.Name); ok {
+			part.Month = month.Format("2006-01")
+		}
+		// Never analyzed
+		if part.EstimatedRows < 0 {
+			part.EstimatedRows = 0
+		}
+		out = append(out, part)
+	}
+	return out, rows.Err()
+}
+
+// DropMonth drops the partition of month with every event in it, and
+// returns its name. Events for the month arriving later recreate it.
+func (p *PartitionedRepository) DropMonth(ctx context.Context, month time.Time) (string, error) {
+	name := partitionName(month)
+	if _, err := p.db.Exec(ctx, `DROP TABLE IF EXISTS `+name); err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	delete(p.months, name)
+	p.mu.Unlock()
+	return name, nil
+}
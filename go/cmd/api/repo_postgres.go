@@ -0,0 +1,866 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+// PostgresRepository stores events in Postgres for durability and
+// idempotency.
+type PostgresRepository struct {
+	db *pgxpool.Pool
+}
+
+// OpenPostgresRepository connects to dsn and creates the schema if needed.
+func OpenPostgresRepository(ctx context.Context, dsn string) (*PostgresRepository, error) {
+	db, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := initDB(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &PostgresRepository{db: db}, nil
+}
+
+// openReadOnlyPool connects to dsn with sessions that default to read-only
+// transactions, so nothing run on the pool can write.
+func openReadOnlyPool(ctx context.Context, dsn string) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ConnConfig.RuntimeParams["default_transaction_read_only"] = "on"
+	return pgxpool.NewWithConfig(ctx, cfg)
+}
+
+// Pool exposes the connection pool to stores sharing the database, such as
+// labels.
+func (p *PostgresRepository) Pool() *pgxpool.Pool {
+	return p.db
+}
+
+func (p *PostgresRepository) Close() {
+	p.db.Close()
+}
+
+// initDB brings the schema up to date with postgresMigrations.
+func initDB(ctx context.Context, db *pgxpool.Pool) error {
+	return migratePostgres(ctx, db, "schema_migrations", postgresMigrations)
+}
+
+// postgresMigrations are the schema changes of the postgres backend,
+// applied in order and each once. The baseline is the schema from before
+// migrations were tracked and only adds what is missing, so databases
+// created by older versions adopt it in place.
+var postgresMigrations = []migration{
+	{Version: 1, Name: "baseline", SQL: `
+		CREATE TABLE IF NOT EXISTS events (
+			event_id TEXT PRIMARY KEY,
+			chain TEXT NOT NULL,
+			network TEXT NOT NULL,
+			tx_hash TEXT NOT NULL,
+			timestamp TEXT NOT NULL,
+			from_addr TEXT NOT NULL,
+			to_addr TEXT NOT NULL,
+			value TEXT NOT NULL,
+			event_type TEXT NOT NULL,
+			block_number BIGINT NULL,
+			slot BIGINT NULL,
+			status TEXT NOT NULL DEFAULT 'confirmed',
+			token_address TEXT NULL,
+			token_symbol TEXT NULL,
+			token_decimals INT NULL,
+			executed_by TEXT NOT NULL DEFAULT '',
+			multisig TEXT NOT NULL DEFAULT '',
+			authority TEXT NOT NULL DEFAULT '',
+			authority_program TEXT NOT NULL DEFAULT '',
+			tenant TEXT NOT NULL DEFAULT '',
+			bridge TEXT NOT NULL DEFAULT '',
+			source_chain TEXT NOT NULL DEFAULT '',
+			dest_chain TEXT NOT NULL DEFAULT '',
+			bridge_sequence TEXT NOT NULL DEFAULT '',
+			annotations TEXT NOT NULL DEFAULT '',
+			fee TEXT NOT NULL DEFAULT '',
+			gas_used BIGINT NULL,
+			priority_fee TEXT NOT NULL DEFAULT '',
+			shared_with TEXT NOT NULL DEFAULT '',
+			amount NUMERIC NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS block_number BIGINT NULL;
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'confirmed';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS executed_by TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS multisig TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS authority TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS authority_program TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS bridge TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS source_chain TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS dest_chain TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS bridge_sequence TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS annotations TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS fee TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS gas_used BIGINT NULL;
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS priority_fee TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS shared_with TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS amount NUMERIC NULL;
+		CREATE INDEX IF NOT EXISTS idx_events_tenant_created ON events (tenant, created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_events_bridge_sequence ON events (bridge, bridge_sequence) WHERE bridge <> '';
+		CREATE INDEX IF NOT EXISTS idx_events_from ON events (LOWER(from_addr));
+		CREATE INDEX IF NOT EXISTS idx_events_to ON events (LOWER(to_addr));
+		CREATE INDEX IF NOT EXISTS idx_events_from_exact ON events (from_addr);
+		CREATE INDEX IF NOT EXISTS idx_events_to_exact ON events (to_addr);
+		CREATE INDEX IF NOT EXISTS idx_events_created ON events (created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_events_tx_hash ON events (tx_hash);
+		CREATE INDEX IF NOT EXISTS idx_events_tx_hash_lower ON events (LOWER(tx_hash));
+		CREATE INDEX IF NOT EXISTS idx_events_chain_height ON events (chain, network, (COALESCE(block_number, slot)));
+		CREATE TABLE IF NOT EXISTS labels (
+			address TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			category TEXT NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_labels_category ON labels (category);
+	` + chainsSchema + viewsSchema + pluginsSchema + metricsSchema + correlationsSchema + bridgeRoutesSchema},
+	{Version: 2, Name: "event nonce", SQL: `ALTER TABLE events ADD COLUMN IF NOT EXISTS nonce BIGINT NULL`},
+	{Version: 3, Name: "event dedup key", SQL: `
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS log_index BIGINT NULL;
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS dedup_key TEXT NULL;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_events_dedup_key ON events (dedup_key);
+	`},
+	// Trigram indexes serve the substring and prefix LIKEs of /search
+	{Version: 4, Name: "search indexes", SQL: `
+		CREATE EXTENSION IF NOT EXISTS pg_trgm;
+		CREATE INDEX IF NOT EXISTS idx_events_tx_hash_trgm ON events USING GIN (LOWER(tx_hash) gin_trgm_ops);
+		CREATE INDEX IF NOT EXISTS idx_events_from_trgm ON events USING GIN (LOWER(from_addr) gin_trgm_ops);
+		CREATE INDEX IF NOT EXISTS idx_events_to_trgm ON events USING GIN (LOWER(to_addr) gin_trgm_ops);
+		CREATE INDEX IF NOT EXISTS idx_events_token_symbol_trgm ON events USING GIN (LOWER(token_symbol) gin_trgm_ops);
+		CREATE INDEX IF NOT EXISTS idx_events_token_address_trgm ON events USING GIN (LOWER(token_address) gin_trgm_ops);
+	`},
+	{Version: 5, Name: "event provenance", SQL: `ALTER TABLE events ADD COLUMN IF NOT EXISTS provenance TEXT NOT NULL DEFAULT ''`},
+	{Version: 6, Name: "event source", SQL: `ALTER TABLE events ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT ''`},
+	{Version: 7, Name: "event amendments", SQL: `
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS supersedes TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS superseded_by TEXT NOT NULL DEFAULT '';
+	`},
+	{Version: 8, Name: "event chain id", SQL: `ALTER TABLE events ADD COLUMN IF NOT EXISTS chain_id TEXT NOT NULL DEFAULT ''`},
+	{Version: 9, Name: "reorg ledger", SQL: reorgsSchema},
+	{Version: 10, Name: "treasury policies", SQL: treasurySchema},
+	{Version: 11, Name: "escalation policies", SQL: escalationSchema},
+	{Version: 12, Name: "wallet ownership", SQL: ownershipSchema},
+	{Version: 13, Name: "wallet privacy", SQL: walletPrivacySchema},
+	{Version: 14, Name: "identities", SQL: identitiesSchema},
+	{Version: 15, Name: "daily reports", SQL: dailyReportSchema},
+}
+
+// Insert stores a single event idempotently (on event_id and dedup_key).
+func (p *PostgresRepository) Insert(ctx context.Context, ev *Event) error {
+	args, err := eventArgs(ev)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.Exec(ctx, `
+		INSERT INTO events (`+eventInsertColumns+`)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,$27,$28,$29,$30,$31,$32,$33,$34,$35,$36,$37,$38)
+		ON CONFLICT DO NOTHING
+	`, args...)
+	return err
+}
+
+// InsertBatch stores events with multi-row INSERTs, idempotently.
+func (p *PostgresRepository) InsertBatch(ctx context.Context, events []*Event) error {
+	const perStatement = 1000 // 38 columns each, well under the 65535 parameter limit
+	for start := 0; start < len(events); start += perStatement {
+		end := start + perStatement
+		if end > len(events) {
+			end = len(events)
+		}
+		var (
+			rows []string
+			args []interface{}
+		)
+		for _, ev := range events[start:end] {
+			evArgs, err := eventArgs(ev)
+			if err != nil {
+				return err
+			}
+			ph := make([]string, len(evArgs))
+			for i := range ph {
+				ph[i] = fmt.Sprintf("$%d", len(args)+i+1)
+			}
+			rows = append(rows, "("+strings.Join(ph, ",")+")")
+			args = append(args, evArgs...)
+		}
+		if _, err := p.db.Exec(ctx, `
+			INSERT INTO events (`+eventInsertColumns+`)
+			VALUES `+strings.Join(rows, ", ")+`
+			ON CONFLICT DO NOTHING
+		`, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateMetadata overwrites the eventMergeColumns of the event stored under
+// ev's event_id with ev's values.
+func (p *PostgresRepository) UpdateMetadata(ctx context.Context, ev *Event) error {
+	args, err := eventMergeArgs(ev)
+	if err != nil {
+		return err
+	}
+	ph := make([]string, len(args))
+	for i := range ph {
+		ph[i] = fmt.Sprintf("$%d", i+2)
+	}
+	_, err = p.db.Exec(ctx, `
+		UPDATE events SET (`+eventMergeColumns+`) = (`+strings.Join(ph, ",")+`), updated_at = NOW()
+		WHERE event_id = $1
+	`, append([]interface{}{ev.EventID}, args...)...)
+	return err
+}
+
+func (p *PostgresRepository) query(ctx context.Context, q string, args ...interface{}) ([]*Event, error) {
+	rows, err := p.db.Query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := scanEvents(rows)
+	return events, rows.Err()
+}
+
+func (p *PostgresRepository) ByWallet(ctx context.Context, address string, filter EventFilter) ([]*Event, error) {
+	return p.ByWallets(ctx, []string{address}, filter)
+}
+
+func (p *PostgresRepository) ByWallets(ctx context.Context, addresses []string, filter EventFilter) ([]*Event, error) {
+	wallets, args := walletWhere("$", 1, addresses)
+	q := `
+		SELECT ` + eventColumns + `
+		FROM events
+		WHERE ` + wallets
+	where, whereArgs := filter.sqlWhere("$", len(args)+1)
+	q += where
+	args = append(args, whereArgs...)
+	return p.page(ctx, q, args, filter)
+}
+
+func (p *PostgresRepository) Recent(ctx context.Context, filter EventFilter) ([]*Event, error) {
+	q := `
+		SELECT ` + eventColumns + `
+		FROM events
+		WHERE 1=1
+	`
+	where, args := filter.sqlWhere("$", 1)
+	return p.page(ctx, q+where, args, filter)
+}
+
+func (p *PostgresRepository) Count(ctx context.Context, addresses []string, filter EventFilter) (int, error) {
+	q := `SELECT COUNT(*) FROM events WHERE 1=1`
+	var args []interface{}
+	if len(addresses) > 0 {
+		var wallets string
+		wallets, args = walletWhere("$", 1, addresses)
+		q += ` AND ` + wallets
+	}
+	where, whereArgs := filter.sqlWhere("$", len(args)+1)
+	var n int
+	err := p.db.QueryRow(ctx, q+where, append(args, whereArgs...)...).Scan(&n)
+	return n, err
+}
+
+// Sample draws rows with TABLESAMPLE BERNOULLI, which keeps every row with
+// the same probability, at the rate the planner's estimate of the matching
+// rows expects to yield sampleOversampling times size of them; ORDER BY
+// random() then keeps size. Listings the estimate puts within that many
+// rows are sampled whole. The estimate is returned as the total, raised to
+// the sample size, or the exact count when a whole listing fit in the
+// sample.
+func (p *PostgresRepository) Sample(ctx context.Context, addresses []string, filter EventFilter, size int) ([]*Event, int, error) {
+	where, args := listingWhere("$", addresses, filter)
+	estimate, err := p.estimateRows(ctx, where, args)
+	if err != nil {
+		return nil, 0, err
+	}
+	from, whole := "events", true
+	if want := float64(size * sampleOversampling); float64(estimate) > want {
+		from, whole = fmt.Sprintf("events TABLESAMPLE BERNOULLI (%g)", 100*want/float64(estimate)), false
+	}
+	q := fmt.Sprintf(`SELECT %s FROM (SELECT * FROM %s WHERE %s ORDER BY random() LIMIT $%d) AS events`,
+		eventColumns, from, where, len(args)+1)
+	events, err := p.query(ctx, q+filter.orderSQL(sortColumns, "created_at DESC"), append(args, size)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	if whole && len(events) < size || estimate < len(events) {
+		estimate = len(events)
+	}
+	return events, estimate, nil
+}
+
+// estimateRows returns the planner's estimate of the events matching where,
+// without running the query.
+func (p *PostgresRepository) estimateRows(ctx context.Context, where string, args []interface{}) (int, error) {
+	var plan []byte
+	if err := p.db.QueryRow(ctx, `EXPLAIN (FORMAT JSON) SELECT 1 FROM events WHERE `+where, args...).Scan(&plan); err != nil {
+		return 0, err
+	}
+	var explained []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(plan, &explained); err != nil || len(explained) == 0 {
+		return 0, fmt.Errorf("unexpected query plan: %s", plan)
+	}
+	return int(explained[0].Plan.Rows), nil
+}
+
+// page orders by created_at (server-side timestamp) for stability and
+// applies the filter's pagination.
+func (p *PostgresRepository) page(ctx context.Context, q string, args []interface{}, filter EventFilter) ([]*Event, error) {
+	idx := len(args) + 1
+	q += filter.orderSQL(sortColumns, "created_at DESC") + fmt.Sprintf(" LIMIT $%d OFFSET $%d", idx, idx+1)
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	return p.query(ctx, q, append(args, limit, filter.Offset)...)
+}
+
+func (p *PostgresRepository) ByID(ctx context.Context, eventID string) (*Event, bool, error) {
+	events, err := p.query(ctx, `SELECT `+eventColumns+` FROM events WHERE event_id = $1`, eventID)
+	if err != nil || len(events) == 0 {
+		return nil, false, err
+	}
+	return events[0], true, nil
+}
+
+func (p *PostgresRepository) ByTxHash(ctx context.Context, hash, chain string) ([]*Event, error) {
+	// Rows written before normalization may differ in case or 0x prefix
+	q := `SELECT ` + eventColumns + ` FROM events WHERE tx_hash = $1`
+	args := []interface{}{hash}
+	if strings.HasPrefix(hash, "0x") {
+		q = `SELECT ` + eventColumns + ` FROM events WHERE LOWER(tx_hash) = ANY($1)`
+		args = []interface{}{[]string{hash, strings.TrimPrefix(hash, "0x")}}
+	}
+	if chain != "" {
+		q += " AND chain = $2"
+		args = append(args, chain)
+	}
+	return p.query(ctx, q+" ORDER BY created_at", args...)
+}
+
+// ApplyConfirmation applies the update in a single statement, returning the
+// previous status of every updated row.
+func (p *PostgresRepository) ApplyConfirmation(ctx context.Context, u ConfirmationUpdate) ([]StatusChange, error) {
+	var where string
+	args := []interface{}{u.Status}
+	if len(u.EventIDs) > 0 {
+		where = "event_id = ANY($2)"
+		args = append(args, u.EventIDs)
+	} else {
+		// G115: Safe conversion - block heights fit in int64 range
+		height := int64(*u.BlockNumber)
+		where = "chain = $2 AND ($3 = '' OR network = $3)"
+		args = append(args, u.Chain, u.Network, height)
+		if u.Status == StatusOrphaned {
+			where += fmt.Sprintf(" AND COALESCE(block_number, slot) >= $4 AND status <> '%s'", StatusFinalized)
+		} else {
+			where += fmt.Sprintf(" AND COALESCE(block_number, slot) <= $4 AND status IN ('%s', '%s')", StatusPending, StatusConfirmed)
+		}
+	}
+
+	q := `
+		UPDATE events e SET status = $1, updated_at = NOW()
+		FROM (SELECT event_id, status FROM events WHERE ` + where + ` AND status <> $1 FOR UPDATE) prev
+		WHERE e.event_id = prev.event_id
+		RETURNING e.event_id, e.chain, e.network, e.tx_hash, prev.status, e.tenant, e.shared_with, e.from_addr, e.to_addr,
+			COALESCE(e.block_number, e.slot), e.timestamp
+	`
+	rows, err := p.db.Query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []StatusChange
+	for rows.Next() {
+		c := StatusChange{Type: "status_change", Status: u.Status}
+		var sharedWith string
+		var height *int64
+		if err := rows.Scan(&c.EventID, &c.Chain, &c.Network, &c.TxHash, &c.PreviousStatus, &c.Tenant, &sharedWith, &c.From, &c.To, &height, &c.Timestamp); err != nil {
+			return nil, err
+		}
+		c.SharedWith = parseSharedWith(sharedWith)
+		c.Height = heightFromDB(height)
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (p *PostgresRepository) VolumeSeries(ctx context.Context, q AnalyticsQuery) ([]VolumePoint, error) {
+	unit, _, _ := truncUnit(q.Interval)
+	where, args := q.analyticsWhere()
+	rows, err := p.db.Query(ctx, `
+		SELECT date_trunc('`+unit+`', timestamp::timestamptz AT TIME ZONE 'UTC') AS bucket,
+			chain, network, COALESCE(token_symbol, '`+nativeToken+`') AS token, COALESCE(token_address, '') AS token_address,
+			SUM(value::numeric)::text, COUNT(*)
+		FROM events
+		WHERE `+where+` AND value ~ '^[0-9]+$'
+		GROUP BY 1, 2, 3, 4, 5
+		ORDER BY 1, 2, 3, 4, 5
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]VolumePoint, 0)
+	for rows.Next() {
+		var p VolumePoint
+		var bucket time.Time
+		if err := rows.Scan(&bucket, &p.Chain, &p.Network, &p.Token, &p.TokenAddress, &p.Volume, &p.Transfers); err != nil {
+			return nil, err
+		}
+		p.Bucket = bucket.UTC().Format(time.RFC3339)
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (p *PostgresRepository) ActiveWalletsSeries(ctx context.Context, q AnalyticsQuery) ([]ActiveWalletsPoint, error) {
+	unit, _, _ := truncUnit(q.Interval)
+	where, args := q.analyticsWhere()
+	rows, err := p.db.Query(ctx, `
+		SELECT bucket, COUNT(DISTINCT addr)
+		FROM (
+			SELECT date_trunc('`+unit+`', timestamp::timestamptz AT TIME ZONE 'UTC') AS bucket,
+				UNNEST(ARRAY[`+addressKeySQL("from_addr")+`, `+addressKeySQL("to_addr")+`]) AS addr
+			FROM events
+			WHERE `+where+`
+		) a
+		GROUP BY bucket
+		ORDER BY bucket
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]ActiveWalletsPoint, 0)
+	for rows.Next() {
+		var p ActiveWalletsPoint
+		var bucket time.Time
+		if err := rows.Scan(&bucket, &p.Wallets); err != nil {
+			return nil, err
+		}
+		p.Bucket = bucket.UTC().Format(time.RFC3339)
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// WalletStats sums the wallet's flows per asset and its fees per chain in
+// SQL. The fee subquery keeps one row per transaction, since every event of
+// a transaction repeats its fee.
+func (p *PostgresRepository) WalletStats(ctx context.Context, address string, filter EventFilter) (WalletStats, error) {
+	wallets, args := walletWhere("$", 1, []string{address})
+	where, whereArgs := filter.sqlWhere("$", len(args)+1)
+	args = append(args, whereArgs...)
+	// walletWhere binds the address key to $1
+	from, to := addressColumn("from_addr", address)+" = $1", addressColumn("to_addr", address)+" = $1"
+	payer := addressColumn("CASE WHEN executed_by <> '' THEN executed_by ELSE from_addr END", address) + " = $1"
+
+	stats := WalletStats{Address: address, Assets: make([]WalletAssetStats, 0)}
+	rows, err := p.db.Query(ctx, `
+		SELECT chain, network, COALESCE(token_symbol, '`+nativeToken+`'), COALESCE(token_address, ''),
+			COALESCE(SUM(value::numeric) FILTER (WHERE `+from+`), 0)::text,
+			COALESCE(SUM(value::numeric) FILTER (WHERE `+to+`), 0)::text,
+			COUNT(*)
+		FROM events
+		WHERE `+wallets+where+` AND value ~ '^[0-9]+$'
+		GROUP BY 1, 2, 3, 4
+	`, args...)
+	if err != nil {
+		return WalletStats{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a WalletAssetStats
+		if err := rows.Scan(&a.Chain, &a.Network, &a.Token, &a.TokenAddress, &a.Sent, &a.Received, &a.Transfers); err != nil {
+			return WalletStats{}, err
+		}
+		stats.Assets = append(stats.Assets, a)
+	}
+	if err := rows.Err(); err != nil {
+		return WalletStats{}, err
+	}
+
+	rows, err = p.db.Query(ctx, `
+		SELECT chain, network, SUM(fee::numeric)::text,
+			COALESCE(SUM(priority_fee::numeric) FILTER (WHERE priority_fee ~ '^[0-9]+$'), 0)::text,
+			COALESCE(SUM(gas_used), 0)::bigint, COUNT(*)
+		FROM (
+			SELECT DISTINCT ON (chain, tx_hash) chain, network, fee, priority_fee, gas_used
+			FROM events
+			WHERE `+wallets+where+` AND fee ~ '^[0-9]+$' AND `+payer+`
+		) t
+		GROUP BY chain, network
+	`, args...)
+	if err != nil {
+		return WalletStats{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var chain, network, fees, priority string
+		var gasUsed, txs int64
+		if err := rows.Scan(&chain, &network, &fees, &priority, &gasUsed, &txs); err != nil {
+			return WalletStats{}, err
+		}
+		// G115: Safe conversion - a SUM of non-negative gas amounts
+		stats.addFees(chain, network, fees, priority, uint64(gasUsed), txs)
+	}
+	if err := rows.Err(); err != nil {
+		return WalletStats{}, err
+	}
+	stats.finish()
+	return stats, nil
+}
+
+// addressKeySQL approximates addressKey as a Postgres expression over
+// column: base58 strings of a Solana key's or Tron address's length are
+// kept as they are.
+func addressKeySQL(column string) string {
+	return `CASE WHEN ` + column + ` ~ '^[1-9A-HJ-NP-Za-km-z]{32,44}$' THEN ` + column + ` ELSE LOWER(` + column + `) END`
+}
+
+// eventColumns is the column list scanEvents uses, in order.
+const eventColumns = `event_id, chain, network, tx_hash, timestamp, from_addr, to_addr, value, event_type, block_number, slot,
+	status, token_address, token_symbol, token_decimals, executed_by, multisig, authority, authority_program, tenant,
+	bridge, source_chain, dest_chain, bridge_sequence, annotations, fee, gas_used, priority_fee, shared_with, nonce, log_index,
+	provenance, source, supersedes, superseded_by, chain_id`
+
+// eventInsertColumns adds the columns derived from an event on insert to
+// eventColumns. dedup_key is unique, so a copy of a stored transfer under
+// another event_id is not inserted (see dedupKey). amount is the value in
+// whole units, for min_value filters; responses compute it from value
+// instead of reading it back.
+const eventInsertColumns = eventColumns + `, dedup_key, amount`
+
+// eventMergeColumns are the columns mergeDuplicate can fill in.
+const eventMergeColumns = `timestamp, block_number, slot, token_address, token_symbol, token_decimals, executed_by, multisig,
+	authority, authority_program, bridge, source_chain, dest_chain, bridge_sequence, annotations, fee, gas_used, priority_fee,
+	nonce, log_index, superseded_by`
+
+// eventArgs converts an event to insert arguments in eventInsertColumns
+// order.
+func eventArgs(ev *Event) ([]interface{}, error) {
+	var slot *int64
+	if ev.Slot != nil {
+		// G115: Safe conversion - Solana slots fit in int64 range
+		if *ev.Slot > uint64(^uint64(0)>>1) {
+			return nil, fmt.Errorf("slot value too large: %d", *ev.Slot)
+		}
+		tmp := int64(*ev.Slot)
+		slot = &tmp
+	}
+	var blockNumber *int64
+	if ev.BlockNumber != nil {
+		// G115: Safe conversion - block heights fit in int64 range
+		if *ev.BlockNumber > uint64(^uint64(0)>>1) {
+			return nil, fmt.Errorf("block number too large: %d", *ev.BlockNumber)
+		}
+		tmp := int64(*ev.BlockNumber)
+		blockNumber = &tmp
+	}
+	status := ev.Status
+	if status == "" {
+		status = StatusConfirmed
+	}
+	var tokAddr, tokSym *string
+	var tokDec *int32
+	if ev.Token != nil {
+		ta := ev.Token.Address
+		ts := ev.Token.Symbol
+		// G115: Safe conversion - decimals are bounded by ERC20/SPL token standards (max 255)
+		td := int32(ev.Token.Decimals)
+		tokAddr = &ta
+		tokSym = &ts
+		tokDec = &td
+	}
+	var authority, authorityProgram string
+	if ev.Authority != nil {
+		authority, authorityProgram = ev.Authority.Address, ev.Authority.Program
+	}
+	var annotations string
+	if len(ev.Annotations) > 0 {
+		b, err := json.Marshal(ev.Annotations)
+		if err != nil {
+			return nil, fmt.Errorf("annotations: %w", err)
+		}
+		annotations = string(b)
+	}
+	var gasUsed *int64
+	if ev.GasUsed != nil {
+		// G115: Safe conversion - gas used per transaction fits in int64 range
+		if *ev.GasUsed > uint64(^uint64(0)>>1) {
+			return nil, fmt.Errorf("gas used too large: %d", *ev.GasUsed)
+		}
+		tmp := int64(*ev.GasUsed)
+		gasUsed = &tmp
+	}
+	var nonce *int64
+	if ev.Nonce != nil {
+		// G115: Safe conversion - account nonces fit in int64 range
+		if *ev.Nonce > uint64(^uint64(0)>>1) {
+			return nil, fmt.Errorf("nonce too large: %d", *ev.Nonce)
+		}
+		tmp := int64(*ev.Nonce)
+		nonce = &tmp
+	}
+	var logIndex *int64
+	if ev.LogIndex != nil {
+		// G115: Safe conversion - log and instruction indexes fit in int64 range
+		if *ev.LogIndex > uint64(^uint64(0)>>1) {
+			return nil, fmt.Errorf("log index too large: %d", *ev.LogIndex)
+		}
+		tmp := int64(*ev.LogIndex)
+		logIndex = &tmp
+	}
+	var amount interface{}
+	if a, ok := eventAmount(ev); ok {
+		amount = a.Numeric()
+	}
+	return []interface{}{
+		ev.EventID, ev.Chain, ev.Network, ev.TxHash, ev.Timestamp,
+		ev.From, ev.To, ev.Value, ev.EventType, blockNumber, slot, status, tokAddr, tokSym, tokDec,
+		ev.ExecutedBy, ev.Multisig, authority, authorityProgram, ev.Tenant,
+		ev.Bridge, ev.SourceChain, ev.DestChain, ev.Sequence, annotations, ev.Fee, gasUsed, ev.PriorityFee, sharedWithColumn(ev.SharedWith), nonce, logIndex,
+		ev.Provenance, ev.Source, ev.Supersedes, ev.SupersededBy, ev.ChainID, dedupKey(ev), amount,
+	}, nil
+}
+
+// eventMergeArgs returns ev's insert arguments for eventMergeColumns, in
+// order.
+func eventMergeArgs(ev *Event) ([]interface{}, error) {
+	args, err := eventArgs(ev)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int)
+	for i, column := range strings.Split(eventInsertColumns, ",") {
+		index[strings.TrimSpace(column)] = i
+	}
+	var out []interface{}
+	for _, column := range strings.Split(eventMergeColumns, ",") {
+		out = append(out, args[index[strings.TrimSpace(column)]])
+	}
+	return out, nil
+}
+
+// rowScanner is the subset of pgx.Rows and *sql.Rows that scanEvents needs.
+type rowScanner interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+}
+
+// scanEvents decodes rows selected with eventColumns. Rows that fail to scan
+// or hold out-of-range values are logged and skipped.
+func scanEvents(rows rowScanner) []*Event {
+	out := make([]*Event, 0)
+	for rows.Next() {
+		var ev Event
+		var blockNumber, slot, gasUsed, nonce, logIndex *int64
+		var tokAddr, tokSym *string
+		var tokDec *int32
+		var authority, authorityProgram, annotations, sharedWith string
+		if err := rows.Scan(&ev.EventID, &ev.Chain, &ev.Network, &ev.TxHash, &ev.Timestamp,
+			&ev.From, &ev.To, &ev.Value, &ev.EventType, &blockNumber, &slot, &ev.Status, &tokAddr, &tokSym, &tokDec,
+			&ev.ExecutedBy, &ev.Multisig, &authority, &authorityProgram, &ev.Tenant,
+			&ev.Bridge, &ev.SourceChain, &ev.DestChain, &ev.Sequence, &annotations,
+			&ev.Fee, &gasUsed, &ev.PriorityFee, &sharedWith, &nonce, &logIndex, &ev.Provenance, &ev.Source,
+			&ev.Supersedes, &ev.SupersededBy, &ev.ChainID); err != nil {
+			log.WithError(err).Warn("db scan failed")
+			continue
+		}
+		if blockNumber != nil && *blockNumber >= 0 {
+			// G115: Safe conversion - checked non-negative
+			b := uint64(*blockNumber)
+			ev.BlockNumber = &b
+		}
+		if slot != nil {
+			// G115: Safe conversion - values from DB are already validated
+			if *slot < 0 {
+				log.Warnf("negative slot value in DB: %d", *slot)
+				continue
+			}
+			s := uint64(*slot)
+			ev.Slot = &s
+		}
+		if gasUsed != nil && *gasUsed >= 0 {
+			// G115: Safe conversion - checked non-negative
+			g := uint64(*gasUsed)
+			ev.GasUsed = &g
+		}
+		if nonce != nil && *nonce >= 0 {
+			// G115: Safe conversion - checked non-negative
+			n := uint64(*nonce)
+			ev.Nonce = &n
+		}
+		if logIndex != nil && *logIndex >= 0 {
+			// G115: Safe conversion - checked non-negative
+			i := uint64(*logIndex)
+			ev.LogIndex = &i
+		}
+		if tokAddr != nil || tokSym != nil || tokDec != nil {
+			ev.Token = &Token{Address: getOrEmpty(tokAddr), Symbol: getOrEmpty(tokSym)}
+			if tokDec != nil {
+				// G115: Safe conversion - decimals validated at persistence
+				if *tokDec < 0 || *tokDec > 255 {
+					log.Warnf("invalid token decimals in DB: %d", *tokDec)
+				} else {
+					ev.Token.Decimals = uint8(*tokDec)
+				}
+			}
+		}
+		ev.SharedWith = parseSharedWith(sharedWith)
+		if authority != "" {
+			ev.Authority = &Authority{Address: authority, Program: authorityProgram}
+		}
+		if annotations != "" {
+			if err := json.Unmarshal([]byte(annotations), &ev.Annotations); err != nil {
+				log.WithError(err).WithField("event_id", ev.EventID).Warn("invalid annotations in DB")
+			}
+		}
+		if ev.ChainID == "" {
+			// Stored before chain ids were
+			ev.ChainID = ChainID(ev.Chain, ev.Network)
+		}
+		out = append(out, &ev)
+	}
+	return out
+}
+
+// getOrEmpty safely dereferences an optional string.
+func getOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// PurgeBefore deletes events received before cutoff (by created_at, which
+// is indexed and partitions the Timescale hypertable), one batch per
+// statement. Each batch commits on its own, so an interrupted purge keeps
+// its progress.
+func (p *PostgresRepository) PurgeBefore(ctx context.Context, cutoff time.Time, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = defaultPurgeBatchSize
+	}
+	total := 0
+	for {
+		tag, err := p.db.Exec(ctx, `
+			DELETE FROM events WHERE event_id IN (
+				SELECT event_id FROM events WHERE created_at < $1 LIMIT $2
+			)
+		`, cutoff, batchSize)
+		if err != nil {
+			return total, err
+		}
+		n := int(tag.RowsAffected())
+		total += n
+		if n < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// RetentionSegments counts the events received before before by month,
+// chain and network.
+func (p *PostgresRepository) RetentionSegments(ctx context.Context, before time.Time) ([]RetentionSegment, error) {
+	rows, err := p.db.Query(ctx, `
+		SELECT date_trunc('month', created_at AT TIME ZONE 'UTC'), chain, network, COUNT(*)
+		FROM events WHERE created_at < $1
+		GROUP BY 1, 2, 3 ORDER BY 1, 2, 3
+	`, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []RetentionSegment
+	for rows.Next() {
+		var seg RetentionSegment
+		if err := rows.Scan(&seg.Month, &seg.Chain, &seg.Network, &seg.Events); err != nil {
+			return nil, err
+		}
+		seg.Month = monthStart(seg.Month)
+		out = append(out, seg)
+	}
+	return out, rows.Err()
+}
+
+// ExportSegment writes the events of seg to w as NDJSON, in event_id
+// order, a page at a time, and returns how many it wrote.
+func (p *PostgresRepository) ExportSegment(ctx context.Context, seg RetentionSegment, w io.Writer) (int, error) {
+	const pageSize = 5000
+	enc := json.NewEncoder(w)
+	written, after := 0, ""
+	for {
+		events, err := p.query(ctx, `
+			SELECT `+eventColumns+` FROM events
+			WHERE created_at >= $1 AND created_at < $2 AND chain = $3 AND network = $4 AND event_id > $5
+			ORDER BY event_id LIMIT $6
+		`, seg.Month, seg.End(), seg.Chain, seg.Network, after, pageSize)
+		if err != nil {
+			return written, err
+		}
+		for _, ev := range events {
+			if err := enc.Encode(ev); err != nil {
+				return written, err
+			}
+			written++
+			after = ev.EventID
+		}
+		if len(events) < pageSize {
+			return written, nil
+		}
+	}
+}
+
+// DeleteSegment deletes the events of seg, batchSize per statement, and
+// returns how many it deleted. Rows are keyed by event_id and created_at,
+// which are unique together on every Postgres backend.
+func (p *PostgresRepository) DeleteSegment(ctx context.Context, seg RetentionSegment, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = defaultPurgeBatchSize
+	}
+	total := 0
+	for {
+		tag, err := p.db.Exec(ctx, `
+			DELETE FROM events WHERE (event_id, created_at) IN (
+				SELECT event_id, created_at FROM events
+				WHERE created_at >= $1 AND created_at < $2 AND chain = $3 AND network = $4
+				LIMIT $5
+			)
+		`, seg.Month, seg.End(), seg.Chain, seg.Network, batchSize)
+		if err != nil {
+			return total, err
+		}
+		n := int(tag.RowsAffected())
+		total += n
+		if n < batchSize {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,659 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// SQLiteRepository stores events in a single SQLite file, for single-node
+// deployments and local development without Postgres.
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// OpenSQLiteRepository opens (creating if needed) the database at path.
+// ":memory:" gives a throwaway database.
+func OpenSQLiteRepository(ctx context.Context, path string) (*SQLiteRepository, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time, and every connection to ":memory:"
+	// is a separate database, so share a single connection.
+	db.SetMaxOpenConns(1)
+	if _, err := db.ExecContext(ctx, `
+		PRAGMA journal_mode = WAL;
+		PRAGMA busy_timeout = 5000;
+	`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := migrateSQLite(ctx, db, sqliteMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+	// Events tables created before migrations were tracked may lack
+	// columns of the baseline, which only creates missing tables
+	if err := addSQLiteColumns(ctx, db, map[string]string{
+		"executed_by":       "TEXT NOT NULL DEFAULT ''",
+		"multisig":          "TEXT NOT NULL DEFAULT ''",
+		"authority":         "TEXT NOT NULL DEFAULT ''",
+		"authority_program": "TEXT NOT NULL DEFAULT ''",
+		"tenant":            "TEXT NOT NULL DEFAULT ''",
+		"bridge":            "TEXT NOT NULL DEFAULT ''",
+		"source_chain":      "TEXT NOT NULL DEFAULT ''",
+		"dest_chain":        "TEXT NOT NULL DEFAULT ''",
+		"bridge_sequence":   "TEXT NOT NULL DEFAULT ''",
+		"annotations":       "TEXT NOT NULL DEFAULT ''",
+		"fee":               "TEXT NOT NULL DEFAULT ''",
+		"gas_used":          "INTEGER NULL",
+		"priority_fee":      "TEXT NOT NULL DEFAULT ''",
+		"shared_with":       "TEXT NOT NULL DEFAULT ''",
+		"amount":            "NUMERIC NULL",
+		"amount_key":        "TEXT NULL",
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	repo := &SQLiteRepository{db: db}
+	if err := repo.backfillAmountKeys(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, index := range []string{
+		`CREATE INDEX IF NOT EXISTS idx_events_tenant_created ON events (tenant, created_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_events_bridge_sequence ON events (bridge, bridge_sequence) WHERE bridge <> ''`,
+	} {
+		if _, err := db.ExecContext(ctx, index); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return repo, nil
+}
+
+// OpenSQLiteReadOnly opens the existing database at path read-only,
+// without migrating it.
+func OpenSQLiteReadOnly(path string) (*SQLiteRepository, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return &SQLiteRepository{db: db}, nil
+}
+
+// sqliteMigrations are the schema changes of the sqlite backend, applied
+// in order and each once; see postgresMigrations.
+var sqliteMigrations = []migration{
+	{Version: 1, Name: "baseline", SQL: `
+		CREATE TABLE IF NOT EXISTS events (
+			event_id TEXT PRIMARY KEY,
+			chain TEXT NOT NULL,
+			network TEXT NOT NULL,
+			tx_hash TEXT NOT NULL,
+			timestamp TEXT NOT NULL,
+			from_addr TEXT NOT NULL,
+			to_addr TEXT NOT NULL,
+			value TEXT NOT NULL,
+			event_type TEXT NOT NULL,
+			block_number INTEGER NULL,
+			slot INTEGER NULL,
+			status TEXT NOT NULL DEFAULT 'confirmed',
+			token_address TEXT NULL,
+			token_symbol TEXT NULL,
+			token_decimals INTEGER NULL,
+			executed_by TEXT NOT NULL DEFAULT '',
+			multisig TEXT NOT NULL DEFAULT '',
+			authority TEXT NOT NULL DEFAULT '',
+			authority_program TEXT NOT NULL DEFAULT '',
+			tenant TEXT NOT NULL DEFAULT '',
+			bridge TEXT NOT NULL DEFAULT '',
+			source_chain TEXT NOT NULL DEFAULT '',
+			dest_chain TEXT NOT NULL DEFAULT '',
+			bridge_sequence TEXT NOT NULL DEFAULT '',
+			annotations TEXT NOT NULL DEFAULT '',
+			fee TEXT NOT NULL DEFAULT '',
+			gas_used INTEGER NULL,
+			priority_fee TEXT NOT NULL DEFAULT '',
+			shared_with TEXT NOT NULL DEFAULT '',
+			amount NUMERIC NULL,
+			amount_key TEXT NULL,
+			created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+			updated_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+		);
+		CREATE INDEX IF NOT EXISTS idx_events_from ON events (LOWER(from_addr));
+		CREATE INDEX IF NOT EXISTS idx_events_to ON events (LOWER(to_addr));
+		CREATE INDEX IF NOT EXISTS idx_events_from_exact ON events (from_addr);
+		CREATE INDEX IF NOT EXISTS idx_events_to_exact ON events (to_addr);
+		CREATE INDEX IF NOT EXISTS idx_events_created ON events (created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_events_tx_hash_lower ON events (LOWER(tx_hash));
+		CREATE INDEX IF NOT EXISTS idx_events_chain_height ON events (chain, network, COALESCE(block_number, slot));
+	`},
+	{Version: 2, Name: "event nonce", SQL: `ALTER TABLE events ADD COLUMN nonce INTEGER NULL`},
+	{Version: 3, Name: "event dedup key", SQL: `
+		ALTER TABLE events ADD COLUMN log_index INTEGER NULL;
+		ALTER TABLE events ADD COLUMN dedup_key TEXT NULL;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_events_dedup_key ON events (dedup_key);
+	`},
+	{Version: 4, Name: "event provenance", SQL: `ALTER TABLE events ADD COLUMN provenance TEXT NOT NULL DEFAULT ''`},
+	{Version: 5, Name: "event source", SQL: `ALTER TABLE events ADD COLUMN source TEXT NOT NULL DEFAULT ''`},
+	{Version: 6, Name: "event amendments", SQL: `
+		ALTER TABLE events ADD COLUMN supersedes TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN superseded_by TEXT NOT NULL DEFAULT '';
+	`},
+	{Version: 7, Name: "event chain id", SQL: `ALTER TABLE events ADD COLUMN chain_id TEXT NOT NULL DEFAULT ''`},
+}
+
+// addSQLiteColumns adds the columns missing from an events table created by
+// an older version; SQLite has no ADD COLUMN IF NOT EXISTS.
+func addSQLiteColumns(ctx context.Context, db *sql.DB, columns map[string]string) error {
+	rows, err := db.QueryContext(ctx, `SELECT name FROM pragma_table_info('events')`)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for name, def := range columns {
+		if existing[name] {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, `ALTER TABLE events ADD COLUMN `+name+` `+def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqliteInsertColumns are the columns SQLite inserts. SQLite's NUMERIC is a
+// double for values this large, so amount is stored as amountKey text in
+// amount_key and the amount column is left empty.
+const sqliteInsertColumns = eventColumns + `, dedup_key, amount_key`
+
+// sqliteAmountDigits is the width the integer part of an amount key is
+// padded to, more than the 78 digits of the largest uint256.
+const sqliteAmountDigits = 80
+
+// amountKey renders a non-negative amount in whole units as text that sorts
+// like the number: the integer part zero-padded to sqliteAmountDigits and
+// minValuePrecision fractional digits, followed by "+" when digits were cut
+// off. Negative values get "", below every amount.
+func amountKey(r *big.Rat) string {
+	if r.Sign() < 0 {
+		return ""
+	}
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(pow10(minValuePrecision)))
+	digits, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	text := digits.String()
+	if len(text) > sqliteAmountDigits+minValuePrecision {
+		// Larger than any amount
+		return strings.Repeat("9", sqliteAmountDigits) + "+"
+	}
+	text = strings.Repeat("0", sqliteAmountDigits+minValuePrecision-len(text)) + text
+	key := text[:sqliteAmountDigits] + "." + text[sqliteAmountDigits:]
+	if rem.Sign() != 0 {
+		key += "+"
+	}
+	return key
+}
+
+// sqliteEventArgs converts an event to insert arguments in
+// sqliteInsertColumns order.
+func sqliteEventArgs(ev *Event) ([]interface{}, error) {
+	args, err := eventArgs(ev)
+	if err != nil {
+		return nil, err
+	}
+	args = args[:len(args)-1]
+	if amount, ok := eventAmount(ev); ok {
+		return append(args, amountKey(amount.Whole())), nil
+	}
+	return append(args, nil), nil
+}
+
+// backfillAmountKeys sets amount_key on events stored by a version that
+// only wrote the amount column.
+func (s *SQLiteRepository) backfillAmountKeys(ctx context.Context) error {
+	events, err := s.query(ctx, `SELECT `+eventColumns+` FROM events WHERE amount IS NOT NULL AND amount_key IS NULL`)
+	if err != nil {
+		return err
+	}
+	for _, ev := range events {
+		amount, ok := eventAmount(ev)
+		if !ok {
+			continue
+		}
+		if _, err := s.db.ExecContext(ctx, `UPDATE events SET amount_key = ?1 WHERE event_id = ?2`, amountKey(amount.Whole()), ev.EventID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteRepository) Close() {
+	_ = s.db.Close()
+}
+
+// placeholders renders n numbered parameters starting at ?start.
+func placeholders(start, n int) string {
+	ph := make([]string, n)
+	for i := range ph {
+		ph[i] = fmt.Sprintf("?%d", start+i)
+	}
+	return strings.Join(ph, ", ")
+}
+
+// Insert stores a single event idempotently (on event_id and dedup_key).
+func (s *SQLiteRepository) Insert(ctx context.Context, ev *Event) error {
+	args, err := sqliteEventArgs(ev)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO events (`+sqliteInsertColumns+`)
+		VALUES (`+placeholders(1, len(args))+`)
+	`, args...)
+	return err
+}
+
+// InsertBatch stores events idempotently in a single transaction.
+func (s *SQLiteRepository) InsertBatch(ctx context.Context, events []*Event) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT OR IGNORE INTO events (`+sqliteInsertColumns+`)
+		VALUES (`+placeholders(1, strings.Count(sqliteInsertColumns, ",")+1)+`)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, ev := range events {
+		args, err := sqliteEventArgs(ev)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UpdateMetadata overwrites the eventMergeColumns of the event stored under
+// ev's event_id with ev's values.
+func (s *SQLiteRepository) UpdateMetadata(ctx context.Context, ev *Event) error {
+	args, err := eventMergeArgs(ev)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE events SET (`+eventMergeColumns+`) = (`+placeholders(2, len(args))+`),
+			updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now')
+		WHERE event_id = ?1
+	`, append([]interface{}{ev.EventID}, args...)...)
+	return err
+}
+
+func (s *SQLiteRepository) query(ctx context.Context, q string, args ...interface{}) ([]*Event, error) {
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := scanEvents(rows)
+	return events, rows.Err()
+}
+
+// page orders newest first and applies the filter's pagination. rowid breaks
+// ties between events stored within the same millisecond.
+func (s *SQLiteRepository) page(ctx context.Context, q string, args []interface{}, filter EventFilter) ([]*Event, error) {
+	idx := len(args) + 1
+	q += filter.orderSQL(sqliteSortColumns, "created_at DESC, rowid DESC") + fmt.Sprintf(" LIMIT ?%d OFFSET ?%d", idx, idx+1)
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	return s.query(ctx, q, append(args, limit, filter.Offset)...)
+}
+
+func (s *SQLiteRepository) ByWallet(ctx context.Context, address string, filter EventFilter) ([]*Event, error) {
+	return s.ByWallets(ctx, []string{address}, filter)
+}
+
+func (s *SQLiteRepository) ByWallets(ctx context.Context, addresses []string, filter EventFilter) ([]*Event, error) {
+	if len(addresses) == 0 {
+		return []*Event{}, nil
+	}
+	wallets, args := walletWhere("?", 1, addresses)
+	q := `
+		SELECT ` + eventColumns + `
+		FROM events
+		WHERE ` + wallets
+	where, whereArgs := filter.sqlWhere("?", len(args)+1)
+	return s.page(ctx, q+where, append(args, whereArgs...), filter)
+}
+
+func (s *SQLiteRepository) Recent(ctx context.Context, filter EventFilter) ([]*Event, error) {
+	q := `
+		SELECT ` + eventColumns + `
+		FROM events
+		WHERE 1=1
+	`
+	where, args := filter.sqlWhere("?", 1)
+	return s.page(ctx, q+where, args, filter)
+}
+
+func (s *SQLiteRepository) Count(ctx context.Context, addresses []string, filter EventFilter) (int, error) {
+	q := `SELECT COUNT(*) FROM events WHERE 1=1`
+	var args []interface{}
+	if len(addresses) > 0 {
+		var wallets string
+		wallets, args = walletWhere("?", 1, addresses)
+		q += ` AND ` + wallets
+	}
+	where, whereArgs := filter.sqlWhere("?", len(args)+1)
+	var n int
+	err := s.db.QueryRowContext(ctx, q+where, append(args, whereArgs...)...).Scan(&n)
+	return n, err
+}
+
+// Sample has no TABLESAMPLE to draw on: it orders the matching rows at
+// random and returns their exact count.
+func (s *SQLiteRepository) Sample(ctx context.Context, addresses []string, filter EventFilter, size int) ([]*Event, int, error) {
+	total, err := s.Count(ctx, addresses, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	where, args := listingWhere("?", addresses, filter)
+	q := fmt.Sprintf(`SELECT %s FROM (SELECT * FROM events WHERE %s ORDER BY random() LIMIT ?%d) AS events`,
+		eventColumns, where, len(args)+1)
+	events, err := s.query(ctx, q+filter.orderSQL(sqliteSortColumns, "created_at DESC"), append(args, size)...)
+	return events, total, err
+}
+
+func (s *SQLiteRepository) ByID(ctx context.Context, eventID string) (*Event, bool, error) {
+	events, err := s.query(ctx, `SELECT `+eventColumns+` FROM events WHERE event_id = ?1`, eventID)
+	if err != nil || len(events) == 0 {
+		return nil, false, err
+	}
+	return events[0], true, nil
+}
+
+func (s *SQLiteRepository) ByTxHash(ctx context.Context, hash, chain string) ([]*Event, error) {
+	// Rows written before normalization may differ in case or 0x prefix
+	q := `SELECT ` + eventColumns + ` FROM events WHERE tx_hash = ?1`
+	args := []interface{}{hash}
+	if strings.HasPrefix(hash, "0x") {
+		q = `SELECT ` + eventColumns + ` FROM events WHERE LOWER(tx_hash) IN (?1, ?2)`
+		args = append(args, strings.TrimPrefix(hash, "0x"))
+	}
+	if chain != "" {
+		args = append(args, chain)
+		q += fmt.Sprintf(" AND chain = ?%d", len(args))
+	}
+	return s.query(ctx, q+" ORDER BY created_at, rowid", args...)
+}
+
+// ApplyConfirmation selects the affected rows and updates them in one
+// transaction, returning the previous status of every updated row.
+func (s *SQLiteRepository) ApplyConfirmation(ctx context.Context, u ConfirmationUpdate) ([]StatusChange, error) {
+	var where string
+	args := []interface{}{u.Status}
+	if len(u.EventIDs) > 0 {
+		where = "event_id IN (" + placeholders(2, len(u.EventIDs)) + ")"
+		for _, id := range u.EventIDs {
+			args = append(args, id)
+		}
+	} else {
+		// G115: Safe conversion - block heights fit in int64 range
+		height := int64(*u.BlockNumber)
+		where = "chain = ?2 AND (?3 = '' OR network = ?3)"
+		args = append(args, u.Chain, u.Network, height)
+		if u.Status == StatusOrphaned {
+			where += fmt.Sprintf(" AND COALESCE(block_number, slot) >= ?4 AND status <> '%s'", StatusFinalized)
+		} else {
+			where += fmt.Sprintf(" AND COALESCE(block_number, slot) <= ?4 AND status IN ('%s', '%s')", StatusPending, StatusConfirmed)
+		}
+	}
+	where += " AND status <> ?1"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `SELECT event_id, chain, network, tx_hash, status, tenant, shared_with, from_addr, to_addr,
+		COALESCE(block_number, slot), timestamp FROM events WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	var out []StatusChange
+	for rows.Next() {
+		c := StatusChange{Type: "status_change", Status: u.Status}
+		var sharedWith string
+		var height *int64
+		if err := rows.Scan(&c.EventID, &c.Chain, &c.Network, &c.TxHash, &c.PreviousStatus, &c.Tenant, &sharedWith, &c.From, &c.To, &height, &c.Timestamp); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		c.SharedWith = parseSharedWith(sharedWith)
+		c.Height = heightFromDB(height)
+		out = append(out, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE events SET status = ?1, updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now')
+		WHERE `+where, args...); err != nil {
+		return nil, err
+	}
+	return out, tx.Commit()
+}
+
+// inRange loads the events of an analytics query. SQLite has no date_trunc,
+// so bucketing happens in Go.
+func (s *SQLiteRepository) inRange(ctx context.Context, q AnalyticsQuery) ([]*Event, error) {
+	where := fmt.Sprintf("julianday(timestamp) >= julianday(?1) AND julianday(timestamp) < julianday(?2) AND status <> '%s' AND superseded_by = ''", StatusOrphaned)
+	args := []interface{}{q.Start.Format(time.RFC3339Nano), q.End.Format(time.RFC3339Nano)}
+	if q.Chain != "" {
+		args = append(args, q.Chain)
+		where += fmt.Sprintf(" AND chain = ?%d", len(args))
+	}
+	if q.Network != "" {
+		args = append(args, q.Network)
+		where += fmt.Sprintf(" AND network = ?%d", len(args))
+	}
+	if q.Token != "" {
+		args = append(args, q.Token)
+		where += fmt.Sprintf(" AND token_symbol = ?%d", len(args))
+	}
+	if q.Tenant != "" {
+		args = append(args, q.Tenant)
+		where += fmt.Sprintf(" AND "+tenantSQL("?"), "?", len(args))
+	}
+	return s.query(ctx, `SELECT `+eventColumns+` FROM events WHERE `+where, args...)
+}
+
+func (s *SQLiteRepository) VolumeSeries(ctx context.Context, q AnalyticsQuery) ([]VolumePoint, error) {
+	events, err := s.inRange(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return aggregateVolume(events, q), nil
+}
+
+func (s *SQLiteRepository) ActiveWalletsSeries(ctx context.Context, q AnalyticsQuery) ([]ActiveWalletsPoint, error) {
+	events, err := s.inRange(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return aggregateActiveWallets(events, q), nil
+}
+
+// sumLimbs is how many 9-digit limbs exactSumSQL splits a value into: 81
+// digits hold any uint256.
+const sumLimbs = 9
+
+// exactSumSQL renders one SUM per 9-digit limb of expr, an unsigned decimal
+// string, so totals of wei amounts stay exact where SQLite's own SUM would
+// overflow int64 or round through REAL. joinLimbs adds the limbs back up.
+func exactSumSQL(expr string) string {
+	padded := "substr('" + strings.Repeat("0", 9*sumLimbs) + "' || " + expr + fmt.Sprintf(", -%d, %d)", 9*sumLimbs, 9*sumLimbs)
+	sums := make([]string, sumLimbs)
+	for i := range sums {
+		sums[i] = fmt.Sprintf("COALESCE(SUM(CAST(substr(%s, %d, 9) AS INTEGER)), 0)", padded, 1+9*i)
+	}
+	return strings.Join(sums, ", ")
+}
+
+// joinLimbs returns the decimal total of the limb sums of exactSumSQL.
+func joinLimbs(limbs []int64) string {
+	total := new(big.Int)
+	base := big.NewInt(1e9)
+	for _, l := range limbs {
+		total.Mul(total, base)
+		total.Add(total, big.NewInt(l))
+	}
+	return total.String()
+}
+
+// limbDests returns scan destinations for limbs.
+func limbDests(limbs []int64) []interface{} {
+	dests := make([]interface{}, len(limbs))
+	for i := range limbs {
+		dests[i] = &limbs[i]
+	}
+	return dests
+}
+
+// sqliteUnsigned matches the values exactSumSQL can sum.
+func sqliteUnsigned(column string) string {
+	return fmt.Sprintf("(%s <> '' AND %s NOT GLOB '*[^0-9]*' AND length(%s) <= %d)", column, column, column, 9*sumLimbs)
+}
+
+// WalletStats sums the wallet's flows per asset and its fees per chain in
+// SQL, like PostgresRepository.WalletStats, with exactSumSQL in place of
+// NUMERIC.
+func (s *SQLiteRepository) WalletStats(ctx context.Context, address string, filter EventFilter) (WalletStats, error) {
+	wallets, args := walletWhere("?", 1, []string{address})
+	where, whereArgs := filter.sqlWhere("?", len(args)+1)
+	args = append(args, whereArgs...)
+	// walletWhere binds the address key to ?1
+	from, to := addressColumn("from_addr", address)+" = ?1", addressColumn("to_addr", address)+" = ?1"
+	payer := addressColumn("CASE WHEN executed_by <> '' THEN executed_by ELSE from_addr END", address) + " = ?1"
+
+	stats := WalletStats{Address: address, Assets: make([]WalletAssetStats, 0)}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT chain, network, COALESCE(token_symbol, '`+nativeToken+`'), COALESCE(token_address, ''), COUNT(*),
+			`+exactSumSQL("CASE WHEN "+from+" THEN value ELSE '0' END")+`,
+			`+exactSumSQL("CASE WHEN "+to+" THEN value ELSE '0' END")+`
+		FROM events
+		WHERE `+wallets+where+` AND `+sqliteUnsigned("value")+`
+		GROUP BY 1, 2, 3, 4
+	`, args...)
+	if err != nil {
+		return WalletStats{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a WalletAssetStats
+		sent, received := make([]int64, sumLimbs), make([]int64, sumLimbs)
+		dests := append([]interface{}{&a.Chain, &a.Network, &a.Token, &a.TokenAddress, &a.Transfers}, limbDests(sent)...)
+		if err := rows.Scan(append(dests, limbDests(received)...)...); err != nil {
+			return WalletStats{}, err
+		}
+		a.Sent, a.Received = joinLimbs(sent), joinLimbs(received)
+		stats.Assets = append(stats.Assets, a)
+	}
+	if err := rows.Err(); err != nil {
+		return WalletStats{}, err
+	}
+
+	// SQLite has no DISTINCT ON; grouping by transaction keeps one fee each
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT chain, network, COALESCE(SUM(gas_used), 0), COUNT(*),
+			`+exactSumSQL("fee")+`, `+exactSumSQL("priority_fee")+`
+		FROM (
+			SELECT chain, network, MAX(fee) AS fee, MAX(gas_used) AS gas_used,
+				MAX(CASE WHEN `+sqliteUnsigned("priority_fee")+` THEN priority_fee ELSE '0' END) AS priority_fee
+			FROM events
+			WHERE `+wallets+where+` AND `+sqliteUnsigned("fee")+` AND `+payer+`
+			GROUP BY chain, network, tx_hash
+		)
+		GROUP BY chain, network
+	`, args...)
+	if err != nil {
+		return WalletStats{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var chain, network string
+		var gasUsed, txs int64
+		fees, priority := make([]int64, sumLimbs), make([]int64, sumLimbs)
+		dests := append([]interface{}{&chain, &network, &gasUsed, &txs}, limbDests(fees)...)
+		if err := rows.Scan(append(dests, limbDests(priority)...)...); err != nil {
+			return WalletStats{}, err
+		}
+		// G115: Safe conversion - a SUM of non-negative gas amounts
+		stats.addFees(chain, network, joinLimbs(fees), joinLimbs(priority), uint64(gasUsed), txs)
+	}
+	if err := rows.Err(); err != nil {
+		return WalletStats{}, err
+	}
+	stats.finish()
+	return stats, nil
+}
+
+// PurgeBefore deletes events received before cutoff, one batch per
+// statement. created_at is stored as fixed-width ISO 8601 UTC text, which
+// compares chronologically.
+func (s *SQLiteRepository) PurgeBefore(ctx context.Context, cutoff time.Time, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = defaultPurgeBatchSize
+	}
+	bound := cutoff.UTC().Format("2006-01-02T15:04:05.000Z")
+	total := 0
+	for {
+		res, err := s.db.ExecContext(ctx, `
+			DELETE FROM events WHERE rowid IN (
+				SELECT rowid FROM events WHERE created_at < ?1 LIMIT ?2
+			)
+		`, bound, batchSize)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += int(n)
+		if int(n) < batchSize {
+			return total, nil
+		}
+	}
+}
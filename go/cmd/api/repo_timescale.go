@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TimescaleRepository stores events in a TimescaleDB hypertable partitioned
+// by created_at, for deployments ingesting millions of events a day. Reads
+// and status updates are plain Postgres and shared with PostgresRepository;
+// writes are batched with COPY and old chunks are dropped by a retention
+// policy. created_at is the event's own timestamp, so a redelivered event
+// lands on the same row.
+type TimescaleRepository struct {
+	*PostgresRepository
+}
+
+// OpenTimescaleRepository connects to dsn and creates the hypertable if
+// needed. chunkInterval and retention are Postgres intervals (e.g. "1 day",
+// "90 days"); an empty retention keeps events forever.
+func OpenTimescaleRepository(ctx context.Context, dsn, chunkInterval, retention string) (*TimescaleRepository, error) {
+	db, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := initTimescale(ctx, db, chunkInterval, retention); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &TimescaleRepository{&PostgresRepository{db: db}}, nil
+}
+
+// keylessEventsSchema is the baseline events table of the backends that
+// partition it by created_at, without the primary key on event_id their
+// partitioning rules out, and the labels table.
+const keylessEventsSchema = `
+		CREATE TABLE IF NOT EXISTS events (
+			event_id TEXT NOT NULL,
+			chain TEXT NOT NULL,
+			network TEXT NOT NULL,
+			tx_hash TEXT NOT NULL,
+			timestamp TEXT NOT NULL,
+			from_addr TEXT NOT NULL,
+			to_addr TEXT NOT NULL,
+			value TEXT NOT NULL,
+			event_type TEXT NOT NULL,
+			block_number BIGINT NULL,
+			slot BIGINT NULL,
+			status TEXT NOT NULL DEFAULT 'confirmed',
+			token_address TEXT NULL,
+			token_symbol TEXT NULL,
+			token_decimals INT NULL,
+			executed_by TEXT NOT NULL DEFAULT '',
+			multisig TEXT NOT NULL DEFAULT '',
+			authority TEXT NOT NULL DEFAULT '',
+			authority_program TEXT NOT NULL DEFAULT '',
+			tenant TEXT NOT NULL DEFAULT '',
+			bridge TEXT NOT NULL DEFAULT '',
+			source_chain TEXT NOT NULL DEFAULT '',
+			dest_chain TEXT NOT NULL DEFAULT '',
+			bridge_sequence TEXT NOT NULL DEFAULT '',
+			annotations TEXT NOT NULL DEFAULT '',
+			fee TEXT NOT NULL DEFAULT '',
+			gas_used BIGINT NULL,
+			priority_fee TEXT NOT NULL DEFAULT '',
+			shared_with TEXT NOT NULL DEFAULT '',
+			amount NUMERIC NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS executed_by TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS multisig TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS authority TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS authority_program TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS bridge TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS source_chain TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS dest_chain TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS bridge_sequence TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS annotations TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS fee TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS gas_used BIGINT NULL;
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS priority_fee TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS shared_with TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS amount NUMERIC NULL;
+		CREATE TABLE IF NOT EXISTS labels (
+			address TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			category TEXT NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_labels_category ON labels (category);
+	`
+
+// timescaleMigrations are the schema changes of the timescale backend. They
+// are tracked apart from postgresMigrations, as the events table differs,
+// so an events table converted from the postgres backend still gets them.
+var timescaleMigrations = []migration{
+	{Version: 1, Name: "baseline", SQL: `
+		CREATE EXTENSION IF NOT EXISTS timescaledb;` + keylessEventsSchema + chainsSchema + viewsSchema + pluginsSchema + metricsSchema + correlationsSchema + bridgeRoutesSchema},
+	{Version: 2, Name: "event nonce", SQL: `ALTER TABLE events ADD COLUMN IF NOT EXISTS nonce BIGINT NULL`},
+	// Like event_id, dedup_key is only unique per created_at, and a table
+	// converted from the postgres backend drops that backend's index
+	{Version: 3, Name: "event dedup key", SQL: `
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS log_index BIGINT NULL;
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS dedup_key TEXT NULL;
+		DROP INDEX IF EXISTS idx_events_dedup_key;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_events_dedup_key_created ON events (dedup_key, created_at);
+	`},
+	{Version: 4, Name: "search indexes", SQL: `
+		CREATE EXTENSION IF NOT EXISTS pg_trgm;
+		CREATE INDEX IF NOT EXISTS idx_events_tx_hash_trgm ON events USING GIN (LOWER(tx_hash) gin_trgm_ops);
+		CREATE INDEX IF NOT EXISTS idx_events_from_trgm ON events USING GIN (LOWER(from_addr) gin_trgm_ops);
+		CREATE INDEX IF NOT EXISTS idx_events_to_trgm ON events USING GIN (LOWER(to_addr) gin_trgm_ops);
+		CREATE INDEX IF NOT EXISTS idx_events_token_symbol_trgm ON events USING GIN (LOWER(token_symbol) gin_trgm_ops);
+		CREATE INDEX IF NOT EXISTS idx_events_token_address_trgm ON events USING GIN (LOWER(token_address) gin_trgm_ops);
+	`},
+	{Version: 5, Name: "event provenance", SQL: `ALTER TABLE events ADD COLUMN IF NOT EXISTS provenance TEXT NOT NULL DEFAULT ''`},
+	{Version: 6, Name: "event source", SQL: `ALTER TABLE events ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT ''`},
+	{Version: 7, Name: "event amendments", SQL: `
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS supersedes TEXT NOT NULL DEFAULT '';
+		ALTER TABLE events ADD COLUMN IF NOT EXISTS superseded_by TEXT NOT NULL DEFAULT '';
+	`},
+	{Version: 8, Name: "event chain id", SQL: `ALTER TABLE events ADD COLUMN IF NOT EXISTS chain_id TEXT NOT NULL DEFAULT ''`},
+	{Version: 9, Name: "reorg ledger", SQL: reorgsSchema},
+	{Version: 10, Name: "treasury policies", SQL: treasurySchema},
+	{Version: 11, Name: "escalation policies", SQL: escalationSchema},
+	{Version: 12, Name: "wallet ownership", SQL: ownershipSchema},
+	{Version: 13, Name: "wallet privacy", SQL: walletPrivacySchema},
+	{Version: 14, Name: "identities", SQL: identitiesSchema},
+	{Version: 15, Name: "daily reports", SQL: dailyReportSchema},
+}
+
+// initTimescale migrates the schema, then creates the events hypertable and
+// applies the retention policy. Hypertable unique keys must include the
+// partitioning column, so events are unique on (event_id, created_at) rather
+// than keyed by event_id.
+// An events table created by the postgres backend is converted in place: its
+// primary key is dropped and its rows are moved into chunks.
+func initTimescale(ctx context.Context, db *pgxpool.Pool, chunkInterval, retention string) error {
+	if err := migratePostgres(ctx, db, "timescale_migrations", timescaleMigrations); err != nil {
+		return err
+	}
+	if _, err := db.Exec(ctx, `
+		DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM timescaledb_information.hypertables
+				WHERE hypertable_schema = current_schema() AND hypertable_name = 'events'
+			) THEN
+				ALTER TABLE events DROP CONSTRAINT IF EXISTS events_pkey;
+			END IF;
+		END $$;
+	`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(ctx,
+		`SELECT create_hypertable('events', 'created_at', chunk_time_interval => $1::interval, if_not_exists => TRUE, migrate_data => TRUE)`,
+		chunkInterval); err != nil {
+		return err
+	}
+	if _, err := db.Exec(ctx, `
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_events_event_id_created ON events (event_id, created_at);
+		DROP INDEX IF EXISTS idx_events_event_id;
+		CREATE INDEX IF NOT EXISTS idx_events_from ON events (LOWER(from_addr), created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_events_to ON events (LOWER(to_addr), created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_events_from_exact ON events (from_addr, created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_events_to_exact ON events (to_addr, created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_events_tx_hash ON events (tx_hash);
+		CREATE INDEX IF NOT EXISTS idx_events_tx_hash_lower ON events (LOWER(tx_hash));
+		CREATE INDEX IF NOT EXISTS idx_events_chain_height ON events (chain, network, (COALESCE(block_number, slot)));
+		CREATE INDEX IF NOT EXISTS idx_events_tenant_created ON events (tenant, created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_events_bridge_sequence ON events (bridge, bridge_sequence) WHERE bridge <> '';
+	`); err != nil {
+		return err
+	}
+
+	// Replace any previous policy so a changed retention takes effect
+	if _, err := db.Exec(ctx, `SELECT remove_retention_policy('events', if_exists => TRUE)`); err != nil {
+		return err
+	}
+	if retention != "" {
+		if _, err := db.Exec(ctx, `SELECT add_retention_policy('events', $1::interval)`, retention); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *TimescaleRepository) Insert(ctx context.Context, ev *Event) error {
+	return t.InsertBatch(ctx, []*Event{ev})
+}
+
+func (t *TimescaleRepository) InsertBatch(ctx context.Context, events []*Event) error {
+	return insertStaged(ctx, t.db, events)
+}
+
+// insertStaged COPYs events into a staging table and moves those not
+// already stored into events, in one transaction, with partitionTime as
+// created_at. It serves the backends whose events table is partitioned by
+// created_at, where event_id is only unique per created_at: concurrent
+// writers of the same event compute the same created_at, so the unique
+// index keeps one row.
+func insertStaged(ctx context.Context, db *pgxpool.Pool, events []*Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	rows := make([][]interface{}, 0, len(events))
+	for _, ev := range events {
+		args, err := eventArgs(ev)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, append(args, partitionTime(ev)))
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, `
+		CREATE TEMP TABLE events_staging (LIKE events INCLUDING DEFAULTS) ON COMMIT DROP
+	`); err != nil {
+		return err
+	}
+	const insertColumns = eventInsertColumns + `, created_at`
+	columns := strings.Split(strings.Join(strings.Fields(insertColumns), ""), ",")
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"events_staging"}, columns, pgx.CopyFromRows(rows)); err != nil {
+		return err
+	}
+	// The existence check catches rows stored before created_at followed
+	// the event timestamp, and copies of a transfer received at another
+	// time; the unique indexes catch concurrent writers.
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO events (`+insertColumns+`)
+		SELECT DISTINCT ON (COALESCE(s.dedup_key, s.event_id)) `+insertColumns+`
+		FROM events_staging s
+		WHERE NOT EXISTS (SELECT 1 FROM events e WHERE e.event_id = s.event_id OR e.dedup_key = s.dedup_key)
+		ON CONFLICT DO NOTHING
+	`); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// partitionTime is the created_at of an event in the hypertable: its own
+// timestamp, or the time it was received when that does not parse.
+func partitionTime(ev *Event) time.Time {
+	if ts, err := time.Parse(time.RFC3339, ev.Timestamp); err == nil {
+		return ts.UTC()
+	}
+	return time.Now().UTC()
+}
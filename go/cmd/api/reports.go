@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// reportDateLayout is the layout of report dates, which are UTC days.
+	reportDateLayout = "2006-01-02"
+	// defaultReportTopTransfers is how many of the largest transfers a
+	// report lists.
+	defaultReportTopTransfers = 10
+	// maxReportItems caps the exposures, stuck transfers and incidents a
+	// report lists.
+	maxReportItems = 100
+	// maxReportEvents caps the events of a day a report scans.
+	maxReportEvents = 50000
+	reportPageSize  = 1000
+	// reportCheckInterval is how often due reports are looked for.
+	reportCheckInterval = 5 * time.Minute
+	// reportIncidentWindow is how long incidents are remembered for the
+	// reports of the days they happened on.
+	reportIncidentWindow = 48 * time.Hour
+	// maxRememberedIncidents caps the incidents remembered within the
+	// window, across tenants and days.
+	maxRememberedIncidents = 1000
+)
+
+// reportIncidentKinds are the system events reported as indexer incidents.
+var reportIncidentKinds = map[string]bool{
+	SystemIndexerLag:    true,
+	SystemIndexerGap:    true,
+	SystemReorgDetected: true,
+	SystemSLOBurn:       true,
+}
+
+// dailyReportSchema creates the table compiled daily reports are persisted
+// in, one per tenant and day.
+const dailyReportSchema = `
+	CREATE TABLE IF NOT EXISTS daily_reports (
+		tenant TEXT NOT NULL DEFAULT '',
+		day DATE NOT NULL,
+		report JSONB NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (tenant, day)
+	);
+`
+
+// errReportNotReady is returned for days that have not ended yet.
+var errReportNotReady = errors.New("the day has not ended yet")
+
+// ReportTransfer is one of the largest transfers of a day, valued in USD.
+type ReportTransfer struct {
+	Event *Event `json:"event"`
+	USD   string `json:"usd"`
+}
+
+// SanctionedExposure is the first transfer of a day with a counterparty
+// labeled sanctioned that the tenant had never transacted with before.
+type SanctionedExposure struct {
+	Address string `json:"address"`
+	Name    string `json:"name"`
+	// Direction is "sent" when the tenant's wallet sent to the address and
+	// "received" when it received from it.
+	Direction string `json:"direction"`
+	Chain     string `json:"chain"`
+	EventID   string `json:"event_id"`
+	TxHash    string `json:"tx_hash"`
+	At        string `json:"at"`
+}
+
+// DailyReport digests a tenant's UTC day: its largest transfers, new
+// exposure to sanctioned counterparties, the bridge transfers stuck when
+// the report was compiled and the indexer incidents of the day.
+type DailyReport struct {
+	Date               string               `json:"date"`
+	GeneratedAt        string               `json:"generated_at"`
+	LargestTransfers   []ReportTransfer     `json:"largest_transfers"`
+	SanctionedExposure []SanctionedExposure `json:"sanctioned_exposure"`
+	StuckBridges       []BridgeTransfer     `json:"stuck_bridges"`
+	Incidents          []SystemEvent        `json:"incidents"`
+	// Truncated is set when the day had more events than a report scans.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// summary describes the report in one line.
+func (r DailyReport) summary() string {
+	return fmt.Sprintf("Daily report for %s: %d large transfers, %d new sanctioned counterparties, %d stuck bridge transfers, %d indexer incidents",
+		r.Date, len(r.LargestTransfers), len(r.SanctionedExposure), len(r.StuckBridges), len(r.Incidents))
+}
+
+// text renders the report as the body of an email.
+func (r DailyReport) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s.\n", r.summary())
+	if len(r.LargestTransfers) > 0 {
+		b.WriteString("\nLargest transfers:\n")
+		for _, t := range r.LargestTransfers {
+			fmt.Fprintf(&b, "- $%s %s %s -> %s (%s)\n", t.USD, eventAsset(t.Event), t.Event.From, t.Event.To, t.Event.EventID)
+		}
+	}
+	if len(r.SanctionedExposure) > 0 {
+		b.WriteString("\nNew sanctioned counterparties:\n")
+		for _, e := range r.SanctionedExposure {
+			fmt.Fprintf(&b, "- %s (%s), %s on %s in %s\n", e.Address, e.Name, e.Direction, e.Chain, e.EventID)
+		}
+	}
+	if len(r.StuckBridges) > 0 {
+		b.WriteString("\nStuck bridge transfers:\n")
+		for _, t := range r.StuckBridges {
+			fmt.Fprintf(&b, "- %s %s -> %s, %s (%s)\n", t.Transfer.Bridge, t.Transfer.SourceChain, t.Transfer.DestChain, time.Duration(t.LatencySeconds)*time.Second, t.Transfer.EventID)
+		}
+	}
+	if len(r.Incidents) > 0 {
+		b.WriteString("\nIndexer incidents:\n")
+		for _, ev := range r.Incidents {
+			fmt.Fprintf(&b, "- %s %s %s\n", ev.At, ev.Kind, escalationSummary(ev))
+		}
+	}
+	fmt.Fprintf(&b, "\nThe full report is at GET /reports/daily/%s.\n", r.Date)
+	return b.String()
+}
+
+// ParseReportRecipients parses DAILY_REPORT_EMAILS, a comma-separated list
+// of tenant=email entries, or of bare emails receiving the report of a
+// deployment without tenants.
+func ParseReportRecipients(spec string) (map[string][]string, error) {
+	out := make(map[string][]string)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		tenant, email := "", item
+		if t, e, ok := strings.Cut(item, "="); ok {
+			tenant, email = strings.ToLower(strings.TrimSpace(t)), strings.TrimSpace(e)
+			if !chainNamePattern.MatchString(tenant) {
+				return nil, fmt.Errorf("invalid report recipient %q: want tenant=email", item)
+			}
+		}
+		address, err := mail.ParseAddress(email)
+		if err != nil {
+			return nil, fmt.Errorf("invalid report recipient %q: %v", item, err)
+		}
+		out[tenant] = append(out[tenant], address.Address)
+	}
+	return out, nil
+}
+
+type reportKey struct{ tenant, date string }
+
+// DailyReports compiles a daily report for every tenant once its day has
+// ended, announces it as a report.daily system event and emails it to the
+// tenant's recipients. Reports are kept once compiled; with a database
+// attached they are persisted in daily_reports. Indexer incidents are
+// remembered in memory from the system events emitted, so a restart
+// leaves out the incidents before it.
+type DailyReports struct {
+	store   *EventStore
+	labels  *LabelStore
+	tenants *Tenants
+	top     int
+	now     func() time.Time
+
+	events     *SystemEvents
+	mailer     *Mailer
+	recipients map[string][]string
+	db         *pgxpool.Pool
+
+	mu        sync.Mutex
+	reports   map[reportKey]DailyReport
+	incidents []SystemEvent
+}
+
+// NewDailyReports creates reports listing the top largest transfers of
+// each day.
+func NewDailyReports(store *EventStore, labels *LabelStore, tenants *Tenants, top int) *DailyReports {
+	if top <= 0 {
+		top = defaultReportTopTransfers
+	}
+	return &DailyReports{
+		store:   store,
+		labels:  labels,
+		tenants: tenants,
+		top:     top,
+		now:     time.Now,
+		reports: make(map[reportKey]DailyReport),
+	}
+}
+
+// AttachDB persists compiled reports in db and looks up the reports of
+// earlier runs there.
+func (d *DailyReports) AttachDB(ctx context.Context, db *pgxpool.Pool) error {
+	if err := db.Ping(ctx); err != nil {
+		return err
+	}
+	d.db = db
+	return nil
+}
+
+// AttachDelivery announces compiled reports on events and emails them to
+// recipients, by tenant. Either may be nil.
+func (d *DailyReports) AttachDelivery(events *SystemEvents, mailer *Mailer, recipients map[string][]string) {
+	d.events, d.mailer, d.recipients = events, mailer, recipients
+}
+
+// Observe remembers ev when it is an indexer incident. It never blocks.
+func (d *DailyReports) Observe(ev SystemEvent) {
+	if d == nil || !reportIncidentKinds[ev.Kind] {
+		return
+	}
+	cutoff := d.now().Add(-reportIncidentWindow).UTC().Format(time.RFC3339)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	kept := d.incidents[:0]
+	for _, i := range d.incidents {
+		if i.At >= cutoff {
+			kept = append(kept, i)
+		}
+	}
+	d.incidents = append(kept, ev)
+	if len(d.incidents) > maxRememberedIncidents {
+		d.incidents = d.incidents[len(d.incidents)-maxRememberedIncidents:]
+	}
+}
+
+// dayTenants returns the tenants reports are compiled for: every tenant,
+// or the unscoped deployment without tenants.
+func (d *DailyReports) dayTenants() []string {
+	if names := d.tenants.Names(); len(names) > 0 {
+		return names
+	}
+	return []string{""}
+}
+
+// Run compiles and delivers the reports of the previous day as soon as it
+// has ended, until ctx is cancelled. Reports missed while the API was
+// down are compiled on start for the previous day only.
+func (d *DailyReports) Run(ctx context.Context) {
+	ticker := time.NewTicker(reportCheckInterval)
+	defer ticker.Stop()
+	for {
+		d.generateDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// generateDue compiles and delivers the previous day's reports that do
+// not exist yet.
+func (d *DailyReports) generateDue(ctx context.Context) {
+	yesterday := d.now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	for _, tenant := range d.dayTenants() {
+		if _, ok, err := d.stored(ctx, tenant, yesterday); err != nil || ok {
+			if err != nil {
+				log.WithError(err).WithField("tenant", tenant).Warn("could not look up daily report")
+			}
+			continue
+		}
+		report, err := d.Report(ctx, tenant, yesterday.Format(reportDateLayout))
+		if err != nil {
+			log.WithError(err).WithField("tenant", tenant).Error("could not compile daily report")
+			continue
+		}
+		d.deliver(tenant, report)
+	}
+}
+
+// stored returns tenant's report for day when it was compiled already.
+func (d *DailyReports) stored(ctx context.Context, tenant string, day time.Time) (DailyReport, bool, error) {
+	date := day.Format(reportDateLayout)
+	d.mu.Lock()
+	report, ok := d.reports[reportKey{tenant, date}]
+	d.mu.Unlock()
+	if ok || d.db == nil {
+		return report, ok, nil
+	}
+	var raw []byte
+	err := d.db.QueryRow(ctx, `SELECT report FROM daily_reports WHERE tenant = $1 AND day = $2`, tenant, day).Scan(&raw)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DailyReport{}, false, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(raw, &report)
+	}
+	if err != nil {
+		return DailyReport{}, false, err
+	}
+	d.mu.Lock()
+	d.reports[reportKey{tenant, date}] = report
+	d.mu.Unlock()
+	return report, true, nil
+}
+
+// Report returns tenant's report for date, a YYYY-MM-DD UTC day,
+// compiling and keeping it when it does not exist yet. Days that have not
+// ended return errReportNotReady.
+func (d *DailyReports) Report(ctx context.Context, tenant, date string) (DailyReport, error) {
+	day, err := time.Parse(reportDateLayout, date)
+	if err != nil {
+		return DailyReport{}, invalidParam("date", "date must be a YYYY-MM-DD day")
+	}
+	now := d.now().UTC()
+	if day.AddDate(0, 0, 1).After(now) {
+		return DailyReport{}, errReportNotReady
+	}
+	if report, ok, err := d.stored(ctx, tenant, day); err != nil || ok {
+		return report, err
+	}
+	report := d.compile(tenant, day, now)
+	if d.db != nil {
+		raw, err := json.Marshal(report)
+		if err != nil {
+			return DailyReport{}, err
+		}
+		if _, err := d.db.Exec(ctx, `
+			INSERT INTO daily_reports (tenant, day, report) VALUES ($1, $2, $3)
+			ON CONFLICT (tenant, day) DO NOTHING
+		`, tenant, day, raw); err != nil {
+			return DailyReport{}, err
+		}
+	}
+	d.mu.Lock()
+	d.reports[reportKey{tenant, date}] = report
+	d.mu.Unlock()
+	return report, nil
+}
+
+// compile builds tenant's report for the UTC day starting at day.
+func (d *DailyReports) compile(tenant string, day, now time.Time) DailyReport {
+	start, end := day, day.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	report := DailyReport{
+		Date:               day.Format(reportDateLayout),
+		GeneratedAt:        now.Format(time.RFC3339),
+		LargestTransfers:   []ReportTransfer{},
+		SanctionedExposure: []SanctionedExposure{},
+		StuckBridges:       []BridgeTransfer{},
+		Incidents:          []SystemEvent{},
+	}
+
+	var events []*Event
+	for offset := 0; ; offset += reportPageSize {
+		if offset >= maxReportEvents {
+			report.Truncated = true
+			break
+		}
+		page := d.store.GetRecent(EventFilter{Tenant: tenant, StartTime: &start, EndTime: &end, Limit: reportPageSize, Offset: offset})
+		events = append(events, page...)
+		if len(page) < reportPageSize {
+			break
+		}
+	}
+
+	type valued struct {
+		ev  *Event
+		usd *big.Rat
+	}
+	var priced []valued
+	exposed := make(map[string]bool)
+	// Oldest first, so the first transfer with a counterparty is reported
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp < events[j].Timestamp })
+	for _, ev := range events {
+		if ev.Status == StatusOrphaned || ev.SupersededBy != "" {
+			continue
+		}
+		if _, usd, _ := usdOf(d.store.prices, ev, false); usd != nil {
+			priced = append(priced, valued{ev, usd})
+		}
+		for _, side := range []struct{ address, direction string }{{ev.To, "sent"}, {ev.From, "received"}} {
+			label, ok := d.labels.Get(tenant, side.address)
+			key := addressKey(side.address)
+			if !ok || label.Category != LabelSanctioned || exposed[key] || len(report.SanctionedExposure) == maxReportItems {
+				continue
+			}
+			exposed[key] = true
+			// Only counterparties the tenant never transacted with before
+			// the day are new exposure
+			before := start.Add(-time.Nanosecond)
+			if d.store.Count([]string{side.address}, EventFilter{Tenant: tenant, EndTime: &before}) > 0 {
+				continue
+			}
+			report.SanctionedExposure = append(report.SanctionedExposure, SanctionedExposure{
+				Address: side.address, Name: label.Name, Direction: side.direction,
+				Chain: ev.Chain, EventID: ev.EventID, TxHash: ev.TxHash, At: ev.Timestamp,
+			})
+		}
+	}
+	sort.SliceStable(priced, func(i, j int) bool { return priced[i].usd.Cmp(priced[j].usd) > 0 })
+	for i := 0; i < len(priced) && i < d.top; i++ {
+		report.LargestTransfers = append(report.LargestTransfers, ReportTransfer{Event: d.store.EnrichOne(priced[i].ev), USD: priced[i].usd.FloatString(2)})
+	}
+
+	// Transfers sent by the end of the day that are still stuck
+	for _, t := range d.store.BridgeTransfers("", TransferStuck, tenant, maxBridgeTransfers, now) {
+		if at, err := time.Parse(time.RFC3339, t.Transfer.Timestamp); err == nil && !at.After(end) && len(report.StuckBridges) < maxReportItems {
+			t.Transfer = d.store.EnrichOne(t.Transfer)
+			t.Legs = d.store.Enrich(t.Legs)
+			report.StuckBridges = append(report.StuckBridges, t)
+		}
+	}
+
+	startAt, endAt := start.Format(time.RFC3339), end.Format(time.RFC3339)
+	d.mu.Lock()
+	for _, ev := range d.incidents {
+		if ev.At >= startAt && ev.At <= endAt && (ev.Tenant == "" || ev.Tenant == tenant) && len(report.Incidents) < maxReportItems {
+			report.Incidents = append(report.Incidents, ev)
+		}
+	}
+	d.mu.Unlock()
+	return report
+}
+
+// deliver announces tenant's report as a report.daily system event and
+// emails it to the tenant's recipients.
+func (d *DailyReports) deliver(tenant string, report DailyReport) {
+	if d.events != nil {
+		ev := SystemEvent{
+			Kind:    SystemReportDaily,
+			Tenant:  tenant,
+			Message: report.summary(),
+			Data: map[string]interface{}{
+				"date":                report.Date,
+				"largest_transfers":   len(report.LargestTransfers),
+				"sanctioned_exposure": len(report.SanctionedExposure),
+				"stuck_bridges":       len(report.StuckBridges),
+				"incidents":           len(report.Incidents),
+			},
+		}
+		// New sanctioned counterparties need someone to look at them
+		if len(report.SanctionedExposure) > 0 {
+			ev.Severity = SeverityWarning
+		}
+		if err := d.events.Emit(ev); err != nil {
+			log.WithError(err).WithField("tenant", tenant).Warn("could not announce daily report")
+		}
+	}
+	if d.mailer == nil {
+		return
+	}
+	for _, to := range d.recipients[tenant] {
+		if err := d.mailer.Send(to, "Daily report for "+report.Date, report.text()); err != nil {
+			log.WithError(err).WithFields(log.Fields{"tenant": tenant, "to": to}).Error("could not email daily report")
+		}
+	}
+}
+
+// getDailyReport handles GET /reports/daily/{date}, the caller's report
+// for a UTC day, compiled on request for days the job has not reported.
+func getDailyReport(reports *DailyReports, w http.ResponseWriter, r *http.Request) {
+	report, err := reports.Report(r.Context(), tenantFrom(r.Context()), chi.URLParam(r, "date"))
+	var fieldErr *FieldError
+	switch {
+	case errors.As(err, &fieldErr):
+		badRequest(w, err)
+		return
+	case errors.Is(err, errReportNotReady):
+		httpError(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		log.WithError(err).Error("could not compile daily report")
+		httpError(w, "could not compile the report", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
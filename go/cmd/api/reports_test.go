@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	sanctionedAddr    = "0x4444444444444444444444444444444444444444"
+	oldSanctionedAddr = "0x5555555555555555555555555555555555555555"
+)
+
+func TestDailyReports(t *testing.T) {
+	day := time.Date(2025, 10, 14, 0, 0, 0, 0, time.UTC)
+	store := NewEventStore(100, 50)
+	prices, _ := ParsePrices("USDC=1")
+	store.AttachPrices(prices)
+	add := func(id, tenant, to, usdc string, at time.Duration) {
+		ev := makeEvent(id, aliceAddr, to, usdc+"000000000000000000", day.Add(at).Format(time.RFC3339), "USDC")
+		ev.Tenant = tenant
+		store.Add(ev)
+	}
+	add("big", "acme", bobAddr, "5000", 9*time.Hour)
+	add("mid", "acme", bobAddr, "100", 10*time.Hour)
+	add("small", "acme", bobAddr, "1", 11*time.Hour)
+	add("sanctioned", "acme", sanctionedAddr, "10", 12*time.Hour)
+	add("sanctioned-again", "acme", sanctionedAddr, "10", 12*time.Hour+time.Minute)
+	add("known-before", "acme", oldSanctionedAddr, "10", -12*time.Hour)
+	add("known", "acme", oldSanctionedAddr, "10", 13*time.Hour)
+	add("other-tenant", "globex", bobAddr, "1000000", 14*time.Hour)
+	add("next-day", "acme", bobAddr, "900000", 25*time.Hour)
+	unpriced := makeEvent("unpriced", aliceAddr, bobAddr, "1", day.Add(15*time.Hour).Format(time.RFC3339), "XYZ")
+	unpriced.Tenant = "acme"
+	store.Add(unpriced)
+	burn := makeEvent("stuck-burn", aliceAddr, bobAddr, "1000000000000000000", day.Add(20*time.Hour).Format(time.RFC3339), "USDC")
+	burn.Tenant, burn.Bridge, burn.SourceChain, burn.DestChain, burn.Sequence = "acme", BridgeCCTP, "ethereum", "base", "9"
+	store.Add(burn)
+	if _, err := store.routes.Put(context.Background(), BridgeCCTP, "ethereum", "base", "usdc", BridgeRouteUpdate{ExpectedLatencySeconds: 900}); err != nil {
+		t.Fatal(err)
+	}
+
+	labels := NewLabelStore()
+	for _, l := range []Label{{Address: sanctionedAddr, Name: "Mixer", Category: LabelSanctioned}, {Address: oldSanctionedAddr, Name: "Old mixer", Category: LabelSanctioned}} {
+		if _, err := labels.Put(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tenants, err := ParseTenants("acme=k1,globex=k2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports := NewDailyReports(store, labels, tenants, 2)
+	reports.now = func() time.Time { return day.Add(24*time.Hour + 10*time.Minute) }
+	for _, ev := range []SystemEvent{
+		{Kind: SystemIndexerLag, Chain: "ethereum", Message: "ethereum is 40 blocks behind", At: day.Add(3 * time.Hour).Format(time.RFC3339)},
+		{Kind: SystemReorgDetected, Tenant: "globex", At: day.Add(4 * time.Hour).Format(time.RFC3339)},
+		{Kind: SystemBackfillCompleted, At: day.Add(5 * time.Hour).Format(time.RFC3339)},
+		{Kind: SystemIndexerGap, At: day.Add(-time.Hour).Format(time.RFC3339)},
+	} {
+		reports.Observe(ev)
+	}
+
+	hub := NewHub()
+	go hub.Run()
+	client := make(chan Frame, 4)
+	hub.register <- client
+	defer func() { hub.unregister <- client }()
+	events := NewSystemEvents(hub, NewHub(), nil)
+	var mails []string
+	mailer, _ := NewMailer("smtp://relay.example.com", "tracker@example.com")
+	mailer.send = func(_ string, _ smtp.Auth, _ string, to []string, msg []byte) error {
+		mails = append(mails, to[0]+"\n"+string(msg))
+		return nil
+	}
+	reports.AttachDelivery(events, mailer, map[string][]string{"acme": {"ops@acme.example"}})
+
+	// The job compiles the previous day's reports once
+	reports.generateDue(context.Background())
+	reports.generateDue(context.Background())
+	if len(mails) != 1 || !strings.HasPrefix(mails[0], "ops@acme.example\n") || !strings.Contains(mails[0], "Subject: Daily report for 2025-10-14") ||
+		!strings.Contains(mails[0], sanctionedAddr+" (Mixer), sent on ethereum in sanctioned") {
+		t.Fatalf("mails = %q", mails)
+	}
+	announced := map[string]SystemEvent{}
+	for i := 0; i < 2; i++ {
+		select {
+		case f := <-client:
+			var ev SystemEvent
+			if err := json.Unmarshal(f.Data, &ev); err != nil || ev.Kind != SystemReportDaily {
+				t.Fatalf("frame %s: %v", f.Data, err)
+			}
+			announced[ev.Tenant] = ev
+		case <-time.After(time.Second):
+			t.Fatal("daily reports not announced")
+		}
+	}
+	if ev := announced["acme"]; ev.Severity != SeverityWarning || ev.Data["date"] != "2025-10-14" || announced["globex"].Severity != SeverityInfo {
+		t.Fatalf("announcements = %+v", announced)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/reports/daily/{date}", func(w http.ResponseWriter, req *http.Request) {
+		getDailyReport(reports, w, req.WithContext(withTenant(req.Context(), req.Header.Get("X-Tenant"))))
+	})
+	get := func(date, tenant string) (*httptest.ResponseRecorder, DailyReport) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/reports/daily/"+date, nil)
+		req.Header.Set("X-Tenant", tenant)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		var report DailyReport
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		}
+		return rec, report
+	}
+
+	rec, report := get("2025-10-14", "acme")
+	if rec.Code != http.StatusOK || len(report.LargestTransfers) != 2 || report.LargestTransfers[0].Event.EventID != "big" ||
+		report.LargestTransfers[0].USD != "5000.00" || report.LargestTransfers[1].Event.EventID != "mid" {
+		t.Fatalf("largest transfers: %d %+v", rec.Code, report.LargestTransfers)
+	}
+	if e := report.SanctionedExposure; len(e) != 1 || e[0].EventID != "sanctioned" || e[0].Direction != "sent" || e[0].Name != "Mixer" {
+		t.Fatalf("sanctioned exposure = %+v", e)
+	}
+	if s := report.StuckBridges; len(s) != 1 || s[0].Transfer.EventID != "stuck-burn" || s[0].Status != TransferStuck {
+		t.Fatalf("stuck bridges = %+v", s)
+	}
+	if i := report.Incidents; len(i) != 1 || i[0].Kind != SystemIndexerLag {
+		t.Fatalf("incidents = %+v", i)
+	}
+	if _, globex := get("2025-10-14", "globex"); len(globex.LargestTransfers) != 1 || globex.LargestTransfers[0].Event.EventID != "other-tenant" ||
+		len(globex.SanctionedExposure) != 0 || len(globex.Incidents) != 2 {
+		t.Fatalf("globex report = %+v", globex)
+	}
+
+	// Earlier days are compiled on request; days that have not ended and
+	// invalid dates are not
+	if rec, earlier := get("2025-10-13", "acme"); rec.Code != http.StatusOK || len(earlier.LargestTransfers) != 1 || len(earlier.SanctionedExposure) != 1 {
+		t.Fatalf("earlier day: %d %+v", rec.Code, earlier)
+	}
+	if rec, _ := get("2025-10-15", "acme"); rec.Code != http.StatusNotFound {
+		t.Fatalf("today: %d", rec.Code)
+	}
+	if rec, _ := get("yesterday", "acme"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid date: %d", rec.Code)
+	}
+	if len(mails) != 1 {
+		t.Fatalf("reports compiled on request were emailed: %q", mails)
+	}
+
+	// Incidents are capped like the other lists
+	for i := 0; i < maxReportItems+50; i++ {
+		reports.Observe(SystemEvent{Kind: SystemIndexerGap, At: day.Add(-time.Duration(i+1) * time.Minute).Format(time.RFC3339)})
+	}
+	if busy := reports.compile("acme", day.Add(-24*time.Hour), reports.now()); len(busy.Incidents) != maxReportItems {
+		t.Fatalf("incidents of a busy day = %d, want %d", len(busy.Incidents), maxReportItems)
+	}
+}
+
+func TestParseReportRecipients(t *testing.T) {
+	recipients, err := ParseReportRecipients("acme=ops@acme.example, Acme=Risk <risk@acme.example>,me@example.com")
+	if err != nil || len(recipients["acme"]) != 2 || recipients["acme"][1] != "risk@acme.example" || recipients[""][0] != "me@example.com" {
+		t.Fatalf("recipients = %v, %v", recipients, err)
+	}
+	for _, spec := range []string{"acme=", "acme=not-an-email", "Bad Tenant=ops@acme.example"} {
+		if _, err := ParseReportRecipients(spec); err == nil {
+			t.Errorf("ParseReportRecipients(%q) accepted", spec)
+		}
+	}
+}
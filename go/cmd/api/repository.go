@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultPageSize is the page size used when a filter has no Limit.
+const defaultPageSize = 50
+
+// defaultPurgeBatchSize is the number of rows PurgeBefore deletes per
+// statement when no batch size is given.
+const defaultPurgeBatchSize = 1000
+
+// Storage backends selectable with STORAGE_BACKEND.
+const (
+	BackendMemory      = "memory"
+	BackendPostgres    = "postgres"
+	BackendSQLite      = "sqlite"
+	BackendTimescale   = "timescale"
+	BackendPartitioned = "partitioned"
+)
+
+// EventRepository is a storage backend for events. Implementations must be
+// safe for concurrent use and share these semantics:
+//
+//   - Insert is idempotent on event_id, and on dedupKey where the backend
+//     stores it.
+//   - List methods return events newest first, apply EventFilter predicates
+//     (orphaned events are excluded unless a status is requested), and
+//     paginate with Limit/Offset, where a zero Limit means defaultPageSize.
+//   - Addresses are matched case-insensitively.
+type EventRepository interface {
+	Insert(ctx context.Context, ev *Event) error
+	// InsertBatch stores events with Insert's semantics in as few round
+	// trips as the backend allows.
+	InsertBatch(ctx context.Context, events []*Event) error
+	ByWallet(ctx context.Context, address string, filter EventFilter) ([]*Event, error)
+	ByWallets(ctx context.Context, addresses []string, filter EventFilter) ([]*Event, error)
+	Recent(ctx context.Context, filter EventFilter) ([]*Event, error)
+	// Count returns how many events the list methods page through for
+	// addresses (every event when empty) and filter, ignoring Limit/Offset.
+	Count(ctx context.Context, addresses []string, filter EventFilter) (int, error)
+	// Sample draws up to size of the events Count counts, each with the
+	// same probability, in the listing's order, and returns them with an
+	// estimate of that count.
+	Sample(ctx context.Context, addresses []string, filter EventFilter, size int) ([]*Event, int, error)
+	// ByID returns the event with the given event_id, including orphaned ones.
+	ByID(ctx context.Context, eventID string) (*Event, bool, error)
+	// ByTxHash returns every event of a transaction. hash is canonical (see
+	// normalizeTxHash); rows stored in other casings must still match.
+	ByTxHash(ctx context.Context, hash, chain string) ([]*Event, error)
+	ApplyConfirmation(ctx context.Context, u ConfirmationUpdate) ([]StatusChange, error)
+	// UpdateMetadata stores what mergeDuplicate filled in on ev, the copy
+	// of a stored event with ev's event_id. Unknown IDs are ignored.
+	UpdateMetadata(ctx context.Context, ev *Event) error
+	VolumeSeries(ctx context.Context, q AnalyticsQuery) ([]VolumePoint, error)
+	ActiveWalletsSeries(ctx context.Context, q AnalyticsQuery) ([]ActiveWalletsPoint, error)
+	// WalletStats totals what address sent, received and paid in fees over
+	// every event matching filter, ignoring Limit/Offset.
+	WalletStats(ctx context.Context, address string, filter EventFilter) (WalletStats, error)
+	// PurgeBefore deletes the events stored before cutoff, at most batchSize
+	// per statement so a large purge does not hold long locks, and returns
+	// how many were deleted.
+	PurgeBefore(ctx context.Context, cutoff time.Time, batchSize int) (int, error)
+	Close()
+}
+
+// RepositoryConfig selects and configures the storage backend.
+type RepositoryConfig struct {
+	Backend     string
+	PostgresDSN string
+	SQLitePath  string
+	// Timescale hypertable chunk size and retention, as Postgres intervals.
+	// An empty retention keeps events forever.
+	TimescaleChunkInterval string
+	TimescaleRetention     string
+}
+
+// RepositoryConfigFromEnv reads STORAGE_BACKEND, POSTGRES_DSN, SQLITE_PATH,
+// TIMESCALE_CHUNK_INTERVAL and TIMESCALE_RETENTION. Without STORAGE_BACKEND,
+// Postgres is used when POSTGRES_DSN is set and the in-memory store otherwise.
+func RepositoryConfigFromEnv() RepositoryConfig {
+	return repositoryConfigFromEnv("")
+}
+
+// repositoryConfigFromEnv reads the same variables prefixed with prefix,
+// e.g. DIFF_STORAGE_BACKEND for the secondary backend of result diffs.
+func repositoryConfigFromEnv(prefix string) RepositoryConfig {
+	cfg := RepositoryConfig{
+		Backend:                strings.ToLower(os.Getenv(prefix + "STORAGE_BACKEND")),
+		PostgresDSN:            os.Getenv(prefix + "POSTGRES_DSN"),
+		SQLitePath:             os.Getenv(prefix + "SQLITE_PATH"),
+		TimescaleChunkInterval: os.Getenv(prefix + "TIMESCALE_CHUNK_INTERVAL"),
+		TimescaleRetention:     os.Getenv(prefix + "TIMESCALE_RETENTION"),
+	}
+	if cfg.Backend == "" {
+		cfg.Backend = BackendMemory
+		if cfg.PostgresDSN != "" {
+			cfg.Backend = BackendPostgres
+		}
+	}
+	if cfg.SQLitePath == "" {
+		cfg.SQLitePath = "tracker.db"
+	}
+	if cfg.TimescaleChunkInterval == "" {
+		cfg.TimescaleChunkInterval = "1 day"
+	}
+	return cfg
+}
+
+// OpenRepository connects to the configured backend and prepares its schema.
+// It returns a nil repository for the memory backend: the store's in-memory
+// cache is then the only storage.
+func OpenRepository(ctx context.Context, cfg RepositoryConfig) (EventRepository, error) {
+	switch cfg.Backend {
+	case BackendMemory:
+		return nil, nil
+	case BackendPostgres:
+		if cfg.PostgresDSN == "" {
+			return nil, fmt.Errorf("POSTGRES_DSN must be set for the postgres backend")
+		}
+		return OpenPostgresRepository(ctx, cfg.PostgresDSN)
+	case BackendSQLite:
+		return OpenSQLiteRepository(ctx, cfg.SQLitePath)
+	case BackendTimescale:
+		if cfg.PostgresDSN == "" {
+			return nil, fmt.Errorf("POSTGRES_DSN must be set for the timescale backend")
+		}
+		return OpenTimescaleRepository(ctx, cfg.PostgresDSN, cfg.TimescaleChunkInterval, cfg.TimescaleRetention)
+	case BackendPartitioned:
+		if cfg.PostgresDSN == "" {
+			return nil, fmt.Errorf("POSTGRES_DSN must be set for the partitioned backend")
+		}
+		return OpenPartitionedRepository(ctx, cfg.PostgresDSN)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+}
+
+// OpenReadOnlyRepository connects to the configured backend for reading
+// only, such as a second backend results are compared against. Unlike
+// OpenRepository it never creates, migrates or converts the schema, which
+// must already be at this build's migration version, and Postgres sessions
+// are read-only. It returns a nil repository for the memory backend.
+func OpenReadOnlyRepository(ctx context.Context, cfg RepositoryConfig) (EventRepository, error) {
+	if cfg.Backend == BackendMemory {
+		return nil, nil
+	}
+	schema, ok := doctorSchemas[cfg.Backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+	var applied map[int]bool
+	var err error
+	if cfg.Backend == BackendSQLite {
+		if _, statErr := os.Stat(cfg.SQLitePath); statErr != nil {
+			return nil, statErr
+		}
+		applied, _, err = sqliteSchema(ctx, cfg.SQLitePath, schema.table)
+	} else {
+		if cfg.PostgresDSN == "" {
+			return nil, fmt.Errorf("POSTGRES_DSN must be set for the %s backend", cfg.Backend)
+		}
+		applied, _, err = postgresSchema(ctx, cfg.PostgresDSN, schema.table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := currentSchema(schema, applied); err != nil {
+		return nil, err
+	}
+
+	if cfg.Backend == BackendSQLite {
+		return OpenSQLiteReadOnly(cfg.SQLitePath)
+	}
+	db, err := openReadOnlyPool(ctx, cfg.PostgresDSN)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Backend {
+	case BackendTimescale:
+		return &TimescaleRepository{&PostgresRepository{db: db}}, nil
+	case BackendPartitioned:
+		return &PartitionedRepository{PostgresRepository: &PostgresRepository{db: db}, months: make(map[string]bool)}, nil
+	}
+	return &PostgresRepository{db: db}, nil
+}
+
+// currentSchema fails unless applied holds exactly the migrations of
+// schema, so a read-only backend is queried with the columns and indexes
+// this build expects.
+func currentSchema(schema doctorSchema, applied map[int]bool) error {
+	latest, version := len(schema.migrations), 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	if len(applied) != latest || version != latest {
+		return fmt.Errorf("schema is at version %d, this build needs %d; migrate it by running the API against it first", version, latest)
+	}
+	return nil
+}
+
+// paginate applies Limit/Offset to an already filtered, ordered list.
+func paginate(events []*Event, filter EventFilter) []*Event {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if filter.Offset >= len(events) {
+		return []*Event{}
+	}
+	end := filter.Offset + limit
+	if end > len(events) {
+		end = len(events)
+	}
+	return events[filter.Offset:end]
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRepository runs the shared EventRepository contract against repo.
+func testRepository(t *testing.T, repo EventRepository) {
+	ctx := context.Background()
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	at := func(m int) string { return base.Add(time.Duration(m) * time.Minute).Format(time.RFC3339) }
+
+	evmHash := "0x" + strings.Repeat("AB", 32)
+	evm := makeEvent("1", "0xAlice", "bob", "100", at(1), "")
+	evm.Chain, evm.Network, evm.TxHash = "ethereum", "sepolia", evmHash
+	height := uint64(10)
+	evm.BlockNumber = &height
+	evm.ExecutedBy, evm.Multisig = "0xowner", "gnosis_safe"
+	gasUsed := uint64(21000)
+	evm.EventType, evm.Fee, evm.GasUsed, evm.PriorityFee = "native_transfer", "420000", &gasUsed, "21000"
+	evm.Bridge, evm.SourceChain, evm.DestChain, evm.Sequence = BridgeCCTP, "ethereum", "solana", "77"
+	evm.Source = "indexer-ethereum@v0.1.0"
+	vault := makeEvent("2", "bob", "carol", "5", at(2), "USDC")
+	vault.Authority = &Authority{Address: "bob", Program: "program"}
+	vault.Tenant, vault.SharedWith = "treasury", []string{"ops"}
+	vault.Annotations = map[string]json.RawMessage{"risk": json.RawMessage(`0.9`)}
+	vault.Source = "import:helius"
+	events := []*Event{
+		evm,
+		vault,
+		makeEvent("3", "carol", "0xalice", "7", at(3), ""),
+	}
+	for _, ev := range events {
+		if err := repo.Insert(ctx, ev); err != nil {
+			t.Fatalf("insert %s: %v", ev.EventID, err)
+		}
+	}
+	if err := repo.Insert(ctx, makeEvent("2", "bob", "carol", "5", at(2), "USDC")); err != nil {
+		t.Fatalf("duplicate insert: %v", err)
+	}
+
+	ids := func(events []*Event) string {
+		out := make([]string, len(events))
+		for i, ev := range events {
+			out[i] = ev.EventID
+		}
+		return strings.Join(out, ",")
+	}
+
+	recent, err := repo.Recent(ctx, EventFilter{})
+	if err != nil || ids(recent) != "3,2,1" {
+		t.Fatalf("recent = %s, %v; want 3,2,1 (newest first, no duplicates)", ids(recent), err)
+	}
+	if got, _ := repo.Recent(ctx, EventFilter{Limit: 1, Offset: 1}); ids(got) != "2" {
+		t.Fatalf("paged recent = %s, want 2", ids(got))
+	}
+	if got, _ := repo.Recent(ctx, EventFilter{Token: "USDC"}); ids(got) != "2" {
+		t.Fatalf("token filter = %s, want 2", ids(got))
+	}
+	if got, _ := repo.ByWallet(ctx, "bob", EventFilter{Tenant: "treasury"}); ids(got) != "2" || got[0].Tenant != "treasury" || string(got[0].Annotations["risk"]) != "0.9" {
+		t.Fatalf("tenant filter = %s, want 2", ids(got))
+	}
+	if got, _ := repo.Recent(ctx, EventFilter{Bridge: BridgeCCTP, Sequence: "77"}); ids(got) != "1" || got[0].DestChain != "solana" {
+		t.Fatalf("bridge filter = %s, want 1", ids(got))
+	}
+	if got, _ := repo.Recent(ctx, EventFilter{Source: "import:helius"}); ids(got) != "2" || got[0].Source != "import:helius" {
+		t.Fatalf("source filter = %s, want 2", ids(got))
+	}
+	// A trailing * matches by prefix, ignoring case; _ is not a wildcard
+	if got, _ := repo.Recent(ctx, EventFilter{Source: "INDEXER-*"}); ids(got) != "1" {
+		t.Fatalf("source prefix filter = %s, want 1", ids(got))
+	}
+	if got, _ := repo.Recent(ctx, EventFilter{Source: "indexer_*"}); len(got) != 0 {
+		t.Fatalf("source prefix filter with _ = %s, want none", ids(got))
+	}
+	// 7 lamports is 7e-9 SOL; 100 wei and 5 base units of an 18-decimal
+	// token are smaller
+	if got, _ := repo.Recent(ctx, EventFilter{MinValue: big.NewRat(6, 1e9)}); ids(got) != "3" {
+		t.Fatalf("min_value filter = %s, want 3", ids(got))
+	}
+	if got, _ := repo.Recent(ctx, EventFilter{MaxValue: big.NewRat(6, 1e9)}); ids(got) != "2,1" {
+		t.Fatalf("max_value filter = %s, want 2,1", ids(got))
+	}
+	if got, _ := repo.Recent(ctx, EventFilter{Tokens: []string{"DAI", "USDC"}}); ids(got) != "2" {
+		t.Fatalf("tokens filter = %s, want 2", ids(got))
+	}
+	if got, _ := repo.Recent(ctx, EventFilter{SortBy: SortValue, SortOrder: "asc"}); ids(got) != "2,1,3" {
+		t.Fatalf("sorted by value = %s, want 2,1,3 (by whole units)", ids(got))
+	}
+	if got, _ := repo.Recent(ctx, EventFilter{SortBy: SortTimestamp, SortOrder: "asc", Limit: 2}); ids(got) != "1,2" {
+		t.Fatalf("sorted by timestamp = %s, want 1,2", ids(got))
+	}
+	if got, _ := repo.Recent(ctx, EventFilter{SortBy: SortBlockNumber}); !strings.HasPrefix(ids(got), "1,") {
+		t.Fatalf("sorted by block number = %s, want 1 first (the others have none)", ids(got))
+	}
+	// Time bounds are inclusive
+	second := base.Add(2 * time.Minute)
+	if got, _ := repo.Recent(ctx, EventFilter{StartTime: &second}); ids(got) != "3,2" {
+		t.Fatalf("start_time filter = %s, want 3,2", ids(got))
+	}
+	if got, _ := repo.Recent(ctx, EventFilter{EndTime: &second}); ids(got) != "2,1" {
+		t.Fatalf("end_time filter = %s, want 2,1", ids(got))
+	}
+	if n, _ := repo.Count(ctx, []string{"bob"}, EventFilter{StartTime: &second, EndTime: &second}); n != 1 {
+		t.Fatalf("count within time range = %d, want 1", n)
+	}
+	if n, err := repo.Count(ctx, nil, EventFilter{Tenant: "ops"}); err != nil || n != 1 {
+		t.Fatalf("count for the tenant it is shared with = %d, %v; want 1", n, err)
+	}
+	if got, _ := repo.ByWallet(ctx, "carol", EventFilter{Tenant: "ops"}); ids(got) != "2" || len(got[0].SharedWith) != 1 || got[0].SharedWith[0] != "ops" {
+		t.Fatalf("shared event = %s, want 2 shared with ops", ids(got))
+	}
+	if n, err := repo.Count(ctx, nil, EventFilter{Tenant: "audit"}); err != nil || n != 0 {
+		t.Fatalf("count for another tenant = %d, %v; want 0", n, err)
+	}
+
+	byWallet, err := repo.ByWallet(ctx, "0XALICE", EventFilter{})
+	if err != nil || ids(byWallet) != "3,1" {
+		t.Fatalf("by wallet = %s, %v; want 3,1 (case-insensitive)", ids(byWallet), err)
+	}
+	byWallets, err := repo.ByWallets(ctx, []string{"0xalice", "bob"}, EventFilter{Chain: "solana"})
+	if err != nil || ids(byWallets) != "3,2" {
+		t.Fatalf("by wallets = %s, %v; want 3,2", ids(byWallets), err)
+	}
+
+	if n, err := repo.Count(ctx, nil, EventFilter{Limit: 1}); err != nil || n != 3 {
+		t.Fatalf("count = %d, %v; want 3", n, err)
+	}
+	if n, err := repo.Count(ctx, []string{"0XALICE", "carol"}, EventFilter{Chain: "solana"}); err != nil || n != 2 {
+		t.Fatalf("count by wallets = %d, %v; want 2", n, err)
+	}
+
+	if ev, ok, err := repo.ByID(ctx, "2"); err != nil || !ok || ev.Token == nil || ev.Token.Symbol != "USDC" {
+		t.Fatalf("by id = %+v, %v, %v", ev, ok, err)
+	}
+	if _, ok, err := repo.ByID(ctx, "missing"); err != nil || ok {
+		t.Fatalf("missing id: ok=%v err=%v", ok, err)
+	}
+	byHash, err := repo.ByTxHash(ctx, strings.ToLower(evmHash), "")
+	if err != nil || ids(byHash) != "1" {
+		t.Fatalf("by tx hash = %s, %v; want 1", ids(byHash), err)
+	}
+	if ev := byHash[0]; ev.ExecutedBy != "0xowner" || ev.Multisig != "gnosis_safe" {
+		t.Fatalf("multisig attribution not stored: %+v", ev)
+	}
+	if ev := byHash[0]; ev.Fee != "420000" || ev.GasUsed == nil || *ev.GasUsed != 21000 || ev.PriorityFee != "21000" {
+		t.Fatalf("fee not stored: %+v", ev)
+	}
+	// The Safe's transfer was paid for by its owner
+	stats, err := repo.WalletStats(ctx, "0XALICE", EventFilter{Limit: 1})
+	if err != nil || len(stats.Assets) != 2 || stats.Assets[0].Sent != "100" || stats.Assets[0].Fees != "" || stats.Assets[1].Received != "7" {
+		t.Fatalf("wallet stats = %+v, %v", stats, err)
+	}
+	if ev, ok, err := repo.ByID(ctx, "2"); err != nil || !ok || ev.Authority == nil || *ev.Authority != *vault.Authority {
+		t.Fatalf("authority not stored: %+v, %v", ev, err)
+	}
+
+	changes, err := repo.ApplyConfirmation(ctx, ConfirmationUpdate{Chain: "ethereum", Status: StatusOrphaned, BlockNumber: &height})
+	if err != nil || len(changes) != 1 || changes[0].EventID != "1" || changes[0].PreviousStatus != StatusConfirmed {
+		t.Fatalf("apply confirmation = %+v, %v", changes, err)
+	}
+	if got, _ := repo.ByWallet(ctx, "0xalice", EventFilter{}); ids(got) != "3" {
+		t.Fatalf("orphaned event still listed: %s", ids(got))
+	}
+	if ev, ok, _ := repo.ByID(ctx, "1"); !ok || ev.Status != StatusOrphaned {
+		t.Fatalf("orphaned event by id = %+v, %v", ev, ok)
+	}
+
+	q := AnalyticsQuery{Interval: "1h", Start: base, End: base.Add(time.Hour)}
+	volume, err := repo.VolumeSeries(ctx, q)
+	if err != nil || len(volume) != 2 || volume[1].Token != nativeToken || volume[1].Volume != "7" {
+		t.Fatalf("volume = %+v, %v", volume, err)
+	}
+	active, err := repo.ActiveWalletsSeries(ctx, q)
+	if err != nil || len(active) != 1 || active[0].Wallets != 3 {
+		t.Fatalf("active wallets = %+v, %v; want 3 (bob, carol, 0xalice)", active, err)
+	}
+	q.Tenant = "treasury"
+	if volume, err := repo.VolumeSeries(ctx, q); err != nil || len(volume) != 1 || volume[0].Token != "USDC" {
+		t.Fatalf("tenant volume = %+v, %v", volume, err)
+	}
+
+	batch := []*Event{makeEvent("3", "carol", "0xalice", "7", at(3), ""), makeEvent("4", "dave", "bob", "1", at(4), ""), makeEvent("5", wrappedSOL, "bob", "2", at(5), "")}
+	if err := repo.InsertBatch(ctx, batch); err != nil {
+		t.Fatalf("insert batch: %v", err)
+	}
+	if got, _ := repo.ByWallet(ctx, "bob", EventFilter{}); ids(got) != "5,4,2" {
+		t.Fatalf("after batch = %s, want 5,4,2 (existing event skipped)", ids(got))
+	}
+	// Solana keys are stored and matched as they are
+	if got, _ := repo.ByWallet(ctx, wrappedSOL, EventFilter{From: wrappedSOL}); ids(got) != "5" || got[0].From != wrappedSOL {
+		t.Fatalf("by solana wallet = %s, want 5", ids(got))
+	}
+
+	// Every event was stored and timestamped after base and before now
+	if n, err := repo.PurgeBefore(ctx, base, 2); err != nil || n != 0 {
+		t.Fatalf("purge before base = %d, %v; want 0", n, err)
+	}
+	if n, err := repo.PurgeBefore(ctx, time.Now().Add(time.Minute), 2); err != nil || n != 5 {
+		t.Fatalf("purge = %d, %v; want 5 in batches of 2", n, err)
+	}
+	if _, ok, _ := repo.ByID(ctx, "1"); ok {
+		t.Fatal("orphaned event survived the purge")
+	}
+}
+
+func TestMemoryRepository(t *testing.T) {
+	testRepository(t, NewMemoryRepository(100, 50))
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	repo, err := OpenSQLiteRepository(context.Background(), filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer repo.Close()
+	testRepository(t, repo)
+}
+
+func TestRepositoriesKeepFinalizedEventsOnReorg(t *testing.T) {
+	ctx := context.Background()
+	sqlite, err := OpenSQLiteRepository(ctx, ":memory:")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer sqlite.Close()
+	for name, repo := range map[string]EventRepository{"memory": NewMemoryRepository(100, 50), "sqlite": sqlite} {
+		finalized := makeBlockEvent("a", 10)
+		finalized.Status = StatusFinalized
+		if err := repo.InsertBatch(ctx, []*Event{finalized, makeBlockEvent("b", 11)}); err != nil {
+			t.Fatalf("%s: insert: %v", name, err)
+		}
+		height := uint64(10)
+		changes, err := repo.ApplyConfirmation(ctx, ConfirmationUpdate{Chain: "ethereum", Status: StatusOrphaned, BlockNumber: &height})
+		if err != nil || len(changes) != 1 || changes[0].EventID != "b" {
+			t.Fatalf("%s: reorg changes = %+v, %v; want only b", name, changes, err)
+		}
+		if ev, ok, _ := repo.ByID(ctx, "a"); !ok || ev.Status != StatusFinalized {
+			t.Fatalf("%s: finalized event = %+v, want it kept", name, ev)
+		}
+	}
+}
+
+func TestSQLiteRepositoryPurgesByReceivedTime(t *testing.T) {
+	ctx := context.Background()
+	repo, err := OpenSQLiteRepository(ctx, ":memory:")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer repo.Close()
+	ts := time.Now().UTC().Format(time.RFC3339)
+	_ = repo.InsertBatch(ctx, []*Event{makeEvent("old", "a", "b", "1", ts, ""), makeEvent("new", "a", "b", "1", ts, "")})
+	if _, err := repo.db.ExecContext(ctx, `UPDATE events SET created_at = '2020-01-01T00:00:00.000Z' WHERE event_id = 'old'`); err != nil {
+		t.Fatalf("backdate: %v", err)
+	}
+
+	if n, err := repo.PurgeBefore(ctx, time.Now().AddDate(0, 0, -30), 0); err != nil || n != 1 {
+		t.Fatalf("purge = %d, %v; want 1", n, err)
+	}
+	if _, ok, _ := repo.ByID(ctx, "new"); !ok {
+		t.Fatal("recent event purged")
+	}
+}
+
+func TestSQLiteRepositoryAddsColumns(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.db")
+	repo, err := OpenSQLiteRepository(ctx, path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	// Recreate the table as a version without multisig columns left it
+	if _, err := repo.db.ExecContext(ctx, `ALTER TABLE events DROP COLUMN executed_by; ALTER TABLE events DROP COLUMN multisig`); err != nil {
+		t.Fatalf("drop columns: %v", err)
+	}
+	repo.Close()
+
+	repo, err = OpenSQLiteRepository(ctx, path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer repo.Close()
+	ev := makeEvent("1", "safe", "bob", "1", time.Now().UTC().Format(time.RFC3339), "")
+	ev.ExecutedBy, ev.Multisig = "owner", "squads"
+	ev.Authority = &Authority{Address: "vault", Program: "program"}
+	if err := repo.Insert(ctx, ev); err != nil {
+		t.Fatalf("insert after upgrade: %v", err)
+	}
+	if got, ok, err := repo.ByID(ctx, "1"); err != nil || !ok || got.ExecutedBy != "owner" || got.Authority == nil {
+		t.Fatalf("by id = %+v, %v, %v", got, ok, err)
+	}
+}
+
+func TestOpenReadOnlyRepository(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.db")
+	repo, err := OpenSQLiteRepository(ctx, path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := repo.Insert(ctx, makeEvent("1", "a", "b", "1", time.Now().UTC().Format(time.RFC3339), "")); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	repo.Close()
+
+	cfg := RepositoryConfig{Backend: BackendSQLite, SQLitePath: path}
+	ro, err := OpenReadOnlyRepository(ctx, cfg)
+	if err != nil {
+		t.Fatalf("open read-only: %v", err)
+	}
+	if _, ok, err := ro.ByID(ctx, "1"); err != nil || !ok {
+		t.Fatalf("read-only by id = %v, %v", ok, err)
+	}
+	if err := ro.Insert(ctx, makeEvent("2", "a", "b", "1", time.Now().UTC().Format(time.RFC3339), "")); err == nil {
+		t.Fatal("insert into a read-only repository succeeded")
+	}
+	ro.Close()
+
+	// A database behind this build is refused, not migrated
+	repo, err = OpenSQLiteRepository(ctx, path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if _, err := repo.db.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, len(sqliteMigrations)); err != nil {
+		t.Fatalf("forget migration: %v", err)
+	}
+	repo.Close()
+	if _, err := OpenReadOnlyRepository(ctx, cfg); err == nil || !strings.Contains(err.Error(), "schema is at version") {
+		t.Fatalf("open read-only behind this build = %v", err)
+	}
+	if applied, _, err := sqliteSchema(ctx, path, "schema_migrations"); err != nil || applied[len(sqliteMigrations)] {
+		t.Fatalf("read-only open migrated the database: %v, %v", applied, err)
+	}
+	if _, err := OpenReadOnlyRepository(ctx, RepositoryConfig{Backend: BackendSQLite, SQLitePath: filepath.Join(t.TempDir(), "missing.db")}); err == nil {
+		t.Fatal("opened a database that does not exist")
+	}
+}
+
+func TestSQLiteRepositoryComparesAmountsExactly(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.db")
+	repo, err := OpenSQLiteRepository(ctx, path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	// The two values differ beyond a double's 17 significant digits
+	ts := time.Now().UTC().Format(time.RFC3339)
+	low := makeEvent("low", "a", "b", "1000000000000000000000000", ts, "")
+	high := makeEvent("high", "a", "b", "1000000000000000000000001", ts, "")
+	low.Chain, high.Chain = "ethereum", "ethereum"
+	if err := repo.InsertBatch(ctx, []*Event{high, low}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	// Leave low as a version that only wrote the amount column would have
+	if _, err := repo.db.ExecContext(ctx, `UPDATE events SET amount = 1000000, amount_key = NULL WHERE event_id = 'low'`); err != nil {
+		t.Fatalf("downgrade: %v", err)
+	}
+	repo.Close()
+
+	repo, err = OpenSQLiteRepository(ctx, path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer repo.Close()
+	bound, _ := new(big.Rat).SetString("1000000.000000000000000001")
+	if got, err := repo.Recent(ctx, EventFilter{MinValue: bound}); err != nil || len(got) != 1 || got[0].EventID != "high" {
+		t.Fatalf("min_value = %v, %v; want only high", got, err)
+	}
+	if got, err := repo.Recent(ctx, EventFilter{MaxValue: big.NewRat(1000000, 1)}); err != nil || len(got) != 1 || got[0].EventID != "low" {
+		t.Fatalf("max_value = %v, %v; want only low", got, err)
+	}
+	if got, _ := repo.Recent(ctx, EventFilter{SortBy: SortValue, SortOrder: "asc"}); len(got) != 2 || got[0].EventID != "low" {
+		t.Fatalf("sorted by value = %v, want low first", got)
+	}
+}
+
+func TestMemoryRepositoryForgetsTrimmedEvents(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository(1, 1)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	_ = repo.Insert(ctx, makeEvent("1", "alice", "bob", "1", ts, ""))
+	_ = repo.Insert(ctx, makeEvent("2", "alice", "bob", "1", ts, ""))
+	_ = repo.Insert(ctx, makeEvent("1", "alice", "bob", "1", ts, ""))
+
+	// Once trimmed everywhere, an event id can be stored again
+	if got, _ := repo.Recent(ctx, EventFilter{}); len(got) != 1 || got[0].EventID != "1" {
+		t.Fatalf("recent = %+v, want re-inserted event 1", got)
+	}
+}
+
+func TestMemoryRepositoryResizeAndRestore(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository(10, 10)
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i := 1; i <= 4; i++ {
+		_ = repo.Insert(ctx, makeEvent(strconv.Itoa(i), "alice", "bob", "1", base.Add(time.Duration(i)*time.Minute).Format(time.RFC3339), ""))
+	}
+	_ = repo.Insert(ctx, makeEvent("5", "carol", "dave", "1", base.Format(time.RFC3339), ""))
+
+	if dropped := repo.Resize(3, 2); dropped != 2 {
+		t.Fatalf("resize dropped %d, want 2 (events 1 and 2)", dropped)
+	}
+	stats := repo.Stats()
+	if stats.Bytes <= 0 {
+		t.Fatalf("stats = %+v, want the size of the held events", stats)
+	}
+	if stats.Bytes = 0; stats != (CacheStats{Events: 3, Wallets: 4, MaxEvents: 3, MaxEventsPerWallet: 2}) {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	dump := repo.Dump()
+	if len(dump) != 3 || dump[0].EventID != "4" || dump[2].EventID != "5" {
+		t.Fatalf("dump = %+v, want 4,3,5 (newest first)", dump)
+	}
+	restored := NewMemoryRepository(10, 10)
+	_ = restored.Insert(ctx, makeEvent("stale", "x", "y", "1", base.Format(time.RFC3339), ""))
+	restored.Restore(dump)
+	if got, _ := restored.Recent(ctx, EventFilter{}); len(got) != 3 || got[0].EventID != "4" {
+		t.Fatalf("restored recent = %+v", got)
+	}
+	if _, ok, _ := restored.ByID(ctx, "stale"); ok {
+		t.Fatal("restore kept the previous contents")
+	}
+}
+
+func TestMemoryRepositoryExpiresEventsAndKeepsToBudget(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository(100, 100)
+	now := time.Date(2025, 9, 15, 12, 0, 0, 0, time.UTC)
+	repo.now = func() time.Time { return now }
+	repo.SetBudget(time.Hour, 0)
+	ts := now.Format(time.RFC3339)
+	_ = repo.Insert(ctx, makeEvent("old", "alice", "bob", "1", ts, ""))
+	now = now.Add(40 * time.Minute)
+	_ = repo.Insert(ctx, makeEvent("new", "alice", "carol", "1", ts, ""))
+
+	// The TTL counts from when the cache received an event, not its timestamp
+	now = now.Add(30 * time.Minute)
+	if n := repo.Expire(); n != 1 {
+		t.Fatalf("expired %d, want 1", n)
+	}
+	if _, ok, _ := repo.ByID(ctx, "old"); ok {
+		t.Fatal("expired event still held")
+	}
+	if got, _ := repo.ByWallet(ctx, "bob", EventFilter{}); len(got) != 0 {
+		t.Fatalf("bob's history = %+v after expiry", got)
+	}
+	held, _, _ := repo.ByID(ctx, "new")
+	if stats := repo.Stats(); stats.Events != 1 || stats.Bytes != eventSize(held) || stats.TTL != "1h0m0s" {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	// Over the budget, the longest-held events go first
+	size := repo.Stats().Bytes
+	repo.SetBudget(0, 3*size)
+	for i := 0; i < 3; i++ {
+		now = now.Add(time.Minute)
+		_ = repo.Insert(ctx, makeEvent(strconv.Itoa(i), "dave", "erin", "1", ts, ""))
+	}
+	if stats := repo.Stats(); stats.Events != 2 || stats.Bytes > stats.MaxBytes {
+		t.Fatalf("stats = %+v, want 2 events within %d bytes", stats, 3*size)
+	}
+	if _, ok, _ := repo.ByID(ctx, "new"); ok {
+		t.Fatal("the longest-held event was kept over the budget")
+	}
+
+	var out strings.Builder
+	repo.WriteMetrics(&out)
+	for _, want := range []string{"tracker_cache_events 2\n", `tracker_cache_evictions_total{reason="ttl"} 1`, `tracker_cache_evictions_total{reason="memory"} 2`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRepositoryConfigFromEnv(t *testing.T) {
+	t.Setenv("STORAGE_BACKEND", "")
+	t.Setenv("POSTGRES_DSN", "")
+	t.Setenv("SQLITE_PATH", "")
+	if cfg := RepositoryConfigFromEnv(); cfg.Backend != BackendMemory || cfg.SQLitePath != "tracker.db" {
+		t.Fatalf("defaults = %+v", cfg)
+	}
+	t.Setenv("POSTGRES_DSN", "postgres://localhost/tracker")
+	if cfg := RepositoryConfigFromEnv(); cfg.Backend != BackendPostgres {
+		t.Fatalf("backend with dsn = %q, want postgres", cfg.Backend)
+	}
+	t.Setenv("STORAGE_BACKEND", "SQLite")
+	if cfg := RepositoryConfigFromEnv(); cfg.Backend != BackendSQLite {
+		t.Fatalf("explicit backend = %q, want sqlite", cfg.Backend)
+	}
+	t.Setenv("STORAGE_BACKEND", "timescale")
+	t.Setenv("TIMESCALE_RETENTION", "90 days")
+	if cfg := RepositoryConfigFromEnv(); cfg.Backend != BackendTimescale || cfg.TimescaleChunkInterval != "1 day" || cfg.TimescaleRetention != "90 days" {
+		t.Fatalf("timescale config = %+v", cfg)
+	}
+	if _, err := OpenRepository(context.Background(), RepositoryConfig{Backend: BackendTimescale}); err == nil {
+		t.Fatal("expected error for timescale without POSTGRES_DSN")
+	}
+	if _, err := OpenRepository(context.Background(), RepositoryConfig{Backend: BackendPartitioned}); err == nil {
+		t.Fatal("expected error for partitioned without POSTGRES_DSN")
+	}
+	if _, err := OpenRepository(context.Background(), RepositoryConfig{Backend: "mongo"}); err == nil {
+		t.Fatal("expected error for unknown backend")
+	}
+}
+
+func TestPartitionNames(t *testing.T) {
+	month := monthStart(time.Date(2025, 10, 31, 23, 59, 0, 0, time.FixedZone("UTC-5", -5*3600)))
+	if month != time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC) {
+		t.Fatalf("month = %v", month)
+	}
+	if name := partitionName(month); name != "events_2025_11" {
+		t.Fatalf("name = %q", name)
+	}
+	if got, ok := partitionMonth("events_2025_11"); !ok || got != month {
+		t.Fatalf("month of events_2025_11 = %v, %v", got, ok)
+	}
+	for _, name := range []string{"events_unpartitioned", "labels", "events_2025_13"} {
+		if _, ok := partitionMonth(name); ok {
+			t.Errorf("%q parsed as a partition", name)
+		}
+	}
+}
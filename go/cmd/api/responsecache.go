@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	responseCachePrefix = "respcache:"
+	// responseCacheTimeout bounds each Redis call, so a slow Redis degrades
+	// to uncached reads instead of slow ones.
+	responseCacheTimeout = 200 * time.Millisecond
+)
+
+// responseCacheBackend stores cached responses and the generation of each
+// invalidation scope. Keys embed the generations they were computed under,
+// so bumping a generation orphans its entries until they expire.
+// redisResponseCacheBackend implements it on Redis.
+type responseCacheBackend interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Generations returns the generation of each scope, 0 for a scope
+	// that was never bumped or whose generation expired.
+	Generations(ctx context.Context, scopes ...string) ([]int64, error)
+	// Bump moves scopes to a new, never used generation that lasts ttl.
+	Bump(ctx context.Context, ttl time.Duration, scopes ...string) error
+}
+
+type redisResponseCacheBackend struct{ rdb *redis.Client }
+
+func (b redisResponseCacheBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	return b.rdb.Get(ctx, key).Bytes()
+}
+
+func (b redisResponseCacheBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return b.rdb.Set(ctx, key, value, ttl).Err()
+}
+
+func (b redisResponseCacheBackend) Generations(ctx context.Context, scopes ...string) ([]int64, error) {
+	keys := make([]string, len(scopes))
+	for i, scope := range scopes {
+		keys[i] = responseCachePrefix + "gen:" + scope
+	}
+	values, err := b.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	gens := make([]int64, len(values))
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			if gens[i], err = strconv.ParseInt(s, 10, 64); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return gens, nil
+}
+
+// Bump draws generations from one counter, so a scope whose generation
+// expired never returns to a number its old entries were stored under.
+// Generations last as long as entries, so an entry cached under 0 after
+// an expiry is newer than every bump before it.
+func (b redisResponseCacheBackend) Bump(ctx context.Context, ttl time.Duration, scopes ...string) error {
+	gen, err := b.rdb.Incr(ctx, responseCachePrefix+"generation").Result()
+	if err != nil {
+		return err
+	}
+	_, err = b.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, scope := range scopes {
+			p.Set(ctx, responseCachePrefix+"gen:"+scope, gen, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// ResponseCache is a read-through cache of event listings, keyed on the
+// normalized filter. An ingested event evicts the listings of its wallets
+// and tenant; changes that can touch any listing, such as status updates,
+// purges and label changes, evict them all.
+type ResponseCache struct {
+	backend responseCacheBackend
+	ttl     time.Duration
+}
+
+// NewResponseCache returns a cache storing responses in backend for ttl,
+// or nil when ttl is not positive.
+func NewResponseCache(backend responseCacheBackend, ttl time.Duration) *ResponseCache {
+	if backend == nil || ttl <= 0 {
+		return nil
+	}
+	return &ResponseCache{backend: backend, ttl: ttl}
+}
+
+// cachedResponse is a stored response.
+type cachedResponse struct {
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// walletScope and recentScope name the invalidation scopes of wallet
+// histories and of listings across all wallets of a tenant. Every listing
+// is also in allScope.
+func walletScope(address string) string { return "wallet:" + addressKey(address) }
+
+const allScope = "all"
+
+func recentScope(tenant string) string { return "recent:" + tenant }
+
+// responseKey derives the cache key of a listing from its scope, the
+// generations it is computed under, the normalized filter and the
+// response profile.
+func responseKey(scope string, gens []int64, filter EventFilter, profile string) (string, error) {
+	normalized, err := json.Marshal(filter)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%v\x00%s\x00%s", scope, gens, profile, normalized)))
+	return responseCachePrefix + hex.EncodeToString(sum[:]), nil
+}
+
+// Serve writes the response render produces for filter in scope, from the
+// cache when possible. Successful responses get an ETag, and requests
+// whose If-None-Match matches it get 304 Not Modified. A nil cache only
+// adds the ETag.
+func (c *ResponseCache) Serve(w http.ResponseWriter, r *http.Request, scope string, filter EventFilter, profile string, render func(w http.ResponseWriter)) {
+	var key string
+	if c != nil {
+		// Generations are read before rendering, so a bump racing the
+		// render orphans the entry rather than hiding the change
+		ctx, cancel := context.WithTimeout(r.Context(), responseCacheTimeout)
+		gens, err := c.backend.Generations(ctx, scope, allScope)
+		if err == nil {
+			key, err = responseKey(scope, gens, filter, profile)
+		}
+		if err == nil {
+			var data []byte
+			data, err = c.backend.Get(ctx, key)
+			var cached cachedResponse
+			if err == nil && json.Unmarshal(data, &cached) == nil {
+				cancel()
+				writeWithETag(w, r, cached.Header, cached.Body)
+				return
+			}
+		}
+		cancel()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			log.WithError(err).Debug("response cache read failed")
+		}
+		if key == "" {
+			c = nil
+		}
+	}
+
+	rw := &cacheWriter{w: w, header: make(http.Header), status: http.StatusOK}
+	render(rw)
+	if rw.spilled {
+		return
+	}
+	if rw.status != http.StatusOK {
+		for k, v := range rw.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rw.status)
+		_, _ = w.Write(rw.buf.Bytes())
+		return
+	}
+	if c != nil {
+		if data, err := json.Marshal(cachedResponse{Header: rw.header, Body: rw.buf.Bytes()}); err == nil {
+			ctx, cancel := context.WithTimeout(r.Context(), responseCacheTimeout)
+			if err := c.backend.Set(ctx, key, data, c.ttl); err != nil {
+				log.WithError(err).Debug("response cache write failed")
+			}
+			cancel()
+		}
+	}
+	writeWithETag(w, r, rw.header, rw.buf.Bytes())
+}
+
+// cacheWriter buffers a response for the cache. A response that outgrows
+// maxQueryResultBytes is streamed to the client from then on, uncached.
+type cacheWriter struct {
+	w       http.ResponseWriter
+	header  http.Header
+	status  int
+	buf     bytes.Buffer
+	spilled bool
+}
+
+func (cw *cacheWriter) Header() http.Header {
+	if cw.spilled {
+		return cw.w.Header()
+	}
+	return cw.header
+}
+
+func (cw *cacheWriter) WriteHeader(status int) {
+	if !cw.spilled {
+		cw.status = status
+	}
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if cw.spilled {
+		return cw.w.Write(b)
+	}
+	if cw.buf.Len()+len(b) <= maxQueryResultBytes {
+		return cw.buf.Write(b)
+	}
+	cw.spilled = true
+	for k, v := range cw.header {
+		cw.w.Header()[k] = v
+	}
+	cw.w.WriteHeader(cw.status)
+	if _, err := cw.w.Write(cw.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	cw.buf.Reset()
+	return cw.w.Write(b)
+}
+
+// writeWithETag writes a 200 response, or 304 when the client already has
+// it.
+func writeWithETag(w http.ResponseWriter, r *http.Request, header http.Header, body []byte) {
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, using
+// the weak comparison RFC 9110 prescribes for it.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Invalidate evicts the cached listings ev can appear in: the histories of
+// its sender and recipient and the listings of the tenants that see it.
+func (c *ResponseCache) Invalidate(ctx context.Context, ev *Event) {
+	if c == nil {
+		return
+	}
+	scopes := []string{walletScope(ev.From), walletScope(ev.To), recentScope("")}
+	for _, tenant := range eventTenants(ev) {
+		scopes = append(scopes, recentScope(tenant))
+	}
+	c.bump(ctx, scopes...)
+}
+
+// InvalidateAll evicts every cached listing.
+func (c *ResponseCache) InvalidateAll(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	c.bump(ctx, allScope)
+}
+
+func (c *ResponseCache) bump(ctx context.Context, scopes ...string) {
+	ctx, cancel := context.WithTimeout(ctx, responseCacheTimeout)
+	defer cancel()
+	if err := c.backend.Bump(ctx, c.ttl, scopes...); err != nil {
+		log.WithError(err).WithField("scopes", scopes).Warn("response cache invalidation failed")
+	}
+}
+
+// InvalidateAfter wraps routes that change how stored events render, such
+// as label and plugin changes, to evict every cached listing once they
+// succeed.
+func (c *ResponseCache) InvalidateAfter(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		if sw.status < http.StatusBadRequest {
+			c.InvalidateAll(r.Context())
+		}
+	})
+}
+
+// statusWriter records the status a handler writes.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type mapResponseCache struct {
+	mu   sync.Mutex
+	data map[string][]byte
+	gens map[string]int64
+	next int64
+}
+
+func newMapResponseCache() *mapResponseCache {
+	return &mapResponseCache{data: make(map[string][]byte), gens: make(map[string]int64)}
+}
+
+func (c *mapResponseCache) Get(_ context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.data[key]; ok {
+		return v, nil
+	}
+	return nil, redis.Nil
+}
+
+func (c *mapResponseCache) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[key] = value
+	return nil
+}
+
+func (c *mapResponseCache) Generations(_ context.Context, scopes ...string) ([]int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	gens := make([]int64, len(scopes))
+	for i, scope := range scopes {
+		gens[i] = c.gens[scope]
+	}
+	return gens, nil
+}
+
+func (c *mapResponseCache) Bump(_ context.Context, _ time.Duration, scopes ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next++
+	for _, scope := range scopes {
+		c.gens[scope] = c.next
+	}
+	return nil
+}
+
+func (c *mapResponseCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.data)
+}
+
+func TestResponseCacheReadThroughAndInvalidation(t *testing.T) {
+	store := NewEventStore(100, 50)
+	backend := newMapResponseCache()
+	store.AttachResponseCache(NewResponseCache(backend, time.Minute))
+	ts := time.Now().UTC().Format(time.RFC3339)
+	store.Add(makeEvent("1", aliceAddr, bobAddr, "1", ts, "USDC"))
+
+	history := func() *httptest.ResponseRecorder {
+		r := httptest.NewRecorder()
+		getWalletTransactions(store, r, withChiParam(httptest.NewRequest(http.MethodGet, "/wallet/"+aliceAddr+"/transactions", nil), "address", aliceAddr))
+		return r
+	}
+	first := history()
+	if first.Code != http.StatusOK || backend.size() != 1 {
+		t.Fatalf("expected a cached 200, got %d with %d entries", first.Code, backend.size())
+	}
+
+	// An event of other wallets leaves the entry in place; the cached
+	// response is served even though the store changed
+	store.Add(makeEvent("2", aliceAddr, carolAddr, "1", ts, "USDC"))
+	store.responses.Invalidate(context.Background(), makeEvent("3", carolAddr, bobAddr, "1", ts, "DAI"))
+	if cached := history(); cached.Body.String() != first.Body.String() || cached.Header().Get("X-Total-Count") != "1" {
+		t.Fatalf("expected the cached response, got %s", cached.Body.String())
+	}
+
+	// An event of the wallet evicts it
+	store.responses.Invalidate(context.Background(), makeEvent("2", aliceAddr, carolAddr, "1", ts, "USDC"))
+	if fresh := history(); fresh.Header().Get("X-Total-Count") != "2" {
+		t.Fatalf("expected a fresh response, got total %s", fresh.Header().Get("X-Total-Count"))
+	}
+
+	// Label changes evict every listing
+	labels := NewLabelStore()
+	store.AttachLabels(labels)
+	r := httptest.NewRecorder()
+	req := withChiParam(httptest.NewRequest(http.MethodPut, "/labels/"+carolAddr, strings.NewReader(`{"name":"Carol"}`)), "address", carolAddr)
+	store.responses.InvalidateAfter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		putLabel(labels, w, r)
+	})).ServeHTTP(r, req)
+	if r.Code != http.StatusOK {
+		t.Fatalf("put label: %d %s", r.Code, r.Body.String())
+	}
+	if labeled := history(); !strings.Contains(labeled.Body.String(), `"to_label":"Carol"`) {
+		t.Fatalf("expected the new label, got %s", labeled.Body.String())
+	}
+
+	// Errors are not cached
+	before := backend.size()
+	r = httptest.NewRecorder()
+	nobody := "0xd00d000000000000000000000000000000000000"
+	getWalletTransactions(store, r, withChiParam(httptest.NewRequest(http.MethodGet, "/wallet/"+nobody+"/transactions", nil), "address", nobody))
+	if r.Code != http.StatusNotFound || backend.size() != before {
+		t.Fatalf("got %d with %d entries", r.Code, backend.size())
+	}
+}
+
+func TestResponseETag(t *testing.T) {
+	store := NewEventStore(100, 50)
+	store.Add(makeEvent("1", aliceAddr, bobAddr, "1", time.Now().UTC().Format(time.RFC3339), "USDC"))
+
+	r := httptest.NewRecorder()
+	req := withChiParam(httptest.NewRequest(http.MethodGet, "/wallet/"+aliceAddr+"/transactions", nil), "address", aliceAddr)
+	getWalletTransactions(store, r, req)
+	etag := r.Header().Get("ETag")
+	if r.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d %q", r.Code, etag)
+	}
+
+	for header, want := range map[string]int{
+		etag:                 http.StatusNotModified,
+		`"other", W/` + etag: http.StatusNotModified,
+		`"other"`:            http.StatusOK,
+	} {
+		r = httptest.NewRecorder()
+		req := withChiParam(httptest.NewRequest(http.MethodGet, "/wallet/"+aliceAddr+"/transactions", nil), "address", aliceAddr)
+		req.Header.Set("If-None-Match", header)
+		getWalletTransactions(store, r, req)
+		if r.Code != want {
+			t.Errorf("If-None-Match %s: expected %d, got %d", header, want, r.Code)
+		}
+		if want == http.StatusNotModified && r.Body.Len() != 0 {
+			t.Errorf("304 with a body: %s", r.Body.String())
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultRetentionInterval is how often the retention job runs,
+// overridable with RETENTION_INTERVAL.
+const defaultRetentionInterval = 6 * time.Hour
+
+// RetentionSegment is the events of one chain and network received in one
+// month, the unit retention exports and deletes.
+type RetentionSegment struct {
+	Month   time.Time
+	Chain   string
+	Network string
+	Events  int
+}
+
+// End is the first instant after seg's month.
+func (seg RetentionSegment) End() time.Time {
+	return seg.Month.AddDate(0, 1, 0)
+}
+
+// RetentionStore is a backend the retention job can expire events from;
+// the Postgres, TimescaleDB and partitioned backends implement it.
+type RetentionStore interface {
+	// RetentionSegments counts the events received before before by
+	// month, chain and network.
+	RetentionSegments(ctx context.Context, before time.Time) ([]RetentionSegment, error)
+	// ExportSegment writes the events of seg to w as NDJSON and returns
+	// how many it wrote.
+	ExportSegment(ctx context.Context, seg RetentionSegment, w io.Writer) (int, error)
+	// DeleteSegment deletes the events of seg, batchSize per statement.
+	DeleteSegment(ctx context.Context, seg RetentionSegment, batchSize int) (int, error)
+}
+
+// monthPartitions is a RetentionStore partitioned by month, which drops a
+// fully expired month as a whole; *PartitionedRepository implements it.
+type monthPartitions interface {
+	Partitions(ctx context.Context) ([]EventPartition, error)
+	DropMonth(ctx context.Context, month time.Time) (string, error)
+}
+
+// RetentionPolicies are how many days events are kept, by chain:network,
+// chain or "*" for every other network. Networks without a policy keep
+// their events.
+type RetentionPolicies map[string]int
+
+// ParseRetentionPolicies parses RETENTION_DAYS, a comma-separated list of
+// key=days entries where the key is chain:network, chain or *.
+func ParseRetentionPolicies(spec string) (RetentionPolicies, error) {
+	policies := make(RetentionPolicies)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		days, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || key == "" || err != nil || days < 1 {
+			return nil, fmt.Errorf("invalid retention policy %q, want chain:network=days, chain=days or *=days", item)
+		}
+		policies[key] = days
+	}
+	return policies, nil
+}
+
+// TTL returns how long events of chain and network are kept, or 0 to keep
+// them forever.
+func (p RetentionPolicies) TTL(chain, network string) time.Duration {
+	for _, key := range []string{chain + ":" + network, chain, "*"} {
+		if days, ok := p[key]; ok {
+			return time.Duration(days) * 24 * time.Hour
+		}
+	}
+	return 0
+}
+
+// shortest returns the shortest TTL, or 0 when there is none.
+func (p RetentionPolicies) shortest() time.Duration {
+	var min time.Duration
+	for _, days := range p {
+		if ttl := time.Duration(days) * 24 * time.Hour; min == 0 || ttl < min {
+			min = ttl
+		}
+	}
+	return min
+}
+
+// ArchiveObject is a segment exported before it was deleted.
+type ArchiveObject struct {
+	Key    string `json:"key"`
+	Events int    `json:"events"`
+	Bytes  int64  `json:"bytes"`
+	// Signature is the key of its detached signature, when signed.
+	Signature string `json:"signature,omitempty"`
+}
+
+// RetentionRun reports a run of the retention job.
+type RetentionRun struct {
+	Trigger           string          `json:"trigger"`
+	StartedAt         string          `json:"started_at"`
+	FinishedAt        string          `json:"finished_at,omitempty"`
+	Deleted           int             `json:"deleted"`
+	DroppedPartitions []string        `json:"dropped_partitions,omitempty"`
+	Exported          []ArchiveObject `json:"exported,omitempty"`
+	Errors            []string        `json:"errors,omitempty"`
+}
+
+// RetentionStatus is the body of GET /admin/retention.
+type RetentionStatus struct {
+	Enabled    bool              `json:"enabled"`
+	Policies   RetentionPolicies `json:"policies,omitempty"`
+	Interval   string            `json:"interval,omitempty"`
+	Archive    string            `json:"archive,omitempty"`
+	Running    bool              `json:"running"`
+	NextRun    string            `json:"next_run,omitempty"`
+	LastRun    *RetentionRun     `json:"last_run,omitempty"`
+	Partitions []EventPartition  `json:"partitions,omitempty"`
+}
+
+// RetentionJob deletes events older than their network's retention, a
+// whole month of a chain and network at a time once the month has aged
+// out, exporting them to the archive first when one is configured. On the
+// partitioned backend a month whose every network has aged out is dropped
+// as a table. Only one run happens at a time.
+type RetentionJob struct {
+	store    *EventStore
+	target   RetentionStore
+	policies RetentionPolicies
+	archive  *S3Archive
+	signer   *Signer
+	interval time.Duration
+	trigger  chan struct{}
+	now      func() time.Time
+
+	mu      sync.Mutex
+	running bool
+	next    time.Time
+	last    *RetentionRun
+}
+
+// NewRetentionJob returns a job expiring target's events under policies
+// every interval; archive may be nil.
+func NewRetentionJob(store *EventStore, target RetentionStore, policies RetentionPolicies, archive *S3Archive, interval time.Duration) *RetentionJob {
+	return &RetentionJob{
+		store:    store,
+		target:   target,
+		policies: policies,
+		archive:  archive,
+		interval: interval,
+		trigger:  make(chan struct{}, 1),
+		now:      time.Now,
+	}
+}
+
+// AttachSigner uploads a detached signature next to every archive object.
+func (j *RetentionJob) AttachSigner(signer *Signer) {
+	j.signer = signer
+}
+
+// Run runs the job now, then every interval and when triggered, until ctx
+// is done.
+func (j *RetentionJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	trigger := "schedule"
+	for {
+		j.mu.Lock()
+		j.next = j.now().Add(j.interval)
+		j.mu.Unlock()
+		j.RunOnce(ctx, trigger)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			trigger = "schedule"
+		case <-j.trigger:
+			trigger = "admin"
+		}
+	}
+}
+
+// Trigger asks Run for a run now. It returns false when a run is already
+// in progress or requested.
+func (j *RetentionJob) Trigger() bool {
+	j.mu.Lock()
+	running := j.running
+	j.mu.Unlock()
+	if running {
+		return false
+	}
+	select {
+	case j.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// RunOnce expires the events whose month has aged out and returns what it
+// did. A segment that fails to export is kept, and so is the rest of its
+// month's partition.
+func (j *RetentionJob) RunOnce(ctx context.Context, trigger string) RetentionRun {
+	now := j.now().UTC()
+	run := RetentionRun{Trigger: trigger, StartedAt: now.Format(time.RFC3339)}
+	j.mu.Lock()
+	if j.running {
+		j.mu.Unlock()
+		run.Errors = []string{"a run is already in progress"}
+		return run
+	}
+	j.running = true
+	j.mu.Unlock()
+	defer func() {
+		run.FinishedAt = j.now().UTC().Format(time.RFC3339)
+		j.mu.Lock()
+		j.running, j.last = false, &run
+		j.mu.Unlock()
+		if len(run.Errors) > 0 {
+			log.WithField("errors", run.Errors).Warn("retention run failed in part")
+		}
+		if run.Deleted > 0 || len(run.DroppedPartitions) > 0 {
+			log.WithFields(log.Fields{"deleted": run.Deleted, "dropped": run.DroppedPartitions, "exported": len(run.Exported)}).Info("retention: events expired")
+		}
+	}()
+
+	shortest := j.policies.shortest()
+	if shortest == 0 {
+		return run
+	}
+	// Only months that have fully aged out under some policy
+	before := monthStart(now.Add(-shortest))
+	segments, err := j.target.RetentionSegments(ctx, before)
+	if err != nil {
+		run.Errors = append(run.Errors, err.Error())
+		return run
+	}
+	byMonth := make(map[time.Time][]RetentionSegment)
+	var months []time.Time
+	for _, seg := range segments {
+		if _, ok := byMonth[seg.Month]; !ok {
+			months = append(months, seg.Month)
+		}
+		byMonth[seg.Month] = append(byMonth[seg.Month], seg)
+	}
+	sort.Slice(months, func(a, b int) bool { return months[a].Before(months[b]) })
+
+	partitions, _ := j.target.(monthPartitions)
+	for _, month := range months {
+		var expired []RetentionSegment
+		for _, seg := range byMonth[month] {
+			if ttl := j.policies.TTL(seg.Chain, seg.Network); ttl > 0 && !seg.End().After(now.Add(-ttl)) {
+				expired = append(expired, seg)
+			}
+		}
+		if len(expired) == 0 {
+			continue
+		}
+		if !j.exportAll(ctx, expired, &run) {
+			continue
+		}
+		if partitions != nil && len(expired) == len(byMonth[month]) {
+			name, err := partitions.DropMonth(ctx, month)
+			if err != nil {
+				run.Errors = append(run.Errors, fmt.Sprintf("drop %s: %v", partitionName(month), err))
+				continue
+			}
+			run.DroppedPartitions = append(run.DroppedPartitions, name)
+			for _, seg := range expired {
+				run.Deleted += seg.Events
+				j.store.cache.PurgeSegment(seg)
+			}
+			continue
+		}
+		for _, seg := range expired {
+			n, err := j.target.DeleteSegment(ctx, seg, defaultPurgeBatchSize)
+			run.Deleted += n
+			if err != nil {
+				run.Errors = append(run.Errors, fmt.Sprintf("delete %s %s:%s: %v", month.Format("2006-01"), seg.Chain, seg.Network, err))
+				continue
+			}
+			j.store.cache.PurgeSegment(seg)
+		}
+	}
+
+	// Partitions left empty, e.g. by late events that were deleted
+	if partitions != nil {
+		if parts, err := partitions.Partitions(ctx); err == nil {
+			for _, part := range parts {
+				month, ok := partitionMonth(part.Name)
+				if ok && month.Before(before) && len(byMonth[month]) == 0 {
+					if name, err := partitions.DropMonth(ctx, month); err == nil {
+						run.DroppedPartitions = append(run.DroppedPartitions, name)
+					}
+				}
+			}
+		}
+	}
+	if run.Deleted > 0 || len(run.DroppedPartitions) > 0 {
+		j.store.responses.InvalidateAll(ctx)
+	}
+	return run
+}
+
+// exportAll exports segments to the archive, if any, and reports whether
+// they may be deleted: every one was exported, whole.
+func (j *RetentionJob) exportAll(ctx context.Context, segments []RetentionSegment, run *RetentionRun) bool {
+	if j.archive == nil {
+		return true
+	}
+	for _, seg := range segments {
+		obj, err := j.export(ctx, seg)
+		if err != nil {
+			run.Errors = append(run.Errors, fmt.Sprintf("export %s %s:%s: %v", seg.Month.Format("2006-01"), seg.Chain, seg.Network, err))
+			return false
+		}
+		run.Exported = append(run.Exported, obj)
+		// Events stored since the segment was counted were not exported
+		// with certainty; the next run tries again
+		if obj.Events != seg.Events {
+			run.Errors = append(run.Errors, fmt.Sprintf("export %s %s:%s: %d events changed while exporting", seg.Month.Format("2006-01"), seg.Chain, seg.Network, obj.Events-seg.Events))
+			return false
+		}
+	}
+	return true
+}
+
+// export writes seg as gzipped NDJSON to a temporary file and uploads it
+// as events/<month>/<chain>-<network>.ndjson.gz, followed by its detached
+// signature as <object>.sig when a signer is attached.
+func (j *RetentionJob) export(ctx context.Context, seg RetentionSegment) (ArchiveObject, error) {
+	f, err := os.CreateTemp("", "tracker-archive-*.ndjson.gz")
+	if err != nil {
+		return ArchiveObject{}, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	digest := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(f, digest))
+	n, err := j.target.ExportSegment(ctx, seg, gz)
+	if err != nil {
+		return ArchiveObject{}, err
+	}
+	if err := gz.Close(); err != nil {
+		return ArchiveObject{}, err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return ArchiveObject{}, err
+	}
+	key := j.archive.Key(fmt.Sprintf("events/%s/%s-%s.ndjson.gz", seg.Month.Format("2006-01"), seg.Chain, seg.Network))
+	sum := digest.Sum(nil)
+	if err := j.archive.Put(ctx, key, f, size, sum, "application/gzip"); err != nil {
+		return ArchiveObject{}, err
+	}
+	obj := ArchiveObject{Key: key, Events: n, Bytes: size}
+	if j.signer != nil {
+		sig, err := json.Marshal(j.signer.Detached(sum))
+		if err != nil {
+			return ArchiveObject{}, err
+		}
+		sigSum := sha256.Sum256(sig)
+		obj.Signature = key + ".sig"
+		if err := j.archive.Put(ctx, obj.Signature, bytes.NewReader(sig), int64(len(sig)), sigSum[:], "application/json"); err != nil {
+			return ArchiveObject{}, err
+		}
+	}
+	return obj, nil
+}
+
+// Status describes the job, with the partitions on the partitioned
+// backend.
+func (j *RetentionJob) Status(ctx context.Context) RetentionStatus {
+	if j == nil {
+		return RetentionStatus{}
+	}
+	j.mu.Lock()
+	status := RetentionStatus{
+		Enabled:  true,
+		Policies: j.policies,
+		Interval: j.interval.String(),
+		Archive:  j.archive.String(),
+		Running:  j.running,
+		LastRun:  j.last,
+	}
+	if !j.next.IsZero() {
+		status.NextRun = j.next.UTC().Format(time.RFC3339)
+	}
+	j.mu.Unlock()
+	if partitions, ok := j.target.(monthPartitions); ok {
+		parts, err := partitions.Partitions(ctx)
+		if err != nil {
+			log.WithError(err).Warn("could not list event partitions")
+		}
+		status.Partitions = parts
+	}
+	return status
+}
+
+// getRetentionStatus handles GET /admin/retention.
+func getRetentionStatus(job *RetentionJob, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(job.Status(r.Context()))
+}
+
+// runRetention handles POST /admin/retention/run: it starts a run now and
+// answers 202 with the job status.
+func runRetention(job *RetentionJob, w http.ResponseWriter, r *http.Request) {
+	if job == nil {
+		httpError(w, "retention is not configured; set RETENTION_DAYS", http.StatusNotFound)
+		return
+	}
+	if !job.Trigger() {
+		httpError(w, "a retention run is already in progress", http.StatusConflict)
+		return
+	}
+	log.Info("admin: retention run requested")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(job.Status(r.Context()))
+}
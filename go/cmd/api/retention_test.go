@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRetentionStore keeps events in memory, by the month of their
+// timestamp as the Postgres backends do by created_at.
+type fakeRetentionStore struct {
+	mu     sync.Mutex
+	events []*Event
+}
+
+func (f *fakeRetentionStore) month(ev *Event) time.Time {
+	ts, _ := time.Parse(time.RFC3339, ev.Timestamp)
+	return monthStart(ts)
+}
+
+func (f *fakeRetentionStore) in(ev *Event, seg RetentionSegment) bool {
+	return ev.Chain == seg.Chain && ev.Network == seg.Network && f.month(ev).Equal(seg.Month)
+}
+
+func (f *fakeRetentionStore) RetentionSegments(_ context.Context, before time.Time) ([]RetentionSegment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	counts := make(map[RetentionSegment]int)
+	for _, ev := range f.events {
+		if month := f.month(ev); month.Before(before) {
+			counts[RetentionSegment{Month: month, Chain: ev.Chain, Network: ev.Network}]++
+		}
+	}
+	var out []RetentionSegment
+	for seg, n := range counts {
+		seg.Events = n
+		out = append(out, seg)
+	}
+	return out, nil
+}
+
+func (f *fakeRetentionStore) ExportSegment(_ context.Context, seg RetentionSegment, w io.Writer) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, ev := range f.events {
+		if f.in(ev, seg) {
+			if err := json.NewEncoder(w).Encode(ev); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeRetentionStore) DeleteSegment(_ context.Context, seg RetentionSegment, _ int) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kept := f.events[:0]
+	for _, ev := range f.events {
+		if !f.in(ev, seg) {
+			kept = append(kept, ev)
+		}
+	}
+	n := len(f.events) - len(kept)
+	f.events = kept
+	return n, nil
+}
+
+func (f *fakeRetentionStore) ids() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for _, ev := range f.events {
+		ids = append(ids, ev.EventID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// fakePartitionedStore also has a partition per month of its events, plus
+// the empty ones in extra.
+type fakePartitionedStore struct {
+	*fakeRetentionStore
+	extra   []time.Time
+	dropped []string
+}
+
+func (f *fakePartitionedStore) Partitions(context.Context) ([]EventPartition, error) {
+	names := make(map[string]bool)
+	for _, month := range f.extra {
+		names[partitionName(month)] = true
+	}
+	f.mu.Lock()
+	for _, ev := range f.events {
+		names[partitionName(f.month(ev))] = true
+	}
+	f.mu.Unlock()
+	var out []EventPartition
+	for name := range names {
+		out = append(out, EventPartition{Name: name})
+	}
+	return out, nil
+}
+
+func (f *fakePartitionedStore) DropMonth(_ context.Context, month time.Time) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kept := f.events[:0]
+	for _, ev := range f.events {
+		if !f.month(ev).Equal(month) {
+			kept = append(kept, ev)
+		}
+	}
+	f.events = kept
+	f.dropped = append(f.dropped, partitionName(month))
+	return partitionName(month), nil
+}
+
+// retentionFixture returns a store and job as of 2025-09-15 keeping
+// Ethereum events for 30 days and the rest for a year.
+func retentionFixture(t *testing.T, target func(*fakeRetentionStore) RetentionStore, archive *S3Archive) (*EventStore, *fakeRetentionStore, *RetentionJob) {
+	fake := &fakeRetentionStore{}
+	store := NewEventStore(100, 50)
+	for _, ev := range []*Event{
+		{EventID: "eth-june", Chain: "ethereum", Network: "mainnet", Timestamp: "2025-06-10T00:00:00Z", From: aliceAddr, To: bobAddr, Value: "1"},
+		{EventID: "eth-august", Chain: "ethereum", Network: "mainnet", Timestamp: "2025-08-10T00:00:00Z", From: aliceAddr, To: bobAddr, Value: "1"},
+		{EventID: "sol-june", Chain: "solana", Network: "devnet", Timestamp: "2025-06-20T00:00:00Z", From: "SolSender", To: "SolReceiver", Value: "1"},
+		{EventID: "sol-2024", Chain: "solana", Network: "devnet", Timestamp: "2024-05-01T00:00:00Z", From: "SolSender", To: "SolReceiver", Value: "1"},
+	} {
+		fake.events = append(fake.events, ev)
+		store.Add(ev)
+	}
+	policies, err := ParseRetentionPolicies("ethereum=30, *=365")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := NewRetentionJob(store, target(fake), policies, archive, time.Hour)
+	job.now = func() time.Time { return time.Date(2025, 9, 15, 12, 0, 0, 0, time.UTC) }
+	return store, fake, job
+}
+
+func TestParseRetentionPolicies(t *testing.T) {
+	policies, err := ParseRetentionPolicies(" Ethereum:Mainnet=7, solana=30 ,*=365,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		chain, network string
+		days           int
+	}{
+		{"ethereum", "mainnet", 7},
+		{"ethereum", "sepolia", 365},
+		{"solana", "devnet", 30},
+		{"polygon", "mainnet", 365},
+	} {
+		if got := policies.TTL(tc.chain, tc.network); got != time.Duration(tc.days)*24*time.Hour {
+			t.Errorf("TTL(%s, %s) = %v, want %d days", tc.chain, tc.network, got, tc.days)
+		}
+	}
+	if policies.shortest() != 7*24*time.Hour {
+		t.Errorf("shortest = %v", policies.shortest())
+	}
+	if ttl := (RetentionPolicies{"solana": 1}).TTL("ethereum", "mainnet"); ttl != 0 {
+		t.Errorf("network without policy has TTL %v", ttl)
+	}
+	for _, spec := range []string{"ethereum", "ethereum=0", "=5", "*=week"} {
+		if _, err := ParseRetentionPolicies(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
+
+func TestRetentionRunDeletesExpiredSegments(t *testing.T) {
+	store, fake, job := retentionFixture(t, func(f *fakeRetentionStore) RetentionStore { return f }, nil)
+	run := job.RunOnce(context.Background(), "test")
+	if run.Deleted != 2 || len(run.Errors) != 0 || len(run.DroppedPartitions) != 0 {
+		t.Fatalf("run = %+v", run)
+	}
+	if ids := fake.ids(); strings.Join(ids, ",") != "eth-august,sol-june" {
+		t.Fatalf("kept %v", ids)
+	}
+	if events := store.GetByWallet(aliceAddr, EventFilter{}); len(events) != 1 || events[0].EventID != "eth-august" {
+		t.Fatalf("cache kept %d events for alice", len(events))
+	}
+	if status := job.Status(context.Background()); !status.Enabled || status.LastRun == nil || status.LastRun.Deleted != 2 {
+		t.Fatalf("status = %+v", status)
+	}
+}
+
+func TestRetentionRunDropsExpiredPartitions(t *testing.T) {
+	var parts *fakePartitionedStore
+	_, fake, job := retentionFixture(t, func(f *fakeRetentionStore) RetentionStore {
+		parts = &fakePartitionedStore{fakeRetentionStore: f, extra: []time.Time{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}}
+		return parts
+	}, nil)
+	run := job.RunOnce(context.Background(), "test")
+	// May 2024 has aged out whole and January 2024 is empty; June 2025
+	// still holds Solana events, so its Ethereum ones are deleted
+	if run.Deleted != 2 || len(run.Errors) != 0 || strings.Join(run.DroppedPartitions, ",") != "events_2024_05,events_2024_01" {
+		t.Fatalf("run = %+v", run)
+	}
+	if ids := fake.ids(); strings.Join(ids, ",") != "eth-august,sol-june" {
+		t.Fatalf("kept %v", ids)
+	}
+}
+
+func TestRetentionRunExportsBeforeDeleting(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		objects = make(map[string][]string)
+		fail    bool
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			http.Error(w, "slow down", http.StatusServiceUnavailable)
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") || r.Header.Get("Content-Type") != "application/gzip" {
+			t.Errorf("headers = %v", r.Header)
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("body is not gzipped: %v", err)
+			return
+		}
+		var ids []string
+		for lines := bufio.NewScanner(gz); lines.Scan(); {
+			var ev Event
+			if err := json.Unmarshal(lines.Bytes(), &ev); err != nil {
+				t.Errorf("line %q: %v", lines.Text(), err)
+			}
+			ids = append(ids, ev.EventID)
+		}
+		objects[r.URL.Path] = ids
+	}))
+	defer srv.Close()
+	archive, err := NewS3Archive(ArchiveConfig{Endpoint: srv.URL, Bucket: "tracker", Region: "us-east-1", AccessKey: "ak", SecretKey: "sk", Prefix: "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed upload keeps the events
+	fail = true
+	_, fake, job := retentionFixture(t, func(f *fakeRetentionStore) RetentionStore { return f }, archive)
+	if run := job.RunOnce(context.Background(), "test"); run.Deleted != 0 || len(run.Errors) != 2 {
+		t.Fatalf("run = %+v", run)
+	}
+	if len(fake.ids()) != 4 {
+		t.Fatalf("kept %v", fake.ids())
+	}
+
+	fail = false
+	run := job.RunOnce(context.Background(), "test")
+	if run.Deleted != 2 || len(run.Errors) != 0 || len(run.Exported) != 2 {
+		t.Fatalf("run = %+v", run)
+	}
+	if ids := objects["/tracker/prod/events/2025-06/ethereum-mainnet.ndjson.gz"]; len(ids) != 1 || ids[0] != "eth-june" {
+		t.Fatalf("objects = %v", objects)
+	}
+	if ids := objects["/tracker/prod/events/2024-05/solana-devnet.ndjson.gz"]; len(ids) != 1 || ids[0] != "sol-2024" {
+		t.Fatalf("objects = %v", objects)
+	}
+	if obj := run.Exported[0]; obj.Key != "prod/events/2024-05/solana-devnet.ndjson.gz" || obj.Events != 1 || obj.Bytes == 0 {
+		t.Fatalf("exported = %+v", run.Exported)
+	}
+}
+
+func TestRetentionHandlers(t *testing.T) {
+	post := func(job *RetentionJob) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		runRetention(job, rec, httptest.NewRequest("POST", "/admin/retention/run", nil))
+		return rec
+	}
+	if rec := post(nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("status %d without retention, want 404", rec.Code)
+	}
+	rec := httptest.NewRecorder()
+	getRetentionStatus(nil, rec, httptest.NewRequest("GET", "/admin/retention", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"enabled":false`) {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+
+	_, _, job := retentionFixture(t, func(f *fakeRetentionStore) RetentionStore { return f }, nil)
+	if rec := post(job); rec.Code != http.StatusAccepted || !strings.Contains(rec.Body.String(), `"enabled":true`) {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	// Run is not consuming triggers, so the first is still pending
+	if rec := post(job); rec.Code != http.StatusConflict {
+		t.Fatalf("status %d with a pending run, want 409", rec.Code)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultRPCProbeInterval is how often providers are probed,
+	// overridable with RPC_PROBE_INTERVAL.
+	defaultRPCProbeInterval = 30 * time.Second
+	rpcProbeTimeout         = 5 * time.Second
+	// rpcUnhealthyAfter consecutive failed probes mark a provider unhealthy.
+	rpcUnhealthyAfter = 3
+	// rpcLatencyWeight is the weight of the newest probe in the moving
+	// average latency.
+	rpcLatencyWeight = 0.3
+)
+
+// RPCManager benchmarks the RPC providers of every network in the chain
+// registry and hands out the fastest healthy one. Providers are probed
+// with a cheap head request (eth_blockNumber, getSlot) on an interval and
+// whenever the registry changes.
+type RPCManager struct {
+	chains   *ChainRegistry
+	client   *http.Client
+	interval time.Duration
+
+	mu    sync.RWMutex
+	stats map[string]*providerStats // chain:network|url -> stats
+}
+
+type providerStats struct {
+	latency     time.Duration // moving average of successful probes
+	last        time.Duration
+	probes      uint64
+	errors      uint64
+	consecutive int // failed probes in a row
+	lastError   string
+	lastProbe   time.Time
+	height      uint64
+}
+
+func (p *providerStats) healthy() bool {
+	return p.consecutive < rpcUnhealthyAfter
+}
+
+// ProviderStatus is the benchmark of one RPC provider. Provider is the URL
+// reduced to scheme and host, since paths and queries often carry API keys.
+type ProviderStatus struct {
+	Provider      string  `json:"provider"`
+	Healthy       bool    `json:"healthy"`
+	Preferred     bool    `json:"preferred"`
+	LatencyMS     float64 `json:"latency_ms,omitempty"`
+	LastLatencyMS float64 `json:"last_latency_ms,omitempty"`
+	Height        uint64  `json:"height,omitempty"`
+	Probes        uint64  `json:"probes"`
+	Errors        uint64  `json:"errors"`
+	ErrorRate     float64 `json:"error_rate"`
+	LastError     string  `json:"last_error,omitempty"`
+	LastProbe     string  `json:"last_probe,omitempty"`
+}
+
+// ChainStatus is an entry of GET /chains/status.
+type ChainStatus struct {
+	Chain     string           `json:"chain"`
+	Network   string           `json:"network"`
+	Enabled   bool             `json:"enabled"`
+	Providers []ProviderStatus `json:"providers"`
+}
+
+// NewRPCManager returns a manager for the providers of chains, probed every
+// interval once Run is started.
+func NewRPCManager(chains *ChainRegistry, interval time.Duration) *RPCManager {
+	if interval <= 0 {
+		interval = defaultRPCProbeInterval
+	}
+	return &RPCManager{
+		chains:   chains,
+		client:   &http.Client{Timeout: rpcProbeTimeout},
+		interval: interval,
+		stats:    make(map[string]*providerStats),
+	}
+}
+
+// Run probes every provider until ctx is done.
+func (m *RPCManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		changed := m.chains.Changed()
+		m.probeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-changed:
+		}
+	}
+}
+
+// probeAll probes every configured provider concurrently and forgets the
+// providers no longer configured.
+func (m *RPCManager) probeAll(ctx context.Context) {
+	configured := make(map[string]bool)
+	var wg sync.WaitGroup
+	for _, c := range m.chains.List() {
+		for _, u := range c.RPCURLs {
+			key := c.Chain + ":" + c.Network + "|" + u
+			configured[key] = true
+			wg.Add(1)
+			go func(chain, u, key string) {
+				defer wg.Done()
+				m.probe(ctx, chain, u, key)
+			}(c.Chain, u, key)
+		}
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	for key := range m.stats {
+		if !configured[key] {
+			delete(m.stats, key)
+		}
+	}
+	m.mu.Unlock()
+}
+
+// probe measures one head request against a provider.
+func (m *RPCManager) probe(ctx context.Context, chain, u, key string) {
+	method := chainAdapter(chain).HeadMethod()
+	ctx, cancel := context.WithTimeout(ctx, rpcProbeTimeout)
+	defer cancel()
+	start := time.Now()
+	result, err := rpcCall(ctx, m.client, u, method)
+	elapsed := time.Since(start)
+	var height uint64
+	if err == nil {
+		height, err = parseHeight(result)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.stats[key]
+	if !ok {
+		s = &providerStats{}
+		m.stats[key] = s
+	}
+	s.probes++
+	s.lastProbe = time.Now().UTC()
+	if err != nil {
+		s.errors++
+		s.consecutive++
+		// Transport errors quote the URL, which may hold an API key
+		s.lastError = strings.ReplaceAll(err.Error(), u, providerName(u))
+		if s.consecutive == rpcUnhealthyAfter {
+			log.WithFields(log.Fields{"chain": chain, "provider": providerName(u)}).WithError(errors.New(s.lastError)).
+				Warn("rpc provider unhealthy")
+		}
+		return
+	}
+	if s.consecutive >= rpcUnhealthyAfter {
+		log.WithFields(log.Fields{"chain": chain, "provider": providerName(u)}).Info("rpc provider recovered")
+	}
+	s.consecutive = 0
+	s.lastError = ""
+	s.last = elapsed
+	s.height = height
+	if s.latency == 0 {
+		s.latency = elapsed
+	} else {
+		s.latency = time.Duration(rpcLatencyWeight*float64(elapsed) + (1-rpcLatencyWeight)*float64(s.latency))
+	}
+}
+
+// parseHeight reads a block number (hex string) or slot (number).
+func parseHeight(result json.RawMessage) (uint64, error) {
+	if result == nil {
+		return 0, errors.New("empty result")
+	}
+	var hex string
+	if err := json.Unmarshal(result, &hex); err == nil {
+		return strconv.ParseUint(strings.TrimPrefix(hex, "0x"), 16, 64)
+	}
+	var n uint64
+	if err := json.Unmarshal(result, &n); err != nil {
+		return 0, fmt.Errorf("unexpected head result %s", result)
+	}
+	return n, nil
+}
+
+// ranked returns the providers of a pair best first: healthy ones by
+// average latency, then those not probed yet, then unhealthy ones, each in
+// configured order on ties. Callers hold the lock.
+func (m *RPCManager) ranked(chain, network string, urls []string) []string {
+	rank := func(u string) (int, time.Duration) {
+		s, ok := m.stats[chain+":"+network+"|"+u]
+		switch {
+		case !ok || s.probes == s.errors && s.healthy():
+			return 1, 0
+		case s.healthy():
+			return 0, s.latency
+		}
+		return 2, 0
+	}
+	out := append([]string(nil), urls...)
+	sort.SliceStable(out, func(i, j int) bool {
+		ci, li := rank(out[i])
+		cj, lj := rank(out[j])
+		if ci != cj {
+			return ci < cj
+		}
+		return li < lj
+	})
+	return out
+}
+
+// RPCURL returns the preferred provider of the pair.
+func (m *RPCManager) RPCURL(chain, network string) (string, bool) {
+	chain, network = strings.ToLower(chain), strings.ToLower(network)
+	urls := m.chains.RPCURLs(chain, network)
+	if len(urls) == 0 {
+		return "", false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.ranked(chain, network, urls)[0], true
+}
+
+// Status returns the benchmark of every provider, per network, best first.
+func (m *RPCManager) Status() []ChainStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]ChainStatus, 0)
+	for _, c := range m.chains.List() {
+		cs := ChainStatus{Chain: c.Chain, Network: c.Network, Enabled: c.Enabled, Providers: make([]ProviderStatus, 0, len(c.RPCURLs))}
+		for i, u := range m.ranked(c.Chain, c.Network, c.RPCURLs) {
+			p := ProviderStatus{Provider: providerName(u), Healthy: true, Preferred: i == 0}
+			if s, ok := m.stats[c.Chain+":"+c.Network+"|"+u]; ok {
+				p.Healthy = s.healthy()
+				p.LatencyMS = float64(s.latency) / float64(time.Millisecond)
+				p.LastLatencyMS = float64(s.last) / float64(time.Millisecond)
+				p.Height = s.height
+				p.Probes, p.Errors = s.probes, s.errors
+				p.ErrorRate = float64(s.errors) / float64(s.probes)
+				p.LastError = s.lastError
+				p.LastProbe = s.lastProbe.Format(time.RFC3339)
+			}
+			cs.Providers = append(cs.Providers, p)
+		}
+		out = append(out, cs)
+	}
+	return out
+}
+
+// providerName reduces a provider URL to its scheme and host.
+func providerName(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return "unknown"
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
+
+// getChainStatus serves the provider benchmarks of every network.
+func getChainStatus(rpc *RPCManager, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rpc.Status())
+}
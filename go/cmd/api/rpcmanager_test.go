@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeProvider answers head requests after delay, or fails while failing
+// is set.
+func fakeProvider(t *testing.T, delay time.Duration, result string) (*httptest.Server, *atomic.Bool) {
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(delay)
+		_, _ = w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": ` + result + `}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &failing
+}
+
+func TestRPCManagerPrefersFastestHealthy(t *testing.T) {
+	ctx := context.Background()
+	slow, _ := fakeProvider(t, 40*time.Millisecond, `"0x10"`)
+	fast, fastFailing := fakeProvider(t, 0, `"0x11"`)
+	chains := NewChainRegistry(nil, map[string][]string{"ethereum:mainnet": {slow.URL + "/v2/KEY", fast.URL}})
+	m := NewRPCManager(chains, time.Hour)
+
+	// Before any probe the configured order is used
+	if u, ok := m.RPCURL("Ethereum", "mainnet"); !ok || u != slow.URL+"/v2/KEY" {
+		t.Fatalf("unprobed preference = %q", u)
+	}
+	m.probeAll(ctx)
+	if u, _ := m.RPCURL("ethereum", "mainnet"); u != fast.URL {
+		t.Fatalf("preferred = %q, want the fast provider", u)
+	}
+
+	fastFailing.Store(true)
+	for i := 0; i < rpcUnhealthyAfter; i++ {
+		m.probeAll(ctx)
+	}
+	if u, _ := m.RPCURL("ethereum", "mainnet"); u != slow.URL+"/v2/KEY" {
+		t.Fatalf("preferred = %q, want the slow provider once the fast one is unhealthy", u)
+	}
+
+	status := m.Status()
+	if len(status) != 1 || len(status[0].Providers) != 2 {
+		t.Fatalf("status = %+v", status)
+	}
+	best, worst := status[0].Providers[0], status[0].Providers[1]
+	if !best.Preferred || !best.Healthy || best.Provider != slow.URL || best.Height != 0x10 || best.LatencyMS < 40 {
+		t.Fatalf("preferred provider status = %+v", best)
+	}
+	if worst.Healthy || worst.Probes != 4 || worst.Errors != 3 || worst.ErrorRate != 0.75 || !strings.Contains(worst.LastError, "503") {
+		t.Fatalf("failing provider status = %+v", worst)
+	}
+
+	// Removed providers are forgotten
+	only := []string{fast.URL}
+	if _, err := chains.Update(ctx, "ethereum", "mainnet", ChainUpdate{RPCURLs: &only}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	m.probeAll(ctx)
+	if n := len(m.Status()[0].Providers); n != 1 || len(m.stats) != 1 {
+		t.Fatalf("providers after removal = %d, stats = %d", n, len(m.stats))
+	}
+}
+
+func TestChainStatusEndpointHidesKeys(t *testing.T) {
+	sol, _ := fakeProvider(t, 0, `12345`)
+	m := NewRPCManager(NewChainRegistry(nil, map[string][]string{"solana:devnet": {sol.URL + "/?api-key=KEY"}}), time.Hour)
+	m.probeAll(context.Background())
+
+	rec := httptest.NewRecorder()
+	getChainStatus(m, rec, httptest.NewRequest(http.MethodGet, "/chains/status", nil))
+	if strings.Contains(rec.Body.String(), "KEY") {
+		t.Fatalf("status leaks the provider key: %s", rec.Body)
+	}
+	var status []ChainStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil || status[0].Providers[0].Height != 12345 {
+		t.Fatalf("status = %+v, %v", status, err)
+	}
+	if _, ok := m.RPCURL("solana", "mainnet"); ok {
+		t.Fatal("network without providers has a preferred provider")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sampleOversampling is how many times the requested sample size the
+// TABLESAMPLE rate aims to draw, so a low planner estimate rarely leaves
+// the sample short.
+const sampleOversampling = 4
+
+// parseSample reads the sample parameter of a listing: the number of events
+// to draw at random instead of a page, or 0 for a regular page. Samples
+// have no pages, so offset is rejected along with it.
+func parseSample(r *http.Request) (int, error) {
+	p := newQueryParams(r)
+	size := p.Int("sample", 0, 1, maxPageSize)
+	if err := p.Err(); err != nil {
+		return 0, err
+	}
+	if size > 0 && p.String("offset") != "" {
+		return 0, invalidParam("offset", "offset cannot be combined with sample")
+	}
+	return size, nil
+}
+
+// Sample draws up to size events uniformly from those a listing for
+// addresses (every event when empty) pages through, in the listing's
+// order, with an estimate of how many events match. It reads the
+// repository when one is attached and the cache otherwise.
+func (s *EventStore) Sample(addresses []string, filter EventFilter, size int) ([]*Event, int) {
+	if s.repo != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		events, estimate, err := s.repo.Sample(ctx, addresses, filter, size)
+		if err == nil {
+			return events, estimate
+		}
+		log.WithError(err).Warn("repository sample failed; falling back to in-memory")
+	}
+	events, total, _ := s.cache.Sample(context.Background(), addresses, filter, size)
+	return events, total
+}
+
+// writeSample serves a sample of a listing, with the estimated number of
+// matching events in X-Total-Estimate. Samples are random, so they bypass
+// the response cache.
+func writeSample(store *EventStore, w http.ResponseWriter, addresses []string, filter EventFilter, size int, profile string) {
+	events, estimate := store.Sample(addresses, filter, size)
+	w.Header().Set("X-Total-Estimate", strconv.Itoa(estimate))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(withProfile(profile, store.Enrich(events)))
+}
+
+// reservoirSample keeps size events of events, each with the same
+// probability, in their original order.
+func reservoirSample(events []*Event, size int) []*Event {
+	if len(events) <= size {
+		return events
+	}
+	picked := make([]int, size)
+	for i := range picked {
+		picked[i] = i
+	}
+	for i := size; i < len(events); i++ {
+		if j := rand.Intn(i + 1); j < size {
+			picked[j] = i
+		}
+	}
+	sort.Ints(picked)
+	out := make([]*Event, size)
+	for i, idx := range picked {
+		out[i] = events[idx]
+	}
+	return out
+}
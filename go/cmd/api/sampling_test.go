@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSampleListings(t *testing.T) {
+	store := NewEventStore(100, 50)
+	base := time.Now().UTC().Add(-time.Hour)
+	for i := 0; i < 20; i++ {
+		from := aliceAddr
+		if i%2 == 1 {
+			from = bobAddr
+		}
+		store.Add(makeEvent(fmt.Sprint(i), from, carolAddr, "1", base.Add(time.Duration(i)*time.Minute).Format(time.RFC3339), ""))
+	}
+
+	r := httptest.NewRecorder()
+	getTransactions(store, r, httptest.NewRequest(http.MethodGet, "/transactions?sample=5&from="+aliceAddr+"&sort_by=timestamp&sort_order=asc", nil))
+	if r.Code != http.StatusOK || r.Header().Get("X-Total-Estimate") != "10" || r.Header().Get("ETag") != "" {
+		t.Fatalf("status %d, headers %v", r.Code, r.Header())
+	}
+	var events []*Event
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(events) != 5 {
+		t.Fatalf("expected 5 sampled events, got %d", len(events))
+	}
+	for i, ev := range events {
+		if ev.From != aliceAddr {
+			t.Fatalf("sampled event %s does not match the filter", ev.EventID)
+		}
+		if i > 0 && ev.Timestamp < events[i-1].Timestamp {
+			t.Fatalf("sample is not in the listing's order: %s before %s", events[i-1].Timestamp, ev.Timestamp)
+		}
+	}
+
+	// A sample larger than the listing returns all of it
+	req := withChiParam(httptest.NewRequest(http.MethodGet, "/wallet/"+bobAddr+"/transactions?sample=50", nil), "address", bobAddr)
+	r = httptest.NewRecorder()
+	getWalletTransactions(store, r, req)
+	events = nil
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil || len(events) != 10 || r.Header().Get("X-Total-Estimate") != "10" {
+		t.Fatalf("wallet sample: %d events, headers %v, err %v", len(events), r.Header(), err)
+	}
+
+	for _, target := range []string{"/transactions?sample=0", "/transactions?sample=5&offset=10"} {
+		r = httptest.NewRecorder()
+		getTransactions(store, r, httptest.NewRequest(http.MethodGet, target, nil))
+		if r.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, r.Code)
+		}
+	}
+}
+
+func TestReservoirSampleIsUniform(t *testing.T) {
+	events := make([]*Event, 10)
+	for i := range events {
+		events[i] = &Event{EventID: fmt.Sprint(i)}
+	}
+	counts := make(map[string]int)
+	const rounds = 5000
+	for round := 0; round < rounds; round++ {
+		sample := reservoirSample(events, 3)
+		for i, ev := range sample {
+			counts[ev.EventID]++
+			if i > 0 && ev.EventID < sample[i-1].EventID {
+				t.Fatalf("sample out of order: %v", sample)
+			}
+		}
+	}
+	// Each event is kept with probability 3/10
+	for id, n := range counts {
+		if got := float64(n) / rounds; got < 0.25 || got > 0.35 {
+			t.Errorf("event %s kept in %.2f of samples", id, got)
+		}
+	}
+	if len(counts) != len(events) {
+		t.Fatalf("only %d events ever sampled", len(counts))
+	}
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The TypeScript client is generated from the OpenAPI document, so it
+// follows every route and schema change. `api sdk` writes it as an npm
+// package tarball for releases, and GET /sdk/typescript.tgz serves the one
+// matching the running API.
+
+// sdkPackageName is the npm name of the generated client.
+const sdkPackageName = "@cross-chain-tracker/client"
+
+// sdkModTime is the modification time of every file in the tarball, the
+// one npm uses, so identical documents yield identical tarballs.
+var sdkModTime = time.Date(1985, time.October, 26, 8, 15, 0, 0, time.UTC)
+
+// oaDocument is the part of an OpenAPI document the generator reads.
+type oaDocument struct {
+	Info struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description"`
+	} `json:"info"`
+	Paths      map[string]map[string]oaOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]*oaSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type oaOperation struct {
+	OperationID string `json:"operationId"`
+	Summary     string `json:"summary"`
+	Parameters  []struct {
+		Name        string    `json:"name"`
+		In          string    `json:"in"`
+		Description string    `json:"description"`
+		Schema      *oaSchema `json:"schema"`
+	} `json:"parameters"`
+	RequestBody *struct {
+		Content map[string]oaMedia `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content map[string]oaMedia `json:"content"`
+	} `json:"responses"`
+}
+
+type oaMedia struct {
+	Schema *oaSchema `json:"schema"`
+}
+
+type oaSchema struct {
+	Ref                  string               `json:"$ref"`
+	Type                 string               `json:"type"`
+	Enum                 []string             `json:"enum"`
+	Items                *oaSchema            `json:"items"`
+	Properties           map[string]*oaSchema `json:"properties"`
+	Required             []string             `json:"required"`
+	AdditionalProperties *oaSchema            `json:"additionalProperties"`
+	AllOf                []*oaSchema          `json:"allOf"`
+}
+
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// tsKey renders a property name, quoted when it is not an identifier.
+func tsKey(name string) string {
+	if tsIdentifier.MatchString(name) {
+		return name
+	}
+	return strconv.Quote(name)
+}
+
+// tsCamel turns a snake_case parameter name into a camelCase argument.
+func tsCamel(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// tsType renders a schema as a TypeScript type.
+func tsType(s *oaSchema) string {
+	switch {
+	case s == nil:
+		return "unknown"
+	case s.Ref != "":
+		return strings.TrimPrefix(s.Ref, "#/components/schemas/")
+	case len(s.AllOf) > 0:
+		parts := make([]string, len(s.AllOf))
+		for i, part := range s.AllOf {
+			parts[i] = tsType(part)
+		}
+		return strings.Join(parts, " & ")
+	case len(s.Enum) > 0:
+		literals := make([]string, len(s.Enum))
+		for i, v := range s.Enum {
+			literals[i] = strconv.Quote(v)
+		}
+		return strings.Join(literals, " | ")
+	}
+	switch s.Type {
+	case "string":
+		return "string"
+	case "integer", "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		item := tsType(s.Items)
+		if strings.ContainsAny(item, "|& ") {
+			item = "(" + item + ")"
+		}
+		return item + "[]"
+	case "object":
+		if s.Properties != nil {
+			return "{ " + strings.Join(tsMembers(s), " ") + " }"
+		}
+		if s.AdditionalProperties != nil {
+			return "Record<string, " + tsType(s.AdditionalProperties) + ">"
+		}
+		return "Record<string, unknown>"
+	}
+	return "unknown"
+}
+
+// tsMembers renders the properties of an object schema, optional unless
+// required.
+func tsMembers(s *oaSchema) []string {
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	members := make([]string, len(names))
+	for i, name := range names {
+		optional := "?"
+		if containsToken(s.Required, name) {
+			optional = ""
+		}
+		members[i] = tsKey(name) + optional + ": " + tsType(s.Properties[name]) + ";"
+	}
+	return members
+}
+
+// sdkMethod is a client method generated from an operation.
+type sdkMethod struct {
+	name, method, path, summary string
+	// args are the path parameters, in path order, as camelCase names.
+	args       []string
+	pathParams map[string]string // parameter name -> argument
+	query      []sdkQueryParam
+	// body is the TypeScript type of the request body, and bodyType its
+	// content type when it is not JSON.
+	body, bodyType string
+	// result is "json", "raw" (a fetch Response), "void" or "stream" (the
+	// URL of a Server-Sent Events stream).
+	result     string
+	resultType string
+}
+
+type sdkQueryParam struct {
+	name, typ, description string
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// sdkMethods lists the client methods of doc, by operation ID.
+func sdkMethods(doc *oaDocument) ([]sdkMethod, error) {
+	var methods []sdkMethod
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			if op.OperationID == "" {
+				return nil, fmt.Errorf("%s %s has no operationId", strings.ToUpper(method), path)
+			}
+			m := sdkMethod{name: op.OperationID, method: strings.ToUpper(method), path: path, summary: op.Summary, pathParams: map[string]string{}}
+			for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+				arg := tsCamel(match[1])
+				m.args = append(m.args, arg)
+				m.pathParams[match[1]] = arg
+			}
+			for _, p := range op.Parameters {
+				if p.In == "query" {
+					m.query = append(m.query, sdkQueryParam{name: p.Name, typ: tsType(p.Schema), description: p.Description})
+				}
+			}
+			if op.RequestBody != nil {
+				for contentType, media := range op.RequestBody.Content {
+					if contentType == "application/json" {
+						m.body = tsType(media.Schema)
+					} else {
+						m.body, m.bodyType = "BodyInit", contentType
+					}
+				}
+			}
+			m.result, m.resultType = sdkResult(op)
+			methods = append(methods, m)
+		}
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].name < methods[j].name })
+	for i := 1; i < len(methods); i++ {
+		if methods[i].name == methods[i-1].name {
+			return nil, fmt.Errorf("duplicate operationId %s", methods[i].name)
+		}
+	}
+	return methods, nil
+}
+
+// sdkResult decides what a method resolves to from the operation's 2xx
+// responses: their JSON bodies, the raw response when one has another
+// content type, nothing when none has a body, or the stream URL for
+// Server-Sent Events.
+func sdkResult(op oaOperation) (string, string) {
+	codes := make([]string, 0, len(op.Responses))
+	for code := range op.Responses {
+		if strings.HasPrefix(code, "2") {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	var types []string
+	for _, code := range codes {
+		for contentType, media := range op.Responses[code].Content {
+			switch contentType {
+			case "text/event-stream":
+				return "stream", "string"
+			case "application/json":
+				if t := tsType(media.Schema); !containsToken(types, t) {
+					types = append(types, t)
+				}
+			default:
+				return "raw", "Response"
+			}
+		}
+	}
+	if len(types) == 0 {
+		return "void", "void"
+	}
+	return "json", strings.Join(types, " | ")
+}
+
+// signature renders the method's parameters: the path parameters, the body
+// and the optional query parameters.
+func (m sdkMethod) signature(typed bool) string {
+	var params []string
+	for _, arg := range m.args {
+		if typed {
+			arg += ": string"
+		}
+		params = append(params, arg)
+	}
+	if m.body != "" {
+		if typed {
+			params = append(params, "body: "+m.body)
+		} else {
+			params = append(params, "body")
+		}
+	}
+	if len(m.query) > 0 {
+		if typed {
+			params = append(params, "query?: "+m.queryTypeName())
+		} else {
+			params = append(params, "query")
+		}
+	}
+	return strings.Join(params, ", ")
+}
+
+// queryTypeName names the type of the method's query parameters.
+func (m sdkMethod) queryTypeName() string {
+	return strings.ToUpper(m.name[:1]) + m.name[1:] + "Query"
+}
+
+// pathExpr renders the path as a JavaScript template literal.
+func (m sdkMethod) pathExpr() string {
+	return "`" + pathParamPattern.ReplaceAllStringFunc(m.path, func(param string) string {
+		return "${encodeURIComponent(" + m.pathParams[param[1:len(param)-1]] + ")}"
+	}) + "`"
+}
+
+// renderSDKDeclarations renders index.d.ts: the schemas, the query
+// parameter types and the client class.
+func renderSDKDeclarations(doc *oaDocument, methods []sdkMethod) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by `api sdk` from the %s OpenAPI document %s. DO NOT EDIT.\n\n", doc.Info.Title, doc.Info.Version)
+
+	names := make([]string, 0, len(doc.Components.Schemas))
+	for name := range doc.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := doc.Components.Schemas[name]
+		if s != nil && s.Type == "object" && s.Properties != nil && len(s.AllOf) == 0 {
+			fmt.Fprintf(&b, "export interface %s {\n", name)
+			for _, member := range tsMembers(s) {
+				fmt.Fprintf(&b, "  %s\n", member)
+			}
+			b.WriteString("}\n\n")
+			continue
+		}
+		fmt.Fprintf(&b, "export type %s = %s;\n\n", name, tsType(s))
+	}
+
+	for _, m := range methods {
+		if len(m.query) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "/** Query parameters of {@link TrackerClient.%s}. */\nexport interface %s {\n", m.name, m.queryTypeName())
+		for _, q := range m.query {
+			if q.description != "" {
+				fmt.Fprintf(&b, "  /** %s */\n", strings.ReplaceAll(q.description, "*/", "*\\/"))
+			}
+			fmt.Fprintf(&b, "  %s?: %s;\n", tsKey(q.name), q.typ)
+		}
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString(`export interface ClientOptions {
+  /** Base URL of the API, e.g. https://tracker.example */
+  baseUrl: string;
+  /** Tenant API key, sent as X-API-Key (and as api_key in stream URLs). */
+  apiKey?: string;
+  /** ADMIN_TOKEN, sent as a bearer token. */
+  adminToken?: string;
+  /** fetch implementation; the global fetch by default. */
+  fetch?: typeof fetch;
+}
+
+/** Rejection of every request the API answers with an error status. */
+export declare class ApiError extends Error {
+  readonly status: number;
+  readonly body: ErrorResponse | undefined;
+  constructor(status: number, body: ErrorResponse | undefined);
+}
+
+export declare class TrackerClient {
+  readonly options: ClientOptions;
+  constructor(options: ClientOptions);
+`)
+	for _, m := range methods {
+		sig := m.signature(true)
+		if m.summary != "" {
+			fmt.Fprintf(&b, "  /** %s */\n", strings.ReplaceAll(m.summary, "*/", "*\\/"))
+		}
+		if m.result == "stream" {
+			fmt.Fprintf(&b, "  %s(%s): string;\n", m.name, sig)
+			continue
+		}
+		fmt.Fprintf(&b, "  %s(%s): Promise<%s>;\n", m.name, sig, m.resultType)
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// renderSDKModule renders index.js, the ES module implementing the client.
+func renderSDKModule(doc *oaDocument, methods []sdkMethod) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by `api sdk` from the %s OpenAPI document %s. DO NOT EDIT.\n\n", doc.Info.Title, doc.Info.Version)
+	b.WriteString(`export class ApiError extends Error {
+  constructor(status, body) {
+    super(body && body.message ? body.message : "HTTP " + status);
+    this.name = "ApiError";
+    this.status = status;
+    this.body = body;
+  }
+}
+
+function buildUrl(client, path, query, withKey) {
+  const params = new URLSearchParams();
+  for (const [key, value] of Object.entries(query || {})) {
+    if (value !== undefined && value !== null) {
+      params.set(key, String(value));
+    }
+  }
+  if (withKey && client.options.apiKey) {
+    params.set("api_key", client.options.apiKey);
+  }
+  const search = params.toString();
+  return client.baseUrl + path + (search ? "?" + search : "");
+}
+
+async function send(client, method, path, query, body, contentType) {
+  const headers = {};
+  if (client.options.apiKey) {
+    headers["X-API-Key"] = client.options.apiKey;
+  }
+  if (client.options.adminToken) {
+    headers["Authorization"] = "Bearer " + client.options.adminToken;
+  }
+  let payload;
+  if (body !== undefined) {
+    headers["Content-Type"] = contentType || "application/json";
+    payload = contentType ? body : JSON.stringify(body);
+  }
+  const fetchImpl = client.options.fetch || globalThis.fetch;
+  const res = await fetchImpl(buildUrl(client, path, query, false), { method, headers, body: payload });
+  if (!res.ok) {
+    let error;
+    try {
+      error = await res.json();
+    } catch {
+      error = undefined;
+    }
+    throw new ApiError(res.status, error);
+  }
+  return res;
+}
+
+export class TrackerClient {
+  constructor(options) {
+    this.options = options;
+    this.baseUrl = options.baseUrl.replace(/\/+$/, "");
+  }
+`)
+	for _, m := range methods {
+		b.WriteString("\n")
+		if m.summary != "" {
+			fmt.Fprintf(&b, "  /** %s */\n", strings.ReplaceAll(m.summary, "*/", "*\\/"))
+		}
+		query, body, contentType := "undefined", "undefined", ""
+		if len(m.query) > 0 {
+			query = "query"
+		}
+		if m.body != "" {
+			body = "body"
+		}
+		if m.bodyType != "" {
+			contentType = ", " + strconv.Quote(m.bodyType)
+		}
+		call := fmt.Sprintf("send(this, %q, %s, %s, %s%s)", m.method, m.pathExpr(), query, body, contentType)
+		switch m.result {
+		case "stream":
+			fmt.Fprintf(&b, "  %s(%s) {\n    return buildUrl(this, %s, %s, true);\n  }\n", m.name, m.signature(false), m.pathExpr(), query)
+		case "json":
+			fmt.Fprintf(&b, "  async %s(%s) {\n    return (await %s).json();\n  }\n", m.name, m.signature(false), call)
+		case "raw":
+			fmt.Fprintf(&b, "  %s(%s) {\n    return %s;\n  }\n", m.name, m.signature(false), call)
+		default:
+			fmt.Fprintf(&b, "  async %s(%s) {\n    await %s;\n  }\n", m.name, m.signature(false), call)
+		}
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// sdkFiles renders the npm package of the client for an OpenAPI document,
+// versioned version or, when empty, like the document.
+func sdkFiles(spec []byte, version string) (map[string][]byte, error) {
+	var doc oaDocument
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("parse OpenAPI document: %w", err)
+	}
+	if version == "" {
+		version = doc.Info.Version
+	}
+	methods, err := sdkMethods(&doc)
+	if err != nil {
+		return nil, err
+	}
+	pkg, _ := json.MarshalIndent(map[string]interface{}{
+		"name":        sdkPackageName,
+		"version":     version,
+		"description": "TypeScript client for the " + doc.Info.Title + ", generated from its OpenAPI document.",
+		"type":        "module",
+		"main":        "index.js",
+		"types":       "index.d.ts",
+		"files":       []string{"index.js", "index.d.ts", "openapi.json", "README.md"},
+		"license":     "MIT",
+	}, "", "  ")
+	readme := fmt.Sprintf("# %s\n\nTypeScript client for the %s %s, generated from `openapi.json`. Do not edit it by hand: regenerate it with `make sdk` or download it from `GET /sdk/typescript.tgz`.\n\n"+
+		"```ts\nimport { TrackerClient, ApiError } from \"%s\";\n\n"+
+		"const tracker = new TrackerClient({ baseUrl: \"https://tracker.example\", apiKey: \"...\" });\n"+
+		"const events = await tracker.listTransactions({ chain: \"ethereum\", limit: 25 });\n"+
+		"const live = new EventSource(tracker.subscribeEvents({ payload: \"compact\" }));\n```\n\n"+
+		"Every method resolves to the decoded JSON response, or to the fetch `Response` for downloads, and rejects with an `ApiError` carrying the status and the API's `ErrorResponse`. Stream methods return the URL to open with `EventSource`.\n",
+		sdkPackageName, doc.Info.Title, version, sdkPackageName)
+	return map[string][]byte{
+		"package.json": append(pkg, '\n'),
+		"index.js":     renderSDKModule(&doc, methods),
+		"index.d.ts":   renderSDKDeclarations(&doc, methods),
+		"openapi.json": spec,
+		"README.md":    []byte(readme),
+	}, nil
+}
+
+// writeSDKTarball writes files as an npm package tarball, under package/
+// and in name order.
+func writeSDKTarball(out io.Writer, files map[string][]byte) error {
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hdr := &tar.Header{Name: "package/" + name, Mode: 0o644, Size: int64(len(files[name])), ModTime: sdkModTime, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// buildSDK renders the client of the running API as a tarball.
+func buildSDK(version string) ([]byte, error) {
+	files, err := sdkFiles(openAPIDocument(), version)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeSDKTarball(&buf, files); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var (
+	sdkOnce    sync.Once
+	sdkTarball []byte
+	sdkErr     error
+)
+
+// serveSDK serves the TypeScript client of the running API, built once.
+func serveSDK(w http.ResponseWriter, r *http.Request) {
+	sdkOnce.Do(func() { sdkTarball, sdkErr = buildSDK("") })
+	if sdkErr != nil {
+		httpError(w, "could not build the client", http.StatusInternalServerError)
+		return
+	}
+	header := make(http.Header)
+	header.Set("Content-Type", "application/gzip")
+	header.Set("Content-Disposition", `attachment; filename="tracker-client.tgz"`)
+	writeWithETag(w, r, header, sdkTarball)
+}
+
+// runSDK implements `api sdk [-version v] [-o file]`, writing the
+// TypeScript client tarball to file or stdout, and returns the exit code.
+func runSDK(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("sdk", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	version := fs.String("version", "", "package version (default: the OpenAPI document's)")
+	output := fs.String("o", "", "tarball path (default: stdout)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	tarball, err := buildSDK(*version)
+	if err == nil && *output != "" {
+		err = os.WriteFile(*output, tarball, 0o644)
+	} else if err == nil {
+		_, err = stdout.Write(tarball)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "sdk: %v\n", err)
+		return 1
+	}
+	return 0
+}
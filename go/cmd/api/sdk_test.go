@@ -0,0 +1,134 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const sdkTestSpec = `{
+  "info": {"title": "Test API", "version": "1.0.0"},
+  "paths": {
+    "/items/{item_id}": {
+      "get": {"operationId": "getItem", "summary": "One item",
+        "parameters": [{"name": "item_id", "in": "path"}, {"name": "view", "in": "query", "description": "Field set.", "schema": {"type": "string", "enum": ["full", "compact"]}}],
+        "responses": {"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Item"}}}},
+          "404": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/ErrorResponse"}}}}}},
+      "delete": {"operationId": "deleteItem", "responses": {"204": {}}}
+    },
+    "/items": {
+      "post": {"operationId": "createItem",
+        "requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Item"}}}},
+        "responses": {"201": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Item"}}}},
+          "202": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Job"}}}}}}
+    },
+    "/items.csv": {"get": {"operationId": "exportItems", "responses": {"200": {"content": {"text/csv": {"schema": {"type": "string"}}}}}}},
+    "/items/live": {"get": {"operationId": "subscribeItems", "parameters": [{"name": "since", "in": "query", "schema": {"type": "integer"}}],
+      "responses": {"200": {"content": {"text/event-stream": {"schema": {"type": "string"}}}}}}}
+  },
+  "components": {"schemas": {
+    "ErrorResponse": {"type": "object", "properties": {"code": {"type": "string"}, "message": {"type": "string"}}, "required": ["code", "message"]},
+    "Item": {"type": "object", "properties": {"id": {"type": "string"}, "tags": {"type": "array", "items": {"type": "string", "enum": ["a", "b"]}},
+      "counts": {"type": "object", "additionalProperties": {"type": "integer"}}, "content-type": {"type": "string"}}, "required": ["id"]},
+    "Job": {"allOf": [{"$ref": "#/components/schemas/Item"}, {"type": "object", "properties": {"done": {"type": "boolean"}}}]}
+  }}
+}`
+
+func TestSDKFiles(t *testing.T) {
+	files, err := sdkFiles([]byte(sdkTestSpec), "2.3.4")
+	if err != nil {
+		t.Fatalf("sdkFiles: %v", err)
+	}
+	dts, js := string(files["index.d.ts"]), string(files["index.js"])
+	for _, want := range []string{
+		"export interface Item {\n  \"content-type\"?: string;\n  counts?: Record<string, number>;\n  id: string;\n  tags?: (\"a\" | \"b\")[];\n}",
+		"export type Job = Item & { done?: boolean; };",
+		"export interface GetItemQuery {\n  /** Field set. */\n  view?: \"full\" | \"compact\";\n}",
+		"createItem(body: Item): Promise<Item | Job>;",
+		"deleteItem(itemId: string): Promise<void>;",
+		"exportItems(): Promise<Response>;",
+		"getItem(itemId: string, query?: GetItemQuery): Promise<Item>;",
+		"subscribeItems(query?: SubscribeItemsQuery): string;",
+	} {
+		if !strings.Contains(dts, want) {
+			t.Errorf("index.d.ts lacks %q:\n%s", want, dts)
+		}
+	}
+	for _, want := range []string{
+		"return (await send(this, \"GET\", `/items/${encodeURIComponent(itemId)}`, query, undefined)).json();",
+		"await send(this, \"DELETE\", `/items/${encodeURIComponent(itemId)}`, undefined, undefined);",
+		"return send(this, \"GET\", `/items.csv`, undefined, undefined);",
+		"return buildUrl(this, `/items/live`, query, true);",
+	} {
+		if !strings.Contains(js, want) {
+			t.Errorf("index.js lacks %q:\n%s", want, js)
+		}
+	}
+	if !strings.Contains(string(files["package.json"]), `"version": "2.3.4"`) {
+		t.Errorf("package.json = %s", files["package.json"])
+	}
+
+	if _, err := sdkFiles([]byte(`{"paths": {"/x": {"get": {}}}}`), ""); err == nil {
+		t.Error("expected an error for an operation without operationId")
+	}
+}
+
+func TestSDKTarballIsReproducible(t *testing.T) {
+	files, err := sdkFiles([]byte(sdkTestSpec), "")
+	if err != nil {
+		t.Fatalf("sdkFiles: %v", err)
+	}
+	var first, second bytes.Buffer
+	if err := writeSDKTarball(&first, files); err != nil {
+		t.Fatalf("tarball: %v", err)
+	}
+	if err := writeSDKTarball(&second, files); err != nil {
+		t.Fatalf("tarball: %v", err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Fatal("tarballs of the same document differ")
+	}
+
+	gz, err := gzip.NewReader(&first)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar: %v", err)
+		}
+		names = append(names, hdr.Name)
+		if body, _ := io.ReadAll(tr); !bytes.Equal(body, files[strings.TrimPrefix(hdr.Name, "package/")]) {
+			t.Errorf("%s does not match its file", hdr.Name)
+		}
+	}
+	if got := strings.Join(names, ","); got != "package/README.md,package/index.d.ts,package/index.js,package/openapi.json,package/package.json" {
+		t.Fatalf("entries = %s", got)
+	}
+}
+
+func TestServeSDK(t *testing.T) {
+	r := httptest.NewRecorder()
+	serveSDK(r, httptest.NewRequest(http.MethodGet, "/sdk/typescript.tgz", nil))
+	if r.Code != http.StatusOK || r.Header().Get("Content-Type") != "application/gzip" || r.Header().Get("ETag") == "" {
+		t.Fatalf("status %d, headers %v", r.Code, r.Header())
+	}
+	req := httptest.NewRequest(http.MethodGet, "/sdk/typescript.tgz", nil)
+	req.Header.Set("If-None-Match", r.Header().Get("ETag"))
+	r = httptest.NewRecorder()
+	serveSDK(r, req)
+	if r.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", r.Code)
+	}
+}
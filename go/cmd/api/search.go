@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Search result types.
+const (
+	SearchEvent  = "event"
+	SearchWallet = "wallet"
+	SearchToken  = "token"
+)
+
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+	// minSearchLength keeps one or two characters from matching most of
+	// the table.
+	minSearchLength = 3
+	// searchScanLimit is how many of the newest matching events a search
+	// derives its wallet and token results from.
+	searchScanLimit = 500
+)
+
+// searchSQL is the EventFilter.Search predicate, formatted with the
+// placeholder prefix and index of the containing pattern, then of the
+// prefix pattern. Every LOWER() expression has a trigram index in Postgres.
+const searchSQL = ` AND (LOWER(tx_hash) LIKE %[1]s%[2]d ESCAPE '\'` +
+	` OR LOWER(from_addr) LIKE %[3]s%[4]d ESCAPE '\' OR LOWER(to_addr) LIKE %[3]s%[4]d ESCAPE '\'` +
+	` OR LOWER(token_symbol) LIKE %[3]s%[4]d ESCAPE '\' OR LOWER(token_address) LIKE %[3]s%[4]d ESCAPE '\')`
+
+// searchPatterns returns the LIKE patterns of searchSQL for fragment: one
+// matching it anywhere, for tx hashes, and one matching it as a prefix.
+func searchPatterns(fragment string) (contains, prefix string) {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(fragment))
+	return "%" + escaped + "%", escaped + "%"
+}
+
+// hasFold reports whether s starts with fragment, or contains it when
+// anywhere is set, ignoring case.
+func hasFold(s, fragment string, anywhere bool) bool {
+	s, fragment = strings.ToLower(s), strings.ToLower(fragment)
+	if anywhere {
+		return strings.Contains(s, fragment)
+	}
+	return strings.HasPrefix(s, fragment)
+}
+
+// matchesSearch is searchSQL for the in-memory backend.
+func matchesSearch(ev *Event, fragment string) bool {
+	if hasFold(ev.TxHash, fragment, true) || hasFold(ev.From, fragment, false) || hasFold(ev.To, fragment, false) {
+		return true
+	}
+	return ev.Token != nil && (hasFold(ev.Token.Symbol, fragment, false) || hasFold(ev.Token.Address, fragment, false))
+}
+
+// SearchResult is one match of a search. Event results carry the event
+// whose tx hash contains the fragment; wallet results an address that
+// starts with it or whose label contains it; token results a token whose
+// symbol or contract starts with it.
+type SearchResult struct {
+	Type    string `json:"type"`
+	Chain   string `json:"chain,omitempty"`
+	Network string `json:"network,omitempty"`
+	// Address is the wallet's, or the token's contract or mint.
+	Address string `json:"address,omitempty"`
+	Symbol  string `json:"symbol,omitempty"`
+	Label   *Label `json:"label,omitempty"`
+	Event   *Event `json:"event,omitempty"`
+}
+
+// SearchResponse lists the events, then wallets, then tokens matching q.
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Results []SearchResult `json:"results"`
+}
+
+// Search finds what fragment identifies among the events matching filter
+// and the labels, at most limit results of each type.
+func Search(store *EventStore, labels *LabelStore, fragment string, filter EventFilter, limit int) []SearchResult {
+	filter.Search, filter.Limit, filter.Offset = fragment, searchScanLimit, 0
+	events := store.Enrich(store.GetRecent(filter))
+
+	var matched, wallets, tokens []SearchResult
+	walletAt := make(map[string]int)
+	tokenSeen := make(map[tokenKey]bool)
+	addWallet := func(chain, address string, label *Label) {
+		key := addressKey(address)
+		if i, ok := walletAt[key]; ok {
+			// The same key on several chains, as EVM addresses are
+			if chain != "" && wallets[i].Chain != chain {
+				wallets[i].Chain = ""
+			}
+			return
+		}
+		if label == nil {
+			if l, ok := labels.Get(filter.Tenant, address); ok {
+				label = &l
+			}
+		}
+		walletAt[key] = len(wallets)
+		wallets = append(wallets, SearchResult{Type: SearchWallet, Chain: chain, Address: address, Label: label})
+	}
+	for _, ev := range events {
+		if hasFold(ev.TxHash, fragment, true) && len(matched) < limit {
+			matched = append(matched, SearchResult{Type: SearchEvent, Chain: ev.Chain, Network: ev.Network, Event: ev})
+		}
+		for _, address := range []string{ev.From, ev.To} {
+			if address != "" && hasFold(address, fragment, false) {
+				addWallet(ev.Chain, address, nil)
+			}
+		}
+		if ev.Token == nil || !hasFold(ev.Token.Symbol, fragment, false) && !hasFold(ev.Token.Address, fragment, false) {
+			continue
+		}
+		key := tokenAddressKey(ev.Chain, ev.Network, ev.Token.Address)
+		if !tokenSeen[key] {
+			tokenSeen[key] = true
+			tokens = append(tokens, SearchResult{Type: SearchToken, Chain: ev.Chain, Network: ev.Network, Address: ev.Token.Address, Symbol: ev.Token.Symbol})
+		}
+	}
+	// Labeled wallets match by name too, whether or not they have events
+	for _, l := range labels.List(filter.Tenant, "") {
+		if hasFold(l.Name, fragment, true) || hasFold(l.Address, fragment, false) {
+			l := l
+			addWallet("", l.Address, &l)
+		}
+	}
+
+	out := make([]SearchResult, 0, len(matched)+2*limit)
+	out = append(out, matched...)
+	out = append(out, capResults(wallets, limit)...)
+	return append(out, capResults(tokens, limit)...)
+}
+
+func capResults(results []SearchResult, limit int) []SearchResult {
+	if len(results) > limit {
+		return results[:limit]
+	}
+	return results
+}
+
+// search handles GET /search: a tx hash fragment, address prefix, token
+// symbol or label pasted from an explorer, across chains.
+func search(store *EventStore, labels *LabelStore, w http.ResponseWriter, r *http.Request) {
+	p := newQueryParams(r)
+	q := p.String("q")
+	limit := p.Int("limit", defaultSearchLimit, 1, maxSearchLimit)
+	chain, network := p.Network()
+	filter := EventFilter{
+		Chain:   chain,
+		Network: network,
+		Tenant:  tenantFrom(r.Context()),
+	}
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	if len(q) < minSearchLength {
+		badRequest(w, invalidParam("q", "q must be at least %d characters", minSearchLength))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(SearchResponse{Query: q, Results: Search(store, labels, q, filter, limit)})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func searchFixtures() []*Event {
+	transfer := makeEvent("a", aliceAddr, bobAddr, "5", "2025-10-14T10:00:00Z", "USDC")
+	transfer.TxHash = testEVMHash
+	transfer.Token.Address = "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	solana := makeEvent("b", "9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM", "HN7cABqLq46Es1jh92dQQisAq662SmxELLLsHHe4YWrH", "7", "2025-10-14T11:00:00Z", "")
+	solana.TxHash = testSolSig
+	return []*Event{transfer, solana}
+}
+
+func TestSearch(t *testing.T) {
+	store := NewEventStore(100, 50)
+	for _, ev := range searchFixtures() {
+		store.Add(ev)
+	}
+	labels := NewLabelStore()
+	if _, err := labels.Put(context.Background(), Label{Address: bobAddr, Name: "Binance Hot Wallet", Category: LabelExchange}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		q    string
+		want []string // type:address or type:event_id
+	}{
+		{strings.ToUpper(testEVMHash[20:32]), []string{"event:a"}},
+		{testSolSig[:12], []string{"event:b"}},
+		{"0xA11CE", []string{"wallet:" + aliceAddr}},
+		{"9WzDX", []string{"wallet:9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM"}},
+		{"binance", []string{"wallet:" + bobAddr}},
+		{"usd", []string{"token:0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"}},
+		{"nothing", nil},
+	}
+	for _, tc := range tests {
+		var got []string
+		for _, r := range Search(store, labels, tc.q, EventFilter{}, defaultSearchLimit) {
+			if r.Type == SearchEvent {
+				got = append(got, r.Type+":"+r.Event.EventID)
+			} else {
+				got = append(got, r.Type+":"+r.Address)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("Search(%q) = %v, want %v", tc.q, got, tc.want)
+		}
+	}
+
+	results := Search(store, labels, "0xb0b", EventFilter{}, defaultSearchLimit)
+	if len(results) != 1 || results[0].Label == nil || results[0].Label.Name != "Binance Hot Wallet" || results[0].Chain != "ethereum" {
+		t.Fatalf("labeled wallet = %+v", results)
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	store := NewEventStore(100, 50)
+	for _, ev := range searchFixtures() {
+		store.Add(ev)
+	}
+	rec := httptest.NewRecorder()
+	search(store, NewLabelStore(), rec, httptest.NewRequest("GET", "/search?q=ab", nil))
+	if rec.Code != 400 {
+		t.Fatalf("short query: status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	search(store, NewLabelStore(), rec, httptest.NewRequest("GET", "/search?q="+testEVMHash[:10]+"&chain=solana", nil))
+	var resp SearchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != 200 || resp.Results == nil || len(resp.Results) != 0 {
+		t.Fatalf("other chain: status %d, %+v", rec.Code, resp)
+	}
+}
+
+func TestSQLiteRepositorySearch(t *testing.T) {
+	ctx := context.Background()
+	repo, err := OpenSQLiteRepository(ctx, filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer repo.Close()
+	if err := repo.InsertBatch(ctx, searchFixtures()); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	for q, want := range map[string]int{
+		strings.ToUpper(testEVMHash[20:32]): 1,
+		"usdc":                              1,
+		"hn7cab":                            1,
+		// The middle of an address is not a prefix
+		aliceAddr[4:12]: 0,
+		// LIKE wildcards match literally
+		"0x%": 0,
+		"___": 0,
+	} {
+		events, err := repo.Recent(ctx, EventFilter{Search: q})
+		if err != nil || len(events) != want {
+			t.Errorf("Search %q: %d events, %v; want %d", q, len(events), err, want)
+		}
+	}
+}
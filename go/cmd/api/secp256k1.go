@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strconv"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// The secp256k1 curve y² = x³ + 7 over the field of secpP, with generator
+// (secpGx, secpGy) of order secpN.
+var (
+	secpP, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	secpN, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	secpGx, _ = new(big.Int).SetString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", 16)
+	secpGy, _ = new(big.Int).SetString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", 16)
+)
+
+// errBadSignature is returned for signatures that are malformed or were
+// not made by the expected key.
+var errBadSignature = errors.New("signature does not match the address")
+
+// secpPoint is an affine point of secp256k1; a nil x is the point at
+// infinity. Recovery runs once per ownership proof, so the arithmetic
+// favors brevity over speed and is not constant-time, which is fine as it
+// only handles public values.
+type secpPoint struct{ x, y *big.Int }
+
+func (a secpPoint) add(b secpPoint) secpPoint {
+	switch {
+	case a.x == nil:
+		return b
+	case b.x == nil:
+		return a
+	case a.x.Cmp(b.x) == 0:
+		if a.y.Cmp(b.y) != 0 || a.y.Sign() == 0 {
+			return secpPoint{}
+		}
+		return a.double()
+	}
+	den := new(big.Int).Sub(b.x, a.x)
+	den.ModInverse(den.Mod(den, secpP), secpP)
+	lambda := new(big.Int).Sub(b.y, a.y)
+	lambda.Mul(lambda, den)
+	return a.withSlope(lambda.Mod(lambda, secpP), b.x)
+}
+
+func (a secpPoint) double() secpPoint {
+	if a.x == nil || a.y.Sign() == 0 {
+		return secpPoint{}
+	}
+	lambda := new(big.Int).Mul(a.x, a.x)
+	lambda.Mul(lambda, big.NewInt(3))
+	lambda.Mul(lambda, new(big.Int).ModInverse(new(big.Int).Lsh(a.y, 1), secpP))
+	return a.withSlope(lambda.Mod(lambda, secpP), a.x)
+}
+
+// withSlope returns the third point on the line of slope lambda through a
+// and the point of abscissa bx, reflected.
+func (a secpPoint) withSlope(lambda, bx *big.Int) secpPoint {
+	x := new(big.Int).Mul(lambda, lambda)
+	x.Sub(x, a.x).Sub(x, bx).Mod(x, secpP)
+	y := new(big.Int).Sub(a.x, x)
+	y.Mul(y, lambda).Sub(y, a.y).Mod(y, secpP)
+	return secpPoint{x, y}
+}
+
+func (a secpPoint) mul(k *big.Int) secpPoint {
+	var out secpPoint
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		out = out.double()
+		if k.Bit(i) == 1 {
+			out = out.add(a)
+		}
+	}
+	return out
+}
+
+// eip191Hash is the Keccak-256 hash wallets sign for personal_sign: the
+// message behind the "\x19Ethereum Signed Message:\n" prefix and its
+// length.
+func eip191Hash(message string) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte("\x19Ethereum Signed Message:\n" + strconv.Itoa(len(message)) + message))
+	return h.Sum(nil)
+}
+
+// ecrecoverAddress returns the EIP-55 address of the key that made sig, a
+// 65-byte r || s || v signature with v 0, 1, 27 or 28, over hash.
+func ecrecoverAddress(hash, sig []byte) (string, error) {
+	if len(sig) != 65 {
+		return "", errBadSignature
+	}
+	v := sig[64]
+	if v >= 27 {
+		v -= 27
+	}
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:64])
+	if v > 1 || r.Sign() == 0 || s.Sign() == 0 || r.Cmp(secpN) >= 0 || s.Cmp(secpN) >= 0 {
+		return "", errBadSignature
+	}
+
+	// R is the curve point of abscissa r whose ordinate has the parity v
+	y2 := new(big.Int).Exp(r, big.NewInt(3), secpP)
+	y2.Add(y2, big.NewInt(7)).Mod(y2, secpP)
+	y := new(big.Int).Exp(y2, new(big.Int).Rsh(new(big.Int).Add(secpP, big.NewInt(1)), 2), secpP)
+	if new(big.Int).Exp(y, big.NewInt(2), secpP).Cmp(y2) != 0 {
+		return "", errBadSignature
+	}
+	if y.Bit(0) != uint(v) {
+		y.Sub(secpP, y)
+	}
+
+	// Q = r⁻¹(sR - eG)
+	rInv := new(big.Int).ModInverse(r, secpN)
+	e := new(big.Int).SetBytes(hash)
+	u1 := new(big.Int).Neg(e)
+	u1.Mul(u1, rInv).Mod(u1, secpN)
+	u2 := new(big.Int).Mul(s, rInv)
+	u2.Mod(u2, secpN)
+	q := secpPoint{secpGx, secpGy}.mul(u1).add(secpPoint{r, y}.mul(u2))
+	if q.x == nil {
+		return "", errBadSignature
+	}
+
+	pub := make([]byte, 64)
+	q.x.FillBytes(pub[:32])
+	q.y.FillBytes(pub[32:])
+	h := sha3.NewLegacyKeccak256()
+	h.Write(pub)
+	return checksumAddress("0x" + hex.EncodeToString(h.Sum(nil)[12:])), nil
+}
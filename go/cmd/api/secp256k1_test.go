@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// signEIP191 signs message with the secp256k1 key d, as personal_sign
+// does, using nonce k.
+func signEIP191(d, k *big.Int, message string) string {
+	return signSecp256k1(d, k, eip191Hash(message))
+}
+
+// signSecp256k1 signs hash with the secp256k1 key d and nonce k, returning
+// r || s || v in hex.
+func signSecp256k1(d, k *big.Int, hash []byte) string {
+	e := new(big.Int).SetBytes(hash)
+	rp := secpPoint{secpGx, secpGy}.mul(k)
+	r := new(big.Int).Mod(rp.x, secpN)
+	s := new(big.Int).Mul(r, d)
+	s.Add(s, e).Mul(s, new(big.Int).ModInverse(k, secpN)).Mod(s, secpN)
+	sig := make([]byte, 65)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:64])
+	sig[64] = 27 + byte(rp.y.Bit(0))
+	return "0x" + hex.EncodeToString(sig)
+}
+
+// secpAddress returns the EIP-55 address of the secp256k1 key d.
+func secpAddress(d *big.Int) string {
+	pub := secpPoint{secpGx, secpGy}.mul(d)
+	b := make([]byte, 64)
+	pub.x.FillBytes(b[:32])
+	pub.y.FillBytes(b[32:])
+	h := sha3.NewLegacyKeccak256()
+	h.Write(b)
+	return checksumAddress("0x" + hex.EncodeToString(h.Sum(nil)[12:]))
+}
+
+func TestEcrecoverAddress(t *testing.T) {
+	// personal_sign of "Some data" by 0x2c7536E3605D9C16a7a3D7b1898e529396a65c23
+	sig, _ := hex.DecodeString("b91467e570a6466aa9e9876cbcd013baba02900b8979d43fe208a4a4f339f5fd6007e74cd82e037b800186422fc2da167c747ef045e5d18a5f5d4300f8e1a0291c")
+	if got := hex.EncodeToString(eip191Hash("Some data")); got != "1da44b586eb0729ff70a73c326926f6ed5a25f5b056e7f47fbc6e58d86871655" {
+		t.Fatalf("hash = %s", got)
+	}
+	address, err := ecrecoverAddress(eip191Hash("Some data"), sig)
+	if err != nil || address != "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23" {
+		t.Fatalf("recovered %s, %v", address, err)
+	}
+
+	a := chainAdapter("ethereum")
+	if err := a.VerifyMessage("0x2c7536e3605d9c16a7a3d7b1898e529396a65c23", "Some data", hex.EncodeToString(sig)); err != nil {
+		t.Fatalf("valid signature: %v", err)
+	}
+	if err := a.VerifyMessage("0x2c7536E3605D9C16a7a3D7b1898e529396a65c23", "Other data", hex.EncodeToString(sig)); err == nil {
+		t.Fatal("signature of another message accepted")
+	}
+	for _, bad := range []string{"", "0x1234", "zz", "0x" + hex.EncodeToString(append(sig[:64:64], 29))} {
+		if err := a.VerifyMessage("0x2c7536E3605D9C16a7a3D7b1898e529396a65c23", "Some data", bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+
+	d, k := big.NewInt(0xC0FFEE), big.NewInt(0xBEEF)
+	if err := a.VerifyMessage(secpAddress(d), "hello", signEIP191(d, k, "hello")); err != nil {
+		t.Fatalf("test signer: %v", err)
+	}
+}
+
+func TestTronVerifyMessage(t *testing.T) {
+	d, k := big.NewInt(0xC0FFEE), big.NewInt(0xBEEF)
+	key, _ := hex.DecodeString(secpAddress(d)[2:])
+	address := tronAddress(key)
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte("\x19TRON Signed Message:\n5hello"))
+	sig := signSecp256k1(d, k, h.Sum(nil))
+
+	a := chainAdapter("tron")
+	if err := a.VerifyMessage(address, "hello", sig); err != nil {
+		t.Fatalf("valid signature: %v", err)
+	}
+	if err := a.VerifyMessage(address, "hello", signEIP191(d, k, "hello")); err == nil {
+		t.Fatal("Ethereum-prefixed signature accepted")
+	}
+	if err := a.VerifyMessage(tronUSDT, "hello", sig); err == nil {
+		t.Fatal("signature by another key accepted")
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultSequenceInterval is how often watched wallets are checked for
+	// missed transactions, overridable with SEQUENCE_CHECK_INTERVAL.
+	defaultSequenceInterval = 5 * time.Minute
+	// sequenceHistory is how many of a wallet's latest events a check
+	// reads to find the nonces already captured.
+	sequenceHistory = 500
+	// sequenceSignatureLimit is how many recent signatures a check asks a
+	// Solana node for.
+	sequenceSignatureLimit = 100
+	// backfillQueueKey is the Redis list backfill jobs take requests from.
+	backfillQueueKey = "cross_chain_backfill_requests"
+)
+
+// Reasons of backfill requests.
+const (
+	BackfillNonceGap          = "nonce_gap"
+	BackfillMissingSignatures = "missing_signatures"
+)
+
+// BackfillRequest asks the backfill jobs to index transactions of a watched
+// wallet the listener missed. EVM gaps name the missing nonces and, when
+// known, the blocks between which they were mined; Solana gaps name the
+// missing signatures.
+type BackfillRequest struct {
+	ID          string   `json:"id"`
+	Chain       string   `json:"chain"`
+	Network     string   `json:"network"`
+	Address     string   `json:"address"`
+	Reason      string   `json:"reason"`
+	Nonces      []uint64 `json:"nonces,omitempty"`
+	FromBlock   uint64   `json:"from_block,omitempty"`
+	ToBlock     uint64   `json:"to_block,omitempty"`
+	Signatures  []string `json:"signatures,omitempty"`
+	RequestedAt string   `json:"requested_at"`
+}
+
+// backfillQueue hands backfill requests to the backfill jobs;
+// redisBackfillQueue is the production implementation.
+type backfillQueue interface {
+	Enqueue(ctx context.Context, req BackfillRequest) error
+}
+
+type redisBackfillQueue struct{ rdb *redis.Client }
+
+func (q redisBackfillQueue) Enqueue(ctx context.Context, req BackfillRequest) error {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return q.rdb.LPush(ctx, backfillQueueKey, payload).Err()
+}
+
+// SequenceWallet is a wallet whose transactions are checked for gaps on one
+// chain and network.
+type SequenceWallet struct {
+	Chain   string `json:"chain"`
+	Network string `json:"network"`
+	Address string `json:"address"`
+}
+
+// ParseSequenceWallets parses a comma-separated list of
+// chain:network=address entries, e.g. "ethereum:mainnet=0xabc...".
+func ParseSequenceWallets(spec string) ([]SequenceWallet, error) {
+	var out []SequenceWallet
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pair, address, ok := strings.Cut(item, "=")
+		parts := strings.Split(pair, ":")
+		if !ok || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid sequence wallet %q: want chain:network=address", item)
+		}
+		chain := strings.ToLower(parts[0])
+		canonical, err := canonicalAddress(chain, strings.TrimSpace(address))
+		if err != nil {
+			return nil, fmt.Errorf("invalid sequence wallet %q: %v", item, err)
+		}
+		out = append(out, SequenceWallet{Chain: chain, Network: strings.ToLower(parts[1]), Address: canonical})
+	}
+	return out, nil
+}
+
+// SequenceStatus is what the tracker knows about one watched wallet.
+type SequenceStatus struct {
+	SequenceWallet
+	// AccountNonce is the EVM account nonce at the last check and
+	// NextNonce the lowest nonce not checked yet.
+	AccountNonce *uint64 `json:"account_nonce,omitempty"`
+	NextNonce    *uint64 `json:"next_nonce,omitempty"`
+	// CheckedSlot is the Solana slot up to which signatures were checked.
+	CheckedSlot *uint64 `json:"checked_slot,omitempty"`
+	// Missing counts the transactions found missing since start, and
+	// Backfills the requests enqueued for them.
+	Missing   int    `json:"missing"`
+	Backfills int    `json:"backfills"`
+	CheckedAt string `json:"checked_at,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// walletSequence is the tracker's state for one wallet. A transaction is
+// only checked one interval after it shows up on chain, giving the
+// listener time to deliver it, and only once.
+type walletSequence struct {
+	SequenceWallet
+	started bool
+	// EVM: nonces below next were checked; those below accountNonce were
+	// mined by the last check.
+	next, accountNonce uint64
+	// Solana: signatures up to checkedSlot were checked; those up to
+	// lastSlot were seen by the last check.
+	checkedSlot, lastSlot uint64
+	missing, backfills    int
+	status                SequenceStatus
+}
+
+// snapshot returns the status of w after a check that ended with err.
+func (w *walletSequence) snapshot(err error) SequenceStatus {
+	s := SequenceStatus{SequenceWallet: w.SequenceWallet, Missing: w.missing, Backfills: w.backfills,
+		CheckedAt: time.Now().UTC().Format(time.RFC3339)}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	if w.started && w.Chain == "solana" {
+		slot := w.checkedSlot
+		s.CheckedSlot = &slot
+	} else if w.started {
+		next, nonce := w.next, w.accountNonce
+		s.NextNonce, s.AccountNonce = &next, &nonce
+	}
+	return s
+}
+
+// SequenceTracker finds transactions of watched wallets the listener
+// missed, by comparing account nonces (EVM) and recent signatures (Solana)
+// from the chain's RPC provider with the stored events, and enqueues
+// targeted backfills for them.
+type SequenceTracker struct {
+	store     *EventStore
+	endpoints rpcEndpoints
+	client    *http.Client
+	queue     backfillQueue
+	events    *SystemEvents
+	interval  time.Duration
+	wallets   []*walletSequence
+
+	// checking serializes checks and wallet changes, which own the
+	// wallets' state; mu guards the list and their published status.
+	checking sync.Mutex
+	mu       sync.Mutex
+}
+
+// NewSequenceTracker returns a tracker for wallets, checking every
+// interval. events may be nil.
+func NewSequenceTracker(store *EventStore, endpoints rpcEndpoints, queue backfillQueue, events *SystemEvents, wallets []SequenceWallet, interval time.Duration) *SequenceTracker {
+	if interval <= 0 {
+		interval = defaultSequenceInterval
+	}
+	t := &SequenceTracker{
+		store:     store,
+		endpoints: endpoints,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		queue:     queue,
+		events:    events,
+		interval:  interval,
+	}
+	t.SetWallets(wallets)
+	return t
+}
+
+// SetWallets replaces the watched wallets. Wallets watched before keep
+// their state, so their checks resume where they stopped; a check in
+// progress finishes first.
+func (t *SequenceTracker) SetWallets(wallets []SequenceWallet) {
+	t.checking.Lock()
+	defer t.checking.Unlock()
+	current := make(map[SequenceWallet]*walletSequence, len(t.wallets))
+	for _, w := range t.wallets {
+		current[w.SequenceWallet] = w
+	}
+	next := make([]*walletSequence, 0, len(wallets))
+	for _, sw := range wallets {
+		w, ok := current[sw]
+		if !ok {
+			w = &walletSequence{SequenceWallet: sw, status: SequenceStatus{SequenceWallet: sw}}
+		}
+		delete(current, sw)
+		next = append(next, w)
+	}
+	t.mu.Lock()
+	t.wallets = next
+	t.mu.Unlock()
+}
+
+// Run checks the wallets every interval until ctx is done.
+func (t *SequenceTracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		t.CheckAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll checks every wallet once.
+func (t *SequenceTracker) CheckAll(ctx context.Context) {
+	t.checking.Lock()
+	defer t.checking.Unlock()
+	for _, w := range t.wallets {
+		err := t.check(ctx, w)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"chain": w.Chain, "network": w.Network, "address": w.Address}).
+				Warn("sequence check failed")
+		}
+		t.mu.Lock()
+		w.status = w.snapshot(err)
+		t.mu.Unlock()
+	}
+}
+
+func (t *SequenceTracker) check(ctx context.Context, w *walletSequence) error {
+	url, ok := t.endpoints.RPCURL(w.Chain, w.Network)
+	if !ok {
+		return errNoRPC
+	}
+	call := func(ctx context.Context, method string, params ...interface{}) (json.RawMessage, error) {
+		return rpcCall(ctx, t.client, url, method, params...)
+	}
+	if w.Chain == "solana" {
+		return t.checkSignatures(ctx, call, w)
+	}
+	return t.checkNonces(ctx, call, w)
+}
+
+// eventSubmitter is the account that sent ev's transaction and whose
+// nonce it carries.
+func eventSubmitter(ev *Event) string {
+	if ev.ExecutedBy != "" {
+		return ev.ExecutedBy
+	}
+	return ev.From
+}
+
+// checkNonces flags the nonces mined by the previous check that no stored
+// event of the wallet carries.
+func (t *SequenceTracker) checkNonces(ctx context.Context, call RPCCaller, w *walletSequence) error {
+	result, err := call(ctx, "eth_getTransactionCount", w.Address, "latest")
+	if err != nil {
+		return err
+	}
+	count, err := parseHeight(result)
+	if err != nil {
+		return err
+	}
+	if !w.started {
+		// Transactions sent before the wallet was tracked are not expected
+		w.started, w.next, w.accountNonce = true, count, count
+		return nil
+	}
+
+	blocks := make(map[uint64]uint64)
+	for _, ev := range t.store.GetByWallet(w.Address, EventFilter{Chain: w.Chain, Network: w.Network, Limit: sequenceHistory}) {
+		if ev.Nonce == nil || addressKey(eventSubmitter(ev)) != addressKey(w.Address) {
+			continue
+		}
+		var block uint64
+		if ev.BlockNumber != nil {
+			block = *ev.BlockNumber
+		}
+		blocks[*ev.Nonce] = block
+	}
+	req := BackfillRequest{Reason: BackfillNonceGap}
+	for n := w.next; n < w.accountNonce; n++ {
+		if _, ok := blocks[n]; !ok {
+			req.Nonces = append(req.Nonces, n)
+		}
+	}
+	w.next, w.accountNonce = w.accountNonce, count
+	if len(req.Nonces) == 0 {
+		return nil
+	}
+	// The gap was mined between the captured transactions around it
+	first, last := req.Nonces[0], req.Nonces[len(req.Nonces)-1]
+	for n, block := range blocks {
+		if n < first && block > req.FromBlock {
+			req.FromBlock = block
+		}
+		if n > last && block > 0 && (req.ToBlock == 0 || block < req.ToBlock) {
+			req.ToBlock = block
+		}
+	}
+	return t.requestBackfill(ctx, w, req, len(req.Nonces))
+}
+
+// signatureInfo is an entry of getSignaturesForAddress.
+type signatureInfo struct {
+	Signature string          `json:"signature"`
+	Slot      uint64          `json:"slot"`
+	Err       json.RawMessage `json:"err"`
+}
+
+// checkSignatures flags the successful signatures seen by the previous
+// check that have no stored event.
+func (t *SequenceTracker) checkSignatures(ctx context.Context, call RPCCaller, w *walletSequence) error {
+	result, err := call(ctx, "getSignaturesForAddress", w.Address,
+		map[string]interface{}{"limit": sequenceSignatureLimit, "commitment": "confirmed"})
+	if err != nil {
+		return err
+	}
+	var signatures []signatureInfo
+	if err := json.Unmarshal(result, &signatures); err != nil {
+		return fmt.Errorf("unexpected getSignaturesForAddress result: %w", err)
+	}
+	latest := w.lastSlot
+	for _, s := range signatures {
+		if s.Slot > latest {
+			latest = s.Slot
+		}
+	}
+	if !w.started {
+		w.started, w.checkedSlot, w.lastSlot = true, latest, latest
+		return nil
+	}
+
+	req := BackfillRequest{Reason: BackfillMissingSignatures}
+	for _, s := range signatures {
+		failed := len(s.Err) > 0 && string(s.Err) != "null"
+		if failed || s.Slot <= w.checkedSlot || s.Slot > w.lastSlot {
+			continue
+		}
+		if len(t.store.GetByTxHash(s.Signature, w.Chain)) == 0 {
+			req.Signatures = append(req.Signatures, s.Signature)
+		}
+	}
+	w.checkedSlot, w.lastSlot = w.lastSlot, latest
+	if len(req.Signatures) == 0 {
+		return nil
+	}
+	sort.Strings(req.Signatures)
+	return t.requestBackfill(ctx, w, req, len(req.Signatures))
+}
+
+// requestBackfill enqueues req for w and reports the gap as a system event.
+func (t *SequenceTracker) requestBackfill(ctx context.Context, w *walletSequence, req BackfillRequest, missing int) error {
+	req.ID = newRandomID()
+	req.Chain, req.Network, req.Address = w.Chain, w.Network, w.Address
+	req.RequestedAt = time.Now().UTC().Format(time.RFC3339)
+	w.missing += missing
+	log.WithFields(log.Fields{"chain": w.Chain, "network": w.Network, "address": w.Address, "missing": missing}).
+		Warn("transactions missing from watched wallet")
+	if t.events != nil {
+		if err := t.events.Emit(SystemEvent{
+			Kind:    SystemIndexerGap,
+			Chain:   w.Chain,
+			Network: w.Network,
+			Message: fmt.Sprintf("%d transactions of %s were not indexed; backfill requested", missing, w.Address),
+			Data:    map[string]interface{}{"address": w.Address, "backfill_id": req.ID, "nonces": req.Nonces, "signatures": req.Signatures},
+		}); err != nil {
+			log.WithError(err).Warn("could not emit indexer gap")
+		}
+	}
+	if err := t.queue.Enqueue(ctx, req); err != nil {
+		return fmt.Errorf("could not enqueue backfill: %w", err)
+	}
+	w.backfills++
+	return nil
+}
+
+// Status returns the state of every watched wallet.
+func (t *SequenceTracker) Status() []SequenceStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]SequenceStatus, 0, len(t.wallets))
+	for _, w := range t.wallets {
+		out = append(out, w.status)
+	}
+	return out
+}
+
+// getSequenceStatus handles GET /admin/sequences.
+func getSequenceStatus(tracker *SequenceTracker, w http.ResponseWriter, r *http.Request) {
+	status := []SequenceStatus{}
+	if tracker != nil {
+		status = tracker.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type memoryBackfillQueue struct {
+	mu       sync.Mutex
+	requests []BackfillRequest
+}
+
+func (q *memoryBackfillQueue) Enqueue(_ context.Context, req BackfillRequest) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.requests = append(q.requests, req)
+	return nil
+}
+
+// sequenceRPC answers eth_getTransactionCount with *nonce and
+// getSignaturesForAddress with *signatures.
+func sequenceRPC(t *testing.T, nonce *uint64, signatures *string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode rpc request: %v", err)
+		}
+		result := "null"
+		switch req.Method {
+		case "eth_getTransactionCount":
+			result = `"0x` + strconv.FormatUint(*nonce, 16) + `"`
+		case "getSignaturesForAddress":
+			result = *signatures
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": ` + result + `}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestParseSequenceWallets(t *testing.T) {
+	got, err := ParseSequenceWallets(" Ethereum:Mainnet=" + aliceAddr + ", solana:devnet=" + wrappedSOL)
+	if err != nil || len(got) != 2 || got[0].Chain != "ethereum" || got[0].Network != "mainnet" || got[1].Address != wrappedSOL {
+		t.Fatalf("wallets = %+v, %v", got, err)
+	}
+	for _, spec := range []string{"ethereum=" + aliceAddr, "ethereum:mainnet=nope", "solana:devnet"} {
+		if _, err := ParseSequenceWallets(spec); err == nil {
+			t.Errorf("ParseSequenceWallets(%q) accepted", spec)
+		}
+	}
+}
+
+func TestSequenceTrackerFindsNonceGaps(t *testing.T) {
+	store := NewEventStore(100, 50)
+	nonce := uint64(5)
+	srv := sequenceRPC(t, &nonce, nil)
+	queue := &memoryBackfillQueue{}
+	tracker := NewSequenceTracker(store, NewRPCManager(NewChainRegistry(nil, map[string][]string{"ethereum:sepolia": {srv.URL}}), 0),
+		queue, nil, []SequenceWallet{{Chain: "ethereum", Network: "sepolia", Address: aliceAddr}}, 0)
+	sent := func(id string, n, block uint64) {
+		ev := makeEvent(id, aliceAddr, bobAddr, "1", "", "")
+		ev.Nonce, ev.BlockNumber = &n, &block
+		store.Add(ev)
+	}
+
+	// Nonces below 5 predate tracking
+	tracker.CheckAll(context.Background())
+	// 5 to 8 are mined; 6 and 7 are never captured
+	nonce = 9
+	sent("n5", 5, 100)
+	sent("n8", 8, 130)
+	// Received transfers carry the sender's nonce, not alice's
+	received := makeEvent("in", bobAddr, aliceAddr, "1", "", "")
+	received.Nonce = &nonce
+	store.Add(received)
+	// Nonces mined since the last check get an interval to arrive
+	tracker.CheckAll(context.Background())
+	if len(queue.requests) != 0 {
+		t.Fatalf("requests before the grace interval = %+v", queue.requests)
+	}
+	tracker.CheckAll(context.Background())
+	if len(queue.requests) != 1 {
+		t.Fatalf("requests = %+v", queue.requests)
+	}
+	req := queue.requests[0]
+	if req.Reason != BackfillNonceGap || len(req.Nonces) != 2 || req.Nonces[0] != 6 || req.Nonces[1] != 7 ||
+		req.FromBlock != 100 || req.ToBlock != 130 || req.Address != aliceAddr || req.ID == "" {
+		t.Fatalf("request = %+v", req)
+	}
+
+	// A gap is only requested once
+	tracker.CheckAll(context.Background())
+	status := tracker.Status()
+	if len(queue.requests) != 1 || len(status) != 1 || status[0].Missing != 2 || status[0].Backfills != 1 ||
+		*status[0].NextNonce != 9 || *status[0].AccountNonce != 9 {
+		t.Fatalf("after recheck: %d requests, status %+v", len(queue.requests), status)
+	}
+}
+
+func TestSequenceTrackerFindsMissingSignatures(t *testing.T) {
+	store := NewEventStore(100, 50)
+	signatures := `[{"signature": "old", "slot": 10, "err": null}]`
+	srv := sequenceRPC(t, nil, &signatures)
+	queue := &memoryBackfillQueue{}
+	tracker := NewSequenceTracker(store, NewRPCManager(NewChainRegistry(nil, map[string][]string{"solana:devnet": {srv.URL}}), 0),
+		queue, nil, []SequenceWallet{{Chain: "solana", Network: "devnet", Address: wrappedSOL}}, 0)
+
+	tracker.CheckAll(context.Background())
+	signatures = `[{"signature": "lost", "slot": 12, "err": null}, {"signature": "failed", "slot": 12, "err": {"InstructionError": [0, "Custom"]}},
+		{"signature": "seen", "slot": 11, "err": null}, {"signature": "old", "slot": 10, "err": null}]`
+	ev := makeEvent("seen", wrappedSOL, "", "1", "", "")
+	ev.TxHash = "seen"
+	store.Add(ev)
+	tracker.CheckAll(context.Background())
+	tracker.CheckAll(context.Background())
+	if len(queue.requests) != 1 || len(queue.requests[0].Signatures) != 1 || queue.requests[0].Signatures[0] != "lost" ||
+		queue.requests[0].Reason != BackfillMissingSignatures {
+		t.Fatalf("requests = %+v", queue.requests)
+	}
+	if status := tracker.Status(); *status[0].CheckedSlot != 12 {
+		t.Fatalf("status = %+v", status)
+	}
+
+	rec := httptest.NewRecorder()
+	getSequenceStatus(tracker, rec, httptest.NewRequest(http.MethodGet, "/admin/sequences", nil))
+	var got []SequenceStatus
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || len(got) != 1 || got[0].Missing != 1 {
+		t.Fatalf("status response = %+v, %v", got, err)
+	}
+}
+
+func TestSequenceTrackerSetWalletsKeepsState(t *testing.T) {
+	store := NewEventStore(100, 50)
+	nonce := uint64(5)
+	srv := sequenceRPC(t, &nonce, nil)
+	alice := SequenceWallet{Chain: "ethereum", Network: "sepolia", Address: aliceAddr}
+	bob := SequenceWallet{Chain: "ethereum", Network: "sepolia", Address: bobAddr}
+	tracker := NewSequenceTracker(store, NewRPCManager(NewChainRegistry(nil, map[string][]string{"ethereum:sepolia": {srv.URL}}), 0),
+		&memoryBackfillQueue{}, nil, []SequenceWallet{alice}, 0)
+	tracker.CheckAll(context.Background())
+
+	tracker.SetWallets([]SequenceWallet{bob, alice})
+	status := tracker.Status()
+	if len(status) != 2 || status[0].Address != bobAddr || status[0].NextNonce != nil {
+		t.Fatalf("status after adding bob = %+v", status)
+	}
+	if status[1].Address != aliceAddr || status[1].NextNonce == nil || *status[1].NextNonce != 5 {
+		t.Fatalf("alice's state was lost: %+v", status[1])
+	}
+	tracker.SetWallets(nil)
+	if status := tracker.Status(); len(status) != 0 {
+		t.Fatalf("status after removing every wallet = %+v", status)
+	}
+}
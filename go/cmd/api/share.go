@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultShareTTL = 7 * 24 * time.Hour
+	maxShareTTL     = 90 * 24 * time.Hour
+)
+
+// Kinds of objects a share link can expose.
+const (
+	ShareKindWallet   = "wallet"
+	ShareKindTransfer = "transfer"
+	ShareKindJourney  = "journey"
+	// ShareKindDownload tokens are the emailed download links of query
+	// jobs, served by getDownload rather than as share links.
+	ShareKindDownload = "download"
+)
+
+var (
+	errShareInvalid = errors.New("invalid share token")
+	errShareExpired = errors.New("share link expired")
+)
+
+// ShareRequest is the body of POST /share.
+type ShareRequest struct {
+	Kind       string `json:"kind"`
+	Target     string `json:"target"`
+	Chain      string `json:"chain,omitempty"`
+	Network    string `json:"network,omitempty"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+}
+
+// ShareLink is returned when a share link is created.
+type ShareLink struct {
+	Token     string `json:"token"`
+	URL       string `json:"url"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// SharedView is what an unauthenticated holder of a share link sees. Only the
+// field matching the shared kind is set.
+type SharedView struct {
+	Kind      string   `json:"kind"`
+	Target    string   `json:"target"`
+	Chain     string   `json:"chain,omitempty"`
+	Network   string   `json:"network,omitempty"`
+	ExpiresAt string   `json:"expires_at"`
+	Events    []*Event `json:"events,omitempty"`
+	Event     *Event   `json:"event,omitempty"`
+	Journey   *Journey `json:"journey,omitempty"`
+}
+
+// shareClaims is the signed payload of a share token.
+type shareClaims struct {
+	Kind    string `json:"k"`
+	Target  string `json:"t"`
+	Chain   string `json:"c,omitempty"`
+	Network string `json:"n,omitempty"`
+	// Tenant scopes the shared view to the tenant that created the link.
+	Tenant  string `json:"tn,omitempty"`
+	Expires int64  `json:"e"`
+}
+
+// ShareLinks issues and verifies stateless, HMAC-signed share tokens. Tokens
+// are valid until they expire or the signing key changes.
+type ShareLinks struct {
+	key     []byte
+	baseURL string
+}
+
+// NewShareLinks creates a share link issuer. When key is empty a random key is
+// generated, so links stop working when the process restarts.
+func NewShareLinks(key []byte, baseURL string) *ShareLinks {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.WithError(err).Fatal("could not generate share signing key")
+		}
+		log.Warn("SHARE_SIGNING_KEY not set; share links will not survive a restart")
+	}
+	return &ShareLinks{key: key, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+func (l *ShareLinks) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, l.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Issue signs the claims into a URL-safe token.
+func (l *ShareLinks) Issue(c shareClaims) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(l.sign(payload)), nil
+}
+
+// Verify checks the token signature and expiry and returns its claims.
+func (l *ShareLinks) Verify(token string, now time.Time) (shareClaims, error) {
+	var c shareClaims
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return c, errShareInvalid
+	}
+	enc := base64.RawURLEncoding
+	payload, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return c, errShareInvalid
+	}
+	sig, err := enc.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, l.sign(payload)) {
+		return c, errShareInvalid
+	}
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return c, errShareInvalid
+	}
+	if now.Unix() >= c.Expires {
+		return c, errShareExpired
+	}
+	return c, nil
+}
+
+// createShare issues a share link for a wallet, a single transfer, or a
+// peel-chain journey. The link only exposes the creator's tenant's events.
+func createShare(links *ShareLinks, store *EventStore, w http.ResponseWriter, r *http.Request) {
+	var req ShareRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Target == "" {
+		badRequest(w, invalidParam("target", "target is required"))
+		return
+	}
+	tenant := tenantFrom(r.Context())
+	switch req.Kind {
+	case ShareKindWallet, ShareKindJourney:
+		address, err := canonicalAddress("", req.Target)
+		if err != nil {
+			badRequest(w, invalidParam("target", "target: %v", err))
+			return
+		}
+		req.Target = address
+	case ShareKindTransfer:
+		if ev, ok := store.GetByID(req.Target); !ok || !tenantSees(tenant, ev) {
+			httpError(w, "transfer not found", http.StatusNotFound)
+			return
+		}
+	default:
+		badRequest(w, invalidParam("kind", "kind must be one of wallet, transfer, journey"))
+		return
+	}
+
+	ttl := defaultShareTTL
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+		if ttl > maxShareTTL {
+			ttl = maxShareTTL
+		}
+	}
+	expires := time.Now().Add(ttl).UTC()
+
+	token, err := links.Issue(shareClaims{
+		Kind:    req.Kind,
+		Target:  req.Target,
+		Chain:   req.Chain,
+		Network: req.Network,
+		Tenant:  tenant,
+		Expires: expires.Unix(),
+	})
+	if err != nil {
+		httpError(w, "could not issue share link", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(ShareLink{
+		Token:     token,
+		URL:       links.baseURL + "/shared/" + token,
+		ExpiresAt: expires.Format(time.RFC3339),
+	})
+}
+
+// getShared renders the object behind a share link. No authentication is
+// required; the token itself limits access to the shared object.
+func getShared(links *ShareLinks, store *EventStore, w http.ResponseWriter, r *http.Request) {
+	claims, err := links.Verify(chi.URLParam(r, "token"), time.Now())
+	if errors.Is(err, errShareExpired) {
+		httpError(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil || claims.Kind == ShareKindDownload {
+		httpError(w, "share link not found", http.StatusNotFound)
+		return
+	}
+
+	profile, err := parseProfile(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	view := SharedView{
+		Kind:      claims.Kind,
+		Target:    claims.Target,
+		Chain:     claims.Chain,
+		Network:   claims.Network,
+		ExpiresAt: time.Unix(claims.Expires, 0).UTC().Format(time.RFC3339),
+	}
+	switch claims.Kind {
+	case ShareKindWallet:
+		filter := EventFilter{Chain: claims.Chain, Network: claims.Network, Tenant: claims.Tenant}
+		p := newQueryParams(r)
+		filter.Limit, filter.Offset = p.Page()
+		filter.IncludeTotal = p.Bool("include_total", true)
+		if err := p.Err(); err != nil {
+			badRequest(w, err)
+			return
+		}
+		page := store.Page([]string{claims.Target}, filter, func(f EventFilter) []*Event { return store.GetByWallet(claims.Target, f) })
+		view.Events = withProfile(profile, store.Enrich(page.events))
+		setPaginationHeaders(w, r, filter, page)
+	case ShareKindTransfer:
+		ev, ok := store.GetByID(claims.Target)
+		if !ok || !tenantSees(claims.Tenant, ev) {
+			httpError(w, "transfer not found", http.StatusNotFound)
+			return
+		}
+		view.Event = withProfileOne(profile, store.EnrichOne(ev))
+	case ShareKindJourney:
+		journey := DetectPeelChain(store, claims.Target, PeelChainOptions{Chain: claims.Chain, Network: claims.Network, Tenant: claims.Tenant})
+		view.Journey = &journey
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	_ = json.NewEncoder(w).Encode(view)
+}
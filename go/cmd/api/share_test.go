@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShareLinksIssueAndVerify(t *testing.T) {
+	links := NewShareLinks([]byte("secret"), "")
+	now := time.Now()
+
+	token, err := links.Issue(shareClaims{Kind: ShareKindWallet, Target: "alice", Expires: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	c, err := links.Verify(token, now)
+	if err != nil || c.Target != "alice" {
+		t.Fatalf("expected valid claims for alice, got %+v err=%v", c, err)
+	}
+
+	if _, err := links.Verify(token, now.Add(2*time.Hour)); err != errShareExpired {
+		t.Fatalf("expected expired error, got %v", err)
+	}
+
+	// A token signed with another key is rejected
+	other := NewShareLinks([]byte("other"), "")
+	if _, err := other.Verify(token, now); err != errShareInvalid {
+		t.Fatalf("expected invalid signature error, got %v", err)
+	}
+
+	// Tampering with the payload invalidates the signature
+	forged, _ := NewShareLinks([]byte("other"), "").Issue(shareClaims{Kind: ShareKindWallet, Target: "bob", Expires: now.Add(time.Hour).Unix()})
+	tampered := strings.SplitN(forged, ".", 2)[0] + "." + strings.SplitN(token, ".", 2)[1]
+	if _, err := links.Verify(tampered, now); err != errShareInvalid {
+		t.Fatalf("expected tampered token to be rejected, got %v", err)
+	}
+}
+
+func TestCreateAndGetSharedWallet(t *testing.T) {
+	store := NewEventStore(100, 50)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	store.Add(makeEvent("1", aliceAddr, bobAddr, "1", ts, ""))
+	store.Add(makeEvent("2", carolAddr, "dave", "1", ts, ""))
+	links := NewShareLinks([]byte("secret"), "https://tracker.example")
+
+	body, _ := json.Marshal(ShareRequest{Kind: ShareKindWallet, Target: strings.ToUpper(aliceAddr), TTLSeconds: 60})
+	r := httptest.NewRecorder()
+	createShare(links, store, r, httptest.NewRequest(http.MethodPost, "/share", bytes.NewReader(body)))
+	if r.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", r.Code, r.Body.String())
+	}
+	var link ShareLink
+	if err := json.NewDecoder(r.Body).Decode(&link); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !strings.HasPrefix(link.URL, "https://tracker.example/shared/") {
+		t.Fatalf("unexpected share url %q", link.URL)
+	}
+
+	req := withChiParam(httptest.NewRequest(http.MethodGet, "/shared/"+link.Token, nil), "token", link.Token)
+	r = httptest.NewRecorder()
+	getShared(links, store, r, req)
+	if r.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", r.Code)
+	}
+	var view SharedView
+	if err := json.NewDecoder(r.Body).Decode(&view); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if view.Target != checksumAddress(aliceAddr) || len(view.Events) != 1 || view.Events[0].EventID != "1" {
+		t.Fatalf("expected only alice's event, got %+v", view)
+	}
+}
+
+func TestCreateShareValidation(t *testing.T) {
+	store := NewEventStore(100, 50)
+	links := NewShareLinks([]byte("secret"), "")
+
+	cases := map[string]int{
+		`{"kind":"case","target":"x"}`:     http.StatusBadRequest,
+		`{"kind":"wallet"}`:                http.StatusBadRequest,
+		`{"kind":"transfer","target":"x"}`: http.StatusNotFound,
+		`not json`:                         http.StatusBadRequest,
+	}
+	for body, want := range cases {
+		r := httptest.NewRecorder()
+		createShare(links, store, r, httptest.NewRequest(http.MethodPost, "/share", strings.NewReader(body)))
+		if r.Code != want {
+			t.Fatalf("body %s: expected %d, got %d", body, want, r.Code)
+		}
+	}
+
+	req := withChiParam(httptest.NewRequest(http.MethodGet, "/shared/bogus", nil), "token", "bogus")
+	r := httptest.NewRecorder()
+	getShared(links, store, r, req)
+	if r.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for bogus token, got %d", r.Code)
+	}
+}
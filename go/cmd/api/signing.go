@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Headers carrying the signature of a signed body.
+const (
+	keyIDHeader     = "X-Tracker-Key-Id"
+	signatureHeader = "X-Tracker-Signature-Ed25519"
+)
+
+// signatureAlgorithm names what a signature covers: the Ed25519 signature
+// of the SHA-256 digest of the content, so large archives are signed
+// without holding them in memory.
+const signatureAlgorithm = "ed25519-sha256"
+
+// Signer signs export files and webhook bodies with the deployment key, so
+// downstream consumers can check with the published public key that data
+// came from this tracker unaltered. A nil Signer signs nothing.
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// ParseSigningKey reads SIGNING_KEY: a PEM-encoded PKCS #8 Ed25519 private
+// key, as written by `openssl genpkey -algorithm ed25519`, or the base64
+// of a 32-byte seed. It returns nil when spec is empty.
+func ParseSigningKey(spec string) (*Signer, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	var key ed25519.PrivateKey
+	if block, _ := pem.Decode([]byte(spec)); block != nil {
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("signing key: %w", err)
+		}
+		k, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("signing key: not an Ed25519 key")
+		}
+		key = k
+	} else {
+		seed, err := base64.StdEncoding.DecodeString(spec)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("signing key: want a PEM private key or the base64 of a %d-byte seed", ed25519.SeedSize)
+		}
+		key = ed25519.NewKeyFromSeed(seed)
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	sum := sha256.Sum256(pub)
+	return &Signer{key: key, keyID: hex.EncodeToString(sum[:8])}, nil
+}
+
+// KeyID identifies the key, as the first 8 bytes of the SHA-256 of its
+// public key in hex, so consumers can tell rotated keys apart.
+func (s *Signer) KeyID() string {
+	if s == nil {
+		return ""
+	}
+	return s.keyID
+}
+
+// SignDigest signs the SHA-256 digest of some content and returns the
+// signature in base64.
+func (s *Signer) SignDigest(digest []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, digest))
+}
+
+// Sign signs body.
+func (s *Signer) Sign(body []byte) string {
+	sum := sha256.Sum256(body)
+	return s.SignDigest(sum[:])
+}
+
+// SetHeaders adds the key id and signature of body to h, when s is
+// configured.
+func (s *Signer) SetHeaders(h http.Header, body []byte) {
+	if s == nil {
+		return
+	}
+	h.Set(keyIDHeader, s.keyID)
+	h.Set(signatureHeader, s.Sign(body))
+}
+
+// DetachedSignature is the content of the .sig object uploaded next to a
+// signed archive object.
+type DetachedSignature struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature"`
+}
+
+// Detached returns the detached signature of content with the SHA-256
+// digest.
+func (s *Signer) Detached(digest []byte) DetachedSignature {
+	return DetachedSignature{KeyID: s.keyID, Algorithm: signatureAlgorithm, SHA256: hex.EncodeToString(digest), Signature: s.SignDigest(digest)}
+}
+
+// TrackerKey is the body of GET /.well-known/tracker-key.
+type TrackerKey struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	// PublicKey is the raw 32-byte Ed25519 public key in base64, and
+	// PublicKeyPEM the same as a PEM-encoded SubjectPublicKeyInfo.
+	PublicKey    string `json:"public_key"`
+	PublicKeyPEM string `json:"public_key_pem"`
+}
+
+// PublicKey describes the key signatures are checked with.
+func (s *Signer) PublicKey() TrackerKey {
+	pub := s.key.Public().(ed25519.PublicKey)
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	return TrackerKey{
+		KeyID:        s.keyID,
+		Algorithm:    signatureAlgorithm,
+		PublicKey:    base64.StdEncoding.EncodeToString(pub),
+		PublicKeyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	}
+}
+
+// getTrackerKey handles GET /.well-known/tracker-key.
+func getTrackerKey(signer *Signer, w http.ResponseWriter, r *http.Request) {
+	if signer == nil {
+		httpError(w, "signing is not configured; set SIGNING_KEY", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_ = json.NewEncoder(w).Encode(signer.PublicKey())
+}
+
+// signResponses buffers the responses of the routes it wraps, such as
+// exports, and adds the signature headers of successful ones. Without a
+// signer it passes responses through.
+func signResponses(signer *Signer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if signer == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &signedResponse{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			if rec.status < 300 {
+				signer.SetHeaders(w.Header(), rec.body.Bytes())
+			}
+			w.WriteHeader(rec.status)
+			_, _ = w.Write(rec.body.Bytes())
+		})
+	}
+}
+
+// signedResponse holds a response back until its body is complete.
+type signedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (s *signedResponse) WriteHeader(status int) { s.status = status }
+
+func (s *signedResponse) Write(b []byte) (int, error) { return s.body.Write(b) }
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterChainAdapter(solanaAdapter{})
+}
+
+// solanaAdapter handles Solana: base58 public keys and signatures, which
+// are case-sensitive and kept as they are, and the Solana JSON-RPC API.
+type solanaAdapter struct{}
+
+func (solanaAdapter) Chain() string { return "solana" }
+
+func (a solanaAdapter) Normalize(ev *Event) error { return normalizeEvent(a, ev) }
+
+func (solanaAdapter) Validate(address string) (string, error) {
+	if !isSolanaAddress(address) {
+		return "", errNotChainAddress
+	}
+	return address, nil
+}
+
+func (solanaAdapter) TxHash(hash string) (string, bool) {
+	return hash, isSolanaSignature(hash)
+}
+
+func (solanaAdapter) Subscribe(network string) string { return eventsChannel("solana", network) }
+
+// Backfill returns the jsonParsed getTransaction result at confirmed
+// commitment, which no longer changes.
+func (solanaAdapter) Backfill(ctx context.Context, call RPCCaller, hash string) (json.RawMessage, bool, error) {
+	raw, err := call(ctx, "getTransaction", hash,
+		map[string]interface{}{"encoding": "jsonParsed", "maxSupportedTransactionVersion": 0, "commitment": "confirmed"})
+	return raw, raw != nil, err
+}
+
+func (solanaAdapter) HeadMethod() string { return "getSlot" }
+
+func (solanaAdapter) NativeDecimals() (int, bool) { return 9, true }
+
+func (solanaAdapter) NativeSymbol() string { return "SOL" }
+
+// VerifyMessage checks an ed25519 signature of the message bytes by the
+// address's key, as wallets' signMessage makes it, encoded in base58 or
+// 0x-prefixed hex.
+func (solanaAdapter) VerifyMessage(address, message, signature string) error {
+	signature = strings.TrimSpace(signature)
+	var sig []byte
+	var ok bool
+	if strings.HasPrefix(signature, "0x") {
+		var err error
+		sig, err = hex.DecodeString(signature[2:])
+		ok = err == nil
+	} else {
+		sig, ok = decodeBase58(signature)
+	}
+	key, keyOK := decodeBase58(address)
+	if !ok || !keyOK || len(sig) != ed25519.SignatureSize || len(key) != ed25519.PublicKeySize {
+		return errBadSignature
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), []byte(message), sig) {
+		return errBadSignature
+	}
+	return nil
+}
+
+const (
+	solanaSystemProgram = "11111111111111111111111111111111"
+	// historySignaturePage is the most signatures getSignaturesForAddress
+	// returns at once.
+	historySignaturePage = 1000
+)
+
+// solanaTokenPrograms are the SPL Token and Token-2022 program IDs.
+var solanaTokenPrograms = map[string]bool{
+	"TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5DA": true,
+	"TokenzQdBNbLqP5VEhdkAS6EPFLC1PHnBqCXEpPxuEb": true,
+}
+
+// History returns the SOL and SPL token transfers of address's recent
+// successful transactions, newest first. At most bound.Limit transactions
+// are fetched.
+func (a solanaAdapter) History(ctx context.Context, call RPCCaller, address string, bound HistoryBound) ([]*Event, error) {
+	var events []*Event
+	before := ""
+	for scanned := 0; bound.Limit <= 0 || scanned < bound.Limit; {
+		limit := historySignaturePage
+		if bound.Limit > 0 && bound.Limit-scanned < limit {
+			limit = bound.Limit - scanned
+		}
+		params := map[string]interface{}{"limit": limit, "commitment": "confirmed"}
+		if before != "" {
+			params["before"] = before
+		}
+		result, err := call(ctx, "getSignaturesForAddress", address, params)
+		if err != nil {
+			return nil, err
+		}
+		var page []struct {
+			signatureInfo
+			BlockTime *int64 `json:"blockTime"`
+		}
+		if result != nil {
+			if err := json.Unmarshal(result, &page); err != nil {
+				return nil, fmt.Errorf("unexpected getSignaturesForAddress result: %w", err)
+			}
+		}
+		for _, s := range page {
+			before = s.Signature
+			scanned++
+			if s.BlockTime != nil && time.Unix(*s.BlockTime, 0).Before(bound.Since) {
+				return events, nil
+			}
+			if len(s.Err) > 0 && string(s.Err) != "null" {
+				continue
+			}
+			raw, _, err := a.Backfill(ctx, call, s.Signature)
+			if err != nil {
+				return nil, err
+			}
+			if raw != nil {
+				events = append(events, solanaTransfers(raw, s.Signature, address)...)
+			}
+		}
+		if len(page) < limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+// solanaInstruction is an instruction of a jsonParsed transaction.
+type solanaInstruction struct {
+	ProgramID string `json:"programId"`
+	Parsed    struct {
+		Type string `json:"type"`
+		Info struct {
+			Source            string          `json:"source"`
+			Destination       string          `json:"destination"`
+			Authority         string          `json:"authority"`
+			MultisigAuthority string          `json:"multisigAuthority"`
+			Mint              string          `json:"mint"`
+			Amount            string          `json:"amount"`
+			Lamports          json.RawMessage `json:"lamports"`
+			TokenAmount       struct {
+				Amount   string `json:"amount"`
+				Decimals *uint8 `json:"decimals"`
+			} `json:"tokenAmount"`
+		} `json:"info"`
+	} `json:"parsed"`
+}
+
+// solanaTokenBalance is an entry of a transaction's token balances.
+type solanaTokenBalance struct {
+	AccountIndex  int    `json:"accountIndex"`
+	Mint          string `json:"mint"`
+	Owner         string `json:"owner"`
+	UITokenAmount struct {
+		Decimals *uint8 `json:"decimals"`
+	} `json:"uiTokenAmount"`
+}
+
+// solanaTransfers decodes the transfers of a jsonParsed getTransaction
+// result that involve address, with the event IDs and instruction indexes
+// the listener gives them: instructions are numbered in execution order,
+// each top-level one followed by its inner ones.
+func solanaTransfers(raw json.RawMessage, signature, address string) []*Event {
+	var tx struct {
+		Slot      uint64 `json:"slot"`
+		BlockTime *int64 `json:"blockTime"`
+		Meta      struct {
+			Fee               uint64 `json:"fee"`
+			InnerInstructions []struct {
+				Index        int                 `json:"index"`
+				Instructions []solanaInstruction `json:"instructions"`
+			} `json:"innerInstructions"`
+			PreTokenBalances  []solanaTokenBalance `json:"preTokenBalances"`
+			PostTokenBalances []solanaTokenBalance `json:"postTokenBalances"`
+		} `json:"meta"`
+		Transaction struct {
+			Message struct {
+				AccountKeys  []json.RawMessage   `json:"accountKeys"`
+				Instructions []solanaInstruction `json:"instructions"`
+			} `json:"message"`
+		} `json:"transaction"`
+	}
+	if json.Unmarshal(raw, &tx) != nil {
+		return nil
+	}
+	keys := make([]string, len(tx.Transaction.Message.AccountKeys))
+	for i, k := range tx.Transaction.Message.AccountKeys {
+		var key struct {
+			Pubkey string `json:"pubkey"`
+		}
+		if json.Unmarshal(k, &keys[i]) != nil && json.Unmarshal(k, &key) == nil {
+			keys[i] = key.Pubkey
+		}
+	}
+	tokenAccount := func(account string) (solanaTokenBalance, bool) {
+		for _, balances := range [][]solanaTokenBalance{tx.Meta.PostTokenBalances, tx.Meta.PreTokenBalances} {
+			for _, b := range balances {
+				if b.AccountIndex >= 0 && b.AccountIndex < len(keys) && keys[b.AccountIndex] == account {
+					return b, true
+				}
+			}
+		}
+		return solanaTokenBalance{}, false
+	}
+
+	var ordered []solanaInstruction
+	for i, ix := range tx.Transaction.Message.Instructions {
+		ordered = append(ordered, ix)
+		for _, inner := range tx.Meta.InnerInstructions {
+			if inner.Index == i {
+				ordered = append(ordered, inner.Instructions...)
+			}
+		}
+	}
+	var timestamp string
+	if tx.BlockTime != nil {
+		timestamp = time.Unix(*tx.BlockTime, 0).UTC().Format(time.RFC3339)
+	}
+	slot, fee := tx.Slot, strconv.FormatUint(tx.Meta.Fee, 10)
+	event := func(id, from, to, value, eventType string, index uint64) *Event {
+		return &Event{EventID: "sol:" + signature + id, Chain: "solana", TxHash: signature, Timestamp: timestamp,
+			From: from, To: to, Value: value, EventType: eventType, Slot: &slot, Fee: fee, LogIndex: &index}
+	}
+
+	var tokens, natives []*Event
+	for i, ix := range ordered {
+		info := ix.Parsed.Info
+		switch {
+		case ix.ProgramID == solanaSystemProgram && (ix.Parsed.Type == "transfer" || ix.Parsed.Type == "transferWithSeed"):
+			var lamports uint64
+			if json.Unmarshal(info.Lamports, &lamports) != nil || lamports == 0 || info.Source != address && info.Destination != address {
+				continue
+			}
+			natives = append(natives, event(fmt.Sprintf(":native%d", len(natives)), info.Source, info.Destination,
+				strconv.FormatUint(lamports, 10), "native_transfer", uint64(i)))
+		case solanaTokenPrograms[ix.ProgramID] && (ix.Parsed.Type == "transfer" || ix.Parsed.Type == "transferChecked"):
+			authority, amount, mint, decimals := info.Authority, info.Amount, info.Mint, info.TokenAmount.Decimals
+			if authority == "" {
+				authority = info.MultisigAuthority
+			}
+			if ix.Parsed.Type == "transferChecked" {
+				amount = info.TokenAmount.Amount
+			}
+			// transfer names neither mint nor decimals; the balances do
+			account, ok := tokenAccount(info.Source)
+			if !ok {
+				account, _ = tokenAccount(info.Destination)
+			}
+			if mint == "" {
+				mint = account.Mint
+			}
+			if decimals == nil {
+				decimals = account.UITokenAmount.Decimals
+			}
+			to := info.Destination
+			if account, ok := tokenAccount(info.Destination); ok && account.Owner != "" {
+				to = account.Owner
+			}
+			if authority == "" || amount == "" ||
+				authority != address && to != address && info.Source != address && info.Destination != address {
+				continue
+			}
+			ev := event(fmt.Sprintf(":%d", len(tokens)), authority, to, amount, "spl_transfer", uint64(i))
+			ev.Token = &Token{Address: mint}
+			if decimals != nil {
+				ev.Token.Decimals = *decimals
+			}
+			tokens = append(tokens, ev)
+		}
+	}
+	return append(tokens, natives...)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Startup phases, in the order main runs them. Startup is complete once
+// every one of them ended.
+const (
+	// PhaseConfig reads the settings, token lists and tenants.
+	PhaseConfig = "config"
+	// PhaseStorage connects to the storage backend and migrates it.
+	PhaseStorage = "storage"
+	// PhaseHydrate loads the persisted labels, chains, views and policies
+	// into memory.
+	PhaseHydrate = "hydrate"
+	// PhaseSubscribe lasts until the event source's subscription is
+	// established.
+	PhaseSubscribe = "subscribe"
+)
+
+var startupPhases = []string{PhaseConfig, PhaseStorage, PhaseHydrate, PhaseSubscribe}
+
+// StartupPhase is the timing of one startup phase.
+type StartupPhase struct {
+	Name      string `json:"name"`
+	StartedAt string `json:"started_at"`
+	// DurationMS is the time spent so far while Running.
+	DurationMS int64 `json:"duration_ms"`
+	Running    bool  `json:"running,omitempty"`
+	// HeapBytes is the heap in use when the phase ended.
+	HeapBytes uint64 `json:"heap_bytes,omitempty"`
+}
+
+// StartupReport is the startup section of GET /status.
+type StartupReport struct {
+	StartedAt string `json:"started_at"`
+	Complete  bool   `json:"complete"`
+	// DurationMS is the time from process start to the end of the last
+	// phase, or so far while starting.
+	DurationMS   int64  `json:"duration_ms"`
+	BudgetMS     int64  `json:"budget_ms,omitempty"`
+	MaxHeapBytes uint64 `json:"max_heap_bytes,omitempty"`
+	// Violations are the budgets startup exceeded; the API is not ready
+	// while there are any.
+	Violations []string       `json:"violations,omitempty"`
+	Phases     []StartupPhase `json:"phases"`
+}
+
+// StatusReport answers GET /status.
+type StatusReport struct {
+	Ready bool `json:"ready"`
+	// NotReady says why the API is not ready, as /ready does.
+	NotReady      string        `json:"not_ready,omitempty"`
+	UptimeSeconds int64         `json:"uptime_seconds"`
+	Startup       StartupReport `json:"startup"`
+}
+
+// startupPhase is a phase begun by StartupTracker.Begin.
+type startupPhase struct {
+	name       string
+	start, end time.Time
+	heap       uint64
+}
+
+// StartupTracker times the phases of a cold start and enforces the
+// startup budgets: a start that takes longer than the time budget, or
+// ends with more heap in use than the memory budget, keeps the API not
+// ready until it is restarted, so a deploy whose cold start regressed is
+// held back instead of silently extending downtime.
+type StartupTracker struct {
+	now  func() time.Time
+	heap func() uint64
+
+	mu      sync.Mutex
+	started time.Time
+	budget  time.Duration
+	maxHeap uint64
+	phases  map[string]*startupPhase
+	// violations are set when startup completes.
+	violations []string
+	complete   bool
+}
+
+// NewStartupTracker returns a tracker of a process started at started.
+func NewStartupTracker(started time.Time) *StartupTracker {
+	return &StartupTracker{now: time.Now, heap: heapInUse, started: started, phases: make(map[string]*startupPhase)}
+}
+
+// heapInUse returns the bytes of heap objects allocated and not yet freed.
+func heapInUse() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// SetBudget sets the time from process start startup may take and the
+// heap it may end with; zero disables a budget.
+func (s *StartupTracker) SetBudget(budget time.Duration, maxHeapBytes uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.budget, s.maxHeap = budget, maxHeapBytes
+}
+
+// Begin starts the phase name and returns the function ending it, which
+// may be called more than once.
+func (s *StartupTracker) Begin(name string) func() {
+	p := &startupPhase{name: name, start: s.now()}
+	s.mu.Lock()
+	s.phases[name] = p
+	s.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() { s.end(p) })
+	}
+}
+
+func (s *StartupTracker) end(p *startupPhase) {
+	heap := s.heap()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.end, p.heap = s.now(), heap
+	if s.complete {
+		return
+	}
+	for _, name := range startupPhases {
+		if q, ok := s.phases[name]; !ok || q.end.IsZero() {
+			return
+		}
+	}
+	s.complete = true
+	took := p.end.Sub(s.started)
+	if s.budget > 0 && took > s.budget {
+		s.violations = append(s.violations, fmt.Sprintf("startup took %s, over its %s budget", took.Round(time.Millisecond), s.budget))
+	}
+	if s.maxHeap > 0 && heap > s.maxHeap {
+		s.violations = append(s.violations, fmt.Sprintf("startup ended with %d MB of heap in use, over its %d MB budget", heap>>20, s.maxHeap>>20))
+	}
+	entry := log.WithFields(log.Fields{"duration": took.Round(time.Millisecond).String(), "heap_mb": heap >> 20})
+	for _, name := range startupPhases {
+		q := s.phases[name]
+		entry = entry.WithField(name, q.end.Sub(q.start).Round(time.Millisecond).String())
+	}
+	if len(s.violations) > 0 {
+		entry.Error("api: startup over budget: " + strings.Join(s.violations, "; ") + "; marking the API not ready")
+		return
+	}
+	entry.Info("api: startup complete")
+}
+
+// NotReady returns why startup keeps the API not ready: it is still
+// running, naming the phase it is in, or it exceeded a budget. It is empty
+// once startup completed within its budgets.
+func (s *StartupTracker) NotReady() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.complete {
+		return strings.Join(s.violations, "; ")
+	}
+	now := s.now()
+	var running []string
+	for _, name := range startupPhases {
+		if p, ok := s.phases[name]; ok && p.end.IsZero() {
+			running = append(running, fmt.Sprintf("%s for %s", name, now.Sub(p.start).Round(time.Millisecond)))
+		}
+	}
+	reason := "starting"
+	if len(running) > 0 {
+		reason += ": " + strings.Join(running, ", ")
+	}
+	if took := now.Sub(s.started); s.budget > 0 && took > s.budget {
+		reason += fmt.Sprintf("; %s over its %s budget", took.Round(time.Millisecond), s.budget)
+	}
+	return reason
+}
+
+// Report returns the phase timings, in the order they run.
+func (s *StartupTracker) Report() StartupReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	r := StartupReport{
+		StartedAt:    s.started.UTC().Format(time.RFC3339),
+		Complete:     s.complete,
+		BudgetMS:     s.budget.Milliseconds(),
+		MaxHeapBytes: s.maxHeap,
+		Violations:   s.violations,
+		Phases:       make([]StartupPhase, 0, len(startupPhases)),
+	}
+	end := s.started
+	for _, name := range startupPhases {
+		p, ok := s.phases[name]
+		if !ok {
+			continue
+		}
+		phase := StartupPhase{Name: name, StartedAt: p.start.UTC().Format(time.RFC3339), HeapBytes: p.heap}
+		if p.end.IsZero() {
+			phase.Running = true
+			phase.DurationMS = now.Sub(p.start).Milliseconds()
+		} else {
+			phase.DurationMS = p.end.Sub(p.start).Milliseconds()
+			if p.end.After(end) {
+				end = p.end
+			}
+		}
+		r.Phases = append(r.Phases, phase)
+	}
+	if !s.complete {
+		end = now
+	}
+	r.DurationMS = end.Sub(s.started).Milliseconds()
+	return r
+}
+
+// WriteMetrics writes the phase timings and whether startup is over
+// budget, in the Prometheus text format.
+func (s *StartupTracker) WriteMetrics(out io.Writer) {
+	r := s.Report()
+	fmt.Fprintf(out, "# HELP tracker_startup_phase_seconds Time spent in each startup phase.\n# TYPE tracker_startup_phase_seconds gauge\n")
+	for _, p := range r.Phases {
+		fmt.Fprintf(out, "tracker_startup_phase_seconds{phase=%q} %g\n", p.Name, float64(p.DurationMS)/1000)
+	}
+	fmt.Fprintf(out, "# HELP tracker_startup_seconds Time from process start to the end of startup, or so far.\n# TYPE tracker_startup_seconds gauge\n")
+	fmt.Fprintf(out, "tracker_startup_seconds %g\n", float64(r.DurationMS)/1000)
+	over := 0
+	if len(r.Violations) > 0 {
+		over = 1
+	}
+	fmt.Fprintf(out, "# HELP tracker_startup_over_budget Whether startup exceeded its time or memory budget.\n# TYPE tracker_startup_over_budget gauge\n")
+	fmt.Fprintf(out, "tracker_startup_over_budget %d\n", over)
+}
+
+// subscribedKey is the context key of the function event sources call once
+// their subscription is established.
+type subscribedKey struct{}
+
+// withSubscribed returns ctx carrying subscribed, which event sources call
+// each time they establish their subscription.
+func withSubscribed(ctx context.Context, subscribed func()) context.Context {
+	return context.WithValue(ctx, subscribedKey{}, subscribed)
+}
+
+// markSubscribed tells the caller of the event source that its
+// subscription is established.
+func markSubscribed(ctx context.Context) {
+	if subscribed, ok := ctx.Value(subscribedKey{}).(func()); ok {
+		subscribed()
+	}
+}
+
+// notReady returns why the API is not ready, or "" when it is.
+func notReady(watchdog *Watchdog, startup *StartupTracker) string {
+	if reason := startup.NotReady(); reason != "" {
+		return reason
+	}
+	if watchdog == nil {
+		return ""
+	}
+	if stalled := watchdog.Stalled(); len(stalled) > 0 {
+		return "stalled " + strings.Join(stalled, ", ")
+	}
+	return ""
+}
+
+// statusHandler reports readiness, uptime and the startup timings.
+func statusHandler(watchdog *Watchdog, startup *StartupTracker, w http.ResponseWriter, r *http.Request) {
+	status := StatusReport{
+		NotReady:      notReady(watchdog, startup),
+		UptimeSeconds: int64(startup.now().Sub(startup.started).Seconds()),
+		Startup:       startup.Report(),
+	}
+	status.Ready = status.NotReady == ""
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartupTrackerPhasesAndReadiness(t *testing.T) {
+	started := time.Date(2025, 10, 14, 12, 0, 0, 0, time.UTC)
+	now := started
+	startup := NewStartupTracker(started)
+	startup.now = func() time.Time { return now }
+	startup.heap = func() uint64 { return 64 << 20 }
+	startup.SetBudget(time.Minute, 128<<20)
+	ready := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		readyHandler(nil, startup, rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		return rec
+	}
+
+	for _, phase := range []struct {
+		name string
+		took time.Duration
+	}{{PhaseConfig, time.Second}, {PhaseStorage, 3 * time.Second}, {PhaseHydrate, 2 * time.Second}} {
+		end := startup.Begin(phase.name)
+		now = now.Add(phase.took)
+		end()
+	}
+	ctx := withSubscribed(context.Background(), startup.Begin(PhaseSubscribe))
+	now = now.Add(500 * time.Millisecond)
+	if rec := ready(); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "subscribe for 500ms") {
+		t.Fatalf("ready while subscribing: %d %s", rec.Code, rec.Body.String())
+	}
+
+	markSubscribed(ctx)
+	now = now.Add(time.Hour)
+	markSubscribed(ctx)
+	if rec := ready(); rec.Code != http.StatusOK {
+		t.Fatalf("ready after startup: %d %s", rec.Code, rec.Body.String())
+	}
+	report := startup.Report()
+	if !report.Complete || report.DurationMS != 6500 || len(report.Phases) != 4 || report.Phases[1].DurationMS != 3000 || report.Phases[3].HeapBytes != 64<<20 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	rec := httptest.NewRecorder()
+	statusHandler(nil, startup, rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status StatusReport
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil || !status.Ready || status.UptimeSeconds != 3606 || status.Startup.Phases[0].Name != PhaseConfig {
+		t.Fatalf("status = %+v, %v", status, err)
+	}
+	var out bytes.Buffer
+	startup.WriteMetrics(&out)
+	for _, want := range []string{`tracker_startup_phase_seconds{phase="storage"} 3`, "tracker_startup_seconds 6.5", "tracker_startup_over_budget 0"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestStartupOverBudgetFailsReadiness(t *testing.T) {
+	started := time.Now()
+	now := started
+	startup := NewStartupTracker(started)
+	startup.now = func() time.Time { return now }
+	startup.heap = func() uint64 { return 300 << 20 }
+	startup.SetBudget(10*time.Second, 256<<20)
+
+	for _, name := range startupPhases {
+		end := startup.Begin(name)
+		now = now.Add(4 * time.Second)
+		if name == PhaseHydrate {
+			if reason := startup.NotReady(); !strings.Contains(reason, "hydrate for 4s") || !strings.Contains(reason, "over its 10s budget") {
+				t.Fatalf("not ready while over budget = %q", reason)
+			}
+		}
+		end()
+	}
+	reason := startup.NotReady()
+	if !strings.Contains(reason, "startup took 16s, over its 10s budget") || !strings.Contains(reason, "300 MB of heap in use, over its 256 MB budget") {
+		t.Fatalf("not ready = %q", reason)
+	}
+	rec := httptest.NewRecorder()
+	readyHandler(nil, startup, rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("ready over budget = %d", rec.Code)
+	}
+	var out bytes.Buffer
+	startup.WriteMetrics(&out)
+	if !strings.Contains(out.String(), "tracker_startup_over_budget 1") {
+		t.Fatalf("metrics:\n%s", out.String())
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+// systemEventsChannel is the Redis Pub/Sub channel indexers and other
+// services publish non-transaction lifecycle events on.
+const systemEventsChannel = "cross_chain_system_events"
+
+// Kinds of system events. Unlike chain events these describe the tracker
+// itself: what it watches, how far it has indexed, and what it detected.
+const (
+	SystemWatchlistAdded    = "watchlist.address_added"
+	SystemWatchlistExpired  = "watchlist.address_expired"
+	SystemBackfillCompleted = "backfill.completed"
+	SystemIndexerGap        = "indexer.gap_detected"
+	SystemAlertTriggered    = "alert.triggered"
+
+	// Operational notices, meant for banners in frontends.
+	SystemIndexerLag           = "indexer.lag"
+	SystemIndexerRecovered     = "indexer.recovered"
+	SystemReorgDetected        = "reorg.detected"
+	SystemMaintenanceScheduled = "maintenance.scheduled"
+	SystemMaintenanceEnded     = "maintenance.ended"
+	SystemSLOBurn              = "slo.burn"
+	SystemSLORecovered         = "slo.recovered"
+
+	// SystemReportDaily announces a tenant's daily report.
+	SystemReportDaily = "report.daily"
+)
+
+// Severities tell frontends how prominently to show a notice.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// systemEventKinds maps every known kind to its default severity.
+var systemEventKinds = map[string]string{
+	SystemWatchlistAdded:       SeverityInfo,
+	SystemWatchlistExpired:     SeverityInfo,
+	SystemBackfillCompleted:    SeverityInfo,
+	SystemIndexerGap:           SeverityWarning,
+	SystemAlertTriggered:       SeverityWarning,
+	SystemIndexerLag:           SeverityWarning,
+	SystemIndexerRecovered:     SeverityInfo,
+	SystemReorgDetected:        SeverityWarning,
+	SystemMaintenanceScheduled: SeverityInfo,
+	SystemMaintenanceEnded:     SeverityInfo,
+	SystemSLOBurn:              SeverityCritical,
+	SystemSLORecovered:         SeverityInfo,
+	SystemReportDaily:          SeverityInfo,
+}
+
+// systemEventResolves lists kinds that end an earlier active notice on the
+// same chain and network.
+var systemEventResolves = map[string]string{
+	SystemIndexerRecovered: SystemIndexerLag,
+	SystemMaintenanceEnded: SystemMaintenanceScheduled,
+	SystemSLORecovered:     SystemSLOBurn,
+}
+
+// SystemEvent is a non-transaction notification delivered on /events/system,
+// on the live event stream and to configured webhooks. Type is always
+// "system_event" so stream consumers can tell it apart from events. Notices
+// with ExpiresAt stay active until then and are sent to clients as they
+// connect, so banners survive a page reload.
+type SystemEvent struct {
+	Type      string                 `json:"type"`
+	ID        string                 `json:"id"`
+	Kind      string                 `json:"kind"`
+	Severity  string                 `json:"severity"`
+	Chain     string                 `json:"chain,omitempty"`
+	Network   string                 `json:"network,omitempty"`
+	Message   string                 `json:"message,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	At        string                 `json:"at"`
+	ExpiresAt string                 `json:"expires_at,omitempty"`
+	// Tenant scopes the event to one tenant, such as an alert about its
+	// wallets. It is then only sent on that tenant's live event streams
+	// and to webhooks, not on the system stream every tenant reads.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Validate checks that the event has a known kind and severity.
+func (e SystemEvent) Validate() error {
+	if _, ok := systemEventKinds[e.Kind]; !ok {
+		return fmt.Errorf("unknown system event kind %q", e.Kind)
+	}
+	switch e.Severity {
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return fmt.Errorf("invalid severity %q", e.Severity)
+	}
+	if e.ExpiresAt != "" {
+		if _, err := time.Parse(time.RFC3339, e.ExpiresAt); err != nil {
+			return fmt.Errorf("invalid expires_at: %v", err)
+		}
+	}
+	return nil
+}
+
+func (e SystemEvent) expired(now time.Time) bool {
+	t, err := time.Parse(time.RFC3339, e.ExpiresAt)
+	return err != nil || !now.Before(t)
+}
+
+// newRandomID returns a random 128-bit hex identifier.
+func newRandomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// SystemEvents fans system events out to the system stream, the live event
+// stream and webhooks, and remembers which notices are still active.
+type SystemEvents struct {
+	hub       *Hub
+	systemHub *Hub
+	webhooks  *WebhookDispatcher
+	escalator *Escalator
+	reports   *DailyReports
+
+	mu     sync.Mutex
+	active []SystemEvent
+}
+
+// NewSystemEvents creates an emitter. webhooks may be nil.
+func NewSystemEvents(hub, systemHub *Hub, webhooks *WebhookDispatcher) *SystemEvents {
+	return &SystemEvents{hub: hub, systemHub: systemHub, webhooks: webhooks}
+}
+
+// AttachEscalator escalates the alerts emitted from now on through the
+// tenants' escalation policies.
+func (s *SystemEvents) AttachEscalator(escalator *Escalator) {
+	s.escalator = escalator
+}
+
+// AttachReports records the indexer incidents emitted from now on for the
+// daily reports.
+func (s *SystemEvents) AttachReports(reports *DailyReports) {
+	s.reports = reports
+}
+
+// Active returns the notices that have not expired or been resolved, oldest
+// first.
+func (s *SystemEvents) Active(now time.Time) []SystemEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.active[:0]
+	for _, ev := range s.active {
+		if !ev.expired(now) {
+			kept = append(kept, ev)
+		}
+	}
+	s.active = kept
+	return append([]SystemEvent(nil), kept...)
+}
+
+// track records ev as active when it has an expiry and drops notices it
+// resolves.
+func (s *SystemEvents) track(ev SystemEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if resolved, ok := systemEventResolves[ev.Kind]; ok {
+		kept := s.active[:0]
+		for _, a := range s.active {
+			if a.Kind == resolved && a.Chain == ev.Chain && a.Network == ev.Network {
+				continue
+			}
+			kept = append(kept, a)
+		}
+		s.active = kept
+	}
+	if ev.ExpiresAt != "" {
+		s.active = append(s.active, ev)
+	}
+}
+
+// Emit fills in the envelope fields, validates the event and delivers it.
+func (s *SystemEvents) Emit(ev SystemEvent) error {
+	ev.Type = "system_event"
+	if ev.ID == "" {
+		ev.ID = newRandomID()
+	}
+	if ev.At == "" {
+		ev.At = time.Now().UTC().Format(time.RFC3339)
+	}
+	if ev.Severity == "" {
+		ev.Severity = systemEventKinds[ev.Kind]
+	}
+	if err := ev.Validate(); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"kind": ev.Kind, "id": ev.ID, "severity": ev.Severity}).Info("system event")
+	s.track(ev)
+	if ev.Tenant != "" {
+		s.hub.PublishEvent(&Event{Tenant: ev.Tenant}, payload)
+	} else {
+		s.systemHub.broadcast <- payload
+		s.hub.broadcast <- payload
+	}
+	if s.webhooks != nil {
+		s.webhooks.Notify(ev)
+	}
+	s.escalator.Observe(ev)
+	s.reports.Observe(ev)
+	return nil
+}
+
+// subscribeToSystemEvents relays system events published on Redis by the
+// listener, backfill jobs and alerting.
+func subscribeToSystemEvents(ctx context.Context, redisURL string, events *SystemEvents) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		log.Fatalf("could not parse redis url: %v", err)
+	}
+
+	rdb := redis.NewClient(opt)
+	pubsub := rdb.Subscribe(ctx, systemEventsChannel)
+	defer pubsub.Close()
+
+	log.Infof("subscribing to %s", systemEventsChannel)
+
+	for msg := range pubsub.Channel() {
+		var ev SystemEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &ev); err != nil {
+			log.WithError(err).Error("could not unmarshal system event")
+			continue
+		}
+		if err := events.Emit(ev); err != nil {
+			log.WithError(err).Warn("dropping system event")
+		}
+	}
+}
+
+// serveSystemSSE streams system events. Clients connecting without a resume
+// position first receive the currently active notices.
+func serveSystemSSE(events *SystemEvents, w http.ResponseWriter, r *http.Request) {
+	var preamble [][]byte
+	if _, resume := parseReplayCursor(r); !resume {
+		for _, ev := range events.Active(time.Now()) {
+			if payload, err := json.Marshal(ev); err == nil {
+				preamble = append(preamble, payload)
+			}
+		}
+	}
+	// System notices describe the deployment rather than a tenant's
+	// wallets, so every tenant receives them
+	streamSSE(events.systemHub, w, r.WithContext(withTenant(r.Context(), "")), "", preamble, nil)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSystemEventsEmitBroadcasts(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	client := make(chan Frame, 4)
+	hub.register <- client
+	defer func() { hub.unregister <- client }()
+
+	events := NewSystemEvents(hub, NewHub(), nil)
+	go events.systemHub.Run()
+	err := events.Emit(SystemEvent{
+		Kind:  SystemBackfillCompleted,
+		Chain: "ethereum",
+		Data:  map[string]interface{}{"address": "0xabc", "events": 12},
+	})
+	if err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+
+	select {
+	case f := <-client:
+		var got SystemEvent
+		if err := json.Unmarshal(f.Data, &got); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if got.Type != "system_event" || got.Kind != SystemBackfillCompleted || got.ID == "" || got.At == "" {
+			t.Fatalf("unexpected system event %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected system event on the live stream")
+	}
+}
+
+func TestSystemEventsRejectsUnknownKind(t *testing.T) {
+	events := NewSystemEvents(NewHub(), NewHub(), nil)
+	if err := events.Emit(SystemEvent{Kind: "something.else"}); err == nil {
+		t.Fatal("expected unknown kind to be rejected")
+	}
+}
+
+func TestSystemEventsActiveNotices(t *testing.T) {
+	hub, systemHub := NewHub(), NewHub()
+	go hub.Run()
+	go systemHub.Run()
+	events := NewSystemEvents(hub, systemHub, nil)
+
+	now := time.Now()
+	window := SystemEvent{
+		Kind:      SystemMaintenanceScheduled,
+		Message:   "database upgrade",
+		ExpiresAt: now.Add(time.Hour).UTC().Format(time.RFC3339),
+	}
+	stale := SystemEvent{
+		Kind:      SystemIndexerLag,
+		Chain:     "solana",
+		ExpiresAt: now.Add(-time.Minute).UTC().Format(time.RFC3339),
+	}
+	for _, ev := range []SystemEvent{window, stale, {Kind: SystemReorgDetected}} {
+		if err := events.Emit(ev); err != nil {
+			t.Fatalf("emit: %v", err)
+		}
+	}
+
+	active := events.Active(now)
+	if len(active) != 1 || active[0].Kind != SystemMaintenanceScheduled || active[0].Severity != SeverityInfo {
+		t.Fatalf("expected only the maintenance notice to be active, got %+v", active)
+	}
+
+	// A fresh client sees the active notice first
+	tw := newTestRW()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go serveSystemSSE(events, tw, httptest.NewRequest(http.MethodGet, "/events/system", nil).WithContext(ctx))
+	select {
+	case b := <-tw.writes:
+		if !strings.Contains(string(b), "database upgrade") {
+			t.Fatalf("expected maintenance notice, got %s", b)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected active notices on connect")
+	}
+
+	if err := events.Emit(SystemEvent{Kind: SystemMaintenanceEnded}); err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+	if active := events.Active(now); len(active) != 0 {
+		t.Fatalf("expected maintenance.ended to resolve the notice, got %+v", active)
+	}
+}
+
+func TestReorgEmitsSystemNotice(t *testing.T) {
+	store := NewEventStore(100, 50)
+	height := uint64(100)
+	ev := makeEvent("a", "alice", "bob", "1", time.Now().UTC().Format(time.RFC3339), "")
+	ev.Chain = "ethereum"
+	ev.BlockNumber = &height
+	store.Add(ev)
+
+	hub, systemHub := NewHub(), NewHub()
+	go hub.Run()
+	go systemHub.Run()
+	client := make(chan Frame, 4)
+	systemHub.register <- client
+	defer func() { systemHub.unregister <- client }()
+	events := NewSystemEvents(hub, systemHub, nil)
+
+	handleConfirmation(context.Background(), store, hub, events, ConfirmationUpdate{Chain: "ethereum", Status: StatusOrphaned, BlockNumber: &height})
+
+	select {
+	case f := <-client:
+		var got SystemEvent
+		if err := json.Unmarshal(f.Data, &got); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if got.Kind != SystemReorgDetected || got.Chain != "ethereum" || got.Severity != SeverityWarning {
+			t.Fatalf("unexpected reorg notice %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected reorg notice on the system stream")
+	}
+}
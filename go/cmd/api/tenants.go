@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// tenantKey is the request context key of the caller's tenant.
+type tenantKey struct{}
+
+// withTenant returns ctx scoped to tenant.
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantFrom returns the tenant a request is scoped to, or "" when it sees
+// every event (single-tenant deployments and internal callers).
+func tenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// tenantSees reports whether a caller scoped to tenant may see ev: the
+// event's owner and the tenants it is shared with.
+func tenantSees(tenant string, ev *Event) bool {
+	return tenant == "" || ev.Tenant == tenant || containsToken(ev.SharedWith, tenant)
+}
+
+// tenantEvents returns the events a caller scoped to tenant may see.
+func tenantEvents(tenant string, events []*Event) []*Event {
+	if tenant == "" {
+		return events
+	}
+	out := make([]*Event, 0, len(events))
+	for _, ev := range events {
+		if tenantSees(tenant, ev) {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// eventTenants returns the tenants that see ev: its owner and the tenants
+// it is shared with.
+func eventTenants(ev *Event) []string {
+	if ev.Tenant == "" {
+		return ev.SharedWith
+	}
+	return append([]string{ev.Tenant}, ev.SharedWith...)
+}
+
+// sharedWithColumn encodes SharedWith for the shared_with column as
+// ",a,b,", so one substring test finds a tenant; tenant names cannot hold
+// commas.
+func sharedWithColumn(tenants []string) string {
+	if len(tenants) == 0 {
+		return ""
+	}
+	return "," + strings.Join(tenants, ",") + ","
+}
+
+// parseSharedWith decodes the shared_with column.
+func parseSharedWith(column string) []string {
+	column = strings.Trim(column, ",")
+	if column == "" {
+		return nil
+	}
+	return strings.Split(column, ",")
+}
+
+// tenantSQL is the predicate matching the events a tenant sees, with one
+// placeholder (prefix as in EventFilter.sqlWhere) referenced twice.
+func tenantSQL(prefix string) string {
+	// Postgres has strpos, SQLite instr; both take (haystack, needle)
+	find := "strpos"
+	if prefix == "?" {
+		find = "instr"
+	}
+	return "(tenant = %[1]s%[2]d OR " + find + "(shared_with, ',' || %[1]s%[2]d || ',') > 0)"
+}
+
+// Tenants maps API keys and watched wallets to tenant IDs so one deployment
+// can serve several teams. With no tenants configured the API is open and
+// unscoped, as before. Wallets can be replaced at runtime by a config
+// reload; keys cannot.
+type Tenants struct {
+	keys map[[sha256.Size]byte]string // sha256(api key) -> tenant
+
+	mu     sync.RWMutex
+	owners map[string]string // lowercased address -> tenant
+}
+
+// parseTenantSpec calls add for every tenant=value entry of a
+// comma-separated spec; what names the value in errors.
+func parseTenantSpec(spec, what string, add func(tenant, value string) error) error {
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		tenant, value, ok := strings.Cut(item, "=")
+		tenant = strings.ToLower(strings.TrimSpace(tenant))
+		if !ok || !chainNamePattern.MatchString(tenant) || strings.TrimSpace(value) == "" {
+			return fmt.Errorf("invalid tenant %s %q: want tenant=%s", what, item, what)
+		}
+		if err := add(tenant, strings.TrimSpace(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTenantWallets parses TENANT_WALLETS into an owner map.
+func parseTenantWallets(spec string) (map[string]string, error) {
+	owners := make(map[string]string)
+	err := parseTenantSpec(spec, "address", func(tenant, address string) error {
+		address = addressKey(address)
+		if other, ok := owners[address]; ok && other != tenant {
+			return fmt.Errorf("wallet %s is owned by both %q and %q", address, other, tenant)
+		}
+		owners[address] = tenant
+		return nil
+	})
+	return owners, err
+}
+
+// ParseTenants parses TENANT_API_KEYS and TENANT_WALLETS, comma-separated
+// lists of tenant=key and tenant=address entries. A tenant may have several
+// keys and wallets; a key or wallet belongs to at most one tenant.
+func ParseTenants(keys, wallets string) (*Tenants, error) {
+	t := &Tenants{keys: make(map[[sha256.Size]byte]string)}
+	err := parseTenantSpec(keys, "key", func(tenant, key string) error {
+		sum := sha256.Sum256([]byte(key))
+		if other, ok := t.keys[sum]; ok && other != tenant {
+			return fmt.Errorf("api key of tenant %q is also assigned to %q", tenant, other)
+		}
+		t.keys[sum] = tenant
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := t.SetWallets(wallets); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// SetWallets replaces the tenants' wallets with a TENANT_WALLETS spec.
+// Events already stored keep the tenant they were tagged with.
+func (t *Tenants) SetWallets(spec string) error {
+	owners, err := parseTenantWallets(spec)
+	if err != nil {
+		return err
+	}
+	if len(owners) > 0 && len(t.keys) == 0 {
+		return fmt.Errorf("TENANT_WALLETS requires TENANT_API_KEYS")
+	}
+	t.mu.Lock()
+	t.owners = owners
+	t.mu.Unlock()
+	return nil
+}
+
+// Enabled reports whether requests must carry a tenant API key.
+func (t *Tenants) Enabled() bool {
+	return t != nil && len(t.keys) > 0
+}
+
+// Authenticate returns the tenant of an API key.
+func (t *Tenants) Authenticate(key string) (string, bool) {
+	if !t.Enabled() || key == "" {
+		return "", false
+	}
+	// Keys are looked up by digest so the map probe does not leak how much
+	// of a guessed key matches
+	tenant, ok := t.keys[sha256.Sum256([]byte(key))]
+	return tenant, ok
+}
+
+// Names returns the tenants that have an API key, sorted.
+func (t *Tenants) Names() []string {
+	if t == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var out []string
+	for _, tenant := range t.keys {
+		if !seen[tenant] {
+			seen[tenant] = true
+			out = append(out, tenant)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Wallets returns the tenants' wallets in addressKey form, in no
+// particular order.
+func (t *Tenants) Wallets() []string {
+	if t == nil {
+		return nil
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	out := make([]string, 0, len(t.owners))
+	for address := range t.owners {
+		out = append(out, address)
+	}
+	return out
+}
+
+// Owner returns the tenant watching address.
+func (t *Tenants) Owner(address string) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	tenant, ok := t.owners[addressKey(address)]
+	return tenant, ok
+}
+
+// Tag assigns an untagged event to the tenant owning its sender, or else its
+// recipient, and shares it with the owner of the other wallet, so a
+// transfer between two tenants' wallets shows up for both. Events the
+// listener already tagged keep their tenant and are shared the same way.
+func (t *Tenants) Tag(ev *Event) {
+	if t == nil {
+		return
+	}
+	for _, address := range []string{ev.From, ev.To} {
+		tenant, ok := t.Owner(address)
+		switch {
+		case !ok || tenantSees(tenant, ev):
+		case ev.Tenant == "":
+			ev.Tenant = tenant
+		default:
+			ev.SharedWith = append(ev.SharedWith, tenant)
+		}
+	}
+}
+
+// requireTenant scopes requests to the tenant of their API key, read from
+// the X-API-Key header or, for EventSource clients that cannot set headers,
+// the api_key query parameter. Without tenants every request passes
+// unscoped.
+func requireTenant(tenants *Tenants) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !tenants.Enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("X-API-Key")
+			if key == "" {
+				key = r.URL.Query().Get("api_key")
+			}
+			tenant, ok := tenants.Authenticate(key)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `ApiKey realm="tracker"`)
+				httpError(w, "missing or invalid API key", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), tenant)))
+		})
+	}
+}
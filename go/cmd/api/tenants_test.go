@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestParseTenants(t *testing.T) {
+	tenants, err := ParseTenants("treasury=k1, treasury=k2,ops=k3", "treasury=0xABC,ops=0xdef")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if tenant, ok := tenants.Authenticate("k2"); !ok || tenant != "treasury" {
+		t.Fatalf("k2 = %q, %v", tenant, ok)
+	}
+	if _, ok := tenants.Authenticate("k4"); ok {
+		t.Fatal("unknown key authenticated")
+	}
+	if tenant, ok := tenants.Owner("0xabc"); !ok || tenant != "treasury" {
+		t.Fatalf("owner = %q, %v; want treasury (case-insensitive)", tenant, ok)
+	}
+
+	for _, tc := range []struct{ keys, wallets string }{
+		{"treasury", ""},
+		{"Bad Name=k1", ""},
+		{"treasury=k1,ops=k1", ""},
+		{"treasury=k1", "treasury=0xabc,ops=0xABC"},
+		{"", "treasury=0xabc"},
+	} {
+		if _, err := ParseTenants(tc.keys, tc.wallets); err == nil {
+			t.Errorf("ParseTenants(%q, %q) accepted", tc.keys, tc.wallets)
+		}
+	}
+	if none, err := ParseTenants("", ""); err != nil || none.Enabled() {
+		t.Fatalf("no tenants = %+v, %v; want disabled", none, err)
+	}
+
+	// Reloaded wallets replace the previous ones; invalid ones are refused
+	if err := tenants.SetWallets("ops=0xABC"); err != nil {
+		t.Fatalf("set wallets: %v", err)
+	}
+	if tenant, _ := tenants.Owner("0xabc"); tenant != "ops" {
+		t.Fatalf("owner after reload = %q, want ops", tenant)
+	}
+	if _, ok := tenants.Owner("0xdef"); ok {
+		t.Fatal("dropped wallet still owned")
+	}
+	if err := tenants.SetWallets("ops"); err == nil {
+		t.Fatal("invalid wallets accepted")
+	}
+}
+
+func TestTenantsTagByOwnership(t *testing.T) {
+	tenants, _ := ParseTenants("treasury=k1,ops=k2", "treasury=alice,ops=bob")
+	sent := &Event{From: "Alice", To: "bob"}
+	received := &Event{From: "carol", To: "bob"}
+	tagged := &Event{From: "alice", Tenant: "ops"}
+	for _, ev := range []*Event{sent, received, tagged} {
+		tenants.Tag(ev)
+	}
+	if sent.Tenant != "treasury" || received.Tenant != "ops" || tagged.Tenant != "ops" {
+		t.Fatalf("tenants = %q, %q, %q; want treasury, ops, ops", sent.Tenant, received.Tenant, tagged.Tenant)
+	}
+	// A transfer between two tenants' wallets is shared with the other
+	if len(sent.SharedWith) != 1 || sent.SharedWith[0] != "ops" || len(received.SharedWith) != 0 ||
+		len(tagged.SharedWith) != 1 || tagged.SharedWith[0] != "treasury" {
+		t.Fatalf("shared with = %v, %v, %v; want ops, none, treasury", sent.SharedWith, received.SharedWith, tagged.SharedWith)
+	}
+	if !tenantSees("ops", sent) || tenantSees("audit", sent) {
+		t.Fatal("shared event visibility is wrong")
+	}
+}
+
+func TestTenantScopedEndpoints(t *testing.T) {
+	tenants, _ := ParseTenants("treasury=k1,ops=k2", "")
+	store := NewEventStore(100, 50)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	mine := makeEvent("mine", aliceAddr, bobAddr, "1", ts, "")
+	mine.Tenant = "treasury"
+	theirs := makeEvent("theirs", aliceAddr, carolAddr, "2", ts, "")
+	theirs.Tenant = "ops"
+	store.Add(mine)
+	store.Add(theirs)
+
+	router := chi.NewRouter()
+	router.Group(func(r chi.Router) {
+		r.Use(requireTenant(tenants))
+		r.Get("/wallet/{address}/transactions", func(w http.ResponseWriter, r *http.Request) {
+			getWalletTransactions(store, w, r)
+		})
+		r.Get("/transactions/{event_id}", func(w http.ResponseWriter, r *http.Request) {
+			getEventDetail(store, nil, w, r)
+		})
+	})
+	get := func(path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/wallet/"+aliceAddr+"/transactions", ""); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("without key = %d, want 401", rec.Code)
+	}
+	if rec := get("/wallet/"+aliceAddr+"/transactions?api_key=nope", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("invalid key = %d, want 401", rec.Code)
+	}
+
+	rec := get("/wallet/"+aliceAddr+"/transactions", "k1")
+	var events []*Event
+	if err := json.NewDecoder(rec.Body).Decode(&events); err != nil || len(events) != 1 || events[0].EventID != "mine" {
+		t.Fatalf("treasury history = %d %+v, %v", rec.Code, events, err)
+	}
+	if rec.Header().Get("X-Total-Count") != "1" {
+		t.Fatalf("total = %q, want 1", rec.Header().Get("X-Total-Count"))
+	}
+	// A wallet with only another tenant's activity is unknown
+	if rec := get("/wallet/"+carolAddr+"/transactions?api_key=k1", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("other tenant's wallet = %d, want 404", rec.Code)
+	}
+	if rec := get("/transactions/theirs", "k1"); rec.Code != http.StatusNotFound {
+		t.Fatalf("other tenant's event = %d, want 404", rec.Code)
+	}
+	if rec := get("/transactions/theirs", "k2"); rec.Code != http.StatusOK {
+		t.Fatalf("own event = %d, want 200", rec.Code)
+	}
+}
+
+func TestHubDeliversTenantFrames(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	treasury := make(chan Frame, 4)
+	ops := make(chan Frame, 4)
+	all := make(chan Frame, 4)
+	hub.join(treasury, "treasury")
+	hub.join(ops, "ops")
+	hub.register <- all
+
+	hub.Publish("treasury", []byte(`{"event_id":"mine"}`))
+	hub.Publish("", []byte(`{"event_id":"untagged"}`))
+	hub.broadcast <- []byte(`{"type":"system_event"}`)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-all:
+		case <-time.After(time.Second):
+			t.Fatalf("unscoped subscriber got %d of 3 frames", i)
+		}
+	}
+	if len(treasury) != 1 || len(ops) != 0 {
+		t.Fatalf("treasury got %d frames, ops %d; want 1 and 0", len(treasury), len(ops))
+	}
+	if f := <-treasury; !strings.Contains(string(f.Data), "mine") {
+		t.Fatalf("treasury frame = %s", f.Data)
+	}
+
+	// Resuming replays only the tenant's frames
+	hub.mu.Lock()
+	missed := hub.replay.since(replayCursor{}, "ops")
+	hub.mu.Unlock()
+	if len(missed) != 0 {
+		t.Fatalf("ops replay = %d frames, want 0", len(missed))
+	}
+
+	// A shared event reaches every tenant that sees it
+	hub.PublishTo([]string{"treasury", "ops"}, []byte(`{"event_id":"shared"}`))
+	for name, ch := range map[string]chan Frame{"treasury": treasury, "ops": ops} {
+		select {
+		case f := <-ch:
+			if !strings.Contains(string(f.Data), "shared") {
+				t.Fatalf("%s frame = %s", name, f.Data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s did not get the shared frame", name)
+		}
+	}
+}
+
+func TestQueryJobsAreTenantScoped(t *testing.T) {
+	jobs := NewQueryJobs(NewEventStore(10, 10), 1, time.Minute, time.Hour)
+	job, err := jobs.Submit(QueryRequest{Kind: "transactions", Tenant: "treasury"})
+	if err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if _, ok := jobs.Get(job.ID, "ops"); ok {
+		t.Fatal("another tenant sees the job")
+	}
+	if jobs.Cancel(job.ID, "ops") {
+		t.Fatal("another tenant canceled the job")
+	}
+	if _, ok := jobs.Get(job.ID, "treasury"); !ok {
+		t.Fatal("submitter cannot see the job")
+	}
+
+	req, _ := jobRequest(context.Background(), QueryRequest{Tenant: "treasury"})
+	if tenantFrom(req.Context()) != "treasury" {
+		t.Fatal("job request is not scoped to the submitter")
+	}
+}
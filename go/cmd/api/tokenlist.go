@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenListChains maps token list chainIds to the chain and network names
+// used by events. Solana lists use 101-103 by convention.
+var tokenListChains = map[int]struct{ chain, network string }{
+	1:        {"ethereum", "mainnet"},
+	11155111: {"ethereum", "sepolia"},
+	101:      {"solana", "mainnet"},
+	102:      {"solana", "testnet"},
+	103:      {"solana", "devnet"},
+}
+
+// TokenInfo is a curated token list entry.
+type TokenInfo struct {
+	ChainID  int    `json:"chainId"`
+	Address  string `json:"address"`
+	Symbol   string `json:"symbol"`
+	Decimals uint8  `json:"decimals"`
+}
+
+// builtinTokens are well-known tokens that are verified without any
+// TOKEN_LISTS configured.
+var builtinTokens = []TokenInfo{
+	{1, "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", "USDC", 6},
+	{1, "0xdAC17F958D2ee523a2206206994597C13D831ec7", "USDT", 6},
+	{1, "0x6B175474E89094C44Da98b954EedeAC495271d0F", "DAI", 18},
+	{1, "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2", "WETH", 18},
+	{11155111, "0x1c7D4B196Cb0C7B01d743Fbc6116a902379C7238", "USDC", 6},
+	{101, "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v", "USDC", 6},
+	{101, "Es9vMFrzaCERmJfrF4H2FYD4KCoNkY11McCe8BenwNYB", "USDT", 6},
+	{103, "4zMMC9srt5Ri5X14GAgXhaHii3GnPAEERYPJgZJDncDU", "USDC", 6},
+}
+
+type tokenKey struct{ chain, network, address string }
+
+// symbolKey identifies a curated symbol on one network.
+type symbolKey struct{ chain, network, symbol string }
+
+// tokenAddressKey normalizes an address for lookups: EVM addresses are
+// case-insensitive, Solana mints are not.
+func tokenAddressKey(chain, network, address string) tokenKey {
+	if strings.HasPrefix(address, "0x") || strings.HasPrefix(address, "0X") {
+		address = strings.ToLower(address)
+	}
+	return tokenKey{chain, network, address}
+}
+
+// TokenList resolves token addresses to curated symbols, so a token that
+// merely calls itself "USDC" is not displayed as the real one, and tags scam
+// tokens.
+type TokenList struct {
+	mu      sync.RWMutex
+	tokens  map[tokenKey]TokenInfo
+	symbols map[symbolKey]struct{}
+	scams   map[tokenKey]struct{}
+}
+
+// NewTokenList returns a list holding the built-in tokens.
+func NewTokenList() *TokenList {
+	l := &TokenList{
+		tokens:  make(map[tokenKey]TokenInfo),
+		symbols: make(map[symbolKey]struct{}),
+		scams:   make(map[tokenKey]struct{}),
+	}
+	l.add(builtinTokens)
+	return l
+}
+
+// add registers tokens on known chains and returns how many were added.
+func (l *TokenList) add(tokens []TokenInfo) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, t := range tokens {
+		c, ok := tokenListChains[t.ChainID]
+		if !ok || t.Address == "" || t.Symbol == "" {
+			continue
+		}
+		l.tokens[tokenAddressKey(c.chain, c.network, t.Address)] = t
+		l.symbols[symbolKey{c.chain, c.network, strings.ToUpper(t.Symbol)}] = struct{}{}
+		n++
+	}
+	return n
+}
+
+// addScams registers scam token addresses on known chains and returns how
+// many were added.
+func (l *TokenList) addScams(tokens []TokenInfo) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, t := range tokens {
+		c, ok := tokenListChains[t.ChainID]
+		if !ok || t.Address == "" {
+			continue
+		}
+		l.scams[tokenAddressKey(c.chain, c.network, t.Address)] = struct{}{}
+		n++
+	}
+	return n
+}
+
+// Load adds the tokens of a token list in the Uniswap JSON format
+// ({"tokens": [{"chainId", "address", "symbol", "decimals"}]}).
+func (l *TokenList) Load(r io.Reader) (int, error) {
+	tokens, err := decodeTokenList(r)
+	if err != nil {
+		return 0, err
+	}
+	return l.add(tokens), nil
+}
+
+// LoadSources loads comma-separated token list files or http(s) URLs.
+func (l *TokenList) LoadSources(ctx context.Context, sources string) (int, error) {
+	return loadTokenSources(ctx, sources, l.add)
+}
+
+// LoadScamSources loads scam token lists, in the same format and from the
+// same kinds of sources as LoadSources.
+func (l *TokenList) LoadScamSources(ctx context.Context, sources string) (int, error) {
+	return loadTokenSources(ctx, sources, l.addScams)
+}
+
+func loadTokenSources(ctx context.Context, sources string, add func([]TokenInfo) int) (int, error) {
+	total := 0
+	for _, src := range strings.Split(sources, ",") {
+		src = strings.TrimSpace(src)
+		if src == "" {
+			continue
+		}
+		tokens, err := readTokenList(ctx, src)
+		if err != nil {
+			return total, fmt.Errorf("token list %s: %w", src, err)
+		}
+		total += add(tokens)
+	}
+	return total, nil
+}
+
+func decodeTokenList(r io.Reader) ([]TokenInfo, error) {
+	var list struct {
+		Tokens []TokenInfo `json:"tokens"`
+	}
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return nil, err
+	}
+	return list.Tokens, nil
+}
+
+// readTokenList reads a token list from a file or an http(s) URL.
+func readTokenList(ctx context.Context, src string) ([]TokenInfo, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return decodeTokenList(f)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return decodeTokenList(io.LimitReader(resp.Body, 20<<20))
+}
+
+// Lookup returns the curated entry for a token address.
+func (l *TokenList) Lookup(chain, network, address string) (TokenInfo, bool) {
+	if l == nil {
+		return TokenInfo{}, false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	t, ok := l.tokens[tokenAddressKey(chain, network, address)]
+	return t, ok
+}
+
+// IsScam reports whether an unlisted token is a known scam or impersonates
+// a curated token by claiming its symbol on the same network.
+func (l *TokenList) IsScam(chain, network, address, symbol string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	key := tokenAddressKey(chain, network, address)
+	if _, listed := l.tokens[key]; listed {
+		return false
+	}
+	if _, scam := l.scams[key]; scam {
+		return true
+	}
+	_, impersonates := l.symbols[symbolKey{chain, network, strings.ToUpper(strings.TrimSpace(symbol))}]
+	return impersonates
+}
+
+// ResolveOne returns ev with its token checked against the list: listed
+// tokens get the curated symbol and decimals and verified=true, others keep
+// their on-chain symbol with verified=false and are tagged scam when IsScam
+// says so. Events with a token are copied, since stored events are shared
+// with other readers.
+func (l *TokenList) ResolveOne(ev *Event) *Event {
+	if ev == nil || ev.Token == nil {
+		return ev
+	}
+	resolved := *ev
+	tok := *ev.Token
+	tok.Verified, tok.Scam = false, false
+	if t, ok := l.Lookup(ev.Chain, ev.Network, tok.Address); ok {
+		tok.Symbol, tok.Decimals, tok.Verified = t.Symbol, t.Decimals, true
+	} else {
+		tok.Scam = l.IsScam(ev.Chain, ev.Network, tok.Address, tok.Symbol)
+	}
+	resolved.Token = &tok
+	return &resolved
+}
+
+// ResolveVolume marks each volume point as verified (native or listed) or
+// not, using the curated symbol for listed tokens, and tags scam tokens.
+func (l *TokenList) ResolveVolume(points []VolumePoint) []VolumePoint {
+	for i, p := range points {
+		if p.TokenAddress == "" {
+			points[i].Verified = p.Token == nativeToken
+			continue
+		}
+		t, ok := l.Lookup(p.Chain, p.Network, p.TokenAddress)
+		points[i].Verified = ok
+		if ok {
+			points[i].Token = t.Symbol
+		} else {
+			points[i].Scam = l.IsScam(p.Chain, p.Network, p.TokenAddress, p.Token)
+		}
+	}
+	return points
+}
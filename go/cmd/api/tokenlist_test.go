@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenListResolvesSymbols(t *testing.T) {
+	store := NewEventStore(100, 50)
+	ts := time.Now().UTC().Format(time.RFC3339)
+
+	listed := makeEvent("1", "alice", "bob", "5", ts, "USDC")
+	listed.Chain, listed.Network = "ethereum", "mainnet"
+	listed.Token = &Token{Address: "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", Symbol: "USD Coin", Decimals: 6}
+	fake := makeEvent("2", "alice", "bob", "5", ts, "USDC")
+	fake.Chain, fake.Network = "ethereum", "mainnet"
+	fake.Token = &Token{Address: "0xbad", Symbol: "USDC", Decimals: 6, Verified: true}
+	store.Add(listed)
+	store.Add(fake)
+
+	r := httptest.NewRecorder()
+	getTransactions(store, r, httptest.NewRequest(http.MethodGet, "/transactions", nil))
+	var events []*Event
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil || len(events) != 2 {
+		t.Fatalf("decode: %v (%d events)", err, len(events))
+	}
+	byID := map[string]*Token{}
+	for _, ev := range events {
+		byID[ev.EventID] = ev.Token
+	}
+	if tok := byID["1"]; !tok.Verified || tok.Symbol != "USDC" {
+		t.Fatalf("listed token = %+v, want verified USDC", tok)
+	}
+	if tok := byID["2"]; tok.Verified || !tok.Scam || tok.Symbol != "USDC" {
+		t.Fatalf("spoofed token = %+v, want unverified scam", tok)
+	}
+	if listed.Token.Symbol != "USD Coin" || !fake.Token.Verified || fake.Token.Scam {
+		t.Fatal("stored events were modified")
+	}
+
+	// The spoofed token is left out of volume unless scam tokens are asked for
+	q := AnalyticsQuery{Interval: "1h", Start: time.Now().Add(-time.Hour), End: time.Now().Add(time.Hour)}
+	volume, err := store.VolumeSeries(q)
+	if err != nil || len(volume) != 1 || !volume[0].Verified {
+		t.Fatalf("volume = %+v, %v; want only the listed token", volume, err)
+	}
+	q.IncludeScam = true
+	volume, err = store.VolumeSeries(q)
+	if err != nil || len(volume) != 2 {
+		t.Fatalf("volume with scam = %+v, %v", volume, err)
+	}
+	for _, p := range volume {
+		if p.Token != "USDC" || p.Verified != (p.TokenAddress == listed.Token.Address) || p.Scam == p.Verified {
+			t.Fatalf("volume point = %+v", p)
+		}
+	}
+}
+
+func TestTokenListScamSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scam.json")
+	list := `{"tokens": [
+		{"chainId": 1, "address": "0x00000000000000000000000000000000000000DD", "symbol": "FREE", "decimals": 18},
+		{"chainId": 1, "address": "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", "symbol": "USDC", "decimals": 6}
+	]}`
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tokens := NewTokenList()
+	if n, err := tokens.LoadScamSources(context.Background(), path); err != nil || n != 2 {
+		t.Fatalf("loaded %d, %v", n, err)
+	}
+
+	tests := []struct {
+		name                     string
+		network, address, symbol string
+		want                     bool
+	}{
+		{"listed scam", "mainnet", "0x00000000000000000000000000000000000000dd", "FREE", true},
+		{"curated token wins over scam list", "mainnet", "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", "USDC", false},
+		{"impersonates curated symbol", "mainnet", "0xbad", " usdc ", true},
+		{"symbol curated on another network only", "sepolia", "0xbad", "DAI", false},
+		{"unknown token", "mainnet", "0xbad", "MEME", false},
+	}
+	for _, tt := range tests {
+		if got := tokens.IsScam("ethereum", tt.network, tt.address, tt.symbol); got != tt.want {
+			t.Errorf("%s: IsScam = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTokenListLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	list := `{"name": "test", "tokens": [
+		{"chainId": 1, "address": "0x00000000000000000000000000000000000000AA", "symbol": "AAA", "decimals": 18},
+		{"chainId": 103, "address": "MintBBB", "symbol": "BBB", "decimals": 9},
+		{"chainId": 999, "address": "0xcc", "symbol": "CCC", "decimals": 18}
+	]}`
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(list))
+	}))
+	defer srv.Close()
+
+	tokens := NewTokenList()
+	n, err := tokens.LoadSources(context.Background(), path+", "+srv.URL)
+	if err != nil || n != 4 {
+		t.Fatalf("loaded %d, %v; want 4 (unknown chainId skipped)", n, err)
+	}
+	if tok, ok := tokens.Lookup("ethereum", "mainnet", "0x00000000000000000000000000000000000000aa"); !ok || tok.Symbol != "AAA" {
+		t.Fatalf("EVM lookup = %+v, %v (case-insensitive)", tok, ok)
+	}
+	if _, ok := tokens.Lookup("solana", "devnet", "mintbbb"); ok {
+		t.Fatal("Solana mints must match case-sensitively")
+	}
+	if _, ok := tokens.Lookup("ethereum", "sepolia", "0x00000000000000000000000000000000000000aa"); ok {
+		t.Fatal("token resolved on the wrong network")
+	}
+	if _, err := tokens.LoadSources(context.Background(), filepath.Join(t.TempDir(), "missing.json")); err == nil || !strings.Contains(err.Error(), "missing.json") {
+		t.Fatalf("missing file error = %v", err)
+	}
+}
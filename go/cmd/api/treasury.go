@@ -0,0 +1,499 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxApprovedAddresses caps the approved recipients of a treasury policy.
+const maxApprovedAddresses = 1000
+
+// treasuryAlertRule tags the alerts treasury policies raise.
+const treasuryAlertRule = "treasury_allowlist"
+
+// treasurySchema creates the table treasury policies are persisted in,
+// keyed on the tenant and the treasury's addressKey.
+const treasurySchema = `
+	CREATE TABLE IF NOT EXISTS treasury_policies (
+		tenant TEXT NOT NULL DEFAULT '',
+		address TEXT NOT NULL,
+		definition JSONB NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (tenant, address)
+	);
+`
+
+// TreasuryPolicy puts a watched wallet in allowlist mode: every transfer
+// it sends to an address that is not approved raises a critical alert as
+// soon as it is ingested, whatever the amount.
+type TreasuryPolicy struct {
+	Address string `json:"address"`
+	// Chain limits the policy to one chain; EVM addresses otherwise match
+	// on every EVM chain.
+	Chain     string   `json:"chain,omitempty"`
+	Label     string   `json:"label,omitempty"`
+	Approved  []string `json:"approved"`
+	UpdatedAt string   `json:"updated_at,omitempty"`
+	// Tenant owns the policy; it only sees the events the tenant sees.
+	Tenant string `json:"-"`
+}
+
+// ApprovedAddresses is the body of POST /treasuries/{address}/approved.
+type ApprovedAddresses struct {
+	Addresses []string `json:"addresses"`
+}
+
+// errNotApproved is returned when revoking an address that is not
+// approved.
+var errNotApproved = errors.New("address is not approved")
+
+// canonicalApproved validates and deduplicates the recipients in add,
+// appending them to approved. field names add in errors.
+func canonicalApproved(field, chain string, approved, add []string) ([]string, error) {
+	seen := make(map[string]struct{}, len(approved)+len(add))
+	for _, a := range approved {
+		seen[addressKey(a)] = struct{}{}
+	}
+	for i, a := range add {
+		if strings.TrimSpace(a) == "" {
+			continue
+		}
+		a, err := canonicalAddress(chain, a)
+		if err != nil {
+			return nil, invalidParam(field, "%s[%d]: %v", field, i, err)
+		}
+		if _, dup := seen[addressKey(a)]; dup {
+			continue
+		}
+		seen[addressKey(a)] = struct{}{}
+		approved = append(approved, a)
+	}
+	if len(approved) > maxApprovedAddresses {
+		return nil, invalidParam(field, "at most %d approved addresses per treasury", maxApprovedAddresses)
+	}
+	return approved, nil
+}
+
+// Validate normalizes the policy and checks its addresses.
+func (p *TreasuryPolicy) Validate() error {
+	p.Chain = strings.ToLower(strings.TrimSpace(p.Chain))
+	if p.Chain != "" {
+		p.Chain, _ = canonicalNetwork(p.Chain, "")
+	}
+	address, err := canonicalAddress(p.Chain, p.Address)
+	if err != nil {
+		return invalidParam("address", "address: %v", err)
+	}
+	p.Address = address
+	p.Label = strings.TrimSpace(p.Label)
+	approved, err := canonicalApproved("approved", p.Chain, nil, p.Approved)
+	if err != nil {
+		return err
+	}
+	p.Approved = approved
+	return nil
+}
+
+// Violates reports whether ev is a transfer out of the treasury to an
+// address that is not approved. Transfers to itself and orphaned events
+// never are.
+func (p TreasuryPolicy) Violates(ev *Event) bool {
+	if !tenantSees(p.Tenant, ev) || (p.Chain != "" && ev.Chain != p.Chain) || ev.Status == StatusOrphaned {
+		return false
+	}
+	if ev.To == "" || addressKey(ev.From) != addressKey(p.Address) || addressKey(ev.To) == addressKey(p.Address) {
+		return false
+	}
+	to := addressKey(ev.To)
+	for _, a := range p.Approved {
+		if addressKey(a) == to {
+			return false
+		}
+	}
+	return true
+}
+
+// treasuryKey keys a policy on its tenant and address.
+type treasuryKey struct{ tenant, address string }
+
+// TreasuryPolicies keeps the tenants' treasury policies in memory and, when
+// a database is attached, persists them to the treasury_policies table.
+// Check raises an alert for every ingested transfer a policy forbids.
+type TreasuryPolicies struct {
+	mu       sync.RWMutex
+	policies map[treasuryKey]TreasuryPolicy
+	// byAddress indexes the policies on their addressKey, so Check only
+	// looks at the policies of an event's sender.
+	byAddress map[string][]treasuryKey
+	db        *pgxpool.Pool
+	alerts    *SystemEvents
+}
+
+func NewTreasuryPolicies() *TreasuryPolicies {
+	return &TreasuryPolicies{policies: make(map[treasuryKey]TreasuryPolicy), byAddress: make(map[string][]treasuryKey)}
+}
+
+// AttachDB connects the policies to Postgres and loads the stored ones.
+func (s *TreasuryPolicies) AttachDB(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx, `SELECT tenant, address, definition, updated_at FROM treasury_policies`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var loaded []TreasuryPolicy
+	for rows.Next() {
+		var p TreasuryPolicy
+		var tenant, address string
+		var definition []byte
+		var updated time.Time
+		if err := rows.Scan(&tenant, &address, &definition, &updated); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(definition, &p); err != nil {
+			log.WithError(err).WithField("treasury", address).Warn("skipping unreadable treasury policy")
+			continue
+		}
+		p.Tenant = tenant
+		p.UpdatedAt = updated.UTC().Format(time.RFC3339)
+		loaded = append(loaded, p)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policies = make(map[treasuryKey]TreasuryPolicy, len(loaded))
+	s.byAddress = make(map[string][]treasuryKey)
+	for _, p := range loaded {
+		s.setLocked(p)
+	}
+	s.db = db
+	return nil
+}
+
+// AttachAlerts makes Check emit its alerts on events. Violations found
+// before are only logged.
+func (s *TreasuryPolicies) AttachAlerts(events *SystemEvents) {
+	s.mu.Lock()
+	s.alerts = events
+	s.mu.Unlock()
+}
+
+// setLocked stores p and indexes it. Callers hold the write lock.
+func (s *TreasuryPolicies) setLocked(p TreasuryPolicy) {
+	key := treasuryKey{p.Tenant, addressKey(p.Address)}
+	if _, ok := s.policies[key]; !ok {
+		s.byAddress[key.address] = append(s.byAddress[key.address], key)
+	}
+	s.policies[key] = p
+}
+
+// Get returns tenant's policy for address.
+func (s *TreasuryPolicies) Get(tenant, address string) (TreasuryPolicy, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.policies[treasuryKey{tenant, addressKey(address)}]
+	return p, ok
+}
+
+// List returns tenant's policies by address.
+func (s *TreasuryPolicies) List(tenant string) []TreasuryPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]TreasuryPolicy, 0)
+	for key, p := range s.policies {
+		if key.tenant == tenant {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Address < out[j].Address })
+	return out
+}
+
+// Put validates and saves p as tenant's policy for its address, replacing
+// the previous one, and reports whether it is new.
+func (s *TreasuryPolicies) Put(ctx context.Context, p TreasuryPolicy, tenant string) (TreasuryPolicy, bool, error) {
+	if err := p.Validate(); err != nil {
+		return p, false, err
+	}
+	p.Tenant = tenant
+	p.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := s.save(ctx, p); err != nil {
+		return p, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, existed := s.policies[treasuryKey{tenant, addressKey(p.Address)}]
+	s.setLocked(p)
+	return p, !existed, nil
+}
+
+// Update applies change to tenant's policy for address and saves it,
+// unless change fails. It returns false when there is no such policy.
+func (s *TreasuryPolicies) Update(ctx context.Context, tenant, address string, change func(p *TreasuryPolicy) error) (TreasuryPolicy, bool, error) {
+	p, ok := s.Get(tenant, address)
+	if !ok {
+		return p, false, nil
+	}
+	p.Approved = append([]string(nil), p.Approved...)
+	if err := change(&p); err != nil {
+		return p, true, err
+	}
+	p.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := s.save(ctx, p); err != nil {
+		return p, true, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setLocked(p)
+	return p, true, nil
+}
+
+// save persists p when a database is attached.
+func (s *TreasuryPolicies) save(ctx context.Context, p TreasuryPolicy) error {
+	if s.db == nil {
+		return nil
+	}
+	definition, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(ctx, `
+		INSERT INTO treasury_policies (tenant, address, definition) VALUES ($1, $2, $3)
+		ON CONFLICT (tenant, address) DO UPDATE SET definition = EXCLUDED.definition, updated_at = NOW()`,
+		p.Tenant, addressKey(p.Address), definition)
+	return err
+}
+
+// Delete removes tenant's policy for address and reports whether it
+// existed.
+func (s *TreasuryPolicies) Delete(ctx context.Context, tenant, address string) (bool, error) {
+	if _, ok := s.Get(tenant, address); !ok {
+		return false, nil
+	}
+	key := treasuryKey{tenant, addressKey(address)}
+	if s.db != nil {
+		if _, err := s.db.Exec(ctx, `DELETE FROM treasury_policies WHERE tenant = $1 AND address = $2`, key.tenant, key.address); err != nil {
+			return false, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.policies[key]; !ok {
+		return false, nil
+	}
+	delete(s.policies, key)
+	keys := s.byAddress[key.address][:0]
+	for _, k := range s.byAddress[key.address] {
+		if k != key {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		delete(s.byAddress, key.address)
+	} else {
+		s.byAddress[key.address] = keys
+	}
+	return true, nil
+}
+
+// Check raises a critical alert.triggered system event, scoped to the
+// policy's tenant, for every policy ev violates, and returns them.
+func (s *TreasuryPolicies) Check(ev *Event) []TreasuryPolicy {
+	if s == nil || ev.From == "" {
+		return nil
+	}
+	s.mu.RLock()
+	var violated []TreasuryPolicy
+	for _, key := range s.byAddress[addressKey(ev.From)] {
+		if p := s.policies[key]; p.Violates(ev) {
+			violated = append(violated, p)
+		}
+	}
+	alerts := s.alerts
+	s.mu.RUnlock()
+
+	for _, p := range violated {
+		fields := log.Fields{"tenant": p.Tenant, "treasury": p.Address, "to": ev.To, "event_id": ev.EventID}
+		if alerts == nil {
+			log.WithFields(fields).Error("treasury transfer to an unapproved address")
+			continue
+		}
+		if err := alerts.Emit(treasuryAlert(p, ev)); err != nil {
+			log.WithError(err).WithFields(fields).Error("failed to emit treasury alert")
+		}
+	}
+	return violated
+}
+
+// treasuryAlert describes a transfer p forbids.
+func treasuryAlert(p TreasuryPolicy, ev *Event) SystemEvent {
+	name := p.Address
+	if p.Label != "" {
+		name = p.Label
+	}
+	data := map[string]interface{}{
+		"rule":            treasuryAlertRule,
+		"treasury":        p.Address,
+		"counterparty":    ev.To,
+		"event_id":        ev.EventID,
+		"tx_hash":         ev.TxHash,
+		"value":           ev.Value,
+		"event_timestamp": ev.Timestamp,
+	}
+	if ev.Token != nil {
+		data["token"] = ev.Token.Symbol
+	}
+	return SystemEvent{
+		Kind:     SystemAlertTriggered,
+		Severity: SeverityCritical,
+		Tenant:   p.Tenant,
+		Chain:    ev.Chain,
+		Network:  ev.Network,
+		Message:  fmt.Sprintf("treasury %s sent a transfer to unapproved address %s", name, ev.To),
+		Data:     data,
+	}
+}
+
+// treasuryError answers err from a policy change: 400 for invalid input,
+// 500 otherwise.
+func treasuryError(w http.ResponseWriter, err error) {
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		badRequest(w, err)
+		return
+	}
+	log.WithError(err).Error("failed to store treasury policy")
+	httpError(w, "could not store treasury policy", http.StatusInternalServerError)
+}
+
+func writeTreasuryPolicy(w http.ResponseWriter, status int, p TreasuryPolicy) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// putTreasuryPolicy creates or replaces the caller's policy for the
+// {address} treasury.
+func putTreasuryPolicy(policies *TreasuryPolicies, w http.ResponseWriter, r *http.Request) {
+	var p TreasuryPolicy
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&p); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	p.Address = chi.URLParam(r, "address")
+	p, created, err := policies.Put(r.Context(), p, tenantFrom(r.Context()))
+	if err != nil {
+		treasuryError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeTreasuryPolicy(w, status, p)
+}
+
+// listTreasuryPolicies returns the caller's treasury policies.
+func listTreasuryPolicies(policies *TreasuryPolicies, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(policies.List(tenantFrom(r.Context())))
+}
+
+// getTreasuryPolicy returns the caller's policy for a treasury.
+func getTreasuryPolicy(policies *TreasuryPolicies, w http.ResponseWriter, r *http.Request) {
+	p, ok := policies.Get(tenantFrom(r.Context()), chi.URLParam(r, "address"))
+	if !ok {
+		httpError(w, "treasury policy not found", http.StatusNotFound)
+		return
+	}
+	writeTreasuryPolicy(w, http.StatusOK, p)
+}
+
+// deleteTreasuryPolicy removes the caller's policy for a treasury.
+func deleteTreasuryPolicy(policies *TreasuryPolicies, w http.ResponseWriter, r *http.Request) {
+	ok, err := policies.Delete(r.Context(), tenantFrom(r.Context()), chi.URLParam(r, "address"))
+	if err != nil {
+		log.WithError(err).Error("failed to delete treasury policy")
+		httpError(w, "could not delete treasury policy", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "treasury policy not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// approveTreasuryRecipients adds the addresses in the body to a treasury's
+// approved list.
+func approveTreasuryRecipients(policies *TreasuryPolicies, w http.ResponseWriter, r *http.Request) {
+	var body ApprovedAddresses
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&body); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(body.Addresses) == 0 {
+		badRequest(w, invalidParam("addresses", "addresses is required"))
+		return
+	}
+	p, ok, err := policies.Update(r.Context(), tenantFrom(r.Context()), chi.URLParam(r, "address"), func(p *TreasuryPolicy) error {
+		approved, err := canonicalApproved("addresses", p.Chain, p.Approved, body.Addresses)
+		p.Approved = approved
+		return err
+	})
+	if !ok {
+		httpError(w, "treasury policy not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		treasuryError(w, err)
+		return
+	}
+	writeTreasuryPolicy(w, http.StatusOK, p)
+}
+
+// revokeTreasuryRecipient removes the {counterparty} address from a
+// treasury's approved list.
+func revokeTreasuryRecipient(policies *TreasuryPolicies, w http.ResponseWriter, r *http.Request) {
+	counterparty := addressKey(strings.TrimSpace(chi.URLParam(r, "counterparty")))
+	p, ok, err := policies.Update(r.Context(), tenantFrom(r.Context()), chi.URLParam(r, "address"), func(p *TreasuryPolicy) error {
+		kept := p.Approved[:0]
+		for _, a := range p.Approved {
+			if addressKey(a) != counterparty {
+				kept = append(kept, a)
+			}
+		}
+		if len(kept) == len(p.Approved) {
+			return errNotApproved
+		}
+		p.Approved = kept
+		return nil
+	})
+	if !ok {
+		httpError(w, "treasury policy not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errNotApproved) {
+		httpError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		treasuryError(w, err)
+		return
+	}
+	writeTreasuryPolicy(w, http.StatusOK, p)
+}
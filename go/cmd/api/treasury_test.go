@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	treasuryAddr = "0x1111111111111111111111111111111111111111"
+	vendorAddr   = "0x2222222222222222222222222222222222222222"
+	unknownAddr  = "0x3333333333333333333333333333333333333333"
+)
+
+func TestTreasuryPolicyEndpoints(t *testing.T) {
+	policies := NewTreasuryPolicies()
+	do := func(method, target, tenant, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		parts := strings.Split(strings.TrimPrefix(target, "/treasuries/"), "/")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("address", parts[0])
+		if len(parts) == 3 {
+			rctx.URLParams.Add("counterparty", parts[2])
+		}
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		req = req.WithContext(withTenant(req.Context(), tenant))
+		r := httptest.NewRecorder()
+		switch {
+		case method == http.MethodPut:
+			putTreasuryPolicy(policies, r, req)
+		case method == http.MethodPost:
+			approveTreasuryRecipients(policies, r, req)
+		case method == http.MethodDelete && len(parts) == 3:
+			revokeTreasuryRecipient(policies, r, req)
+		case method == http.MethodDelete:
+			deleteTreasuryPolicy(policies, r, req)
+		default:
+			getTreasuryPolicy(policies, r, req)
+		}
+		return r
+	}
+	decode := func(r *httptest.ResponseRecorder) TreasuryPolicy {
+		t.Helper()
+		var p TreasuryPolicy
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return p
+	}
+
+	upper := strings.ToUpper(vendorAddr[2:])
+	r := do(http.MethodPut, "/treasuries/"+treasuryAddr, "acme", `{"label": "Ops treasury", "approved": ["0x`+upper+`", "`+vendorAddr+`"]}`)
+	if r.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", r.Code, r.Body.String())
+	}
+	if p := decode(r); len(p.Approved) != 1 || p.Label != "Ops treasury" {
+		t.Fatalf("created policy = %+v", p)
+	}
+	if r := do(http.MethodPut, "/treasuries/"+treasuryAddr, "acme", `{"approved": []}`); r.Code != http.StatusOK {
+		t.Fatalf("replace: status %d", r.Code)
+	}
+
+	r = do(http.MethodPost, "/treasuries/"+treasuryAddr+"/approved", "acme", `{"addresses": ["`+vendorAddr+`", "`+unknownAddr+`"]}`)
+	if p := decode(r); r.Code != http.StatusOK || len(p.Approved) != 2 {
+		t.Fatalf("approve: status %d, policy %+v", r.Code, p)
+	}
+	r = do(http.MethodDelete, "/treasuries/"+treasuryAddr+"/approved/"+unknownAddr, "acme", "")
+	if p := decode(r); r.Code != http.StatusOK || len(p.Approved) != 1 || p.Approved[0] != vendorAddr {
+		t.Fatalf("revoke: status %d, policy %+v", r.Code, p)
+	}
+	if r := do(http.MethodDelete, "/treasuries/"+treasuryAddr+"/approved/"+unknownAddr, "acme", ""); r.Code != http.StatusNotFound {
+		t.Fatalf("revoking an address that is not approved: status %d", r.Code)
+	}
+
+	// Policies belong to their tenant
+	if r := do(http.MethodGet, "/treasuries/"+treasuryAddr, "other", ""); r.Code != http.StatusNotFound {
+		t.Fatalf("other tenant: status %d", r.Code)
+	}
+	for _, body := range []string{`{"approved": ["nope"]}`, `{"chain": "solana"}`} {
+		if r := do(http.MethodPut, "/treasuries/"+treasuryAddr, "acme", body); r.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, r.Code)
+		}
+	}
+	if r := do(http.MethodPost, "/treasuries/"+treasuryAddr+"/approved", "acme", `{"addresses": []}`); r.Code != http.StatusBadRequest {
+		t.Fatalf("empty approval: status %d", r.Code)
+	}
+
+	if r := do(http.MethodDelete, "/treasuries/"+treasuryAddr, "acme", ""); r.Code != http.StatusNoContent {
+		t.Fatalf("delete: status %d", r.Code)
+	}
+	if len(policies.List("acme")) != 0 || len(policies.byAddress) != 0 {
+		t.Fatalf("policy still indexed after delete")
+	}
+}
+
+func TestTreasuryPoliciesAlertOnUnapprovedRecipients(t *testing.T) {
+	policies := NewTreasuryPolicies()
+	hub := NewHub()
+	policies.AttachAlerts(NewSystemEvents(hub, NewHub(), nil))
+	if _, _, err := policies.Put(context.Background(), TreasuryPolicy{Address: treasuryAddr, Chain: "eth", Approved: []string{vendorAddr}}, "acme"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	transfer := func(id, from, to string) *Event {
+		ev := makeEvent(id, from, to, "5", time.Now().UTC().Format(time.RFC3339), "USDC")
+		ev.Chain, ev.Tenant = "ethereum", "acme"
+		return ev
+	}
+	unapproved := transfer("1", treasuryAddr, unknownAddr)
+	otherTenant := transfer("4", treasuryAddr, unknownAddr)
+	otherTenant.Tenant = "other"
+	otherChain := transfer("5", treasuryAddr, unknownAddr)
+	otherChain.Chain = "base"
+	for _, ev := range []*Event{transfer("2", treasuryAddr, vendorAddr), transfer("3", unknownAddr, treasuryAddr), otherTenant, otherChain} {
+		if violated := policies.Check(ev); len(violated) != 0 {
+			t.Fatalf("event %s raised %+v", ev.EventID, violated)
+		}
+	}
+	if violated := policies.Check(unapproved); len(violated) != 1 {
+		t.Fatalf("expected one violation, got %+v", violated)
+	}
+
+	frames := hub.queue.take()
+	if len(frames) != 1 || !containsToken(frames[0].Tenants, "acme") || len(frames[0].Tenants) != 1 {
+		t.Fatalf("alert frames = %+v", frames)
+	}
+	var alert SystemEvent
+	if err := json.Unmarshal(frames[0].Data, &alert); err != nil {
+		t.Fatalf("decode alert: %v", err)
+	}
+	if alert.Kind != SystemAlertTriggered || alert.Severity != SeverityCritical || alert.Data["rule"] != treasuryAlertRule ||
+		alert.Data["counterparty"] != unknownAddr || alert.Data["event_id"] != "1" {
+		t.Fatalf("alert = %+v", alert)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+func init() {
+	RegisterChainAdapter(tronAdapter{})
+}
+
+// tronAddressPrefix is the first byte of every Tron mainnet and testnet
+// address, before the 20 bytes of the key hash.
+const tronAddressPrefix = 0x41
+
+var (
+	errBadTronChecksum = errors.New("base58check checksum does not match")
+	errNoTronHistory   = errors.New("history import is not supported on tron")
+)
+
+// tronAdapter handles Tron: base58check addresses starting with T, which
+// are case-sensitive and kept as they are, and the Ethereum-compatible
+// JSON-RPC API of Tron full nodes (/jsonrpc). Addresses given in the
+// 41-prefixed hex form of the node APIs are stored in base58check.
+type tronAdapter struct{}
+
+func (tronAdapter) Chain() string { return "tron" }
+
+func (a tronAdapter) Normalize(ev *Event) error { return normalizeEvent(a, ev) }
+
+func (tronAdapter) Validate(address string) (string, error) {
+	if isTronAddress(address) {
+		return address, nil
+	}
+	if len(address) == 34 && address[0] == 'T' {
+		if payload, ok := decodeBase58(address); ok && len(payload) == 25 && payload[0] == tronAddressPrefix {
+			return "", errBadTronChecksum
+		}
+	}
+	h := strings.TrimPrefix(strings.TrimPrefix(address, "0x"), "0X")
+	if len(h) == 42 && strings.HasPrefix(h, "41") && isHex(h) {
+		payload, _ := hex.DecodeString(h)
+		return tronAddress(payload[1:]), nil
+	}
+	return "", errNotChainAddress
+}
+
+// TxHash returns hash as 0x-prefixed lowercase hex, the form the JSON-RPC
+// API takes and returns, so lookups by a hash pasted from an explorer
+// without 0x find it as on EVM chains.
+func (tronAdapter) TxHash(hash string) (string, bool) {
+	return evmAdapter{chain: "tron"}.TxHash(hash)
+}
+
+func (tronAdapter) Subscribe(network string) string { return eventsChannel("tron", network) }
+
+// Backfill returns the transaction and its receipt from the JSON-RPC API.
+func (tronAdapter) Backfill(ctx context.Context, call RPCCaller, hash string) (json.RawMessage, bool, error) {
+	return evmAdapter{chain: "tron"}.Backfill(ctx, call, hash)
+}
+
+// History is not supported: the JSON-RPC API has no account history and
+// its logs carry hex addresses.
+func (tronAdapter) History(ctx context.Context, call RPCCaller, address string, bound HistoryBound) ([]*Event, error) {
+	return nil, errNoTronHistory
+}
+
+func (tronAdapter) HeadMethod() string { return "eth_blockNumber" }
+
+func (tronAdapter) NativeDecimals() (int, bool) { return 6, true }
+
+func (tronAdapter) NativeSymbol() string { return "TRX" }
+
+// VerifyMessage checks a TIP-191 signMessageV2 signature: 65 hex-encoded
+// bytes, r || s || v, over the message behind the "\x19TRON Signed
+// Message:\n" prefix, whose recovered signer must be address.
+func (tronAdapter) VerifyMessage(address, message, signature string) error {
+	signature = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(signature), "0x"), "0X")
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return errBadSignature
+	}
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte("\x19TRON Signed Message:\n" + strconv.Itoa(len(message)) + message))
+	signer, err := ecrecoverAddress(h.Sum(nil), sig)
+	if err != nil {
+		return err
+	}
+	key, _ := hex.DecodeString(signer[2:])
+	if tronAddress(key) != address {
+		return errBadSignature
+	}
+	return nil
+}
+
+// isTronAddress reports whether a is a base58check Tron address: the
+// prefix byte, a 20-byte key hash and a 4-byte checksum.
+func isTronAddress(a string) bool {
+	if len(a) != 34 || a[0] != 'T' {
+		return false
+	}
+	payload, ok := decodeBase58(a)
+	return ok && len(payload) == 25 && payload[0] == tronAddressPrefix && bytes.Equal(tronChecksum(payload[:21]), payload[21:])
+}
+
+// tronAddress returns the base58check address of a 20-byte key hash.
+func tronAddress(keyHash []byte) string {
+	payload := append([]byte{tronAddressPrefix}, keyHash...)
+	return encodeBase58(append(payload, tronChecksum(payload)...))
+}
+
+// tronChecksum is the first 4 bytes of the double SHA-256 of payload.
+func tronChecksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[:4]
+}
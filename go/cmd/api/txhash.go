@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var errUnrecognizedHash = errors.New("unrecognized transaction hash format")
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isBase58(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune(base58Alphabet, c) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// isEVMHash reports whether h is a 32-byte hex hash, with or without 0x.
+func isEVMHash(h string) bool {
+	h = strings.TrimPrefix(strings.TrimPrefix(h, "0x"), "0X")
+	return len(h) == 64 && isHex(h)
+}
+
+// isSolanaSignature reports whether h looks like a base58-encoded 64-byte
+// Solana transaction signature.
+func isSolanaSignature(h string) bool {
+	return len(h) >= 64 && len(h) <= 88 && isBase58(h)
+}
+
+// normalizeTxHash returns the canonical form of a transaction hash as the
+// chain's adapter defines it: 0x-prefixed lowercase hex for EVM chains, and
+// the base58 signature unchanged for Solana (base58 is case-sensitive).
+// Unrecognized hashes are only trimmed.
+func normalizeTxHash(chain, hash string) string {
+	hash = strings.TrimSpace(hash)
+	if canonical, ok := chainAdapter(chain).TxHash(hash); ok {
+		return canonical
+	}
+	return hash
+}
+
+// canonicalTxHash normalizes a user-supplied hash whose chain is unknown.
+func canonicalTxHash(hash string) (string, error) {
+	hash = strings.TrimSpace(hash)
+	switch {
+	case isEVMHash(hash):
+		return normalizeTxHash("", hash), nil
+	case isSolanaSignature(hash):
+		return hash, nil
+	}
+	return "", errUnrecognizedHash
+}
+
+// GetByTxHash returns every event of a transaction, matching stored hashes in
+// any casing or prefix form. The hash must already be canonical.
+func (s *EventStore) GetByTxHash(hash, chain string) []*Event {
+	return s.read(func(ctx context.Context, r EventRepository) ([]*Event, error) {
+		return r.ByTxHash(ctx, hash, chain)
+	})
+}
+
+// getTransactionByHash looks up a transaction by hash in whatever format the
+// user pasted: with or without 0x, any case, or a Solana base58 signature.
+func getTransactionByHash(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	hash, err := canonicalTxHash(chi.URLParam(r, "hash"))
+	if err != nil {
+		badRequest(w, invalidParam("hash", "%v", err))
+		return
+	}
+	profile, err := parseProfile(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	p := newQueryParams(r)
+	chain, _ := p.Network()
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	current, err := currentVersions(r, store.GetByTxHash(hash, chain))
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	events := tenantEvents(tenantFrom(r.Context()), current)
+	if len(events) == 0 {
+		httpError(w, "transaction not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(withProfile(profile, store.Enrich(events)))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testEVMHash = "0x5c504ed432cb51138bcf09aa5e8a410dd4a1e204ef84bfed1be16dfba1b22060"
+	testSolSig  = "5VERv8NMvzbJMEkV8xnrLkEaWRtSz9CosKDYjCJjBRnbJLgp8uirBgmQpjKhoR4tjF3ZpRzrFmBV6UjKdiSZkQUW"
+)
+
+func TestNormalizeTxHash(t *testing.T) {
+	bare := strings.ToUpper(strings.TrimPrefix(testEVMHash, "0x"))
+	cases := []struct{ chain, in, want string }{
+		{"ethereum", testEVMHash, testEVMHash},
+		{"ethereum", bare, testEVMHash},
+		{"ethereum", "0X" + bare, testEVMHash},
+		{"ethereum", "  " + testEVMHash + "\n", testEVMHash},
+		{"solana", testSolSig, testSolSig},
+		{"tron", bare, testEVMHash},
+		{"ethereum", "hash", "hash"},
+	}
+	for _, c := range cases {
+		if got := normalizeTxHash(c.chain, c.in); got != c.want {
+			t.Errorf("normalizeTxHash(%q, %q) = %q, want %q", c.chain, c.in, got, c.want)
+		}
+	}
+
+	if _, err := canonicalTxHash("0x1234"); err == nil {
+		t.Errorf("expected short hex hash to be rejected")
+	}
+	if _, err := canonicalTxHash("not a hash!"); err == nil {
+		t.Errorf("expected garbage to be rejected")
+	}
+	if got, err := canonicalTxHash(testSolSig); err != nil || got != testSolSig {
+		t.Errorf("expected solana signature to be kept as is, got %q err=%v", got, err)
+	}
+}
+
+func TestGetTransactionByHashAnyFormat(t *testing.T) {
+	store := NewEventStore(100, 50)
+	ts := time.Now().UTC().Format(time.RFC3339)
+
+	eth := makeEvent("eth-1", "alice", "bob", "1", ts, "")
+	eth.Chain = "ethereum"
+	// Producers may emit uppercase or unprefixed hashes
+	eth.TxHash = strings.ToUpper(strings.TrimPrefix(testEVMHash, "0x"))
+	store.Add(eth)
+	sol := makeEvent("sol-1", "carol", "dave", "1", ts, "")
+	sol.TxHash = testSolSig
+	store.Add(sol)
+
+	lookup := func(hash string) (int, []*Event) {
+		r := httptest.NewRecorder()
+		req := withChiParam(httptest.NewRequest(http.MethodGet, "/tx/"+hash, nil), "hash", hash)
+		getTransactionByHash(store, r, req)
+		var events []*Event
+		if r.Code == http.StatusOK {
+			_ = json.NewDecoder(r.Body).Decode(&events)
+		}
+		return r.Code, events
+	}
+
+	for _, h := range []string{testEVMHash, strings.TrimPrefix(testEVMHash, "0x"), strings.ToUpper(testEVMHash[2:])} {
+		code, events := lookup(h)
+		if code != http.StatusOK || len(events) != 1 || events[0].EventID != "eth-1" {
+			t.Fatalf("lookup %q: code=%d events=%+v", h, code, events)
+		}
+		if events[0].TxHash != testEVMHash {
+			t.Fatalf("expected stored hash to be canonical, got %q", events[0].TxHash)
+		}
+	}
+
+	if code, events := lookup(testSolSig); code != http.StatusOK || len(events) != 1 || events[0].EventID != "sol-1" {
+		t.Fatalf("solana lookup: code=%d events=%+v", code, events)
+	}
+	// Base58 is case-sensitive, so a re-cased signature must not match
+	if code, _ := lookup(strings.Replace(testSolSig, "V", "v", 1)); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for re-cased signature, got %d", code)
+	}
+	if code, _ := lookup("xyz"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unrecognized hash, got %d", code)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxPageSize caps limit on list endpoints; larger values are clamped.
+const maxPageSize = 500
+
+// Error codes of ErrorResponse. Statuses without a specific code use
+// codeForStatus.
+const (
+	ErrCodeInvalidParameter = "invalid_parameter"
+	ErrCodeInvalidBody      = "invalid_body"
+	ErrCodeUnauthorized     = "unauthorized"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeConflict         = "conflict"
+	ErrCodeGone             = "gone"
+	ErrCodeRateLimited      = "rate_limited"
+	ErrCodeUnavailable      = "unavailable"
+	ErrCodeInternal         = "internal"
+)
+
+// ErrorResponse is the body of every error response. Field names the
+// offending query parameter or body field when there is one.
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+// FieldError reports an invalid request parameter.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string { return e.Message }
+
+func invalidParam(field, format string, args ...interface{}) *FieldError {
+	return &FieldError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// codeForStatus returns the error code used for a status without a more
+// specific one.
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrCodeInvalidBody
+	case http.StatusUnauthorized:
+		return ErrCodeUnauthorized
+	case http.StatusNotFound:
+		return ErrCodeNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrCodeMethodNotAllowed
+	case http.StatusConflict:
+		return ErrCodeConflict
+	case http.StatusGone:
+		return ErrCodeGone
+	case http.StatusTooManyRequests:
+		return ErrCodeRateLimited
+	case http.StatusServiceUnavailable:
+		return ErrCodeUnavailable
+	default:
+		return ErrCodeInternal
+	}
+}
+
+// writeError replies with body as JSON.
+func writeError(w http.ResponseWriter, status int, body ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// httpError replies with msg in an ErrorResponse, like http.Error does with
+// plain text.
+func httpError(w http.ResponseWriter, msg string, status int) {
+	writeError(w, status, ErrorResponse{Code: codeForStatus(status), Message: msg})
+}
+
+// badRequest replies 400 with err, naming the field when err is a
+// FieldError.
+func badRequest(w http.ResponseWriter, err error) {
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		writeError(w, http.StatusBadRequest, ErrorResponse{Code: ErrCodeInvalidParameter, Message: fe.Message, Field: fe.Field})
+		return
+	}
+	httpError(w, err.Error(), http.StatusBadRequest)
+}
+
+// notFoundHandler and methodNotAllowedHandler give unknown routes the same
+// envelope as handler errors.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	httpError(w, "no route for "+r.URL.Path, http.StatusNotFound)
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	httpError(w, r.Method+" is not allowed on "+r.URL.Path, http.StatusMethodNotAllowed)
+}
+
+// queryParams parses query parameters, keeping the first invalid one so a
+// handler can read every parameter and check Err once.
+type queryParams struct {
+	v   url.Values
+	err *FieldError
+}
+
+func newQueryParams(r *http.Request) *queryParams {
+	return &queryParams{v: r.URL.Query()}
+}
+
+// Err returns the first invalid parameter, or nil.
+func (p *queryParams) Err() error {
+	if p.err == nil {
+		return nil
+	}
+	return p.err
+}
+
+func (p *queryParams) fail(field, format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = invalidParam(field, format, args...)
+	}
+}
+
+// String returns the trimmed parameter.
+func (p *queryParams) String(name string) string {
+	return strings.TrimSpace(p.v.Get(name))
+}
+
+// Enum returns the parameter, which must be empty or one of allowed.
+func (p *queryParams) Enum(name string, allowed ...string) string {
+	s := p.String(name)
+	if s == "" {
+		return ""
+	}
+	for _, a := range allowed {
+		if s == a {
+			return s
+		}
+	}
+	p.fail(name, "%s must be one of %s", name, strings.Join(allowed, ", "))
+	return ""
+}
+
+// Int returns the parameter, or def when absent. It must be an integer of
+// at least min; values above max are clamped to max.
+func (p *queryParams) Int(name string, def, min, max int) int {
+	s := p.String(name)
+	if s == "" {
+		return def
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		p.fail(name, "%s must be an integer", name)
+		return def
+	}
+	if n < min {
+		p.fail(name, "%s must be at least %d", name, min)
+		return def
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// Float returns the parameter, or def when absent. It must be a number in
+// [min, max].
+func (p *queryParams) Float(name string, def, min, max float64) float64 {
+	s := p.String(name)
+	if s == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		p.fail(name, "%s must be a number", name)
+		return def
+	}
+	if f < min || f > max {
+		p.fail(name, "%s must be between %g and %g", name, min, max)
+		return def
+	}
+	return f
+}
+
+// Decimal returns the parameter as an exact non-negative number, or nil
+// when absent.
+func (p *queryParams) Decimal(name string) *big.Rat {
+	s := p.String(name)
+	if s == "" {
+		return nil
+	}
+	d, ok := new(big.Rat).SetString(s)
+	if !ok {
+		p.fail(name, "%s must be a number", name)
+		return nil
+	}
+	if d.Sign() < 0 {
+		p.fail(name, "%s must not be negative", name)
+		return nil
+	}
+	return d
+}
+
+// Address returns the parameter as a canonical address on chain (any
+// supported chain when empty), or "" when absent.
+func (p *queryParams) Address(name, chain string) string {
+	s := p.String(name)
+	if s == "" {
+		return ""
+	}
+	address, err := canonicalAddress(chain, s)
+	if err != nil {
+		p.fail(name, "%s: %v", name, err)
+		return ""
+	}
+	return address
+}
+
+// Network returns the chain and network parameters in canonical form. The
+// chain may be an alias such as eth, or a CAIP-2 chain id such as eip155:1
+// that sets the network too.
+func (p *queryParams) Network() (chain, network string) {
+	chain, network, err := resolveNetwork(p.String("chain"), p.String("network"))
+	if err != nil {
+		p.fail("chain", "%v", err)
+	}
+	return chain, network
+}
+
+// pathAddress returns the {address} route parameter as a canonical address
+// on chain (any supported chain when empty).
+func pathAddress(r *http.Request, chain string) (string, error) {
+	address, err := canonicalAddress(chain, chi.URLParam(r, "address"))
+	if err != nil {
+		return "", invalidParam("address", "address: %v", err)
+	}
+	return address, nil
+}
+
+// Bool returns the parameter, or def when absent.
+func (p *queryParams) Bool(name string, def bool) bool {
+	s := p.String(name)
+	if s == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		p.fail(name, "%s must be true or false", name)
+	}
+	return b
+}
+
+// Time returns the parameter as an RFC3339 time, or nil when absent.
+func (p *queryParams) Time(name string) *time.Time {
+	s := p.String(name)
+	if s == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		p.fail(name, "%s must be an RFC3339 time", name)
+		return nil
+	}
+	return &t
+}
+
+// Page returns the limit and offset parameters.
+func (p *queryParams) Page() (limit, offset int) {
+	return p.Int("limit", defaultPageSize, 1, maxPageSize), p.Int("offset", 0, 0, math.MaxInt32)
+}
+
+// parseEventFilter reads the filter parameters shared by event listings,
+// scoped to the caller's tenant.
+func parseEventFilter(r *http.Request) (EventFilter, error) {
+	p := newQueryParams(r)
+	chain, network := p.Network()
+	f := EventFilter{
+		Chain:     chain,
+		Network:   network,
+		Tenant:    tenantFrom(r.Context()),
+		Token:     p.String("token"),
+		From:      p.Address("from", chain),
+		To:        p.Address("to", chain),
+		Status:    p.Enum("status", StatusPending, StatusConfirmed, StatusFinalized, StatusOrphaned),
+		Bridge:    p.Enum("bridge", BridgeWormhole, BridgeLayerZero, BridgeCCTP),
+		Sequence:  p.String("sequence"),
+		Source:    p.String("source"),
+		SortBy:    p.Enum("sort_by", SortTimestamp, SortValue, SortBlockNumber),
+		SortOrder: p.Enum("sort_order", "asc", "desc"),
+	}
+	f.Limit, f.Offset = p.Page()
+	f.IncludeTotal = p.Bool("include_total", true)
+	f.IncludeHistory = p.Bool("include_history", false)
+	f.MinValue = p.Decimal("min_value")
+	f.StartTime = p.Time("start_time")
+	f.EndTime = p.Time("end_time")
+	if p.err == nil && f.StartTime != nil && f.EndTime != nil && f.EndTime.Before(*f.StartTime) {
+		p.fail("end_time", "end_time must not be before start_time")
+	}
+	return f, p.Err()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func decodeError(t *testing.T, r *httptest.ResponseRecorder) ErrorResponse {
+	t.Helper()
+	var body ErrorResponse
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		t.Fatalf("decode error body %q: %v", r.Body.String(), err)
+	}
+	return body
+}
+
+func TestHTTPErrorEnvelope(t *testing.T) {
+	r := httptest.NewRecorder()
+	httpError(r, "label not found", http.StatusNotFound)
+	body := decodeError(t, r)
+	if r.Code != http.StatusNotFound || body.Code != ErrCodeNotFound || body.Message != "label not found" || body.Field != "" {
+		t.Fatalf("error response = %d %+v", r.Code, body)
+	}
+}
+
+func TestInvalidQueryParams(t *testing.T) {
+	store := NewEventStore(100, 50)
+	store.Add(makeEvent("1", "alice", "bob", "5", time.Now().UTC().Format(time.RFC3339), ""))
+
+	tests := []struct {
+		query, field string
+	}{
+		{"limit=abc", "limit"},
+		{"limit=0", "limit"},
+		{"offset=-1", "offset"},
+		{"min_value=lots", "min_value"},
+		{"start_time=yesterday", "start_time"},
+		{"end_time=2025-10-14", "end_time"},
+		{"start_time=2025-10-15T00:00:00Z&end_time=2025-10-14T00:00:00Z", "end_time"},
+		{"status=lost", "status"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRecorder()
+		getTransactions(store, r, httptest.NewRequest(http.MethodGet, "/transactions?"+tt.query, nil))
+		if r.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", tt.query, r.Code)
+			continue
+		}
+		if body := decodeError(t, r); body.Code != ErrCodeInvalidParameter || body.Field != tt.field || body.Message == "" {
+			t.Errorf("%s: body %+v, want field %s", tt.query, body, tt.field)
+		}
+	}
+}
+
+func TestLimitIsCapped(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/transactions?limit=100000", nil)
+	filter, err := parseEventFilter(req)
+	if err != nil || filter.Limit != maxPageSize {
+		t.Fatalf("limit = %d, %v; want %d", filter.Limit, err, maxPageSize)
+	}
+}
+
+func TestWalletTransactionsUnknownAddress(t *testing.T) {
+	store := NewEventStore(100, 50)
+	store.Add(makeEvent("1", aliceAddr, bobAddr, "5", time.Now().UTC().Format(time.RFC3339), ""))
+	router := chi.NewRouter()
+	router.NotFound(notFoundHandler)
+	router.Get("/wallet/{address}/transactions", func(w http.ResponseWriter, r *http.Request) {
+		getWalletTransactions(store, w, r)
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRecorder()
+		router.ServeHTTP(r, httptest.NewRequest(http.MethodGet, path, nil))
+		return r
+	}
+	if r := get("/wallet/0x0000000000000000000000000000000000000bad/transactions"); r.Code != http.StatusNotFound || decodeError(t, r).Code != ErrCodeNotFound {
+		t.Fatalf("unknown address: %d %s", r.Code, r.Body.String())
+	}
+	// A known wallet whose filter matches nothing is an empty page
+	if r := get("/wallet/" + aliceAddr + "/transactions?chain=base"); r.Code != http.StatusOK || r.Body.String() != "[]\n" {
+		t.Fatalf("filtered known wallet: %d %s", r.Code, r.Body.String())
+	}
+	if r := get("/no/such/route"); r.Code != http.StatusNotFound || decodeError(t, r).Code != ErrCodeNotFound {
+		t.Fatalf("unknown route: %d %s", r.Code, r.Body.String())
+	}
+}
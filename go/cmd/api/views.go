@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxViewTokens caps the token symbols of a saved view.
+const maxViewTokens = 50
+
+// viewsSchema creates the table saved views are persisted in. The filter
+// set is stored as the view's JSON definition.
+const viewsSchema = `
+	CREATE TABLE IF NOT EXISTS views (
+		id TEXT PRIMARY KEY,
+		tenant TEXT NOT NULL DEFAULT '',
+		definition JSONB NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+`
+
+// View is a saved, named filter set. Its ID can be shared with anyone who
+// can read the creator's tenant's events.
+type View struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Chain     string   `json:"chain,omitempty"`
+	Network   string   `json:"network,omitempty"`
+	Tokens    []string `json:"tokens,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+	// MinValue and MaxValue bound the value in whole units, as min_value
+	// does on GET /transactions.
+	MinValue  string `json:"min_value,omitempty"`
+	MaxValue  string `json:"max_value,omitempty"`
+	Status    string `json:"status,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+	// Tenant owns the view; other tenants cannot see it.
+	Tenant string `json:"-"`
+}
+
+// Validate normalizes the view's filters and checks them.
+func (v *View) Validate() error {
+	v.Name = strings.TrimSpace(v.Name)
+	if v.Name == "" {
+		return invalidParam("name", "name is required")
+	}
+	v.Chain = strings.TrimSpace(v.Chain)
+	v.Network = strings.TrimSpace(v.Network)
+
+	tokens := make([]string, 0, len(v.Tokens))
+	for _, t := range v.Tokens {
+		if t = strings.TrimSpace(t); t != "" && !containsToken(tokens, t) {
+			tokens = append(tokens, t)
+		}
+	}
+	if len(tokens) > maxViewTokens {
+		return invalidParam("tokens", "at most %d tokens per view", maxViewTokens)
+	}
+	v.Tokens = tokens
+
+	addresses := make([]string, 0, len(v.Addresses))
+	seen := make(map[string]struct{}, len(v.Addresses))
+	for i, a := range v.Addresses {
+		if strings.TrimSpace(a) == "" {
+			continue
+		}
+		a, err := canonicalAddress(v.Chain, a)
+		if err != nil {
+			return invalidParam("addresses", "addresses[%d]: %v", i, err)
+		}
+		if _, dup := seen[addressKey(a)]; dup {
+			continue
+		}
+		seen[addressKey(a)] = struct{}{}
+		addresses = append(addresses, a)
+	}
+	if len(addresses) > maxBulkWallets {
+		return invalidParam("addresses", "at most %d addresses per view", maxBulkWallets)
+	}
+	v.Addresses = addresses
+
+	min, err := parseViewBound("min_value", &v.MinValue)
+	if err != nil {
+		return err
+	}
+	max, err := parseViewBound("max_value", &v.MaxValue)
+	if err != nil {
+		return err
+	}
+	if min != nil && max != nil && max.Cmp(min) < 0 {
+		return invalidParam("max_value", "max_value must not be below min_value")
+	}
+
+	switch v.Status {
+	case "", StatusPending, StatusConfirmed, StatusFinalized, StatusOrphaned:
+		return nil
+	}
+	return invalidParam("status", "status must be one of %s, %s, %s, %s", StatusPending, StatusConfirmed, StatusFinalized, StatusOrphaned)
+}
+
+// parseViewBound trims a value bound and parses it like the min_value query
+// parameter; an empty bound is nil.
+func parseViewBound(field string, s *string) (*big.Rat, error) {
+	*s = strings.TrimSpace(*s)
+	if *s == "" {
+		return nil, nil
+	}
+	d, ok := new(big.Rat).SetString(*s)
+	if !ok {
+		return nil, invalidParam(field, "%s must be a number", field)
+	}
+	if d.Sign() < 0 {
+		return nil, invalidParam(field, "%s must not be negative", field)
+	}
+	return d, nil
+}
+
+// Filter returns the view's filter set as an event filter scoped to its
+// tenant. Addresses are matched separately, as either side of an event.
+func (v View) Filter() EventFilter {
+	f := EventFilter{
+		Chain:   v.Chain,
+		Network: v.Network,
+		Tokens:  v.Tokens,
+		Status:  v.Status,
+		Tenant:  v.Tenant,
+	}
+	// Validate has already checked the bounds
+	if v.MinValue != "" {
+		f.MinValue, _ = new(big.Rat).SetString(v.MinValue)
+	}
+	if v.MaxValue != "" {
+		f.MaxValue, _ = new(big.Rat).SetString(v.MaxValue)
+	}
+	return f
+}
+
+// Matches reports whether a live event belongs to the view.
+func (v View) Matches(ev *Event) bool {
+	if !v.Filter().Matches(ev) {
+		return false
+	}
+	if len(v.Addresses) == 0 {
+		return true
+	}
+	from, to := addressKey(ev.From), addressKey(ev.To)
+	for _, a := range v.Addresses {
+		if key := addressKey(a); key == from || key == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ViewStore keeps saved views in memory and, when a database is attached,
+// persists them to the views table.
+type ViewStore struct {
+	mu    sync.RWMutex
+	views map[string]View
+	db    *pgxpool.Pool
+}
+
+func NewViewStore() *ViewStore {
+	return &ViewStore{views: make(map[string]View)}
+}
+
+// AttachDB connects the store to Postgres and loads the existing views.
+func (s *ViewStore) AttachDB(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx, `SELECT id, tenant, definition, created_at FROM views`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	loaded := make(map[string]View)
+	for rows.Next() {
+		var v View
+		var id, tenant string
+		var definition []byte
+		var created time.Time
+		if err := rows.Scan(&id, &tenant, &definition, &created); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(definition, &v); err != nil {
+			log.WithError(err).WithField("view", id).Warn("skipping unreadable saved view")
+			continue
+		}
+		v.ID, v.Tenant = id, tenant
+		v.CreatedAt = created.UTC().Format(time.RFC3339)
+		loaded[v.ID] = v
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.views = loaded
+	s.db = db
+	s.mu.Unlock()
+	return nil
+}
+
+// Create validates and saves a new view for tenant.
+func (s *ViewStore) Create(ctx context.Context, v View, tenant string) (View, error) {
+	if err := v.Validate(); err != nil {
+		return v, err
+	}
+	v.ID = newRandomID()
+	v.Tenant = tenant
+	v.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	if s.db != nil {
+		definition, err := json.Marshal(v)
+		if err != nil {
+			return v, err
+		}
+		if _, err := s.db.Exec(ctx, `INSERT INTO views (id, tenant, definition) VALUES ($1, $2, $3)`,
+			v.ID, v.Tenant, definition); err != nil {
+			return v, err
+		}
+	}
+	s.mu.Lock()
+	s.views[v.ID] = v
+	s.mu.Unlock()
+	return v, nil
+}
+
+// Get returns the view with id if tenant can see it.
+func (s *ViewStore) Get(id, tenant string) (View, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.views[id]
+	if !ok || (tenant != "" && v.Tenant != tenant) {
+		return View{}, false
+	}
+	return v, true
+}
+
+// List returns the views tenant can see, newest first.
+func (s *ViewStore) List(tenant string) []View {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]View, 0, len(s.views))
+	for _, v := range s.views {
+		if tenant == "" || v.Tenant == tenant {
+			out = append(out, v)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt != out[j].CreatedAt {
+			return out[i].CreatedAt > out[j].CreatedAt
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// Delete removes a view tenant can see and reports whether it existed.
+func (s *ViewStore) Delete(ctx context.Context, id, tenant string) (bool, error) {
+	if _, ok := s.Get(id, tenant); !ok {
+		return false, nil
+	}
+	if s.db != nil {
+		if _, err := s.db.Exec(ctx, `DELETE FROM views WHERE id = $1`, id); err != nil {
+			return false, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.views[id]
+	delete(s.views, id)
+	return ok, nil
+}
+
+// createView saves the filter set in the body as a named view.
+func createView(views *ViewStore, w http.ResponseWriter, r *http.Request) {
+	var v View
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&v); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	v, err := views.Create(r.Context(), v, tenantFrom(r.Context()))
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		badRequest(w, err)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to store view")
+		httpError(w, "could not store view", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/views/"+v.ID)
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// listViews returns the caller's saved views.
+func listViews(views *ViewStore, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(views.List(tenantFrom(r.Context())))
+}
+
+// lookupView resolves the {id} path parameter, writing a 404 when the
+// caller cannot see the view.
+func lookupView(views *ViewStore, w http.ResponseWriter, r *http.Request) (View, bool) {
+	v, ok := views.Get(chi.URLParam(r, "id"), tenantFrom(r.Context()))
+	if !ok {
+		httpError(w, "view not found", http.StatusNotFound)
+	}
+	return v, ok
+}
+
+// getView returns a saved view's definition.
+func getView(views *ViewStore, w http.ResponseWriter, r *http.Request) {
+	v, ok := lookupView(views, w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// deleteView removes a saved view.
+func deleteView(views *ViewStore, w http.ResponseWriter, r *http.Request) {
+	ok, err := views.Delete(r.Context(), chi.URLParam(r, "id"), tenantFrom(r.Context()))
+	if err != nil {
+		log.WithError(err).Error("failed to delete view")
+		httpError(w, "could not delete view", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "view not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getViewTransactions returns a page of the events matching a saved view,
+// newest first, paginated like GET /transactions.
+func getViewTransactions(views *ViewStore, store *EventStore, w http.ResponseWriter, r *http.Request) {
+	v, ok := lookupView(views, w, r)
+	if !ok {
+		return
+	}
+	profile, err := parseProfile(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	filter := v.Filter()
+	p := newQueryParams(r)
+	filter.Limit, filter.Offset = p.Page()
+	filter.IncludeTotal = p.Bool("include_total", true)
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	fetch := store.GetRecent
+	if len(v.Addresses) > 0 {
+		fetch = func(f EventFilter) []*Event { return store.GetByWallets(v.Addresses, f) }
+	}
+	page := store.Page(v.Addresses, filter, fetch)
+	setPaginationHeaders(w, r, filter, page)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(withProfile(profile, store.Enrich(page.events)))
+}
+
+// subscribeView streams the live events matching a saved view over SSE.
+// Status changes and other non-event frames are not delivered.
+func subscribeView(views *ViewStore, hub *Hub, w http.ResponseWriter, r *http.Request) {
+	v, ok := lookupView(views, w, r)
+	if !ok {
+		return
+	}
+	streamSSE(hub, w, r, "", nil, func(frame Frame) bool {
+		var ev struct {
+			Type string `json:"type"`
+			Event
+		}
+		if err := json.Unmarshal(frame.Data, &ev); err != nil || ev.Type != "" || ev.EventID == "" {
+			return false
+		}
+		return v.Matches(&ev.Event)
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestViewValidate(t *testing.T) {
+	v := View{Name: " whales ", Tokens: []string{"USDC", " USDC", ""}, Addresses: []string{aliceAddr, strings.ToUpper(aliceAddr)}, MinValue: "1.5"}
+	if err := v.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if v.Name != "whales" || len(v.Tokens) != 1 || len(v.Addresses) != 1 || v.Addresses[0] != checksumAddress(aliceAddr) {
+		t.Fatalf("normalized view = %+v", v)
+	}
+
+	for field, bad := range map[string]View{
+		"name":      {},
+		"addresses": {Name: "x", Addresses: []string{"nobody"}},
+		"min_value": {Name: "x", MinValue: "-1"},
+		"max_value": {Name: "x", MinValue: "10", MaxValue: "1"},
+		"status":    {Name: "x", Status: "lost"},
+	} {
+		err := bad.Validate()
+		if fe, ok := err.(*FieldError); !ok || fe.Field != field {
+			t.Errorf("%s: got %v", field, err)
+		}
+	}
+}
+
+func TestViewTransactions(t *testing.T) {
+	store := NewEventStore(100, 50)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	store.Add(makeEvent("1", aliceAddr, bobAddr, "2000000000000000000", ts, "USDC"))
+	store.Add(makeEvent("2", aliceAddr, carolAddr, "1", ts, "USDC"))
+	store.Add(makeEvent("3", carolAddr, bobAddr, "5000000000000000000", ts, "DAI"))
+	store.Add(makeEvent("4", bobAddr, carolAddr, "3000000000000000000", ts, "WETH"))
+	views := NewViewStore()
+
+	body := `{"name":"alice and bob stablecoins","tokens":["USDC","DAI"],"addresses":["` + aliceAddr + `","` + bobAddr + `"],"min_value":"1"}`
+	r := httptest.NewRecorder()
+	createView(views, r, httptest.NewRequest(http.MethodPost, "/views", strings.NewReader(body)))
+	if r.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", r.Code, r.Body.String())
+	}
+	var v View
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil || v.ID == "" {
+		t.Fatalf("decode: %+v, %v", v, err)
+	}
+	if r.Header().Get("Location") != "/views/"+v.ID {
+		t.Fatalf("location = %q", r.Header().Get("Location"))
+	}
+
+	r = httptest.NewRecorder()
+	getViewTransactions(views, store, r, withChiParam(httptest.NewRequest(http.MethodGet, "/views/"+v.ID+"/transactions", nil), "id", v.ID))
+	var events []*Event
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(events) != 2 || r.Header().Get("X-Total-Count") != "2" {
+		t.Fatalf("expected events 3 and 1, got %d (%s)", len(events), r.Header().Get("X-Total-Count"))
+	}
+	for _, ev := range events {
+		if ev.EventID != "1" && ev.EventID != "3" {
+			t.Fatalf("unexpected event %s", ev.EventID)
+		}
+	}
+
+	// Views are scoped to the creator's tenant
+	r = httptest.NewRecorder()
+	req := withChiParam(httptest.NewRequest(http.MethodGet, "/views/"+v.ID, nil), "id", v.ID)
+	getView(views, r, req.WithContext(withTenant(req.Context(), "other")))
+	if r.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for another tenant, got %d", r.Code)
+	}
+
+	r = httptest.NewRecorder()
+	deleteView(views, r, withChiParam(httptest.NewRequest(http.MethodDelete, "/views/"+v.ID, nil), "id", v.ID))
+	if r.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", r.Code)
+	}
+	if _, ok := views.Get(v.ID, ""); ok {
+		t.Fatal("view still exists after delete")
+	}
+}
+
+func TestSubscribeViewFiltersFrames(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	views := NewViewStore()
+	v, err := views.Create(context.Background(), View{Name: "usdc", Tokens: []string{"USDC"}}, "")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := withChiParam(httptest.NewRequest(http.MethodGet, "/views/"+v.ID+"/subscribe", nil).WithContext(ctx), "id", v.ID)
+	r := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		subscribeView(views, hub, r, req)
+		close(done)
+	}()
+	// Wait for the subscriber to register before publishing
+	for i := 0; i < 100; i++ {
+		hub.mu.Lock()
+		n := len(hub.clients)
+		hub.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ts := time.Now().UTC().Format(time.RFC3339)
+	for _, payload := range []interface{}{
+		makeEvent("dai", aliceAddr, bobAddr, "1", ts, "DAI"),
+		StatusChange{Type: "status_change", EventID: "usdc", Status: StatusFinalized},
+		makeEvent("usdc", aliceAddr, bobAddr, "1", ts, "USDC"),
+	} {
+		b, _ := json.Marshal(payload)
+		hub.Publish("", b)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	body := r.Body.String()
+	if strings.Count(body, "data: ") != 1 || !strings.Contains(body, `"event_id":"usdc"`) || strings.Contains(body, "status_change") {
+		t.Fatalf("unexpected stream:\n%s", body)
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxWalletExportEvents bounds the transfers a wallet export collects.
+const maxWalletExportEvents = 100000
+
+// maxExportCounterparties is how many counterparties the summary lists.
+const maxExportCounterparties = 10
+
+// WalletExportRequest is the optional body of POST /wallet/{address}/export.
+// The filters are those of GET /wallet/{address}/transactions.
+type WalletExportRequest struct {
+	Chain     string `json:"chain,omitempty"`
+	Network   string `json:"network,omitempty"`
+	Token     string `json:"token,omitempty"`
+	StartTime string `json:"start_time,omitempty"`
+	EndTime   string `json:"end_time,omitempty"`
+	// Email receives a download link once the export is ready.
+	Email string `json:"email,omitempty"`
+}
+
+// walletExportFilter reads the wallet and filters of an export.
+func walletExportFilter(r *http.Request) (string, EventFilter, error) {
+	p := newQueryParams(r)
+	chain, network := p.Network()
+	f := EventFilter{
+		Chain:     chain,
+		Network:   network,
+		Token:     p.String("token"),
+		Tenant:    tenantFrom(r.Context()),
+		StartTime: p.Time("start_time"),
+		EndTime:   p.Time("end_time"),
+		SortBy:    SortTimestamp,
+		SortOrder: "asc",
+	}
+	if p.err == nil && f.StartTime != nil && f.EndTime != nil && f.EndTime.Before(*f.StartTime) {
+		p.fail("end_time", "end_time must not be before start_time")
+	}
+	if err := p.Err(); err != nil {
+		return "", f, err
+	}
+	address, err := pathAddress(r, chain)
+	return address, f, err
+}
+
+// walletHistory collects the events of a wallet matching filter a page at a
+// time, and reports whether maxWalletExportEvents cut it short.
+func walletHistory(store *EventStore, address string, filter EventFilter) ([]*Event, bool) {
+	seen := make(map[string]bool)
+	var events []*Event
+	filter.Limit = maxPageSize
+	for filter.Offset = 0; ; filter.Offset += maxPageSize {
+		page := store.GetByWallet(address, filter)
+		for _, ev := range page {
+			if seen[ev.EventID] {
+				continue
+			}
+			if len(events) == maxWalletExportEvents {
+				return events, true
+			}
+			seen[ev.EventID] = true
+			events = append(events, ev)
+		}
+		if len(page) < maxPageSize {
+			return events, false
+		}
+	}
+}
+
+// walletJourneys traces the peel chains leaving the wallet on each network
+// it sent from.
+func walletJourneys(store *EventStore, address string, events []*Event, tenant string) []Journey {
+	journeys := []Journey{}
+	traced := make(map[string]bool)
+	for _, ev := range events {
+		pair := ev.Chain + ":" + ev.Network
+		if addressKey(ev.From) != addressKey(address) || traced[pair] {
+			continue
+		}
+		traced[pair] = true
+		j := DetectPeelChain(store, address, PeelChainOptions{Chain: ev.Chain, Network: ev.Network, Tenant: tenant})
+		if len(j.Hops) > 0 {
+			journeys = append(journeys, j)
+		}
+	}
+	return journeys
+}
+
+// getWalletExport writes the complete history of a wallet as a zip archive
+// of transactions.csv, transfers.json (the events in the normalized
+// schema), journeys.json (peel chains leaving the wallet) and summary.pdf.
+// It runs as the wallet_export query job.
+func getWalletExport(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	address, filter, err := walletExportFilter(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	events, truncated := walletHistory(store, address, filter)
+	if len(events) == 0 {
+		httpError(w, "no events for address "+address, http.StatusNotFound)
+		return
+	}
+	events = store.Enrich(events)
+	journeys := walletJourneys(store, address, events, filter.Tenant)
+	now := time.Now().UTC()
+	summary := exportSummary(address, filter, events, journeys, truncated, now)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"transactions.csv", func(out io.Writer) error { return writeExportCSV(out, address, events) }},
+		{"transfers.json", func(out io.Writer) error { return json.NewEncoder(out).Encode(events) }},
+		{"journeys.json", func(out io.Writer) error { return json.NewEncoder(out).Encode(journeys) }},
+		{"summary.pdf", func(out io.Writer) error { _, err := out.Write(writePDF(summary)); return err }},
+	} {
+		out, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: now})
+		if err == nil {
+			err = file.write(out)
+		}
+		if err != nil {
+			httpError(w, "could not write "+file.name, http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		httpError(w, "could not write the archive", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", address+"-export.zip"))
+	_, _ = w.Write(buf.Bytes())
+}
+
+// writeExportCSV writes one line per transfer, with its direction and
+// counterparty seen from address.
+func writeExportCSV(out io.Writer, address string, events []*Event) error {
+	cw := csv.NewWriter(out)
+	_ = cw.Write([]string{"event_id", "timestamp", "chain", "network", "chain_id", "tx_hash", "block_number", "direction",
+		"counterparty", "counterparty_label", "from", "to", "token", "token_address", "value", "value_decimal", "fee", "status"})
+	for _, ev := range events {
+		direction, counterparty, label := exportDirection(address, ev)
+		var block, token, tokenAddress string
+		if ev.BlockNumber != nil {
+			block = strconv.FormatUint(*ev.BlockNumber, 10)
+		} else if ev.Slot != nil {
+			block = strconv.FormatUint(*ev.Slot, 10)
+		}
+		if ev.Token != nil {
+			token, tokenAddress = ev.Token.Symbol, ev.Token.Address
+		}
+		_ = cw.Write([]string{ev.EventID, ev.Timestamp, ev.Chain, ev.Network, ev.ChainID, ev.TxHash, block, direction,
+			counterparty, label, ev.From, ev.To, token, tokenAddress, ev.Value, ev.ValueDecimal, ev.Fee, ev.Status})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportDirection says whether ev left (out), reached (in) or stayed in
+// (self) the wallet, and names the other side with its label.
+func exportDirection(address string, ev *Event) (direction, counterparty, label string) {
+	from, to := addressKey(ev.From) == addressKey(address), addressKey(ev.To) == addressKey(address)
+	switch {
+	case from && to:
+		return "self", ev.To, ev.ToLabel
+	case from:
+		return "out", ev.To, ev.ToLabel
+	}
+	return "in", ev.From, ev.FromLabel
+}
+
+// exportSummary lays out summary.pdf: the scope of the export, the totals
+// per asset, the main counterparties and the journeys found.
+func exportSummary(address string, filter EventFilter, events []*Event, journeys []Journey, truncated bool, now time.Time) []pdfLine {
+	lines := []pdfLine{
+		{text: "Wallet export: " + address, heading: true},
+		{text: "Generated " + now.Format(time.RFC3339)},
+	}
+	var scope []string
+	for _, kv := range [][2]string{{"chain", filter.Chain}, {"network", filter.Network}, {"token", filter.Token}} {
+		if kv[1] != "" {
+			scope = append(scope, kv[0]+" "+kv[1])
+		}
+	}
+	if filter.StartTime != nil {
+		scope = append(scope, "from "+filter.StartTime.UTC().Format(time.RFC3339))
+	}
+	if filter.EndTime != nil {
+		scope = append(scope, "until "+filter.EndTime.UTC().Format(time.RFC3339))
+	}
+	if len(scope) == 0 {
+		scope = []string{"complete history"}
+	}
+	lines = append(lines,
+		pdfLine{text: "Scope: " + strings.Join(scope, ", ")},
+		pdfLine{text: fmt.Sprintf("Transfers: %d, from %s to %s", len(events), events[0].Timestamp, events[len(events)-1].Timestamp)})
+	if truncated {
+		lines = append(lines, pdfLine{text: fmt.Sprintf("Only the first %d transfers are included.", maxWalletExportEvents)})
+	}
+
+	lines = append(lines, pdfLine{}, pdfLine{text: "Assets (raw units)", heading: true})
+	for _, a := range aggregateWalletStats(address, events).Assets {
+		text := fmt.Sprintf("%s:%s %s: sent %s, received %s in %d transfers", a.Chain, a.Network, a.Token, a.Sent, a.Received, a.Transfers)
+		if a.Fees != "" {
+			text += ", fees " + a.Fees
+		}
+		lines = append(lines, pdfLine{text: text})
+	}
+
+	type counterparty struct {
+		name, label string
+		transfers   int
+	}
+	byKey := make(map[string]*counterparty)
+	for _, ev := range events {
+		direction, name, label := exportDirection(address, ev)
+		if direction == "self" {
+			continue
+		}
+		c, ok := byKey[addressKey(name)]
+		if !ok {
+			c = &counterparty{name: name}
+			byKey[addressKey(name)] = c
+		}
+		if label != "" {
+			c.label = label
+		}
+		c.transfers++
+	}
+	ranked := make([]*counterparty, 0, len(byKey))
+	for _, c := range byKey {
+		ranked = append(ranked, c)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].transfers != ranked[j].transfers {
+			return ranked[i].transfers > ranked[j].transfers
+		}
+		return ranked[i].name < ranked[j].name
+	})
+	if len(ranked) > maxExportCounterparties {
+		ranked = ranked[:maxExportCounterparties]
+	}
+	lines = append(lines, pdfLine{}, pdfLine{text: "Top counterparties", heading: true})
+	for _, c := range ranked {
+		name := c.name
+		if c.label != "" {
+			name += " (" + c.label + ")"
+		}
+		lines = append(lines, pdfLine{text: fmt.Sprintf("%s: %d transfers", name, c.transfers)})
+	}
+
+	lines = append(lines, pdfLine{}, pdfLine{text: "Peel-chain journeys", heading: true})
+	if len(journeys) == 0 {
+		lines = append(lines, pdfLine{text: "None detected."})
+	}
+	for _, j := range journeys {
+		lines = append(lines, pdfLine{text: fmt.Sprintf("%s: %d hops to %s, confidence %.2f", j.Hops[0].Chain, len(j.Hops), j.Terminal, j.Confidence)})
+	}
+
+	return append(lines, pdfLine{}, pdfLine{text: "Files", heading: true},
+		pdfLine{text: "transactions.csv: every transfer, one per line"},
+		pdfLine{text: "transfers.json: the transfers in the normalized event schema"},
+		pdfLine{text: "journeys.json: the peel-chain journeys above, hop by hop"})
+}
+
+// pdfLine is a line of text of a generated PDF; headings are set in bold.
+type pdfLine struct {
+	text    string
+	heading bool
+}
+
+const (
+	pdfLinesPerPage = 52
+	pdfMaxLineChars = 100
+)
+
+// writePDF renders lines as a plain A4 document in Helvetica, starting a
+// page every pdfLinesPerPage lines. Characters outside printable ASCII are
+// replaced and long lines cut.
+func writePDF(lines []pdfLine) []byte {
+	var pages [][]pdfLine
+	for len(lines) > pdfLinesPerPage {
+		pages = append(pages, lines[:pdfLinesPerPage])
+		lines = lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	var buf bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	buf.WriteString("%PDF-1.4\n")
+	// Objects 1 to 4 are the catalog, the page tree and the two fonts;
+	// each page is followed by its content stream
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold >>")
+	for i, page := range pages {
+		var content strings.Builder
+		content.WriteString("BT\n14 TL\n50 792 Td\n")
+		for _, line := range page {
+			font := "/F1"
+			if line.heading {
+				font = "/F2"
+			}
+			fmt.Fprintf(&content, "%s 10 Tf (%s) Tj T*\n", font, pdfText(line.text))
+		}
+		content.WriteString("ET")
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", 6+2*i))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
+
+// pdfText escapes s for a PDF string literal.
+func pdfText(s string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		if n == pdfMaxLineChars {
+			b.WriteString("...")
+			break
+		}
+		n++
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// createWalletExport submits a wallet_export query job for a wallet and
+// returns 202 with the job, as POST /queries does. Its filters are checked
+// up front rather than failing the job.
+func createWalletExport(jobs *QueryJobs, w http.ResponseWriter, r *http.Request) {
+	var body WalletExportRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	req := QueryRequest{
+		Kind:    "wallet_export",
+		Address: chi.URLParam(r, "address"),
+		Params:  make(map[string]string),
+		Email:   body.Email,
+		Tenant:  tenantFrom(r.Context()),
+	}
+	for name, value := range map[string]string{"chain": body.Chain, "network": body.Network, "token": body.Token,
+		"start_time": body.StartTime, "end_time": body.EndTime} {
+		if value != "" {
+			req.Params[name] = value
+		}
+	}
+	jr, err := jobRequest(r.Context(), req)
+	if err == nil {
+		_, _, err = walletExportFilter(jr)
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	submitQuery(jobs, w, req)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWalletExportArchiveAndEmailedLink(t *testing.T) {
+	store := NewEventStore(100, 50)
+	base := time.Now().Add(-time.Hour).UTC()
+	store.Add(makeEvent("1", aliceAddr, bobAddr, "100", base.Format(time.RFC3339), ""))
+	store.Add(makeEvent("2", carolAddr, aliceAddr, "5", base.Add(time.Minute).Format(time.RFC3339), "USDC"))
+	store.Add(makeEvent("3", bobAddr, carolAddr, "1", base.Add(2*time.Minute).Format(time.RFC3339), ""))
+
+	jobs := NewQueryJobs(store, 1, time.Minute, time.Hour)
+	mails := make(chan string, 1)
+	mailer, _ := NewMailer("smtp://mail.example", "tracker@example.com")
+	mailer.send = func(_ string, _ smtp.Auth, _ string, _ []string, msg []byte) error {
+		mails <- string(msg)
+		return nil
+	}
+	links := NewShareLinks([]byte("key"), "https://tracker.example")
+	jobs.AttachMailer(mailer, links)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/wallet/"+aliceAddr+"/export", strings.NewReader(body))
+		createWalletExport(jobs, rr, withChiParam(req, "address", aliceAddr))
+		return rr
+	}
+	if rr := post(`{"start_time":"yesterday"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid filter: status %d", rr.Code)
+	}
+	rr := post(`{"chain":"ethereum","email":"Ops <ops@example.com>"}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("create status = %d: %s", rr.Code, rr.Body.String())
+	}
+	var created QueryJob
+	_ = json.Unmarshal(rr.Body.Bytes(), &created)
+	if job := waitForJob(t, jobs, created.ID); job.Status != JobSucceeded || job.ContentType != "application/zip" {
+		t.Fatalf("job = %+v", job)
+	}
+
+	var mail string
+	select {
+	case mail = <-mails:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no email sent")
+	}
+	link := regexp.MustCompile(`https://tracker\.example/downloads/(\S+)`).FindStringSubmatch(mail)
+	if link == nil || !strings.Contains(mail, "To: ops@example.com") {
+		t.Fatalf("email = %s", mail)
+	}
+	rr = httptest.NewRecorder()
+	getDownload(links, jobs, rr, withChiParam(httptest.NewRequest(http.MethodGet, "/downloads/x", nil), "token", link[1]))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("download status %d, content type %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	// The download token is not a share link
+	shared := httptest.NewRecorder()
+	getShared(links, store, shared, withChiParam(httptest.NewRequest(http.MethodGet, "/shared/x", nil), "token", link[1]))
+	if shared.Code != http.StatusNotFound {
+		t.Fatalf("download token as share link: status %d", shared.Code)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	rows, err := csv.NewReader(bytes.NewReader(files["transactions.csv"])).ReadAll()
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("transactions.csv = %v, %v", rows, err)
+	}
+	if rows[1][0] != "1" || rows[1][7] != "out" || rows[2][0] != "2" || rows[2][7] != "in" || rows[2][12] != "USDC" {
+		t.Fatalf("rows = %v, want alice's transfers oldest first", rows)
+	}
+	var transfers []*Event
+	if err := json.Unmarshal(files["transfers.json"], &transfers); err != nil || len(transfers) != 2 {
+		t.Fatalf("transfers.json = %s", files["transfers.json"])
+	}
+	if _, ok := files["journeys.json"]; !ok {
+		t.Fatal("journeys.json missing")
+	}
+	if pdf := string(files["summary.pdf"]); !strings.HasPrefix(pdf, "%PDF-1.4") || !strings.Contains(pdf, "(Wallet export: "+checksumAddress(aliceAddr)+")") {
+		t.Fatalf("summary.pdf = %s", pdf)
+	}
+}
+
+func TestWritePDF(t *testing.T) {
+	lines := []pdfLine{{text: "Title (draft)", heading: true}}
+	for i := 0; i < pdfLinesPerPage; i++ {
+		lines = append(lines, pdfLine{text: fmt.Sprintf("line %d é", i)})
+	}
+	pdf := writePDF(lines)
+	if !bytes.Contains(pdf, []byte(`(Title \(draft\)) Tj`)) || !bytes.Contains(pdf, []byte("(line 0 ?) Tj")) || !bytes.Contains(pdf, []byte("/Count 2")) {
+		t.Fatalf("pdf = %s", pdf)
+	}
+	// Every cross-reference entry points at its object
+	m := regexp.MustCompile(`xref\n0 (\d+)\n0000000000 65535 f \n((?:\d{10} 00000 n \n)+)trailer`).FindSubmatch(pdf)
+	if m == nil {
+		t.Fatalf("no xref table in %s", pdf)
+	}
+	for i, entry := range strings.Split(strings.TrimSpace(string(m[2])), "\n") {
+		var off int
+		fmt.Sscanf(entry, "%d", &off)
+		if !bytes.HasPrefix(pdf[off:], []byte(fmt.Sprintf("%d 0 obj", i+1))) {
+			t.Fatalf("xref entry %d points at %q", i+1, pdf[off:off+10])
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// unsignedInteger matches the decimal strings value and fee columns must
+// hold to be summed.
+var unsignedInteger = regexp.MustCompile(`^[0-9]+$`)
+
+// WalletStats totals a wallet's flows per asset. Amounts are in the asset's
+// smallest unit (wei, lamports, ...), as decimal strings.
+type WalletStats struct {
+	Address string             `json:"address"`
+	Assets  []WalletAssetStats `json:"assets"`
+}
+
+// WalletAssetStats is what a wallet sent and received of one asset. The
+// native-currency row of each chain also carries the fees of the
+// transactions the wallet paid for, and Outflow, what left the wallet in
+// total: Sent plus Fees.
+type WalletAssetStats struct {
+	Chain        string `json:"chain"`
+	Network      string `json:"network"`
+	Token        string `json:"token"`
+	TokenAddress string `json:"token_address,omitempty"`
+	Sent         string `json:"sent"`
+	Received     string `json:"received"`
+	Transfers    int64  `json:"transfers"`
+	Fees         string `json:"fees,omitempty"`
+	PriorityFees string `json:"priority_fees,omitempty"`
+	GasUsed      uint64 `json:"gas_used,omitempty"`
+	// FeeTransactions counts the transactions Fees was paid for.
+	FeeTransactions int64  `json:"fee_transactions,omitempty"`
+	Outflow         string `json:"outflow,omitempty"`
+}
+
+// feePayer returns the address that paid an event's transaction fee.
+func feePayer(ev *Event) string {
+	if ev.ExecutedBy != "" {
+		return ev.ExecutedBy
+	}
+	return ev.From
+}
+
+// aggregateWalletStats totals the flows of address over its events. Fees
+// are counted once per transaction, for the events address paid for.
+func aggregateWalletStats(address string, events []*Event) WalletStats {
+	type assetKey struct{ chain, network, token, tokenAddress string }
+	type txKey struct{ chain, hash string }
+	key := addressKey(address)
+	rows := make(map[assetKey]*WalletAssetStats)
+	sent := make(map[assetKey]*big.Int)
+	received := make(map[assetKey]*big.Int)
+	feeTotals := make(map[[2]string]*walletFees)
+	feeTxs := make(map[txKey]struct{})
+	for _, ev := range events {
+		if val, ok := new(big.Int).SetString(ev.Value, 10); ok && val.Sign() >= 0 {
+			k := assetKey{ev.Chain, ev.Network, nativeToken, ""}
+			if ev.Token != nil {
+				k.token, k.tokenAddress = ev.Token.Symbol, ev.Token.Address
+			}
+			if rows[k] == nil {
+				rows[k] = &WalletAssetStats{Chain: k.chain, Network: k.network, Token: k.token, TokenAddress: k.tokenAddress}
+				sent[k], received[k] = new(big.Int), new(big.Int)
+			}
+			rows[k].Transfers++
+			if addressKey(ev.From) == key {
+				sent[k].Add(sent[k], val)
+			}
+			if addressKey(ev.To) == key {
+				received[k].Add(received[k], val)
+			}
+		}
+
+		tx := txKey{ev.Chain, ev.TxHash}
+		if _, dup := feeTxs[tx]; dup || !unsignedInteger.MatchString(ev.Fee) || addressKey(feePayer(ev)) != key {
+			continue
+		}
+		feeTxs[tx] = struct{}{}
+		chain := [2]string{ev.Chain, ev.Network}
+		if feeTotals[chain] == nil {
+			feeTotals[chain] = &walletFees{fees: new(big.Int), priority: new(big.Int)}
+		}
+		feeTotals[chain].add(ev)
+	}
+
+	stats := WalletStats{Address: address, Assets: make([]WalletAssetStats, 0, len(rows))}
+	for k, row := range rows {
+		row.Sent, row.Received = sent[k].String(), received[k].String()
+		stats.Assets = append(stats.Assets, *row)
+	}
+	for chain, f := range feeTotals {
+		stats.addFees(chain[0], chain[1], f.fees.String(), f.priority.String(), f.gasUsed, f.txs)
+	}
+	stats.finish()
+	return stats
+}
+
+// walletFees accumulates the fees paid on one chain.
+type walletFees struct {
+	fees, priority *big.Int
+	gasUsed        uint64
+	txs            int64
+}
+
+func (f *walletFees) add(ev *Event) {
+	fee, _ := new(big.Int).SetString(ev.Fee, 10)
+	f.fees.Add(f.fees, fee)
+	if unsignedInteger.MatchString(ev.PriorityFee) {
+		tip, _ := new(big.Int).SetString(ev.PriorityFee, 10)
+		f.priority.Add(f.priority, tip)
+	}
+	if ev.GasUsed != nil {
+		f.gasUsed += *ev.GasUsed
+	}
+	f.txs++
+}
+
+// addFees records the fees paid on a chain on its native-currency row,
+// adding the row when the wallet moved no native currency there.
+func (s *WalletStats) addFees(chain, network, fees, priorityFees string, gasUsed uint64, txs int64) {
+	for i := range s.Assets {
+		a := &s.Assets[i]
+		if a.Chain == chain && a.Network == network && a.Token == nativeToken && a.TokenAddress == "" {
+			a.Fees, a.PriorityFees, a.GasUsed, a.FeeTransactions = fees, priorityFees, gasUsed, txs
+			return
+		}
+	}
+	s.Assets = append(s.Assets, WalletAssetStats{Chain: chain, Network: network, Token: nativeToken, Sent: "0", Received: "0",
+		Fees: fees, PriorityFees: priorityFees, GasUsed: gasUsed, FeeTransactions: txs})
+}
+
+// finish computes the native outflows and sorts the assets by chain,
+// network, token and token address.
+func (s *WalletStats) finish() {
+	for i := range s.Assets {
+		a := &s.Assets[i]
+		if a.Token != nativeToken || a.TokenAddress != "" {
+			continue
+		}
+		outflow, ok := new(big.Int).SetString(a.Sent, 10)
+		if !ok {
+			continue
+		}
+		if fees, ok := new(big.Int).SetString(a.Fees, 10); ok {
+			outflow.Add(outflow, fees)
+		}
+		a.Outflow = outflow.String()
+	}
+	sort.Slice(s.Assets, func(i, j int) bool {
+		a, b := s.Assets[i], s.Assets[j]
+		if a.Chain != b.Chain {
+			return a.Chain < b.Chain
+		}
+		if a.Network != b.Network {
+			return a.Network < b.Network
+		}
+		if a.Token != b.Token {
+			return a.Token < b.Token
+		}
+		return a.TokenAddress < b.TokenAddress
+	})
+}
+
+// WalletStats totals a wallet's flows and fees, from the repository when
+// one is attached and from the cache otherwise.
+func (s *EventStore) WalletStats(address string, filter EventFilter) (WalletStats, error) {
+	if s.repo != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		stats, err := s.repo.WalletStats(ctx, address, filter)
+		if err == nil {
+			return stats, nil
+		}
+		log.WithError(err).Warn("repository query failed; falling back to in-memory")
+	}
+	return s.cache.WalletStats(context.Background(), address, filter)
+}
+
+// getWalletStats serves a wallet's totals sent, received and paid in fees
+// per asset.
+func getWalletStats(store *EventStore, w http.ResponseWriter, r *http.Request) {
+	p := newQueryParams(r)
+	chain, network := p.Network()
+	filter := EventFilter{
+		Chain:   chain,
+		Network: network,
+		Tenant:  tenantFrom(r.Context()),
+		Status:  p.Enum("status", StatusPending, StatusConfirmed, StatusFinalized, StatusOrphaned),
+	}
+	filter.StartTime = p.Time("start_time")
+	filter.EndTime = p.Time("end_time")
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	address, err := pathAddress(r, filter.Chain)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	stats, err := store.WalletStats(address, filter)
+	if err != nil {
+		log.WithError(err).Error("failed to compute wallet stats")
+		httpError(w, "could not compute wallet stats", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWalletStatsCountsFeesOncePerTransaction(t *testing.T) {
+	gas := uint64(21000)
+	withFee := func(ev *Event, tx, fee, tip string) *Event {
+		ev.Chain, ev.Network, ev.TxHash = "ethereum", "mainnet", tx
+		ev.Fee, ev.GasUsed, ev.PriorityFee = fee, &gas, tip
+		return ev
+	}
+	store := NewEventStore(100, 50)
+	// A native transfer and a token transfer of the same transaction
+	store.Add(withFee(makeEvent("1", aliceAddr, bobAddr, "1000", "2025-10-14T10:00:00Z", ""), "0x01", "50", "10"))
+	store.Add(withFee(makeEvent("2", aliceAddr, carolAddr, "7", "2025-10-14T10:00:00Z", "USDC"), "0x01", "50", "10"))
+	// Received: bob paid the fee
+	store.Add(withFee(makeEvent("3", bobAddr, aliceAddr, "300", "2025-10-14T11:00:00Z", ""), "0x02", "40", "0"))
+	// A token-only transaction alice paid for
+	store.Add(withFee(makeEvent("4", aliceAddr, bobAddr, "3", "2025-10-14T12:00:00Z", "USDC"), "0x03", "60", "5"))
+
+	r := httptest.NewRecorder()
+	getWalletStats(store, r, withChiParam(httptest.NewRequest(http.MethodGet, "/wallet/x/stats?chain=ethereum", nil), "address", aliceAddr))
+	if r.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", r.Code, r.Body.String())
+	}
+	var stats WalletStats
+	if err := json.NewDecoder(r.Body).Decode(&stats); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(stats.Assets) != 2 {
+		t.Fatalf("expected USDC and native rows, got %+v", stats.Assets)
+	}
+	usdc, native := stats.Assets[0], stats.Assets[1]
+	if usdc.Token != "USDC" || usdc.Sent != "10" || usdc.Transfers != 2 || usdc.Fees != "" {
+		t.Fatalf("unexpected USDC row %+v", usdc)
+	}
+	if native.Token != nativeToken || native.Sent != "1000" || native.Received != "300" || native.Transfers != 2 {
+		t.Fatalf("unexpected native row %+v", native)
+	}
+	if native.Fees != "110" || native.PriorityFees != "15" || native.GasUsed != 42000 || native.FeeTransactions != 2 || native.Outflow != "1110" {
+		t.Fatalf("expected fees of transactions 0x01 and 0x03 once each, got %+v", native)
+	}
+}
+
+func TestWalletStatsAddsNativeRowForFees(t *testing.T) {
+	ev := makeEvent("1", aliceAddr, bobAddr, "5", "2025-10-14T10:00:00Z", "USDC")
+	ev.Chain, ev.Fee = "ethereum", "21"
+	stats := aggregateWalletStats(aliceAddr, []*Event{ev})
+	if len(stats.Assets) != 2 {
+		t.Fatalf("expected a native row for the fees, got %+v", stats.Assets)
+	}
+	native := stats.Assets[1]
+	if native.Token != nativeToken || native.Sent != "0" || native.Transfers != 0 || native.Fees != "21" || native.Outflow != "21" {
+		t.Fatalf("unexpected native row %+v", native)
+	}
+}
+
+func TestWalletStatsRejectsInvalidAddress(t *testing.T) {
+	r := httptest.NewRecorder()
+	getWalletStats(NewEventStore(10, 10), r, withChiParam(httptest.NewRequest(http.MethodGet, "/wallet/x/stats?chain=ethereum", nil), "address", "nope"))
+	if r.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", r.Code)
+	}
+}
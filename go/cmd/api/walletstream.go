@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// eventAddressKeys returns the addressKeys of ev's sender and recipient,
+// each once.
+func eventAddressKeys(ev *Event) []string {
+	var keys []string
+	for _, address := range []string{ev.From, ev.To} {
+		if address == "" {
+			continue
+		}
+		if key := addressKey(address); !containsToken(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// watch registers a client for the frames concerning the wallet with
+// addressKey address. When resume is set it returns the buffered frames
+// about that wallet the cursor has not seen, under the same lock as
+// subscribe.
+func (h *Hub) watch(client chan Frame, tenant, address string, c replayCursor, resume bool) []Frame {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.wallets[address] == nil {
+		h.wallets[address] = make(map[chan Frame]string)
+	}
+	h.wallets[address][client] = tenant
+	h.walletOf[client] = address
+	if !resume {
+		return nil
+	}
+	var missed []Frame
+	for _, f := range h.replay.since(c, tenant) {
+		if containsToken(f.Addresses, address) {
+			missed = append(missed, f)
+		}
+	}
+	log.WithField("replayed", len(missed)).Info("wallet watcher registered")
+	return missed
+}
+
+// subscribeWallet streams the events sent or received by {address} over
+// SSE, with the same resume semantics as /events/subscribe.
+func subscribeWallet(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	address, err := pathAddress(r, "")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	streamSSE(hub, w, r, addressKey(address), nil, nil)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHubDeliversToWalletWatchers(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	alice := make(chan Frame, 4)
+	bob := make(chan Frame, 4)
+	scoped := make(chan Frame, 4)
+	hub.watch(alice, "", addressKey(aliceAddr), replayCursor{}, false)
+	hub.watch(bob, "", addressKey(bobAddr), replayCursor{}, false)
+	hub.watch(scoped, "acme", addressKey(bobAddr), replayCursor{}, false)
+
+	hub.PublishEvent(makeEvent("a-to-b", aliceAddr, bobAddr, "1", "", ""), []byte(`{"event_id":"a-to-b"}`))
+	other := makeEvent("other", aliceAddr, carolAddr, "1", "", "")
+	hub.PublishEvent(other, []byte(`{"event_id":"other"}`))
+
+	for name, ch := range map[string]chan Frame{"alice": alice, "bob": bob} {
+		select {
+		case f := <-ch:
+			if !strings.Contains(string(f.Data), "a-to-b") {
+				t.Fatalf("%s got %s first", name, f.Data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s missed the transfer", name)
+		}
+	}
+	select {
+	case f := <-alice:
+		if !strings.Contains(string(f.Data), "other") {
+			t.Fatalf("alice got %s", f.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("alice missed her second transfer")
+	}
+	if len(bob) != 0 || len(scoped) != 0 {
+		t.Fatalf("bob got %d extra frames, tenant watcher got %d", len(bob), len(scoped))
+	}
+
+	hub.unregister <- bob
+	hub.unregister <- scoped
+	hub.unregister <- alice
+	// Run handles the last unregister after the send returns
+	waitUntil := time.Now().Add(time.Second)
+	for {
+		hub.mu.Lock()
+		empty := len(hub.wallets) == 0 && len(hub.walletOf) == 0
+		hub.mu.Unlock()
+		if empty {
+			break
+		}
+		if time.Now().After(waitUntil) {
+			t.Fatal("wallet index not emptied")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, open := <-alice; open {
+		t.Fatal("unregistered watcher not closed")
+	}
+}
+
+func TestSubscribeWalletReplaysItsFrames(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	hub.PublishEvent(makeEvent("mine", aliceAddr, bobAddr, "1", "", ""), []byte(`{"event_id":"mine"}`))
+	hub.PublishEvent(makeEvent("theirs", bobAddr, carolAddr, "1", "", ""), []byte(`{"event_id":"theirs"}`))
+	waitUntil := time.Now().Add(time.Second)
+	for time.Now().Before(waitUntil) {
+		hub.mu.Lock()
+		n := len(hub.replay.since(replayCursor{}, ""))
+		hub.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	tw := newTestRW()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/wallet/"+aliceAddr+"/subscribe?since=0", nil).WithContext(ctx)
+	req = withChiParam(req, "address", aliceAddr)
+	go subscribeWallet(hub, tw, req)
+
+	select {
+	case b := <-tw.writes:
+		if !strings.Contains(string(b), `"event_id":"mine"`) {
+			t.Fatalf("expected only the wallet's frame, got %q", b)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("wallet frame was not replayed")
+	}
+
+	rec := httptest.NewRecorder()
+	subscribeWallet(hub, rec, withChiParam(httptest.NewRequest(http.MethodGet, "/wallet/nope/subscribe", nil), "address", "nope"))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid address = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultWatchdogThreshold is how long a loop may make no progress before
+// it counts as stalled.
+const defaultWatchdogThreshold = 30 * time.Second
+
+// Watchdog detects loops that stopped making progress, such as a hub whose
+// Run loop is stuck or an ingest handler blocked on a send, and marks the
+// API not ready while they are stalled. Hubs are probed through their Run
+// loop, which answers as soon as it is free; handlers are stalled when one
+// call takes longer than the threshold, so an idle source is never flagged.
+type Watchdog struct {
+	threshold time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	hubs     map[string]*Hub
+	handlers map[string]*watchedHandler
+	stalled  map[string]bool
+	stalls   map[string]uint64
+}
+
+// watchedHandler counts the calls of an event handler in progress and
+// when the oldest of them started.
+type watchedHandler struct {
+	running int
+	since   time.Time
+}
+
+// NewWatchdog creates a watchdog that flags loops idle for threshold.
+func NewWatchdog(threshold time.Duration) *Watchdog {
+	return &Watchdog{
+		threshold: threshold,
+		now:       time.Now,
+		hubs:      make(map[string]*Hub),
+		handlers:  make(map[string]*watchedHandler),
+		stalled:   make(map[string]bool),
+		stalls:    make(map[string]uint64),
+	}
+}
+
+// WatchHub adds hub's Run loop to the watched loops under name.
+func (w *Watchdog) WatchHub(name string, hub *Hub) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.hubs[name] = hub
+	w.stalled[name] = false
+}
+
+// WatchHandler returns handle, timing each call under name.
+func (w *Watchdog) WatchHandler(name string, handle EventHandler) EventHandler {
+	w.mu.Lock()
+	h := &watchedHandler{}
+	w.handlers[name] = h
+	w.stalled[name] = false
+	w.mu.Unlock()
+	return func(ctx context.Context, payload []byte) error {
+		w.mu.Lock()
+		if h.running == 0 {
+			h.since = w.now()
+		}
+		h.running++
+		w.mu.Unlock()
+		defer func() {
+			w.mu.Lock()
+			h.running--
+			w.mu.Unlock()
+		}()
+		return handle(ctx, payload)
+	}
+}
+
+// Check probes every watched loop once and updates which are stalled. The
+// hub probes run concurrently and each waits up to the threshold.
+func (w *Watchdog) Check(ctx context.Context) {
+	w.mu.Lock()
+	hubs := make(map[string]*Hub, len(w.hubs))
+	for name, hub := range w.hubs {
+		hubs[name] = hub
+	}
+	w.mu.Unlock()
+
+	type probe struct {
+		name string
+		ok   bool
+	}
+	probes := make(chan probe, len(hubs))
+	for name, hub := range hubs {
+		go func(name string, hub *Hub) {
+			timer := time.NewTimer(w.threshold)
+			defer timer.Stop()
+			select {
+			case hub.probe <- struct{}{}:
+				probes <- probe{name, true}
+			case <-timer.C:
+				probes <- probe{name, false}
+			case <-ctx.Done():
+				probes <- probe{name, true}
+			}
+		}(name, hub)
+	}
+	results := make([]probe, 0, len(hubs))
+	for range hubs {
+		results = append(results, <-probes)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	now := w.now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, p := range results {
+		w.setStalledLocked(p.name, !p.ok)
+	}
+	for name, h := range w.handlers {
+		w.setStalledLocked(name, h.running > 0 && now.Sub(h.since) > w.threshold)
+	}
+}
+
+// setStalledLocked records whether name is stalled, logging changes.
+// Callers hold the lock.
+func (w *Watchdog) setStalledLocked(name string, stalled bool) {
+	if w.stalled[name] == stalled {
+		return
+	}
+	w.stalled[name] = stalled
+	if stalled {
+		w.stalls[name]++
+		log.WithField("loop", name).Errorf("watchdog: no progress for %s; marking the API not ready", w.threshold)
+	} else {
+		log.WithField("loop", name).Info("watchdog: loop is making progress again")
+	}
+}
+
+// Stalled returns the names of the loops currently stalled, sorted.
+func (w *Watchdog) Stalled() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var names []string
+	for name, stalled := range w.stalled {
+		if stalled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run checks the watched loops every threshold until ctx is done.
+func (w *Watchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.threshold)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.Check(ctx)
+		}
+	}
+}
+
+// WriteMetrics writes whether each watched loop is stalled and how often
+// it stalled, in the Prometheus text format.
+func (w *Watchdog) WriteMetrics(out io.Writer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	names := make([]string, 0, len(w.stalled))
+	for name := range w.stalled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(out, "# HELP tracker_watchdog_stalled Whether a loop made no progress within the watchdog threshold.\n# TYPE tracker_watchdog_stalled gauge\n")
+	for _, name := range names {
+		v := 0
+		if w.stalled[name] {
+			v = 1
+		}
+		fmt.Fprintf(out, "tracker_watchdog_stalled{loop=%q} %d\n", name, v)
+	}
+	fmt.Fprintf(out, "# HELP tracker_watchdog_stalls_total Times a loop was found stalled.\n# TYPE tracker_watchdog_stalls_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(out, "tracker_watchdog_stalls_total{loop=%q} %d\n", name, w.stalls[name])
+	}
+}
+
+// readyHandler answers 200 once startup completed within its budgets
+// while no watched loop is stalled, and 503 saying why otherwise.
+func readyHandler(watchdog *Watchdog, startup *StartupTracker, w http.ResponseWriter, r *http.Request) {
+	if reason := notReady(watchdog, startup); reason != "" {
+		httpError(w, "not ready: "+reason, http.StatusServiceUnavailable)
+		return
+	}
+	healthHandler(w, r)
+}
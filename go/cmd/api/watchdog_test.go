@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchdogDetectsStalledHub(t *testing.T) {
+	hub, stuck := NewHub(), NewHub()
+	go hub.Run()
+	watchdog := NewWatchdog(20 * time.Millisecond)
+	watchdog.WatchHub("hub", hub)
+	// Nothing runs this hub's loop, as if it were deadlocked
+	watchdog.WatchHub("system_hub", stuck)
+
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		readyHandler(watchdog, nil, rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		return rec.Code
+	}
+	if code := ready(); code != http.StatusOK {
+		t.Fatalf("ready before any check = %d, want 200", code)
+	}
+
+	watchdog.Check(context.Background())
+	if stalled := watchdog.Stalled(); len(stalled) != 1 || stalled[0] != "system_hub" {
+		t.Fatalf("stalled = %v, want system_hub", stalled)
+	}
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Fatalf("ready = %d, want 503", code)
+	}
+	var out bytes.Buffer
+	watchdog.WriteMetrics(&out)
+	for _, want := range []string{
+		`tracker_watchdog_stalled{loop="hub"} 0`,
+		`tracker_watchdog_stalled{loop="system_hub"} 1`,
+		`tracker_watchdog_stalls_total{loop="system_hub"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, out.String())
+		}
+	}
+
+	go stuck.Run()
+	watchdog.Check(context.Background())
+	if code := ready(); code != http.StatusOK {
+		t.Fatalf("ready after the loop resumed = %d, want 200", code)
+	}
+}
+
+func TestWatchdogDetectsBlockedHandler(t *testing.T) {
+	now := time.Now()
+	watchdog := NewWatchdog(time.Minute)
+	watchdog.now = func() time.Time { return now }
+	release := make(chan struct{})
+	handle := watchdog.WatchHandler("ingest", func(ctx context.Context, payload []byte) error {
+		<-release
+		return nil
+	})
+
+	// An idle handler is not stalled however long it waits for events
+	now = now.Add(time.Hour)
+	watchdog.Check(context.Background())
+	if stalled := watchdog.Stalled(); len(stalled) != 0 {
+		t.Fatalf("idle handler reported stalled: %v", stalled)
+	}
+
+	done := make(chan error)
+	go func() { done <- handle(context.Background(), nil) }()
+	deadline := time.Now().Add(time.Second)
+	for {
+		watchdog.mu.Lock()
+		running := watchdog.handlers["ingest"].running
+		watchdog.mu.Unlock()
+		if running == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("handler did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	watchdog.Check(context.Background())
+	if stalled := watchdog.Stalled(); len(stalled) != 0 {
+		t.Fatalf("handler reported stalled before the threshold: %v", stalled)
+	}
+	now = now.Add(2 * time.Minute)
+	watchdog.Check(context.Background())
+	if stalled := watchdog.Stalled(); len(stalled) != 1 || stalled[0] != "ingest" {
+		t.Fatalf("stalled = %v, want ingest", stalled)
+	}
+
+	close(release)
+	<-done
+	watchdog.Check(context.Background())
+	if stalled := watchdog.Stalled(); len(stalled) != 0 {
+		t.Fatalf("stalled after the handler returned = %v", stalled)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	webhookQueueSize = 256
+	webhookAttempts  = 4
+	webhookTimeout   = 10 * time.Second
+)
+
+// WebhookDispatcher POSTs system events to configured URLs. Deliveries are
+// queued and retried with exponential backoff; when the queue is full new
+// events are dropped rather than blocking the emitter.
+type WebhookDispatcher struct {
+	urls    []string
+	secret  []byte
+	kinds   map[string]struct{}
+	client  *http.Client
+	queue   chan SystemEvent
+	backoff time.Duration
+	latency *LatencyTracker
+	signer  *Signer
+}
+
+// NewWebhookDispatcher creates a dispatcher for the comma-separated urls. kinds
+// optionally restricts delivery to a comma-separated list of event kinds. It
+// returns nil when no URLs are configured.
+func NewWebhookDispatcher(urls, secret, kinds string) *WebhookDispatcher {
+	d := &WebhookDispatcher{
+		secret:  []byte(secret),
+		kinds:   make(map[string]struct{}),
+		client:  &http.Client{Timeout: webhookTimeout},
+		queue:   make(chan SystemEvent, webhookQueueSize),
+		backoff: 500 * time.Millisecond,
+	}
+	for _, u := range strings.Split(urls, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			d.urls = append(d.urls, u)
+		}
+	}
+	if len(d.urls) == 0 {
+		return nil
+	}
+	for _, k := range strings.Split(kinds, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			d.kinds[k] = struct{}{}
+		}
+	}
+	return d
+}
+
+// Notify queues an event for delivery if its kind is subscribed.
+func (d *WebhookDispatcher) Notify(ev SystemEvent) {
+	if len(d.kinds) > 0 {
+		if _, ok := d.kinds[ev.Kind]; !ok {
+			return
+		}
+	}
+	select {
+	case d.queue <- ev:
+	default:
+		log.WithFields(log.Fields{"kind": ev.Kind, "id": ev.ID}).Warn("webhook queue full; dropping event")
+	}
+}
+
+// AttachLatency times acknowledged deliveries of events whose data carries
+// the event_timestamp of the chain event they are about, such as alerts.
+func (d *WebhookDispatcher) AttachLatency(latency *LatencyTracker) {
+	d.latency = latency
+}
+
+// AttachSigner signs delivered bodies with the deployment key, next to the
+// HMAC of WEBHOOK_SECRET.
+func (d *WebhookDispatcher) AttachSigner(signer *Signer) {
+	d.signer = signer
+}
+
+// Run delivers queued events until ctx is cancelled.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-d.queue:
+			payload, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			for _, url := range d.urls {
+				if err := d.deliver(ctx, url, ev, payload); err != nil {
+					log.WithError(err).WithFields(log.Fields{"url": url, "id": ev.ID}).Error("webhook delivery failed")
+				} else if ts, ok := ev.Data["event_timestamp"].(string); ok {
+					d.latency.Observe(ev.Chain, StageWebhook, ts)
+				}
+			}
+		}
+	}
+}
+
+// sign returns the hex HMAC-SHA256 of the payload, sent in the
+// X-Tracker-Signature header so receivers can authenticate deliveries.
+func (d *WebhookDispatcher) sign(payload []byte) string {
+	mac := hmac.New(sha256.New, d.secret)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *WebhookDispatcher) deliver(ctx context.Context, url string, ev SystemEvent, payload []byte) error {
+	wait := d.backoff
+	var lastErr error
+	for attempt := 0; attempt < webhookAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Tracker-Event", ev.Kind)
+		req.Header.Set("X-Tracker-Delivery", ev.ID)
+		if len(d.secret) > 0 {
+			req.Header.Set("X-Tracker-Signature", d.sign(payload))
+		}
+		d.signer.SetHeaders(req.Header, payload)
+		resp, err := d.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("webhook returned %s", resp.Status)
+		// Client errors other than rate limiting will not succeed on retry
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return lastErr
+		}
+	}
+	return lastErr
+}
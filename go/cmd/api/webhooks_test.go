@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookDispatcherDisabledWithoutURLs(t *testing.T) {
+	if d := NewWebhookDispatcher(" , ", "secret", ""); d != nil {
+		t.Fatalf("expected nil dispatcher without urls")
+	}
+}
+
+func TestWebhookDeliverySignedAndRetried(t *testing.T) {
+	var calls int32
+	received := make(chan SystemEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first attempt to exercise the retry path
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		d := &WebhookDispatcher{secret: []byte("secret")}
+		if got := r.Header.Get("X-Tracker-Signature"); got != d.sign(body) {
+			t.Errorf("bad signature %q", got)
+		}
+		if r.Header.Get("X-Tracker-Event") != SystemIndexerGap {
+			t.Errorf("unexpected event header %q", r.Header.Get("X-Tracker-Event"))
+		}
+		var ev SystemEvent
+		_ = json.Unmarshal(body, &ev)
+		received <- ev
+	}))
+	defer srv.Close()
+
+	d := NewWebhookDispatcher(srv.URL, "secret", "")
+	d.backoff = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	d.Notify(SystemEvent{Type: "system_event", ID: "abc", Kind: SystemIndexerGap})
+	select {
+	case ev := <-received:
+		if ev.ID != "abc" {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("expected 2 attempts, got %d", n)
+	}
+}
+
+func TestWebhookKindFilter(t *testing.T) {
+	d := NewWebhookDispatcher("http://example.invalid", "", SystemAlertTriggered)
+	d.Notify(SystemEvent{Kind: SystemBackfillCompleted})
+	d.Notify(SystemEvent{Kind: SystemAlertTriggered})
+	if len(d.queue) != 1 {
+		t.Fatalf("expected only the subscribed kind to be queued, got %d", len(d.queue))
+	}
+}
@@ -0,0 +1,416 @@
+//! ERC-4337 account abstraction.
+//!
+//! Smart accounts do not send transactions themselves: a bundler EOA submits
+//! their UserOperations to the EntryPoint contract in a single `handleOps`
+//! call. These helpers decode such bundles so each UserOperation is
+//! attributed to its smart account, recording the bundler as `executed_by`.
+use ethers::abi::{self, ParamType, Token};
+use ethers::types::{Address, Log, H256, U256};
+use ethers::utils::keccak256;
+
+/// EntryPoint v0.6 and v0.7 addresses, identical on every EVM chain.
+const ENTRY_POINTS: [&str; 2] = [
+    "0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789",
+    "0x0000000071727De22E5E9d8BAf0edAC6f37da032",
+];
+
+const HANDLE_OPS_V06: &str = "handleOps((address,uint256,bytes,bytes,uint256,uint256,uint256,uint256,uint256,bytes,bytes)[],address)";
+const HANDLE_OPS_V07: &str =
+    "handleOps((address,uint256,bytes,bytes,bytes32,uint256,bytes32,bytes,bytes)[],address)";
+const USER_OPERATION_EVENT: &str =
+    "UserOperationEvent(bytes32,address,address,uint256,bool,uint256,uint256)";
+
+// Call encodings of the common smart accounts (SimpleAccount, Kernel,
+// Biconomy, ...).
+const EXECUTE: &str = "execute(address,uint256,bytes)";
+const EXECUTE_BATCH: &str = "executeBatch(address[],bytes[])";
+const EXECUTE_BATCH_WITH_VALUES: &str = "executeBatch(address[],uint256[],bytes[])";
+
+/// A UserOperation of a `handleOps` bundle.
+#[derive(Debug, PartialEq)]
+pub struct UserOperation {
+    pub sender: Address,
+    pub nonce: U256,
+    pub call_data: Vec<u8>,
+}
+
+/// A call a smart account makes while executing a UserOperation.
+#[derive(Debug, PartialEq)]
+pub struct AccountCall {
+    pub to: Address,
+    pub value: U256,
+    pub data: Vec<u8>,
+}
+
+impl AccountCall {
+    /// Whether the call only sends native currency.
+    pub fn is_native_transfer(&self) -> bool {
+        self.data.is_empty() && !self.value.is_zero()
+    }
+}
+
+fn selector(signature: &str) -> [u8; 4] {
+    let hash = keccak256(signature);
+    [hash[0], hash[1], hash[2], hash[3]]
+}
+
+/// Whether `address` is a known EntryPoint.
+pub fn is_entry_point(address: Address) -> bool {
+    ENTRY_POINTS
+        .iter()
+        .any(|a| a.parse::<Address>().ok() == Some(address))
+}
+
+/// Decode the UserOperations of an EntryPoint `handleOps` call (v0.6 or
+/// v0.7), or None when `input` is something else.
+pub fn decode_handle_ops(input: &[u8]) -> Option<Vec<UserOperation>> {
+    if input.len() < 4 {
+        return None;
+    }
+    let fields = if input[..4] == selector(HANDLE_OPS_V06) {
+        vec![
+            ParamType::Address,
+            ParamType::Uint(256),
+            ParamType::Bytes,
+            ParamType::Bytes,
+            ParamType::Uint(256),
+            ParamType::Uint(256),
+            ParamType::Uint(256),
+            ParamType::Uint(256),
+            ParamType::Uint(256),
+            ParamType::Bytes,
+            ParamType::Bytes,
+        ]
+    } else if input[..4] == selector(HANDLE_OPS_V07) {
+        vec![
+            ParamType::Address,
+            ParamType::Uint(256),
+            ParamType::Bytes,
+            ParamType::Bytes,
+            ParamType::FixedBytes(32),
+            ParamType::Uint(256),
+            ParamType::FixedBytes(32),
+            ParamType::Bytes,
+            ParamType::Bytes,
+        ]
+    } else {
+        return None;
+    };
+    let tokens = abi::decode(
+        &[
+            ParamType::Array(Box::new(ParamType::Tuple(fields))),
+            ParamType::Address,
+        ],
+        &input[4..],
+    )
+    .ok()?;
+    tokens
+        .into_iter()
+        .next()?
+        .into_array()?
+        .into_iter()
+        .map(|op| {
+            // sender, nonce, initCode, callData lead both versions
+            let mut fields = op.into_tuple()?.into_iter();
+            let sender = fields.next()?.into_address()?;
+            let nonce = fields.next()?.into_uint()?;
+            let call_data = fields.nth(1)?.into_bytes()?;
+            Some(UserOperation {
+                sender,
+                nonce,
+                call_data,
+            })
+        })
+        .collect()
+}
+
+/// Decode the calls a smart account makes for `call_data`: one for
+/// `execute`, one per entry for `executeBatch`. Other call data yields no
+/// calls.
+pub fn decode_account_calls(call_data: &[u8]) -> Vec<AccountCall> {
+    decode_calls(call_data).unwrap_or_default()
+}
+
+fn decode_calls(call_data: &[u8]) -> Option<Vec<AccountCall>> {
+    if call_data.len() < 4 {
+        return None;
+    }
+    let (sel, args) = call_data.split_at(4);
+    let addresses = ParamType::Array(Box::new(ParamType::Address));
+    let payloads = ParamType::Array(Box::new(ParamType::Bytes));
+    if sel == selector(EXECUTE) {
+        let mut tokens = abi::decode(
+            &[ParamType::Address, ParamType::Uint(256), ParamType::Bytes],
+            args,
+        )
+        .ok()?
+        .into_iter();
+        return Some(vec![AccountCall {
+            to: tokens.next()?.into_address()?,
+            value: tokens.next()?.into_uint()?,
+            data: tokens.next()?.into_bytes()?,
+        }]);
+    }
+    let (targets, values, data) = if sel == selector(EXECUTE_BATCH) {
+        let mut tokens = abi::decode(&[addresses, payloads], args).ok()?.into_iter();
+        let targets = tokens.next()?.into_array()?;
+        let values = vec![Token::Uint(U256::zero()); targets.len()];
+        (targets, values, tokens.next()?.into_array()?)
+    } else if sel == selector(EXECUTE_BATCH_WITH_VALUES) {
+        let mut tokens = abi::decode(
+            &[
+                addresses,
+                ParamType::Array(Box::new(ParamType::Uint(256))),
+                payloads,
+            ],
+            args,
+        )
+        .ok()?
+        .into_iter();
+        (
+            tokens.next()?.into_array()?,
+            tokens.next()?.into_array()?,
+            tokens.next()?.into_array()?,
+        )
+    } else {
+        return None;
+    };
+    if targets.len() != values.len() || targets.len() != data.len() {
+        return None;
+    }
+    targets
+        .into_iter()
+        .zip(values)
+        .zip(data)
+        .map(|((to, value), data)| {
+            Some(AccountCall {
+                to: to.into_address()?,
+                value: value.into_uint()?,
+                data: data.into_bytes()?,
+            })
+        })
+        .collect()
+}
+
+/// Whether the EntryPoint reported the UserOperation of `sender` with
+/// `nonce` as successful among `logs`. A reverted UserOperation still mines
+/// the bundle but emits UserOperationEvent with success = false.
+pub fn user_operation_succeeded(sender: Address, nonce: U256, logs: &[Log]) -> bool {
+    let topic = H256::from(keccak256(USER_OPERATION_EVENT));
+    let sender_topic = H256::from(sender);
+    logs.iter()
+        .filter(|l| {
+            is_entry_point(l.address)
+                && l.topics.len() == 4
+                && l.topics[0] == topic
+                && l.topics[2] == sender_topic
+        })
+        .any(|l| {
+            let decoded = abi::decode(
+                &[
+                    ParamType::Uint(256),
+                    ParamType::Bool,
+                    ParamType::Uint(256),
+                    ParamType::Uint(256),
+                ],
+                l.data.as_ref(),
+            );
+            matches!(
+                decoded.as_deref(),
+                Ok([Token::Uint(n), Token::Bool(true), ..]) if *n == nonce
+            )
+        })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn entry_point() -> Address {
+        ENTRY_POINTS[0].parse().unwrap()
+    }
+
+    fn user_op_v06(sender: Address, nonce: u64, call_data: Vec<u8>) -> Token {
+        Token::Tuple(vec![
+            Token::Address(sender),
+            Token::Uint(U256::from(nonce)),
+            Token::Bytes(vec![]),
+            Token::Bytes(call_data),
+            Token::Uint(U256::from(100_000u64)),
+            Token::Uint(U256::from(100_000u64)),
+            Token::Uint(U256::from(21_000u64)),
+            Token::Uint(U256::from(1u64)),
+            Token::Uint(U256::from(1u64)),
+            Token::Bytes(vec![]),
+            Token::Bytes(vec![0u8; 65]),
+        ])
+    }
+
+    fn user_op_v07(sender: Address, nonce: u64, call_data: Vec<u8>) -> Token {
+        Token::Tuple(vec![
+            Token::Address(sender),
+            Token::Uint(U256::from(nonce)),
+            Token::Bytes(vec![]),
+            Token::Bytes(call_data),
+            Token::FixedBytes(vec![0u8; 32]),
+            Token::Uint(U256::from(21_000u64)),
+            Token::FixedBytes(vec![0u8; 32]),
+            Token::Bytes(vec![]),
+            Token::Bytes(vec![0u8; 65]),
+        ])
+    }
+
+    fn handle_ops(signature: &str, ops: Vec<Token>) -> Vec<u8> {
+        let mut input = selector(signature).to_vec();
+        input.extend(abi::encode(&[
+            Token::Array(ops),
+            Token::Address(Address::from_low_u64_be(0xb0b)),
+        ]));
+        input
+    }
+
+    fn execute(to: Address, value: u64, data: Vec<u8>) -> Vec<u8> {
+        let mut input = selector(EXECUTE).to_vec();
+        input.extend(abi::encode(&[
+            Token::Address(to),
+            Token::Uint(U256::from(value)),
+            Token::Bytes(data),
+        ]));
+        input
+    }
+
+    fn user_operation_event(sender: Address, nonce: u64, success: bool) -> Log {
+        Log {
+            address: entry_point(),
+            topics: vec![
+                H256::from(keccak256(USER_OPERATION_EVENT)),
+                H256::zero(),
+                H256::from(sender),
+                H256::zero(),
+            ],
+            data: abi::encode(&[
+                Token::Uint(U256::from(nonce)),
+                Token::Bool(success),
+                Token::Uint(U256::zero()),
+                Token::Uint(U256::zero()),
+            ])
+            .into(),
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_handle_ops_selectors() {
+        assert_eq!(selector(HANDLE_OPS_V06), [0x1f, 0xad, 0x94, 0x8c]);
+        assert_eq!(selector(HANDLE_OPS_V07), [0x76, 0x5e, 0x82, 0x7f]);
+        assert_eq!(selector(EXECUTE), [0xb6, 0x1d, 0x27, 0xf6]);
+    }
+
+    #[test]
+    fn test_is_entry_point() {
+        assert!(is_entry_point(entry_point()));
+        assert!(is_entry_point(ENTRY_POINTS[1].parse().unwrap()));
+        assert!(!is_entry_point(Address::zero()));
+    }
+
+    #[test]
+    fn test_decode_handle_ops() {
+        let (alice, bob) = (Address::from_low_u64_be(0xa), Address::from_low_u64_be(0xb));
+        let call = execute(Address::from_low_u64_be(0xc), 7, vec![]);
+        for (signature, ops) in [
+            (
+                HANDLE_OPS_V06,
+                vec![
+                    user_op_v06(alice, 1, call.clone()),
+                    user_op_v06(bob, 2, vec![]),
+                ],
+            ),
+            (
+                HANDLE_OPS_V07,
+                vec![
+                    user_op_v07(alice, 1, call.clone()),
+                    user_op_v07(bob, 2, vec![]),
+                ],
+            ),
+        ] {
+            let ops = decode_handle_ops(&handle_ops(signature, ops)).unwrap();
+            assert_eq!(ops.len(), 2);
+            assert_eq!(ops[0].sender, alice);
+            assert_eq!(ops[0].nonce, U256::from(1u64));
+            assert_eq!(ops[0].call_data, call);
+            assert_eq!(ops[1].sender, bob);
+        }
+    }
+
+    #[test]
+    fn test_decode_other_calldata() {
+        assert!(decode_handle_ops(&[]).is_none());
+        assert!(decode_handle_ops(&[0xa9, 0x05, 0x9c, 0xbb, 0, 0]).is_none());
+        // Right selector, truncated arguments
+        assert!(decode_handle_ops(&selector(HANDLE_OPS_V06)).is_none());
+    }
+
+    #[test]
+    fn test_decode_execute() {
+        let to = Address::from_low_u64_be(0xbeef);
+        let calls = decode_account_calls(&execute(to, 1_000, vec![]));
+        assert_eq!(calls.len(), 1);
+        assert_eq!(calls[0].to, to);
+        assert!(calls[0].is_native_transfer());
+
+        let token_call = decode_account_calls(&execute(to, 0, vec![0xa9, 0x05, 0x9c, 0xbb]));
+        assert!(!token_call[0].is_native_transfer());
+        assert!(decode_account_calls(&[0xde, 0xad, 0xbe, 0xef]).is_empty());
+    }
+
+    #[test]
+    fn test_decode_execute_batch() {
+        let (a, b) = (Address::from_low_u64_be(1), Address::from_low_u64_be(2));
+        let mut input = selector(EXECUTE_BATCH_WITH_VALUES).to_vec();
+        input.extend(abi::encode(&[
+            Token::Array(vec![Token::Address(a), Token::Address(b)]),
+            Token::Array(vec![
+                Token::Uint(U256::from(5u64)),
+                Token::Uint(U256::zero()),
+            ]),
+            Token::Array(vec![Token::Bytes(vec![]), Token::Bytes(vec![1, 2, 3, 4])]),
+        ]));
+        let calls = decode_account_calls(&input);
+        assert_eq!(calls.len(), 2);
+        assert!(calls[0].is_native_transfer());
+        assert_eq!(calls[0].to, a);
+        assert!(!calls[1].is_native_transfer());
+
+        let mut input = selector(EXECUTE_BATCH).to_vec();
+        input.extend(abi::encode(&[
+            Token::Array(vec![Token::Address(a)]),
+            Token::Array(vec![Token::Bytes(vec![1, 2, 3, 4])]),
+        ]));
+        let calls = decode_account_calls(&input);
+        assert_eq!(calls.len(), 1);
+        assert!(calls[0].value.is_zero());
+    }
+
+    #[test]
+    fn test_user_operation_succeeded() {
+        let sender = Address::from_low_u64_be(0xa);
+        let logs = [
+            user_operation_event(sender, 1, true),
+            user_operation_event(sender, 2, false),
+        ];
+        assert!(user_operation_succeeded(sender, U256::from(1u64), &logs));
+        assert!(!user_operation_succeeded(sender, U256::from(2u64), &logs));
+        assert!(!user_operation_succeeded(
+            Address::from_low_u64_be(0xb),
+            U256::from(1u64),
+            &logs
+        ));
+        let mut spoofed = user_operation_event(sender, 1, true);
+        spoofed.address = Address::zero();
+        assert!(!user_operation_succeeded(
+            sender,
+            U256::from(1u64),
+            &[spoofed]
+        ));
+    }
+}
@@ -0,0 +1,503 @@
+//! Bridge protocol awareness.
+//!
+//! Cross-chain transfers leave one transaction on each chain. Bridges number
+//! their messages, so instead of pairing the two legs by amount and timing
+//! these helpers recognize the messages of Wormhole's token bridge,
+//! LayerZero v2 and Circle's CCTP and report the protocol's identifier
+//! alongside the chains involved. Both legs of a transfer get the same
+//! `bridge`, `source_chain` and `sequence`.
+use ethers::abi::{self, ParamType};
+use ethers::types::{Address, Log, H256, U256};
+use ethers::utils::keccak256;
+use serde::Serialize;
+use serde_json::Value;
+
+/// `bridge` values.
+pub const WORMHOLE: &str = "wormhole";
+pub const LAYERZERO: &str = "layerzero";
+pub const CCTP: &str = "cctp";
+
+const LOG_MESSAGE_PUBLISHED: &str = "LogMessagePublished(address,uint64,uint32,bytes,uint8)";
+const TRANSFER_REDEEMED: &str = "TransferRedeemed(uint16,bytes32,uint64)";
+const PACKET_SENT: &str = "PacketSent(bytes,bytes,address)";
+const PACKET_DELIVERED: &str = "PacketDelivered((uint32,bytes32,uint64),address)";
+const DEPOSIT_FOR_BURN: &str =
+    "DepositForBurn(uint64,address,uint256,address,bytes32,uint32,bytes32,bytes32)";
+const MESSAGE_RECEIVED: &str = "MessageReceived(address,uint32,uint64,bytes32,bytes)";
+
+/// Wormhole chain IDs, mainnet and testnet.
+const WORMHOLE_CHAINS: [(u16, &str); 12] = [
+    (1, "solana"),
+    (2, "ethereum"),
+    (4, "bsc"),
+    (5, "polygon"),
+    (6, "avalanche"),
+    (23, "arbitrum"),
+    (24, "optimism"),
+    (30, "base"),
+    (10002, "ethereum"),
+    (10003, "arbitrum"),
+    (10004, "base"),
+    (10005, "optimism"),
+];
+
+/// LayerZero v2 endpoint IDs with the chain and network they stand for.
+const LAYERZERO_EIDS: [(u32, &str, &str); 14] = [
+    (30101, "ethereum", "mainnet"),
+    (30102, "bsc", "mainnet"),
+    (30106, "avalanche", "mainnet"),
+    (30109, "polygon", "mainnet"),
+    (30110, "arbitrum", "mainnet"),
+    (30111, "optimism", "mainnet"),
+    (30168, "solana", "mainnet"),
+    (30184, "base", "mainnet"),
+    (40161, "ethereum", "sepolia"),
+    (40168, "solana", "devnet"),
+    (40217, "ethereum", "holesky"),
+    (40231, "arbitrum", "sepolia"),
+    (40232, "optimism", "sepolia"),
+    (40245, "base", "sepolia"),
+];
+
+/// CCTP domains, shared by mainnet and testnet.
+const CCTP_DOMAINS: [(u32, &str); 7] = [
+    (0, "ethereum"),
+    (1, "avalanche"),
+    (2, "optimism"),
+    (3, "arbitrum"),
+    (5, "solana"),
+    (6, "base"),
+    (7, "polygon"),
+];
+
+/// Wormhole token bridge programs on Solana mainnet and devnet.
+const WORMHOLE_TOKEN_BRIDGE_PROGRAMS: [&str; 2] = [
+    "wormDTUJ6AWPNvk59vGQbDvGJmqbDTdgWgAqcLBCgUb",
+    "DZnkkTmCiFWfYTfT41X3Rd1kDgozqzxWaHqsw6W4x2oe",
+];
+
+/// A bridge message sent or received by a transaction.
+#[derive(Serialize, Debug, Clone, PartialEq)]
+pub struct BridgeMessage {
+    pub bridge: String,
+    pub source_chain: String,
+    /// Empty when the sending side does not say where the message goes.
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub dest_chain: String,
+    /// The message's identifier on its route: the token bridge sequence for
+    /// Wormhole, the nonce for CCTP and the packet GUID for LayerZero, whose
+    /// nonces are only unique per sender and receiver.
+    pub sequence: String,
+}
+
+impl BridgeMessage {
+    fn new(bridge: &str, source_chain: String, dest_chain: String, sequence: String) -> Self {
+        BridgeMessage {
+            bridge: bridge.into(),
+            source_chain,
+            dest_chain,
+            sequence,
+        }
+    }
+}
+
+fn topic(signature: &str) -> H256 {
+    H256::from(keccak256(signature))
+}
+
+/// Chain name of a protocol's chain identifier. Chains the tracker does not
+/// know are named after the protocol, e.g. `wormhole:21`.
+fn chain_name<T: PartialEq + std::fmt::Display>(
+    bridge: &str,
+    table: &[(T, &str)],
+    id: T,
+) -> String {
+    table
+        .iter()
+        .find(|(known, _)| *known == id)
+        .map(|(_, chain)| chain.to_string())
+        .unwrap_or_else(|| format!("{}:{}", bridge, id))
+}
+
+fn layerzero_chain(eid: u32) -> String {
+    LAYERZERO_EIDS
+        .iter()
+        .find(|(known, _, _)| *known == eid)
+        .map(|(_, chain, _)| chain.to_string())
+        .unwrap_or_else(|| format!("{}:{}", LAYERZERO, eid))
+}
+
+fn layerzero_eid(chain: &str, network: &str) -> Option<u32> {
+    LAYERZERO_EIDS
+        .iter()
+        .find(|(_, c, n)| *c == chain && *n == network)
+        .map(|(eid, _, _)| *eid)
+}
+
+/// Decode the first bridge message among a transaction's `logs`. `chain`
+/// and `network` are where the transaction was mined.
+pub fn decode_evm_logs(chain: &str, network: &str, logs: &[Log]) -> Option<BridgeMessage> {
+    logs.iter()
+        .find_map(|log| decode_evm_log(chain, network, log))
+}
+
+fn decode_evm_log(chain: &str, network: &str, log: &Log) -> Option<BridgeMessage> {
+    let signature = *log.topics.first()?;
+    if signature == topic(LOG_MESSAGE_PUBLISHED) {
+        decode_log_message_published(chain, log)
+    } else if signature == topic(TRANSFER_REDEEMED) {
+        decode_transfer_redeemed(chain, log)
+    } else if signature == topic(PACKET_SENT) {
+        decode_packet_sent(log)
+    } else if signature == topic(PACKET_DELIVERED) {
+        decode_packet_delivered(chain, network, log)
+    } else if signature == topic(DEPOSIT_FOR_BURN) {
+        decode_deposit_for_burn(chain, log)
+    } else if signature == topic(MESSAGE_RECEIVED) {
+        decode_message_received(chain, log)
+    } else {
+        None
+    }
+}
+
+/// A Wormhole core message carrying a token bridge transfer. Other
+/// emitters' messages are skipped: their sequences are counted per emitter
+/// and could not be told apart from the token bridge's.
+fn decode_log_message_published(chain: &str, log: &Log) -> Option<BridgeMessage> {
+    let mut tokens = abi::decode(
+        &[
+            ParamType::Uint(64),
+            ParamType::Uint(32),
+            ParamType::Bytes,
+            ParamType::Uint(8),
+        ],
+        &log.data,
+    )
+    .ok()?
+    .into_iter();
+    let sequence = tokens.next()?.into_uint()?;
+    let payload = tokens.nth(1)?.into_bytes()?;
+    // Transfer (1) and TransferWithPayload (3): payload id, amount, token
+    // address, token chain and recipient precede the target chain
+    if !matches!(payload.first(), Some(1) | Some(3)) || payload.len() < 101 {
+        return None;
+    }
+    let to_chain = u16::from_be_bytes([payload[99], payload[100]]);
+    Some(BridgeMessage::new(
+        WORMHOLE,
+        chain.into(),
+        chain_name(WORMHOLE, &WORMHOLE_CHAINS, to_chain),
+        sequence.to_string(),
+    ))
+}
+
+fn decode_transfer_redeemed(chain: &str, log: &Log) -> Option<BridgeMessage> {
+    if log.topics.len() != 4 {
+        return None;
+    }
+    let emitter_chain = U256::from_big_endian(log.topics[1].as_bytes()).low_u32() as u16;
+    let sequence = U256::from_big_endian(log.topics[3].as_bytes());
+    Some(BridgeMessage::new(
+        WORMHOLE,
+        chain_name(WORMHOLE, &WORMHOLE_CHAINS, emitter_chain),
+        chain.into(),
+        sequence.to_string(),
+    ))
+}
+
+/// A LayerZero packet: an 81 byte header (version, nonce, source eid,
+/// sender, destination eid, receiver) followed by the GUID and message.
+fn decode_packet_sent(log: &Log) -> Option<BridgeMessage> {
+    let packet = abi::decode(
+        &[ParamType::Bytes, ParamType::Bytes, ParamType::Address],
+        &log.data,
+    )
+    .ok()?
+    .into_iter()
+    .next()?
+    .into_bytes()?;
+    if packet.len() < 113 {
+        return None;
+    }
+    let src_eid = u32::from_be_bytes(packet[9..13].try_into().ok()?);
+    let dst_eid = u32::from_be_bytes(packet[45..49].try_into().ok()?);
+    Some(BridgeMessage::new(
+        LAYERZERO,
+        layerzero_chain(src_eid),
+        layerzero_chain(dst_eid),
+        format!("{:?}", H256::from_slice(&packet[81..113])),
+    ))
+}
+
+/// A delivered LayerZero packet. Its GUID is not logged, so it is derived
+/// from the origin and receiver the way the endpoint generates it.
+fn decode_packet_delivered(chain: &str, network: &str, log: &Log) -> Option<BridgeMessage> {
+    let mut tokens = abi::decode(
+        &[
+            ParamType::Tuple(vec![
+                ParamType::Uint(32),
+                ParamType::FixedBytes(32),
+                ParamType::Uint(64),
+            ]),
+            ParamType::Address,
+        ],
+        &log.data,
+    )
+    .ok()?
+    .into_iter();
+    let mut origin = tokens.next()?.into_tuple()?.into_iter();
+    let receiver = tokens.next()?.into_address()?;
+    let src_eid = origin.next()?.into_uint()?.low_u32();
+    let sender = origin.next()?.into_fixed_bytes()?;
+    let nonce = origin.next()?.into_uint()?.low_u64();
+    let dst_eid = layerzero_eid(chain, network)?;
+    Some(BridgeMessage::new(
+        LAYERZERO,
+        layerzero_chain(src_eid),
+        chain.into(),
+        format!(
+            "{:?}",
+            layerzero_guid(nonce, src_eid, &sender, dst_eid, receiver)
+        ),
+    ))
+}
+
+/// GUID of a LayerZero v2 packet, as generated by the sending endpoint.
+fn layerzero_guid(
+    nonce: u64,
+    src_eid: u32,
+    sender: &[u8],
+    dst_eid: u32,
+    receiver: Address,
+) -> H256 {
+    let mut packed = Vec::with_capacity(80);
+    packed.extend_from_slice(&nonce.to_be_bytes());
+    packed.extend_from_slice(&src_eid.to_be_bytes());
+    packed.extend_from_slice(sender);
+    packed.extend_from_slice(&dst_eid.to_be_bytes());
+    packed.extend_from_slice(H256::from(receiver).as_bytes());
+    H256::from(keccak256(packed))
+}
+
+fn decode_deposit_for_burn(chain: &str, log: &Log) -> Option<BridgeMessage> {
+    if log.topics.len() != 4 {
+        return None;
+    }
+    let nonce = U256::from_big_endian(log.topics[1].as_bytes());
+    // amount, mintRecipient, destinationDomain, ...
+    let domain = log.data.get(64..96)?;
+    let dest_domain = U256::from_big_endian(domain).low_u32();
+    Some(BridgeMessage::new(
+        CCTP,
+        chain.into(),
+        chain_name(CCTP, &CCTP_DOMAINS, dest_domain),
+        nonce.to_string(),
+    ))
+}
+
+fn decode_message_received(chain: &str, log: &Log) -> Option<BridgeMessage> {
+    if log.topics.len() != 3 {
+        return None;
+    }
+    let nonce = U256::from_big_endian(log.topics[2].as_bytes());
+    let source_domain = U256::from_big_endian(log.data.get(..32)?).low_u32();
+    Some(BridgeMessage::new(
+        CCTP,
+        chain_name(CCTP, &CCTP_DOMAINS, source_domain),
+        chain.into(),
+        nonce.to_string(),
+    ))
+}
+
+/// Decode an outgoing Wormhole token bridge transfer from a `getTransaction`
+/// result fetched with `jsonParsed` encoding. The core bridge logs the
+/// message's sequence; the destination chain is not logged and stays empty.
+pub fn decode_solana_transaction(tx: &Value) -> Option<BridgeMessage> {
+    let invokes_token_bridge = tx["transaction"]["message"]["accountKeys"]
+        .as_array()?
+        .iter()
+        .filter_map(|k| k.as_str().or_else(|| k["pubkey"].as_str()))
+        .any(|k| WORMHOLE_TOKEN_BRIDGE_PROGRAMS.contains(&k));
+    if !invokes_token_bridge {
+        return None;
+    }
+    let sequence = tx["meta"]["logMessages"]
+        .as_array()?
+        .iter()
+        .filter_map(Value::as_str)
+        .find_map(|line| line.strip_prefix("Program log: Sequence: "))?
+        .trim()
+        .parse::<u64>()
+        .ok()?;
+    Some(BridgeMessage::new(
+        WORMHOLE,
+        "solana".into(),
+        String::new(),
+        sequence.to_string(),
+    ))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use ethers::abi::Token;
+    use serde_json::json;
+
+    fn log(signature: &str, topics: Vec<H256>, data: Vec<u8>) -> Log {
+        let mut all = vec![topic(signature)];
+        all.extend(topics);
+        Log {
+            topics: all,
+            data: data.into(),
+            ..Default::default()
+        }
+    }
+
+    fn word(n: u64) -> H256 {
+        H256::from_low_u64_be(n)
+    }
+
+    #[test]
+    fn test_wormhole_token_bridge_transfer() {
+        let mut payload = vec![1u8];
+        payload.extend([0u8; 98]);
+        payload.extend(30u16.to_be_bytes());
+        payload.extend([0u8; 32]);
+        let data = abi::encode(&[
+            Token::Uint(U256::from(4242u64)),
+            Token::Uint(U256::zero()),
+            Token::Bytes(payload),
+            Token::Uint(U256::from(1u64)),
+        ]);
+        let sent = log(LOG_MESSAGE_PUBLISHED, vec![word(0xb1)], data);
+        let msg = decode_evm_logs("ethereum", "mainnet", &[Log::default(), sent]).unwrap();
+        assert_eq!(
+            msg,
+            BridgeMessage::new(WORMHOLE, "ethereum".into(), "base".into(), "4242".into())
+        );
+
+        let redeemed = log(
+            TRANSFER_REDEEMED,
+            vec![word(2), word(0xb1), word(4242)],
+            vec![],
+        );
+        let msg = decode_evm_logs("base", "mainnet", &[redeemed]).unwrap();
+        assert_eq!(
+            msg,
+            BridgeMessage::new(WORMHOLE, "ethereum".into(), "base".into(), "4242".into())
+        );
+    }
+
+    #[test]
+    fn test_wormhole_other_payloads_are_skipped() {
+        let data = abi::encode(&[
+            Token::Uint(U256::from(7u64)),
+            Token::Uint(U256::zero()),
+            Token::Bytes(b"governance".to_vec()),
+            Token::Uint(U256::from(1u64)),
+        ]);
+        let sent = log(LOG_MESSAGE_PUBLISHED, vec![word(0xb1)], data);
+        assert!(decode_evm_logs("ethereum", "mainnet", &[sent]).is_none());
+    }
+
+    #[test]
+    fn test_layerzero_legs_share_the_guid() {
+        let sender = Address::from_low_u64_be(0xa1);
+        let receiver = Address::from_low_u64_be(0xb2);
+        let guid = layerzero_guid(9, 30101, H256::from(sender).as_bytes(), 30184, receiver);
+
+        let mut packet = vec![1u8];
+        packet.extend(9u64.to_be_bytes());
+        packet.extend(30101u32.to_be_bytes());
+        packet.extend(H256::from(sender).as_bytes());
+        packet.extend(30184u32.to_be_bytes());
+        packet.extend(H256::from(receiver).as_bytes());
+        packet.extend(guid.as_bytes());
+        packet.extend(b"message");
+        let data = abi::encode(&[
+            Token::Bytes(packet),
+            Token::Bytes(vec![]),
+            Token::Address(Address::zero()),
+        ]);
+        let sent = decode_evm_logs("ethereum", "mainnet", &[log(PACKET_SENT, vec![], data)]);
+
+        let data = abi::encode(&[
+            Token::Tuple(vec![
+                Token::Uint(U256::from(30101u64)),
+                Token::FixedBytes(H256::from(sender).as_bytes().to_vec()),
+                Token::Uint(U256::from(9u64)),
+            ]),
+            Token::Address(receiver),
+        ]);
+        let delivered = decode_evm_logs("base", "mainnet", &[log(PACKET_DELIVERED, vec![], data)]);
+
+        let want = BridgeMessage::new(
+            LAYERZERO,
+            "ethereum".into(),
+            "base".into(),
+            format!("{:?}", guid),
+        );
+        assert_eq!(sent.unwrap(), want);
+        assert_eq!(delivered.unwrap(), want);
+    }
+
+    #[test]
+    fn test_cctp_burn_and_mint() {
+        let data = abi::encode(&[
+            Token::Uint(U256::from(1_000_000u64)),
+            Token::FixedBytes(vec![0u8; 32]),
+            Token::Uint(U256::from(5u64)),
+            Token::FixedBytes(vec![0u8; 32]),
+            Token::FixedBytes(vec![0u8; 32]),
+        ]);
+        let burn = log(
+            DEPOSIT_FOR_BURN,
+            vec![word(77), word(0xc0), word(0xd0)],
+            data,
+        );
+        assert_eq!(
+            decode_evm_logs("ethereum", "sepolia", &[burn]).unwrap(),
+            BridgeMessage::new(CCTP, "ethereum".into(), "solana".into(), "77".into())
+        );
+
+        let data = abi::encode(&[
+            Token::Uint(U256::from(6u64)),
+            Token::FixedBytes(vec![0u8; 32]),
+            Token::Bytes(vec![]),
+        ]);
+        let mint = log(MESSAGE_RECEIVED, vec![word(0xc0), word(78)], data);
+        assert_eq!(
+            decode_evm_logs("ethereum", "mainnet", &[mint]).unwrap(),
+            BridgeMessage::new(CCTP, "base".into(), "ethereum".into(), "78".into())
+        );
+    }
+
+    #[test]
+    fn test_unknown_chains_are_named_after_the_bridge() {
+        assert_eq!(chain_name(CCTP, &CCTP_DOMAINS, 10), "cctp:10");
+        assert_eq!(layerzero_chain(30999), "layerzero:30999");
+    }
+
+    #[test]
+    fn test_solana_wormhole_transfer() {
+        let tx = json!({
+            "transaction": {"message": {"accountKeys": [
+                {"pubkey": "Payer111111111111111111111111111111111111"},
+                {"pubkey": WORMHOLE_TOKEN_BRIDGE_PROGRAMS[0]}
+            ]}},
+            "meta": {"logMessages": [
+                "Program worm2ZoG2kUd4vFXhvjh93UUH596ayRfgQ2MgjNMTth invoke [2]",
+                "Program log: Sequence: 31337"
+            ]}
+        });
+        assert_eq!(
+            decode_solana_transaction(&tx).unwrap(),
+            BridgeMessage::new(WORMHOLE, "solana".into(), String::new(), "31337".into())
+        );
+
+        let mut other = tx.clone();
+        other["transaction"]["message"]["accountKeys"][1]["pubkey"] =
+            json!("11111111111111111111111111111111");
+        assert!(decode_solana_transaction(&other).is_none());
+    }
+}
@@ -1,6 +1,33 @@
-use anyhow::{Context, Result};
+use anyhow::{anyhow, Context, Result};
 use dotenvy::dotenv;
 
+/// How much of each chain the listener indexes, from `INDEXING_PROFILE`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum IndexingProfile {
+    /// Scan every block's transactions. With no watched addresses, every
+    /// transfer is published.
+    Full,
+    /// Only fetch what concerns the watched addresses: targeted log
+    /// filters, blocks where a watched balance changed, and the backfills
+    /// the API requests. For deployments that cannot afford full-chain
+    /// ingestion.
+    WatchOnly,
+}
+
+impl IndexingProfile {
+    /// Parses `full` (the default when empty) or `watch-only`.
+    pub fn parse(s: &str) -> Result<Self> {
+        match s.trim() {
+            "" | "full" => Ok(IndexingProfile::Full),
+            "watch-only" => Ok(IndexingProfile::WatchOnly),
+            other => Err(anyhow!(
+                "INDEXING_PROFILE must be full or watch-only, got {}",
+                other
+            )),
+        }
+    }
+}
+
 /// Runtime configuration for the listener service loaded from environment.
 #[derive(Debug, Clone)]
 pub struct Config {
@@ -15,6 +42,7 @@ pub struct Config {
     pub poll_interval_secs: u64,
     #[allow(dead_code)]
     pub log_level: Option<String>,
+    pub indexing_profile: IndexingProfile,
 }
 
 impl Config {
@@ -104,6 +132,17 @@ impl Config {
 
         let log_level = std::env::var("LOG_LEVEL").ok();
 
+        let indexing_profile =
+            IndexingProfile::parse(&std::env::var("INDEXING_PROFILE").unwrap_or_default())?;
+        if indexing_profile == IndexingProfile::WatchOnly
+            && watched_addresses_eth.is_empty()
+            && watched_addresses_sol.is_empty()
+        {
+            return Err(anyhow!(
+                "INDEXING_PROFILE=watch-only needs WATCHED_ADDRESSES_ETH or WATCHED_ADDRESSES_SOL"
+            ));
+        }
+
         Ok(Config {
             eth_rpc_url,
             sol_rpc_url,
@@ -114,6 +153,7 @@ impl Config {
             sol_network,
             poll_interval_secs,
             log_level,
+            indexing_profile,
         })
     }
 }
@@ -134,6 +174,7 @@ mod tests {
         std::env::remove_var("SOL_NETWORK");
         std::env::remove_var("POLL_INTERVAL_SECS");
         std::env::remove_var("LOG_LEVEL");
+        std::env::remove_var("INDEXING_PROFILE");
     }
 
     #[test]
@@ -172,6 +213,7 @@ mod tests {
         assert_eq!(cfg.watched_addresses_eth.len(), 2);
         assert_eq!(cfg.watched_addresses_sol.len(), 2);
         assert_eq!(cfg.poll_interval_secs, 42);
+        assert_eq!(cfg.indexing_profile, IndexingProfile::Full);
 
         // Clean up after test
         cleanup_env();
@@ -203,4 +245,36 @@ mod tests {
             res
         );
     }
+
+    #[test]
+    #[serial]
+    fn test_config_watch_only_needs_watched_addresses() {
+        cleanup_env();
+        std::env::set_var("ETH_RPC_URL", "wss://example.eth");
+        std::env::set_var("SOL_RPC_URL", "wss://example.sol");
+        std::env::set_var("REDIS_URL", "redis://localhost");
+        std::env::set_var("ETH_NETWORK", "mainnet");
+        std::env::set_var("SOL_NETWORK", "mainnet");
+        std::env::set_var("WATCHED_ADDRESSES_ETH", "");
+        std::env::set_var("WATCHED_ADDRESSES_SOL", "");
+        std::env::set_var("INDEXING_PROFILE", "watch-only");
+        let without = Config::from_env();
+
+        std::env::set_var(
+            "WATCHED_ADDRESSES_ETH",
+            "0x0000000000000000000000000000000000000001",
+        );
+        let with = Config::from_env();
+
+        std::env::set_var("INDEXING_PROFILE", "partial");
+        let unknown = Config::from_env();
+        cleanup_env();
+
+        assert!(without.is_err(), "watch-only without addresses loaded");
+        assert_eq!(
+            with.expect("config should load").indexing_profile,
+            IndexingProfile::WatchOnly
+        );
+        assert!(unknown.is_err(), "unknown profile accepted");
+    }
 }
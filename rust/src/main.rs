@@ -2,9 +2,10 @@
 //!
 //! This binary monitors Ethereum and Solana for transactions touching a set of
 //! watched addresses. Events are normalized to a chain-agnostic JSON schema and
-//! published to a Redis Pub/Sub channel (`cross_chain_events`) for the Go API to
-//! consume. The listener supports both websocket subscriptions (preferred in
-//! production) and HTTP polling (useful for local testing with Anvil/Devnet).
+//! published to a per-network Redis Pub/Sub channel
+//! (`cross_chain_events:<chain>:<network>`) for the Go API to consume. The
+//! listener supports both websocket subscriptions (preferred in production) and
+//! HTTP polling (useful for local testing with Anvil/Devnet).
 use anyhow::anyhow;
 use redis::AsyncCommands;
 use serde::{Deserialize, Serialize};
@@ -24,20 +25,59 @@ use solana_transaction_status::UiTransactionEncoding;
 
 use tracing::{error, info, warn};
 use tracing_subscriber::{fmt, EnvFilter};
+mod account_abstraction;
+mod bridges;
 mod config;
+mod multisig;
 mod retry;
 mod solana_parser;
+mod watch_filters;
+mod watch_only;
+
+use config::IndexingProfile;
 
 // Include the golden test module
 mod tests;
 
+/// Redis channel carrying events for a single chain/network pair, e.g.
+/// `cross_chain_events:ethereum:mainnet`.
+fn events_channel(chain: &str, network: &str) -> String {
+    format!("cross_chain_events:{}:{}", chain, network)
+}
+
+/// Version of the JSON event envelope, sent as `schema_version`. Bump it
+/// when fields are renamed or change meaning, and teach the Go API to
+/// decode the new version before deploying the listener.
+const EVENT_SCHEMA_VERSION: u32 = 1;
+
+/// Producer name sent as `source`, e.g. `indexer-ethereum@v0.1.0`, so the
+/// API can trace events back to the listener build that indexed them.
+fn event_source(chain: &str) -> String {
+    format!("indexer-{}@v{}", chain, env!("CARGO_PKG_VERSION"))
+}
+
+/// What is published for an event: its fields plus the envelope version
+/// and the producer.
+#[derive(Serialize)]
+struct Envelope<'a> {
+    schema_version: u32,
+    source: String,
+    #[serde(flatten)]
+    event: &'a Event,
+}
+
 /// Publish a normalized event to Redis with retry and exponential backoff.
 ///
 /// On success, returns Ok(()). On repeated failures, returns the last error
 /// and logs a structured message for operational visibility.
 async fn publish_event_to_redis(redis_client: &redis::Client, event: &Event) -> anyhow::Result<()> {
     use retry::retry_with_backoff;
-    let payload = serde_json::to_string(event)?;
+    let payload = serde_json::to_string(&Envelope {
+        schema_version: EVENT_SCHEMA_VERSION,
+        source: event_source(&event.chain),
+        event,
+    })?;
+    let channel = events_channel(&event.chain, &event.network);
     // Retry publish with exponential backoff to survive short redis outages
     let attempts = 8usize;
     let base = Duration::from_millis(500);
@@ -46,9 +86,10 @@ async fn publish_event_to_redis(redis_client: &redis::Client, event: &Event) ->
     let res: anyhow::Result<()> = retry_with_backoff(attempts, base, factor, || {
         let client = redis_client.clone();
         let payload = payload.clone();
+        let channel = channel.clone();
         async move {
             match client.get_multiplexed_async_connection().await {
-                Ok(mut con) => match con.publish::<_, _, ()>("cross_chain_events", payload).await {
+                Ok(mut con) => match con.publish::<_, _, ()>(channel, payload).await {
                     Ok(_) => Ok(()),
                     Err(e) => Err(anyhow!(e)),
                 },
@@ -176,6 +217,193 @@ struct Event {
     slot: Option<u64>,
     #[serde(skip_serializing_if = "Option::is_none")]
     token: Option<Token>,
+    /// Account that submitted a transfer on behalf of `from`: the executing
+    /// member of a multisig, or the bundler of an ERC-4337 smart account.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    executed_by: Option<String>,
+    /// Kind of multisig in `from` (`gnosis_safe` or `squads`).
+    #[serde(skip_serializing_if = "Option::is_none")]
+    multisig: Option<String>,
+    /// Program controlling the PDA that signed a Solana token transfer.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    authority: Option<Authority>,
+    /// Bridge message the transaction sent or received, flattened into
+    /// `bridge`, `source_chain`, `dest_chain` and `sequence`.
+    #[serde(flatten)]
+    bridge: Option<bridges::BridgeMessage>,
+    /// What the transaction cost, flattened into `fee`, `gas_used` and
+    /// `priority_fee`. Every event of a transaction repeats it.
+    #[serde(flatten)]
+    fee: Option<Fee>,
+    /// Nonce of the EVM transaction, which its submitter (`executed_by`
+    /// when set, `from` otherwise) sent; the API uses it to find
+    /// transactions the listener missed.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    nonce: Option<u64>,
+    /// Position of the transfer in its transaction: the log index of an
+    /// ERC-20 transfer, the instruction index of a Solana one. With the tx
+    /// hash it identifies the transfer whatever `event_id` an indexer gave it.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    log_index: Option<u64>,
+}
+
+/// Fee of a transaction in the chain's native smallest unit (wei,
+/// lamports). `gas_used` is gas on EVM chains and compute units on Solana;
+/// `priority_fee` is the part of `fee` paid above the base fee.
+#[derive(Serialize, Debug, Clone)]
+struct Fee {
+    fee: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    gas_used: Option<u64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    priority_fee: Option<String>,
+}
+
+impl From<solana_parser::TransactionFee> for Fee {
+    fn from(f: solana_parser::TransactionFee) -> Self {
+        Fee {
+            fee: f.fee.to_string(),
+            gas_used: f.compute_units,
+            priority_fee: Some(f.priority_fee.to_string()),
+        }
+    }
+}
+
+/// Fee paid for an EVM transaction, from its receipt and the base fee of its
+/// block (None before EIP-1559, when there is no priority fee to tell apart).
+fn evm_fee(receipt: &TransactionReceipt, base_fee: Option<U256>) -> Option<Fee> {
+    let gas_used = receipt.gas_used?;
+    let price = receipt.effective_gas_price?;
+    Some(Fee {
+        fee: (gas_used * price).to_string(),
+        gas_used: Some(gas_used.low_u64()),
+        priority_fee: base_fee.map(|base| (gas_used * price.saturating_sub(base)).to_string()),
+    })
+}
+
+/// Program-derived address that signed a token transfer and the program
+/// that signed for it.
+#[derive(Serialize, Debug)]
+struct Authority {
+    address: String,
+    program: String,
+}
+
+/// The parties of a native transfer. Usually the transaction itself, but a
+/// Safe execution sending native currency is the Safe's transfer, made on
+/// its behalf by the owner who sent the transaction, and each UserOperation
+/// of an ERC-4337 bundle is its smart account's transfer, submitted by the
+/// bundler.
+struct NativeTransfer {
+    event_id: String,
+    from: Address,
+    to: Address,
+    value: U256,
+    executed_by: Option<String>,
+    multisig: Option<String>,
+}
+
+/// Resolve the native transfers made by `tx`. Only Safe executions and
+/// EntryPoint bundles cost an extra RPC call, to check that the inner calls
+/// succeeded.
+async fn resolve_native_transfers<M: Middleware>(
+    provider: &M,
+    tx: &Transaction,
+) -> Vec<NativeTransfer> {
+    let direct = NativeTransfer {
+        event_id: format!("eth:{:?}", tx.hash),
+        from: tx.from,
+        to: tx.to.unwrap_or_default(),
+        value: tx.value,
+        executed_by: None,
+        multisig: None,
+    };
+    let Some(to) = tx.to else {
+        return vec![direct];
+    };
+    if account_abstraction::is_entry_point(to) {
+        if let Some(ops) = account_abstraction::decode_handle_ops(&tx.input) {
+            return resolve_user_operations(provider, tx, ops).await;
+        }
+    }
+    let Some(call) = multisig::decode_exec_transaction(&tx.input) else {
+        return vec![direct];
+    };
+    if !call.is_native_transfer() {
+        return vec![direct];
+    }
+    match provider.get_transaction_receipt(tx.hash).await {
+        Ok(Some(receipt)) if multisig::safe_execution_succeeded(to, &receipt.logs) => {
+            vec![NativeTransfer {
+                event_id: direct.event_id,
+                from: to,
+                to: call.to,
+                value: call.value,
+                executed_by: Some(format!("{:?}", tx.from)),
+                multisig: Some(multisig::GNOSIS_SAFE.into()),
+            }]
+        }
+        _ => vec![direct],
+    }
+}
+
+/// One transfer per native-currency call of each successful UserOperation
+/// in the bundle `tx`, identified as `eth:<tx>:op<i>` (`:op<i>:<j>` for
+/// batched calls). The bundler's own zero-value call is not a transfer.
+async fn resolve_user_operations<M: Middleware>(
+    provider: &M,
+    tx: &Transaction,
+    ops: Vec<account_abstraction::UserOperation>,
+) -> Vec<NativeTransfer> {
+    let logs = match provider.get_transaction_receipt(tx.hash).await {
+        Ok(Some(receipt)) => receipt.logs,
+        _ => return Vec::new(),
+    };
+    let mut transfers = Vec::new();
+    for (i, op) in ops.iter().enumerate() {
+        if !account_abstraction::user_operation_succeeded(op.sender, op.nonce, &logs) {
+            continue;
+        }
+        let calls = account_abstraction::decode_account_calls(&op.call_data);
+        let batched = calls.len() > 1;
+        for (j, call) in calls.into_iter().enumerate() {
+            if !call.is_native_transfer() {
+                continue;
+            }
+            let event_id = if batched {
+                format!("eth:{:?}:op{}:{}", tx.hash, i, j)
+            } else {
+                format!("eth:{:?}:op{}", tx.hash, i)
+            };
+            transfers.push(NativeTransfer {
+                event_id,
+                from: op.sender,
+                to: call.to,
+                value: call.value,
+                executed_by: Some(format!("{:?}", tx.from)),
+                multisig: None,
+            });
+        }
+    }
+    transfers
+}
+
+/// `executed_by` and `multisig` of a token transfer sent by `from` in `tx`:
+/// set when `tx` is an execution of the Safe at `from`, or an EntryPoint
+/// bundle with a UserOperation of the smart account at `from`.
+fn token_executor(tx: &Transaction, from: Address) -> (Option<String>, Option<String>) {
+    let executor = Some(format!("{:?}", tx.from));
+    if tx.to == Some(from) && multisig::decode_exec_transaction(&tx.input).is_some() {
+        return (executor, Some(multisig::GNOSIS_SAFE.into()));
+    }
+    let bundled = tx.to.is_some_and(account_abstraction::is_entry_point)
+        && account_abstraction::decode_handle_ops(&tx.input)
+            .is_some_and(|ops| ops.iter().any(|op| op.sender == from));
+    if bundled {
+        (executor, None)
+    } else {
+        (None, None)
+    }
 }
 
 #[tokio::main]
@@ -204,6 +432,12 @@ async fn main() -> anyhow::Result<()> {
         let last_eth_block = Arc::clone(&last_eth_block);
         let redis_client = redis_client.clone();
         tokio::spawn(async move {
+            if cfg.indexing_profile == IndexingProfile::WatchOnly
+                && cfg.watched_addresses_eth.is_empty()
+            {
+                info!("No ETH addresses to watch.");
+                return;
+            }
             // Support both WebSocket (for production) and HTTP (for Anvil testing)
             let use_websocket = cfg.eth_rpc_url.starts_with("ws");
 
@@ -232,6 +466,7 @@ async fn main() -> anyhow::Result<()> {
 
                     let native_tracker = track_native_transfers(
                         Arc::clone(&provider),
+                        cfg.indexing_profile,
                         watched_addresses.clone(),
                         cfg.eth_network.clone(),
                         Arc::clone(&processed_txs),
@@ -275,6 +510,7 @@ async fn main() -> anyhow::Result<()> {
                 info!("Using HTTP polling mode for ETH at {}", cfg.eth_rpc_url);
                 poll_eth_blocks(
                     cfg.eth_rpc_url.clone(),
+                    cfg.indexing_profile,
                     cfg.watched_addresses_eth.clone(),
                     cfg.eth_network.clone(),
                     Arc::clone(&processed_txs),
@@ -288,28 +524,38 @@ async fn main() -> anyhow::Result<()> {
 
     let sol_tracker = {
         let cfg = cfg.clone();
+        let processed_txs = Arc::clone(&processed_txs);
+        let last_sol_slot = Arc::clone(&last_sol_slot);
         let redis_client = redis_client.clone();
         tokio::spawn(async move {
             track_solana_transfers(
                 &cfg.sol_rpc_url,
                 &cfg.sol_network,
                 &cfg.watched_addresses_sol,
-                Arc::clone(&processed_txs),
-                Arc::clone(&last_sol_slot),
+                processed_txs,
+                last_sol_slot,
                 redis_client,
             )
             .await
         })
     };
 
-    tokio::try_join!(eth_tracker, sol_tracker)?;
+    // Watch-only deployments pull what the targeted trackers missed on demand
+    let backfills = tokio::spawn(async move {
+        if cfg.indexing_profile == IndexingProfile::WatchOnly {
+            consume_backfills(&cfg, processed_txs, last_sol_slot, redis_client).await;
+        }
+    });
+
+    tokio::try_join!(eth_tracker, sol_tracker, backfills)?;
 
     Ok(())
 }
 
 /// Track ERC‑20 Transfer events via websocket logs and publish matching events.
 ///
-/// Filters to events where either the `from` or `to` matches the watched set.
+/// Subscribes only to transfers from or to the watched set, so the node does
+/// the filtering rather than streaming every transfer on the chain.
 async fn track_erc20_transfers(
     provider: Arc<Provider<Ws>>,
     watched_addresses: Vec<Address>,
@@ -318,9 +564,14 @@ async fn track_erc20_transfers(
     last_block: Arc<Mutex<Option<u64>>>,
     redis_client: redis::Client,
 ) -> anyhow::Result<()> {
-    let filter = Filter::new().event("Transfer(address,address,uint256)");
-    let mut stream = provider.subscribe_logs(&filter).await?;
-    info!("Subscribed to all ERC-20 Transfer logs");
+    let [sent, received] = watch_filters::transfer_filters(&watched_addresses);
+    let sent = provider.subscribe_logs(&sent).await?;
+    let received = provider.subscribe_logs(&received).await?;
+    let mut stream = sent.merge(received);
+    info!(
+        "Subscribed to ERC-20 Transfer logs of {} watched addresses",
+        watched_addresses.len()
+    );
 
     while let Some(log) = stream.next().await {
         if log.topics.len() == 3 {
@@ -337,19 +588,35 @@ async fn track_erc20_transfers(
                 }
 
                 let block_number = log.block_number;
-                let timestamp = match block_number {
+                let (timestamp, base_fee) = match block_number {
                     Some(bn) => match provider.get_block(bn).await {
-                        Ok(Some(block)) => block.timestamp.to_string(),
+                        Ok(Some(block)) => (block.timestamp.to_string(), block.base_fee_per_gas),
                         _ => {
                             warn!("Could not get block for log in tx {:?}", tx_hash);
-                            "".to_string()
+                            ("".to_string(), None)
                         }
                     },
-                    None => "".to_string(),
+                    None => ("".to_string(), None),
                 };
 
                 // Fetch token metadata
                 let (symbol, decimals) = fetch_token_metadata(&provider, log.address).await;
+                let tx = provider.get_transaction(tx_hash).await.ok().flatten();
+                let (executed_by, multisig) = match &tx {
+                    Some(tx) => token_executor(tx, from),
+                    None => (None, None),
+                };
+                let nonce = tx.as_ref().map(|tx| tx.nonce.low_u64());
+                // The receipt holds any bridge message and the fee paid
+                let receipt = provider
+                    .get_transaction_receipt(tx_hash)
+                    .await
+                    .ok()
+                    .flatten();
+                let bridge = receipt
+                    .as_ref()
+                    .and_then(|r| bridges::decode_evm_logs("ethereum", &network, &r.logs));
+                let fee = receipt.as_ref().and_then(|r| evm_fee(r, base_fee));
 
                 let event = Event {
                     event_id: event_id.clone(),
@@ -367,6 +634,13 @@ async fn track_erc20_transfers(
                         symbol,
                         decimals,
                     }),
+                    executed_by,
+                    multisig,
+                    authority: None,
+                    bridge,
+                    fee,
+                    nonce,
+                    log_index: log.log_index.map(|i| i.low_u64()),
                 };
 
                 // Only mark as processed if publish succeeds
@@ -395,15 +669,29 @@ async fn track_erc20_transfers(
 /// Track native ETH transfers by subscribing to new blocks and scanning txs.
 ///
 /// This is a pragmatic approach that works across providers with websocket
-/// support and provides consistent timestamps from the block header.
+/// support and provides consistent timestamps from the block header. The
+/// watch-only profile only fetches the blocks where a watched balance
+/// changed instead (see `track_watched_balances`).
 async fn track_native_transfers(
     provider: Arc<Provider<Ws>>,
+    profile: IndexingProfile,
     watched_addresses: Vec<Address>,
     network: String,
     processed_txs: Arc<Mutex<HashSet<String>>>,
     last_block: Arc<Mutex<Option<u64>>>,
     redis_client: redis::Client,
 ) -> anyhow::Result<()> {
+    if profile == IndexingProfile::WatchOnly {
+        return track_watched_balances(
+            provider,
+            watched_addresses,
+            network,
+            processed_txs,
+            last_block,
+            redis_client,
+        )
+        .await;
+    }
     let mut stream = provider.subscribe_blocks().await?;
     info!("Subscribed to new blocks for native transfers");
 
@@ -413,38 +701,62 @@ async fn track_native_transfers(
                 Ok(Some(block)) => {
                     let block_number = block.number.unwrap_or_default();
                     for tx in block.transactions {
-                        let from_watched =
-                            tx.from != Address::zero() && watched_addresses.contains(&tx.from);
-                        let to_watched =
-                            tx.to.is_some() && watched_addresses.contains(&tx.to.unwrap());
-
-                        if from_watched || to_watched {
-                            let event_id = format!("eth:{:?}", tx.hash);
-
-                            if processed_txs.lock().await.contains(&event_id) {
-                                info!("Duplicate event skipped: {}", event_id);
-                                continue;
-                            }
+                        for transfer in resolve_native_transfers(provider.as_ref(), &tx).await {
+                            let from_watched = transfer.from != Address::zero()
+                                && watched_addresses.contains(&transfer.from);
+                            let to_watched = transfer.to != Address::zero()
+                                && watched_addresses.contains(&transfer.to);
+                            // Executions by a watched Safe owner or bundler are reported too
+                            let executor_watched = transfer.executed_by.is_some()
+                                && watched_addresses.contains(&tx.from);
+
+                            if from_watched || to_watched || executor_watched {
+                                let event_id = transfer.event_id.clone();
+
+                                if processed_txs.lock().await.contains(&event_id) {
+                                    info!("Duplicate event skipped: {}", event_id);
+                                    continue;
+                                }
 
-                            let event = Event {
-                                event_id: event_id.clone(),
-                                chain: "ethereum".into(),
-                                network: network.clone(),
-                                tx_hash: format!("{:?}", tx.hash),
-                                timestamp: block.timestamp.to_string(),
-                                from: format!("{:?}", tx.from),
-                                to: format!("{:?}", tx.to.unwrap_or_default()),
-                                value: tx.value.to_string(),
-                                event_type: "transfer".into(),
-                                slot: None,
-                                token: None,
-                            };
-                            // Only mark as processed if publish succeeds
-                            if let Err(e) = publish_event_to_redis(&redis_client, &event).await {
-                                error!("Failed to publish event to Redis: {:?}", e);
-                                // Don't mark as processed so it can be retried later
-                            } else {
-                                processed_txs.lock().await.insert(event_id);
+                                let receipt = provider
+                                    .get_transaction_receipt(tx.hash)
+                                    .await
+                                    .ok()
+                                    .flatten();
+                                let bridge = receipt.as_ref().and_then(|r| {
+                                    bridges::decode_evm_logs("ethereum", &network, &r.logs)
+                                });
+                                let fee = receipt
+                                    .as_ref()
+                                    .and_then(|r| evm_fee(r, block.base_fee_per_gas));
+                                let event = Event {
+                                    event_id: event_id.clone(),
+                                    chain: "ethereum".into(),
+                                    network: network.clone(),
+                                    tx_hash: format!("{:?}", tx.hash),
+                                    timestamp: block.timestamp.to_string(),
+                                    from: format!("{:?}", transfer.from),
+                                    to: format!("{:?}", transfer.to),
+                                    value: transfer.value.to_string(),
+                                    event_type: "native_transfer".into(),
+                                    slot: None,
+                                    token: None,
+                                    executed_by: transfer.executed_by,
+                                    multisig: transfer.multisig,
+                                    authority: None,
+                                    bridge,
+                                    fee,
+                                    nonce: Some(tx.nonce.low_u64()),
+                                    log_index: None,
+                                };
+                                // Only mark as processed if publish succeeds
+                                if let Err(e) = publish_event_to_redis(&redis_client, &event).await
+                                {
+                                    error!("Failed to publish event to Redis: {:?}", e);
+                                    // Don't mark as processed so it can be retried later
+                                } else {
+                                    processed_txs.lock().await.insert(event_id);
+                                }
                             }
                         }
                     }
@@ -471,10 +783,111 @@ async fn track_native_transfers(
     Err(anyhow!("Native transfer block stream ended"))
 }
 
+/// Watch-only counterpart of `track_native_transfers`: follows block headers
+/// and fetches a block's transactions only when a watched balance changed
+/// since the last header. ERC-20 transfers come from the log subscription.
+async fn track_watched_balances(
+    provider: Arc<Provider<Ws>>,
+    watched_addresses: Vec<Address>,
+    network: String,
+    processed_txs: Arc<Mutex<HashSet<String>>>,
+    last_block: Arc<Mutex<Option<u64>>>,
+    redis_client: redis::Client,
+) -> anyhow::Result<()> {
+    let mut stream = provider.subscribe_blocks().await?;
+    info!(
+        "Subscribed to new blocks for balance changes of {} watched addresses",
+        watched_addresses.len()
+    );
+    let mut balances = watch_only::BalanceTracker::default();
+    let mut last_checked: Option<u64> = None;
+    let no_transfers = HashSet::new();
+
+    while let Some(header) = stream.next().await {
+        let Some(number) = header.number else {
+            continue;
+        };
+        let block_num = number.as_u64();
+        // A change may have happened in headers the subscription skipped
+        let from = match last_checked {
+            Some(last) if last < block_num => {
+                (last + 1).max(block_num.saturating_sub(watch_only::MAX_BACKFILL_BLOCKS - 1))
+            }
+            _ => block_num,
+        };
+        let blocks = watched_blocks(
+            provider.as_ref(),
+            &mut balances,
+            &watched_addresses,
+            from,
+            block_num,
+            Some(&no_transfers),
+        )
+        .await;
+        for block in blocks {
+            if let Err(e) = process_eth_block(
+                provider.as_ref(),
+                block,
+                &watched_addresses,
+                Some(&no_transfers),
+                &network,
+                &processed_txs,
+                &redis_client,
+            )
+            .await
+            {
+                warn!("Error processing block {}: {:?}", block, e);
+            }
+        }
+        last_checked = Some(block_num);
+        let mut last = last_block.lock().await;
+        if last.is_none() || block_num > last.unwrap() {
+            *last = Some(block_num);
+        }
+    }
+    warn!("Watched balance block stream ended.");
+    Err(anyhow!("Watched balance block stream ended"))
+}
+
+/// The blocks in `from..=to` the watch-only profile fetches: all of them
+/// when a watched balance at `to` differs from the last check, otherwise
+/// those in `transfer_blocks`, the blocks with a watched ERC-20 transfer
+/// (all of them when unknown). A failed balance lookup counts as a change,
+/// so blocks are fetched rather than missed.
+async fn watched_blocks<M: Middleware>(
+    provider: &M,
+    balances: &mut watch_only::BalanceTracker,
+    watched_addresses: &[Address],
+    from: u64,
+    to: u64,
+    transfer_blocks: Option<&HashSet<u64>>,
+) -> Vec<u64> {
+    let mut changed = false;
+    for address in watched_addresses {
+        let at = BlockId::Number(BlockNumber::Number(to.into()));
+        match provider.get_balance(*address, Some(at)).await {
+            Ok(balance) => changed |= balances.changed(*address, balance),
+            Err(e) => {
+                warn!(
+                    "Balance of {:?} at block {} unavailable: {:?}. Fetching the blocks.",
+                    address, to, e
+                );
+                changed = true;
+            }
+        }
+    }
+    (from..=to)
+        .filter(|block| changed || transfer_blocks.map_or(true, |blocks| blocks.contains(block)))
+        .collect()
+}
+
 /// HTTP polling mode for Ethereum (e.g., local Anvil). Processes new blocks
 /// since the last seen height and handles chain resets with a small lookback.
+/// The watch-only profile starts at the current head rather than genesis
+/// and only fetches the blocks `watched_blocks` selects.
 async fn poll_eth_blocks(
     rpc_url: String,
+    profile: IndexingProfile,
     watched_addresses_str: Vec<String>,
     network: String,
     processed_txs: Arc<Mutex<HashSet<String>>>,
@@ -496,6 +909,7 @@ async fn poll_eth_blocks(
             return;
         }
     };
+    let mut balances = watch_only::BalanceTracker::default();
 
     loop {
         match provider.get_block_number().await {
@@ -521,6 +935,7 @@ async fn poll_eth_blocks(
                                 prev
                             }
                         }
+                        None if profile == IndexingProfile::WatchOnly => current,
                         None => {
                             // Initial state: start from block 0 if chain has any blocks
                             if current > 0 {
@@ -537,11 +952,37 @@ async fn poll_eth_blocks(
                     let range_start = if current == start { start } else { start + 1 };
                     if range_start <= current {
                         info!("Polling blocks {} to {}", range_start, current);
-                        for block_num in range_start..=current {
+                        let transfer_txs = if watched_addresses.is_empty() {
+                            None
+                        } else {
+                            watched_transfer_txs(
+                                provider.as_ref(),
+                                &watched_addresses,
+                                range_start,
+                                current,
+                            )
+                            .await
+                        };
+                        let blocks: Vec<u64> = match profile {
+                            IndexingProfile::Full => (range_start..=current).collect(),
+                            IndexingProfile::WatchOnly => {
+                                watched_blocks(
+                                    provider.as_ref(),
+                                    &mut balances,
+                                    &watched_addresses,
+                                    range_start,
+                                    current,
+                                    transfer_txs.as_ref().map(|t| &t.blocks),
+                                )
+                                .await
+                            }
+                        };
+                        for block_num in blocks {
                             if let Err(e) = process_eth_block(
-                                &provider,
+                                provider.as_ref(),
                                 block_num,
                                 &watched_addresses,
+                                transfer_txs.as_ref().map(|t| &t.txs),
                                 &network,
                                 &processed_txs,
                                 &redis_client,
@@ -564,13 +1005,62 @@ async fn poll_eth_blocks(
     }
 }
 
+/// Transactions with an ERC-20 transfer from or to a watched address, and the
+/// blocks they are in.
+struct WatchedTransfers {
+    txs: HashSet<H256>,
+    blocks: HashSet<u64>,
+}
+
+/// The watched ERC-20 transfers in blocks `from..=to`, found with
+/// `eth_getLogs`. None when the node refuses the query (e.g. the range is
+/// too large), in which case every receipt is checked.
+async fn watched_transfer_txs<M: Middleware>(
+    provider: &M,
+    watched_addresses: &[Address],
+    from: u64,
+    to: u64,
+) -> Option<WatchedTransfers> {
+    let mut transfers = WatchedTransfers {
+        txs: HashSet::new(),
+        blocks: HashSet::new(),
+    };
+    for filter in watch_filters::transfer_filters(watched_addresses) {
+        match provider
+            .get_logs(&filter.from_block(from).to_block(to))
+            .await
+        {
+            Ok(logs) => {
+                for log in logs {
+                    transfers.txs.extend(log.transaction_hash);
+                    transfers
+                        .blocks
+                        .extend(log.block_number.map(|n| n.as_u64()));
+                }
+            }
+            Err(e) => {
+                warn!(
+                    "eth_getLogs for watched addresses failed: {:?}. Checking every receipt.",
+                    e
+                );
+                return None;
+            }
+        }
+    }
+    Some(transfers)
+}
+
 /// Process a single Ethereum block (native transfers and ERC‑20 logs).
 ///
-/// Publishes events to Redis and updates the in‑memory deduplication state.
-async fn process_eth_block(
-    provider: &Provider<Http>,
+/// `transfer_txs`, when known, are the transactions with a watched ERC-20
+/// transfer; receipts are then only fetched for those and for watched native
+/// transfers. Publishes events to Redis and updates the in‑memory
+/// deduplication state.
+async fn process_eth_block<M: Middleware>(
+    provider: &M,
     block_num: u64,
     watched_addresses: &[Address],
+    transfer_txs: Option<&HashSet<H256>>,
     network: &str,
     processed_txs: &Arc<Mutex<HashSet<String>>>,
     redis_client: &redis::Client,
@@ -589,15 +1079,39 @@ async fn process_eth_block(
         // Check native transfers
         // If watched_addresses is empty, track ALL transactions (useful for testing)
         let track_all = watched_addresses.is_empty();
-        let from_watched = track_all || watched_addresses.contains(&tx.from);
-        let to_watched = track_all
-            || tx
-                .to
-                .map(|to| watched_addresses.contains(&to))
-                .unwrap_or(false);
-
-        if from_watched || to_watched {
-            let event_id = format!("eth:{:?}", tx.hash);
+        let transfers: Vec<NativeTransfer> = resolve_native_transfers(provider, &tx)
+            .await
+            .into_iter()
+            .filter(|transfer| {
+                let from_watched = track_all || watched_addresses.contains(&transfer.from);
+                let to_watched = track_all
+                    || (transfer.to != Address::zero() && watched_addresses.contains(&transfer.to));
+                // Executions by a watched Safe owner or bundler are reported too
+                let executor_watched =
+                    transfer.executed_by.is_some() && watched_addresses.contains(&tx.from);
+                from_watched || to_watched || executor_watched
+            })
+            .collect();
+        // Nothing watched happened in this transaction, so its receipt is not needed
+        if transfers.is_empty() && transfer_txs.is_some_and(|txs| !txs.contains(&tx.hash)) {
+            continue;
+        }
+
+        // The receipt holds the ERC-20 Transfer logs and any bridge message
+        let receipt = provider
+            .get_transaction_receipt(tx.hash)
+            .await
+            .ok()
+            .flatten();
+        let bridge = receipt
+            .as_ref()
+            .and_then(|r| bridges::decode_evm_logs("ethereum", network, &r.logs));
+        let fee = receipt
+            .as_ref()
+            .and_then(|r| evm_fee(r, block.base_fee_per_gas));
+
+        for transfer in transfers {
+            let event_id = transfer.event_id.clone();
             // Check if already processed before creating the event
             let already_processed = {
                 let processed = processed_txs.lock().await;
@@ -611,12 +1125,19 @@ async fn process_eth_block(
                     network: network.to_string(),
                     tx_hash: format!("{:?}", tx.hash),
                     timestamp: block.timestamp.to_string(),
-                    from: format!("{:?}", tx.from),
-                    to: format!("{:?}", tx.to.unwrap_or_default()),
-                    value: tx.value.to_string(),
-                    event_type: "transfer".into(),
+                    from: format!("{:?}", transfer.from),
+                    to: format!("{:?}", transfer.to),
+                    value: transfer.value.to_string(),
+                    event_type: "native_transfer".into(),
                     slot: None,
                     token: None,
+                    executed_by: transfer.executed_by,
+                    multisig: transfer.multisig,
+                    authority: None,
+                    bridge: bridge.clone(),
+                    fee: fee.clone(),
+                    nonce: Some(tx.nonce.low_u64()),
+                    log_index: None,
                 };
                 // Only mark as processed if publish succeeds
                 if let Err(e) = publish_event_to_redis(redis_client, &event).await {
@@ -629,8 +1150,8 @@ async fn process_eth_block(
         }
 
         // Check for ERC20 Transfer logs in transaction receipt
-        // Always check receipts (either for specific addresses or all if list is empty)
-        if let Ok(Some(receipt)) = provider.get_transaction_receipt(tx.hash).await {
+        // Receipts of transactions without a watched transfer were skipped above
+        if let Some(receipt) = receipt {
             for log in receipt.logs {
                 if log.topics.len() == 3
                     && log.topics[0]
@@ -659,6 +1180,7 @@ async fn process_eth_block(
                             // Fetch token metadata
                             let (symbol, decimals) =
                                 fetch_token_metadata(provider, log.address).await;
+                            let (executed_by, multisig) = token_executor(&tx, from);
 
                             let event = Event {
                                 event_id: event_id.clone(),
@@ -676,6 +1198,13 @@ async fn process_eth_block(
                                     symbol,
                                     decimals,
                                 }),
+                                executed_by,
+                                multisig,
+                                authority: None,
+                                bridge: bridge.clone(),
+                                fee: fee.clone(),
+                                nonce: Some(tx.nonce.low_u64()),
+                                log_index: log.log_index.map(|i| i.low_u64()),
                             };
                             // Only mark as processed if publish succeeds
                             if let Err(e) = publish_event_to_redis(redis_client, &event).await {
@@ -807,26 +1336,136 @@ async fn process_solana_transaction(
         .unwrap()
         .to_rfc3339();
 
-    // Decode the transaction if possible. Different solana crate versions
-    // expose parsed or compiled forms; to be robust across versions we only
-    // check whether the watched address appears among the transaction's
-    // account keys. This is a simpler, reliable signal that the transaction
-    // touched the watched address (covers native and token transfers).
-    if let Some(decoded_tx) = tx_with_meta.transaction.transaction.decode() {
+    // Token and SOL transfers touching the watched address are published as
+    // their own events; the placeholder below covers any other transaction
+    let parsed = serde_json::to_value(&tx_with_meta.transaction)?;
+    let bridge = bridges::decode_solana_transaction(&parsed);
+    let fee = solana_parser::parse_transaction_fee(&parsed).map(Fee::from);
+    let watched = watched_address.to_string();
+    let transfers: Vec<_> = solana_parser::parse_spl_transfers(&parsed)
+        .into_iter()
+        .filter(|t| {
+            t.authority == watched
+                || t.destination_owner.as_deref() == Some(watched.as_str())
+                || t.source == watched
+                || t.destination == watched
+        })
+        .collect();
+    let sol_transfers: Vec<_> = solana_parser::parse_sol_transfers(&parsed)
+        .into_iter()
+        .filter(|t| t.source == watched || t.destination == watched)
+        .collect();
+    if !transfers.is_empty() || !sol_transfers.is_empty() {
+        let mut published = true;
+        for (i, transfer) in transfers.iter().enumerate() {
+            let event = Event {
+                event_id: format!("{}:{}", event_id, i),
+                chain: "solana".into(),
+                network: network.to_string(),
+                tx_hash: signature.clone(),
+                timestamp: timestamp.clone(),
+                from: transfer.authority.clone(),
+                to: transfer
+                    .destination_owner
+                    .clone()
+                    .unwrap_or_else(|| transfer.destination.clone()),
+                value: transfer.amount.clone(),
+                event_type: "spl_transfer".into(),
+                slot: Some(slot),
+                token: Some(Token {
+                    address: transfer.mint.clone().unwrap_or_default(),
+                    symbol: String::new(),
+                    decimals: transfer.decimals.unwrap_or(0),
+                }),
+                executed_by: None,
+                multisig: None,
+                // A vault PDA's transfer is the protocol's, not the user who
+                // triggered it
+                authority: transfer.pda_program().map(|program| Authority {
+                    address: transfer.authority.clone(),
+                    program: program.to_string(),
+                }),
+                bridge: bridge.clone(),
+                fee: fee.clone(),
+                nonce: None,
+                log_index: Some(transfer.instruction_index),
+            };
+            if let Err(e) = publish_event_to_redis(redis_client, &event).await {
+                error!("Failed to publish event to Redis: {:?}", e);
+                published = false;
+            }
+        }
+        for (i, transfer) in sol_transfers.iter().enumerate() {
+            let event = Event {
+                event_id: format!("{}:native{}", event_id, i),
+                chain: "solana".into(),
+                network: network.to_string(),
+                tx_hash: signature.clone(),
+                timestamp: timestamp.clone(),
+                from: transfer.source.clone(),
+                to: transfer.destination.clone(),
+                value: transfer.lamports.to_string(),
+                event_type: "native_transfer".into(),
+                slot: Some(slot),
+                token: None,
+                executed_by: None,
+                multisig: None,
+                authority: None,
+                bridge: bridge.clone(),
+                fee: fee.clone(),
+                nonce: None,
+                log_index: Some(transfer.instruction_index),
+            };
+            if let Err(e) = publish_event_to_redis(redis_client, &event).await {
+                error!("Failed to publish event to Redis: {:?}", e);
+                published = false;
+            }
+        }
+        // Retried as a whole if any transfer failed to publish; the API
+        // deduplicates on event_id
+        if published {
+            processed_txs.lock().await.insert(event_id.clone());
+        }
+    } else if let Some(decoded_tx) = tx_with_meta.transaction.transaction.decode() {
+        // Decode the transaction if possible. Different solana crate versions
+        // expose parsed or compiled forms; to be robust across versions we only
+        // check whether the watched address appears among the transaction's
+        // account keys. This is a simpler, reliable signal that the transaction
+        // touched the watched address (covers native and token transfers).
         let account_keys = decoded_tx.message.static_account_keys();
         if account_keys.iter().any(|k| k == watched_address) {
+            // A Squads transaction signed by someone else acts for the
+            // watched vault; the fee payer is the member executing it.
+            let fee_payer = account_keys.first();
+            let (from, executed_by, multisig) =
+                if multisig::invokes_squads(account_keys) && fee_payer != Some(watched_address) {
+                    (
+                        watched_address.to_string(),
+                        fee_payer.map(|k| k.to_string()),
+                        Some(multisig::SQUADS.to_string()),
+                    )
+                } else {
+                    (String::new(), None, None)
+                };
             let event = Event {
                 event_id: event_id.clone(),
                 chain: "solana".into(),
                 network: network.to_string(),
                 tx_hash: signature.clone(),
                 timestamp: timestamp.clone(),
-                from: "".into(),
+                from,
                 to: "".into(),
                 value: "".into(),
                 event_type: "solana_tx".into(),
                 slot: Some(slot),
                 token: None,
+                executed_by,
+                multisig,
+                authority: None,
+                bridge,
+                fee,
+                nonce: None,
+                log_index: None,
             };
             // Only mark as processed if publish succeeds
             if let Err(e) = publish_event_to_redis(redis_client, &event).await {
@@ -982,3 +1621,186 @@ async fn poll_solana_transfers(
         sleep(Duration::from_secs(60)).await;
     }
 }
+
+/// Pull the backfills the API requests (see `watch_only`) for the networks
+/// this listener indexes. Requests for other networks are dropped with a
+/// warning, so one listener per Redis instance should run this profile.
+async fn consume_backfills(
+    cfg: &config::Config,
+    processed_txs: Arc<Mutex<HashSet<String>>>,
+    last_sol_slot: Arc<Mutex<Option<u64>>>,
+    redis_client: redis::Client,
+) {
+    info!(
+        "Pulling backfill requests from {}",
+        watch_only::BACKFILL_QUEUE
+    );
+    let mut con = None;
+    loop {
+        if con.is_none() {
+            match redis_client.get_multiplexed_async_connection().await {
+                Ok(c) => con = Some(c),
+                Err(e) => {
+                    warn!("Redis unavailable for backfills: {:?}. Retrying in 5s.", e);
+                    sleep(Duration::from_secs(5)).await;
+                    continue;
+                }
+            }
+        }
+        let popped: redis::RedisResult<Option<(String, String)>> = match con.as_mut() {
+            Some(c) => {
+                redis::cmd("BRPOP")
+                    .arg(watch_only::BACKFILL_QUEUE)
+                    .arg(5)
+                    .query_async(c)
+                    .await
+            }
+            None => continue,
+        };
+        let payload = match popped {
+            Ok(Some((_, payload))) => payload,
+            Ok(None) => continue,
+            Err(e) => {
+                warn!("Reading backfill requests failed: {:?}. Retrying in 5s.", e);
+                con = None;
+                sleep(Duration::from_secs(5)).await;
+                continue;
+            }
+        };
+        let req: watch_only::BackfillRequest = match serde_json::from_str(&payload) {
+            Ok(req) => req,
+            Err(e) => {
+                warn!("Dropping malformed backfill request: {:?}", e);
+                continue;
+            }
+        };
+
+        let res = match req.chain.as_str() {
+            "ethereum" if req.network == cfg.eth_network => {
+                backfill_eth(cfg, &req, &processed_txs, &redis_client).await
+            }
+            "solana" if req.network == cfg.sol_network => {
+                backfill_solana(cfg, &req, &processed_txs, &last_sol_slot, &redis_client).await
+            }
+            _ => {
+                warn!(
+                    "Dropping backfill request {} for {}:{}, which this listener does not index",
+                    req.id, req.chain, req.network
+                );
+                continue;
+            }
+        };
+        match res {
+            Ok(()) => info!(
+                "Backfill {} ({}) of {} pulled",
+                req.id, req.reason, req.address
+            ),
+            Err(e) => warn!("Backfill {} of {} failed: {:?}", req.id, req.address, e),
+        }
+    }
+}
+
+/// Pull the blocks of an EVM backfill request, publishing the requested
+/// wallet's transfers in them.
+async fn backfill_eth(
+    cfg: &config::Config,
+    req: &watch_only::BackfillRequest,
+    processed_txs: &Arc<Mutex<HashSet<String>>>,
+    redis_client: &redis::Client,
+) -> anyhow::Result<()> {
+    let (from, to) = req.block_range().ok_or_else(|| {
+        anyhow!(
+            "no block range of at most {} blocks",
+            watch_only::MAX_BACKFILL_BLOCKS
+        )
+    })?;
+    let watched: Vec<Address> = vec![req.address.parse()?];
+    if cfg.eth_rpc_url.starts_with("ws") {
+        let provider = Provider::new(Ws::connect(cfg.eth_rpc_url.clone()).await?);
+        backfill_eth_blocks(
+            &provider,
+            &watched,
+            from,
+            to,
+            &cfg.eth_network,
+            processed_txs,
+            redis_client,
+        )
+        .await;
+    } else {
+        let provider = Provider::<Http>::try_from(cfg.eth_rpc_url.as_str())?;
+        backfill_eth_blocks(
+            &provider,
+            &watched,
+            from,
+            to,
+            &cfg.eth_network,
+            processed_txs,
+            redis_client,
+        )
+        .await;
+    }
+    Ok(())
+}
+
+async fn backfill_eth_blocks<M: Middleware>(
+    provider: &M,
+    watched_addresses: &[Address],
+    from: u64,
+    to: u64,
+    network: &str,
+    processed_txs: &Arc<Mutex<HashSet<String>>>,
+    redis_client: &redis::Client,
+) {
+    // Every block is fetched, as the missing transactions may be calls
+    // that moved no watched balance
+    let transfers = watched_transfer_txs(provider, watched_addresses, from, to).await;
+    for block_num in from..=to {
+        if let Err(e) = process_eth_block(
+            provider,
+            block_num,
+            watched_addresses,
+            transfers.as_ref().map(|t| &t.txs),
+            network,
+            processed_txs,
+            redis_client,
+        )
+        .await
+        {
+            warn!("Error processing block {}: {:?}", block_num, e);
+        }
+    }
+}
+
+/// Fetch the signatures of a Solana backfill request, publishing the
+/// requested wallet's transfers in them.
+async fn backfill_solana(
+    cfg: &config::Config,
+    req: &watch_only::BackfillRequest,
+    processed_txs: &Arc<Mutex<HashSet<String>>>,
+    last_slot: &Arc<Mutex<Option<u64>>>,
+    redis_client: &redis::Client,
+) -> anyhow::Result<()> {
+    let pubkey = Pubkey::from_str(&req.address)?;
+    let rpc_url = cfg
+        .sol_rpc_url
+        .replace("ws:", "http:")
+        .replace("wss:", "https:");
+    let rpc_client = RpcClient::new(rpc_url);
+    for signature in &req.signatures {
+        if let Err(e) = process_solana_transaction(
+            &rpc_client,
+            &cfg.sol_network,
+            signature.clone(),
+            &pubkey,
+            Arc::clone(processed_txs),
+            Arc::clone(last_slot),
+            redis_client,
+        )
+        .await
+        {
+            warn!("Failed to process solana tx {}: {:?}", signature, e);
+        }
+    }
+    Ok(())
+}
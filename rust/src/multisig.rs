@@ -0,0 +1,172 @@
+//! Multisig wallet awareness.
+//!
+//! Treasuries are usually Gnosis Safes on Ethereum and Squads vaults on
+//! Solana. Their transfers are sent by whichever member executes the
+//! proposal, so these helpers recognize such executions and let the listener
+//! attribute the transfer to the multisig, recording the member as
+//! `executed_by`.
+use ethers::abi::{self, ParamType};
+use ethers::types::{Address, Log, H256, U256};
+use ethers::utils::keccak256;
+use solana_sdk::pubkey::Pubkey;
+
+/// `multisig` value of transfers executed through a Gnosis Safe.
+pub const GNOSIS_SAFE: &str = "gnosis_safe";
+/// `multisig` value of transactions executed through the Squads program.
+pub const SQUADS: &str = "squads";
+
+/// Squads v3 and v4 program IDs.
+const SQUADS_PROGRAM_IDS: [&str; 2] = [
+    "SMPLecH534NA9acpos4G6x7uf3LWbCAwZQE9e8ZekMu",
+    "SQDS4ep65T869zMMBKyuUq6mG8Qk1XaQmD3SCDCi4TzU",
+];
+
+const EXEC_TRANSACTION: &str =
+    "execTransaction(address,uint256,bytes,uint8,uint256,uint256,uint256,address,address,bytes)";
+const EXECUTION_SUCCESS: &str = "ExecutionSuccess(bytes32,uint256)";
+
+/// The call a Safe makes on behalf of its owners.
+#[derive(Debug, PartialEq)]
+pub struct SafeCall {
+    pub to: Address,
+    pub value: U256,
+    pub data: Vec<u8>,
+    /// 0 for CALL, 1 for DELEGATECALL.
+    pub operation: u8,
+}
+
+impl SafeCall {
+    /// Whether the call only sends native currency.
+    pub fn is_native_transfer(&self) -> bool {
+        self.operation == 0 && self.data.is_empty() && !self.value.is_zero()
+    }
+}
+
+/// Decode the calldata of a Safe `execTransaction`, or None when `input` is
+/// something else.
+pub fn decode_exec_transaction(input: &[u8]) -> Option<SafeCall> {
+    if input.len() < 4 || input[..4] != keccak256(EXEC_TRANSACTION)[..4] {
+        return None;
+    }
+    let tokens = abi::decode(
+        &[
+            ParamType::Address,
+            ParamType::Uint(256),
+            ParamType::Bytes,
+            ParamType::Uint(8),
+            ParamType::Uint(256),
+            ParamType::Uint(256),
+            ParamType::Uint(256),
+            ParamType::Address,
+            ParamType::Address,
+            ParamType::Bytes,
+        ],
+        &input[4..],
+    )
+    .ok()?;
+    let mut tokens = tokens.into_iter();
+    Some(SafeCall {
+        to: tokens.next()?.into_address()?,
+        value: tokens.next()?.into_uint()?,
+        data: tokens.next()?.into_bytes()?,
+        operation: tokens.next()?.into_uint()?.low_u32() as u8,
+    })
+}
+
+/// Whether the Safe at `safe` emitted ExecutionSuccess among `logs`. A Safe
+/// whose inner call reverts still mines the outer transaction but emits
+/// ExecutionFailure instead.
+pub fn safe_execution_succeeded(safe: Address, logs: &[Log]) -> bool {
+    let topic = H256::from(keccak256(EXECUTION_SUCCESS));
+    logs.iter()
+        .any(|l| l.address == safe && l.topics.first() == Some(&topic))
+}
+
+/// Whether a Solana transaction with these account keys calls the Squads
+/// program.
+pub fn invokes_squads(account_keys: &[Pubkey]) -> bool {
+    account_keys
+        .iter()
+        .any(|k| SQUADS_PROGRAM_IDS.contains(&k.to_string().as_str()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use ethers::abi::Token;
+    use std::str::FromStr;
+
+    fn exec_calldata(to: Address, value: u64, data: Vec<u8>, operation: u8) -> Vec<u8> {
+        let mut input = keccak256(EXEC_TRANSACTION)[..4].to_vec();
+        input.extend(abi::encode(&[
+            Token::Address(to),
+            Token::Uint(U256::from(value)),
+            Token::Bytes(data),
+            Token::Uint(U256::from(operation)),
+            Token::Uint(U256::zero()),
+            Token::Uint(U256::zero()),
+            Token::Uint(U256::zero()),
+            Token::Address(Address::zero()),
+            Token::Address(Address::zero()),
+            Token::Bytes(vec![0u8; 65]),
+        ]));
+        input
+    }
+
+    #[test]
+    fn test_exec_transaction_selector() {
+        assert_eq!(keccak256(EXEC_TRANSACTION)[..4], [0x6a, 0x76, 0x12, 0x02]);
+    }
+
+    #[test]
+    fn test_decode_native_transfer() {
+        let to = Address::from_low_u64_be(0xbeef);
+        let call = decode_exec_transaction(&exec_calldata(to, 1_000, vec![], 0)).unwrap();
+        assert_eq!(call.to, to);
+        assert_eq!(call.value, U256::from(1_000u64));
+        assert!(call.is_native_transfer());
+    }
+
+    #[test]
+    fn test_decode_contract_call_is_not_native_transfer() {
+        let to = Address::from_low_u64_be(0xbeef);
+        let call = decode_exec_transaction(&exec_calldata(to, 0, vec![0xa9, 0x05, 0x9c, 0xbb], 0))
+            .unwrap();
+        assert!(!call.is_native_transfer());
+        let delegate = decode_exec_transaction(&exec_calldata(to, 5, vec![], 1)).unwrap();
+        assert!(!delegate.is_native_transfer());
+    }
+
+    #[test]
+    fn test_decode_other_calldata() {
+        assert!(decode_exec_transaction(&[]).is_none());
+        assert!(decode_exec_transaction(&[0xa9, 0x05, 0x9c, 0xbb, 0, 0]).is_none());
+        // Right selector, truncated arguments
+        assert!(decode_exec_transaction(&keccak256(EXEC_TRANSACTION)[..8]).is_none());
+    }
+
+    #[test]
+    fn test_safe_execution_succeeded() {
+        let safe = Address::from_low_u64_be(0x5afe);
+        let success = Log {
+            address: safe,
+            topics: vec![H256::from(keccak256(EXECUTION_SUCCESS))],
+            ..Default::default()
+        };
+        let failure = Log {
+            address: safe,
+            topics: vec![H256::from(keccak256("ExecutionFailure(bytes32,uint256)"))],
+            ..Default::default()
+        };
+        assert!(safe_execution_succeeded(safe, &[success.clone()]));
+        assert!(!safe_execution_succeeded(safe, &[failure]));
+        assert!(!safe_execution_succeeded(Address::zero(), &[success]));
+    }
+
+    #[test]
+    fn test_invokes_squads() {
+        let squads = Pubkey::from_str(SQUADS_PROGRAM_IDS[1]).unwrap();
+        assert!(invokes_squads(&[Pubkey::new_unique(), squads]));
+        assert!(!invokes_squads(&[Pubkey::new_unique()]));
+    }
+}
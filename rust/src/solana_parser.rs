@@ -94,6 +94,268 @@ pub fn parsed_tx_touches_watched(parsed: &Value, watched: &Pubkey) -> bool {
     false
 }
 
+/// SPL Token and Token-2022 program IDs.
+const SPL_TOKEN_PROGRAM_IDS: [&str; 2] = [
+    "TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5DA",
+    "TokenzQdBNbLqP5VEhdkAS6EPFLC1PHnBqCXEpPxuEb",
+];
+
+/// An SPL token transfer decoded from a `jsonParsed` transaction.
+#[derive(Debug, PartialEq)]
+pub struct SplTransfer {
+    /// Source and destination token accounts.
+    pub source: String,
+    pub destination: String,
+    /// Owner (or delegate) of the source account that signed the transfer.
+    pub authority: String,
+    /// Owner of the destination token account, from the token balances.
+    pub destination_owner: Option<String>,
+    pub mint: Option<String>,
+    pub amount: String,
+    pub decimals: Option<u8>,
+    /// Program whose instruction invoked the token program, for transfers
+    /// made through CPI; None for top-level instructions.
+    pub invoked_by: Option<String>,
+    /// Position of the instruction in the transaction, see
+    /// `parse_spl_transfers`.
+    pub instruction_index: u64,
+}
+
+impl SplTransfer {
+    /// The program controlling `authority` when it is a program-derived
+    /// address. A PDA has no private key, so only the program that invoked
+    /// the token program can have signed for it (with `invoke_signed`).
+    pub fn pda_program(&self) -> Option<&str> {
+        let authority = Pubkey::from_str(&self.authority).ok()?;
+        if authority.is_on_curve() {
+            return None;
+        }
+        self.invoked_by.as_deref()
+    }
+}
+
+/// Mint, owner and decimals of a token account from the transaction's token
+/// balances.
+struct TokenAccount {
+    mint: Option<String>,
+    owner: Option<String>,
+    decimals: Option<u8>,
+}
+
+/// Extract the SPL `transfer` and `transferChecked` instructions, top-level
+/// and inner, of a `getTransaction` result fetched with `jsonParsed`
+/// encoding (an object with `transaction` and `meta`). Instructions are
+/// numbered in execution order, each top-level one followed by its inner
+/// ones, so a transfer's `instruction_index` is the same whichever wallet
+/// it was fetched for.
+pub fn parse_spl_transfers(tx: &Value) -> Vec<SplTransfer> {
+    let message = &tx["transaction"]["message"];
+    let meta = &tx["meta"];
+    let account_keys: Vec<&str> = message["accountKeys"]
+        .as_array()
+        .map(|keys| {
+            keys.iter()
+                .map(|k| k.as_str().or_else(|| k["pubkey"].as_str()).unwrap_or(""))
+                .collect()
+        })
+        .unwrap_or_default();
+    let token_account = |address: &str| -> Option<TokenAccount> {
+        ["postTokenBalances", "preTokenBalances"]
+            .iter()
+            .filter_map(|field| meta[*field].as_array())
+            .flatten()
+            .find(|b| {
+                b["accountIndex"]
+                    .as_u64()
+                    .and_then(|i| account_keys.get(i as usize))
+                    == Some(&address)
+            })
+            .map(|b| TokenAccount {
+                mint: b["mint"].as_str().map(String::from),
+                owner: b["owner"].as_str().map(String::from),
+                decimals: b["uiTokenAmount"]["decimals"].as_u64().map(|d| d as u8),
+            })
+    };
+
+    let mut transfers = Vec::new();
+    let top_level = message["instructions"]
+        .as_array()
+        .cloned()
+        .unwrap_or_default();
+    let inner = meta["innerInstructions"]
+        .as_array()
+        .cloned()
+        .unwrap_or_default();
+    let mut position = 0u64;
+    for (index, ix) in top_level.iter().enumerate() {
+        let mut push = |ix: &Value, invoked_by: Option<String>, position: u64| {
+            if let Some(mut transfer) = decode_token_transfer(ix, invoked_by, position) {
+                if let Some(account) =
+                    token_account(&transfer.source).or_else(|| token_account(&transfer.destination))
+                {
+                    transfer.mint = transfer.mint.or(account.mint);
+                    transfer.decimals = transfer.decimals.or(account.decimals);
+                }
+                transfer.destination_owner =
+                    token_account(&transfer.destination).and_then(|a| a.owner);
+                transfers.push(transfer);
+            }
+        };
+        push(ix, None, position);
+        position += 1;
+
+        // Inner instructions are flattened in execution order; the caller of
+        // one at stack height h is the last instruction seen at height h - 1
+        let mut callers = vec![String::new(), program_id(ix).to_string()];
+        let inner_ixs = inner
+            .iter()
+            .filter(|group| group["index"].as_u64() == Some(index as u64))
+            .filter_map(|group| group["instructions"].as_array())
+            .flatten();
+        for ix in inner_ixs {
+            let height = ix["stackHeight"].as_u64().unwrap_or(2).max(2) as usize;
+            callers.truncate(height);
+            let caller = callers.get(height - 1).cloned();
+            push(ix, caller.filter(|c| !c.is_empty()), position);
+            position += 1;
+            callers.resize(height, String::new());
+            callers.push(program_id(ix).to_string());
+        }
+    }
+    transfers
+}
+
+fn program_id(ix: &Value) -> &str {
+    ix["programId"].as_str().unwrap_or("")
+}
+
+fn decode_token_transfer(
+    ix: &Value,
+    invoked_by: Option<String>,
+    instruction_index: u64,
+) -> Option<SplTransfer> {
+    if !SPL_TOKEN_PROGRAM_IDS.contains(&program_id(ix)) {
+        return None;
+    }
+    let parsed = &ix["parsed"];
+    let info = &parsed["info"];
+    let (amount, decimals, mint) = match parsed["type"].as_str()? {
+        "transfer" => (info["amount"].as_str()?.to_string(), None, None),
+        "transferChecked" => (
+            info["tokenAmount"]["amount"].as_str()?.to_string(),
+            info["tokenAmount"]["decimals"].as_u64().map(|d| d as u8),
+            info["mint"].as_str().map(String::from),
+        ),
+        _ => return None,
+    };
+    let authority = info["authority"]
+        .as_str()
+        .or_else(|| info["multisigAuthority"].as_str())?;
+    Some(SplTransfer {
+        source: info["source"].as_str()?.to_string(),
+        destination: info["destination"].as_str()?.to_string(),
+        authority: authority.to_string(),
+        destination_owner: None,
+        mint,
+        amount,
+        decimals,
+        invoked_by,
+        instruction_index,
+    })
+}
+
+/// System program ID.
+const SYSTEM_PROGRAM_ID: &str = "11111111111111111111111111111111";
+
+/// Fee charged per signature before any prioritization fee, in lamports.
+const LAMPORTS_PER_SIGNATURE: u64 = 5000;
+
+/// A native SOL transfer decoded from a `jsonParsed` transaction.
+#[derive(Debug, PartialEq)]
+pub struct SolTransfer {
+    pub source: String,
+    pub destination: String,
+    pub lamports: u64,
+    /// Position of the instruction in the transaction, numbered like
+    /// `SplTransfer::instruction_index`.
+    pub instruction_index: u64,
+}
+
+/// Extract the System program `transfer` and `transferWithSeed`
+/// instructions, top-level and inner, of a `getTransaction` result fetched
+/// with `jsonParsed` encoding.
+pub fn parse_sol_transfers(tx: &Value) -> Vec<SolTransfer> {
+    let top_level = tx["transaction"]["message"]["instructions"]
+        .as_array()
+        .cloned()
+        .unwrap_or_default();
+    let inner = tx["meta"]["innerInstructions"]
+        .as_array()
+        .cloned()
+        .unwrap_or_default();
+    let mut transfers = Vec::new();
+    let mut position = 0u64;
+    for (index, ix) in top_level.iter().enumerate() {
+        transfers.extend(decode_sol_transfer(ix, position));
+        position += 1;
+        let inner_ixs = inner
+            .iter()
+            .filter(|group| group["index"].as_u64() == Some(index as u64))
+            .filter_map(|group| group["instructions"].as_array())
+            .flatten();
+        for ix in inner_ixs {
+            transfers.extend(decode_sol_transfer(ix, position));
+            position += 1;
+        }
+    }
+    transfers
+}
+
+fn decode_sol_transfer(ix: &Value, instruction_index: u64) -> Option<SolTransfer> {
+    if program_id(ix) != SYSTEM_PROGRAM_ID {
+        return None;
+    }
+    let parsed = &ix["parsed"];
+    let info = &parsed["info"];
+    if !matches!(parsed["type"].as_str()?, "transfer" | "transferWithSeed") {
+        return None;
+    }
+    let lamports = info["lamports"].as_u64()?;
+    if lamports == 0 {
+        return None;
+    }
+    Some(SolTransfer {
+        source: info["source"].as_str()?.to_string(),
+        destination: info["destination"].as_str()?.to_string(),
+        lamports,
+        instruction_index,
+    })
+}
+
+/// What a Solana transaction cost its fee payer, in lamports, and the
+/// compute units it consumed.
+#[derive(Debug, PartialEq)]
+pub struct TransactionFee {
+    pub fee: u64,
+    /// The part of `fee` above the per-signature base fee.
+    pub priority_fee: u64,
+    pub compute_units: Option<u64>,
+}
+
+/// Read the fee of a `getTransaction` result from its `meta`.
+pub fn parse_transaction_fee(tx: &Value) -> Option<TransactionFee> {
+    let meta = &tx["meta"];
+    let fee = meta["fee"].as_u64()?;
+    let signatures = tx["transaction"]["signatures"]
+        .as_array()
+        .map_or(1, |s| s.len() as u64);
+    Some(TransactionFee {
+        fee,
+        priority_fee: fee.saturating_sub(signatures * LAMPORTS_PER_SIGNATURE),
+        compute_units: meta["computeUnitsConsumed"].as_u64(),
+    })
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -309,4 +571,178 @@ mod tests {
 
         assert!(parse_spl_transfer(&tx).is_none());
     }
+
+    /// A jsonParsed getTransaction result where `program` moves tokens out of
+    /// the vault owned by `pda` (through CPI) to `user`.
+    fn vault_withdrawal(pda: &Pubkey, program: &Pubkey, user: &Pubkey) -> Value {
+        let (vault, user_ata, mint) = (
+            Pubkey::new_unique(),
+            Pubkey::new_unique(),
+            Pubkey::new_unique(),
+        );
+        json!({
+            "transaction": {
+                "message": {
+                    "accountKeys": [
+                        {"pubkey": user.to_string(), "signer": true},
+                        {"pubkey": vault.to_string(), "signer": false},
+                        {"pubkey": user_ata.to_string(), "signer": false},
+                        {"pubkey": program.to_string(), "signer": false}
+                    ],
+                    "instructions": [
+                        {"programId": program.to_string(), "accounts": [], "data": "", "stackHeight": null},
+                        {
+                            "program": "spl-token",
+                            "programId": TOKEN_PROGRAM_ID,
+                            "parsed": {"type": "transfer", "info": {
+                                "source": user_ata.to_string(),
+                                "destination": vault.to_string(),
+                                "authority": user.to_string(),
+                                "amount": "5"
+                            }},
+                            "stackHeight": null
+                        }
+                    ]
+                }
+            },
+            "meta": {
+                "innerInstructions": [{
+                    "index": 0,
+                    "instructions": [{
+                        "program": "spl-token",
+                        "programId": TOKEN_PROGRAM_ID,
+                        "parsed": {"type": "transferChecked", "info": {
+                            "source": vault.to_string(),
+                            "destination": user_ata.to_string(),
+                            "authority": pda.to_string(),
+                            "mint": mint.to_string(),
+                            "tokenAmount": {"amount": "1000", "decimals": 6}
+                        }},
+                        "stackHeight": 2
+                    }]
+                }],
+                "postTokenBalances": [
+                    {"accountIndex": 1, "mint": mint.to_string(), "owner": pda.to_string(),
+                     "uiTokenAmount": {"decimals": 6}},
+                    {"accountIndex": 2, "mint": mint.to_string(), "owner": user.to_string(),
+                     "uiTokenAmount": {"decimals": 6}}
+                ]
+            }
+        })
+    }
+
+    #[test]
+    fn test_parse_spl_transfers_attributes_pda_to_program() {
+        let program = Pubkey::new_unique();
+        let (pda, _) = Pubkey::find_program_address(&[b"vault"], &program);
+        let user = Pubkey::new_unique();
+        let transfers = parse_spl_transfers(&vault_withdrawal(&pda, &program, &user));
+        assert_eq!(transfers.len(), 2);
+
+        // The user's own deposit: top-level and signed by a wallet key
+        let deposit = &transfers[0];
+        assert_eq!(deposit.authority, user.to_string());
+        assert_eq!(deposit.amount, "5");
+        assert_eq!(deposit.decimals, Some(6));
+        assert_eq!(deposit.destination_owner, Some(pda.to_string()));
+        assert_eq!(deposit.pda_program(), None);
+        assert_eq!(deposit.instruction_index, 2);
+
+        // The vault's withdrawal: signed by the PDA inside the program's CPI
+        let withdrawal = &transfers[1];
+        assert_eq!(withdrawal.authority, pda.to_string());
+        assert_eq!(withdrawal.amount, "1000");
+        assert_eq!(withdrawal.destination_owner, Some(user.to_string()));
+        assert_eq!(withdrawal.invoked_by, Some(program.to_string()));
+        assert_eq!(withdrawal.pda_program(), Some(program.to_string().as_str()));
+        assert_eq!(withdrawal.instruction_index, 1);
+    }
+
+    #[test]
+    fn test_parse_spl_transfers_nested_cpi() {
+        let outer = Pubkey::new_unique();
+        let inner_program = Pubkey::new_unique();
+        let (pda, _) = Pubkey::find_program_address(&[b"vault"], &inner_program);
+        let mut tx = vault_withdrawal(&pda, &outer, &Pubkey::new_unique());
+        // outer -> inner_program -> token program
+        let group = &mut tx["meta"]["innerInstructions"][0]["instructions"];
+        let transfer = group[0].take();
+        *group = json!([
+            {"programId": inner_program.to_string(), "accounts": [], "data": "", "stackHeight": 2},
+            transfer
+        ]);
+        group[1]["stackHeight"] = json!(3);
+
+        let transfers = parse_spl_transfers(&tx);
+        assert_eq!(
+            transfers[1].pda_program(),
+            Some(inner_program.to_string().as_str())
+        );
+    }
+
+    #[test]
+    fn test_parse_spl_transfers_ignores_other_instructions() {
+        let tx = json!({
+            "transaction": {"message": {"accountKeys": [], "instructions": [
+                {"program": "system", "programId": "11111111111111111111111111111111",
+                 "parsed": {"type": "transfer", "info": {"source": "a", "destination": "b", "lamports": 1}}},
+                {"program": "spl-token", "programId": TOKEN_PROGRAM_ID,
+                 "parsed": {"type": "approve", "info": {}}}
+            ]}},
+            "meta": {}
+        });
+        assert!(parse_spl_transfers(&tx).is_empty());
+    }
+
+    #[test]
+    fn test_parse_sol_transfers_top_level_and_inner() {
+        let tx = json!({
+            "transaction": {"message": {"accountKeys": [], "instructions": [
+                {"program": "system", "programId": SYSTEM_PROGRAM_ID,
+                 "parsed": {"type": "transfer", "info": {"source": "a", "destination": "b", "lamports": 7}}},
+                {"programId": "Prog1111111111111111111111111111111111111", "accounts": [], "data": ""}
+            ]}},
+            "meta": {"innerInstructions": [{"index": 1, "instructions": [
+                {"program": "system", "programId": SYSTEM_PROGRAM_ID,
+                 "parsed": {"type": "transferWithSeed", "info": {"source": "c", "destination": "d", "lamports": 9}}},
+                {"program": "system", "programId": SYSTEM_PROGRAM_ID,
+                 "parsed": {"type": "createAccount", "info": {"source": "c", "newAccount": "e", "lamports": 1}}}
+            ]}]}
+        });
+        let transfers = parse_sol_transfers(&tx);
+        assert_eq!(
+            transfers,
+            vec![
+                SolTransfer {
+                    source: "a".into(),
+                    destination: "b".into(),
+                    lamports: 7,
+                    instruction_index: 0
+                },
+                SolTransfer {
+                    source: "c".into(),
+                    destination: "d".into(),
+                    lamports: 9,
+                    instruction_index: 2
+                },
+            ]
+        );
+    }
+
+    #[test]
+    fn test_parse_transaction_fee() {
+        let tx = json!({
+            "transaction": {"signatures": ["s1", "s2"], "message": {}},
+            "meta": {"fee": 25000, "computeUnitsConsumed": 1200}
+        });
+        assert_eq!(
+            parse_transaction_fee(&tx),
+            Some(TransactionFee {
+                fee: 25000,
+                priority_fee: 15000,
+                compute_units: Some(1200)
+            })
+        );
+        assert_eq!(parse_transaction_fee(&json!({"meta": {}})), None);
+    }
 }
@@ -0,0 +1,72 @@
+//! Targeted log filters for watched EVM addresses.
+//!
+//! ERC-20 `Transfer` logs index the sender and recipient as topics 1 and 2,
+//! so the node can select the watched wallets' transfers itself instead of
+//! the listener receiving every transfer on the chain and discarding almost
+//! all of them. Topics are ANDed across positions and ORed within one, hence
+//! one filter for the transfers a watched address sent and one for those it
+//! received.
+use ethers::types::{Address, Filter, ValueOrArray, H256};
+
+/// Signature of the ERC-20 `Transfer` event.
+pub const TRANSFER_EVENT: &str = "Transfer(address,address,uint256)";
+
+/// The topic an indexed address is logged as: left-padded to 32 bytes.
+pub fn address_topic(address: Address) -> H256 {
+    H256::from(address)
+}
+
+/// Filters for the ERC-20 transfers sent by and received by `watched`. A
+/// transfer between two watched addresses matches both.
+pub fn transfer_filters(watched: &[Address]) -> [Filter; 2] {
+    let topics = ValueOrArray::Array(
+        watched
+            .iter()
+            .map(|address| Some(address_topic(*address)))
+            .collect::<Vec<_>>(),
+    );
+    [
+        Filter::new().event(TRANSFER_EVENT).topic1(topics.clone()),
+        Filter::new().event(TRANSFER_EVENT).topic2(topics),
+    ]
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use ethers::utils::keccak256;
+    use std::str::FromStr;
+
+    fn topic_values(topic: &Option<ValueOrArray<Option<H256>>>) -> Vec<H256> {
+        match topic {
+            Some(ValueOrArray::Value(Some(v))) => vec![*v],
+            Some(ValueOrArray::Array(vs)) => vs.iter().flatten().copied().collect(),
+            _ => Vec::new(),
+        }
+    }
+
+    #[test]
+    fn address_topic_left_pads() {
+        let address = Address::from_str("0x00000000000000000000000000000000000000ab").unwrap();
+        let topic = address_topic(address);
+        assert_eq!(topic.as_bytes()[..12], [0u8; 12]);
+        assert_eq!(Address::from(topic), address);
+    }
+
+    #[test]
+    fn transfer_filters_select_sender_and_recipient() {
+        let a = Address::from_str("0x0000000000000000000000000000000000000001").unwrap();
+        let b = Address::from_str("0x0000000000000000000000000000000000000002").unwrap();
+        let [sent, received] = transfer_filters(&[a, b]);
+        let transfer = H256::from(keccak256(TRANSFER_EVENT));
+        let watched = vec![address_topic(a), address_topic(b)];
+
+        assert_eq!(topic_values(&sent.topics[0]), vec![transfer]);
+        assert_eq!(topic_values(&sent.topics[1]), watched);
+        assert!(sent.topics[2].is_none());
+
+        assert_eq!(topic_values(&received.topics[0]), vec![transfer]);
+        assert!(received.topics[1].is_none());
+        assert_eq!(topic_values(&received.topics[2]), watched);
+    }
+}
@@ -0,0 +1,121 @@
+//! The `watch-only` indexing profile.
+//!
+//! Instead of scanning every block, the listener fetches a block only when it
+//! has a watched ERC-20 transfer (see `watch_filters`) or a watched address's
+//! balance changed in it, as a native transfer or the gas of a transaction
+//! it sent must change it. What that misses, the API's sequence checks find
+//! from nonces and signatures and request as backfills on a Redis list,
+//! which this profile pulls on demand.
+use ethers::types::{Address, U256};
+use serde::Deserialize;
+use std::collections::HashMap;
+
+/// Redis list the API pushes backfill requests to.
+pub const BACKFILL_QUEUE: &str = "cross_chain_backfill_requests";
+
+/// Longest block range a backfill pulls, as each block is fetched with its
+/// transactions.
+pub const MAX_BACKFILL_BLOCKS: u64 = 5000;
+
+/// Last observed balance of each watched address.
+#[derive(Debug, Default)]
+pub struct BalanceTracker {
+    balances: HashMap<Address, U256>,
+}
+
+impl BalanceTracker {
+    /// Records `balance` for `address` and reports whether it differs from
+    /// the previous observation. The first observation is only recorded.
+    pub fn changed(&mut self, address: Address, balance: U256) -> bool {
+        match self.balances.insert(address, balance) {
+            Some(previous) => previous != balance,
+            None => false,
+        }
+    }
+}
+
+/// A backfill request from the API, as it queues them: missing EVM nonces
+/// with the blocks between which they were mined, or missing Solana
+/// signatures.
+#[derive(Debug, Deserialize)]
+pub struct BackfillRequest {
+    pub id: String,
+    pub chain: String,
+    pub network: String,
+    pub address: String,
+    pub reason: String,
+    #[serde(default)]
+    pub from_block: u64,
+    #[serde(default)]
+    pub to_block: u64,
+    #[serde(default)]
+    pub signatures: Vec<String>,
+}
+
+impl BackfillRequest {
+    /// The blocks to pull for an EVM request, or None when the request has
+    /// no range or a longer one than `MAX_BACKFILL_BLOCKS`.
+    pub fn block_range(&self) -> Option<(u64, u64)> {
+        if self.to_block == 0 || self.to_block < self.from_block {
+            return None;
+        }
+        if self.to_block - self.from_block >= MAX_BACKFILL_BLOCKS {
+            return None;
+        }
+        Some((self.from_block, self.to_block))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn balance_tracker_reports_changes_after_the_first_observation() {
+        let mut tracker = BalanceTracker::default();
+        let a = Address::from_low_u64_be(1);
+        let b = Address::from_low_u64_be(2);
+        assert!(!tracker.changed(a, U256::from(10)));
+        assert!(!tracker.changed(a, U256::from(10)));
+        assert!(tracker.changed(a, U256::from(7)));
+        assert!(!tracker.changed(b, U256::from(7)));
+    }
+
+    #[test]
+    fn backfill_request_decodes_the_api_payload() {
+        let req: BackfillRequest = serde_json::from_str(
+            r#"{"id":"r1","chain":"ethereum","network":"mainnet","address":"0xabc","reason":"nonce_gap","nonces":[4,5],"from_block":100,"to_block":120,"requested_at":"2025-10-14T10:00:00Z"}"#,
+        )
+        .unwrap();
+        assert_eq!(req.block_range(), Some((100, 120)));
+        assert!(req.signatures.is_empty());
+
+        let req: BackfillRequest = serde_json::from_str(
+            r#"{"id":"r2","chain":"solana","network":"devnet","address":"Addr","reason":"missing_signatures","signatures":["s1","s2"],"requested_at":"2025-10-14T10:00:00Z"}"#,
+        )
+        .unwrap();
+        assert_eq!(req.block_range(), None);
+        assert_eq!(req.signatures, vec!["s1", "s2"]);
+    }
+
+    #[test]
+    fn block_range_refuses_unbounded_ranges() {
+        let req = |from_block, to_block| BackfillRequest {
+            id: "r".into(),
+            chain: "ethereum".into(),
+            network: "mainnet".into(),
+            address: "0xabc".into(),
+            reason: "nonce_gap".into(),
+            from_block,
+            to_block,
+            signatures: Vec::new(),
+        };
+        assert_eq!(req(7, 7).block_range(), Some((7, 7)));
+        assert_eq!(
+            req(0, MAX_BACKFILL_BLOCKS - 1).block_range(),
+            Some((0, MAX_BACKFILL_BLOCKS - 1))
+        );
+        assert_eq!(req(0, MAX_BACKFILL_BLOCKS).block_range(), None);
+        assert_eq!(req(9, 3).block_range(), None);
+    }
+}